@@ -8,6 +8,7 @@ require (
 	cloud.google.com/go/storage v1.56.1
 	cloud.google.com/go/texttospeech v1.13.0
 	cloud.google.com/go/vertexai v0.15.0
+	github.com/googleapis/gax-go/v2 v2.15.0
 	github.com/schollz/progressbar/v3 v3.18.0
 	google.golang.org/api v0.248.0
 	google.golang.org/genai v1.19.0
@@ -38,7 +39,6 @@ require (
 	github.com/google/s2a-go v0.1.9 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.6 // indirect
-	github.com/googleapis/gax-go/v2 v2.15.0 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
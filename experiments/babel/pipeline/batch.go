@@ -0,0 +1,197 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// errObjectNotFound is returned by ObjectStore.Get for a missing object
+var errObjectNotFound = errors.New("object not found")
+
+// errBatchNotFound is returned for a retry of a batch without a manifest
+var errBatchNotFound = errors.New("batch not found")
+
+// BatchManifest records a /babel batch so that its failed voices can be retried
+// without translating again; it is stored next to the clips, and a retry stores
+// a new manifest with the retried entries merged in
+type BatchManifest struct {
+	// BatchID identifies the batch; it starts the file names of its clips
+	BatchID string `json:"batch_id"`
+	// RetryOf is the batch this one retried, if any
+	RetryOf string `json:"retry_of,omitempty"`
+	// Request is the original request, whose style and loudness settings retries reuse
+	Request BabelRequest `json:"request"`
+	// Translations are the successful translations, by language
+	Translations map[string]string `json:"translations"`
+	// TranslationErrors are the languages whose translation failed
+	TranslationErrors map[string]string `json:"translation_errors,omitempty"`
+	// Romanizations are the romanized translations, when romanization was requested
+	Romanizations map[string]string `json:"romanizations,omitempty"`
+	Entries       []ManifestEntry   `json:"entries"`
+}
+
+// ManifestEntry is one voice of a batch and its output
+type ManifestEntry struct {
+	Backend      string      `json:"backend"`
+	VoiceName    string      `json:"voice_name"`
+	LanguageCode string      `json:"language_code"`
+	Gender       string      `json:"gender"`
+	Output       BabelOutput `json:"output"`
+	// Error is the failure of the voice, empty when its clip was stored
+	Error string `json:"error,omitempty"`
+}
+
+func (e ManifestEntry) spec() VoiceSpec {
+	return VoiceSpec{Backend: e.Backend, Name: e.VoiceName, LanguageCode: e.LanguageCode, Gender: e.Gender}
+}
+
+func (e ManifestEntry) matches(o BabelOutput) bool {
+	return e.VoiceName == o.VoiceName && e.LanguageCode == o.LanguageCode
+}
+
+// batchID returns the batch ID that starts the outputs' file names
+func batchID(outputs []BabelOutput) string {
+	if len(outputs) == 0 {
+		return ""
+	}
+	id, _, _ := strings.Cut(outputs[0].AudioPath, "-")
+	return id
+}
+
+// manifestObjectName is where the manifest of a batch is stored
+func manifestObjectName(storagePath, batchID string) string {
+	return fmt.Sprintf("%s/batches/%s.json", storagePath, batchID)
+}
+
+// newBatchManifest describes a batch from its outputs; the translation of each
+// language is the text its voices were given
+func newBatchManifest(req BabelRequest, outputs []BabelOutput, translationErrors map[string]error) *BatchManifest {
+	manifest := &BatchManifest{
+		BatchID:      batchID(outputs),
+		Request:      req,
+		Translations: map[string]string{},
+	}
+	for language, err := range translationErrors {
+		if manifest.TranslationErrors == nil {
+			manifest.TranslationErrors = map[string]string{}
+		}
+		manifest.TranslationErrors[language] = err.Error()
+	}
+	for _, o := range outputs {
+		if _, failed := translationErrors[o.LanguageCode]; !failed {
+			manifest.Translations[o.LanguageCode] = o.Text
+		}
+		if o.Romanized != "" {
+			if manifest.Romanizations == nil {
+				manifest.Romanizations = map[string]string{}
+			}
+			manifest.Romanizations[o.LanguageCode] = o.Romanized
+		}
+	}
+	manifest.merge(outputs)
+	return manifest
+}
+
+// merge replaces the entries of the outputs' voices with the outputs, adding
+// entries for new voices
+func (m *BatchManifest) merge(outputs []BabelOutput) {
+	for _, o := range outputs {
+		entry := ManifestEntry{Backend: o.Backend, VoiceName: o.VoiceName, LanguageCode: o.LanguageCode, Gender: o.Gender, Output: o, Error: o.Error}
+		if i := slices.IndexFunc(m.Entries, func(e ManifestEntry) bool { return e.matches(o) }); i >= 0 {
+			m.Entries[i] = entry
+		} else {
+			m.Entries = append(m.Entries, entry)
+		}
+	}
+}
+
+// retrySpecs selects the voices to retry: the named voices, or every failed
+// voice when no voices are named, leaving out those whose translation failed
+func (m *BatchManifest) retrySpecs(selections []VoiceSelection) ([]VoiceSpec, error) {
+	var specs []VoiceSpec
+	if len(selections) == 0 {
+		for _, e := range m.Entries {
+			if e.Error != "" && !e.Output.TranslationFailed {
+				specs = append(specs, e.spec())
+			}
+		}
+		if len(specs) == 0 {
+			return nil, fmt.Errorf("batch %s has no failed voices to retry", m.BatchID)
+		}
+	}
+	for _, selection := range selections {
+		found := false
+		for _, e := range m.Entries {
+			if e.VoiceName == selection.Name && (len(selection.LanguageCodes) == 0 || slices.Contains(selection.LanguageCodes, e.LanguageCode)) {
+				specs = append(specs, e.spec())
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("voice %s is not in batch %s", selection.Name, m.BatchID)
+		}
+	}
+	for _, spec := range specs {
+		if _, ok := m.Translations[spec.LanguageCode]; !ok {
+			return nil, fmt.Errorf("cannot retry %s: the %s translation failed in batch %s, send a new request instead", spec.Name, spec.LanguageCode, m.BatchID)
+		}
+	}
+	return specs, nil
+}
+
+// loadManifest reads the manifest of a batch from the store
+func (p *Pipeline) loadManifest(ctx context.Context, id string) (*BatchManifest, error) {
+	if strings.ContainsAny(id, "/\\") {
+		// not a batch ID, and not to be used in an object name
+		return nil, fmt.Errorf("%w: %q", errBatchNotFound, id)
+	}
+	r, err := p.Store.Get(ctx, manifestObjectName(p.StoragePath, id))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", errBatchNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var manifest BatchManifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("manifest of batch %s: %v", id, err)
+	}
+	return &manifest, nil
+}
+
+// storeManifest writes the manifest of a batch to the store
+func (p *Pipeline) storeManifest(ctx context.Context, manifest *BatchManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.Store.Put(ctx, manifestObjectName(p.StoragePath, manifest.BatchID), bytes.NewReader(data))
+}
+
+// retryVoices voices the given voices of a previous batch again, with the batch's
+// translations and settings, so Gemini is not called
+func (p *Pipeline) retryVoices(ctx context.Context, manifest *BatchManifest, specs []VoiceSpec) []BabelOutput {
+	outputs := p.speak(ctx, manifest.Request, specs, manifest.Translations)
+	applyRomanizations(outputs, manifest.Romanizations)
+	return outputs
+}
@@ -0,0 +1,196 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTTS records the voices and texts it synthesizes
+type countingTTS struct {
+	mu    sync.Mutex
+	calls map[string]string
+}
+
+func (c *countingTTS) Synthesize(ctx context.Context, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[voice.Name] = text
+	return fakeTTS{}.Synthesize(ctx, voice, text, opts)
+}
+
+func (c *countingTTS) Model() string { return "" }
+
+// noTranslator fails the test if the pipeline asks Gemini for anything
+type noTranslator struct{ t *testing.T }
+
+func (n noTranslator) Generate(ctx context.Context, prompt string) (string, error) {
+	n.t.Errorf("unexpected Gemini call: %q", prompt)
+	return "", nil
+}
+
+const previousBatch = "20250101.120000.25"
+
+// storePreviousBatch stores a manifest of a batch where the French voice failed
+// and the Japanese translation failed
+func storePreviousBatch(t *testing.T, p *Pipeline) {
+	t.Helper()
+	manifest := newBatchManifest(BabelRequest{Statement: "hello there", Romanize: true},
+		[]BabelOutput{
+			{VoiceName: "de-DE-Chirp3-HD-Fenrir", LanguageCode: "de-DE", Gender: "MALE", Backend: BackendChirp, Text: "[de-DE] hello there", AudioPath: previousBatch + "-de.wav", Length: 162},
+			{VoiceName: "fr-FR-Chirp3-HD-Aoede", LanguageCode: "fr-FR", Gender: "FEMALE", Backend: BackendChirp, Text: "[fr-FR] hello there", AudioPath: previousBatch + "-fr.wav", Error: "voice unavailable"},
+			{VoiceName: "ja-JP-Chirp3-HD-Kore", LanguageCode: "ja-JP", Gender: "FEMALE", Backend: BackendChirp, Text: "couldn't translate", AudioPath: previousBatch + "-ja.wav", Length: 170},
+		},
+		map[string]error{"ja-JP": errObjectNotFound},
+	)
+	if err := p.storeManifest(context.Background(), manifest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func retryPipeline(t *testing.T) (*Pipeline, *countingTTS, *memoryStore) {
+	tts := &countingTTS{calls: map[string]string{}}
+	store := &memoryStore{objects: map[string][]byte{}}
+	p := &Pipeline{
+		Translator:   noTranslator{t},
+		Synthesizers: map[string]Synthesizer{BackendChirp: tts},
+		Store:        store,
+		StoragePath:  "babel",
+		WorkDir:      t.TempDir(),
+		Voices:       testVoices(),
+	}
+	storePreviousBatch(t, p)
+	return p, tts, store
+}
+
+func postBabel(p *Pipeline, request string) (*httptest.ResponseRecorder, BabelResponse) {
+	rec := httptest.NewRecorder()
+	p.handleSynthesis(rec, httptest.NewRequest(http.MethodPost, "/babel", strings.NewReader(request)))
+	var response BabelResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec, response
+}
+
+func TestNewBatchID(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 30, 45, 0, time.UTC)
+	first, second := newBatchID(now), newBatchID(now)
+	if !strings.HasPrefix(first, now.Format(TimeFormat)+"_") || strings.Contains(first, "-") {
+		t.Errorf("unexpected batch ID %q", first)
+	}
+	if first == second {
+		t.Errorf("expected batches started at the same time to get different IDs, both got %q", first)
+	}
+	if got := batchID([]BabelOutput{{AudioPath: speechFileName(first, VoiceSpec{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCode: "fr-FR", Gender: "FEMALE"})}}); got != first {
+		t.Errorf("expected the batch ID back from a clip name, got %q", got)
+	}
+}
+
+func TestRetryFailedVoices(t *testing.T) {
+	p, tts, store := retryPipeline(t)
+
+	rec, response := postBabel(p, `{"retry_of": "`+previousBatch+`", "statement": "ignored"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	// only the failed French voice is synthesized, with the stored translation
+	if len(tts.calls) != 1 || tts.calls["fr-FR-Chirp3-HD-Aoede"] != "[fr-FR] hello there" {
+		t.Errorf("expected only the failed voice to be synthesized, got %v", tts.calls)
+	}
+	if len(response.AudioMetadata) != 1 || response.AudioMetadata[0].VoiceName != "fr-FR-Chirp3-HD-Aoede" {
+		t.Errorf("expected only the retried entry, got %+v", response.AudioMetadata)
+	}
+	if response.BatchID == "" || response.BatchID == previousBatch || !strings.HasPrefix(response.AudioMetadata[0].AudioPath, response.BatchID+"-") {
+		t.Errorf("expected a new batch, got %q for %s", response.BatchID, response.AudioMetadata[0].AudioPath)
+	}
+
+	// the new manifest merges the retried voice into the previous batch
+	merged, err := p.loadManifest(context.Background(), response.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.RetryOf != previousBatch || len(merged.Entries) != 3 || merged.Request.Statement != "hello there" {
+		t.Errorf("unexpected manifest %+v", merged)
+	}
+	paths := map[string]string{}
+	for _, e := range merged.Entries {
+		if e.Error != "" {
+			t.Errorf("%s still failed: %s", e.VoiceName, e.Error)
+		}
+		paths[e.LanguageCode] = e.Output.AudioPath
+	}
+	if paths["de-DE"] != previousBatch+"-de.wav" || paths["fr-FR"] != response.AudioMetadata[0].AudioPath {
+		t.Errorf("unexpected audio paths %v", paths)
+	}
+	if _, ok := store.objects["babel/"+response.AudioMetadata[0].AudioPath]; !ok {
+		t.Error("expected the retried clip to be stored")
+	}
+
+	// the merged batch has nothing left to retry
+	if rec, _ := postBabel(p, `{"retry_of": "`+response.BatchID+`"}`); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "no failed voices") {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRetryNamedVoices(t *testing.T) {
+	p, tts, _ := retryPipeline(t)
+
+	rec, response := postBabel(p, `{"retry_of": "`+previousBatch+`", "voices": [{"name": "de-DE-Chirp3-HD-Fenrir"}, {"name": "fr-FR-Chirp3-HD-Aoede"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	var synthesized []string
+	for name := range tts.calls {
+		synthesized = append(synthesized, name)
+	}
+	sort.Strings(synthesized)
+	if strings.Join(synthesized, ",") != "de-DE-Chirp3-HD-Fenrir,fr-FR-Chirp3-HD-Aoede" || len(response.AudioMetadata) != 2 {
+		t.Errorf("expected the two named voices, got %v and %d entries", synthesized, len(response.AudioMetadata))
+	}
+}
+
+func TestRetryErrors(t *testing.T) {
+	p, tts, _ := retryPipeline(t)
+
+	tests := []struct {
+		name     string
+		request  string
+		status   int
+		wantBody string
+	}{
+		{name: "unknown batch", request: `{"retry_of": "20240101.010101.24"}`, status: http.StatusNotFound, wantBody: "batch not found: 20240101.010101.24"},
+		{name: "path in batch", request: `{"retry_of": "../secrets"}`, status: http.StatusNotFound, wantBody: "batch not found"},
+		{name: "voice not in batch", request: `{"retry_of": "` + previousBatch + `", "voices": [{"name": "en-US-Chirp3-HD-Puck"}]}`, status: http.StatusBadRequest, wantBody: "voice en-US-Chirp3-HD-Puck is not in batch"},
+		{name: "failed translation", request: `{"retry_of": "` + previousBatch + `", "voices": [{"name": "ja-JP-Chirp3-HD-Kore"}]}`, status: http.StatusBadRequest, wantBody: "the ja-JP translation failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := postBabel(p, tt.request)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d containing %q", rec.Code, rec.Body, tt.status, tt.wantBody)
+			}
+		})
+	}
+	if len(tts.calls) != 0 {
+		t.Errorf("expected no synthesis for rejected retries, got %v", tts.calls)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BucketRoute sends the audio files of the languages whose code starts with
+// Prefix to Bucket, under Path
+type BucketRoute struct {
+	Prefix string
+	Bucket string
+	Path   string
+}
+
+// BucketMap routes audio files to buckets by language code, for --bucket-map;
+// its routes are sorted longest prefix first, so the most specific one matches
+type BucketMap []BucketRoute
+
+// LoadBucketMap reads a {languageCodePrefix: "bucket/path"} JSON map, e.g.
+// {"es-*": "audio-us/babel/es", "ja-*": "gs://audio-asia/babel"}
+func LoadBucketMap(filename string) (BucketMap, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseBucketMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return m, nil
+}
+
+// parseBucketMap parses the JSON of a bucket map; a prefix may end in '*', and
+// is matched without regard to case
+func parseBucketMap(data []byte) (BucketMap, error) {
+	var entries map[string]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of language code prefixes to bucket paths: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no routes")
+	}
+	var m BucketMap
+	seen := make(map[string]string)
+	for key, location := range entries {
+		prefix := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(key), "*"))
+		if prefix == "" {
+			return nil, fmt.Errorf("%q: the language code prefix is empty, use BABEL_BUCKET for the default location", key)
+		}
+		if other, ok := seen[prefix]; ok {
+			return nil, fmt.Errorf("%q and %q are the same prefix", other, key)
+		}
+		seen[prefix] = key
+		bucket, path, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(location), "gs://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("%q: %q has no bucket", key, location)
+		}
+		m = append(m, BucketRoute{Prefix: prefix, Bucket: bucket, Path: strings.Trim(path, "/")})
+	}
+	sort.Slice(m, func(i, j int) bool {
+		if len(m[i].Prefix) != len(m[j].Prefix) {
+			return len(m[i].Prefix) > len(m[j].Prefix)
+		}
+		return m[i].Prefix < m[j].Prefix
+	})
+	return m, nil
+}
+
+// route returns the route of a language code, the one with the longest matching
+// prefix; false means the default bucket and path
+func (m BucketMap) route(languageCode string) (BucketRoute, bool) {
+	code := strings.ToLower(languageCode)
+	for _, r := range m {
+		if strings.HasPrefix(code, r.Prefix) {
+			return r, true
+		}
+	}
+	return BucketRoute{}, false
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBucketMapRoute(t *testing.T) {
+	m, err := parseBucketMap([]byte(`{
+		"es-*": "audio-us/babel/es",
+		"es-ES": "gs://audio-eu/babel/es/",
+		"e": "audio-misc",
+		"ja-*": "gs://audio-asia/babel"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		languageCode string
+		want         BucketRoute
+		routed       bool
+	}{
+		// the longest matching prefix wins over the shorter ones it overlaps
+		{"es-ES", BucketRoute{Prefix: "es-es", Bucket: "audio-eu", Path: "babel/es"}, true},
+		{"es-US", BucketRoute{Prefix: "es-", Bucket: "audio-us", Path: "babel/es"}, true},
+		{"ES-mx", BucketRoute{Prefix: "es-", Bucket: "audio-us", Path: "babel/es"}, true},
+		{"en-US", BucketRoute{Prefix: "e", Bucket: "audio-misc", Path: ""}, true},
+		{"ja-JP", BucketRoute{Prefix: "ja-", Bucket: "audio-asia", Path: "babel"}, true},
+		{"de-DE", BucketRoute{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := m.route(tt.languageCode)
+		if ok != tt.routed || got != tt.want {
+			t.Errorf("route(%s) = %+v, %v; want %+v, %v", tt.languageCode, got, ok, tt.want, tt.routed)
+		}
+	}
+}
+
+func TestParseBucketMapErrors(t *testing.T) {
+	tests := map[string]string{
+		`["es-"]`:                       "expected a JSON object",
+		`{}`:                            "no routes",
+		`{"*": "audio"}`:                "prefix is empty",
+		`{"es-*": "audio", "ES-": "x"}`: "the same prefix",
+		`{"es-": "gs:///babel"}`:        "has no bucket",
+	}
+	for data, want := range tests {
+		if _, err := parseBucketMap([]byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseBucketMap(%s) = %v, want an error containing %q", data, err, want)
+		}
+	}
+}
+
+func TestRoutedUploads(t *testing.T) {
+	p, _, store := retryPipeline(t)
+	euStore := &memoryStore{objects: map[string][]byte{}}
+	p.Routes, _ = parseBucketMap([]byte(`{"fr-*": "audio-eu/babel/fr"}`))
+	p.OpenStore = func(bucket string) ObjectStore {
+		if bucket != "audio-eu" {
+			t.Errorf("unexpected bucket %s", bucket)
+		}
+		return euStore
+	}
+
+	rec, response := postBabel(p, `{"retry_of": "`+previousBatch+`", "voices": [{"name": "de-DE-Chirp3-HD-Fenrir"}, {"name": "fr-FR-Chirp3-HD-Aoede"}]}`)
+	if rec.Code != http.StatusOK || len(response.AudioMetadata) != 2 {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	for _, o := range response.AudioMetadata {
+		switch o.LanguageCode {
+		case "fr-FR":
+			if _, ok := euStore.objects["babel/fr/"+o.AudioPath]; !ok {
+				t.Errorf("expected the French clip in the routed bucket, got %v", euStore.objects)
+			}
+			if o.StorageURI != "gs://audio-eu/babel/fr/"+o.AudioPath {
+				t.Errorf("unexpected storage_uri %q", o.StorageURI)
+			}
+		case "de-DE":
+			if _, ok := store.objects["babel/"+o.AudioPath]; !ok {
+				t.Errorf("expected the German clip in the default bucket")
+			}
+			if o.StorageURI != "" {
+				t.Errorf("expected no storage_uri for the default location, got %q", o.StorageURI)
+			}
+		}
+	}
+	// the batch manifest stays in the default location
+	if _, err := p.loadManifest(context.Background(), response.BatchID); err != nil {
+		t.Errorf("expected the manifest in the default bucket: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the ID of a service request, echoed from the client or
+// generated, so a browser client can quote it when reporting a problem
+const requestIDHeader = "X-Request-Id"
+
+// requestIDPattern limits client-supplied request IDs to what is safe to echo
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// CORS settings of the service's responses
+const (
+	corsAllowedMethods = "GET, POST, OPTIONS"
+	corsAllowedHeaders = "Content-Type, " + requestIDHeader
+	// corsMaxAge is how long browsers may cache a preflight response
+	corsMaxAge = 10 * time.Minute
+)
+
+// CORSPolicy lists the origins browsers may call the service from; a policy with
+// no origins sends no CORS headers, so browsers on other origins are refused
+type CORSPolicy struct {
+	origins map[string]bool
+	// anyOrigin allows every origin, only when "*" is listed explicitly
+	anyOrigin bool
+}
+
+// ParseAllowedOrigins reads the comma-separated BABEL_ALLOWED_ORIGINS, e.g.
+// "https://app.example.com,http://localhost:8501"; a trailing slash is ignored
+func ParseAllowedOrigins(value string) CORSPolicy {
+	policy := CORSPolicy{origins: map[string]bool{}}
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch origin {
+		case "":
+		case "*":
+			policy.anyOrigin = true
+		default:
+			policy.origins[strings.ToLower(origin)] = true
+		}
+	}
+	return policy
+}
+
+// Enabled reports whether any origin is allowed
+func (c CORSPolicy) Enabled() bool {
+	return c.anyOrigin || len(c.origins) > 0
+}
+
+// allows reports whether browsers on the origin may call the service
+func (c CORSPolicy) allows(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	return c.anyOrigin || c.origins[strings.ToLower(origin)]
+}
+
+// handler wraps the service's routes with CORS: responses to an allowed origin echo
+// it and expose the request ID, and preflight requests are answered here, with the
+// allowed methods and headers and how long to cache them; a preflight from an origin
+// that is not allowed is refused with 403
+func (c CORSPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := c.allows(origin)
+		w.Header().Add("Vary", "Origin")
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
+		}
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// withRequestID sets the request ID header of every response, to the client's
+// own ID when it sent a valid one
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
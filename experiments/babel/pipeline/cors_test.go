@@ -0,0 +1,168 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// corsRequest sends a request through the CORS policy to a handler recording
+// whether it ran
+func corsRequest(t *testing.T, policy CORSPolicy, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+	rec := httptest.NewRecorder()
+	policy.handler(next).ServeHTTP(rec, r)
+	return rec, called
+}
+
+func preflight(path, origin string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+	return r
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	policy := ParseAllowedOrigins(" https://app.example.com/ , http://localhost:8501")
+	r := httptest.NewRequest(http.MethodGet, "/voices", nil)
+	r.Header.Set("Origin", "https://APP.example.com")
+	rec, called := corsRequest(t, policy, r)
+	if !called {
+		t.Fatal("handler not called")
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://APP.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request's origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != requestIDHeader {
+		t.Errorf("Access-Control-Expose-Headers = %q, want %q", got, requestIDHeader)
+	}
+	if got := rec.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q, want Origin", got)
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	for name, policy := range map[string]CORSPolicy{
+		"default":      ParseAllowedOrigins(""),
+		"other origin": ParseAllowedOrigins("https://app.example.com"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/babel", nil)
+			r.Header.Set("Origin", "https://evil.example.com")
+			rec, called := corsRequest(t, policy, r)
+			// the browser enforces the policy; the request itself is still served
+			if !called {
+				t.Error("handler not called")
+			}
+			for _, h := range []string{"Access-Control-Allow-Origin", "Access-Control-Expose-Headers"} {
+				if got := rec.Header().Get(h); got != "" {
+					t.Errorf("%s = %q, want none", h, got)
+				}
+			}
+
+			rec, called = corsRequest(t, policy, preflight("/babel", "https://evil.example.com"))
+			if called {
+				t.Error("handler called for a preflight request")
+			}
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("preflight status = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "" {
+				t.Errorf("Access-Control-Allow-Methods = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	for name, policy := range map[string]CORSPolicy{
+		"listed":   ParseAllowedOrigins("http://localhost:8501"),
+		"wildcard": ParseAllowedOrigins("*"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec, called := corsRequest(t, policy, preflight("/babel", "http://localhost:8501"))
+			if called {
+				t.Error("handler called for a preflight request")
+			}
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+			}
+			want := map[string]string{
+				"Access-Control-Allow-Origin":  "http://localhost:8501",
+				"Access-Control-Allow-Methods": corsAllowedMethods,
+				"Access-Control-Allow-Headers": corsAllowedHeaders,
+				"Access-Control-Max-Age":       "600",
+			}
+			for h, v := range want {
+				if got := rec.Header().Get(h); got != v {
+					t.Errorf("%s = %q, want %q", h, got, v)
+				}
+			}
+			if got := rec.Header().Values("Vary"); len(got) != 3 {
+				t.Errorf("Vary = %q, want Origin and the preflight request headers", got)
+			}
+		})
+	}
+}
+
+func TestServiceHandlerRoutes(t *testing.T) {
+	handler := (&Pipeline{}).ServiceHandler(ParseAllowedOrigins("http://localhost:8501"))
+	for _, path := range []string{"/babel", "/voices"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, preflight(path, "http://localhost:8501"))
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("preflight %s: status = %d, want %d", path, rec.Code, http.StatusNoContent)
+		}
+		if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+			t.Errorf("preflight %s: Access-Control-Max-Age = %q, want 600", path, got)
+		}
+		if rec.Header().Get(requestIDHeader) == "" {
+			t.Errorf("preflight %s: no %s", path, requestIDHeader)
+		}
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range []struct {
+		name, sent string
+		want       *regexp.Regexp
+	}{
+		{"echoed", "client-42", regexp.MustCompile(`^client-42$`)},
+		{"generated", "", regexp.MustCompile(`^[0-9a-f]{16}$`)},
+		{"invalid replaced", "bad id\r\nX-Injected: 1", regexp.MustCompile(`^[0-9a-f]{16}$`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/voices", nil)
+			if tt.sent != "" {
+				r.Header[requestIDHeader] = []string{tt.sent}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if got := rec.Header().Get(requestIDHeader); !tt.want.MatchString(got) {
+				t.Errorf("%s = %q, want %s", requestIDHeader, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// defaultCostPerMillionChars is the list price of Chirp 3: HD voices, in USD
+// per million characters
+const defaultCostPerMillionChars = 30.0
+
+// ttsCharCount is the number of characters Cloud Text-to-Speech bills for
+// text, which counts Unicode characters rather than bytes
+func ttsCharCount(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// CharacterSummary totals the characters sent to text-to-speech in a run
+type CharacterSummary struct {
+	// ByLanguage is the characters sent for each language, across its voices
+	ByLanguage     map[string]int `json:"chars_by_language"`
+	TotalChars     int            `json:"total_chars"`
+	CostPerMillion float64        `json:"cost_per_million_chars"`
+	EstimatedCost  float64        `json:"estimated_cost"`
+}
+
+// SummarizeCharacters totals the char_count of the outputs and estimates their
+// cost at costPerMillion per million characters; nil if nothing was voiced
+func SummarizeCharacters(outputs []BabelOutput, costPerMillion float64) *CharacterSummary {
+	summary := &CharacterSummary{ByLanguage: map[string]int{}, CostPerMillion: costPerMillion}
+	for _, o := range outputs {
+		if o.CharCount == 0 {
+			continue
+		}
+		summary.ByLanguage[o.LanguageCode] += o.CharCount
+		summary.TotalChars += o.CharCount
+	}
+	if summary.TotalChars == 0 {
+		return nil
+	}
+	summary.EstimatedCost = float64(summary.TotalChars) * costPerMillion / 1e6
+	return summary
+}
+
+// Languages returns the languages of the summary, sorted
+func (s *CharacterSummary) Languages() []string {
+	languages := make([]string, 0, len(s.ByLanguage))
+	for language := range s.ByLanguage {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// ResolveCostPerMillion returns the per-million-character rate, from the flag
+// when it is set, else from TTS_COST_PER_MILLION_CHARS, else the default
+func ResolveCostPerMillion(flagValue float64, envValue string) (float64, error) {
+	if flagValue >= 0 {
+		return flagValue, nil
+	}
+	if envValue == "" {
+		return defaultCostPerMillionChars, nil
+	}
+	rate, err := strconv.ParseFloat(envValue, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("TTS_COST_PER_MILLION_CHARS must be a non-negative number, got %q", envValue)
+	}
+	return rate, nil
+}
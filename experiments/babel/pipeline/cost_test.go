@@ -0,0 +1,86 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCharCountPerLanguage(t *testing.T) {
+	translations := map[string]string{
+		"de-DE": "Guten Tag",      // 9 characters
+		"ja-JP": "こんにちは",          // 5 characters, 15 bytes
+		"fr-FR": "Bonjour à tous", // 14 characters, 15 bytes
+	}
+	specs := []VoiceSpec{
+		{Name: "de-DE-Chirp3-HD-Fenrir", LanguageCode: "de-DE", Backend: BackendChirp},
+		{Name: "de-DE-Chirp3-HD-Kore", LanguageCode: "de-DE", Backend: BackendChirp},
+		{Name: "ja-JP-Chirp3-HD-Kore", LanguageCode: "ja-JP", Backend: BackendChirp},
+		{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCode: "fr-FR", Backend: BackendChirp},
+	}
+	synthesizers := map[string]Synthesizer{BackendChirp: fakeTTS{fail: "fr-FR-Chirp3-HD-Aoede"}}
+	outputs := generateSpeech(context.Background(), t.TempDir(), specs, translations, synthesizers, SynthesisOptions{}, nil, BestEffort)
+
+	for _, o := range outputs {
+		want := ttsCharCount(translations[o.LanguageCode])
+		if o.Error != "" {
+			want = 0
+		}
+		if o.CharCount != want {
+			t.Errorf("%s char_count = %d, want %d", o.VoiceName, o.CharCount, want)
+		}
+	}
+
+	summary := SummarizeCharacters(outputs, 16)
+	// the failed French voice is not billed
+	if want := map[string]int{"de-DE": 18, "ja-JP": 5}; !reflect.DeepEqual(summary.ByLanguage, want) {
+		t.Errorf("chars by language = %v, want %v", summary.ByLanguage, want)
+	}
+	if summary.TotalChars != 23 || math.Abs(summary.EstimatedCost-23*16/1e6) > 1e-12 {
+		t.Errorf("total %d chars costing %g, want 23 chars costing %g", summary.TotalChars, summary.EstimatedCost, 23*16/1e6)
+	}
+	if got := summary.Languages(); !reflect.DeepEqual(got, []string{"de-DE", "ja-JP"}) {
+		t.Errorf("languages() = %v", got)
+	}
+
+	if SummarizeCharacters(outputs[:0], 16) != nil {
+		t.Error("expected no summary without outputs")
+	}
+}
+
+func TestResolveCostPerMillion(t *testing.T) {
+	tests := []struct {
+		flag    float64
+		env     string
+		want    float64
+		wantErr bool
+	}{
+		{flag: -1, want: defaultCostPerMillionChars},
+		{flag: -1, env: "16", want: 16},
+		{flag: 4, env: "16", want: 4},
+		{flag: 0, want: 0},
+		{flag: -1, env: "cheap", wantErr: true},
+		{flag: -1, env: "-2", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveCostPerMillion(tt.flag, tt.env)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveCostPerMillion(%g, %q) = %g, %v", tt.flag, tt.env, got, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+)
+
+// maxSpeakingRate caps the speed-up used to fit a clip into max_duration_seconds;
+// beyond it speech sounds rushed, so longer clips are flagged instead
+const maxSpeakingRate = 1.5
+
+// wavDuration returns the length of LINEAR16 WAV audio in seconds, from the
+// size of its data chunk and the sample rate, channels and sample size of its
+// fmt chunk
+func wavDuration(audio []byte) (float64, error) {
+	header, pcm, err := splitWAV(audio)
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, fmt.Errorf("audio has no WAV header to read the sample rate from")
+	}
+	sampleRate, channels, bitsPerSample, err := wavFormat(header)
+	if err != nil {
+		return 0, err
+	}
+	return pcmDuration(len(pcm), sampleRate, channels, bitsPerSample), nil
+}
+
+// wavFormat reads the fmt chunk of a WAV header, as returned by splitWAV
+func wavFormat(header []byte) (sampleRate, channels, bitsPerSample int, err error) {
+	for offset := 12; offset+8 <= len(header); {
+		chunkID := string(header[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(header[offset+4 : offset+8]))
+		body := offset + 8
+		if chunkID == "fmt " {
+			if chunkSize < 16 || body+16 > len(header) {
+				return 0, 0, 0, fmt.Errorf("WAV fmt chunk is %d bytes, too short", chunkSize)
+			}
+			channels = int(binary.LittleEndian.Uint16(header[body+2:]))
+			sampleRate = int(binary.LittleEndian.Uint32(header[body+4:]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(header[body+14:]))
+			if channels == 0 || sampleRate == 0 || bitsPerSample == 0 {
+				return 0, 0, 0, fmt.Errorf("WAV fmt chunk has no audio format (%d Hz, %d channels, %d bits)", sampleRate, channels, bitsPerSample)
+			}
+			return sampleRate, channels, bitsPerSample, nil
+		}
+		offset = body + chunkSize + chunkSize%2
+	}
+	return 0, 0, 0, fmt.Errorf("no fmt chunk found in WAV audio")
+}
+
+// pcmDuration is the length in seconds of size bytes of PCM in the given format
+func pcmDuration(size, sampleRate, channels, bitsPerSample int) float64 {
+	bytesPerSecond := sampleRate * channels * bitsPerSample / 8
+	if bytesPerSecond <= 0 {
+		return 0
+	}
+	return float64(size) / float64(bytesPerSecond)
+}
+
+// fitSpeakingRate returns the speaking rate that should bring a clip of the given
+// duration, voiced at rate (1 when 0), down to maxDuration: the rate scaled by how
+// far the clip is over, rounded up to a hundredth and capped at maxSpeakingRate
+func fitSpeakingRate(duration, maxDuration, rate float64) float64 {
+	if rate <= 0 {
+		rate = 1
+	}
+	// the tolerance keeps float error, such as 11/10 giving 1.1000000000000001,
+	// from rounding an exact rate up to the next hundredth
+	fitted := math.Ceil(rate*duration/maxDuration*100-1e-6) / 100
+	return math.Min(fitted, maxSpeakingRate)
+}
+
+// fitDuration measures a clip against opts.MaxDurationSeconds, recording its
+// duration in the output; a clip over the limit is synthesized once more at a
+// faster speaking rate, and flagged if it is still too long. It returns the audio
+// to keep and how many times the text was synthesized again.
+func fitDuration(ctx context.Context, synthesizer Synthesizer, voice VoiceSpec, text string, opts SynthesisOptions, audio []byte, output *BabelOutput) ([]byte, int) {
+	duration, err := wavDuration(audio)
+	if err != nil {
+		log.Printf("unable to measure the duration of %s, not checking it: %v", voice.Name, err)
+		return audio, 0
+	}
+	output.OriginalDurationSeconds = duration
+	output.DurationSeconds = duration
+	output.SpeakingRate = 1
+	if opts.SpeakingRate > 0 {
+		output.SpeakingRate = opts.SpeakingRate
+	}
+	if duration <= opts.MaxDurationSeconds {
+		return audio, 0
+	}
+
+	faster := opts
+	faster.SpeakingRate = fitSpeakingRate(duration, opts.MaxDurationSeconds, opts.SpeakingRate)
+	retaken, err := synthesizer.Synthesize(ctx, voice, text, faster)
+	if err == nil {
+		var retakenDuration float64
+		if retakenDuration, err = wavDuration(retaken); err == nil {
+			audio = retaken
+			output.DurationSeconds = retakenDuration
+			output.SpeakingRate = faster.SpeakingRate
+		}
+	}
+	if err != nil {
+		log.Printf("unable to voice %s faster, keeping the %.2fs clip: %v", voice.Name, duration, err)
+	}
+	output.OverMaxDuration = output.DurationSeconds > opts.MaxDurationSeconds
+	return audio, 1
+}
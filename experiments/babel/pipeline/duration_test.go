@@ -0,0 +1,156 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+// rateSynthesizer voices the text as silence whose length depends on the speaking rate
+type rateSynthesizer struct {
+	seconds func(rate float64) float64
+	rates   []float64
+}
+
+func (r *rateSynthesizer) Synthesize(ctx context.Context, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error) {
+	r.rates = append(r.rates, opts.SpeakingRate)
+	rate := opts.SpeakingRate
+	if rate == 0 {
+		rate = 1
+	}
+	samples := int(math.Round(r.seconds(rate) * testSampleRate))
+	return pcmToWAV(make([]byte, 2*samples), testSampleRate), nil
+}
+
+func (r *rateSynthesizer) Model() string { return "" }
+
+func TestWAVDuration(t *testing.T) {
+	// 24 kHz mono LINEAR16 is 48000 bytes a second
+	for size, want := range map[int]float64{0: 0, 48000: 1, 120000: 2.5, 7200: 0.15} {
+		got, err := wavDuration(pcmToWAV(make([]byte, size), testSampleRate))
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%d bytes: got %v, %v, want %v", size, got, err, want)
+		}
+	}
+
+	// 44.1 kHz stereo LINEAR16 is 176400 bytes a second
+	stereo := pcmToWAV(make([]byte, 441000), 44100)
+	binary.LittleEndian.PutUint16(stereo[22:], 2)
+	if got, err := wavDuration(stereo); err != nil || math.Abs(got-2.5) > 1e-9 {
+		t.Errorf("stereo: got %v, %v, want 2.5", got, err)
+	}
+
+	if _, err := wavDuration(make([]byte, 48000)); err == nil {
+		t.Error("expected raw PCM, with no sample rate, to be rejected")
+	}
+	if got := pcmDuration(96000, 48000, 1, 16); got != 1 {
+		t.Errorf("pcmDuration at 48 kHz = %v, want 1", got)
+	}
+}
+
+func TestFitSpeakingRate(t *testing.T) {
+	tests := []struct {
+		duration, max, rate, want float64
+	}{
+		{duration: 12, max: 10, want: 1.2},
+		{duration: 11, max: 10, rate: 1, want: 1.1},
+		// rounded up, so the retake lands under the limit
+		{duration: 10.01, max: 10, want: 1.01},
+		{duration: 25, max: 10, want: maxSpeakingRate},
+		{duration: 6, max: 5, rate: 1.3, want: maxSpeakingRate},
+		{duration: 6, max: 5, rate: 1.1, want: 1.32},
+	}
+	for _, tc := range tests {
+		if got := fitSpeakingRate(tc.duration, tc.max, tc.rate); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("fitSpeakingRate(%v, %v, %v) = %v, want %v", tc.duration, tc.max, tc.rate, got, tc.want)
+		}
+	}
+}
+
+func TestFitDuration(t *testing.T) {
+	voice := VoiceSpec{Backend: BackendChirp, Name: "de-DE-Chirp3-HD-Fenrir", LanguageCode: "de-DE"}
+	opts := SynthesisOptions{MaxDurationSeconds: 10}
+
+	// a clip that fits is kept as it is
+	tts := &rateSynthesizer{seconds: func(rate float64) float64 { return 8 / rate }}
+	var output BabelOutput
+	first, _ := tts.Synthesize(context.Background(), voice, "hallo", opts)
+	if audio, retakes := fitDuration(context.Background(), tts, voice, "hallo", opts, first, &output); retakes != 0 || len(audio) != len(first) {
+		t.Errorf("expected the clip to be kept, got %d retakes", retakes)
+	}
+	if output.DurationSeconds != 8 || output.OriginalDurationSeconds != 8 || output.SpeakingRate != 1 || output.OverMaxDuration {
+		t.Errorf("unexpected output %+v", output)
+	}
+
+	// 12s is voiced again at 1.2x
+	tts = &rateSynthesizer{seconds: func(rate float64) float64 { return 12 / rate }}
+	output = BabelOutput{}
+	first, _ = tts.Synthesize(context.Background(), voice, "hallo", opts)
+	audio, retakes := fitDuration(context.Background(), tts, voice, "hallo", opts, first, &output)
+	if retakes != 1 || len(tts.rates) != 2 || tts.rates[1] != 1.2 {
+		t.Fatalf("expected one retake at 1.2x, got rates %v", tts.rates)
+	}
+	if output.OriginalDurationSeconds != 12 || math.Abs(output.DurationSeconds-10) > 1e-3 || output.SpeakingRate != 1.2 || output.OverMaxDuration {
+		t.Errorf("unexpected output %+v", output)
+	}
+	if d, _ := wavDuration(audio); d != output.DurationSeconds {
+		t.Errorf("expected the faster take to be kept, got %.3fs", d)
+	}
+
+	// 30s cannot fit even at 1.5x, so the faster take is flagged
+	tts = &rateSynthesizer{seconds: func(rate float64) float64 { return 30 / rate }}
+	output = BabelOutput{}
+	first, _ = tts.Synthesize(context.Background(), voice, "hallo", opts)
+	fitDuration(context.Background(), tts, voice, "hallo", opts, first, &output)
+	if output.SpeakingRate != maxSpeakingRate || math.Abs(output.DurationSeconds-20) > 1e-3 || !output.OverMaxDuration {
+		t.Errorf("expected a flagged 20s clip at 1.5x, got %+v", output)
+	}
+
+	// a failed retake keeps the first take, flagged
+	output = BabelOutput{}
+	failing := stubSynthesizer(func(ctx context.Context, voice VoiceSpec, text string) ([]byte, error) {
+		return nil, errors.New("quota exceeded")
+	})
+	if audio, _ := fitDuration(context.Background(), failing, voice, "hallo", opts, first, &output); len(audio) != len(first) {
+		t.Error("expected the first take to be kept")
+	}
+	if output.DurationSeconds != 30 || output.SpeakingRate != 1 || !output.OverMaxDuration {
+		t.Errorf("unexpected output %+v", output)
+	}
+}
+
+func TestGenerateSpeechMaxDuration(t *testing.T) {
+	tts := &rateSynthesizer{seconds: func(rate float64) float64 { return 6 / rate }}
+	specs := chirpVoiceSpecs([]*texttospeechpb.Voice{{Name: "de-DE-Chirp3-HD-Fenrir", LanguageCodes: []string{"de-DE"}}})
+	outputs := generateSpeech(context.Background(), t.TempDir(), specs, map[string]string{"de-DE": "hallo"}, map[string]Synthesizer{BackendChirp: tts}, SynthesisOptions{MaxDurationSeconds: 5}, nil, BestEffort)
+
+	if len(outputs) != 1 || outputs[0].Error != "" {
+		t.Fatalf("unexpected outputs %+v", outputs)
+	}
+	o := outputs[0]
+	if o.SpeakingRate != 1.2 || o.OriginalDurationSeconds != 6 || o.OverMaxDuration {
+		t.Errorf("expected the clip to be voiced again at 1.2x, got %+v", o)
+	}
+	// both takes are billed
+	if o.CharCount != 2*ttsCharCount("hallo") {
+		t.Errorf("expected the retake's characters to be counted, got %d", o.CharCount)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// effectsProfiles are the audio profiles Cloud Text-to-Speech can optimize a
+// clip for, making it sound as if played on that class of device
+var effectsProfiles = []string{
+	"wearable-class-device",
+	"handset-class-device",
+	"headphone-class-device",
+	"small-bluetooth-speaker-class-device",
+	"medium-bluetooth-speaker-class-device",
+	"large-home-entertainment-class-device",
+	"large-automotive-class-device",
+	"telephony-class-application",
+}
+
+// validateEffectsProfiles checks the request's effects profile and its
+// per-language overrides, so that a typo fails the request before anything is
+// synthesized
+func validateEffectsProfiles(req BabelRequest) error {
+	if req.EffectsProfile != "" && !slices.Contains(effectsProfiles, req.EffectsProfile) {
+		return fmt.Errorf("unknown effects_profile %q, use one of %s", req.EffectsProfile, strings.Join(effectsProfiles, ", "))
+	}
+	for language, profile := range req.EffectsProfileByLanguage {
+		if strings.TrimSpace(language) == "" {
+			return fmt.Errorf("effects_profile_by_language has an empty language code")
+		}
+		// an empty profile turns the request's profile off for the language
+		if profile != "" && !slices.Contains(effectsProfiles, profile) {
+			return fmt.Errorf("unknown effects profile %q for %s in effects_profile_by_language, use one of %s", profile, language, strings.Join(effectsProfiles, ", "))
+		}
+	}
+	return nil
+}
+
+// effectsProfileFor returns the effects profile of a language: its override,
+// matched without regard to case, or else the request's profile
+func (o SynthesisOptions) effectsProfileFor(languageCode string) string {
+	for language, profile := range o.EffectsProfileByLanguage {
+		if strings.EqualFold(language, languageCode) {
+			return profile
+		}
+	}
+	return o.EffectsProfile
+}
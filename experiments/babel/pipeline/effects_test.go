@@ -0,0 +1,142 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// fakeSpeechClient records the Text-to-Speech requests, by voice, and answers
+// each with a short WAV
+type fakeSpeechClient struct {
+	mu       sync.Mutex
+	requests map[string]*texttospeechpb.SynthesizeSpeechRequest
+}
+
+func (f *fakeSpeechClient) SynthesizeSpeech(ctx context.Context, req *texttospeechpb.SynthesizeSpeechRequest, opts ...gax.CallOption) (*texttospeechpb.SynthesizeSpeechResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.requests == nil {
+		f.requests = map[string]*texttospeechpb.SynthesizeSpeechRequest{}
+	}
+	f.requests[req.GetVoice().GetName()] = req
+	return &texttospeechpb.SynthesizeSpeechResponse{AudioContent: pcmToWAV(make([]byte, 96), 24000)}, nil
+}
+
+func TestEffectsProfileAudioConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		req  BabelRequest
+		// want is the effects profile of each voice, "" for none
+		want map[string]string
+	}{
+		{
+			name: "none",
+			req:  BabelRequest{Statement: "hello there"},
+			want: map[string]string{"de-DE-Chirp3-HD-Fenrir": "", "fr-FR-Chirp3-HD-Aoede": "", "ja-JP-Chirp3-HD-Kore": ""},
+		},
+		{
+			name: "global",
+			req:  BabelRequest{Statement: "hello there", EffectsProfile: "telephony-class-application"},
+			want: map[string]string{
+				"de-DE-Chirp3-HD-Fenrir": "telephony-class-application",
+				"fr-FR-Chirp3-HD-Aoede":  "telephony-class-application",
+				"ja-JP-Chirp3-HD-Kore":   "telephony-class-application",
+			},
+		},
+		{
+			name: "per-language override",
+			req: BabelRequest{
+				Statement:      "hello there",
+				EffectsProfile: "telephony-class-application",
+				EffectsProfileByLanguage: map[string]string{
+					"FR-fr": "large-home-entertainment-class-device",
+					"ja-JP": "",
+				},
+			},
+			want: map[string]string{
+				"de-DE-Chirp3-HD-Fenrir": "telephony-class-application",
+				"fr-FR-Chirp3-HD-Aoede":  "large-home-entertainment-class-device",
+				"ja-JP-Chirp3-HD-Kore":   "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeSpeechClient{}
+			p := &Pipeline{
+				Translator:   fakeTranslator{},
+				Synthesizers: map[string]Synthesizer{BackendChirp: chirpSynthesizer{client: client}},
+				WorkDir:      t.TempDir(),
+				Voices:       testVoices(),
+			}
+			outputs, _, err := p.Synthesize(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(client.requests) != len(tt.want) {
+				t.Fatalf("expected %d requests, got %d", len(tt.want), len(client.requests))
+			}
+			for voice, profile := range tt.want {
+				config := client.requests[voice].GetAudioConfig()
+				if config.GetAudioEncoding() != texttospeechpb.AudioEncoding_LINEAR16 {
+					t.Errorf("%s: audio encoding = %v, want LINEAR16", voice, config.GetAudioEncoding())
+				}
+				got := strings.Join(config.GetEffectsProfileId(), ",")
+				if got != profile {
+					t.Errorf("%s: effects profiles = %q, want %q", voice, got, profile)
+				}
+			}
+			for _, o := range outputs {
+				if o.Error != "" || o.EffectsProfile != tt.want[o.VoiceName] {
+					t.Errorf("%s: output effects profile = %q, want %q (error %q)", o.VoiceName, o.EffectsProfile, tt.want[o.VoiceName], o.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidEffectsProfile(t *testing.T) {
+	for name, req := range map[string]BabelRequest{
+		"global":       {Statement: "hello there", EffectsProfile: "telephone"},
+		"per-language": {Statement: "hello there", EffectsProfileByLanguage: map[string]string{"fr-FR": "stadium-class-device"}},
+		"empty code":   {Statement: "hello there", EffectsProfileByLanguage: map[string]string{" ": "telephony-class-application"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &fakeSpeechClient{}
+			p := &Pipeline{
+				Translator:   fakeTranslator{},
+				Synthesizers: map[string]Synthesizer{BackendChirp: chirpSynthesizer{client: client}},
+				Voices:       testVoices(),
+			}
+			_, _, err := p.Synthesize(context.Background(), req)
+			if err == nil {
+				t.Fatal("expected the request to be rejected")
+			}
+			if name != "empty code" && !strings.Contains(err.Error(), strings.Join(effectsProfiles, ", ")) {
+				t.Errorf("expected the error to list the valid profiles, got %v", err)
+			}
+			if len(client.requests) != 0 {
+				t.Errorf("expected no synthesis, got %d requests", len(client.requests))
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"google.golang.org/api/option"
+)
+
+// ResolveAPIEndpoint picks the Gemini API endpoint override, the -api-endpoint flag
+// wins over the API_ENDPOINT env var; an empty result keeps the default regional
+// Vertex AI endpoint
+func ResolveAPIEndpoint(flagValue, envValue string) (string, error) {
+	endpoint := strings.TrimSpace(flagValue)
+	source := "-api-endpoint"
+	if endpoint == "" {
+		endpoint = strings.TrimSpace(envValue)
+		source = "API_ENDPOINT"
+	}
+	if endpoint == "" {
+		return "", nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", source, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("%s: %q must be an http or https URL, e.g. https://europe-west4-aiplatform.googleapis.com", source, endpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%s: %q has no host", source, endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%s: %q must not have a query or fragment", source, endpoint)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// translationClientOptions returns the options for the translation client; a custom
+// endpoint is a base URL, so the client is switched to the REST transport to use it
+func translationClientOptions(endpoint string) []option.ClientOption {
+	if endpoint == "" {
+		return nil
+	}
+	return []option.ClientOption{option.WithEndpoint(endpoint), genai.WithREST()}
+}
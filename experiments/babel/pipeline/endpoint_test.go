@@ -0,0 +1,64 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveAPIEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		flag, env string
+		want      string
+	}{
+		{"", "", ""},
+		{"", "https://europe-west4-aiplatform.googleapis.com/", "https://europe-west4-aiplatform.googleapis.com"},
+		{"https://private.example.com", "https://europe-west4-aiplatform.googleapis.com", "https://private.example.com"},
+		{"  ", "http://localhost:8080", "http://localhost:8080"},
+	} {
+		got, err := ResolveAPIEndpoint(tc.flag, tc.env)
+		if err != nil || got != tc.want {
+			t.Errorf("ResolveAPIEndpoint(%q, %q) = %q, %v; want %q", tc.flag, tc.env, got, err, tc.want)
+		}
+	}
+}
+
+func TestResolveAPIEndpointInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		flag, env string
+		source    string
+	}{
+		{"europe-west4-aiplatform.googleapis.com", "", "-api-endpoint"},
+		{"", "ftp://example.com", "API_ENDPOINT"},
+		{"", "https://", "API_ENDPOINT"},
+		{"https://example.com/?key=x", "", "-api-endpoint"},
+		{"", "https://exa mple.com", "API_ENDPOINT"},
+	} {
+		_, err := ResolveAPIEndpoint(tc.flag, tc.env)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.source+":") {
+			t.Errorf("ResolveAPIEndpoint(%q, %q) error = %v; want an error naming %s", tc.flag, tc.env, err, tc.source)
+		}
+	}
+}
+
+func TestTranslationClientOptions(t *testing.T) {
+	if opts := translationClientOptions(""); len(opts) != 0 {
+		t.Errorf("expected no options for the default endpoint, got %d", len(opts))
+	}
+	if opts := translationClientOptions("https://private.example.com"); len(opts) != 2 {
+		t.Errorf("expected the endpoint and REST options, got %d", len(opts))
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ErrorMode selects how per-language and per-voice failures are handled
+type ErrorMode int
+
+const (
+	// BestEffort continues past failures, records them in the metadata and exits zero
+	BestEffort ErrorMode = iota
+	// Strict runs every language and voice, then exits non-zero if any of them failed
+	Strict
+	// FailFast aborts at the first failure
+	FailFast
+)
+
+func (m ErrorMode) String() string {
+	switch m {
+	case Strict:
+		return "strict"
+	case FailFast:
+		return "fail-fast"
+	default:
+		return "best-effort"
+	}
+}
+
+// ErrorModeFromFlags returns the error mode for the --fail-fast and --strict flags
+func ErrorModeFromFlags(failFast, strict bool) (ErrorMode, error) {
+	switch {
+	case failFast && strict:
+		return BestEffort, fmt.Errorf("--fail-fast and --strict cannot be used together")
+	case failFast:
+		return FailFast, nil
+	case strict:
+		return Strict, nil
+	default:
+		return BestEffort, nil
+	}
+}
+
+// BabelFailure describes a language that could not be translated or a voice that
+// could not be synthesized
+type BabelFailure struct {
+	LanguageCode string `json:"language_code"`
+	VoiceName    string `json:"voice_name,omitempty"`
+	Error        string `json:"error"`
+}
+
+// CollectFailures lists translation failures by language, followed by voice failures;
+// voices skipped for a failed translation are covered by their language's failure
+func CollectFailures(translationErrors map[string]error, outputs []BabelOutput) []BabelFailure {
+	var failures []BabelFailure
+	languages := make([]string, 0, len(translationErrors))
+	for language := range translationErrors {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		failures = append(failures, BabelFailure{
+			LanguageCode: language,
+			Error:        translationErrors[language].Error(),
+		})
+	}
+	for _, o := range outputs {
+		if o.Error != "" && !o.TranslationFailed {
+			failures = append(failures, BabelFailure{
+				LanguageCode: o.LanguageCode,
+				VoiceName:    o.VoiceName,
+				Error:        o.Error,
+			})
+		}
+	}
+	return failures
+}
+
+// synthesisStatus returns the HTTP status for a /babel response: best-effort always
+// reports 200, otherwise failures give 207 when some audio was still produced and 500
+// when nothing was (or when fail-fast aborted the run)
+func synthesisStatus(mode ErrorMode, succeeded int, failures []BabelFailure) int {
+	if mode == BestEffort || len(failures) == 0 {
+		return http.StatusOK
+	}
+	if mode == FailFast || succeeded == 0 {
+		return http.StatusInternalServerError
+	}
+	return http.StatusMultiStatus
+}
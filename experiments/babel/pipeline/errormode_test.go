@@ -0,0 +1,245 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+func TestErrorModeFromFlags(t *testing.T) {
+	for _, tc := range []struct {
+		failFast, strict bool
+		want             ErrorMode
+	}{
+		{false, false, BestEffort},
+		{false, true, Strict},
+		{true, false, FailFast},
+	} {
+		got, err := ErrorModeFromFlags(tc.failFast, tc.strict)
+		if err != nil || got != tc.want {
+			t.Errorf("ErrorModeFromFlags(%t, %t) = %s, %v; want %s", tc.failFast, tc.strict, got, err, tc.want)
+		}
+	}
+	if _, err := ErrorModeFromFlags(true, true); err == nil {
+		t.Error("expected --fail-fast and --strict together to be rejected")
+	}
+}
+
+func TestSynthesisStatus(t *testing.T) {
+	failures := []BabelFailure{{LanguageCode: "fr-FR", VoiceName: "fr-FR-Chirp3-HD-Aoede", Error: "boom"}}
+	for _, tc := range []struct {
+		name      string
+		mode      ErrorMode
+		succeeded int
+		failures  []BabelFailure
+		want      int
+	}{
+		{"best-effort ignores failures", BestEffort, 0, failures, http.StatusOK},
+		{"strict without failures", Strict, 3, nil, http.StatusOK},
+		{"strict partial failure", Strict, 2, failures, http.StatusMultiStatus},
+		{"strict total failure", Strict, 0, failures, http.StatusInternalServerError},
+		{"fail-fast aborted", FailFast, 2, failures, http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := synthesisStatus(tc.mode, tc.succeeded, tc.failures); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCollectFailures(t *testing.T) {
+	translationErrors := map[string]error{"ja-JP": errors.New("quota"), "de-DE": errors.New("timeout")}
+	outputs := []BabelOutput{
+		{VoiceName: "en-US-Chirp3-HD-Puck", LanguageCode: "en-US", Length: 100},
+		{VoiceName: "fr-FR-Chirp3-HD-Aoede", LanguageCode: "fr-FR", Error: "fr-FR-Chirp3-HD-Aoede voice generated 0 bytes"},
+	}
+	failures := CollectFailures(translationErrors, outputs)
+	want := []BabelFailure{
+		{LanguageCode: "de-DE", Error: "timeout"},
+		{LanguageCode: "ja-JP", Error: "quota"},
+		{LanguageCode: "fr-FR", VoiceName: "fr-FR-Chirp3-HD-Aoede", Error: "fr-FR-Chirp3-HD-Aoede voice generated 0 bytes"},
+	}
+	if len(failures) != len(want) {
+		t.Fatalf("got %d failures, want %d: %+v", len(failures), len(want), failures)
+	}
+	for i := range want {
+		if failures[i] != want[i] {
+			t.Errorf("failure %d = %+v, want %+v", i, failures[i], want[i])
+		}
+	}
+}
+
+func TestGenerateSpeechFailureModes(t *testing.T) {
+	dir := t.TempDir()
+	synthesizers := map[string]Synthesizer{BackendChirp: stubSynthesizer(func(ctx context.Context, voice VoiceSpec, text string) ([]byte, error) {
+		if voice.Name == "de-DE-Chirp3-HD-Fenrir" {
+			return nil, errors.New("synthesis failed")
+		}
+		// the other voices only finish once fail-fast has cancelled them, or
+		// straight away when running best-effort
+		if ctx.Value(holdKey{}) != nil {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte("RIFF"), nil
+	})}
+
+	voices := []*texttospeechpb.Voice{
+		{Name: "en-US-Chirp3-HD-Puck", LanguageCodes: []string{"en-US"}},
+		{Name: "de-DE-Chirp3-HD-Fenrir", LanguageCodes: []string{"de-DE"}},
+		{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCodes: []string{"fr-FR"}},
+	}
+	specs := chirpVoiceSpecs(voices)
+	translations := map[string]string{"en-US": "hello", "de-DE": "hallo", "fr-FR": "bonjour"}
+
+	outputs := generateSpeech(context.Background(), dir, specs, translations, synthesizers, SynthesisOptions{}, nil, Strict)
+	if len(outputs) != len(voices) {
+		t.Fatalf("expected one result per voice, got %d", len(outputs))
+	}
+	if failures := CollectFailures(nil, outputs); len(failures) != 1 || failures[0].VoiceName != "de-DE-Chirp3-HD-Fenrir" {
+		t.Errorf("expected only the failing voice to be reported, got %+v", failures)
+	}
+
+	ctx := context.WithValue(context.Background(), holdKey{}, true)
+	outputs = generateSpeech(ctx, dir, specs, translations, synthesizers, SynthesisOptions{}, nil, FailFast)
+	if len(outputs) != len(voices) {
+		t.Fatalf("expected one result per voice, got %d", len(outputs))
+	}
+	if failures := CollectFailures(nil, outputs); len(failures) != len(voices) {
+		t.Errorf("expected the first failure to cancel the other voices, got %+v", failures)
+	}
+}
+
+// the stubs below ignore ctx and hold until released, so these tests only pass
+// if the collection loops themselves stop on cancellation
+
+func TestTranslateCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	done := make(chan struct{})
+	generate := func(ctx context.Context, prompt string) (string, error) {
+		if strings.Contains(prompt, "de-DE") {
+			defer close(done)
+			return "hallo", nil
+		}
+		<-release
+		return "too late", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		// let the finished translation reach the collector before cancelling
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	finished := make(chan struct{})
+	var translations map[string]string
+	var translationErrors map[string]error
+	go func() {
+		defer close(finished)
+		translations, _, translationErrors, _ = translate(ctx, generate, "hello", defaultSourceLanguage, []string{"de-DE", "fr-FR", "ja-JP"}, BestEffort)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("translate did not return after cancellation")
+	}
+
+	if translations["de-DE"] != "hallo" || translationErrors["de-DE"] != nil {
+		t.Errorf("expected the finished translation to be kept, got %q, %v", translations["de-DE"], translationErrors["de-DE"])
+	}
+	for _, language := range []string{"fr-FR", "ja-JP"} {
+		if !errors.Is(translationErrors[language], context.Canceled) {
+			t.Errorf("%s: expected a cancellation error, got %v", language, translationErrors[language])
+		}
+	}
+}
+
+func TestGenerateSpeechCancelled(t *testing.T) {
+	dir := t.TempDir()
+	release := make(chan struct{})
+	defer close(release)
+	done := make(chan struct{})
+	synthesizers := map[string]Synthesizer{BackendChirp: stubSynthesizer(func(ctx context.Context, voice VoiceSpec, text string) ([]byte, error) {
+		if voice.LanguageCode == "en-US" {
+			defer close(done)
+			return []byte("RIFF"), nil
+		}
+		// like the real synthesizers, give up once generateSpeech has returned and cancelled
+		// its context, so no clip is written after the test's directory is removed
+		select {
+		case <-release:
+			return []byte("RIFF"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})}
+	specs := chirpVoiceSpecs([]*texttospeechpb.Voice{
+		{Name: "en-US-Chirp3-HD-Puck", LanguageCodes: []string{"en-US"}},
+		{Name: "de-DE-Chirp3-HD-Fenrir", LanguageCodes: []string{"de-DE"}},
+		{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCodes: []string{"fr-FR"}},
+	})
+	translations := map[string]string{"en-US": "hello", "de-DE": "hallo", "fr-FR": "bonjour"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	finished := make(chan struct{})
+	var outputs []BabelOutput
+	go func() {
+		defer close(finished)
+		outputs = generateSpeech(ctx, dir, specs, translations, synthesizers, SynthesisOptions{}, nil, BestEffort)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("generateSpeech did not return after cancellation")
+	}
+
+	if len(outputs) != len(specs) {
+		t.Fatalf("expected one result per voice, got %+v", outputs)
+	}
+	failures := CollectFailures(nil, outputs)
+	if len(failures) != 2 {
+		t.Fatalf("expected the unfinished voices to fail, got %+v", failures)
+	}
+	for _, f := range failures {
+		if f.LanguageCode == "en-US" || !strings.Contains(f.Error, context.Canceled.Error()) {
+			t.Errorf("unexpected failure %+v", f)
+		}
+	}
+	for _, o := range outputs {
+		if batchID(outputs) == "" || !strings.HasPrefix(o.AudioPath, batchID(outputs)+"-") {
+			t.Errorf("%s: expected a clip name in the batch, got %q", o.VoiceName, o.AudioPath)
+		}
+	}
+}
+
+// holdKey makes the stub synthesizer wait for cancellation
+type holdKey struct{}
@@ -0,0 +1,218 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// DefaultTargetRMSDBFS is the loudness all clips are brought to when normalizing
+	DefaultTargetRMSDBFS = -20.0
+	// defaultTruePeakCeilingDBFS keeps normalized clips clear of clipping, including
+	// inter-sample peaks created on playback
+	defaultTruePeakCeilingDBFS = -1.0
+	// truePeakOversampling is the interpolation factor used to estimate true peak
+	truePeakOversampling = 4
+	// truePeakHalfTaps is the number of neighbouring samples on each side used by
+	// the true-peak interpolator
+	truePeakHalfTaps = 8
+)
+
+// LoudnessOptions configures post-synthesis loudness normalization.
+// Levels are in dBFS relative to a full-scale square wave, so a full-scale
+// sine wave has an RMS of about -3 dBFS.
+type LoudnessOptions struct {
+	TargetRMSDBFS       float64
+	TruePeakCeilingDBFS float64
+}
+
+// LoudnessResult is the measured level of a clip and the gain applied to it
+type LoudnessResult struct {
+	RMSDBFS      float64
+	TruePeakDBFS float64
+	GainDB       float64
+}
+
+// newLoudnessOptions returns normalization options using the defaults for any
+// level that is not provided
+func newLoudnessOptions(targetRMSDBFS, truePeakCeilingDBFS *float64) *LoudnessOptions {
+	opts := &LoudnessOptions{
+		TargetRMSDBFS:       DefaultTargetRMSDBFS,
+		TruePeakCeilingDBFS: defaultTruePeakCeilingDBFS,
+	}
+	if targetRMSDBFS != nil {
+		opts.TargetRMSDBFS = *targetRMSDBFS
+	}
+	if truePeakCeilingDBFS != nil {
+		opts.TruePeakCeilingDBFS = *truePeakCeilingDBFS
+	}
+	return opts
+}
+
+// normalizeLoudness applies gain to a LINEAR16 clip so its RMS reaches the
+// target level, reduced where needed so the true peak stays under the ceiling.
+// The audio may be a WAV file (as returned by Text-to-Speech) or raw PCM; the
+// input slice is not modified.
+func normalizeLoudness(audio []byte, opts LoudnessOptions) ([]byte, LoudnessResult, error) {
+	header, pcm, err := splitWAV(audio)
+	if err != nil {
+		return nil, LoudnessResult{}, err
+	}
+	samples := decodePCM16(pcm)
+	rms, _ := measurePCM16(samples)
+	truePeak := truePeakPCM16(samples)
+
+	result := LoudnessResult{
+		RMSDBFS:      amplitudeToDB(rms),
+		TruePeakDBFS: amplitudeToDB(truePeak),
+	}
+	if rms == 0 {
+		// silence: nothing to normalize
+		return audio, result, nil
+	}
+	result.GainDB = computeLoudnessGain(result.RMSDBFS, result.TruePeakDBFS, opts)
+
+	normalized := make([]byte, 0, len(audio))
+	normalized = append(normalized, header...)
+	normalized = append(normalized, encodePCM16(applyGain(samples, result.GainDB))...)
+	return normalized, result, nil
+}
+
+// computeLoudnessGain returns the gain in dB that moves the RMS level to the
+// target without pushing the true peak over the ceiling
+func computeLoudnessGain(rmsDBFS, truePeakDBFS float64, opts LoudnessOptions) float64 {
+	gain := opts.TargetRMSDBFS - rmsDBFS
+	if truePeakDBFS+gain > opts.TruePeakCeilingDBFS {
+		gain = opts.TruePeakCeilingDBFS - truePeakDBFS
+	}
+	return gain
+}
+
+// splitWAV separates a WAV file into everything up to the start of the "data"
+// chunk and the PCM payload. Input without a RIFF header is treated as raw PCM.
+func splitWAV(audio []byte) (header, pcm []byte, err error) {
+	if len(audio) < 12 || !bytes.Equal(audio[0:4], []byte("RIFF")) || !bytes.Equal(audio[8:12], []byte("WAVE")) {
+		return nil, audio, nil
+	}
+	for offset := 12; offset+8 <= len(audio); {
+		chunkID := string(audio[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(audio[offset+4 : offset+8]))
+		body := offset + 8
+		if chunkID == "data" {
+			end := body + chunkSize
+			// streamed WAVs may carry a placeholder size; use what is actually there
+			if chunkSize == 0 || end > len(audio) {
+				end = len(audio)
+			}
+			return audio[:body], audio[body:end], nil
+		}
+		offset = body + chunkSize + chunkSize%2
+	}
+	return nil, nil, fmt.Errorf("no data chunk found in WAV audio")
+}
+
+// decodePCM16 converts little-endian 16-bit PCM to samples in [-1, 1)
+func decodePCM16(pcm []byte) []float64 {
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[2*i:]))) / 32768
+	}
+	return samples
+}
+
+// encodePCM16 converts samples back to little-endian 16-bit PCM, clamping to range
+func encodePCM16(samples []float64) []byte {
+	pcm := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		v := math.Round(s * 32768)
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(v)))
+	}
+	return pcm
+}
+
+// measurePCM16 returns the RMS and sample peak of the samples as linear amplitudes
+func measurePCM16(samples []float64) (rms, peak float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	var sumSquares float64
+	for _, s := range samples {
+		sumSquares += s * s
+		if a := math.Abs(s); a > peak {
+			peak = a
+		}
+	}
+	return math.Sqrt(sumSquares / float64(len(samples))), peak
+}
+
+// truePeakPCM16 estimates the true (inter-sample) peak by oversampling with a
+// Hann-windowed sinc interpolator, in the spirit of ITU-R BS.1770 true-peak meters
+func truePeakPCM16(samples []float64) float64 {
+	_, peak := measurePCM16(samples)
+	for i := 0; i+1 < len(samples); i++ {
+		for k := 1; k < truePeakOversampling; k++ {
+			t := float64(k) / truePeakOversampling
+			var v float64
+			for j := -truePeakHalfTaps + 1; j <= truePeakHalfTaps; j++ {
+				n := i + j
+				if n < 0 || n >= len(samples) {
+					continue
+				}
+				x := t - float64(j)
+				window := 0.5 * (1 + math.Cos(math.Pi*x/truePeakHalfTaps))
+				v += samples[n] * sinc(x) * window
+			}
+			if a := math.Abs(v); a > peak {
+				peak = a
+			}
+		}
+	}
+	return peak
+}
+
+// sinc is the normalized sinc function sin(pi x)/(pi x)
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// applyGain returns a copy of the samples scaled by the gain in dB
+func applyGain(samples []float64, gainDB float64) []float64 {
+	factor := math.Pow(10, gainDB/20)
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		out[i] = s * factor
+	}
+	return out
+}
+
+// amplitudeToDB converts a linear amplitude to dBFS; silence is reported as -Inf
+func amplitudeToDB(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(amplitude)
+}
@@ -0,0 +1,155 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+const testSampleRate = 24000
+
+// sinePCM16 returns one second of a 16-bit sine wave with the given peak amplitude (0-1)
+func sinePCM16(amplitude, frequency float64) []byte {
+	samples := make([]float64, testSampleRate)
+	for i := range samples {
+		samples[i] = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/testSampleRate)
+	}
+	return encodePCM16(samples)
+}
+
+// wavFile wraps PCM in a minimal mono 16-bit WAV header, as returned by Text-to-Speech
+func wavFile(pcm []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(testSampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(testSampleRate*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+func assertNear(t *testing.T, name string, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > tolerance {
+		t.Errorf("%s = %.3f, want %.3f (±%.3f)", name, got, want, tolerance)
+	}
+}
+
+func TestMeasurePCM16Sine(t *testing.T) {
+	// a sine with peak amplitude A has RMS A/sqrt(2), i.e. 3.01 dB below its peak
+	samples := decodePCM16(sinePCM16(0.5, 1000))
+	rms, peak := measurePCM16(samples)
+	assertNear(t, "rms dBFS", amplitudeToDB(rms), -9.03, 0.05)
+	assertNear(t, "peak dBFS", amplitudeToDB(peak), -6.02, 0.05)
+
+	rms, peak = measurePCM16(nil)
+	if rms != 0 || peak != 0 {
+		t.Errorf("expected zero levels for an empty buffer, got rms=%v peak=%v", rms, peak)
+	}
+}
+
+func TestTruePeakPCM16(t *testing.T) {
+	// at fs/4 with a 45 degree phase offset, every sample lands at ±0.707 of the
+	// waveform's real peak, so only interpolation reveals the inter-sample peak
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = 0.8 * math.Sin(math.Pi/2*float64(i)+math.Pi/4)
+	}
+	_, samplePeak := measurePCM16(samples)
+	truePeak := truePeakPCM16(samples)
+	assertNear(t, "sample peak", samplePeak, 0.8*math.Sqrt2/2, 0.001)
+	if truePeak <= samplePeak {
+		t.Errorf("expected the true peak (%.3f) to exceed the sample peak (%.3f)", truePeak, samplePeak)
+	}
+	assertNear(t, "true peak", truePeak, 0.8, 0.05)
+}
+
+func TestNormalizeLoudnessToTarget(t *testing.T) {
+	opts := LoudnessOptions{TargetRMSDBFS: -20, TruePeakCeilingDBFS: -1}
+	quiet := wavFile(sinePCM16(0.05, 440)) // about -29 dBFS RMS
+	loud := wavFile(sinePCM16(0.5, 440))   // about -9 dBFS RMS
+
+	for name, clip := range map[string][]byte{"quiet": quiet, "loud": loud} {
+		t.Run(name, func(t *testing.T) {
+			original := append([]byte(nil), clip...)
+			normalized, result, err := normalizeLoudness(clip, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(clip, original) {
+				t.Error("the input buffer must not be modified")
+			}
+			if !bytes.Equal(normalized[:44], clip[:44]) || len(normalized) != len(clip) {
+				t.Error("the WAV header and length must be preserved")
+			}
+			_, pcm, _ := splitWAV(normalized)
+			rms, _ := measurePCM16(decodePCM16(pcm))
+			assertNear(t, "normalized rms dBFS", amplitudeToDB(rms), -20, 0.1)
+			assertNear(t, "reported gain", result.GainDB, -20-result.RMSDBFS, 0.001)
+		})
+	}
+}
+
+func TestNormalizeLoudnessTruePeakCeiling(t *testing.T) {
+	// a sine only has 3 dB of crest factor, so reaching -2 dBFS RMS would put
+	// its peak at +1 dBFS; the ceiling must win
+	opts := LoudnessOptions{TargetRMSDBFS: -2, TruePeakCeilingDBFS: -1}
+	normalized, result, err := normalizeLoudness(wavFile(sinePCM16(0.25, 440)), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, pcm, _ := splitWAV(normalized)
+	samples := decodePCM16(pcm)
+	truePeakDB := amplitudeToDB(truePeakPCM16(samples))
+	if truePeakDB > -1+0.01 {
+		t.Errorf("true peak %.3f dBFS exceeds the -1 dBFS ceiling", truePeakDB)
+	}
+	assertNear(t, "gain limited by ceiling", result.GainDB, -1-result.TruePeakDBFS, 0.001)
+	rms, _ := measurePCM16(samples)
+	if amplitudeToDB(rms) > -3.5 {
+		t.Errorf("expected the RMS to fall short of the target, got %.3f dBFS", amplitudeToDB(rms))
+	}
+}
+
+func TestNormalizeLoudnessSilenceAndRawPCM(t *testing.T) {
+	silence := wavFile(make([]byte, 2000))
+	out, result, err := normalizeLoudness(silence, LoudnessOptions{TargetRMSDBFS: -20, TruePeakCeilingDBFS: -1})
+	if err != nil || result.GainDB != 0 || !bytes.Equal(out, silence) {
+		t.Errorf("silence should pass through unchanged, got gain=%v err=%v", result.GainDB, err)
+	}
+
+	raw := sinePCM16(0.1, 440)
+	out, _, err = normalizeLoudness(raw, LoudnessOptions{TargetRMSDBFS: -20, TruePeakCeilingDBFS: -1})
+	if err != nil || len(out) != len(raw) {
+		t.Fatalf("raw PCM should be normalized in place of a WAV, got len=%d err=%v", len(out), err)
+	}
+	rms, _ := measurePCM16(decodePCM16(out))
+	assertNear(t, "raw pcm rms dBFS", amplitudeToDB(rms), -20, 0.1)
+
+	if _, _, err := normalizeLoudness([]byte("RIFF\x04\x00\x00\x00WAVEfmt "), LoudnessOptions{}); err == nil {
+		t.Error("expected an error for a WAV without a data chunk")
+	}
+}
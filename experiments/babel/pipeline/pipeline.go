@@ -0,0 +1,353 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pipeline translates a statement into every language of the available
+// voices, voices each translation and stores the clips, from the command line
+// or as the Babel service
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+// Translator generates text from a prompt, used for translation and romanization
+type Translator interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+// ObjectStore stores the generated audio files and the batch manifests
+type ObjectStore interface {
+	Put(ctx context.Context, objectName string, r io.Reader) error
+	// Get opens an object; a missing object is errObjectNotFound
+	Get(ctx context.Context, objectName string) (io.ReadCloser, error)
+}
+
+// Pipeline translates a statement, voices each translation and stores the clips;
+// the clients it calls are all injected, so the whole flow can run against fakes
+type Pipeline struct {
+	Translator Translator
+	// Synthesizers voice the clips, by backend
+	Synthesizers map[string]Synthesizer
+	// Store receives the audio files of service requests
+	Store ObjectStore
+	// StoragePath is the prefix of the stored object names
+	StoragePath string
+	// WorkDir is where the clips are written before they are uploaded, or left
+	// by Synthesize; the working directory when empty
+	WorkDir string
+	// Routes send the audio files of some languages to other buckets and paths,
+	// as --bucket-map configures; OpenStore opens the store of a route's bucket
+	Routes    BucketMap
+	OpenStore func(bucket string) ObjectStore
+	// Voices are the available Chirp voices; their languages are the languages
+	// the statement is translated into
+	Voices []*texttospeechpb.Voice
+	Mode   ErrorMode
+	// Romanize adds romanized transcripts to every request, as --romanize does
+	Romanize bool
+	// CostPerMillionChars is the text-to-speech price used for the cost estimate
+	CostPerMillionChars float64
+}
+
+// Languages returns the unique language codes of the voices, sorted
+func (p *Pipeline) Languages() []string {
+	langsmap := make(map[string]bool)
+	for _, v := range p.Voices {
+		langsmap[v.GetLanguageCodes()[0]] = true
+	}
+	languages := make([]string, 0, len(langsmap))
+	for lang := range langsmap {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Synthesize translates the request's statement and voices it, leaving the audio
+// files in WorkDir; translation failures are returned alongside the
+// outputs, an error means the request itself is invalid
+// pre-translated statements skip translation and are voiced as they are
+func (p *Pipeline) Synthesize(ctx context.Context, req BabelRequest) ([]BabelOutput, map[string]error, error) {
+	if req.MaxDurationSeconds < 0 {
+		return nil, nil, fmt.Errorf("max_duration_seconds must be positive, got %g", req.MaxDurationSeconds)
+	}
+	if err := validateEffectsProfiles(req); err != nil {
+		return nil, nil, err
+	}
+	languages := p.Languages()
+	if len(req.PreTranslated) > 0 {
+		// the statements are already translated, voice them as they are
+		specs, err := preTranslatedSpecs(req, p.Voices, languages)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p.voice(ctx, req, specs, req.PreTranslated, nil, nil), nil, nil
+	}
+	specs, err := resolveVoiceSpecs(req, p.Voices, languages)
+	if err != nil {
+		return nil, nil, err
+	}
+	sourceLanguage := req.SourceLanguage
+	if sourceLanguage == "" {
+		sourceLanguage = defaultSourceLanguage
+	}
+	translations, translationTimes, translationErrors, suspects := translate(ctx, p.Translator.Generate, req.Statement, sourceLanguage, languages, p.Mode)
+	// generate speech, unless fail-fast has already seen a failure
+	if p.Mode == FailFast && len(translationErrors) > 0 {
+		return nil, translationErrors, nil
+	}
+	// a failed translation's text is its error message, which must not be voiced
+	specs, skipped := skipFailedTranslations(specs, translationErrors)
+	if req.SkipSuspect {
+		var skippedSuspects []BabelOutput
+		specs, skippedSuspects = skipSuspects(specs, translations, suspects)
+		skipped = append(skipped, skippedSuspects...)
+	}
+	outputs := p.voice(ctx, req, specs, translations, translationTimes, translationErrors)
+	applySuspects(outputs, suspects)
+	return append(outputs, skipped...), translationErrors, nil
+}
+
+// skipFailedTranslations separates the voices of languages whose translation
+// failed from the others, returning a failed output without text for each of them
+func skipFailedTranslations(specs []VoiceSpec, translationErrors map[string]error) ([]VoiceSpec, []BabelOutput) {
+	var kept []VoiceSpec
+	var skipped []BabelOutput
+	for _, voice := range specs {
+		err, failed := translationErrors[voice.LanguageCode]
+		if !failed {
+			kept = append(kept, voice)
+			continue
+		}
+		skipped = append(skipped, BabelOutput{
+			VoiceName:         voice.Name,
+			LanguageCode:      voice.LanguageCode,
+			Gender:            voice.Gender,
+			Backend:           voice.Backend,
+			Error:             fmt.Sprintf("translation to %s failed, not voiced: %v", voice.LanguageCode, err),
+			TranslationFailed: true,
+		})
+	}
+	return kept, skipped
+}
+
+// voice generates the speech of each voice from its language's text and adds
+// the timings and, when requested, the romanizations to the outputs
+func (p *Pipeline) voice(ctx context.Context, req BabelRequest, specs []VoiceSpec, translations map[string]string, translationTimes map[string]time.Duration, translationErrors map[string]error) []BabelOutput {
+	outputs := p.speak(ctx, req, specs, translations)
+	applyTranslationTimes(outputs, translationTimes)
+	if req.Romanize || p.Romanize {
+		applyRomanizations(outputs, romanize(ctx, p.Translator.Generate, translations, translationErrors))
+	}
+	return outputs
+}
+
+// speak generates the speech of each voice from its language's text, with the
+// request's style and loudness settings
+func (p *Pipeline) speak(ctx context.Context, req BabelRequest, specs []VoiceSpec, translations map[string]string) []BabelOutput {
+	var loudness *LoudnessOptions
+	if req.NormalizeLoudness {
+		loudness = newLoudnessOptions(req.TargetRMSDBFS, req.TruePeakCeilingDBFS)
+	}
+	opts := SynthesisOptions{
+		Modifiers:                req.Modifiers,
+		Instructions:             req.Instructions,
+		MaxDurationSeconds:       req.MaxDurationSeconds,
+		EffectsProfile:           req.EffectsProfile,
+		EffectsProfileByLanguage: req.EffectsProfileByLanguage,
+	}
+	return generateSpeech(ctx, p.WorkDir, specs, translations, p.Synthesizers, opts, loudness, p.Mode)
+}
+
+// ServiceHandler routes the service's endpoints; every route is served through
+// the CORS policy and gets a request ID
+func (p *Pipeline) ServiceHandler(cors CORSPolicy) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /babel", p.handleSynthesis)
+	mux.HandleFunc("GET /voices", p.handleListVoices)
+	return withRequestID(cors.handler(mux))
+}
+
+// destination routes the audio files of a language with Routes, to Store and
+// StoragePath when no route matches
+func (p *Pipeline) destination(languageCode string) (string, objectUploader) {
+	if route, ok := p.Routes.route(languageCode); ok {
+		return route.Path, p.OpenStore(route.Bucket).Put
+	}
+	return p.StoragePath, p.Store.Put
+}
+
+// applyStorageURIs reports where the routed audio files were stored, as the
+// default location is the only one clients know of
+func (p *Pipeline) applyStorageURIs(outputs []BabelOutput) {
+	for i := range outputs {
+		if route, ok := p.Routes.route(outputs[i].LanguageCode); ok && outputs[i].Length > 0 {
+			outputs[i].StorageURI = fmt.Sprintf("gs://%s/%s", route.Bucket, path.Join(route.Path, outputs[i].AudioPath))
+		}
+	}
+}
+
+// errStorage is reported to service clients when the audio could not be stored
+var errStorage = errors.New("error writing to Storage")
+
+// run serves a /babel request: it synthesizes the clips, stores them with the
+// batch manifest and returns the response with its HTTP status; on error, the
+// status is the one to report it with
+// a request with retry_of voices only the failed (or the named) voices of that
+// batch again and responds with just those entries
+func (p *Pipeline) run(ctx context.Context, req BabelRequest) (BabelResponse, int, error) {
+	var outputs []BabelOutput
+	var translationErrors map[string]error
+	var previous *BatchManifest
+	if req.RetryOf != "" {
+		manifest, err := p.loadManifest(ctx, req.RetryOf)
+		if errors.Is(err, errBatchNotFound) {
+			return BabelResponse{}, http.StatusNotFound, err
+		}
+		if err != nil {
+			log.Printf("unable to read batch %s: %v", req.RetryOf, err)
+			return BabelResponse{}, http.StatusInternalServerError, errStorage
+		}
+		specs, err := manifest.retrySpecs(req.Voices)
+		if err != nil {
+			return BabelResponse{}, http.StatusBadRequest, err
+		}
+		log.Printf("retrying %d voice(s) of batch %s", len(specs), req.RetryOf)
+		outputs, previous = p.retryVoices(ctx, manifest, specs), manifest
+	} else {
+		var err error
+		outputs, translationErrors, err = p.Synthesize(ctx, req)
+		if err != nil {
+			return BabelResponse{}, http.StatusBadRequest, err
+		}
+	}
+
+	if err := uploadOutputs(ctx, p.WorkDir, outputs, p.destination); err != nil {
+		log.Printf("unable to store audio: %v", err)
+		return BabelResponse{}, http.StatusInternalServerError, errStorage
+	}
+	log.Printf("%d files written to %s", len(outputs), p.StoragePath)
+	p.applyStorageURIs(outputs)
+
+	// record the batch, so that its failed voices can be retried
+	id := batchID(outputs)
+	if id != "" {
+		manifest := previous
+		if manifest == nil {
+			manifest = newBatchManifest(req, outputs, translationErrors)
+		} else {
+			manifest.RetryOf, manifest.BatchID = manifest.BatchID, id
+			manifest.merge(outputs)
+		}
+		if err := p.storeManifest(ctx, manifest); err != nil {
+			log.Printf("unable to store the manifest of batch %s: %v", id, err)
+			return BabelResponse{}, http.StatusInternalServerError, errStorage
+		}
+	}
+
+	revisedOutput := []BabelOutput{}
+	for _, o := range outputs {
+		if o.Length > 0 {
+			revisedOutput = append(revisedOutput, o)
+		}
+	}
+
+	response := BabelResponse{BatchID: id}
+	response.AudioMetadata = revisedOutput
+	response.Timing = SummarizeTimings(revisedOutput)
+	response.Characters = SummarizeCharacters(revisedOutput, p.CostPerMillionChars)
+
+	status := http.StatusOK
+	if p.Mode != BestEffort {
+		response.Failures = CollectFailures(translationErrors, outputs)
+		succeeded := 0
+		for _, o := range revisedOutput {
+			if o.Error == "" {
+				succeeded++
+			}
+		}
+		status = synthesisStatus(p.Mode, succeeded, response.Failures)
+		if len(response.Failures) > 0 {
+			log.Printf("%d failure(s), responding %d (%s)", len(response.Failures), status, p.Mode)
+		}
+	}
+	return response, status, nil
+}
+
+// handleSynthesis generates audio with all Journey voices
+func (p *Pipeline) handleSynthesis(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "unable to process body", http.StatusInternalServerError)
+		return
+	}
+	if len(body) == 0 {
+		http.Error(w, "no content provided", http.StatusBadRequest)
+		return
+	}
+	log.Printf("%s", body)
+
+	var babelRequest BabelRequest
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&babelRequest)
+	if err != nil {
+		http.Error(w, "error decoding Fabulae Request", http.StatusInternalServerError)
+		return
+	}
+
+	log.Print("synthesizing... ")
+
+	response, status, err := p.run(r.Context(), babelRequest)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	//fmt.Fprintf(w, "%s", body)
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// handleListVoices lists all Journey voices
+func (p *Pipeline) handleListVoices(w http.ResponseWriter, r *http.Request) {
+	voiceMetadata := []VoiceMetadata{}
+	for _, v := range p.Voices {
+		voiceMetadata = append(voiceMetadata, VoiceMetadata{
+			Name:          v.GetName(),
+			Gender:        v.GetSsmlGender().String(),
+			LanguageCodes: v.GetLanguageCodes(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(voiceMetadata)
+	if err != nil {
+		log.Print(err)
+	}
+}
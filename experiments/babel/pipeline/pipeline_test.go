@@ -0,0 +1,413 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fakeTranslations are the prefixes fakeTranslator marks each language's
+// translation with; Japanese is not in Latin script, so it is romanized, and as
+// the rest of its translation is, it is also flagged as suspect
+var fakeTranslations = map[string]string{"de-DE": "[de-DE]", "fr-FR": "[fr-FR]", "ja-JP": "[日本語]"}
+
+// fakeTranslator stands in for Gemini: a translation is the language's prefix and
+// the statement, and the languages in fail return an error instead
+type fakeTranslator struct {
+	fail map[string]bool
+}
+
+func (f fakeTranslator) Generate(ctx context.Context, prompt string) (string, error) {
+	for language, prefix := range fakeTranslations {
+		if !strings.Contains(prompt, language) {
+			continue
+		}
+		if f.fail[language] {
+			return "", fmt.Errorf("fake gemini: %s is unavailable", language)
+		}
+		if strings.HasPrefix(prompt, "romanize") {
+			return "romanized " + language, nil
+		}
+		statement := prompt[strings.Index(prompt, `\"`)+2 : strings.LastIndex(prompt, `\"`)]
+		return prefix + " " + statement, nil
+	}
+	return "", fmt.Errorf("fake gemini: unexpected prompt %q", prompt)
+}
+
+// fakeTTS returns a short LINEAR16 WAV for every voice but the failing one
+type fakeTTS struct {
+	model string
+	fail  string
+}
+
+func (f fakeTTS) Synthesize(ctx context.Context, voice VoiceSpec, text string, _ SynthesisOptions) ([]byte, error) {
+	if voice.Name == f.fail {
+		return nil, errors.New("fake tts: voice unavailable")
+	}
+	pcm := make([]byte, 2*(40+len(text)))
+	for i := 0; i < len(pcm); i += 2 {
+		pcm[i+1] = byte(i % 64)
+	}
+	return pcmToWAV(pcm, 24000), nil
+}
+
+func (f fakeTTS) Model() string { return f.model }
+
+// memoryStore is an in-memory ObjectStore
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryStore) Put(ctx context.Context, objectName string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.objects[objectName]; exists {
+		return fmt.Errorf("object %s already exists", objectName)
+	}
+	m.objects[objectName] = data
+	return nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[objectName]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// failingStore rejects every object
+type failingStore struct{}
+
+func (failingStore) Put(ctx context.Context, objectName string, r io.Reader) error {
+	return errors.New("bucket not found")
+}
+
+func (failingStore) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return nil, errors.New("bucket not found")
+}
+
+// goldenRun is what a golden file records of a /babel call
+type goldenRun struct {
+	Status   int                `json:"status"`
+	Response BabelResponse      `json:"response"`
+	Objects  []goldenObjectInfo `json:"objects"`
+}
+
+type goldenObjectInfo struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+}
+
+// timestampPrefix is the generateSpeech batch ID that starts each file name
+var timestampPrefix = regexp.MustCompile(`\d{8}\.\d{6}\.\d{2}_[0-9a-f]{8}-`)
+
+// manifestName is the object name of a batch manifest, named by that batch ID
+var manifestName = regexp.MustCompile(`batches/\d{8}\.\d{6}\.\d{2}_[0-9a-f]{8}\.json$`)
+
+// normalizeRun removes what differs between runs: timestamps, timings and the
+// order goroutines finished in
+func normalizeRun(run *goldenRun) {
+	run.Response.Timing = nil
+	if run.Response.BatchID != "" {
+		run.Response.BatchID = "TIMESTAMP"
+	}
+	for i := range run.Response.AudioMetadata {
+		o := &run.Response.AudioMetadata[i]
+		o.AudioPath = timestampPrefix.ReplaceAllString(o.AudioPath, "TIMESTAMP-")
+		o.StageTimings = StageTimings{}
+	}
+	sort.Slice(run.Response.AudioMetadata, func(i, j int) bool {
+		return run.Response.AudioMetadata[i].AudioPath < run.Response.AudioMetadata[j].AudioPath
+	})
+	for i := range run.Response.Failures {
+		f := &run.Response.Failures[i]
+		f.Error = timestampPrefix.ReplaceAllString(f.Error, "TIMESTAMP-")
+	}
+	sort.SliceStable(run.Response.Failures, func(i, j int) bool {
+		a, b := run.Response.Failures[i], run.Response.Failures[j]
+		return a.LanguageCode+" "+a.VoiceName < b.LanguageCode+" "+b.VoiceName
+	})
+	for i := range run.Objects {
+		run.Objects[i].Name = timestampPrefix.ReplaceAllString(run.Objects[i].Name, "TIMESTAMP-")
+		run.Objects[i].Name = manifestName.ReplaceAllString(run.Objects[i].Name, "batches/TIMESTAMP.json")
+		if strings.HasSuffix(run.Objects[i].Name, ".json") {
+			// the manifest size varies with the timings it records
+			run.Objects[i].Bytes = 0
+		}
+	}
+	sort.Slice(run.Objects, func(i, j int) bool { return run.Objects[i].Name < run.Objects[j].Name })
+}
+
+func testVoices() []*texttospeechpb.Voice {
+	return []*texttospeechpb.Voice{
+		{Name: "de-DE-Chirp3-HD-Fenrir", LanguageCodes: []string{"de-DE"}, SsmlGender: texttospeechpb.SsmlVoiceGender_MALE},
+		{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCodes: []string{"fr-FR"}, SsmlGender: texttospeechpb.SsmlVoiceGender_FEMALE},
+		{Name: "ja-JP-Chirp3-HD-Kore", LanguageCodes: []string{"ja-JP"}, SsmlGender: texttospeechpb.SsmlVoiceGender_FEMALE},
+	}
+}
+
+func TestPipelineGolden(t *testing.T) {
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		mode            ErrorMode
+		failTranslation string
+		failVoice       string
+		request         string
+	}{
+		{name: "chirp", mode: BestEffort, request: `{"statement": "hello there"}`},
+		{name: "failing_voice_best_effort", mode: BestEffort, failVoice: "fr-FR-Chirp3-HD-Aoede", request: `{"statement": "hello there"}`},
+		{name: "failing_voice_strict", mode: Strict, failVoice: "fr-FR-Chirp3-HD-Aoede", request: `{"statement": "hello there"}`},
+		{name: "failing_translation_strict", mode: Strict, failTranslation: "ja-JP", request: `{"statement": "hello there"}`},
+		{name: "failing_translation_fail_fast", mode: FailFast, failTranslation: "ja-JP", request: `{"statement": "hello there"}`},
+		{name: "skip_suspect_strict", mode: Strict, request: `{"statement": "hello there", "skip_suspect": true}`},
+		{name: "mixed_backends", mode: BestEffort, request: `{"statement": "good morning", "romanize": true, "voices": [{"name": "de-DE-Chirp3-HD-Fenrir"}, {"name": "Puck", "backend": "gemini", "language_codes": ["fr-FR", "ja-JP"]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := &memoryStore{objects: map[string][]byte{}}
+			p := &Pipeline{
+				Translator: fakeTranslator{fail: map[string]bool{tt.failTranslation: true}},
+				Synthesizers: map[string]Synthesizer{
+					BackendChirp:  fakeTTS{fail: tt.failVoice},
+					BackendGemini: fakeTTS{model: "fake-tts"},
+				},
+				Store:       store,
+				StoragePath: "babel/clips",
+				WorkDir:     dir,
+				Voices:      testVoices(),
+				Mode:        tt.mode,
+
+				CostPerMillionChars: defaultCostPerMillionChars,
+			}
+
+			rec := httptest.NewRecorder()
+			p.handleSynthesis(rec, httptest.NewRequest(http.MethodPost, "/babel", strings.NewReader(tt.request)))
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			run := goldenRun{Status: rec.Code}
+			if err := json.Unmarshal(rec.Body.Bytes(), &run.Response); err != nil {
+				t.Fatalf("response is not a BabelResponse: %v\n%s", err, rec.Body)
+			}
+			if len(run.Response.AudioMetadata) > 0 && run.Response.Timing == nil {
+				t.Error("expected a timing summary")
+			}
+			for name, data := range store.objects {
+				if name == manifestObjectName("babel/clips", run.Response.BatchID) {
+					var manifest BatchManifest
+					if err := json.Unmarshal(data, &manifest); err != nil || manifest.BatchID != run.Response.BatchID {
+						t.Errorf("object %s is not the batch manifest: %v", name, err)
+					}
+					run.Objects = append(run.Objects, goldenObjectInfo{Name: name, Bytes: len(data)})
+					continue
+				}
+				if !bytes.HasPrefix(data, []byte("RIFF")) || !bytes.Equal(data[8:16], []byte("WAVEfmt ")) {
+					t.Errorf("object %s is not a WAV file", name)
+				}
+				run.Objects = append(run.Objects, goldenObjectInfo{Name: name, Bytes: len(data)})
+			}
+			for _, o := range run.Response.AudioMetadata {
+				data, ok := store.objects["babel/clips/"+o.AudioPath]
+				if !ok || len(data) != o.Length {
+					t.Errorf("%s: expected a stored object of %d bytes", o.AudioPath, o.Length)
+				}
+			}
+			if leftover, _ := os.ReadDir(dir); len(leftover) != 0 {
+				t.Errorf("expected the audio files to be removed after upload, found %d", len(leftover))
+			}
+
+			normalizeRun(&run)
+			got, err := json.MarshalIndent(run, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			golden := filepath.Join(goldenDir, tt.name+".json")
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -run TestPipelineGolden -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response does not match %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	newPipeline := func(store ObjectStore) *Pipeline {
+		return &Pipeline{
+			Translator:   fakeTranslator{},
+			Synthesizers: map[string]Synthesizer{BackendChirp: fakeTTS{}},
+			Store:        store,
+			StoragePath:  "babel",
+			WorkDir:      t.TempDir(),
+			Voices:       testVoices(),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		store    ObjectStore
+		request  string
+		status   int
+		wantBody string
+	}{
+		{name: "empty body", store: &memoryStore{}, request: "", status: http.StatusBadRequest, wantBody: "no content provided"},
+		{name: "invalid json", store: &memoryStore{}, request: "{", status: http.StatusInternalServerError, wantBody: "error decoding Fabulae Request"},
+		{name: "unknown backend", store: &memoryStore{}, request: `{"statement": "hi", "backend": "polly"}`, status: http.StatusBadRequest, wantBody: `unknown backend "polly"`},
+		{name: "unknown voice", store: &memoryStore{}, request: `{"statement": "hi", "voices": [{"name": "en-US-Chirp3-HD-Nobody"}]}`, status: http.StatusBadRequest, wantBody: `unknown Chirp voice "en-US-Chirp3-HD-Nobody"`},
+		{name: "unknown effects profile", store: &memoryStore{}, request: `{"statement": "hi", "effects_profile": "radio"}`, status: http.StatusBadRequest, wantBody: "telephony-class-application"},
+		{name: "storage failure", store: failingStore{}, request: `{"statement": "hi"}`, status: http.StatusInternalServerError, wantBody: "error writing to Storage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newPipeline(tt.store).handleSynthesis(rec, httptest.NewRequest(http.MethodPost, "/babel", strings.NewReader(tt.request)))
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d containing %q", rec.Code, rec.Body, tt.status, tt.wantBody)
+			}
+		})
+	}
+}
+
+// recordingTTS records the voices it is asked to synthesize
+type recordingTTS struct {
+	fakeTTS
+	mu     sync.Mutex
+	voiced map[string]string
+}
+
+func (r *recordingTTS) Synthesize(ctx context.Context, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error) {
+	r.mu.Lock()
+	r.voiced[voice.Name] = text
+	r.mu.Unlock()
+	return r.fakeTTS.Synthesize(ctx, voice, text, opts)
+}
+
+func TestFailedTranslationIsNotVoiced(t *testing.T) {
+	tts := &recordingTTS{voiced: map[string]string{}}
+	p := &Pipeline{
+		Translator:   fakeTranslator{fail: map[string]bool{"ja-JP": true}},
+		Synthesizers: map[string]Synthesizer{BackendChirp: tts},
+		WorkDir:      t.TempDir(),
+		Voices:       testVoices(),
+		Mode:         BestEffort,
+	}
+	outputs, translationErrors, err := p.Synthesize(context.Background(), BabelRequest{Statement: "hello there"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := tts.voiced["ja-JP-Chirp3-HD-Kore"]; ok {
+		t.Errorf("expected no audio for the failed ja-JP translation, voiced %q", text)
+	}
+	if len(tts.voiced) != 2 {
+		t.Errorf("expected the other two languages to be voiced, got %v", tts.voiced)
+	}
+	for _, o := range outputs {
+		if o.LanguageCode != "ja-JP" {
+			if o.TranslationFailed || o.Length == 0 {
+				t.Errorf("%s: expected a clip, got %+v", o.VoiceName, o)
+			}
+			continue
+		}
+		if !o.TranslationFailed || o.Length != 0 || o.AudioPath != "" || o.Text != "" || !strings.Contains(o.Error, "fake gemini: ja-JP is unavailable") {
+			t.Errorf("expected a failed output without audio or text, got %+v", o)
+		}
+	}
+	if failures := CollectFailures(translationErrors, outputs); len(failures) != 1 || failures[0].LanguageCode != "ja-JP" || failures[0].VoiceName != "" {
+		t.Errorf("expected the language failure alone, got %+v", failures)
+	}
+}
+
+func TestSynthesizeWritesToWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	p := &Pipeline{
+		Translator:   fakeTranslator{},
+		Synthesizers: map[string]Synthesizer{BackendChirp: fakeTTS{}},
+		WorkDir:      dir,
+		Voices:       testVoices(),
+	}
+	outputs, _, err := p.Synthesize(context.Background(), BabelRequest{Statement: "hello there"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) == 0 {
+		t.Fatal("expected clips")
+	}
+	for _, o := range outputs {
+		if filepath.Dir(o.AudioPath) != "." {
+			t.Errorf("%s: expected the audio path to be a bare file name", o.AudioPath)
+		}
+		if info, err := os.Stat(filepath.Join(dir, o.AudioPath)); err != nil || info.Size() != int64(o.Length) {
+			t.Errorf("%s: expected a clip of %d bytes in the work directory: %v", o.AudioPath, o.Length, err)
+		}
+	}
+}
+
+func TestHandleListVoices(t *testing.T) {
+	p := &Pipeline{Voices: testVoices()}
+	rec := httptest.NewRecorder()
+	p.handleListVoices(rec, httptest.NewRequest(http.MethodGet, "/voices", nil))
+	var voices []VoiceMetadata
+	if err := json.Unmarshal(rec.Body.Bytes(), &voices); err != nil {
+		t.Fatal(err)
+	}
+	if len(voices) != 3 || voices[1].Name != "fr-FR-Chirp3-HD-Aoede" || voices[1].Gender != "FEMALE" || voices[1].LanguageCodes[0] != "fr-FR" {
+		t.Errorf("unexpected voices %+v", voices)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+// LoadPreTranslated reads a {languageCode: text} JSON map of statements that
+// were already translated, e.g. by a human, for --pre-translated
+func LoadPreTranslated(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var texts map[string]string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return nil, fmt.Errorf("%s: expected a JSON object of language codes to text: %v", path, err)
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("%s: no statements", path)
+	}
+	return texts, nil
+}
+
+// preTranslatedSpecs selects the voices for pre-translated statements: the voices
+// of the request that speak one of the statements' languages
+// every language must have text and at least one voice, so no statement is
+// silently dropped
+func preTranslatedSpecs(req BabelRequest, chirpVoices []*texttospeechpb.Voice, available []string) ([]VoiceSpec, error) {
+	languages := make([]string, 0, len(req.PreTranslated))
+	for language := range req.PreTranslated {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		if strings.TrimSpace(req.PreTranslated[language]) == "" {
+			return nil, fmt.Errorf("pre-translated statement for %s is empty", language)
+		}
+		if !slices.Contains(available, language) {
+			return nil, fmt.Errorf("no voice for pre-translated language %s", language)
+		}
+	}
+
+	specs, err := resolveVoiceSpecs(req, chirpVoices, languages)
+	if err != nil {
+		return nil, err
+	}
+	specs = slices.DeleteFunc(specs, func(s VoiceSpec) bool {
+		_, ok := req.PreTranslated[s.LanguageCode]
+		return !ok
+	})
+	for _, language := range languages {
+		if !slices.ContainsFunc(specs, func(s VoiceSpec) bool { return s.LanguageCode == language }) {
+			return nil, fmt.Errorf("no voice selected for pre-translated language %s", language)
+		}
+	}
+	return specs, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingTranslator records the prompts it is sent, and romanizes anything
+type countingTranslator struct {
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (c *countingTranslator) Generate(ctx context.Context, prompt string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompts = append(c.prompts, prompt)
+	return "konnichiwa", nil
+}
+
+func TestPreTranslatedBypassesTranslation(t *testing.T) {
+	translator := &countingTranslator{}
+	p := &Pipeline{
+		Translator:   translator,
+		Synthesizers: map[string]Synthesizer{BackendChirp: fakeTTS{}},
+		WorkDir:      t.TempDir(),
+		Voices:       testVoices(),
+		Mode:         Strict,
+	}
+	req := BabelRequest{PreTranslated: map[string]string{
+		"fr-FR": "bonjour, écrit à la main",
+		"ja-JP": "こんにちは",
+	}}
+
+	outputs, translationErrors, err := p.Synthesize(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(translator.prompts) != 0 {
+		t.Errorf("expected no translation requests, got %q", translator.prompts)
+	}
+	if len(translationErrors) != 0 {
+		t.Errorf("unexpected translation errors %v", translationErrors)
+	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].LanguageCode < outputs[j].LanguageCode })
+	if len(outputs) != 2 {
+		t.Fatalf("expected one output for each pre-translated language, got %+v", outputs)
+	}
+	for _, o := range outputs {
+		if o.Text != req.PreTranslated[o.LanguageCode] || o.Error != "" || o.Length == 0 {
+			t.Errorf("expected %s to be voiced from its pre-translated text, got %+v", o.LanguageCode, o)
+		}
+		if o.TranslationMS != 0 {
+			t.Errorf("%s: expected no translation time, got %d ms", o.LanguageCode, o.TranslationMS)
+		}
+	}
+
+	// romanization still asks Gemini, for the non-Latin-script statement only
+	req.Romanize = true
+	outputs, _, err = p.Synthesize(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(translator.prompts) != 1 || !strings.HasPrefix(translator.prompts[0], "romanize") {
+		t.Errorf("expected a single romanization request, got %q", translator.prompts)
+	}
+	for _, o := range outputs {
+		if (o.LanguageCode == "ja-JP") != (o.Romanized == "konnichiwa") {
+			t.Errorf("unexpected romanization of %s: %q", o.LanguageCode, o.Romanized)
+		}
+	}
+}
+
+func TestPreTranslatedValidation(t *testing.T) {
+	p := &Pipeline{Translator: &countingTranslator{}, Voices: testVoices()}
+	tests := []struct {
+		name    string
+		req     BabelRequest
+		wantErr string
+	}{
+		{
+			name:    "language without a voice",
+			req:     BabelRequest{PreTranslated: map[string]string{"fr-FR": "bonjour", "sw-KE": "habari"}},
+			wantErr: "no voice for pre-translated language sw-KE",
+		},
+		{
+			name:    "empty statement",
+			req:     BabelRequest{PreTranslated: map[string]string{"fr-FR": " "}},
+			wantErr: "pre-translated statement for fr-FR is empty",
+		},
+		{
+			name: "language not covered by the selected voices",
+			req: BabelRequest{
+				PreTranslated: map[string]string{"de-DE": "hallo", "fr-FR": "bonjour"},
+				Voices:        []VoiceSelection{{Name: "de-DE-Chirp3-HD-Fenrir"}},
+			},
+			wantErr: "no voice selected for pre-translated language fr-FR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := p.Synthesize(context.Background(), tt.req)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadPreTranslated(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	texts, err := LoadPreTranslated(write("ok.json", `{"fr-FR": "bonjour", "ja-JP": "こんにちは"}`))
+	if err != nil || len(texts) != 2 || texts["ja-JP"] != "こんにちは" {
+		t.Errorf("unexpected statements %v, %v", texts, err)
+	}
+	for name, content := range map[string]string{"list.json": `["bonjour"]`, "empty.json": `{}`} {
+		if _, err := LoadPreTranslated(write(name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
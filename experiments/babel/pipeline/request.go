@@ -0,0 +1,131 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+// BabelOutput represents the metatdata for the translated audio generated
+type BabelOutput struct {
+	VoiceName    string `json:"voice_name"`
+	LanguageCode string `json:"language_code"`
+	Text         string `json:"text"`
+	AudioPath    string `json:"audio_path"`
+	Gender       string `json:"gender"`
+	Error        string `json:"-"`
+	Length       int    `json:"bytes"`
+	// Backend is the text-to-speech backend that voiced the clip
+	Backend string `json:"backend,omitempty"`
+	// Model is the speech model used, for backends that have one
+	Model string `json:"model,omitempty"`
+	// AppliedGainDB is the loudness normalization gain, when normalization was requested
+	AppliedGainDB *float64 `json:"applied_gain_db,omitempty"`
+	// Romanized is the Latin-script transliteration of Text, when romanization
+	// was requested and the translation is in another script
+	Romanized string `json:"romanized,omitempty"`
+	// CharCount is the number of characters voiced, which text-to-speech bills
+	// for, counting the text again when it was re-synthesized; 0 when synthesis failed
+	CharCount int `json:"char_count"`
+	// DurationSeconds is the length of the clip, and OriginalDurationSeconds the
+	// length of its first take; both are reported when max_duration_seconds is set
+	DurationSeconds         float64 `json:"duration_seconds,omitempty"`
+	OriginalDurationSeconds float64 `json:"original_duration_seconds,omitempty"`
+	// SpeakingRate is the rate the clip was voiced at, above 1 when it was
+	// re-synthesized faster to fit max_duration_seconds
+	SpeakingRate float64 `json:"speaking_rate,omitempty"`
+	// OverMaxDuration flags a clip that is still longer than max_duration_seconds
+	OverMaxDuration bool `json:"over_max_duration,omitempty"`
+	// TranslationSuspect flags a translation that was still empty, unchanged from
+	// the statement or in the wrong script after being asked for twice
+	TranslationSuspect bool `json:"translation_suspect,omitempty"`
+	// TranslationFailed flags a voice that was not synthesized because the
+	// translation into its language failed
+	TranslationFailed bool `json:"translation_failed,omitempty"`
+	// EffectsProfile is the effects profile the clip was voiced with; Gemini
+	// voices have none
+	EffectsProfile string `json:"effects_profile,omitempty"`
+	// StorageURI is where the audio file was stored, reported when --bucket-map
+	// routed it away from the default bucket and path
+	StorageURI string `json:"storage_uri,omitempty"`
+	// StageTimings reports translation_ms, synthesis_ms, upload_ms and total_ms
+	StageTimings
+}
+
+// BabelRequest represents the request to the service
+type BabelRequest struct {
+	// Statement is the primary statement to voice
+	Statement string `json:"statement"`
+	// Modifiers are the tone modifiers for Gemini voices
+	// these could be "happy", "sad", "angry", "professional", etc.
+	Modifiers []string `json:"modifiers"`
+	// Instructions is the voicing instruction for Gemini voices
+	// typically something like "say the following: "
+	Instructions string `json:"instructions"`
+	// VoiceName is for a single Gemini Voice generation
+	VoiceName string `json:"voiceName"`
+	// Backend is the text-to-speech backend, "chirp" (default) or "gemini"
+	Backend string `json:"backend"`
+	// Voices picks voices individually, each with its own backend, to mix
+	// Chirp and Gemini voices in one request
+	Voices []VoiceSelection `json:"voices,omitempty"`
+	// NormalizeLoudness brings every generated clip to the same RMS level
+	NormalizeLoudness bool `json:"normalize_loudness"`
+	// TargetRMSDBFS is the normalization target, -20 dBFS if not set
+	TargetRMSDBFS *float64 `json:"target_rms_dbfs,omitempty"`
+	// TruePeakCeilingDBFS limits the gain so peaks stay below it, -1 dBFS if not set
+	TruePeakCeilingDBFS *float64 `json:"true_peak_ceiling_dbfs,omitempty"`
+	// MaxDurationSeconds is the longest each clip should be; longer clips are
+	// voiced once more at a faster speaking rate, up to 1.5x
+	MaxDurationSeconds float64 `json:"max_duration_seconds,omitempty"`
+	// EffectsProfile makes the Chirp voices sound as if played on a class of
+	// device, e.g. "telephony-class-application"
+	EffectsProfile string `json:"effects_profile,omitempty"`
+	// EffectsProfileByLanguage overrides EffectsProfile for some language codes;
+	// an empty profile voices the language without one
+	EffectsProfileByLanguage map[string]string `json:"effects_profile_by_language,omitempty"`
+	// Romanize adds a romanized transcript to each non-Latin-script output
+	Romanize bool `json:"romanize"`
+	// PreTranslated maps language codes to statements that are already
+	// translated; they are voiced as they are, in those languages only,
+	// instead of translating Statement
+	PreTranslated map[string]string `json:"pre_translated,omitempty"`
+	// SourceLanguage is the language of Statement, "en" if not set; translations
+	// into it may come back unchanged without being suspect
+	SourceLanguage string `json:"source_language,omitempty"`
+	// SkipSuspect doesn't voice the languages whose translation is suspect, they
+	// are reported as failures instead
+	SkipSuspect bool `json:"skip_suspect"`
+	// RetryOf is the batch_id of a previous response whose failed voices, or
+	// the voices named in Voices, are voiced again with that batch's
+	// translations and settings; the other fields are ignored
+	RetryOf string `json:"retry_of,omitempty"`
+}
+
+// BabelResponse represents the response from the service
+type BabelResponse struct {
+	// BatchID identifies the batch for retry_of; it starts the file names of its clips
+	BatchID       string        `json:"batch_id,omitempty"`
+	AudioMetadata []BabelOutput `json:"audio_metadata"`
+	// Failures lists failed languages and voices, reported with --strict or --fail-fast
+	Failures []BabelFailure `json:"failures,omitempty"`
+	// Timing summarizes total_ms across audio_metadata
+	Timing *TimingSummary `json:"timing,omitempty"`
+	// Characters totals char_count across audio_metadata, with its estimated cost
+	Characters *CharacterSummary `json:"characters,omitempty"`
+}
+
+// VoiceMetadata is a minimal set of tts voice metadata
+type VoiceMetadata struct {
+	Name          string   `json:"name"`
+	Gender        string   `json:"gender"`
+	LanguageCodes []string `json:"language_codes"`
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// romanize asks Gemini for a Latin-script transliteration of each translation,
+// for reviewers who can't read the original script
+// translations already written in Latin script and languages that failed to
+// translate are skipped; a failed romanization is logged and left out, it
+// never fails the run
+func romanize(ctx context.Context, generate func(ctx context.Context, prompt string) (string, error), translations map[string]string, translationErrors map[string]error) map[string]string {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	romanized := make(map[string]string)
+
+	for language, text := range translations {
+		if _, failed := translationErrors[language]; failed || isLatinScript(text) {
+			continue
+		}
+		wg.Add(1)
+		go func(language, text string) {
+			defer wg.Done()
+			prompt := fmt.Sprintf(`
+romanize this %s text into the Latin alphabet using its standard romanization scheme \"%s\" output only the romanization, do not translate or explain.
+romanization: `, language, text)
+			prompt = strings.ReplaceAll(prompt, "\n", "")
+			result, err := generate(ctx, prompt)
+			if err != nil {
+				log.Printf("couldn't romanize %s: %v", language, err)
+				return
+			}
+			mu.Lock()
+			romanized[language] = strings.TrimSpace(result)
+			mu.Unlock()
+		}(language, text)
+	}
+	wg.Wait()
+
+	return romanized
+}
+
+// isLatinScript reports whether every letter in text is a Latin letter
+func isLatinScript(text string) bool {
+	for _, r := range text {
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRomanizations records each output's romanized text, by language
+func applyRomanizations(outputs []BabelOutput, romanized map[string]string) {
+	for i := range outputs {
+		outputs[i].Romanized = romanized[outputs[i].LanguageCode]
+	}
+}
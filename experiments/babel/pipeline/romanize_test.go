@@ -0,0 +1,110 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRomanize(t *testing.T) {
+	translated := map[string]string{
+		"ja-JP": "こんにちは",
+		"hi-IN": "नमस्ते",
+		"fr-FR": "bonjour à tous",
+		"ko-KR": "안녕하세요",
+	}
+	romanizations := map[string]string{
+		"ja-JP": "konnichiwa",
+		"hi-IN": "namaste",
+	}
+	var mu sync.Mutex
+	var romanizePrompts []string
+	generate := func(ctx context.Context, prompt string) (string, error) {
+		for language, text := range translated {
+			if !strings.Contains(prompt, language) {
+				continue
+			}
+			if strings.HasPrefix(prompt, "translate") {
+				if language == "ko-KR" {
+					return "", errors.New("quota exceeded")
+				}
+				return text, nil
+			}
+			mu.Lock()
+			romanizePrompts = append(romanizePrompts, prompt)
+			mu.Unlock()
+			if !strings.Contains(prompt, text) {
+				t.Errorf("romanization prompt for %s does not include its translation: %s", language, prompt)
+			}
+			if r, ok := romanizations[language]; ok {
+				return " " + r + "\n", nil
+			}
+			return "", errors.New("unexpected romanization of " + language)
+		}
+		return "", errors.New("unexpected prompt: " + prompt)
+	}
+
+	languages := []string{"ja-JP", "hi-IN", "fr-FR", "ko-KR"}
+	translations, _, translationErrors, _ := translate(context.Background(), generate, "hello everyone", defaultSourceLanguage, languages, BestEffort)
+	if len(translationErrors) != 1 || translationErrors["ko-KR"] == nil {
+		t.Fatalf("expected only ko-KR to fail to translate, got %v", translationErrors)
+	}
+
+	romanized := romanize(context.Background(), generate, translations, translationErrors)
+	if len(romanizePrompts) != 2 {
+		t.Errorf("expected a romanization request for ja-JP and hi-IN only, got %q", romanizePrompts)
+	}
+	if len(romanized) != 2 || romanized["ja-JP"] != "konnichiwa" || romanized["hi-IN"] != "namaste" {
+		t.Errorf("unexpected romanizations %v", romanized)
+	}
+
+	outputs := []BabelOutput{
+		{LanguageCode: "ja-JP", Text: translations["ja-JP"]},
+		{LanguageCode: "fr-FR", Text: translations["fr-FR"]},
+	}
+	applyRomanizations(outputs, romanized)
+	if outputs[0].Romanized != "konnichiwa" || outputs[1].Romanized != "" {
+		t.Errorf("unexpected outputs %+v", outputs)
+	}
+}
+
+func TestRomanizeFailureLeavesOutputUnromanized(t *testing.T) {
+	generate := func(ctx context.Context, prompt string) (string, error) {
+		return "", errors.New("unavailable")
+	}
+	romanized := romanize(context.Background(), generate, map[string]string{"ja-JP": "こんにちは"}, nil)
+	if len(romanized) != 0 {
+		t.Errorf("expected no romanization when generation fails, got %v", romanized)
+	}
+}
+
+func TestIsLatinScript(t *testing.T) {
+	for text, want := range map[string]bool{
+		"Grüß Gott!":          true,
+		"bonjour, ça va? 123": true,
+		"":                    true,
+		"こんにちは":               false,
+		"Привет":              false,
+		"hola مرحبا":          false,
+	} {
+		if got := isLatinScript(text); got != want {
+			t.Errorf("isLatinScript(%q) = %t, want %t", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TimeFormat is the timestamp that starts the file names of the clips
+const TimeFormat = "20060102.030405.06"
+
+// newBatchID returns the ID of a batch started at now, which starts the file
+// names of its clips: the timestamp and a random suffix, so that batches
+// started in the same second neither share an ID nor overwrite each other's
+// clips and manifests
+func newBatchID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return now.Format(TimeFormat) + "_" + hex.EncodeToString(suffix)
+}
+
+// create audio output for each voice given the statement per language
+// each voice is synthesized by the synthesizer for its backend
+// when loudness is not nil, each clip is normalized before it is written
+// otherwise, with no maximum duration either, clips from a StreamingSynthesizer
+// are written to their files as they are synthesized
+// in fail-fast mode the first failed voice cancels the remaining synthesis
+// if ctx is cancelled, generateSpeech returns straight away with the clips
+// finished so far, and the voices still pending fail with the context error
+// the clips are written to workDir, the working directory when it is empty
+func generateSpeech(ctx context.Context, workDir string, specs []VoiceSpec, translations map[string]string, synthesizers map[string]Synthesizer, opts SynthesisOptions, loudness *LoudnessOptions, mode ErrorMode) []BabelOutput {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	//results := []string{}
+	results := []BabelOutput{}
+	resultChan := make(chan BabelOutput, len(specs))
+
+	batch := newBatchID(time.Now())
+
+	for _, voice := range specs {
+		wg.Add(1)
+		text := translations[voice.LanguageCode]
+		//log.Printf("%s %s %s: %s", voice.Name, voice.LanguageCode, voice.Gender, text)
+
+		go func(voice VoiceSpec, text, batch string) {
+			defer wg.Done()
+			outputmetadata := BabelOutput{
+				VoiceName:    voice.Name,
+				LanguageCode: voice.LanguageCode,
+				Text:         text,
+				Gender:       voice.Gender,
+				Backend:      voice.Backend,
+			}
+			filename := speechFileName(batch, voice)
+			var audiobytes []byte
+			var err error
+			// length is the size of the clip; streamed clips are already in
+			// their file
+			length := 0
+			streamed := false
+			retakes := 0
+			if synthesizer, ok := synthesizers[voice.Backend]; ok {
+				outputmetadata.Model = synthesizer.Model()
+				if voice.Backend == BackendChirp {
+					outputmetadata.EffectsProfile = opts.effectsProfileFor(voice.LanguageCode)
+				}
+				select {
+				case <-ctx.Done():
+					err = ctx.Err()
+				default:
+					start := time.Now()
+					if stream, ok := synthesizer.(StreamingSynthesizer); ok && loudness == nil && opts.MaxDurationSeconds == 0 {
+						// nothing is done to the clip after synthesis, so it goes
+						// straight to its file as it is produced
+						var written int64
+						written, err = synthesizeToFile(ctx, stream, filepath.Join(workDir, filename), voice, text, opts)
+						length, streamed = int(written), true
+					} else {
+						audiobytes, err = synthesizer.Synthesize(ctx, voice, text, opts)
+						if err == nil && len(audiobytes) > 0 && opts.MaxDurationSeconds > 0 {
+							audiobytes, retakes = fitDuration(ctx, synthesizer, voice, text, opts, audiobytes, &outputmetadata)
+						}
+						length = len(audiobytes)
+					}
+					outputmetadata.SynthesisMS = time.Since(start).Milliseconds()
+					outputmetadata.updateTotal()
+				}
+			} else {
+				err = fmt.Errorf("%s backend is not available", voice.Backend)
+			}
+			outputmetadata.AudioPath = filename
+			outputmetadata.Length = length
+			if err != nil {
+				outputmetadata.Error = fmt.Sprintf("error goroutine: text %s; voice: %s: %v", text, voice.Name, err)
+				//resultChan <- fmt.Sprintf("error goroutine: text %s; voice: %s", text, voice.GetName())
+			} else if length == 0 {
+				//log.Printf("%s is zero bytes", filename)
+				outputmetadata.Error = fmt.Sprintf("%s voice generated 0 bytes", voice.Name)
+			} else {
+				outputmetadata.CharCount = ttsCharCount(text) * (1 + retakes)
+				if loudness != nil {
+					normalized, result, normErr := normalizeLoudness(audiobytes, *loudness)
+					if normErr != nil {
+						log.Printf("unable to normalize loudness for %s, keeping original: %v", voice.Name, normErr)
+					} else {
+						audiobytes = normalized
+						gain := result.GainDB
+						outputmetadata.AppliedGainDB = &gain
+						outputmetadata.Length = len(audiobytes)
+					}
+				}
+				if !streamed {
+					err = os.WriteFile(filepath.Join(workDir, filename), audiobytes, 0644)
+				}
+				if err != nil {
+					//resultChan <- fmt.Sprintf("unable to write to %s: %v", filename, err)
+					outputmetadata.Error = fmt.Sprintf("unable to write to %s: %v", filename, err)
+				}
+			}
+			/* log.Printf(" %s Audio content (%7d bytes) written to file: %v",
+				voice.Name,
+				len(audiobytes),
+				filename,
+			) */
+			//resultChan <- filename
+			if outputmetadata.Error != "" && mode == FailFast {
+				cancel()
+			}
+			resultChan <- outputmetadata
+		}(voice, text, batch)
+
+	}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	// as in translate, a fail-fast cancellation still collects every voice
+	received := make(map[VoiceSpec]bool)
+collect:
+	for {
+		select {
+		case r, ok := <-resultChan:
+			if !ok {
+				break collect
+			}
+			results = append(results, r)
+			received[VoiceSpec{Backend: r.Backend, Name: r.VoiceName, LanguageCode: r.LanguageCode, Gender: r.Gender}] = true
+		case <-parent.Done():
+			log.Printf("speech generation cancelled with %d of %d voices done: %v", len(results), len(specs), parent.Err())
+			for _, voice := range specs {
+				if received[voice] {
+					continue
+				}
+				results = append(results, BabelOutput{
+					VoiceName:    voice.Name,
+					LanguageCode: voice.LanguageCode,
+					Text:         translations[voice.LanguageCode],
+					Gender:       voice.Gender,
+					Backend:      voice.Backend,
+					AudioPath:    speechFileName(batch, voice),
+					Error:        fmt.Sprintf("error goroutine: text %s; voice: %s: %v", translations[voice.LanguageCode], voice.Name, parent.Err()),
+				})
+			}
+			break collect
+		}
+	}
+
+	return results
+}
+
+// speechFileName is the name of the clip of a voice in the batch
+func speechFileName(batch string, voice VoiceSpec) string {
+	return fmt.Sprintf("%s-%s-%s-%s.wav", batch, voice.Name, voice.LanguageCode, voice.Gender)
+}
+
+// synthesizeToFile streams a clip into its file, which is removed again when
+// synthesis fails or produces nothing
+func synthesizeToFile(ctx context.Context, s StreamingSynthesizer, audiofile string, voice VoiceSpec, text string, opts SynthesisOptions) (int64, error) {
+	f, err := os.Create(audiofile)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.SynthesizeTo(ctx, f, voice, text, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || n == 0 {
+		os.Remove(audiofile)
+	}
+	return n, err
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsObjectStore writes objects to a Cloud Storage bucket, never replacing one
+type gcsObjectStore struct {
+	client *storage.Client
+	bucket string
+}
+
+// NewGCSObjectStore stores objects in the bucket with client
+func NewGCSObjectStore(client *storage.Client, bucket string) ObjectStore {
+	return gcsObjectStore{client: client, bucket: bucket}
+}
+
+// Put writes the contents of r to the object
+func (s gcsObjectStore) Put(ctx context.Context, objectName string, r io.Reader) error {
+	o := s.client.Bucket(s.bucket).Object(objectName)
+
+	o = o.If(storage.Conditions{DoesNotExist: true})
+
+	wc := o.NewWriter(ctx)
+	if _, err := io.Copy(wc, r); err != nil {
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %w", err)
+	}
+	return nil
+}
+
+// Get opens the object for reading
+func (s gcsObjectStore) Get(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	r, err := s.client.Bucket(s.bucket).Object(objectName).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, errObjectNotFound
+	}
+	return r, err
+}
+
+// objectUploader writes the contents of r to an object in the audio bucket
+type objectUploader func(ctx context.Context, objectName string, r io.Reader) error
+
+// uploadDestination returns where the audio files of a language are uploaded:
+// the path of their object names and the uploader of their bucket
+type uploadDestination func(languageCode string) (storagePath string, upload objectUploader)
+
+// uploadOutputs uploads each output's audio file under the storage path of its
+// language and then removes it locally; missing or unreadable files are skipped
+// the audio files are read from workDir, the working directory when it is empty
+func uploadOutputs(ctx context.Context, workDir string, outputs []BabelOutput, destination uploadDestination) error {
+	for i := range outputs {
+		if outputs[i].AudioPath == "" {
+			// voices skipped before synthesis have no audio file
+			continue
+		}
+		audiofile := filepath.Join(workDir, outputs[i].AudioPath)
+		storagePath, upload := destination(outputs[i].LanguageCode)
+		objectName := path.Join(storagePath, outputs[i].AudioPath)
+		// Check if the file exists locally
+		if _, err := os.Stat(audiofile); os.IsNotExist(err) {
+			log.Printf("file %s does not exist, skipping", audiofile)
+			continue // Skip to the next file
+		}
+
+		f, err := os.Open(audiofile)
+		if err != nil {
+			log.Printf("unable to open file %s: %v", audiofile, err)
+			//return err
+			continue
+		}
+
+		start := time.Now()
+		err = upload(ctx, objectName, f)
+		outputs[i].UploadMS = time.Since(start).Milliseconds()
+		outputs[i].updateTotal()
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(audiofile)
+		if err != nil {
+			return fmt.Errorf("os.Remove: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,619 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	texttospeech "cloud.google.com/go/texttospeech/apiv1"
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/googleapis/gax-go/v2"
+	genai "google.golang.org/genai"
+)
+
+const (
+	// BackendChirp synthesizes with Cloud Text-to-Speech Chirp 3 HD voices
+	BackendChirp = "chirp"
+	// BackendGemini synthesizes with Gemini-TTS speech generation
+	BackendGemini = "gemini"
+
+	// defaultGeminiTTSModel is the speech generation model used by the Gemini backend
+	defaultGeminiTTSModel = "gemini-2.5-flash-preview-tts"
+	// defaultGeminiVoice is used when a Gemini request does not name a voice
+	defaultGeminiVoice = "Kore"
+	// geminiMaxTextBytes and geminiMaxPromptBytes are the Gemini-TTS limits on
+	// the text to speak and on the style prompt
+	geminiMaxTextBytes   = 4000
+	geminiMaxPromptBytes = 4000
+	// geminiDefaultSampleRate is the rate of Gemini's raw PCM output when the
+	// mime type does not say
+	geminiDefaultSampleRate = 24000
+	// chirpStreamSampleRate is the rate asked of streaming Chirp synthesis,
+	// which returns raw PCM with no WAV header
+	chirpStreamSampleRate = 24000
+)
+
+// VoiceSpec is a voice to synthesize one language with, on a given backend
+type VoiceSpec struct {
+	Backend      string
+	Name         string
+	LanguageCode string
+	Gender       string
+}
+
+// SynthesisOptions are the per-request style settings; only Gemini voices use
+// Modifiers and Instructions
+type SynthesisOptions struct {
+	Modifiers    []string
+	Instructions string
+	// SpeakingRate speeds up (above 1) or slows down speech; 0 is the voice's natural rate
+	SpeakingRate float64
+	// MaxDurationSeconds is the longest a clip should be, 0 for no limit
+	MaxDurationSeconds float64
+	// EffectsProfile is the Cloud Text-to-Speech effects profile of Chirp voices,
+	// and EffectsProfileByLanguage overrides it for some languages
+	EffectsProfile           string
+	EffectsProfileByLanguage map[string]string
+}
+
+// Synthesizer turns text into LINEAR16 WAV audio with a voice
+type Synthesizer interface {
+	Synthesize(ctx context.Context, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error)
+	// Model is the model used, reported in BabelOutput; empty when the backend has none
+	Model() string
+}
+
+// StreamingSynthesizer is a Synthesizer that writes the audio as it is
+// produced, so a clip needs no buffer of its own
+type StreamingSynthesizer interface {
+	Synthesizer
+	// SynthesizeTo writes LINEAR16 WAV audio to w and returns the bytes written
+	SynthesizeTo(ctx context.Context, w io.Writer, voice VoiceSpec, text string, opts SynthesisOptions) (int64, error)
+}
+
+// synthesizeBytes buffers the audio of a streaming synthesizer, for the callers
+// that need the whole clip
+func synthesizeBytes(ctx context.Context, s StreamingSynthesizer, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := s.SynthesizeTo(ctx, &buf, voice, text, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// VoiceSelection picks a voice, and its backend, when mixing backends in one request
+type VoiceSelection struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend,omitempty"`
+	// LanguageCodes limits the voice to these languages; Gemini voices default to all
+	LanguageCodes []string `json:"language_codes,omitempty"`
+}
+
+// chirpVoiceSpecs describes the Chirp voices, each in its own language
+func chirpVoiceSpecs(chirpVoices []*texttospeechpb.Voice) []VoiceSpec {
+	specs := make([]VoiceSpec, 0, len(chirpVoices))
+	for _, v := range chirpVoices {
+		specs = append(specs, VoiceSpec{
+			Backend:      BackendChirp,
+			Name:         v.GetName(),
+			LanguageCode: v.GetLanguageCodes()[0],
+			Gender:       v.GetSsmlGender().String(),
+		})
+	}
+	return specs
+}
+
+// getGeminiVoicesMetadata lists the Gemini voices with their genders
+func getGeminiVoicesMetadata() []VoiceMetadata {
+	geminiVoicesMetadata := []VoiceMetadata{}
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Zephyr",
+		Gender: "Female",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Puck",
+		Gender: "Male",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Charon",
+		Gender: "Male",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Kore",
+		Gender: "Female",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Leda",
+		Gender: "Female",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Fenrir",
+		Gender: "Male",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Orus",
+		Gender: "Male",
+	})
+	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
+		Name:   "Aoede",
+		Gender: "Female",
+	})
+	return geminiVoicesMetadata
+}
+
+// geminiVoiceSpecs describes a Gemini voice speaking each of the languages
+func geminiVoiceSpecs(name string, languages []string) []VoiceSpec {
+	gender := ""
+	for _, v := range getGeminiVoicesMetadata() {
+		if strings.EqualFold(v.Name, name) {
+			gender = strings.ToUpper(v.Gender)
+		}
+	}
+	specs := make([]VoiceSpec, 0, len(languages))
+	for _, language := range languages {
+		specs = append(specs, VoiceSpec{Backend: BackendGemini, Name: name, LanguageCode: language, Gender: gender})
+	}
+	return specs
+}
+
+// resolveVoiceSpecs selects the voices for a request: explicit per-voice selections
+// when given, otherwise every Chirp voice, or a single Gemini voice in every language
+func resolveVoiceSpecs(req BabelRequest, chirpVoices []*texttospeechpb.Voice, languages []string) ([]VoiceSpec, error) {
+	defaultBackend := strings.ToLower(strings.TrimSpace(req.Backend))
+	if defaultBackend == "" {
+		defaultBackend = BackendChirp
+	}
+	if defaultBackend != BackendChirp && defaultBackend != BackendGemini {
+		return nil, fmt.Errorf("unknown backend %q, use %q or %q", req.Backend, BackendChirp, BackendGemini)
+	}
+
+	if len(req.Voices) == 0 {
+		if defaultBackend == BackendGemini {
+			name := req.VoiceName
+			if name == "" {
+				name = defaultGeminiVoice
+			}
+			return geminiVoiceSpecs(name, languages), nil
+		}
+		return chirpVoiceSpecs(chirpVoices), nil
+	}
+
+	var specs []VoiceSpec
+	for _, selection := range req.Voices {
+		backend := strings.ToLower(strings.TrimSpace(selection.Backend))
+		if backend == "" {
+			backend = defaultBackend
+		}
+		for _, language := range selection.LanguageCodes {
+			if !slices.Contains(languages, language) {
+				return nil, fmt.Errorf("voice %s: language %s is not available", selection.Name, language)
+			}
+		}
+		switch backend {
+		case BackendChirp:
+			i := slices.IndexFunc(chirpVoices, func(v *texttospeechpb.Voice) bool { return v.GetName() == selection.Name })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown Chirp voice %q", selection.Name)
+			}
+			specs = append(specs, chirpVoiceSpecs(chirpVoices[i:i+1])...)
+		case BackendGemini:
+			if selection.Name == "" {
+				return nil, fmt.Errorf("a Gemini voice selection needs a name")
+			}
+			selected := languages
+			if len(selection.LanguageCodes) > 0 {
+				selected = selection.LanguageCodes
+			}
+			specs = append(specs, geminiVoiceSpecs(selection.Name, selected)...)
+		default:
+			return nil, fmt.Errorf("voice %s: unknown backend %q, use %q or %q", selection.Name, selection.Backend, BackendChirp, BackendGemini)
+		}
+	}
+	return specs, nil
+}
+
+// NewSynthesizers returns the available backends; Gemini is left out, with a log
+// message, if its client cannot be created
+func NewSynthesizers(ctx context.Context, projectID, location, apiEndpoint string) map[string]Synthesizer {
+	synthesizers := map[string]Synthesizer{BackendChirp: chirpSynthesizer{}}
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		Project:  projectID,
+		Location: location,
+		Backend:  genai.BackendVertexAI,
+		HTTPOptions: genai.HTTPOptions{
+			BaseURL: apiEndpoint,
+		},
+	})
+	if err != nil {
+		log.Printf("gemini backend unavailable: %v", err)
+		return synthesizers
+	}
+	synthesizers[BackendGemini] = &geminiSynthesizer{models: client.Models, model: defaultGeminiTTSModel}
+	return synthesizers
+}
+
+// speechSynthesizer is the part of the Text-to-Speech client used by the Chirp backend
+type speechSynthesizer interface {
+	SynthesizeSpeech(ctx context.Context, req *texttospeechpb.SynthesizeSpeechRequest, opts ...gax.CallOption) (*texttospeechpb.SynthesizeSpeechResponse, error)
+}
+
+// chirpSynthesizer calls Cloud Text-to-Speech, with a client of its own for each
+// clip unless client is set
+type chirpSynthesizer struct {
+	client speechSynthesizer
+}
+
+func (chirpSynthesizer) Model() string { return "" }
+
+// speechStreamer is the streaming part of the Text-to-Speech client
+type speechStreamer interface {
+	StreamingSynthesize(ctx context.Context, opts ...gax.CallOption) (texttospeechpb.TextToSpeech_StreamingSynthesizeClient, error)
+}
+
+// Synthesize returns LINEAR16 audio for the text with a Chirp voice
+func (c chirpSynthesizer) Synthesize(ctx context.Context, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error) {
+	return synthesizeBytes(ctx, c, voice, text, opts)
+}
+
+// SynthesizeTo writes LINEAR16 audio for the text with a Chirp voice to w; the
+// audio is streamed as it is synthesized unless the clip has an effects
+// profile, which only the unary API applies
+func (c chirpSynthesizer) SynthesizeTo(ctx context.Context, w io.Writer, voice VoiceSpec, text string, opts SynthesisOptions) (int64, error) {
+	client := c.client
+	if client == nil {
+		ttsClient, err := texttospeech.NewClient(ctx)
+		if err != nil {
+			return 0, err
+		}
+		defer ttsClient.Close()
+		client = ttsClient
+	}
+
+	if streamer, ok := client.(speechStreamer); ok && opts.effectsProfileFor(voice.LanguageCode) == "" {
+		return streamChirpSpeech(ctx, streamer, w, voice, text, opts)
+	}
+	resp, err := client.SynthesizeSpeech(ctx, buildChirpSpeechRequest(voice, text, opts))
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(resp.AudioContent)
+	return int64(n), err
+}
+
+// streamChirpSpeech sends the text to the streaming Text-to-Speech API and
+// writes each chunk of PCM to w as it arrives
+func streamChirpSpeech(ctx context.Context, streamer speechStreamer, w io.Writer, voice VoiceSpec, text string, opts SynthesisOptions) (int64, error) {
+	stream, err := streamer.StreamingSynthesize(ctx)
+	if err != nil {
+		return 0, err
+	}
+	requests := []*texttospeechpb.StreamingSynthesizeRequest{
+		{StreamingRequest: &texttospeechpb.StreamingSynthesizeRequest_StreamingConfig{
+			StreamingConfig: &texttospeechpb.StreamingSynthesizeConfig{
+				Voice: &texttospeechpb.VoiceSelectionParams{
+					LanguageCode: voice.LanguageCode,
+					Name:         voice.Name,
+				},
+				StreamingAudioConfig: &texttospeechpb.StreamingAudioConfig{
+					AudioEncoding:   texttospeechpb.AudioEncoding_PCM,
+					SampleRateHertz: chirpStreamSampleRate,
+					SpeakingRate:    opts.SpeakingRate,
+				},
+			},
+		}},
+		{StreamingRequest: &texttospeechpb.StreamingSynthesizeRequest_Input{
+			Input: &texttospeechpb.StreamingSynthesisInput{
+				InputSource: &texttospeechpb.StreamingSynthesisInput_Text{Text: text},
+			},
+		}},
+	}
+	for _, req := range requests {
+		if err := stream.Send(req); err != nil {
+			return 0, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return 0, err
+	}
+
+	wav := newWAVWriter(w, chirpStreamSampleRate)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return wav.finish()
+		}
+		if err != nil {
+			return wav.n, err
+		}
+		if _, err := wav.Write(resp.GetAudioContent()); err != nil {
+			return wav.n, err
+		}
+	}
+}
+
+// buildChirpSpeechRequest returns the Text-to-Speech request for a clip, with
+// the effects profile of the voice's language
+func buildChirpSpeechRequest(voice VoiceSpec, text string, opts SynthesisOptions) *texttospeechpb.SynthesizeSpeechRequest {
+	config := &texttospeechpb.AudioConfig{
+		AudioEncoding: texttospeechpb.AudioEncoding_LINEAR16,
+		SpeakingRate:  opts.SpeakingRate,
+	}
+	if profile := opts.effectsProfileFor(voice.LanguageCode); profile != "" {
+		config.EffectsProfileId = []string{profile}
+	}
+	return &texttospeechpb.SynthesizeSpeechRequest{
+		Input: &texttospeechpb.SynthesisInput{
+			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
+		},
+		Voice: &texttospeechpb.VoiceSelectionParams{
+			LanguageCode: voice.LanguageCode,
+			Name:         voice.Name,
+		},
+		AudioConfig: config,
+	}
+}
+
+// speechGenerator is the part of the genai client used for speech generation
+type speechGenerator interface {
+	GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error]
+}
+
+// geminiSynthesizer generates speech with a Gemini-TTS model
+type geminiSynthesizer struct {
+	models speechGenerator
+	model  string
+}
+
+func (g *geminiSynthesizer) Model() string { return g.model }
+
+// Synthesize returns the generated speech as a WAV file
+func (g *geminiSynthesizer) Synthesize(ctx context.Context, voice VoiceSpec, text string, opts SynthesisOptions) ([]byte, error) {
+	return synthesizeBytes(ctx, g, voice, text, opts)
+}
+
+// SynthesizeTo writes the generated speech to w as a WAV file, each chunk of
+// PCM as it is generated
+func (g *geminiSynthesizer) SynthesizeTo(ctx context.Context, w io.Writer, voice VoiceSpec, text string, opts SynthesisOptions) (int64, error) {
+	contents, config, err := buildGeminiSpeechRequest(voice, text, opts)
+	if err != nil {
+		return 0, err
+	}
+	wav := newWAVWriter(w, 0)
+	var finishReason genai.FinishReason
+	for resp, err := range g.models.GenerateContentStream(ctx, g.model, contents, config) {
+		if err != nil {
+			return wav.n, err
+		}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		finishReason = resp.Candidates[0].FinishReason
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if part.InlineData == nil || len(part.InlineData.Data) == 0 {
+				continue
+			}
+			if wav.sampleRate == 0 {
+				wav.sampleRate = sampleRateFromMimeType(part.InlineData.MIMEType)
+			}
+			if _, err := wav.Write(part.InlineData.Data); err != nil {
+				return wav.n, err
+			}
+		}
+	}
+	if wav.n == 0 {
+		if finishReason == "" {
+			return 0, fmt.Errorf("no audio returned by %s", g.model)
+		}
+		return 0, fmt.Errorf("no audio returned by %s (finish reason %s)", g.model, finishReason)
+	}
+	return wav.finish()
+}
+
+// geminiStylePrompt builds the voicing instruction from the request's
+// instructions and tone modifiers; Gemini-TTS has no speaking rate setting, so a
+// rate is asked for in the prompt
+func geminiStylePrompt(opts SynthesisOptions) string {
+	var style []string
+	if instructions := strings.TrimSpace(opts.Instructions); instructions != "" {
+		style = append(style, instructions)
+	}
+	var modifiers []string
+	for _, m := range opts.Modifiers {
+		if m = strings.TrimSpace(m); m != "" {
+			modifiers = append(modifiers, m)
+		}
+	}
+	if len(modifiers) > 0 {
+		style = append(style, fmt.Sprintf("Use a %s tone.", strings.Join(modifiers, ", ")))
+	}
+	if opts.SpeakingRate > 0 && opts.SpeakingRate != 1 {
+		style = append(style, fmt.Sprintf("Speak at %g times your natural pace.", opts.SpeakingRate))
+	}
+	return strings.Join(style, " ")
+}
+
+// buildGeminiSpeechRequest returns the contents and config for a Gemini-TTS
+// call, rejecting text or style prompts over the Gemini-TTS limits
+func buildGeminiSpeechRequest(voice VoiceSpec, text string, opts SynthesisOptions) ([]*genai.Content, *genai.GenerateContentConfig, error) {
+	if len(text) > geminiMaxTextBytes {
+		return nil, nil, fmt.Errorf("text is %d bytes, over the %d byte Gemini-TTS limit", len(text), geminiMaxTextBytes)
+	}
+	style := geminiStylePrompt(opts)
+	if len(style) > geminiMaxPromptBytes {
+		return nil, nil, fmt.Errorf("style prompt is %d bytes, over the %d byte Gemini-TTS limit", len(style), geminiMaxPromptBytes)
+	}
+	prompt := text
+	if style != "" {
+		prompt = style + "\n" + text
+	}
+	config := &genai.GenerateContentConfig{
+		ResponseModalities: []string{"AUDIO"},
+		SpeechConfig: &genai.SpeechConfig{
+			LanguageCode: voice.LanguageCode,
+			VoiceConfig: &genai.VoiceConfig{
+				PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{VoiceName: voice.Name},
+			},
+		},
+	}
+	return genai.Text(prompt), config, nil
+}
+
+// sampleRateFromMimeType reads the rate from a mime type such as
+// "audio/L16;codec=pcm;rate=24000"
+func sampleRateFromMimeType(mimeType string) int {
+	for _, part := range strings.Split(mimeType, ";") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(part), "rate="); ok {
+			if rate, err := strconv.Atoi(value); err == nil && rate > 0 {
+				return rate
+			}
+		}
+	}
+	return geminiDefaultSampleRate
+}
+
+// pcmToWAV wraps mono 16-bit PCM in a WAV header
+func pcmToWAV(pcm []byte, sampleRate int) []byte {
+	return append(wavHeader(sampleRate, int64(len(pcm))), pcm...)
+}
+
+// wavHeader is the 44 byte header of mono 16-bit PCM; a negative length
+// gives the maximum sizes used when streaming audio of unknown length
+func wavHeader(sampleRate int, pcmLength int64) []byte {
+	riffSize, dataSize := uint32(0xFFFFFFFF), uint32(0xFFFFFFFF)
+	if pcmLength >= 0 {
+		riffSize, dataSize = uint32(36+pcmLength), uint32(pcmLength)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, riffSize)
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataSize)
+	return buf.Bytes()
+}
+
+// wavWriter writes mono 16-bit PCM to w as a WAV file, the header going out
+// with the first chunk; the sizes are only known at the end, so finish fills
+// them in when w is a file or a buffer and otherwise leaves the maximum
+type wavWriter struct {
+	w          io.Writer
+	sampleRate int
+	// start is the offset of the header in w, pcm the PCM bytes written and n
+	// every byte written, header included
+	start, pcm, n int64
+}
+
+func newWAVWriter(w io.Writer, sampleRate int) *wavWriter {
+	return &wavWriter{w: w, sampleRate: sampleRate}
+}
+
+// Write writes a chunk of PCM, after the header on the first call
+func (w *wavWriter) Write(pcm []byte) (int, error) {
+	if len(pcm) == 0 {
+		return 0, nil
+	}
+	if w.n == 0 {
+		switch dst := w.w.(type) {
+		case io.WriteSeeker:
+			start, err := dst.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return 0, err
+			}
+			w.start = start
+		case *bytes.Buffer:
+			w.start = int64(dst.Len())
+		}
+		n, err := w.w.Write(wavHeader(w.sampleRate, -1))
+		w.n += int64(n)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.w.Write(pcm)
+	w.pcm += int64(n)
+	w.n += int64(n)
+	return n, err
+}
+
+// finish fills in the sizes in the header, where w allows it, and returns the
+// bytes written
+func (w *wavWriter) finish() (int64, error) {
+	if w.n == 0 {
+		return 0, nil
+	}
+	header := wavHeader(w.sampleRate, w.pcm)
+	switch dst := w.w.(type) {
+	case io.WriteSeeker:
+		if _, err := dst.Seek(w.start, io.SeekStart); err != nil {
+			return w.n, err
+		}
+		if _, err := dst.Write(header); err != nil {
+			return w.n, err
+		}
+		if _, err := dst.Seek(0, io.SeekEnd); err != nil {
+			return w.n, err
+		}
+	case *bytes.Buffer:
+		copy(dst.Bytes()[w.start:], header)
+	}
+	return w.n, nil
+}
+
+// ListChirpHDVoices returns all voices with "Chirp-HD" in the name
+func ListChirpHDVoices() ([]*texttospeechpb.Voice, error) {
+	voices := []*texttospeechpb.Voice{}
+	ctx := context.Background()
+
+	client, err := texttospeech.NewClient(ctx)
+	if err != nil {
+		return voices, err
+	}
+
+	resp, err := client.ListVoices(ctx, &texttospeechpb.ListVoicesRequest{})
+	if err != nil {
+		return voices, err
+	}
+
+	for _, voice := range resp.Voices {
+		/*
+			if strings.Contains(voice.Name, "Chirp-HD") {
+				voices = append(voices, voice)
+			}
+		*/
+
+		if strings.Contains(voice.Name, "Chirp3-HD") {
+			voices = append(voices, voice)
+		}
+
+	}
+
+	return voices, nil
+}
@@ -0,0 +1,341 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/googleapis/gax-go/v2"
+	genai "google.golang.org/genai"
+)
+
+// stubSynthesizer is a Synthesizer backed by a function, with no model
+type stubSynthesizer func(ctx context.Context, voice VoiceSpec, text string) ([]byte, error)
+
+func (s stubSynthesizer) Synthesize(ctx context.Context, voice VoiceSpec, text string, _ SynthesisOptions) ([]byte, error) {
+	return s(ctx, voice, text)
+}
+
+func (s stubSynthesizer) Model() string { return "" }
+
+// stubSpeechGenerator records the Gemini request and streams raw PCM, in the
+// given chunks or in one chunk of two samples
+type stubSpeechGenerator struct {
+	model    string
+	contents []*genai.Content
+	config   *genai.GenerateContentConfig
+	calls    int
+	chunks   [][]byte
+}
+
+func (g *stubSpeechGenerator) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	g.calls++
+	g.model, g.contents, g.config = model, contents, config
+	chunks := g.chunks
+	if chunks == nil {
+		chunks = [][]byte{{1, 0, 2, 0}}
+	}
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		for _, chunk := range chunks {
+			resp := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				Content: &genai.Content{Parts: []*genai.Part{{
+					InlineData: &genai.Blob{MIMEType: "audio/L16;codec=pcm;rate=16000", Data: chunk},
+				}}},
+			}}}
+			if !yield(resp, nil) {
+				return
+			}
+		}
+	}
+}
+
+var testChirpVoices = []*texttospeechpb.Voice{
+	{Name: "en-US-Chirp3-HD-Puck", LanguageCodes: []string{"en-US"}, SsmlGender: texttospeechpb.SsmlVoiceGender_MALE},
+	{Name: "de-DE-Chirp3-HD-Aoede", LanguageCodes: []string{"de-DE"}, SsmlGender: texttospeechpb.SsmlVoiceGender_FEMALE},
+}
+
+func TestResolveVoiceSpecs(t *testing.T) {
+	languages := []string{"de-DE", "en-US"}
+
+	for _, tc := range []struct {
+		name string
+		req  BabelRequest
+		want []VoiceSpec
+	}{
+		{"chirp by default", BabelRequest{}, []VoiceSpec{
+			{BackendChirp, "en-US-Chirp3-HD-Puck", "en-US", "MALE"},
+			{BackendChirp, "de-DE-Chirp3-HD-Aoede", "de-DE", "FEMALE"},
+		}},
+		{"gemini voice in every language", BabelRequest{Backend: "Gemini", VoiceName: "Puck"}, []VoiceSpec{
+			{BackendGemini, "Puck", "de-DE", "MALE"},
+			{BackendGemini, "Puck", "en-US", "MALE"},
+		}},
+		{"gemini default voice", BabelRequest{Backend: BackendGemini}, []VoiceSpec{
+			{BackendGemini, defaultGeminiVoice, "de-DE", "FEMALE"},
+			{BackendGemini, defaultGeminiVoice, "en-US", "FEMALE"},
+		}},
+		{"mixed per voice", BabelRequest{Voices: []VoiceSelection{
+			{Name: "de-DE-Chirp3-HD-Aoede"},
+			{Name: "Charon", Backend: BackendGemini, LanguageCodes: []string{"en-US"}},
+		}}, []VoiceSpec{
+			{BackendChirp, "de-DE-Chirp3-HD-Aoede", "de-DE", "FEMALE"},
+			{BackendGemini, "Charon", "en-US", "MALE"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveVoiceSpecs(tc.req, testChirpVoices, languages)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+			for i := range tc.want {
+				if got[i] != tc.want[i] {
+					t.Errorf("spec %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+
+	for _, req := range []BabelRequest{
+		{Backend: "polly"},
+		{Voices: []VoiceSelection{{Name: "fr-FR-Chirp3-HD-Leda"}}},
+		{Voices: []VoiceSelection{{Name: "Puck", Backend: BackendGemini, LanguageCodes: []string{"xx-XX"}}}},
+		{Voices: []VoiceSelection{{Backend: BackendGemini}}},
+	} {
+		if _, err := resolveVoiceSpecs(req, testChirpVoices, languages); err == nil {
+			t.Errorf("expected an error for %+v", req)
+		}
+	}
+}
+
+func TestGeminiSynthesizerRequest(t *testing.T) {
+	stub := &stubSpeechGenerator{}
+	synthesizer := &geminiSynthesizer{models: stub, model: defaultGeminiTTSModel}
+	voice := VoiceSpec{Backend: BackendGemini, Name: "Kore", LanguageCode: "de-DE"}
+	opts := SynthesisOptions{Modifiers: []string{"happy", " professional "}, Instructions: "Say the following:"}
+
+	audio, err := synthesizer.Synthesize(context.Background(), voice, "hallo", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stub.model != defaultGeminiTTSModel {
+		t.Errorf("model = %s, want %s", stub.model, defaultGeminiTTSModel)
+	}
+	prompt := stub.contents[0].Parts[0].Text
+	if want := "Say the following: Use a happy, professional tone.\nhallo"; prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+	if got := stub.config.ResponseModalities; len(got) != 1 || got[0] != "AUDIO" {
+		t.Errorf("response modalities = %v, want [AUDIO]", got)
+	}
+	speech := stub.config.SpeechConfig
+	if speech.LanguageCode != "de-DE" || speech.VoiceConfig.PrebuiltVoiceConfig.VoiceName != "Kore" {
+		t.Errorf("speech config = %+v, want Kore in de-DE", speech)
+	}
+
+	// the raw PCM comes back as a WAV file at the rate given in the mime type
+	if !bytes.HasPrefix(audio, []byte("RIFF")) || len(audio) != 44+4 {
+		t.Fatalf("expected a 48 byte WAV file, got %d bytes", len(audio))
+	}
+	if rate := binary.LittleEndian.Uint32(audio[24:28]); rate != 16000 {
+		t.Errorf("sample rate = %d, want 16000", rate)
+	}
+	if _, pcm, _ := splitWAV(audio); !bytes.Equal(pcm, []byte{1, 0, 2, 0}) {
+		t.Errorf("unexpected PCM payload %v", pcm)
+	}
+
+	// Gemini-TTS has no rate setting, so a faster retake asks for it in the prompt
+	opts.SpeakingRate = 1.25
+	if _, err := synthesizer.Synthesize(context.Background(), voice, "hallo", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "Say the following: Use a happy, professional tone. Speak at 1.25 times your natural pace.\nhallo"; stub.contents[0].Parts[0].Text != want {
+		t.Errorf("prompt = %q, want %q", stub.contents[0].Parts[0].Text, want)
+	}
+}
+
+func TestGeminiSynthesizerStreamsToWriter(t *testing.T) {
+	stub := &stubSpeechGenerator{chunks: [][]byte{{1, 0}, {2, 0, 3, 0}, {4, 0}}}
+	synthesizer := &geminiSynthesizer{models: stub, model: defaultGeminiTTSModel}
+	voice := VoiceSpec{Backend: BackendGemini, Name: "Kore", LanguageCode: "en-US"}
+
+	// the header sizes are filled in once the length of the stream is known
+	var buf bytes.Buffer
+	buf.WriteString("prefix")
+	n, err := synthesizer.SynthesizeTo(context.Background(), &buf, voice, "hello", SynthesisOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	audio := bytes.TrimPrefix(buf.Bytes(), []byte("prefix"))
+	if n != int64(len(audio)) || n != 44+8 {
+		t.Fatalf("wrote %d bytes, buffer has %d, want %d", n, len(audio), 44+8)
+	}
+	if size := binary.LittleEndian.Uint32(audio[4:8]); size != 36+8 {
+		t.Errorf("RIFF size = %d, want %d", size, 36+8)
+	}
+	if _, pcm, _ := splitWAV(audio); !bytes.Equal(pcm, []byte{1, 0, 2, 0, 3, 0, 4, 0}) {
+		t.Errorf("unexpected PCM payload %v", pcm)
+	}
+
+	// a clip streamed into its file gets the same sizes
+	audiofile := filepath.Join(t.TempDir(), "clip.wav")
+	if _, err := synthesizeToFile(context.Background(), synthesizer, audiofile, voice, "hello", SynthesisOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	written, err := os.ReadFile(audiofile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, audio) {
+		t.Errorf("file has %v, want %v", written, audio)
+	}
+
+	// nothing is left behind when no audio comes back
+	stub.chunks = [][]byte{}
+	if _, err := synthesizeToFile(context.Background(), synthesizer, audiofile, voice, "hello", SynthesisOptions{}); err == nil {
+		t.Error("expected an error without audio")
+	}
+	if _, err := os.Stat(audiofile); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", audiofile, err)
+	}
+}
+
+// fakeSpeechStream answers a streaming Text-to-Speech call with chunks of PCM
+type fakeSpeechStream struct {
+	texttospeechpb.TextToSpeech_StreamingSynthesizeClient
+	requests []*texttospeechpb.StreamingSynthesizeRequest
+	chunks   [][]byte
+}
+
+func (s *fakeSpeechStream) Send(req *texttospeechpb.StreamingSynthesizeRequest) error {
+	s.requests = append(s.requests, req)
+	return nil
+}
+
+func (s *fakeSpeechStream) CloseSend() error { return nil }
+
+func (s *fakeSpeechStream) Recv() (*texttospeechpb.StreamingSynthesizeResponse, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return &texttospeechpb.StreamingSynthesizeResponse{AudioContent: chunk}, nil
+}
+
+// fakeStreamingSpeechClient is a Text-to-Speech client that can also stream
+type fakeStreamingSpeechClient struct {
+	fakeSpeechClient
+	stream *fakeSpeechStream
+}
+
+func (f *fakeStreamingSpeechClient) StreamingSynthesize(ctx context.Context, opts ...gax.CallOption) (texttospeechpb.TextToSpeech_StreamingSynthesizeClient, error) {
+	return f.stream, nil
+}
+
+func TestChirpSynthesizerStreams(t *testing.T) {
+	client := &fakeStreamingSpeechClient{stream: &fakeSpeechStream{chunks: [][]byte{{1, 0}, {2, 0}}}}
+	synthesizer := chirpSynthesizer{client: client}
+	voice := VoiceSpec{Backend: BackendChirp, Name: "en-US-Chirp3-HD-Puck", LanguageCode: "en-US"}
+
+	var buf bytes.Buffer
+	if _, err := synthesizer.SynthesizeTo(context.Background(), &buf, voice, "hello", SynthesisOptions{SpeakingRate: 1.2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	audio := buf.Bytes()
+	if rate := binary.LittleEndian.Uint32(audio[24:28]); rate != chirpStreamSampleRate {
+		t.Errorf("sample rate = %d, want %d", rate, chirpStreamSampleRate)
+	}
+	if _, pcm, _ := splitWAV(audio); !bytes.Equal(pcm, []byte{1, 0, 2, 0}) {
+		t.Errorf("unexpected PCM payload %v", pcm)
+	}
+	requests := client.stream.requests
+	if len(requests) != 2 {
+		t.Fatalf("expected a config and an input request, got %d", len(requests))
+	}
+	config := requests[0].GetStreamingConfig()
+	if config.GetVoice().GetName() != voice.Name || config.GetStreamingAudioConfig().GetSpeakingRate() != 1.2 {
+		t.Errorf("unexpected streaming config %v", config)
+	}
+	if text := requests[1].GetInput().GetText(); text != "hello" {
+		t.Errorf("input = %q, want hello", text)
+	}
+
+	// effects profiles need the unary API
+	buf.Reset()
+	if _, err := synthesizer.SynthesizeTo(context.Background(), &buf, voice, "hello", SynthesisOptions{EffectsProfile: "headphone-class-device"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.requests[voice.Name] == nil {
+		t.Error("expected a unary request for a clip with an effects profile")
+	}
+}
+
+func TestGeminiSynthesizerLimits(t *testing.T) {
+	stub := &stubSpeechGenerator{}
+	synthesizer := &geminiSynthesizer{models: stub, model: defaultGeminiTTSModel}
+	voice := VoiceSpec{Backend: BackendGemini, Name: "Kore", LanguageCode: "en-US"}
+
+	if _, err := synthesizer.Synthesize(context.Background(), voice, strings.Repeat("a", geminiMaxTextBytes+1), SynthesisOptions{}); err == nil {
+		t.Error("expected an error for text over the limit")
+	}
+	long := SynthesisOptions{Instructions: strings.Repeat("b", geminiMaxPromptBytes+1)}
+	if _, err := synthesizer.Synthesize(context.Background(), voice, "hello", long); err == nil {
+		t.Error("expected an error for a style prompt over the limit")
+	}
+	if stub.calls != 0 {
+		t.Errorf("expected no calls over the limits, got %d", stub.calls)
+	}
+}
+
+func TestGenerateSpeechMixedBackends(t *testing.T) {
+	synthesizers := map[string]Synthesizer{
+		BackendChirp: stubSynthesizer(func(ctx context.Context, voice VoiceSpec, text string) ([]byte, error) {
+			return []byte("RIFF"), nil
+		}),
+		BackendGemini: &geminiSynthesizer{models: &stubSpeechGenerator{}, model: defaultGeminiTTSModel},
+	}
+	specs := []VoiceSpec{
+		{BackendChirp, "en-US-Chirp3-HD-Puck", "en-US", "MALE"},
+		{BackendGemini, "Kore", "en-US", "FEMALE"},
+		{"unknown", "Leda", "en-US", "FEMALE"},
+	}
+
+	outputs := generateSpeech(context.Background(), t.TempDir(), specs, map[string]string{"en-US": "hello"}, synthesizers, SynthesisOptions{}, nil, BestEffort)
+	byBackend := map[string]BabelOutput{}
+	for _, o := range outputs {
+		byBackend[o.Backend] = o
+	}
+	if o := byBackend[BackendChirp]; o.Error != "" || o.Model != "" {
+		t.Errorf("unexpected chirp output %+v", o)
+	}
+	if o := byBackend[BackendGemini]; o.Error != "" || o.Model != defaultGeminiTTSModel {
+		t.Errorf("expected the gemini output to report its model, got %+v", o)
+	}
+	if o := byBackend["unknown"]; o.Error == "" {
+		t.Errorf("expected an error for an unavailable backend, got %+v", o)
+	}
+}
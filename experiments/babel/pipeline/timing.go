@@ -0,0 +1,93 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// StageTimings is how long each stage took for one output, in milliseconds;
+// TotalMS is the sum of the stages, so it excludes time spent waiting on
+// other languages
+type StageTimings struct {
+	TranslationMS int64 `json:"translation_ms"`
+	SynthesisMS   int64 `json:"synthesis_ms"`
+	UploadMS      int64 `json:"upload_ms"`
+	TotalMS       int64 `json:"total_ms"`
+}
+
+// updateTotal recomputes TotalMS after a stage is recorded
+func (t *StageTimings) updateTotal() {
+	t.TotalMS = t.TranslationMS + t.SynthesisMS + t.UploadMS
+}
+
+// translationResult is one language's translation, sent back from its goroutine
+type translationResult struct {
+	Language string
+	Text     string
+	Elapsed  time.Duration
+	Err      error
+	// Suspect is why the translation looks wrong, empty when it looks fine
+	Suspect string
+}
+
+// applyTranslationTimes records each output's translation time, by language
+func applyTranslationTimes(outputs []BabelOutput, translationTimes map[string]time.Duration) {
+	for i := range outputs {
+		outputs[i].TranslationMS = translationTimes[outputs[i].LanguageCode].Milliseconds()
+		outputs[i].updateTotal()
+	}
+}
+
+// TimingSummary aggregates the total_ms of the outputs in a response
+type TimingSummary struct {
+	// SlowestLanguage is the language of the output with the highest total_ms
+	SlowestLanguage string `json:"slowest_language"`
+	SlowestTotalMS  int64  `json:"slowest_total_ms"`
+	P50TotalMS      int64  `json:"p50_total_ms"`
+	P95TotalMS      int64  `json:"p95_total_ms"`
+}
+
+// SummarizeTimings returns the timing summary of the outputs, nil if there are none;
+// percentiles use the nearest-rank method
+func SummarizeTimings(outputs []BabelOutput) *TimingSummary {
+	if len(outputs) == 0 {
+		return nil
+	}
+	summary := &TimingSummary{}
+	totals := make([]int64, 0, len(outputs))
+	for _, o := range outputs {
+		totals = append(totals, o.TotalMS)
+		if summary.SlowestLanguage == "" || o.TotalMS > summary.SlowestTotalMS {
+			summary.SlowestLanguage = o.LanguageCode
+			summary.SlowestTotalMS = o.TotalMS
+		}
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i] < totals[j] })
+	summary.P50TotalMS = nearestRank(totals, 50)
+	summary.P95TotalMS = nearestRank(totals, 95)
+	return summary
+}
+
+// nearestRank is the p-th percentile of the sorted values
+func nearestRank(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// assertStageMS checks a measured stage against the sleep behind it, allowing
+// for scheduling delays but not for another stage's sleep
+func assertStageMS(t *testing.T, name string, got int64, slept time.Duration) {
+	t.Helper()
+	want := slept.Milliseconds()
+	if got < want || got > want+100 {
+		t.Errorf("%s = %d ms, want about %d ms", name, got, want)
+	}
+}
+
+func TestStageTimings(t *testing.T) {
+	dir := t.TempDir()
+	// each language sleeps for a different, distinct time in each stage
+	sleeps := map[string]struct{ translation, synthesis, upload time.Duration }{
+		"de-DE": {150 * time.Millisecond, 30 * time.Millisecond, 60 * time.Millisecond},
+		"fr-FR": {20 * time.Millisecond, 350 * time.Millisecond, 10 * time.Millisecond},
+	}
+	generate := func(ctx context.Context, prompt string) (string, error) {
+		for language, s := range sleeps {
+			if strings.Contains(prompt, language) {
+				time.Sleep(s.translation)
+				return "translated " + language, nil
+			}
+		}
+		return "", nil
+	}
+	synthesizers := map[string]Synthesizer{BackendChirp: stubSynthesizer(func(ctx context.Context, voice VoiceSpec, text string) ([]byte, error) {
+		time.Sleep(sleeps[voice.LanguageCode].synthesis)
+		return []byte("RIFF"), nil
+	})}
+	var uploaded []string
+	upload := func(ctx context.Context, objectName string, r io.Reader) error {
+		for language, s := range sleeps {
+			if strings.Contains(objectName, language) {
+				time.Sleep(s.upload)
+			}
+		}
+		uploaded = append(uploaded, objectName)
+		return nil
+	}
+
+	languages := []string{"de-DE", "fr-FR"}
+	translations, translationTimes, translationErrors, _ := translate(context.Background(), generate, "hello", defaultSourceLanguage, languages, BestEffort)
+	if len(translationErrors) != 0 || translations["fr-FR"] != "translated fr-FR" {
+		t.Fatalf("unexpected translations %v, errors %v", translations, translationErrors)
+	}
+	specs := []VoiceSpec{
+		{BackendChirp, "de-DE-Chirp3-HD-Fenrir", "de-DE", "MALE"},
+		{BackendChirp, "fr-FR-Chirp3-HD-Aoede", "fr-FR", "FEMALE"},
+	}
+	outputs := generateSpeech(context.Background(), dir, specs, translations, synthesizers, SynthesisOptions{}, nil, BestEffort)
+	applyTranslationTimes(outputs, translationTimes)
+	if err := uploadOutputs(context.Background(), dir, outputs, func(string) (string, objectUploader) { return "babel", upload }); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 2 {
+		t.Fatalf("expected both files to be uploaded, got %v", uploaded)
+	}
+
+	for _, o := range outputs {
+		s := sleeps[o.LanguageCode]
+		assertStageMS(t, o.LanguageCode+" translation_ms", o.TranslationMS, s.translation)
+		assertStageMS(t, o.LanguageCode+" synthesis_ms", o.SynthesisMS, s.synthesis)
+		assertStageMS(t, o.LanguageCode+" upload_ms", o.UploadMS, s.upload)
+		if o.TotalMS != o.TranslationMS+o.SynthesisMS+o.UploadMS {
+			t.Errorf("%s total_ms = %d, want the sum of the stages", o.LanguageCode, o.TotalMS)
+		}
+		if _, err := os.Stat(filepath.Join(dir, o.AudioPath)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed after upload", o.AudioPath)
+		}
+	}
+
+	summary := SummarizeTimings(outputs)
+	if summary.SlowestLanguage != "fr-FR" {
+		t.Errorf("expected fr-FR to be the slowest language, got %+v", summary)
+	}
+}
+
+func TestSummarizeTimings(t *testing.T) {
+	if SummarizeTimings(nil) != nil {
+		t.Error("expected no summary without outputs")
+	}
+	var outputs []BabelOutput
+	for i, total := range []int64{500, 100, 300, 200, 900, 400, 700, 600, 800, 1000} {
+		language := []string{"en-US", "de-DE", "fr-FR", "ja-JP", "hi-IN"}[i%5]
+		outputs = append(outputs, BabelOutput{LanguageCode: language, StageTimings: StageTimings{TotalMS: total}})
+	}
+	want := TimingSummary{SlowestLanguage: "hi-IN", SlowestTotalMS: 1000, P50TotalMS: 500, P95TotalMS: 1000}
+	if got := SummarizeTimings(outputs); *got != want {
+		t.Errorf("SummarizeTimings = %+v, want %+v", *got, want)
+	}
+	if got := SummarizeTimings(outputs[:1]); got.P50TotalMS != 500 || got.P95TotalMS != 500 {
+		t.Errorf("expected both percentiles of one output to be its total, got %+v", got)
+	}
+}
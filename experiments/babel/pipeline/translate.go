@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// languageDescriptions names the languages whose code alone makes a poor prompt
+var languageDescriptions = map[string]string{
+	"es-US": "Mexican Spanish",
+}
+
+// translate takes a primary statement and a list of languages
+// and returns the translation of the statement into each of those languages
+// this looks like a list of [en-us]"translated statement"
+// failed languages keep an error message as their text and are also returned
+// in the error map, so their voices must be skipped rather than voiced; in
+// fail-fast mode the first failure cancels the rest
+// the time each translation took is returned by language
+// if ctx is cancelled, translate returns straight away with the translations
+// received so far, and the languages still pending fail with the context error
+// a translation that comes back empty, unchanged or in the wrong script is asked
+// for once more with a more explicit prompt; if it is still suspect, it is kept
+// and the reason is returned in the suspect map, by language
+func translate(ctx context.Context, generate func(ctx context.Context, prompt string) (string, error), statement, sourceLanguage string, languages []string, mode ErrorMode) (map[string]string, map[string]time.Duration, map[string]error, map[string]string) {
+	var wg sync.WaitGroup
+	results := make(map[string]string)
+	translationTimes := make(map[string]time.Duration)
+	translationErrors := make(map[string]error)
+	suspects := make(map[string]string)
+	resultChan := make(chan translationResult, len(languages))
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, language := range languages {
+		wg.Add(1)
+		go func(ctx context.Context, statement, language string) {
+			defer wg.Done()
+			// obtain language description, if there is one
+			languageDescription := language
+			if value, ok := languageDescriptions[language]; ok == true {
+				languageDescription = value
+			}
+			// translation prompt
+			prompt := fmt.Sprintf(`
+translate this into appropriate vernacular in language %s \"%s\" output only the statement mimicing the level of formality, do not explain why.
+translation: `, languageDescription, statement)
+			prompt = strings.ReplaceAll(prompt, "\n", "")
+			var translation string
+			var err error
+			start := time.Now()
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			default:
+				translation, err = generate(ctx, prompt)
+			}
+			var suspect string
+			if err == nil {
+				if suspect = suspectTranslation(statement, translation, language, sourceLanguage); suspect != "" {
+					log.Printf("translation to %s is %s, asking again", language, suspect)
+					retry, retryErr := generate(ctx, explicitTranslationPrompt(statement, languageDescription, language, sourceLanguage))
+					if retryErr != nil {
+						log.Printf("couldn't translate to %s again: %v", language, retryErr)
+					} else {
+						translation = retry
+						suspect = suspectTranslation(statement, translation, language, sourceLanguage)
+					}
+				}
+			}
+			elapsed := time.Since(start)
+			if err != nil {
+				translation = fmt.Sprintf("couldn't translate to %s: %v", language, err)
+				if mode == FailFast {
+					cancel()
+				}
+			}
+			resultChan <- translationResult{Language: language, Text: translation, Elapsed: elapsed, Err: err, Suspect: suspect}
+		}(ctx, statement, language)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	// only the caller's cancellation stops the collection early; a fail-fast
+	// cancellation still lets every goroutine report its language
+collect:
+	for {
+		select {
+		case r, ok := <-resultChan:
+			if !ok {
+				break collect
+			}
+			results[r.Language] = r.Text
+			translationTimes[r.Language] = r.Elapsed
+			if r.Err != nil {
+				translationErrors[r.Language] = r.Err
+			}
+			if r.Suspect != "" {
+				log.Printf("translation to %s is still %s: %q", r.Language, r.Suspect, r.Text)
+				suspects[r.Language] = r.Suspect
+			}
+		case <-parent.Done():
+			log.Printf("translation cancelled with %d of %d languages done: %v", len(results), len(languages), parent.Err())
+			for _, language := range languages {
+				if _, ok := results[language]; !ok {
+					results[language] = fmt.Sprintf("couldn't translate to %s: %v", language, parent.Err())
+					translationErrors[language] = parent.Err()
+				}
+			}
+			break collect
+		}
+	}
+
+	return results, translationTimes, translationErrors, suspects
+}
+
+// geminiTranslator generates text with Gemini on Vertex AI
+type geminiTranslator struct {
+	projectID string
+	location  string
+	// endpoint overrides the regional Vertex AI endpoint when set
+	endpoint string
+}
+
+// NewGeminiTranslator translates with Gemini in the project and location; a
+// non-empty endpoint overrides the regional Vertex AI endpoint
+func NewGeminiTranslator(projectID, location, endpoint string) Translator {
+	return geminiTranslator{projectID: projectID, location: location, endpoint: endpoint}
+}
+
+// Generate calls Gemini using the provided prompt
+func (g geminiTranslator) Generate(ctx context.Context, prompt string) (string, error) {
+	client, err := genai.NewClient(ctx, g.projectID, g.location, translationClientOptions(g.endpoint)...)
+	if err != nil {
+		return "", fmt.Errorf("error creating a client: %v", err)
+	}
+	defer client.Close()
+
+	gemini := client.GenerativeModel("gemini-1.5-flash")
+	gemini.SafetySettings = []*genai.SafetySetting{
+		{
+			Category:  genai.HarmCategoryHarassment,
+			Threshold: genai.HarmBlockNone,
+		},
+		{
+			Category:  genai.HarmCategoryDangerousContent,
+			Threshold: genai.HarmBlockNone,
+		},
+	}
+
+	parts := []genai.Part{genai.Text(prompt)}
+	resp, err := gemini.GenerateContent(ctx, parts...)
+	if err != nil {
+		return "", fmt.Errorf("error generating content: %v", err)
+	}
+	var all []string
+	for _, v := range resp.Candidates[0].Content.Parts {
+		all = append(all, fmt.Sprintf("%s", v))
+	}
+	return strings.Join(all, " "), nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// defaultSourceLanguage is the language statements are assumed to be written in
+const defaultSourceLanguage = "en"
+
+// script is a writing system and the Unicode tables its letters come from
+type script struct {
+	name   string
+	tables []*unicode.RangeTable
+}
+
+// languageScripts are the scripts of the languages not written in Latin script,
+// by base language; a translation into one of them should be mostly in that script
+var languageScripts = map[string]script{
+	"ja":  {"Japanese (kana and kanji)", []*unicode.RangeTable{unicode.Hiragana, unicode.Katakana, unicode.Han}},
+	"zh":  {"Chinese (Han)", []*unicode.RangeTable{unicode.Han}},
+	"cmn": {"Chinese (Han)", []*unicode.RangeTable{unicode.Han}},
+	"yue": {"Chinese (Han)", []*unicode.RangeTable{unicode.Han}},
+	"ko":  {"Korean (Hangul)", []*unicode.RangeTable{unicode.Hangul, unicode.Han}},
+	"ar":  {"Arabic", []*unicode.RangeTable{unicode.Arabic}},
+	"fa":  {"Arabic", []*unicode.RangeTable{unicode.Arabic}},
+	"ur":  {"Arabic", []*unicode.RangeTable{unicode.Arabic}},
+	"he":  {"Hebrew", []*unicode.RangeTable{unicode.Hebrew}},
+	"iw":  {"Hebrew", []*unicode.RangeTable{unicode.Hebrew}},
+	"ru":  {"Cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	"uk":  {"Cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	"bg":  {"Cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	"sr":  {"Cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	"el":  {"Greek", []*unicode.RangeTable{unicode.Greek}},
+	"hi":  {"Devanagari", []*unicode.RangeTable{unicode.Devanagari}},
+	"mr":  {"Devanagari", []*unicode.RangeTable{unicode.Devanagari}},
+	"bn":  {"Bengali", []*unicode.RangeTable{unicode.Bengali}},
+	"gu":  {"Gujarati", []*unicode.RangeTable{unicode.Gujarati}},
+	"pa":  {"Gurmukhi", []*unicode.RangeTable{unicode.Gurmukhi}},
+	"ta":  {"Tamil", []*unicode.RangeTable{unicode.Tamil}},
+	"te":  {"Telugu", []*unicode.RangeTable{unicode.Telugu}},
+	"kn":  {"Kannada", []*unicode.RangeTable{unicode.Kannada}},
+	"ml":  {"Malayalam", []*unicode.RangeTable{unicode.Malayalam}},
+	"th":  {"Thai", []*unicode.RangeTable{unicode.Thai}},
+}
+
+// baseLanguage returns the language subtag of a language code, "ja" for "ja-JP"
+func baseLanguage(code string) string {
+	base, _, _ := strings.Cut(code, "-")
+	return strings.ToLower(base)
+}
+
+// suspectTranslation returns why a translation of statement into language looks
+// wrong, or "" when it looks fine: it is empty, it is the statement unchanged,
+// or most of its letters aren't in the script of a non-Latin-script language
+// the statement is expected back unchanged in its own language, sourceLanguage
+func suspectTranslation(statement, translation, language, sourceLanguage string) string {
+	normalized := normalizeForComparison(translation)
+	if normalized == "" {
+		return "empty"
+	}
+	if normalized == normalizeForComparison(statement) && baseLanguage(language) != baseLanguage(sourceLanguage) {
+		return "unchanged from the statement"
+	}
+	if s, ok := languageScripts[baseLanguage(language)]; ok && !predominantlyInScript(translation, s.tables) {
+		return fmt.Sprintf("not in %s script", s.name)
+	}
+	return ""
+}
+
+// normalizeForComparison lowercases text and keeps only its letters and digits,
+// so that punctuation, quotes and spacing don't hide an unchanged statement
+func normalizeForComparison(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// predominantlyInScript reports whether more than half of the letters in text are
+// in one of the tables; text without letters is not
+func predominantlyInScript(text string, tables []*unicode.RangeTable) bool {
+	letters, inScript := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.In(r, tables...) {
+			inScript++
+		}
+	}
+	return inScript*2 > letters
+}
+
+// explicitTranslationPrompt asks once more for a translation that came back
+// suspect, naming the source language and the script the result must be in
+func explicitTranslationPrompt(statement, languageDescription, language, sourceLanguage string) string {
+	target := languageDescription
+	if s, ok := languageScripts[baseLanguage(language)]; ok {
+		target = fmt.Sprintf("%s, written in %s script", languageDescription, s.name)
+	}
+	return fmt.Sprintf(`the statement below is in language %s. translate it into language %s (%s). the translation must not be empty and must not repeat the original statement. output only the translation, mimicing the level of formality, do not explain why. statement: \"%s\" translation: `, sourceLanguage, target, language, statement)
+}
+
+// skipSuspects separates the voices of languages with a suspect translation from
+// the others, returning a failed output for each of them
+func skipSuspects(specs []VoiceSpec, translations map[string]string, suspects map[string]string) ([]VoiceSpec, []BabelOutput) {
+	var kept []VoiceSpec
+	var skipped []BabelOutput
+	for _, voice := range specs {
+		suspect, ok := suspects[voice.LanguageCode]
+		if !ok {
+			kept = append(kept, voice)
+			continue
+		}
+		skipped = append(skipped, BabelOutput{
+			VoiceName:          voice.Name,
+			LanguageCode:       voice.LanguageCode,
+			Text:               translations[voice.LanguageCode],
+			Gender:             voice.Gender,
+			Backend:            voice.Backend,
+			Error:              fmt.Sprintf("translation to %s is %s, not voiced", voice.LanguageCode, suspect),
+			TranslationSuspect: true,
+		})
+	}
+	return kept, skipped
+}
+
+// applySuspects flags the outputs of languages with a suspect translation
+func applySuspects(outputs []BabelOutput, suspects map[string]string) {
+	for i := range outputs {
+		_, outputs[i].TranslationSuspect = suspects[outputs[i].LanguageCode]
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNormalizeForComparison(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Hello there!", `"hello  there"`, true},
+		{"Hello there", "Hallo da", false},
+		{"你好，世界。", "「你好 世界」", true},
+		{"你好世界", "你好朋友", false},
+		{"مرحبا بالعالم!", "«مرحبا بالعالم»", true},
+		{"Привет, мир!", "привет мир", true},
+		{"Привет, мир!", "Привет, друг!", false},
+		{"...", "", true},
+	}
+	for _, tt := range tests {
+		if got := normalizeForComparison(tt.a) == normalizeForComparison(tt.b); got != tt.same {
+			t.Errorf("%q and %q: same = %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
+
+func TestPredominantlyInScript(t *testing.T) {
+	tests := []struct {
+		language, text string
+		want           bool
+	}{
+		{"ja-JP", "こんにちは、皆さん", true},
+		{"ja-JP", "新しいiPhoneを買いました", true},
+		{"ja-JP", "hello everyone", false},
+		{"cmn-CN", "大家好", true},
+		{"cmn-CN", "hello 大家", false},
+		{"ko-KR", "안녕하세요 여러분", true},
+		{"ar-XA", "مرحبا بالجميع", true},
+		{"ar-XA", "hello بالجميع everyone", false},
+		{"ru-RU", "Всем привет", true},
+		{"ru-RU", "Vsem privet", false},
+		{"hi-IN", "सभी को नमस्ते", true},
+		{"hi-IN", "123 !", false},
+	}
+	for _, tt := range tests {
+		s := languageScripts[baseLanguage(tt.language)]
+		if got := predominantlyInScript(tt.text, s.tables); got != tt.want {
+			t.Errorf("%s %q: got %v, want %v", tt.language, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSuspectTranslation(t *testing.T) {
+	tests := []struct {
+		language, translation string
+		want                  string
+	}{
+		{"fr-FR", "Bonjour à tous !", ""},
+		{"fr-FR", "  ", "empty"},
+		{"fr-FR", "Hello, everyone.", "unchanged from the statement"},
+		{"en-GB", "Hello everyone!", ""},
+		{"de-DE", "Hallo zusammen!", ""},
+		{"ja-JP", "皆さん、こんにちは！", ""},
+		{"ja-JP", "hello everyone", "unchanged from the statement"},
+		{"ja-JP", "Hola a todos", "not in Japanese (kana and kanji) script"},
+		{"cmn-CN", "大家好！", ""},
+		{"ar-XA", "مرحبا بالجميع", ""},
+		{"ar-XA", "Всем привет", "not in Arabic script"},
+		{"ru-RU", "Всем привет!", ""},
+		{"ru-RU", "Vsem privet!", "not in Cyrillic script"},
+	}
+	for _, tt := range tests {
+		if got := suspectTranslation("Hello everyone!", tt.translation, tt.language, defaultSourceLanguage); got != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.language, tt.translation, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateRetriesSuspectTranslations(t *testing.T) {
+	// each language's answers, in order: the first to the usual prompt, the
+	// second to the explicit one
+	answers := map[string][]string{
+		"de-DE": {"Hallo zusammen", "unexpected"},
+		"ja-JP": {"hello everyone", "皆さん、こんにちは"},
+		"ru-RU": {"", "Vsem privet"},
+	}
+	var mu sync.Mutex
+	prompts := map[string][]string{}
+	generate := func(ctx context.Context, prompt string) (string, error) {
+		for language, texts := range answers {
+			if !strings.Contains(prompt, language) {
+				continue
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			prompts[language] = append(prompts[language], prompt)
+			return texts[len(prompts[language])-1], nil
+		}
+		t.Errorf("unexpected prompt %q", prompt)
+		return "", nil
+	}
+
+	translations, _, translationErrors, suspects := translate(context.Background(), generate, "hello everyone", "en", []string{"de-DE", "ja-JP", "ru-RU"}, BestEffort)
+	if len(translationErrors) != 0 {
+		t.Fatalf("unexpected errors %v", translationErrors)
+	}
+	if len(prompts["de-DE"]) != 1 || len(prompts["ja-JP"]) != 2 || len(prompts["ru-RU"]) != 2 {
+		t.Errorf("expected only ja-JP and ru-RU to be asked again, got %d, %d and %d prompts", len(prompts["de-DE"]), len(prompts["ja-JP"]), len(prompts["ru-RU"]))
+	}
+	if retry := prompts["ja-JP"][1]; !strings.Contains(retry, "written in Japanese (kana and kanji) script") || !strings.Contains(retry, "in language en") {
+		t.Errorf("expected the retry to name the source language and script, got %q", retry)
+	}
+	if translations["ja-JP"] != "皆さん、こんにちは" || translations["ru-RU"] != "Vsem privet" {
+		t.Errorf("expected the retried translations, got %v", translations)
+	}
+	if len(suspects) != 1 || suspects["ru-RU"] != "not in Cyrillic script" {
+		t.Errorf("expected only ru-RU to stay suspect, got %v", suspects)
+	}
+}
@@ -41,6 +41,8 @@ import (
 	"math/rand"
 
 	genai "google.golang.org/genai"
+
+	"github.com/ghchinoy/babel/pipeline"
 )
 
 var (
@@ -57,44 +59,6 @@ func init() {
 	flag.StringVar(&outputfile, "output", "", "the filename for output")
 	flag.StringVar(&voiceName, "voice", "", "the voice to use, e.g. Zephyr, Puck, Charon, Kore, Fenrir, Leda, Orus, Aoede")
 	flag.BoolVar(&allVoices, "all", false, "generate audio for all voices")
-	flag.Parse()
-}
-
-func getGeminiVoicesMetadata() []VoiceMetadata {
-	geminiVoicesMetadata := []VoiceMetadata{}
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Zephyr",
-		Gender: "Female",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Puck",
-		Gender: "Male",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Charon",
-		Gender: "Male",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Kore",
-		Gender: "Female",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Leda",
-		Gender: "Female",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Fenrir",
-		Gender: "Male",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Orus",
-		Gender: "Male",
-	})
-	geminiVoicesMetadata = append(geminiVoicesMetadata, VoiceMetadata{
-		Name:   "Aoede",
-		Gender: "Female",
-	})
-	return geminiVoicesMetadata
 }
 
 // generateAllAudio uses all the voices
@@ -139,12 +103,12 @@ func generateSingleAudio(ctx context.Context) {
 	}
 }
 
-func geminiSynthesis(ctx context.Context, prompt string, voiceName string, projectID string) []BabelOutput {
+func geminiSynthesis(ctx context.Context, prompt string, voiceName string, projectID string) []pipeline.BabelOutput {
 	// Create a Gemini client.
 	client := createGeminiClient(ctx, projectID)
 
 	voiceList := []string{}
-	outputmetadata := []BabelOutput{}
+	outputmetadata := []pipeline.BabelOutput{}
 
 	// use the single voice if provided
 	if voiceName != "" {
@@ -162,7 +126,7 @@ func geminiSynthesis(ctx context.Context, prompt string, voiceName string, proje
 		if err != nil {
 			log.Printf("unable to generate audio: %v", err)
 		}
-		metadata := BabelOutput{
+		metadata := pipeline.BabelOutput{
 			VoiceName: voiceName,
 			//LanguageCode: "",
 			Text:      prompt,
@@ -194,6 +158,9 @@ func createGeminiClient(ctx context.Context, projectID string) *genai.Client {
 	cc := genai.ClientConfig{
 		Project:  projectID,
 		Location: location,
+		HTTPOptions: genai.HTTPOptions{
+			BaseURL: apiEndpoint,
+		},
 	}
 	client, err := genai.NewClient(ctx, &cc)
 	//client.ClientConfig.Project = projectID
@@ -266,7 +233,7 @@ func generateAudio(ctx context.Context, client *genai.Client, chosenVoice string
 	}
 
 	if result.Candidates[0].FinishReason == "STOP" {
-		timestamp := time.Now().Format(timeformat)
+		timestamp := time.Now().Format(pipeline.TimeFormat)
 		mimeType := result.Candidates[0].Content.Parts[0].InlineData.MIMEType
 		ext := getFileExtensionFromMimeType(mimeType)
 		var filename string
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// alphaVideoEncoder is the encoder of outputs that keep their alpha channel: VP9 in WebM, the
+// transparent video format browsers and most editors read.
+const alphaVideoEncoder = "libvpx-vp9"
+
+// streamAlpha describes the alpha channel of the first video stream of an input.
+type streamAlpha struct {
+	// HasAlpha reports whether the stream carries an alpha channel.
+	HasAlpha bool
+	// Decoder is the decoder that keeps the alpha channel, when FFmpeg's default one drops it:
+	// the native VP8 and VP9 decoders ignore the alpha of a WebM, which only libvpx decodes.
+	// It is empty when the default decoder keeps it.
+	Decoder string
+}
+
+// decoderArgs returns the input options that select the alpha-preserving decoder, if any. They
+// go right before the '-i' of the input.
+func (a streamAlpha) decoderArgs() []string {
+	if a.Decoder == "" {
+		return nil
+	}
+	return []string{"-c:v", a.Decoder}
+}
+
+// pixFmtHasAlpha reports whether a pixel format, as named by ffprobe, has an alpha channel, e.g.
+// yuva420p, yuva444p10le (ProRes 4444), ya8 (grey PNG with alpha), rgba, bgra or gbrap. pal8 is
+// counted too, as palettes of PNGs and GIFs may have transparent entries.
+func pixFmtHasAlpha(pixFmt string) bool {
+	pixFmt = strings.ToLower(pixFmt)
+	for _, prefix := range []string{"yuva", "ya8", "ya16", "gbrap", "rgba", "bgra", "argb", "abgr"} {
+		if strings.HasPrefix(pixFmt, prefix) {
+			return true
+		}
+	}
+	return pixFmt == "pal8"
+}
+
+// parseStreamAlpha reads the alpha channel of the first video stream from the JSON produced by
+// executeGetMediaInfo. VP8 and VP9 store the alpha of a WebM as side data, so ffprobe reports
+// their pixel format without it; the Matroska 'alpha_mode' tag marks those streams instead.
+func parseStreamAlpha(mediaInfoJSON string) (streamAlpha, error) {
+	var info struct {
+		Streams []struct {
+			CodecType string            `json:"codec_type"`
+			CodecName string            `json:"codec_name"`
+			PixFmt    string            `json:"pix_fmt"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return streamAlpha{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	for _, stream := range info.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		for key, value := range stream.Tags {
+			if strings.EqualFold(key, "alpha_mode") && strings.TrimSpace(value) == "1" {
+				switch stream.CodecName {
+				case "vp9":
+					return streamAlpha{HasAlpha: true, Decoder: "libvpx-vp9"}, nil
+				case "vp8":
+					return streamAlpha{HasAlpha: true, Decoder: "libvpx"}, nil
+				}
+				return streamAlpha{HasAlpha: true}, nil
+			}
+		}
+		return streamAlpha{HasAlpha: pixFmtHasAlpha(stream.PixFmt)}, nil
+	}
+	return streamAlpha{}, fmt.Errorf("no video stream found")
+}
+
+// probeStreamAlpha probes the alpha channel of a local input. A file that cannot be probed is
+// treated as opaque, which is how it was overlaid before alpha was detected; FFmpeg reports
+// unreadable inputs itself.
+func probeStreamAlpha(ctx context.Context, localFile, role string) streamAlpha {
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localFile)
+	if err == nil {
+		var alpha streamAlpha
+		if alpha, err = parseStreamAlpha(mediaInfoJSON); err == nil {
+			return alpha
+		}
+	}
+	log.Printf("Could not detect an alpha channel in the %s %s, treating it as opaque: %v", role, localFile, err)
+	return streamAlpha{}
+}
+
+// checkAlphaOutputFileName checks that an output file name, if given, is a WebM file, the only
+// container the alpha-preserving encode writes.
+func checkAlphaOutputFileName(outputFileName string) error {
+	if outputFileName == "" {
+		return nil
+	}
+	if ext := filepath.Ext(outputFileName); !strings.EqualFold(ext, ".webm") {
+		return fmt.Errorf("must end in .webm when output_preserve_alpha is set, as the transparent output is VP9 in WebM, got '%s'", outputFileName)
+	}
+	return nil
+}
+
+// buildOverlayFilter returns the filter graph that places the overlay ([1:v]) on the video
+// ([0:v]) at x,y. An overlay without alpha keeps the plain overlay filter. With alpha, overlay
+// blends in a format picked from both inputs (format=auto), so a 10-bit or RGBA overlay is not
+// squeezed into 8-bit YUV before its edges are blended, and the result is brought back to
+// yuv420p for the MP4. With preserveAlpha, the video is given an alpha plane first, and the
+// result keeps it as yuva420p for the VP9 encode.
+func buildOverlayFilter(x, y int, overlayHasAlpha, preserveAlpha bool) string {
+	switch {
+	case preserveAlpha:
+		return fmt.Sprintf("[0:v]format=yuva420p[base];[base][1:v]overlay=x=%d:y=%d:format=auto,format=yuva420p", x, y)
+	case overlayHasAlpha:
+		return fmt.Sprintf("[0:v][1:v]overlay=x=%d:y=%d:format=auto,format=yuv420p", x, y)
+	default:
+		return fmt.Sprintf("[0:v][1:v]overlay=%d:%d", x, y)
+	}
+}
+
+// buildOverlayArgs returns the FFmpeg arguments that overlay overlayFile on videoFile at x,y.
+// Inputs whose alpha the default decoder would drop are read with the libvpx decoder: the
+// overlay always, and the video when its alpha is preserved. With preserveAlpha, the output is
+// VP9 with a yuva420p alpha plane and Opus audio, for a WebM; -auto-alt-ref 0 is needed as
+// libvpx cannot encode alpha with alternate reference frames.
+func buildOverlayArgs(videoFile, overlayFile, outputFile string, x, y int, video, overlay streamAlpha, preserveAlpha bool) []string {
+	args := []string{"-y"}
+	if preserveAlpha {
+		args = append(args, video.decoderArgs()...)
+	}
+	args = append(args, "-i", videoFile)
+	args = append(args, overlay.decoderArgs()...)
+	args = append(args, "-i", overlayFile, "-filter_complex", buildOverlayFilter(x, y, overlay.HasAlpha, preserveAlpha))
+	if preserveAlpha {
+		args = append(args, "-c:v", alphaVideoEncoder, "-pix_fmt", "yuva420p", "-b:v", "0", "-crf", "30", "-auto-alt-ref", "0", "-c:a", "libopus")
+	}
+	return append(args, outputFile)
+}
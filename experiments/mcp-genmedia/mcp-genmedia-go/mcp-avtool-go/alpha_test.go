@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStreamAlpha(t *testing.T) {
+	testCases := []struct {
+		name     string
+		json     string
+		expected streamAlpha
+	}{
+		{"opaque H.264", `{"streams": [{"codec_type": "video", "codec_name": "h264", "pix_fmt": "yuv420p"}]}`, streamAlpha{}},
+		{"RGBA PNG", `{"streams": [{"codec_type": "video", "codec_name": "png", "pix_fmt": "rgba"}]}`, streamAlpha{HasAlpha: true}},
+		{"grey PNG with alpha", `{"streams": [{"codec_type": "video", "codec_name": "png", "pix_fmt": "ya8"}]}`, streamAlpha{HasAlpha: true}},
+		{"ProRes 4444", `{"streams": [{"codec_type": "video", "codec_name": "prores", "pix_fmt": "yuva444p10le"}, {"codec_type": "audio"}]}`, streamAlpha{HasAlpha: true}},
+		{"VP9 WebM with alpha", `{"streams": [{"codec_type": "video", "codec_name": "vp9", "pix_fmt": "yuv420p", "tags": {"alpha_mode": "1"}}]}`, streamAlpha{HasAlpha: true, Decoder: "libvpx-vp9"}},
+		{"VP8 WebM with alpha", `{"streams": [{"codec_type": "video", "codec_name": "vp8", "pix_fmt": "yuv420p", "tags": {"ALPHA_MODE": "1"}}]}`, streamAlpha{HasAlpha: true, Decoder: "libvpx"}},
+		{"VP9 WebM without alpha", `{"streams": [{"codec_type": "video", "codec_name": "vp9", "pix_fmt": "yuv420p", "tags": {"alpha_mode": "0"}}]}`, streamAlpha{}},
+		{"first video stream only", `{"streams": [{"codec_type": "audio"}, {"codec_type": "video", "pix_fmt": "yuv420p"}, {"codec_type": "video", "pix_fmt": "rgba"}]}`, streamAlpha{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			alpha, err := parseStreamAlpha(tc.json)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if alpha != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, alpha)
+			}
+		})
+	}
+	if _, err := parseStreamAlpha(`{"streams": [{"codec_type": "audio"}]}`); err == nil {
+		t.Error("expected an error for a file without a video stream")
+	}
+}
+
+func TestBuildOverlayArgs(t *testing.T) {
+	opaque := streamAlpha{}
+	png := streamAlpha{HasAlpha: true}
+	webm := streamAlpha{HasAlpha: true, Decoder: "libvpx-vp9"}
+	testCases := []struct {
+		name          string
+		video         streamAlpha
+		overlay       streamAlpha
+		preserveAlpha bool
+		expected      []string
+	}{
+		{
+			name:     "opaque overlay keeps the plain filter",
+			video:    opaque,
+			overlay:  opaque,
+			expected: []string{"-y", "-i", "in.mp4", "-i", "logo.jpg", "-filter_complex", "[0:v][1:v]overlay=10:20", "out.mp4"},
+		},
+		{
+			name:     "PNG with alpha blends in an automatic format",
+			video:    opaque,
+			overlay:  png,
+			expected: []string{"-y", "-i", "in.mp4", "-i", "logo.jpg", "-filter_complex", "[0:v][1:v]overlay=x=10:y=20:format=auto,format=yuv420p", "out.mp4"},
+		},
+		{
+			name:     "VP9 overlay is decoded with libvpx",
+			video:    webm,
+			overlay:  webm,
+			expected: []string{"-y", "-i", "in.mp4", "-c:v", "libvpx-vp9", "-i", "logo.jpg", "-filter_complex", "[0:v][1:v]overlay=x=10:y=20:format=auto,format=yuv420p", "out.mp4"},
+		},
+		{
+			name:          "preserved alpha encodes VP9 with yuva420p",
+			video:         webm,
+			overlay:       png,
+			preserveAlpha: true,
+			expected: []string{"-y", "-c:v", "libvpx-vp9", "-i", "in.mp4", "-i", "logo.jpg", "-filter_complex",
+				"[0:v]format=yuva420p[base];[base][1:v]overlay=x=10:y=20:format=auto,format=yuva420p",
+				"-c:v", "libvpx-vp9", "-pix_fmt", "yuva420p", "-b:v", "0", "-crf", "30", "-auto-alt-ref", "0", "-c:a", "libopus", "out.mp4"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := buildOverlayArgs("in.mp4", "logo.jpg", "out.mp4", 10, 20, tc.video, tc.overlay, tc.preserveAlpha)
+			if !reflect.DeepEqual(args, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, args)
+			}
+		})
+	}
+}
+
+func TestCheckAlphaOutputFileName(t *testing.T) {
+	for _, name := range []string{"", "lower_third.webm", "LOWER_THIRD.WEBM"} {
+		if err := checkAlphaOutputFileName(name); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"lower_third.mp4", "lower_third.mov", "lower_third"} {
+		if err := checkAlphaOutputFileName(name); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The offset detection is a coarse heuristic. Picture cuts in edited footage often fall on a
+// pause or onset in the dialogue, so the scene changes found by FFmpeg's scene score and the
+// silence boundaries found by silencedetect are turned into two pulse trains and
+// cross-correlated. The lag with the strongest correlation is the estimated offset.
+const (
+	// audioSyncStepSecs is the sampling interval of the pulse trains, and so the resolution of the estimate.
+	audioSyncStepSecs = 0.01
+	// audioSyncPulseWidthSecs is the standard deviation of the Gaussian pulse placed at each event.
+	// It absorbs frame quantization of the scene changes and the coarseness of silencedetect.
+	audioSyncPulseWidthSecs = 0.04
+	// audioSyncMinMatchedEvents is how many scene changes must line up with an audio event
+	// before an estimate can be given full confidence.
+	audioSyncMinMatchedEvents = 3
+	// audioSyncSceneThreshold is the scene score above which a frame counts as a scene change.
+	audioSyncSceneThreshold = 0.3
+	// audioSyncSilenceThresholdDB and audioSyncMinSilenceSecs tune silencedetect for speech pauses.
+	audioSyncSilenceThresholdDB = -35.0
+	audioSyncMinSilenceSecs     = 0.15
+)
+
+// sceneChange is a frame whose scene score passed the threshold.
+type sceneChange struct {
+	Time  float64
+	Score float64
+}
+
+var (
+	scenePTSTimeRegex = regexp.MustCompile(`pts_time:\s*(-?[0-9.]+)`)
+	sceneScoreRegex   = regexp.MustCompile(`lavfi\.scene_score=([0-9.]+)`)
+)
+
+// executeDetectSceneChanges selects the frames of the first video stream whose scene score is
+// above threshold and prints their timestamps and scores with the metadata filter.
+func executeDetectSceneChanges(ctx context.Context, localInputVideo string, threshold float64) (string, error) {
+	sceneFilter := fmt.Sprintf("select='gt(scene,%g)',metadata=print", threshold)
+	// metadata=print reports at info level, so pin it regardless of the requested ffmpeg_log_level.
+	return runFFmpegCommand(ctx, "-hide_banner", "-nostats", "-loglevel", "info", "-i", localInputVideo, "-map", "0:v:0", "-vf", sceneFilter, "-f", "null", "-")
+}
+
+// parseSceneChanges reads the scene changes printed by executeDetectSceneChanges. Each frame is
+// reported as a "pts_time:" line followed by its "lavfi.scene_score=" line.
+func parseSceneChanges(output string) []sceneChange {
+	var scenes []sceneChange
+	for _, line := range strings.Split(output, "\n") {
+		if m := scenePTSTimeRegex.FindStringSubmatch(line); m != nil {
+			t, err := strconv.ParseFloat(m[1], 64)
+			if err != nil || t < 0 {
+				continue
+			}
+			scenes = append(scenes, sceneChange{Time: t, Score: 1})
+			continue
+		}
+		if m := sceneScoreRegex.FindStringSubmatch(line); m != nil && len(scenes) > 0 {
+			if score, err := strconv.ParseFloat(m[1], 64); err == nil {
+				scenes[len(scenes)-1].Score = score
+			}
+		}
+	}
+	return scenes
+}
+
+// audioEnvelopeEvents returns the times at which the audio envelope changes: where sound stops
+// (silence_start) or starts again (silence_end). Edges at the very start or end of the file say
+// nothing about sync and are left out.
+func audioEnvelopeEvents(silences []silenceInterval, totalDuration float64) []float64 {
+	var events []float64
+	for _, silence := range silences {
+		if silence.Start > 0 {
+			events = append(events, silence.Start)
+		}
+		if silence.End >= 0 && (totalDuration <= 0 || silence.End < totalDuration-audioSyncStepSecs) {
+			events = append(events, silence.End)
+		}
+	}
+	sort.Float64s(events)
+	return events
+}
+
+// audioSyncEstimate is the outcome of estimateAudioSyncOffset.
+type audioSyncEstimate struct {
+	// OffsetSeconds is the correction to apply; positive delays the audio.
+	OffsetSeconds float64
+	// Confidence is between 0 and 1. It is the margin of the correlation peak over the best
+	// alternative lag, scaled down when fewer than audioSyncMinMatchedEvents scene changes line
+	// up with an audio event, and halved when the peak sits on the edge of the search range.
+	Confidence    float64
+	SceneChanges  int
+	AudioEvents   int
+	Matched       int
+	AtSearchLimit bool
+}
+
+// confidenceLabel buckets the confidence for the tool result.
+func (e audioSyncEstimate) confidenceLabel() string {
+	switch {
+	case e.Confidence >= 0.7:
+		return "high"
+	case e.Confidence >= 0.4:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// estimateAudioSyncOffset cross-correlates the audio events against the scene changes for lags up
+// to maxOffsetSecs either way, and returns the lag that best moves the audio events onto the
+// scene changes.
+func estimateAudioSyncOffset(scenes []sceneChange, audioEvents []float64, totalDuration, maxOffsetSecs float64) (audioSyncEstimate, error) {
+	if len(scenes) == 0 {
+		return audioSyncEstimate{}, fmt.Errorf("no scene changes were detected, so there is nothing to align the audio against")
+	}
+	if len(audioEvents) == 0 {
+		return audioSyncEstimate{}, fmt.Errorf("no pauses or onsets were detected in the audio")
+	}
+
+	sceneTimes := make([]float64, len(scenes))
+	sceneWeights := make([]float64, len(scenes))
+	end := totalDuration
+	for i, scene := range scenes {
+		sceneTimes[i], sceneWeights[i] = scene.Time, scene.Score
+		end = math.Max(end, scene.Time)
+	}
+	for _, event := range audioEvents {
+		end = math.Max(end, event)
+	}
+
+	// Both trains are padded by maxLag samples on each side, so a shifted event never falls off.
+	maxLag := int(math.Round(maxOffsetSecs / audioSyncStepSecs))
+	n := int(math.Ceil(end/audioSyncStepSecs)) + 2*maxLag + 1
+	video := pulseTrain(n, maxLag, sceneTimes, sceneWeights)
+	audio := pulseTrain(n, maxLag, audioEvents, nil)
+
+	corr := make([]float64, 2*maxLag+1)
+	best := 0
+	for k := range corr {
+		lag := k - maxLag
+		sum := 0.0
+		for i := max(0, -lag); i < n && i+lag < n; i++ {
+			sum += audio[i] * video[i+lag]
+		}
+		corr[k] = sum
+		if sum > corr[best] {
+			best = k
+		}
+	}
+	if corr[best] <= 0 {
+		return audioSyncEstimate{}, fmt.Errorf("no audio event lines up with a scene change within %gs", maxOffsetSecs)
+	}
+
+	// The runner-up is the strongest lag clearly apart from the peak, i.e. an alternative alignment.
+	exclusion := int(math.Ceil(3 * audioSyncPulseWidthSecs / audioSyncStepSecs))
+	runnerUp := 0.0
+	for k, c := range corr {
+		if (k < best-exclusion || k > best+exclusion) && c > runnerUp {
+			runnerUp = c
+		}
+	}
+
+	offset := float64(best-maxLag) * audioSyncStepSecs
+	matched := 0
+	for _, sceneTime := range sceneTimes {
+		for _, event := range audioEvents {
+			if math.Abs(event+offset-sceneTime) <= 2*audioSyncPulseWidthSecs {
+				matched++
+				break
+			}
+		}
+	}
+
+	estimate := audioSyncEstimate{
+		OffsetSeconds: math.Round(offset*1000) / 1000,
+		Confidence:    (1 - runnerUp/corr[best]) * math.Min(1, float64(matched)/audioSyncMinMatchedEvents),
+		SceneChanges:  len(scenes),
+		AudioEvents:   len(audioEvents),
+		Matched:       matched,
+		AtSearchLimit: best == 0 || best == len(corr)-1,
+	}
+	if estimate.AtSearchLimit {
+		estimate.Confidence /= 2
+	}
+	return estimate, nil
+}
+
+// pulseTrain samples a sum of Gaussian pulses, one per event, at audioSyncStepSecs intervals.
+// Sample origin is t=0. weights may be nil for unit pulses.
+func pulseTrain(n, origin int, times, weights []float64) []float64 {
+	train := make([]float64, n)
+	reach := int(math.Ceil(4 * audioSyncPulseWidthSecs / audioSyncStepSecs))
+	for j, t := range times {
+		weight := 1.0
+		if weights != nil {
+			weight = weights[j]
+		}
+		center := origin + int(math.Round(t/audioSyncStepSecs))
+		for i := max(0, center-reach); i < n && i <= center+reach; i++ {
+			d := float64(i-origin)*audioSyncStepSecs - t
+			train[i] += weight * math.Exp(-d*d/(2*audioSyncPulseWidthSecs*audioSyncPulseWidthSecs))
+		}
+	}
+	return train
+}
+
+// buildShiftAudioSyncArgs returns the FFmpeg arguments that shift the audio against the picture.
+// The input is opened twice: the video stream is copied from the first, and the audio is taken
+// from the second, whose timestamps -itsoffset moves by offsetSecs. aresample then pads (or
+// trims) the start of the audio so it begins at zero, for players that ignore edit lists.
+func buildShiftAudioSyncArgs(localInputVideo, outputFile string, offsetSecs float64) []string {
+	return []string{"-y", "-i", localInputVideo,
+		"-itsoffset", strconv.FormatFloat(offsetSecs, 'f', 3, 64), "-i", localInputVideo,
+		"-map", "0:v:0", "-map", "1:a:0",
+		"-c:v", "copy",
+		"-af", "aresample=async=1:first_pts=0",
+		"-c:a", "aac",
+		"-shortest", "-movflags", "+faststart",
+		outputFile,
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// syntheticScenes places a scene change with a moderate score at each time.
+func syntheticScenes(times ...float64) []sceneChange {
+	scenes := make([]sceneChange, len(times))
+	for i, t := range times {
+		scenes[i] = sceneChange{Time: t, Score: 0.4 + 0.1*float64(i%3)}
+	}
+	return scenes
+}
+
+// shiftedEvents returns the scene times moved by -offset, i.e. the audio events of a track that
+// needs a correction of +offset, plus any extra (unrelated) events.
+func shiftedEvents(scenes []sceneChange, offset float64, extra ...float64) []float64 {
+	var events []float64
+	for _, scene := range scenes {
+		events = append(events, scene.Time-offset)
+	}
+	return append(events, extra...)
+}
+
+func TestEstimateAudioSyncOffset(t *testing.T) {
+	cuts := syntheticScenes(1.2, 2.9, 4.1, 6.6, 8.0, 9.4)
+
+	t.Run("voice leads the picture", func(t *testing.T) {
+		estimate, err := estimateAudioSyncOffset(cuts, shiftedEvents(cuts, 0.3, 3.5, 7.3), 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if math.Abs(estimate.OffsetSeconds-0.3) > 1e-9 {
+			t.Errorf("expected an offset of +0.3s, got %+.3f", estimate.OffsetSeconds)
+		}
+		if estimate.Matched != 6 || estimate.confidenceLabel() != "high" {
+			t.Errorf("expected all 6 cuts matched with high confidence, got %+v (%s)", estimate, estimate.confidenceLabel())
+		}
+	})
+
+	t.Run("voice lags the picture", func(t *testing.T) {
+		estimate, err := estimateAudioSyncOffset(cuts, shiftedEvents(cuts, -0.25), 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if math.Abs(estimate.OffsetSeconds+0.25) > 1e-9 || estimate.confidenceLabel() != "high" {
+			t.Errorf("expected -0.25s with high confidence, got %+v", estimate)
+		}
+	})
+
+	t.Run("jittered events", func(t *testing.T) {
+		events := shiftedEvents(cuts, 0.2)
+		for i := range events {
+			events[i] += []float64{0.02, -0.03, 0.01, 0.03, -0.02, 0}[i]
+		}
+		estimate, err := estimateAudioSyncOffset(cuts, events, 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if math.Abs(estimate.OffsetSeconds-0.2) > 0.03 || estimate.Confidence < 0.5 {
+			t.Errorf("expected about +0.2s with at least medium confidence, got %+v", estimate)
+		}
+	})
+
+	t.Run("evenly spaced cuts are ambiguous", func(t *testing.T) {
+		var times []float64
+		for t := 1.0; t <= 5.0; t += 0.4 {
+			times = append(times, t)
+		}
+		periodic := syntheticScenes(times...)
+		estimate, err := estimateAudioSyncOffset(periodic, shiftedEvents(periodic, 0.1), 6, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if estimate.confidenceLabel() != "low" {
+			t.Errorf("expected low confidence for a periodic cut pattern, got %+v", estimate)
+		}
+	})
+
+	t.Run("a single matching cut", func(t *testing.T) {
+		single := syntheticScenes(2.0)
+		estimate, err := estimateAudioSyncOffset(single, []float64{1.7}, 4, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if math.Abs(estimate.OffsetSeconds-0.3) > 1e-9 || estimate.confidenceLabel() != "low" {
+			t.Errorf("expected +0.3s with low confidence, got %+v", estimate)
+		}
+	})
+
+	t.Run("offset beyond the search range", func(t *testing.T) {
+		// Just past the limit, the tail of the real peak lands on the edge of the range.
+		estimate, err := estimateAudioSyncOffset(cuts, shiftedEvents(cuts, 0.55), 10, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !estimate.AtSearchLimit || estimate.OffsetSeconds != 0.5 || estimate.confidenceLabel() == "high" {
+			t.Errorf("expected a +0.5s estimate at the search limit without high confidence, got %+v", estimate)
+		}
+		// Well past it, only chance alignments remain.
+		estimate, err = estimateAudioSyncOffset(cuts, shiftedEvents(cuts, 0.8), 10, 0.5)
+		if err == nil && estimate.confidenceLabel() != "low" {
+			t.Errorf("expected low confidence for an out-of-range offset, got %+v", estimate)
+		}
+	})
+
+	t.Run("nothing to correlate", func(t *testing.T) {
+		if _, err := estimateAudioSyncOffset(nil, []float64{1}, 4, 1); err == nil || !strings.Contains(err.Error(), "scene changes") {
+			t.Errorf("expected a no-scene-changes error, got %v", err)
+		}
+		if _, err := estimateAudioSyncOffset(cuts, nil, 10, 1); err == nil || !strings.Contains(err.Error(), "audio") {
+			t.Errorf("expected a no-audio-events error, got %v", err)
+		}
+		if _, err := estimateAudioSyncOffset(syntheticScenes(2.0), []float64{5.0}, 6, 1); err == nil {
+			t.Error("expected an error when no event is within the search range")
+		}
+	})
+}
+
+func TestParseSceneChanges(t *testing.T) {
+	output := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mp4':
+  Duration: 00:00:08.00, start: 0.000000, bitrate: 1200 kb/s
+[Parsed_metadata_1 @ 0x5581] frame:0    pts:30720   pts_time:2.4
+[Parsed_metadata_1 @ 0x5581] lavfi.scene_score=0.512300
+[Parsed_metadata_1 @ 0x5581] frame:1    pts:70656   pts_time:5.52
+[Parsed_metadata_1 @ 0x5581] lavfi.scene_score=0.874000
+`
+	expected := []sceneChange{{Time: 2.4, Score: 0.5123}, {Time: 5.52, Score: 0.874}}
+	if actual := parseSceneChanges(output); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestAudioEnvelopeEvents(t *testing.T) {
+	silences := []silenceInterval{{Start: 0, End: 0.8}, {Start: 2.5, End: 3.1}, {Start: 7.6, End: 8.0}, {Start: 9.2, End: -1}}
+	expected := []float64{0.8, 2.5, 3.1, 7.6, 9.2}
+	if actual := audioEnvelopeEvents(silences, 8.0); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBuildShiftAudioSyncArgs(t *testing.T) {
+	args := strings.Join(buildShiftAudioSyncArgs("in.mp4", "out.mp4", -0.25), " ")
+	expected := "-y -i in.mp4 -itsoffset -0.250 -i in.mp4 -map 0:v:0 -map 1:a:0 -c:v copy -af aresample=async=1:first_pts=0 -c:a aac -shortest -movflags +faststart out.mp4"
+	if args != expected {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// visualizerModeCQT draws frequency bars along the bottom of the frame with showcqt.
+	visualizerModeCQT = "cqt"
+	// visualizerModeVectorscope draws the stereo image in the center of the frame with avectorscope.
+	visualizerModeVectorscope = "vectorscope"
+
+	visualizerFPS          = 30
+	maxVisualizerDimension = 3840
+)
+
+var visualizerModes = []string{visualizerModeCQT, visualizerModeVectorscope}
+
+// visualizerStillImageExtensions are the background files that are looped as a still image; any
+// other background is read as a video and looped to the length of the audio.
+var visualizerStillImageExtensions = []string{".png", ".jpg", ".jpeg", ".webp", ".bmp", ".tif", ".tiff"}
+
+// audioVisualizer is the layout of an ffmpeg_audio_visualizer video.
+type audioVisualizer struct {
+	Mode          string
+	Width, Height int
+	// Color tints the visualization; empty keeps its own colors.
+	Color string
+	// BackgroundColor fills the frame when there is no background file.
+	BackgroundColor string
+	// HasBackground reports whether a background file is the second input.
+	HasBackground bool
+}
+
+// size returns the size of the visualization: bars across the full width and the bottom third
+// of the frame for cqt, and a square of two thirds of the shorter side for vectorscope. Sizes
+// are even, as the filters and yuv420p require.
+func (v audioVisualizer) size() (width, height int) {
+	if v.Mode == visualizerModeVectorscope {
+		side := min(v.Width, v.Height) * 2 / 3 &^ 1
+		return side, side
+	}
+	return v.Width, v.Height / 3 &^ 1
+}
+
+// position returns where the visualization is overlaid: at the bottom for cqt, centered for
+// vectorscope.
+func (v audioVisualizer) position() (x, y int) {
+	width, height := v.size()
+	if v.Mode == visualizerModeVectorscope {
+		return (v.Width - width) / 2, (v.Height - height) / 2
+	}
+	return 0, v.Height - height
+}
+
+// buildAudioVisualizerFilter returns the filter graph of the visualizer video. The audio ([0:a])
+// drives showcqt or avectorscope; the visualization is tinted with Color by multiplying its
+// grey levels with a solid color, then its black background is keyed out so only the bars or
+// traces cover the background. The background is the looped file ([1:v]), scaled and cropped to
+// fill the frame, or a solid BackgroundColor. The overlay ends with the audio. The output is
+// labeled [v].
+func buildAudioVisualizerFilter(v audioVisualizer) string {
+	width, height := v.size()
+	x, y := v.position()
+	var parts []string
+	if v.Mode == visualizerModeVectorscope {
+		parts = append(parts, fmt.Sprintf("[0:a]avectorscope=s=%dx%d:r=%d:draw=line:scale=sqrt[scope]", width, height, visualizerFPS))
+	} else {
+		parts = append(parts, fmt.Sprintf("[0:a]showcqt=s=%dx%d:r=%d:sono_h=0:axis_h=0[scope]", width, height, visualizerFPS))
+	}
+	scope := "[scope]"
+	if v.Color != "" {
+		parts = append(parts,
+			fmt.Sprintf("color=c=%s:s=%dx%d:r=%d,format=gbrp[tint]", v.Color, width, height, visualizerFPS),
+			"[scope]hue=s=0,format=gbrp[grey]",
+			"[grey][tint]blend=all_mode=multiply:shortest=1[tinted]")
+		scope = "[tinted]"
+	}
+	parts = append(parts, scope+"format=rgba,colorkey=black:0.1:0.1[viz]")
+	if v.HasBackground {
+		parts = append(parts, fmt.Sprintf("[1:v]scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,setsar=1,fps=%d[bg]", v.Width, v.Height, v.Width, v.Height, visualizerFPS))
+	} else {
+		parts = append(parts, fmt.Sprintf("color=c=%s:s=%dx%d:r=%d[bg]", v.BackgroundColor, v.Width, v.Height, visualizerFPS))
+	}
+	parts = append(parts, fmt.Sprintf("[bg][viz]overlay=%d:%d:shortest=1:format=auto,format=yuv420p[v]", x, y))
+	return strings.Join(parts, ";")
+}
+
+// buildAudioVisualizerArgs returns the FFmpeg arguments that render the visualizer video as an
+// H.264 MP4 with the audio re-encoded as AAC. A still image background is looped as a single
+// frame and a video background is looped until the audio ends.
+func buildAudioVisualizerArgs(audioFile, backgroundFile, outputFile, filter string) []string {
+	args := []string{"-y", "-i", audioFile}
+	if backgroundFile != "" {
+		if slices.Contains(visualizerStillImageExtensions, strings.ToLower(filepath.Ext(backgroundFile))) {
+			args = append(args, "-loop", "1", "-i", backgroundFile)
+		} else {
+			args = append(args, "-stream_loop", "-1", "-i", backgroundFile)
+		}
+	}
+	return append(args, "-filter_complex", filter, "-map", "[v]", "-map", "0:a",
+		"-c:v", "libx264", "-preset", "medium", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "192k", "-shortest", "-movflags", "+faststart", outputFile)
+}
+
+// addAudioVisualizerTool defines and registers the 'ffmpeg_audio_visualizer' tool.
+// This tool renders a video of an audio track with a visualization that reacts to it.
+func addAudioVisualizerTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_audio_visualizer",
+		mcp.WithDescription("Renders a music video from an audio track: a visualization that reacts to the audio, frequency bars (cqt) or a stereo vectorscope, overlaid on a background image, video or solid color, with the original audio. The output is an H.264 MP4 as long as the audio."),
+		mcp.WithString("audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithString("background_uri", mcp.Description("Optional. URI of a background image or video (local path or gs://), scaled and cropped to fill the frame. A video is looped to the length of the audio. Defaults to a solid background_color.")),
+		mcp.WithString("mode", mcp.DefaultString(visualizerModeCQT), mcp.Enum(visualizerModes...), mcp.Description("Optional. 'cqt' draws frequency bars along the bottom third of the frame (showcqt); 'vectorscope' draws the stereo image in the center (avectorscope). Defaults to 'cqt'.")),
+		mcp.WithString("resolution", mcp.DefaultString("1280x720"), mcp.Description(fmt.Sprintf("Optional. Output size as WIDTHxHEIGHT with even numbers up to %d, e.g. '1080x1920' for portrait. Defaults to '1280x720'.", maxVisualizerDimension))),
+		mcp.WithString("color", mcp.Description("Optional. Color to tint the visualization with, as an FFmpeg color name or hex value (e.g., 'cyan', '#FF0050'). Defaults to the visualization's own colors.")),
+		mcp.WithString("background_color", mcp.DefaultString("black"), mcp.Description("Optional. Background color when there is no background_uri, as an FFmpeg color name or hex value. Defaults to 'black'.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'visualizer.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegAudioVisualizerHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegAudioVisualizerHandler is the handler for the audio visualizer tool.
+// It renders the visualization, background and audio in a single FFmpeg pass.
+func ffmpegAudioVisualizerHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_audio_visualizer")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_audio_visualizer", argsMap)
+
+	audioURI, _ := argsMap["audio_uri"].(string)
+	audioURI = strings.TrimSpace(audioURI)
+	if audioURI == "" {
+		return invalidParamResult("audio_uri", reasonRequired), nil
+	}
+	backgroundURI, _ := argsMap["background_uri"].(string)
+	backgroundURI = strings.TrimSpace(backgroundURI)
+	visualizer := audioVisualizer{Mode: visualizerModeCQT, Width: 1280, Height: 720, BackgroundColor: "black", HasBackground: backgroundURI != ""}
+	if mode, _ := argsMap["mode"].(string); strings.TrimSpace(mode) != "" {
+		visualizer.Mode = strings.ToLower(strings.TrimSpace(mode))
+		if !slices.Contains(visualizerModes, visualizer.Mode) {
+			return invalidParamResult("mode", "must be one of '%s', got '%s'", strings.Join(visualizerModes, "', '"), mode), nil
+		}
+	}
+	if resolution, _ := argsMap["resolution"].(string); strings.TrimSpace(resolution) != "" {
+		var ok bool
+		if visualizer.Width, visualizer.Height, ok = parseResolution(resolution, maxVisualizerDimension); !ok {
+			return invalidParamResult("resolution", "must be WIDTHxHEIGHT with even numbers up to %d, got '%s'", maxVisualizerDimension, resolution), nil
+		}
+	}
+	if width, height := visualizer.size(); width < 16 || height < 16 {
+		return invalidParamResult("resolution", "is too small for the %s visualization, which would be %dx%d", visualizer.Mode, width, height), nil
+	}
+	visualizer.Color, _ = argsMap["color"].(string)
+	visualizer.Color = strings.TrimSpace(visualizer.Color)
+	if visualizer.Color != "" && !ffmpegColorRegex.MatchString(visualizer.Color) {
+		return invalidParamResult("color", "must be an FFmpeg color name or hex value such as 'cyan' or '#FF0050', got '%s'", visualizer.Color), nil
+	}
+	if backgroundColor, _ := argsMap["background_color"].(string); strings.TrimSpace(backgroundColor) != "" {
+		visualizer.BackgroundColor = strings.TrimSpace(backgroundColor)
+		if !ffmpegColorRegex.MatchString(visualizer.BackgroundColor) {
+			return invalidParamResult("background_color", "must be an FFmpeg color name or hex value such as 'black' or '#101820', got '%s'", visualizer.BackgroundColor), nil
+		}
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_audio_visualizer: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	filter := buildAudioVisualizerFilter(visualizer)
+	span.SetAttributes(
+		attribute.String("audio_uri", audioURI),
+		attribute.String("background_uri", backgroundURI),
+		attribute.String("mode", visualizer.Mode),
+		attribute.String("resolution", fmt.Sprintf("%dx%d", visualizer.Width, visualizer.Height)),
+		attribute.String("color", visualizer.Color),
+		attribute.String("filter_complex", filter),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localAudio, audioCleanup, err := prepareInputFile(ctx, audioURI, "input_audio_visualizer", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer audioCleanup()
+	localBackground := ""
+	if visualizer.HasBackground {
+		var backgroundCleanup func()
+		localBackground, backgroundCleanup, err = prepareInputFile(ctx, backgroundURI, "input_background_visualizer", cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare background: %v", err)), nil
+		}
+		defer backgroundCleanup()
+	}
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildAudioVisualizerArgs(localAudio, localBackground, tempOutputFile, filter)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg audio visualizer failed: %v", ffmpegErr)), nil
+	}
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	background := fmt.Sprintf("a %s background", visualizer.BackgroundColor)
+	if visualizer.HasBackground {
+		background = fmt.Sprintf("the background %s", backgroundURI)
+	}
+	messageParts := []string{fmt.Sprintf("%dx%d %s visualizer video rendered over %s in %v.", visualizer.Width, visualizer.Height, visualizer.Mode, background, time.Since(startTime))}
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+
+	span.SetAttributes(attribute.Float64("duration_ms", float64(time.Since(startTime).Milliseconds())))
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
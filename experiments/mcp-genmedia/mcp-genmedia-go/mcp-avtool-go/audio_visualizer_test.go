@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildAudioVisualizerFilter(t *testing.T) {
+	testCases := []struct {
+		name       string
+		visualizer audioVisualizer
+		want       string
+	}{
+		{
+			name:       "cqt bars over a solid color",
+			visualizer: audioVisualizer{Mode: visualizerModeCQT, Width: 1280, Height: 720, BackgroundColor: "black"},
+			want: "[0:a]showcqt=s=1280x240:r=30:sono_h=0:axis_h=0[scope];" +
+				"[scope]format=rgba,colorkey=black:0.1:0.1[viz];" +
+				"color=c=black:s=1280x720:r=30[bg];" +
+				"[bg][viz]overlay=0:480:shortest=1:format=auto,format=yuv420p[v]",
+		},
+		{
+			name:       "tinted vectorscope over a background file",
+			visualizer: audioVisualizer{Mode: visualizerModeVectorscope, Width: 1080, Height: 1920, Color: "#FF0050", BackgroundColor: "black", HasBackground: true},
+			want: "[0:a]avectorscope=s=720x720:r=30:draw=line:scale=sqrt[scope];" +
+				"color=c=#FF0050:s=720x720:r=30,format=gbrp[tint];" +
+				"[scope]hue=s=0,format=gbrp[grey];" +
+				"[grey][tint]blend=all_mode=multiply:shortest=1[tinted];" +
+				"[tinted]format=rgba,colorkey=black:0.1:0.1[viz];" +
+				"[1:v]scale=1080:1920:force_original_aspect_ratio=increase,crop=1080:1920,setsar=1,fps=30[bg];" +
+				"[bg][viz]overlay=180:600:shortest=1:format=auto,format=yuv420p[v]",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := buildAudioVisualizerFilter(tc.visualizer)
+			if got != tc.want {
+				t.Errorf("unexpected filter graph:\n got: %s\nwant: %s", got, tc.want)
+			}
+			// The audio input must feed the visualizer, whose output is overlaid on the background.
+			if !strings.HasPrefix(got, "[0:a]") || !strings.Contains(got, "[bg][viz]overlay") {
+				t.Errorf("expected [0:a] to drive the visualizer overlaid on [bg], got %s", got)
+			}
+		})
+	}
+}
+
+func TestAudioVisualizerSize(t *testing.T) {
+	testCases := []struct {
+		visualizer    audioVisualizer
+		width, height int
+	}{
+		{audioVisualizer{Mode: visualizerModeCQT, Width: 1920, Height: 1080}, 1920, 360},
+		{audioVisualizer{Mode: visualizerModeCQT, Width: 640, Height: 362}, 640, 120},
+		{audioVisualizer{Mode: visualizerModeVectorscope, Width: 1280, Height: 720}, 480, 480},
+		{audioVisualizer{Mode: visualizerModeVectorscope, Width: 1000, Height: 1000}, 666, 666},
+	}
+	for _, tc := range testCases {
+		if width, height := tc.visualizer.size(); width != tc.width || height != tc.height {
+			t.Errorf("%s %dx%d: expected %dx%d, got %dx%d", tc.visualizer.Mode, tc.visualizer.Width, tc.visualizer.Height, tc.width, tc.height, width, height)
+		}
+	}
+}
+
+func TestBuildAudioVisualizerArgs(t *testing.T) {
+	tail := []string{"-filter_complex", "F", "-map", "[v]", "-map", "0:a",
+		"-c:v", "libx264", "-preset", "medium", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "192k", "-shortest", "-movflags", "+faststart", "out.mp4"}
+	testCases := []struct {
+		name       string
+		background string
+		inputs     []string
+	}{
+		{"solid color", "", []string{"-y", "-i", "song.mp3"}},
+		{"still image", "cover.JPG", []string{"-y", "-i", "song.mp3", "-loop", "1", "-i", "cover.JPG"}},
+		{"video", "loop.mp4", []string{"-y", "-i", "song.mp3", "-stream_loop", "-1", "-i", "loop.mp4"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := append(append([]string{}, tc.inputs...), tail...)
+			if got := buildAudioVisualizerArgs("song.mp3", tc.background, "out.mp4", "F"); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
 	"github.com/mark3labs/mcp-go/server"
@@ -35,6 +36,51 @@ func main() {
 
 	cfg := common.LoadConfig()
 
+	if err := resolveFFmpegBinaries(cfg.FFmpegPath, cfg.FFprobePath); err != nil {
+		log.Fatalf("invalid FFmpeg configuration: %v", err)
+	}
+	log.Printf("Using FFmpeg %s and ffprobe %s", ffmpegBinary, ffprobeBinary)
+
+	timeouts, err := loadOperationTimeouts(os.LookupEnv)
+	if err != nil {
+		log.Fatalf("invalid timeout configuration: %v", err)
+	}
+	operationTimeouts = timeouts
+	log.Printf("Time limits: ffprobe %v, FFmpeg %v, GCS transfers %v", timeouts[phaseFFprobe], timeouts[phaseFFmpeg], timeouts[phaseGCSTransfer])
+
+	history, err := loadJobHistory(os.LookupEnv)
+	if err != nil {
+		log.Fatalf("invalid job history configuration: %v", err)
+	}
+	jobs = history
+	if jobs.sink != nil {
+		log.Printf("Job history: last %d jobs, mirrored to %s", jobs.capacity, jobs.prefix)
+	} else {
+		log.Printf("Job history: last %d jobs, in memory only", jobs.capacity)
+	}
+
+	gcsImpersonator = common.NewImpersonatorFromConfig(cfg)
+	if gcsImpersonator.Enabled() {
+		log.Printf("GCS impersonation enabled for %d service account(s)", len(cfg.ImpersonationAllowList))
+	}
+
+	// List the encoders once, so tools can reject profiles the FFmpeg build cannot produce.
+	if encoders, err := probeFFmpegEncoders(context.Background()); err != nil {
+		log.Printf("Could not list the FFmpeg encoders, so encoder-specific profiles are not checked: %v", err)
+	} else {
+		ffmpegEncoders = encoders
+		checkEditorialEncoders(encoders)
+	}
+	// And the filters, so tools with an optional filter can fall back when it is missing.
+	if filters, err := probeFFmpegFilters(context.Background()); err != nil {
+		log.Printf("Could not list the FFmpeg filters, so optional filters are not checked: %v", err)
+	} else {
+		ffmpegFilters = filters
+		if !sofalizerAvailable() {
+			log.Printf("FFmpeg has no sofalizer filter; ffmpeg_downmix_surround makes stereo downmixes of binaural requests.")
+		}
+	}
+
 	// Initialize OpenTelemetry
 	tp, err := common.InitTracerProvider(serviceName, version)
 	if err != nil {
@@ -49,6 +95,11 @@ func main() {
 	s := server.NewMCPServer(
 		"AV Compositing Tool", // More general name
 		version,
+		server.WithToolHandlerMiddleware(jobHistoryMiddleware),
+		server.WithToolHandlerMiddleware(ffmpegLogMiddleware),
+		server.WithToolHandlerMiddleware(timeoutMiddleware),
+		server.WithToolHandlerMiddleware(impersonationMiddleware),
+		server.WithLogging(),
 	)
 
 	// Register tools - these functions are now in mcp_handlers.go
@@ -60,7 +111,40 @@ func main() {
 	addAdjustVolumeTool(s, cfg)
 	addLayerAudioTool(s, cfg)
 	addCreateGifTool(s, cfg)
+	addImagesToGifTool(s, cfg)
 	addGetMediaInfoTool(s, cfg)
+	addGetChaptersTool(s, cfg)
+	addSplitOnSilenceTool(s, cfg)
+	addMakeVoiceNoteTool(s, cfg)
+	addExtractSubtitlesTool(s, cfg)
+	addConcatAudioWithGapsTool(s, cfg)
+	addCompressToSizeTool(s, cfg)
+	addProgressBarTool(s, cfg)
+	addSideBySideTool(s, cfg)
+	addShiftAudioSyncTool(s, cfg)
+	addEqualizerTool(s, cfg)
+	addTonemapHDRToSDRTool(s, cfg)
+	addCountdownOverlayTool(s, cfg)
+	addTrimMediaTool(s, cfg)
+	addPackageHLSTool(s, cfg)
+	addCaptionTextTool(s, cfg)
+	addPitchShiftTool(s, cfg)
+	addDenoiseAudioTool(s, cfg)
+	addKenBurnsTool(s, cfg)
+	addGenerateThumbnailTool(s, cfg)
+	addBlurFillVerticalTool(s, cfg)
+	addDetectAnomaliesTool(s, cfg)
+	addDuckAudioTool(s, cfg)
+	addSpeedRampTool(s, cfg)
+	addMuxSubtitlesTool(s, cfg)
+	addExportEditorialTool(s, cfg)
+	addSetCoverArtTool(s, cfg)
+	addSeamlessLoopTool(s, cfg)
+	addAudioVisualizerTool(s, cfg)
+	addDownmixSurroundTool(s, cfg)
+	addBatchTool(s, cfg)
+	addListJobsTool(s)
+	addGetJobTool(s)
 
 	log.Printf("Starting AV Compositing Tool (avtool) MCP Server (Version: %s, Transport: %s)", version, *transport)
 
@@ -99,5 +183,6 @@ func main() {
 			log.Fatalf("STDIO Server error: %v", err)
 		}
 	}
+	jobs.wait()
 	log.Println("AV Compositing Tool (avtool) Server has stopped.")
 }
\ No newline at end of file
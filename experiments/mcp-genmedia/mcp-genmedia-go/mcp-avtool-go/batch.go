@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultBatchConcurrency = 2
+	maxBatchConcurrency     = 8
+	maxBatchItems           = 100
+)
+
+// batchOperations are the tools ffmpeg_batch can run, by tool name.
+var batchOperations = map[string]func(context.Context, mcp.CallToolRequest, *common.Config) (*mcp.CallToolResult, error){
+	"ffmpeg_get_media_info":           ffmpegGetMediaInfoHandler,
+	"ffmpeg_get_chapters":             ffmpegGetChaptersHandler,
+	"ffmpeg_convert_audio_wav_to_mp3": ffmpegConvertAudioHandler,
+	"ffmpeg_video_to_gif":             ffmpegVideoToGifHandler,
+	"ffmpeg_images_to_gif":            ffmpegImagesToGifHandler,
+	"ffmpeg_combine_audio_and_video":  ffmpegCombineAudioVideoHandler,
+	"ffmpeg_overlay_image_on_video":   ffmpegOverlayImageHandler,
+	"ffmpeg_concatenate_media_files":  ffmpegConcatenateMediaHandler,
+	"ffmpeg_adjust_volume":            ffmpegAdjustVolumeHandler,
+	"ffmpeg_layer_audio_files":        ffmpegLayerAudioHandler,
+	"ffmpeg_split_on_silence":         ffmpegSplitOnSilenceHandler,
+	"ffmpeg_make_voice_note":          ffmpegMakeVoiceNoteHandler,
+	"ffmpeg_extract_subtitles":        ffmpegExtractSubtitlesHandler,
+	"ffmpeg_concat_audio_with_gaps":   ffmpegConcatAudioWithGapsHandler,
+	"ffmpeg_compress_to_size":         ffmpegCompressToSizeHandler,
+	"ffmpeg_progress_bar":             ffmpegProgressBarHandler,
+	"ffmpeg_side_by_side":             ffmpegSideBySideHandler,
+	"ffmpeg_shift_audio_sync":         ffmpegShiftAudioSyncHandler,
+	"ffmpeg_equalizer":                ffmpegEqualizerHandler,
+	"ffmpeg_tonemap_hdr_to_sdr":       ffmpegTonemapHDRToSDRHandler,
+	"ffmpeg_countdown_overlay":        ffmpegCountdownOverlayHandler,
+	"ffmpeg_trim_media":               ffmpegTrimMediaHandler,
+	"ffmpeg_package_hls":              ffmpegPackageHLSHandler,
+	"ffmpeg_caption_text":             ffmpegCaptionTextHandler,
+	"ffmpeg_pitch_shift":              ffmpegPitchShiftHandler,
+	"ffmpeg_denoise_audio":            ffmpegDenoiseAudioHandler,
+	"ffmpeg_ken_burns":                ffmpegKenBurnsHandler,
+	"ffmpeg_generate_thumbnail":       ffmpegGenerateThumbnailHandler,
+	"ffmpeg_blur_fill_vertical":       ffmpegBlurFillVerticalHandler,
+	"ffmpeg_detect_anomalies":         ffmpegDetectAnomaliesHandler,
+	"ffmpeg_duck_audio":               ffmpegDuckAudioHandler,
+	"ffmpeg_speed_ramp":               ffmpegSpeedRampHandler,
+	"ffmpeg_mux_subtitles":            ffmpegMuxSubtitlesHandler,
+	"ffmpeg_export_editorial":         ffmpegExportEditorialHandler,
+	"ffmpeg_set_cover_art":            ffmpegSetCoverArtHandler,
+	"ffmpeg_seamless_loop":            ffmpegSeamlessLoopHandler,
+	"ffmpeg_audio_visualizer":         ffmpegAudioVisualizerHandler,
+	"ffmpeg_downmix_surround":         ffmpegDownmixSurroundHandler,
+}
+
+// batchItemResult is the outcome of one item of an ffmpeg_batch call.
+type batchItemResult struct {
+	Index             int         `json:"index"`
+	Status            string      `json:"status"` // "success" or "error"
+	Message           string      `json:"message"`
+	StructuredContent interface{} `json:"structured_content,omitempty"`
+}
+
+// batchReport is the structured result of ffmpeg_batch.
+type batchReport struct {
+	Operation string            `json:"operation"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []batchItemResult `json:"results"`
+}
+
+// addBatchTool defines and registers the 'ffmpeg_batch' tool.
+// This tool runs one of the other tools over a list of inputs.
+func addBatchTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_batch",
+		mcp.WithDescription("Runs one avtool operation over many inputs in a single call, a few at a time, and reports the outcome of each item. One failing item does not stop the others."),
+		mcp.WithString("operation", mcp.Required(), mcp.Enum(slices.Sorted(maps.Keys(batchOperations))...), mcp.Description("Name of the tool to run for every item, e.g. 'ffmpeg_convert_audio_wav_to_mp3'.")),
+		mcp.WithObject("common_params", mcp.Description("Optional. Arguments shared by every item, as the operation takes them, e.g. {\"output_gcs_bucket\": \"my-bucket\"}.")),
+		mcp.WithArray("inputs", mcp.Required(), mcp.Description(fmt.Sprintf("Per-item arguments (up to %d items). Each object is merged over common_params, so an item's value wins, e.g. [{\"input_audio_uri\": \"gs://b/a.wav\", \"output_file_name\": \"a.mp3\"}]. Give each item its own output_file_name when several write to the same place.", maxBatchItems)), mcp.Items(map[string]any{"type": "object"})),
+		mcp.WithNumber("concurrency", mcp.DefaultNumber(defaultBatchConcurrency), mcp.Min(1), mcp.Max(maxBatchConcurrency), mcp.Description(fmt.Sprintf("Optional. How many items run at the same time (1-%d). Defaults to %d; every item may run FFmpeg, so keep it at or below the server's CPU count.", maxBatchConcurrency, defaultBatchConcurrency))),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegBatchHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegBatchHandler runs the requested operation once per item with bounded concurrency. Each
+// item goes through the same FFmpeg log, timeout and impersonation middleware as a direct call,
+// so 'timeout_seconds', 'ffmpeg_log_level' and 'impersonate_service_account' can be given in
+// common_params or per item; an item without a service account inherits the batch's. The
+// result is successful as long as the batch itself was valid; the status of each item is in
+// the report.
+func ffmpegBatchHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_batch")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_batch", argsMap)
+
+	operation, _ := argsMap["operation"].(string)
+	operation = strings.TrimSpace(operation)
+	if operation == "" {
+		return invalidParamResult("operation", reasonRequired), nil
+	}
+	handler, ok := batchOperations[operation]
+	if !ok {
+		return invalidParamResult("operation", "must be one of the avtool tools other than ffmpeg_batch, got '%s'", operation), nil
+	}
+	commonParams := map[string]interface{}{}
+	if raw, present := argsMap["common_params"]; present && raw != nil {
+		if commonParams, ok = raw.(map[string]interface{}); !ok {
+			return invalidParamResult("common_params", "must be an object, got %T", raw), nil
+		}
+	}
+	rawInputs, _ := argsMap["inputs"].([]interface{})
+	if len(rawInputs) == 0 {
+		return invalidParamResult("inputs", "at least one item is required"), nil
+	}
+	if len(rawInputs) > maxBatchItems {
+		return invalidParamResult("inputs", "at most %d items are allowed, got %d", maxBatchItems, len(rawInputs)), nil
+	}
+	items := make([]map[string]interface{}, len(rawInputs))
+	for i, raw := range rawInputs {
+		input, ok := raw.(map[string]interface{})
+		if !ok {
+			return invalidParamResult(fmt.Sprintf("inputs[%d]", i), "must be an object, got %T", raw), nil
+		}
+		items[i] = mergeBatchArguments(commonParams, input)
+	}
+	concurrency := defaultBatchConcurrency
+	if raw, present := argsMap["concurrency"]; present && raw != nil {
+		n, ok := raw.(float64)
+		if !ok || n != math.Trunc(n) || n < 1 || n > maxBatchConcurrency {
+			return invalidParamResult("concurrency", "must be a whole number from 1 to %d, got %v", maxBatchConcurrency, raw), nil
+		}
+		concurrency = int(n)
+	}
+
+	span.SetAttributes(
+		attribute.String("operation", operation),
+		attribute.Int("items", len(items)),
+		attribute.Int("concurrency", concurrency),
+	)
+
+	itemHandler := impersonationMiddleware(ffmpegLogMiddleware(timeoutMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return handler(ctx, request, cfg)
+	})))
+	results := runBatch(ctx, itemHandler, items, concurrency)
+
+	report := batchReport{Operation: operation, Results: results}
+	for _, r := range results {
+		if r.Status == "success" {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.Int("succeeded", report.Succeeded),
+		attribute.Int("failed", report.Failed),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to encode the batch report: %v", err)), nil
+	}
+	message := fmt.Sprintf("Batch of %d %s item(s) completed in %v: %d succeeded, %d failed. %s",
+		len(items), operation, duration, report.Succeeded, report.Failed, reportJSON)
+	return mcp.NewToolResultStructured(report, message), nil
+}
+
+// mergeBatchArguments returns the arguments of one item: shared overlaid with the item's own.
+func mergeBatchArguments(shared, item map[string]interface{}) map[string]interface{} {
+	args := make(map[string]interface{}, len(shared)+len(item))
+	maps.Copy(args, shared)
+	maps.Copy(args, item)
+	return args
+}
+
+// runBatch calls handler once per item, running at most concurrency calls at a time, and
+// returns the results in item order.
+func runBatch(ctx context.Context, handler server.ToolHandlerFunc, items []map[string]interface{}, concurrency int) []batchItemResult {
+	results := make([]batchItemResult, len(items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, args := range items {
+		wg.Add(1)
+		go func(i int, args map[string]interface{}) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := batchItemResult{Index: i, Status: "error"}
+			if err := ctx.Err(); err != nil {
+				result.Message = fmt.Sprintf("not run: %v", err)
+				results[i] = result
+				return
+			}
+			toolResult, err := handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+			switch {
+			case err != nil:
+				result.Message = err.Error()
+			case toolResult == nil:
+				result.Message = "the operation returned no result"
+			default:
+				result.Message = toolResultText(toolResult)
+				result.StructuredContent = toolResult.StructuredContent
+				if !toolResult.IsError {
+					result.Status = "success"
+				}
+			}
+			log.Printf("Batch item %d: %s", i, result.Status)
+			results[i] = result
+		}(i, args)
+	}
+	wg.Wait()
+	return results
+}
+
+// toolResultText joins the text content of a tool result.
+func toolResultText(result *mcp.CallToolResult) string {
+	var parts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
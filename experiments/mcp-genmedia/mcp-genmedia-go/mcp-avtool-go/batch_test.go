@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestBatchHandlerReportsEachItem(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nfor last; do :; done\necho mp3 > \"$last\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	originalBinary := ffmpegBinary
+	ffmpegBinary = script
+	t.Cleanup(func() { ffmpegBinary = originalBinary })
+
+	input := filepath.Join(dir, "intro.wav")
+	if err := os.WriteFile(input, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"operation":     "ffmpeg_convert_audio_wav_to_mp3",
+		"common_params": map[string]interface{}{"output_local_dir": outDir, "output_file_name": "shared.mp3"},
+		"inputs": []interface{}{
+			map[string]interface{}{"input_audio_uri": input, "output_file_name": "intro.mp3"},
+			map[string]interface{}{"input_audio_uri": filepath.Join(dir, "missing.wav")},
+		},
+	}}}
+	result, err := ffmpegBatchHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+
+	report, ok := result.StructuredContent.(batchReport)
+	if !ok {
+		t.Fatalf("expected a batch report, got %T", result.StructuredContent)
+	}
+	if report.Succeeded != 1 || report.Failed != 1 || len(report.Results) != 2 {
+		t.Fatalf("expected one success and one failure, got %+v", report)
+	}
+	if r := report.Results[0]; r.Index != 0 || r.Status != "success" || !strings.Contains(r.Message, "intro.mp3") {
+		t.Errorf("unexpected first result %+v", r)
+	}
+	if r := report.Results[1]; r.Index != 1 || r.Status != "error" || !strings.Contains(r.Message, "missing.wav") {
+		t.Errorf("unexpected second result %+v", r)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "intro.mp3")); err != nil {
+		t.Errorf("expected the item's own output_file_name to win: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "1 succeeded, 1 failed") {
+		t.Errorf("expected the counts in the result, got %q", text)
+	}
+}
+
+func TestRunBatchBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	release := make(chan struct{})
+	handler := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return mcp.NewToolResultText("done"), nil
+	}
+	done := make(chan []batchItemResult)
+	go func() { done <- runBatch(context.Background(), handler, make([]map[string]interface{}, 5), 2) }()
+	for i := 0; i < 5; i++ {
+		release <- struct{}{}
+	}
+	results := <-done
+	if peak > 2 {
+		t.Errorf("expected at most 2 items at a time, saw %d", peak)
+	}
+	for i, r := range results {
+		if r.Index != i || r.Status != "success" {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+)
+
+// maxConcatListBytes caps the size of an input_list_uri file. Even a list of thousands of
+// gs:// URIs is far below it.
+const maxConcatListBytes = 1 << 20
+
+// concatInput is one input of ffmpeg_concatenate_media_files, with the streams to standardize.
+type concatInput struct {
+	URI     string
+	Streams streamSelection
+}
+
+// parseConcatInputs reads the input_media_uris items. An item is a URI string, or an object with
+// a "uri" and optional "video_stream" and "audio_stream" indices, as reported by
+// ffmpeg_get_media_info.
+func parseConcatInputs(items []interface{}) ([]concatInput, error) {
+	inputs := make([]concatInput, 0, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case string:
+			inputs = append(inputs, concatInput{URI: v, Streams: defaultStreams})
+		case map[string]interface{}:
+			input := concatInput{Streams: defaultStreams}
+			for _, key := range slices.Sorted(maps.Keys(v)) {
+				value := v[key]
+				switch key {
+				case "uri":
+					uri, ok := value.(string)
+					if !ok || strings.TrimSpace(uri) == "" {
+						return nil, fmt.Errorf("item %d: 'uri' must be a non-empty string", i+1)
+					}
+					input.URI = uri
+				case "video_stream", "audio_stream":
+					index, ok := value.(float64)
+					if !ok || index < 0 || index != float64(int(index)) {
+						return nil, fmt.Errorf("item %d: '%s' must be a non-negative integer, got %v", i+1, key, value)
+					}
+					if key == "video_stream" {
+						input.Streams.Video = int(index)
+					} else {
+						input.Streams.Audio = int(index)
+					}
+				default:
+					return nil, fmt.Errorf("item %d: unsupported key '%s'; objects take 'uri', 'video_stream' and 'audio_stream'", i+1, key)
+				}
+			}
+			if input.URI == "" {
+				return nil, fmt.Errorf("item %d: 'uri' is required", i+1)
+			}
+			inputs = append(inputs, input)
+		default:
+			return nil, fmt.Errorf("item %d must be a URI string or an object with a 'uri', got %T", i+1, item)
+		}
+	}
+	return inputs, nil
+}
+
+// readConcatInputList fetches the list file at listURI (local or gs://) and parses it with
+// parseConcatInputList.
+func readConcatInputList(ctx context.Context, listURI, projectID string) ([]string, error) {
+	localPath, cleanup, err := prepareInputFile(ctx, listURI, "concat_input_list", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxConcatListBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConcatListBytes {
+		return nil, fmt.Errorf("the list is larger than %d bytes", maxConcatListBytes)
+	}
+	return parseConcatInputList(string(data))
+}
+
+// parseConcatInputList reads one input URI per line. Surrounding whitespace is trimmed, and blank
+// lines and lines starting with '#' are skipped. Each entry must be a gs://bucket/object URI or a
+// local path, the same as an input_media_uris item; errors name the offending line. A UTF-8
+// byte order mark, as some editors write, is ignored.
+func parseConcatInputList(content string) ([]string, error) {
+	var uris []string
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 4096), maxConcatListBytes)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.IndexFunc(line, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("line %d contains a control character", lineNo)
+		}
+		if strings.HasPrefix(line, "gs://") {
+			if _, _, err := common.ParseGCSPath(line); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+		} else if strings.Contains(line, "://") {
+			return nil, fmt.Errorf("line %d: '%s' is not a gs:// URI or a local path", lineNo, line)
+		}
+		uris = append(uris, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("the list has no input URIs")
+	}
+	return uris, nil
+}
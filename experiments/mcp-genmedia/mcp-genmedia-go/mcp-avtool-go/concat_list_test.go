@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseConcatInputList(t *testing.T) {
+	content := "# spring campaign, in order\n" +
+		"gs://media/clips/001.mp4\n" +
+		"\n" +
+		"   gs://media/clips/002.mp4   \r\n" +
+		"\t\n" +
+		"  # gs://media/clips/skipped.mp4\n" +
+		"/data/outro.mp4\n"
+	got, err := parseConcatInputList(content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"gs://media/clips/001.mp4", "gs://media/clips/002.mp4", "/data/outro.mp4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	errorCases := map[string]string{
+		"# only comments\n\n":                 "the list has no input URIs",
+		"gs://media/a.mp4\ngs://media\n":      "line 2: invalid GCS URI format",
+		"gs://media/a.mp4\nhttps://x/b.mp4\n": "line 2: 'https://x/b.mp4' is not a gs:// URI or a local path",
+		"\n\ngs://media/a.mp4\x00evil\n":      "line 3 contains a control character",
+	}
+	for content, want := range errorCases {
+		if _, err := parseConcatInputList(content); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseConcatInputList(%q): got error %v, want it to contain %q", content, err, want)
+		}
+	}
+}
+
+func TestConcatenateHandlerReportsBadListLine(t *testing.T) {
+	listPath := filepath.Join(t.TempDir(), "inputs.txt")
+	if err := os.WriteFile(listPath, []byte("a.mp4\nftp://host/b.mp4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{"input_list_uri": listPath}}}
+	result, err := ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Invalid parameter 'input_list_uri': line 2: 'ftp://host/b.mp4' is not a gs:// URI or a local path."
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || text != want {
+		t.Errorf("got %q (IsError %t), want %q", text, result.IsError, want)
+	}
+}
+
+// fakeWAVConcatTools installs a fake ffprobe that reports 44.1 kHz 16-bit PCM for files ending in
+// "_44k.wav" and 48 kHz 24-bit PCM for other files, and a fake ffmpeg that logs its arguments,
+// keeps a copy of the concat list it is given and creates its output file.
+func fakeWAVConcatTools(t *testing.T) (commandLog, listCopy string) {
+	t.Helper()
+	dir := t.TempDir()
+	commandLog, listCopy = filepath.Join(dir, "ffmpeg.log"), filepath.Join(dir, "concat_list.txt")
+	ffprobe := "#!/bin/sh\nfor last; do :; done\ncase \"$last\" in\n" +
+		"*_44k.wav) echo '{\"streams\": [{\"codec_type\": \"audio\", \"codec_name\": \"pcm_s16le\", \"sample_rate\": \"44100\", \"channels\": 2}]}' ;;\n" +
+		"*) echo '{\"streams\": [{\"codec_type\": \"audio\", \"codec_name\": \"pcm_s24le\", \"sample_rate\": \"48000\", \"channels\": 2}]}' ;;\n" +
+		"esac\n"
+	ffmpeg := "#!/bin/sh\necho \"$*\" >> '" + commandLog + "'\nprev=''\nconcat=''\nfor a; do\n" +
+		"  if [ \"$prev\" = -f ] && [ \"$a\" = concat ]; then concat=1; fi\n" +
+		"  if [ -n \"$concat\" ] && [ \"$prev\" = -i ]; then cp \"$a\" '" + listCopy + "'; fi\n" +
+		"  prev=\"$a\"\ndone\n: > \"$a\"\n"
+	for name, script := range map[string]string{"ffprobe": ffprobe, "ffmpeg": ffmpeg} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	originalFFmpeg, originalFFprobe := ffmpegBinary, ffprobeBinary
+	ffmpegBinary, ffprobeBinary = filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe")
+	t.Cleanup(func() { ffmpegBinary, ffprobeBinary = originalFFmpeg, originalFFprobe })
+	return commandLog, listCopy
+}
+
+// wavConcatInputs creates empty input files with the given names.
+func wavConcatInputs(t *testing.T, names ...string) []interface{} {
+	t.Helper()
+	dir := t.TempDir()
+	var uris []interface{}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		uris = append(uris, path)
+	}
+	return uris
+}
+
+func TestConcatenateHandlerResamplesMixedWAV(t *testing.T) {
+	commandLog, listCopy := fakeWAVConcatTools(t)
+	inputs := wavConcatInputs(t, "voice_44k.wav", "music_48k.wav", "outro_48k.wav")
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_media_uris": inputs,
+		"output_file_name": "joined.wav",
+		"output_local_dir": t.TempDir(),
+		"auto_resample":    true,
+	}}}
+	result, err := ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result.Content)
+	}
+
+	logData, _ := os.ReadFile(commandLog)
+	commands := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(commands) != 2 {
+		t.Fatalf("expected one resample and one concat command, got %q", commands)
+	}
+	resamplePrefix := "-y -i " + inputs[0].(string) + " -map 0:a:0 -vn -af aresample=resampler=soxr -ar 48000 -ac 2 -c:a pcm_s24le "
+	if !strings.HasPrefix(commands[0], resamplePrefix) || !strings.HasSuffix(commands[0], "/resampled_0.wav") {
+		t.Errorf("unexpected resample command %q", commands[0])
+	}
+	resampledPath := strings.TrimPrefix(commands[0], resamplePrefix)
+	if !strings.HasPrefix(commands[1], "-y -f concat -safe 0 -i ") || !strings.Contains(commands[1], " -c copy ") {
+		t.Errorf("unexpected concat command %q", commands[1])
+	}
+
+	list, _ := os.ReadFile(listCopy)
+	wantList := "file '" + resampledPath + "'\nfile '" + inputs[1].(string) + "'\nfile '" + inputs[2].(string) + "'\n"
+	if string(list) != wantList {
+		t.Errorf("concat list:\n got %q\nwant %q", list, wantList)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if want := "Input 1 (" + inputs[0].(string) + "): resampled to 48000 Hz, 2 ch, pcm_s24le."; !strings.Contains(text, want) {
+		t.Errorf("expected %q in %q", want, text)
+	}
+	if strings.Contains(text, "Input 2") || strings.Contains(text, "Input 3") {
+		t.Errorf("only the 44.1 kHz input should be reported as resampled, got %q", text)
+	}
+}
+
+func TestConcatenateHandlerResamplesToTarget(t *testing.T) {
+	commandLog, _ := fakeWAVConcatTools(t)
+	inputs := wavConcatInputs(t, "voice_44k.wav", "music_48k.wav", "outro_48k.wav")
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_media_uris":   inputs,
+		"output_file_name":   "joined.wav",
+		"auto_resample":      true,
+		"target_sample_rate": 44100.0,
+		"target_channels":    1.0,
+	}}}
+	result, err := ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result.Content)
+	}
+
+	logData, _ := os.ReadFile(commandLog)
+	commands := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(commands) != 4 {
+		t.Fatalf("expected three resample commands and one concat command, got %q", commands)
+	}
+	for i, command := range commands[:3] {
+		if !strings.Contains(command, "-i "+inputs[i].(string)+" ") || !strings.Contains(command, " -ar 44100 -ac 1 -c:a pcm_s24le ") {
+			t.Errorf("unexpected resample command %d: %q", i, command)
+		}
+	}
+}
+
+func TestConcatenateHandlerRejectsMixedWAVWithoutResample(t *testing.T) {
+	commandLog, _ := fakeWAVConcatTools(t)
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_media_uris": wavConcatInputs(t, "voice_44k.wav", "music_48k.wav"),
+		"output_file_name": "joined.wav",
+	}}}
+	result, err := ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "'auto_resample'") {
+		t.Errorf("expected an error suggesting auto_resample, got %q", text)
+	}
+	if _, err := os.Stat(commandLog); !os.IsNotExist(err) {
+		t.Errorf("expected ffmpeg not to run, got %v", err)
+	}
+}
+
+func TestParseConcatInputs(t *testing.T) {
+	got, err := parseConcatInputs([]interface{}{
+		"gs://media/intro.mp4",
+		map[string]interface{}{"uri": "gs://media/interview.mkv", "audio_stream": 2.0},
+		map[string]interface{}{"uri": "/data/broll.mov", "video_stream": 1.0, "audio_stream": 0.0},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []concatInput{
+		{URI: "gs://media/intro.mp4", Streams: defaultStreams},
+		{URI: "gs://media/interview.mkv", Streams: streamSelection{Video: -1, Audio: 2}},
+		{URI: "/data/broll.mov", Streams: streamSelection{Video: 1, Audio: 0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	errorCases := map[string][]interface{}{
+		"item 1: 'uri' is required":                             {map[string]interface{}{"audio_stream": 1.0}},
+		"item 2: 'video_stream' must be a non-negative integer": {"a.mp4", map[string]interface{}{"uri": "b.mkv", "video_stream": -1.0}},
+		"item 1 must be a URI string or an object":              {42.0},
+	}
+	for want, items := range errorCases {
+		if _, err := parseConcatInputs(items); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseConcatInputs(%v): got error %v, want it to contain %q", items, err, want)
+		}
+	}
+}
+
+// fakeMultiTrackTools installs a fake ffprobe that reports an H.264 video stream and English and
+// German audio streams, and a fake ffmpeg that logs its arguments and creates its output file.
+func fakeMultiTrackTools(t *testing.T) (commandLog string) {
+	t.Helper()
+	dir := t.TempDir()
+	commandLog = filepath.Join(dir, "ffmpeg.log")
+	ffprobe := "#!/bin/sh\necho '{\"streams\": [" +
+		"{\"index\": 0, \"codec_type\": \"video\", \"codec_name\": \"h264\", \"r_frame_rate\": \"24/1\", \"avg_frame_rate\": \"24/1\"}, " +
+		"{\"index\": 1, \"codec_type\": \"audio\", \"codec_name\": \"aac\", \"tags\": {\"language\": \"eng\"}}, " +
+		"{\"index\": 2, \"codec_type\": \"audio\", \"codec_name\": \"aac\", \"tags\": {\"language\": \"deu\"}}]}'\n"
+	ffmpeg := "#!/bin/sh\necho \"$*\" >> '" + commandLog + "'\nfor last; do :; done\n: > \"$last\"\n"
+	for name, script := range map[string]string{"ffprobe": ffprobe, "ffmpeg": ffmpeg} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	originalFFmpeg, originalFFprobe := ffmpegBinary, ffprobeBinary
+	ffmpegBinary, ffprobeBinary = filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe")
+	t.Cleanup(func() { ffmpegBinary, ffprobeBinary = originalFFmpeg, originalFFprobe })
+	return commandLog
+}
+
+func TestConcatenateHandlerMapsSelectedStreams(t *testing.T) {
+	commandLog := fakeMultiTrackTools(t)
+	inputs := wavConcatInputs(t, "intro.mp4", "interview.mkv")
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_media_uris": []interface{}{inputs[0], map[string]interface{}{"uri": inputs[1], "audio_stream": 2.0}},
+		"output_file_name": "joined.mp4",
+		"output_local_dir": t.TempDir(),
+	}}}
+	result, err := ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result.Content)
+	}
+
+	logData, _ := os.ReadFile(commandLog)
+	commands := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(commands) != 3 {
+		t.Fatalf("expected two standardize commands and one concat command, got %q", commands)
+	}
+	if strings.Contains(commands[0], "-map") {
+		t.Errorf("expected FFmpeg to pick the streams of the first input, got %q", commands[0])
+	}
+	if want := "-y -i " + inputs[1].(string) + " -map 0:v:0 -map 0:2 -vf "; !strings.HasPrefix(commands[1], want) {
+		t.Errorf("expected the German track to be mapped, got %q", commands[1])
+	}
+
+	// a stream that does not exist is reported with the streams that do, before anything runs
+	os.Remove(commandLog)
+	request.Params.Arguments.(map[string]interface{})["input_media_uris"] = []interface{}{inputs[0], map[string]interface{}{"uri": inputs[1], "audio_stream": 3.0}}
+	result, err = ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Invalid parameter 'input_media_uris': input 2 (" + inputs[1].(string) + "): stream #3 is not an audio stream; available audio streams: #1 (aac, eng), #2 (aac, deu)."
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || text != want {
+		t.Errorf("got %q (IsError %t), want %q", text, result.IsError, want)
+	}
+	if _, err := os.Stat(commandLog); !os.IsNotExist(err) {
+		t.Errorf("expected ffmpeg not to run, got %v", err)
+	}
+}
+
+func TestCombineAudioVideoHandlerChecksAudioStream(t *testing.T) {
+	commandLog := fakeMultiTrackTools(t)
+	inputs := wavConcatInputs(t, "in.mp4", "dub.mkv")
+	args := map[string]interface{}{
+		"input_video_uri":    inputs[0],
+		"input_audio_uri":    inputs[1],
+		"audio_stream_index": 0.0,
+		"output_local_dir":   t.TempDir(),
+	}
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+	result, err := ffmpegCombineAudioVideoHandler(context.Background(), request, &common.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Invalid parameter 'audio_stream_index': stream #0 is not an audio stream; available audio streams: #1 (aac, eng), #2 (aac, deu)."
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || text != want {
+		t.Errorf("got %q (IsError %t), want %q", text, result.IsError, want)
+	}
+
+	args["audio_stream_index"] = 1.0
+	if result, err := ffmpegCombineAudioVideoHandler(context.Background(), request, &common.Config{}); err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result.Content)
+	}
+	logData, _ := os.ReadFile(commandLog)
+	if !strings.Contains(string(logData), " -map 0 -map 1:1 -c:v copy ") {
+		t.Errorf("expected the English track to be mapped, got %q", logData)
+	}
+}
+
+func TestConcatenateHandlerNormalizesLoudness(t *testing.T) {
+	commandLog := fakeMultiTrackTools(t)
+	inputs := wavConcatInputs(t, "studio.mp4", "phone.mkv", "drone.mp4")
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_media_uris":   inputs,
+		"normalize_loudness": true,
+		"target_lufs":        -14.0,
+		"output_file_name":   "joined.mp4",
+		"output_local_dir":   t.TempDir(),
+	}}}
+	result, err := ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result.Content)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Inputs loudness-normalized to -14 LUFS.") {
+		t.Errorf("expected the loudness target in the result, got %q", text)
+	}
+
+	logData, _ := os.ReadFile(commandLog)
+	commands := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(commands) != len(inputs)+1 {
+		t.Fatalf("expected %d standardize commands and one concat command, got %q", len(inputs), commands)
+	}
+	for i, command := range commands[:len(inputs)] {
+		if !strings.Contains(command, " -af loudnorm=I=-14:TP=-1.5:LRA=11 ") {
+			t.Errorf("standardize command %d has no loudnorm filter: %q", i+1, command)
+		}
+	}
+	if strings.Contains(commands[len(inputs)], "loudnorm") {
+		t.Errorf("the concat command should copy the normalized streams, got %q", commands[len(inputs)])
+	}
+
+	// the WAV path joins PCM without re-encoding, so there is nothing to normalize
+	request.Params.Arguments.(map[string]interface{})["output_file_name"] = "joined.wav"
+	result, err = ffmpegConcatenateMediaHandler(context.Background(), request, &common.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Invalid parameter 'normalize_loudness': applies only when inputs are standardized, not to WAV output."
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || text != want {
+		t.Errorf("got %q (IsError %t), want %q", text, result.IsError, want)
+	}
+}
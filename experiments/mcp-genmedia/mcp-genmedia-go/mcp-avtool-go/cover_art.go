@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// coverArtContainers are the audio containers ffmpeg_set_cover_art writes, by file extension,
+// with the muxer arguments each needs for the picture to be read as cover art. MP3 stores it as
+// an ID3v2 APIC frame; version 3 tags are the ones most players and podcast apps read, and the
+// "Cover (front)" comment sets the APIC picture type. MP4 (M4A) stores it as a 'covr' atom,
+// which the attached_pic disposition alone is enough for.
+var coverArtContainers = map[string][]string{
+	".mp3": {"-id3v2_version", "3", "-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)"},
+	".m4a": nil,
+}
+
+// coverArtImageCodec returns the codec the cover image is written with: JPEG and PNG images are
+// copied, as both containers take them as they are, and other images are encoded as JPEG.
+func coverArtImageCodec(imageFile string) string {
+	switch strings.ToLower(filepath.Ext(imageFile)) {
+	case ".jpg", ".jpeg", ".png":
+		return "copy"
+	}
+	return "mjpeg"
+}
+
+// buildCoverArtArgs returns the FFmpeg arguments that write the audio streams of audioFile,
+// unchanged, with imageFile as the cover art of outputFile. Any cover art audioFile already has
+// is not mapped, so the new image replaces it. container is a key of coverArtContainers.
+func buildCoverArtArgs(audioFile, imageFile, outputFile, container string) []string {
+	args := []string{
+		"-y", "-i", audioFile, "-i", imageFile,
+		"-map", "0:a", "-map", "1:v:0",
+		"-c:a", "copy", "-c:v", coverArtImageCodec(imageFile),
+		"-disposition:v", "attached_pic",
+	}
+	args = append(args, coverArtContainers[container]...)
+	return append(args, outputFile)
+}
+
+// addSetCoverArtTool defines and registers the 'ffmpeg_set_cover_art' tool.
+// This tool embeds an image in an MP3 or M4A file as its cover art.
+func addSetCoverArtTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_set_cover_art",
+		mcp.WithDescription("Embeds an image as the cover art of an MP3 or M4A audio file, as podcast and music distribution expect. The audio is copied without re-encoding."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file, an .mp3 or .m4a (local path or gs://).")),
+		mcp.WithString("image_uri", mcp.Required(), mcp.Description("URI of the cover image (local path or gs://). JPEG and PNG images are embedded as they are; other formats are converted to JPEG.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output file, with the input's extension. Defaults to a generated name.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output to.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegSetCoverArtHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegSetCoverArtHandler is the handler for the cover art tool.
+// The output is in the container of the input audio, which picks the cover art flags.
+func ffmpegSetCoverArtHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_set_cover_art")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_set_cover_art", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	inputAudioURI = strings.TrimSpace(inputAudioURI)
+	if inputAudioURI == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	container := strings.ToLower(filepath.Ext(inputAudioURI))
+	if _, ok := coverArtContainers[container]; !ok {
+		return invalidParamResult("input_audio_uri", "must be an .mp3 or .m4a file, got '%s'", inputAudioURI), nil
+	}
+	imageURI, _ := argsMap["image_uri"].(string)
+	imageURI = strings.TrimSpace(imageURI)
+	if imageURI == "" {
+		return invalidParamResult("image_uri", reasonRequired), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputFileName = strings.TrimSpace(outputFileName)
+	if ext := filepath.Ext(outputFileName); outputFileName != "" && !strings.EqualFold(ext, container) {
+		return invalidParamResult("output_file_name", "must end in %s, the input's container, got '%s'", container, outputFileName), nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_set_cover_art: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.String("image_uri", imageURI),
+		attribute.String("container", strings.TrimPrefix(container, ".")),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, audioCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_cover_art", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer audioCleanup()
+
+	localImage, imageCleanup, err := prepareInputFile(ctx, imageURI, "input_image_cover_art", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare cover image: %v", err)), nil
+	}
+	defer imageCleanup()
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, strings.TrimPrefix(container, "."))
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildCoverArtArgs(localInputAudio, localImage, tempOutputFile, container)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg cover art embedding failed: %v", ffmpegErr)), nil
+	}
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	messageParts := []string{fmt.Sprintf("Cover art embedded in %s audio in %v.", strings.ToUpper(strings.TrimPrefix(container, ".")), time.Since(startTime))}
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+
+	span.SetAttributes(attribute.Float64("duration_ms", float64(time.Since(startTime).Milliseconds())))
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
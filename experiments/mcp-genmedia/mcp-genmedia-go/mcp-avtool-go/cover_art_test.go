@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestBuildCoverArtArgs(t *testing.T) {
+	testCases := []struct {
+		name      string
+		image     string
+		output    string
+		container string
+		want      string
+	}{
+		{"mp3 with jpeg", "cover.jpg", "out.mp3", ".mp3", "-y -i in -i cover.jpg -map 0:a -map 1:v:0 -c:a copy -c:v copy -disposition:v attached_pic -id3v2_version 3 -metadata:s:v title=Album cover -metadata:s:v comment=Cover (front) out.mp3"},
+		{"m4a with png", "cover.PNG", "out.m4a", ".m4a", "-y -i in -i cover.PNG -map 0:a -map 1:v:0 -c:a copy -c:v copy -disposition:v attached_pic out.m4a"},
+		{"m4a with webp", "cover.webp", "out.m4a", ".m4a", "-y -i in -i cover.webp -map 0:a -map 1:v:0 -c:a copy -c:v mjpeg -disposition:v attached_pic out.m4a"},
+	}
+	for _, tc := range testCases {
+		if got := strings.Join(buildCoverArtArgs("in", tc.image, tc.output, tc.container), " "); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSetCoverArtHandlerMapsAttachedPicture(t *testing.T) {
+	commandLog := fakeMultiTrackTools(t)
+	inputs := wavConcatInputs(t, "episode.m4a", "cover.jpg")
+	outputDir := t.TempDir()
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_audio_uri":  inputs[0],
+		"image_uri":        inputs[1],
+		"output_file_name": "episode_cover.m4a",
+		"output_local_dir": outputDir,
+	}}}
+	result, err := ffmpegSetCoverArtHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result.Content)
+	}
+
+	logData, _ := os.ReadFile(commandLog)
+	command := strings.TrimSpace(string(logData))
+	for _, want := range []string{"-map 0:a -map 1:v:0", "-c:a copy", "-disposition:v attached_pic"} {
+		if !strings.Contains(command, want) {
+			t.Errorf("expected %q in %q", want, command)
+		}
+	}
+	if strings.Contains(command, "-id3v2_version") {
+		t.Errorf("expected no ID3 options for M4A output, got %q", command)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "episode_cover.m4a")); err != nil {
+		t.Errorf("expected the output in the local directory: %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// downmixStereo folds the surround channels into two with pan coefficients.
+	downmixStereo = "stereo"
+	// downmixBinaural renders the surround channels for headphones with sofalizer and an HRTF.
+	downmixBinaural = "binaural"
+)
+
+var downmixLayouts = []string{downmixStereo, downmixBinaural}
+
+// surroundLayouts are the channels, in FFmpeg's order, of the multichannel layouts
+// ffmpeg_downmix_surround takes, by the name ffprobe reports as channel_layout.
+var surroundLayouts = map[string][]string{
+	"3.0":        {"FL", "FR", "FC"},
+	"quad":       {"FL", "FR", "BL", "BR"},
+	"quad(side)": {"FL", "FR", "SL", "SR"},
+	"4.0":        {"FL", "FR", "FC", "BC"},
+	"5.0":        {"FL", "FR", "FC", "BL", "BR"},
+	"5.0(side)":  {"FL", "FR", "FC", "SL", "SR"},
+	"5.1":        {"FL", "FR", "FC", "LFE", "BL", "BR"},
+	"5.1(side)":  {"FL", "FR", "FC", "LFE", "SL", "SR"},
+	"6.1":        {"FL", "FR", "FC", "LFE", "BC", "SL", "SR"},
+	"7.1":        {"FL", "FR", "FC", "LFE", "BL", "BR", "SL", "SR"},
+}
+
+// defaultSurroundLayouts are the layouts FFmpeg assumes for a channel count when a file does not
+// name its layout, as some WAV and raw AAC files do not.
+var defaultSurroundLayouts = map[int]string{6: "5.1", 8: "7.1"}
+
+// stereoDownmixGains are the gains, before normalization, of each channel in the left and right
+// outputs of the stereo downmix, after ITU-R BS.775: the center and the surrounds go to their
+// side at -3 dB, and a back center to both sides at -6 dB. The LFE channel is dropped, as
+// headphones reproduce the low end of the main channels and adding it muddies the mix.
+var stereoDownmixGains = map[string][2]float64{
+	"FL": {1, 0},
+	"FR": {0, 1},
+	"FC": {math.Sqrt2 / 2, math.Sqrt2 / 2},
+	"BL": {math.Sqrt2 / 2, 0},
+	"BR": {0, math.Sqrt2 / 2},
+	"SL": {math.Sqrt2 / 2, 0},
+	"SR": {0, math.Sqrt2 / 2},
+	"BC": {0.5, 0.5},
+}
+
+// ffmpegFilters is the set of filters in the FFmpeg build, listed by probeFFmpegFilters at
+// startup. It is nil when the probe did not run or failed; optional filters such as sofalizer are
+// then tried regardless, and a missing filter shows up as an FFmpeg error.
+var ffmpegFilters map[string]bool
+
+// probeFFmpegFilters lists the filters of the FFmpeg build with 'ffmpeg -filters'.
+func probeFFmpegFilters(ctx context.Context) (map[string]bool, error) {
+	output, err := runFFmpegCommand(ctx, "-hide_banner", "-filters")
+	if err != nil {
+		return nil, err
+	}
+	filters := parseFFmpegFilters(output)
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("no filters found in the output of 'ffmpeg -filters'")
+	}
+	return filters, nil
+}
+
+// parseFFmpegFilters reads the filter names from the output of 'ffmpeg -filters', where each
+// filter is a line of capability flags, its name and its input and output types, such as
+// "A->A". The legend above the list has no such types.
+func parseFFmpegFilters(output string) map[string]bool {
+	filters := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.Contains(fields[2], "->") {
+			continue
+		}
+		filters[fields[1]] = true
+	}
+	return filters
+}
+
+// sofalizerAvailable reports whether the FFmpeg build has the sofalizer filter, which needs
+// libmysofa. When the filters could not be listed it is assumed to be there.
+func sofalizerAvailable() bool {
+	return ffmpegFilters == nil || ffmpegFilters["sofalizer"]
+}
+
+// surroundChannels returns the layout and channels of a multichannel audio stream. A stream
+// without a named layout is given FFmpeg's default layout for its channel count.
+func surroundChannels(format audioFormat) (string, []string, error) {
+	if format.Channels <= 2 {
+		return "", nil, fmt.Errorf("the input has %d channel(s), so there is nothing to downmix", format.Channels)
+	}
+	layout := strings.ToLower(strings.TrimSpace(format.ChannelLayout))
+	if layout == "" || layout == "unknown" {
+		layout = defaultSurroundLayouts[format.Channels]
+	}
+	channels, ok := surroundLayouts[layout]
+	if !ok || len(channels) != format.Channels {
+		return "", nil, fmt.Errorf("the input's channel layout '%s' (%d channels) is not supported; supported layouts are %s",
+			format.ChannelLayout, format.Channels, strings.Join(slices.Sorted(maps.Keys(surroundLayouts)), ", "))
+	}
+	return layout, channels, nil
+}
+
+// buildStereoDownmixFilter returns the pan filter that folds the channels into stereo with
+// stereoDownmixGains. The gains of each output are scaled to add up to 1, as FFmpeg does for its
+// own -ac 2 downmix, so the downmix cannot clip even when every channel is at full scale.
+func buildStereoDownmixFilter(channels []string) string {
+	outputs := []string{"pan=stereo"}
+	for side, name := range []string{"FL", "FR"} {
+		var total float64
+		for _, channel := range channels {
+			total += stereoDownmixGains[channel][side]
+		}
+		var terms []string
+		for _, channel := range channels {
+			if gain := stereoDownmixGains[channel][side]; gain > 0 {
+				terms = append(terms, fmt.Sprintf("%.4f*%s", gain/total, channel))
+			}
+		}
+		outputs = append(outputs, name+"="+strings.Join(terms, "+"))
+	}
+	return strings.Join(outputs, "|")
+}
+
+// buildBinauralFilter returns the sofalizer filter that renders the input's channels for
+// headphones with the HRTF of a SOFA file. The path must pass filterPathSafe.
+func buildBinauralFilter(localSOFAFile string) string {
+	return fmt.Sprintf("sofalizer=sofa='%s':type=freq", escapeFilterPath(localSOFAFile))
+}
+
+// buildDownmixArgs returns the FFmpeg arguments that downmix the first audio stream of the input
+// with the filter to two channels at the input's sample rate; the WAV codec is chosen by
+// pcmOutputCodec.
+func buildDownmixArgs(localInputAudio, outputFile, filter string, format audioFormat, sampleFormat string) []string {
+	args := []string{"-y", "-i", localInputAudio, "-map", "0:a:0", "-af", filter,
+		"-ac", "2", "-ar", strconv.Itoa(format.SampleRate)}
+	if codec := pcmOutputCodec(strings.TrimPrefix(filepath.Ext(outputFile), "."), format, sampleFormat); codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	return append(args, outputFile)
+}
+
+// addDownmixSurroundTool defines and registers the 'ffmpeg_downmix_surround' tool.
+// This tool turns a surround mix into two channels for headphone delivery.
+func addDownmixSurroundTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_downmix_surround",
+		mcp.WithDescription("Downmixes multichannel audio, such as a 5.1 or 7.1 mix, to two channels for headphone delivery: a stereo downmix with standard pan coefficients, or a binaural rendering with FFmpeg's sofalizer and an HRTF. The input's channel layout is detected with ffprobe. The output keeps the input's format and sample rate."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio or video file with a multichannel audio stream (local path or gs://). The first audio stream is downmixed.")),
+		mcp.WithString("target_layout", mcp.DefaultString(downmixStereo), mcp.Enum(downmixLayouts...), mcp.Description("Optional. 'stereo' folds the center and surrounds into the left and right channels at -3 dB and drops the LFE; 'binaural' renders every channel from its position with an HRTF, so the surround image is kept on headphones, and needs sofa_uri. Binaural falls back to the stereo downmix when the FFmpeg build has no sofalizer filter. Defaults to 'stereo'.")),
+		mcp.WithString("sofa_uri", mcp.Description("Optional. URI (local path or gs://) of a SOFA file with the HRTF for the 'binaural' target_layout, e.g. one from the SOFA conventions database. Required for 'binaural'.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output audio file. Defaults to the input's format.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output audio file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output audio file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegDownmixSurroundHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegDownmixSurroundHandler is the handler for the surround downmix tool.
+// It probes the input's channel layout, then downmixes it with pan or sofalizer.
+func ffmpegDownmixSurroundHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_downmix_surround")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_downmix_surround", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	inputAudioURI = strings.TrimSpace(inputAudioURI)
+	if inputAudioURI == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	targetLayout, _ := argsMap["target_layout"].(string)
+	targetLayout = strings.ToLower(strings.TrimSpace(targetLayout))
+	if targetLayout == "" {
+		targetLayout = downmixStereo
+	}
+	if !slices.Contains(downmixLayouts, targetLayout) {
+		return invalidParamResult("target_layout", "must be one of '%s', got '%s'", strings.Join(downmixLayouts, "', '"), targetLayout), nil
+	}
+	sofaURI, _ := argsMap["sofa_uri"].(string)
+	sofaURI = strings.TrimSpace(sofaURI)
+	if targetLayout == downmixBinaural && sofaURI == "" {
+		return invalidParamResult("sofa_uri", "a SOFA file is required for the 'binaural' target_layout"), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_downmix_surround: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	// Without sofalizer, a binaural request still gets a downmix its listener can use.
+	var notes []string
+	if targetLayout == downmixBinaural && !sofalizerAvailable() {
+		log.Printf("Handler ffmpeg_downmix_surround: FFmpeg has no sofalizer filter, falling back to a stereo downmix")
+		notes = append(notes, "This FFmpeg build has no sofalizer filter, so a stereo downmix was made instead of a binaural one.")
+		targetLayout = downmixStereo
+	}
+
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.String("target_layout", targetLayout),
+		attribute.String("sofa_uri", sofaURI),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_downmix", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputAudio)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input audio: %v", err)), nil
+	}
+	format, err := parseAudioFormat(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input audio format: %v", err)), nil
+	}
+	layout, channels, err := surroundChannels(format)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Cannot downmix %s: %v", inputAudioURI, err)), nil
+	}
+
+	filter := buildStereoDownmixFilter(channels)
+	if targetLayout == downmixBinaural {
+		localSOFAFile, sofaCleanup, err := prepareInputFile(ctx, sofaURI, "sofa_hrtf", cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare SOFA file: %v", err)), nil
+		}
+		defer sofaCleanup()
+		if !filterPathSafe(localSOFAFile) {
+			return invalidParamResult("sofa_uri", "the path must not contain quotes or backslashes, got '%s'", localSOFAFile), nil
+		}
+		filter = buildBinauralFilter(localSOFAFile)
+	}
+	span.SetAttributes(attribute.String("input_channel_layout", layout), attribute.String("filter", filter))
+
+	defaultOutputExt := "mp3"
+	inputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputAudio), "."))
+	switch inputExt {
+	case "wav", "mp3", "aac", "m4a", "ogg", "flac":
+		defaultOutputExt = inputExt
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, defaultOutputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildDownmixArgs(localInputAudio, tempOutputFile, filter, format, sampleFormat)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg downmix failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	target := "stereo"
+	if targetLayout == downmixBinaural {
+		target = fmt.Sprintf("binaural with the HRTF %s", sofaURI)
+	}
+	messageParts := []string{fmt.Sprintf("Downmixed %s audio (%d channels) to %s in %v.", layout, format.Channels, target, duration)}
+	messageParts = append(messageParts, notes...)
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
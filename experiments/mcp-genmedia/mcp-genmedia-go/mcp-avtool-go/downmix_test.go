@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildStereoDownmixFilter(t *testing.T) {
+	testCases := []struct {
+		layout string
+		want   string
+	}{
+		// FL and FR at 1, FC and the surrounds at -3 dB (0.7071), scaled by 1/2.4142 so each side
+		// adds up to 1; the LFE is dropped.
+		{"5.1", "pan=stereo|FL=0.4142*FL+0.2929*FC+0.2929*BL|FR=0.4142*FR+0.2929*FC+0.2929*BR"},
+		{"5.1(side)", "pan=stereo|FL=0.4142*FL+0.2929*FC+0.2929*SL|FR=0.4142*FR+0.2929*FC+0.2929*SR"},
+		{"7.1", "pan=stereo|FL=0.3204*FL+0.2265*FC+0.2265*BL+0.2265*SL|FR=0.3204*FR+0.2265*FC+0.2265*BR+0.2265*SR"},
+		{"4.0", "pan=stereo|FL=0.4531*FL+0.3204*FC+0.2265*BC|FR=0.4531*FR+0.3204*FC+0.2265*BC"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.layout, func(t *testing.T) {
+			if got := buildStereoDownmixFilter(surroundLayouts[tc.layout]); got != tc.want {
+				t.Errorf("unexpected filter:\n got: %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSurroundChannels(t *testing.T) {
+	testCases := []struct {
+		name       string
+		format     audioFormat
+		wantLayout string
+		wantErr    string
+	}{
+		{"named", audioFormat{Channels: 6, ChannelLayout: "5.1(side)"}, "5.1(side)", ""},
+		{"unknown six channels", audioFormat{Channels: 6, ChannelLayout: "unknown"}, "5.1", ""},
+		{"unnamed eight channels", audioFormat{Channels: 8}, "7.1", ""},
+		{"stereo", audioFormat{Channels: 2, ChannelLayout: "stereo"}, "", "nothing to downmix"},
+		{"unsupported", audioFormat{Channels: 8, ChannelLayout: "7.1(wide)"}, "", "channel layout '7.1(wide)' (8 channels) is not supported"},
+		{"unnamed five channels", audioFormat{Channels: 5}, "", "channel layout '' (5 channels) is not supported"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			layout, channels, err := surroundChannels(tc.format)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if layout != tc.wantLayout || len(channels) != tc.format.Channels {
+				t.Errorf("expected %s with %d channels, got %s %v", tc.wantLayout, tc.format.Channels, layout, channels)
+			}
+		})
+	}
+}
+
+func TestParseFFmpegFilters(t *testing.T) {
+	output := `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  ..C = Command support
+  A = Audio input/output
+  | = Source or sink filter
+ TSC acompressor       A->A       Audio compressor.
+ ... pan               A->A       Remix channels with coefficients (panning).
+ .S. sofalizer         A->A       SOFAlizer (Spatially Oriented Format for Acoustics).
+ ... anullsrc          |->A       Null audio source, return empty audio frames.
+`
+	want := map[string]bool{"acompressor": true, "pan": true, "sofalizer": true, "anullsrc": true}
+	if got := parseFFmpegFilters(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBuildDownmixArgs(t *testing.T) {
+	format := audioFormat{SampleRate: 48000, Channels: 6, CodecName: "pcm_s24le"}
+	want := []string{"-y", "-i", "in.wav", "-map", "0:a:0", "-af", "sofalizer=sofa='/tmp/c\\:d.sofa':type=freq",
+		"-ac", "2", "-ar", "48000", "-c:a", "pcm_s24le", "out.wav"}
+	if got := buildDownmixArgs("in.wav", "out.wav", buildBinauralFilter("/tmp/c:d.sofa"), format, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/teris-io/shortid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	pngSequenceProfile = "png_sequence"
+	// editorialAudioCodec is the PCM codec of the audio tracks in the MOV profiles: 24-bit, as
+	// editing and finishing applications expect.
+	editorialAudioCodec = "pcm_s24le"
+)
+
+// editorialProfile is a MOV export profile of ffmpeg_export_editorial.
+type editorialProfile struct {
+	// Encoder is the FFmpeg video encoder the profile needs.
+	Encoder string
+	// VideoArgs are the video encoding arguments.
+	VideoArgs []string
+	// Description is how the profile is named in results.
+	Description string
+}
+
+// editorialProfiles are the MOV profiles, by name. prores_ks numbers the ProRes profiles 0 (Proxy)
+// to 5 (4444 XQ); 2 is standard 422, written as 10-bit 4:2:2, and 4 is 4444, written as 10-bit
+// 4:4:4 with an alpha channel, which is kept when the input has one. The apl0 vendor tag makes
+// the files read as Apple-encoded ProRes in tools that check for it.
+var editorialProfiles = map[string]editorialProfile{
+	"prores_422": {
+		Encoder:     "prores_ks",
+		VideoArgs:   []string{"-c:v", "prores_ks", "-profile:v", "2", "-pix_fmt", "yuv422p10le", "-vendor", "apl0"},
+		Description: "ProRes 422",
+	},
+	"prores_4444": {
+		Encoder:     "prores_ks",
+		VideoArgs:   []string{"-c:v", "prores_ks", "-profile:v", "4", "-pix_fmt", "yuva444p10le", "-vendor", "apl0"},
+		Description: "ProRes 4444",
+	},
+}
+
+// ffmpegEncoders is the set of encoders in the FFmpeg build, listed by probeFFmpegEncoders at
+// startup. It is nil when the probe did not run or failed; the profiles are then offered
+// regardless, and a missing encoder shows up as an FFmpeg error.
+var ffmpegEncoders map[string]bool
+
+// probeFFmpegEncoders lists the encoders of the FFmpeg build with 'ffmpeg -encoders'.
+func probeFFmpegEncoders(ctx context.Context) (map[string]bool, error) {
+	output, err := runFFmpegCommand(ctx, "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, err
+	}
+	encoders := parseFFmpegEncoders(output)
+	if len(encoders) == 0 {
+		return nil, fmt.Errorf("no encoders found in the output of 'ffmpeg -encoders'")
+	}
+	return encoders, nil
+}
+
+// parseFFmpegEncoders reads the encoder names from the output of 'ffmpeg -encoders', where each
+// encoder is a line of six capability flags, starting with V, A or S, followed by its name. The
+// legend above the list ("V..... = Video") has the same shape but an '=' for a name.
+func parseFFmpegEncoders(output string) map[string]bool {
+	encoders := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields[0]) != 6 || !strings.ContainsRune("VAS", rune(fields[0][0])) || fields[1] == "=" {
+			continue
+		}
+		encoders[fields[1]] = true
+	}
+	return encoders
+}
+
+// checkEditorialEncoders logs, at startup, which editorial profiles the FFmpeg build cannot produce.
+func checkEditorialEncoders(encoders map[string]bool) {
+	for _, name := range slices.Sorted(maps.Keys(editorialProfiles)) {
+		if encoder := editorialProfiles[name].Encoder; !encoders[encoder] {
+			log.Printf("FFmpeg has no %s encoder; ffmpeg_export_editorial profile %s is unavailable.", encoder, name)
+		}
+	}
+}
+
+// buildEditorialMOVArgs returns the FFmpeg arguments that export the first video stream and all
+// audio streams of inputFile as a MOV in the given profile, with the audio as PCM.
+func buildEditorialMOVArgs(inputFile, outputFile string, profile editorialProfile) []string {
+	args := []string{"-y", "-i", inputFile, "-map", "0:v:0", "-map", "0:a?"}
+	args = append(args, profile.VideoArgs...)
+	return append(args, "-c:a", editorialAudioCodec, outputFile)
+}
+
+// pngSequencePattern is the FFmpeg image2 pattern of a PNG sequence: the sequence name and a
+// six-digit frame number starting at 1, e.g. shot_000001.png.
+func pngSequencePattern(name string) string {
+	return name + "_%06d.png"
+}
+
+// buildPNGSequenceArgs returns the FFmpeg arguments that write every frame of the first video
+// stream of inputFile to outputDir as a numbered PNG.
+func buildPNGSequenceArgs(inputFile, outputDir, name string) []string {
+	return []string{"-y", "-i", inputFile, "-map", "0:v:0", "-c:v", "png", filepath.Join(outputDir, pngSequencePattern(name))}
+}
+
+// addExportEditorialTool defines and registers the 'ffmpeg_export_editorial' tool.
+// This tool exports a video in the formats editing and finishing applications take.
+func addExportEditorialTool(s *server.MCPServer, cfg *common.Config) {
+	profiles := append(slices.Sorted(maps.Keys(editorialProfiles)), pngSequenceProfile)
+	tool := mcp.NewTool("ffmpeg_export_editorial",
+		mcp.WithDescription("Exports a video for editorial handoff and finishing in applications such as Premiere or Resolve: as a ProRes MOV, or as a PNG image sequence."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithString("profile", mcp.Required(), mcp.Enum(profiles...), mcp.Description("'prores_422' and 'prores_4444' write a MOV with 10-bit ProRes video (4444 keeps an alpha channel) and every audio track as 24-bit PCM. 'png_sequence' writes one PNG per frame, without audio.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output MOV file (ProRes profiles only).")),
+		mcp.WithString("output_sequence_name", mcp.Description("Optional. Name of the PNG sequence (png_sequence only). Frames are written to a directory of this name, created under output_local_dir and used as the object prefix in the GCS buckets, as <name>_000001.png, <name>_000002.png and so on. Letters, digits, '.', '_' and '-' only. Defaults to 'frames_<id>'.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output to.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegExportEditorialHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegExportEditorialHandler is the handler for the editorial export tool.
+// ProRes profiles write a single MOV; png_sequence writes a directory of frames and uploads it
+// under the sequence name.
+func ffmpegExportEditorialHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_export_editorial")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_export_editorial", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	profileName, _ := argsMap["profile"].(string)
+	profileName = strings.TrimSpace(profileName)
+	profile, isMOV := editorialProfiles[profileName]
+	if !isMOV && profileName != pngSequenceProfile {
+		return invalidParamResult("profile", "must be one of 'prores_422', 'prores_4444', 'png_sequence', got '%s'", profileName), nil
+	}
+	if isMOV && ffmpegEncoders != nil && !ffmpegEncoders[profile.Encoder] {
+		return mcp.NewToolResultError(fmt.Sprintf("Profile '%s' needs FFmpeg's %s encoder, which this FFmpeg build does not have. Install an FFmpeg build with %s, or use 'png_sequence'.", profileName, profile.Encoder, profile.Encoder)), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputFileName = strings.TrimSpace(outputFileName)
+	sequenceName, _ := argsMap["output_sequence_name"].(string)
+	sequenceName = strings.TrimSpace(sequenceName)
+	if isMOV {
+		if sequenceName != "" {
+			return invalidParamResult("output_sequence_name", "only applies to the png_sequence profile; use output_file_name for '%s'", profileName), nil
+		}
+		if ext := filepath.Ext(outputFileName); outputFileName != "" && !strings.EqualFold(ext, ".mov") {
+			return invalidParamResult("output_file_name", "must end in .mov for the '%s' profile, got '%s'", profileName, outputFileName), nil
+		}
+	} else {
+		if outputFileName != "" {
+			return invalidParamResult("output_file_name", "does not apply to the png_sequence profile; use output_sequence_name"), nil
+		}
+		if sequenceName == "" {
+			uid, _ := shortid.Generate()
+			sequenceName = fmt.Sprintf("frames_%s", uid)
+		} else if !packageNameRegex.MatchString(sequenceName) || sequenceName == "." || sequenceName == ".." {
+			return invalidParamResult("output_sequence_name", "must contain only letters, digits, '.', '_' and '-', got '%s'", sequenceName), nil
+		}
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_export_editorial: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.String("profile", profileName),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_sequence_name", sequenceName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_editorial", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	var messageParts []string
+	if isMOV {
+		tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mov")
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+		}
+		defer outputCleanup()
+
+		if _, ffmpegErr := runFFmpegCommand(ctx, buildEditorialMOVArgs(localInputVideo, tempOutputFile, profile)...); ffmpegErr != nil {
+			span.RecordError(ffmpegErr)
+			return mcp.NewToolResultError(fmt.Sprintf("FFMpeg %s export failed: %v", profile.Description, ffmpegErr)), nil
+		}
+		finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+		if processErr != nil {
+			span.RecordError(processErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+		}
+		finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+		messageParts = append(messageParts, fmt.Sprintf("%s MOV export completed in %v, with audio as 24-bit PCM.", profile.Description, time.Since(startTime)))
+		if outputLocalDir != "" && finalLocalPath != "" {
+			messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+		} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+			messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+		}
+		if finalGCSPath != "" {
+			messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+		}
+		if gcsUploadIssues != "" {
+			messageParts = append(messageParts, gcsUploadIssues)
+		}
+	} else {
+		sequenceDir, err := os.MkdirTemp("", "png_sequence_")
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output directory: %v", err)), nil
+		}
+		defer os.RemoveAll(sequenceDir)
+
+		if _, ffmpegErr := runFFmpegCommand(ctx, buildPNGSequenceArgs(localInputVideo, sequenceDir, sequenceName)...); ffmpegErr != nil {
+			span.RecordError(ffmpegErr)
+			return mcp.NewToolResultError(fmt.Sprintf("FFMpeg PNG sequence export failed: %v", ffmpegErr)), nil
+		}
+		localSequenceDir := ""
+		if outputLocalDir != "" {
+			localSequenceDir = filepath.Join(outputLocalDir, sequenceName)
+		}
+		files, gcsUploads, processErr := processOutputDirToBuckets(ctx, sequenceDir, localSequenceDir, outputGCSBuckets, sequenceName, cfg.ProjectID)
+		if processErr != nil {
+			span.RecordError(processErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+		}
+		_, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+		var totalBytes int64
+		for _, f := range files {
+			totalBytes += f.Size
+		}
+		span.SetAttributes(attribute.Int("frame_count", len(files)))
+
+		messageParts = append(messageParts, fmt.Sprintf("PNG sequence of %d frames (%s) exported in %v, named %s.", len(files), common.FormatBytes(totalBytes), time.Since(startTime), pngSequencePattern(sequenceName)))
+		if localSequenceDir != "" {
+			messageParts = append(messageParts, fmt.Sprintf("Frames saved locally to: %s.", localSequenceDir))
+		}
+		for _, upload := range gcsUploads {
+			if upload.Err == nil {
+				messageParts = append(messageParts, fmt.Sprintf("Frames uploaded to GCS: %s.", upload.GCSPath))
+			}
+		}
+		if gcsUploadIssues != "" {
+			messageParts = append(messageParts, gcsUploadIssues)
+		}
+		if outputLocalDir == "" && len(outputGCSBuckets) == 0 {
+			messageParts = append(messageParts, "No output location requested; the frames were only written to temporary files.")
+		}
+	}
+
+	span.SetAttributes(attribute.Float64("duration_ms", float64(time.Since(startTime).Milliseconds())))
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
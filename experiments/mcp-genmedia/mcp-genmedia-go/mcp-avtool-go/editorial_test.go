@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseFFmpegEncoders(t *testing.T) {
+	output := `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ VF...D prores_ks            Apple ProRes (iCodec Pro) (codec prores)
+ V....D png                  PNG (Portable Network Graphics) image
+ A....D pcm_s24le            PCM signed 24-bit little-endian
+`
+	encoders := parseFFmpegEncoders(output)
+	for _, name := range []string{"libx264", "prores_ks", "png", "pcm_s24le"} {
+		if !encoders[name] {
+			t.Errorf("expected %s in %v", name, encoders)
+		}
+	}
+	if len(encoders) != 4 {
+		t.Errorf("expected only the 4 encoders, got %v", encoders)
+	}
+}
+
+func TestBuildEditorialMOVArgs(t *testing.T) {
+	testCases := map[string]string{
+		"prores_422":  "-y -i in.mp4 -map 0:v:0 -map 0:a? -c:v prores_ks -profile:v 2 -pix_fmt yuv422p10le -vendor apl0 -c:a pcm_s24le out.mov",
+		"prores_4444": "-y -i in.mp4 -map 0:v:0 -map 0:a? -c:v prores_ks -profile:v 4 -pix_fmt yuva444p10le -vendor apl0 -c:a pcm_s24le out.mov",
+	}
+	for name, want := range testCases {
+		if got := strings.Join(buildEditorialMOVArgs("in.mp4", "out.mov", editorialProfiles[name]), " "); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if len(editorialProfiles) != len(testCases) {
+		t.Errorf("expected a test case for every profile, got %d profiles", len(editorialProfiles))
+	}
+}
+
+func TestBuildPNGSequenceArgs(t *testing.T) {
+	if got, want := pngSequencePattern("shot_010"), "shot_010_%06d.png"; got != want {
+		t.Errorf("got pattern %q, want %q", got, want)
+	}
+	want := "-y -i in.mp4 -map 0:v:0 -c:v png " + filepath.Join("/tmp/seq", "shot_010_%06d.png")
+	if got := strings.Join(buildPNGSequenceArgs("in.mp4", "/tmp/seq", "shot_010"), " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportEditorialPNGSequence(t *testing.T) {
+	dir := t.TempDir()
+	// the fake ffmpeg writes three frames named after the pattern it is given
+	script := "#!/bin/sh\nfor last; do :; done\nfor i in 1 2 3; do echo png > \"$(printf \"$last\" $i)\"; done\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	originalBinary := ffmpegBinary
+	ffmpegBinary = filepath.Join(dir, "ffmpeg")
+	t.Cleanup(func() { ffmpegBinary = originalBinary })
+
+	input := filepath.Join(dir, "shot.mp4")
+	if err := os.WriteFile(input, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_video_uri":      input,
+		"profile":              "png_sequence",
+		"output_sequence_name": "shot_010",
+		"output_local_dir":     outDir,
+	}}}
+	result, err := ffmpegExportEditorialHandler(context.Background(), request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	for _, frame := range []string{"shot_010_000001.png", "shot_010_000002.png", "shot_010_000003.png"} {
+		if _, err := os.Stat(filepath.Join(outDir, "shot_010", frame)); err != nil {
+			t.Errorf("expected frame %s: %v", frame, err)
+		}
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "PNG sequence of 3 frames") {
+		t.Errorf("expected the frame count in the result, got %q", text)
+	}
+}
+
+func TestExportEditorialRequiresProResEncoder(t *testing.T) {
+	originalEncoders := ffmpegEncoders
+	ffmpegEncoders = map[string]bool{"libx264": true, "png": true}
+	t.Cleanup(func() { ffmpegEncoders = originalEncoders })
+
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_video_uri": "in.mp4",
+		"profile":         "prores_4444",
+	}}}
+	result, err := ffmpegExportEditorialHandler(context.Background(), request, &common.Config{})
+	if err != nil || !result.IsError {
+		t.Fatalf("expected an error result, got %v %+v", err, result)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "needs FFmpeg's prores_ks encoder") {
+		t.Errorf("unexpected error %q", text)
+	}
+}
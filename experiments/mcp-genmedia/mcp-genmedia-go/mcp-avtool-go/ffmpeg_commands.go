@@ -1,24 +1,85 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
+// ffmpegBinary is the FFMpeg executable run by runFFmpegCommand. It is a variable so tests
+// can substitute a fake command.
+var ffmpegBinary = "ffmpeg"
+
+// resolveFFmpegBinaries checks that the configured FFmpeg and ffprobe executables exist and
+// runs them from their resolved paths from then on. A bare name is looked up in PATH.
+func resolveFFmpegBinaries(ffmpegPath, ffprobePath string) error {
+	resolvedFFmpeg, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return fmt.Errorf("FFmpeg executable not usable (set FFMPEG_PATH to its location): %w", err)
+	}
+	resolvedFFprobe, err := exec.LookPath(ffprobePath)
+	if err != nil {
+		return fmt.Errorf("ffprobe executable not usable (set FFPROBE_PATH to its location): %w", err)
+	}
+	ffmpegBinary, ffprobeBinary = resolvedFFmpeg, resolvedFFprobe
+	return nil
+}
+
 // runFFmpegCommand executes an FFMpeg command with the given arguments.
+// The command is killed if it runs past the FFmpeg time limit (see effectiveTimeout).
 // It logs the command being executed and captures the combined stdout and stderr.
+// When the MCP client has set its log level to debug, stderr lines are also streamed to it
+// as they are written (see ffmpegLogStreamer).
 // If the command fails, it logs the error and the output, then returns an error.
 // Otherwise, it logs the last few lines of the output for brevity and returns the full output.
 func runFFmpegCommand(ctx context.Context, args ...string) (string, error) {
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	logOptions := ffmpegLogOptionsFromContext(ctx)
+	if logOptions != nil {
+		args = applyFFmpegLogLevel(args, logOptions.Level)
+	}
+	op := startOperation(ctx, phaseFFmpeg)
+	defer op.cancel()
+	cmd := exec.CommandContext(op.ctx, ffmpegBinary, args...)
+	cmd.WaitDelay = subprocessWaitDelay
 	log.Printf("Running FFMpeg command: ffmpeg %s", strings.Join(args, " "))
 
-	output, err := cmd.CombinedOutput()
+	var combined syncBuffer
+	cmd.Stdout = &combined
+	cmd.Stderr = &combined
+	streamer := ffmpegLogStreamerFromContext(ctx)
+	if streamer != nil {
+		// stderr is scanned as FFMpeg writes it, while still being buffered for the error tail
+		cmd.Stderr = io.MultiWriter(&combined, streamer)
+	}
+	err := cmd.Run()
+	if streamer != nil {
+		streamer.Close()
+	}
+	output := combined.Bytes()
+	if logOptions != nil {
+		logOptions.record(args, string(output))
+	}
+	if timeout := op.timeoutErr(); timeout != nil {
+		log.Printf("FFMpeg command killed: %v\nFFMpeg Output:\n%s", timeout, string(output))
+		return string(output), timeout
+	}
 	if err != nil {
 		log.Printf("FFMpeg command failed. Error: %v\nFFMpeg Output:\n%s", err, string(output))
 		return string(output), fmt.Errorf("ffmpeg command failed: %w. Output: %s", err, string(output))
@@ -27,6 +88,182 @@ func runFFmpegCommand(ctx context.Context, args ...string) (string, error) {
 	return string(output), nil
 }
 
+// ffmpegLogLevels are the values accepted for the 'ffmpeg_log_level' tool parameter.
+var ffmpegLogLevels = []string{"error", "warning", "info", "debug"}
+
+// ffmpegLogOptions carries the per-request FFMpeg logging settings and collects the
+// output of every FFMpeg command run while handling that request.
+type ffmpegLogOptions struct {
+	Level          string
+	IncludeFullLog bool
+
+	mu   sync.Mutex
+	logs []string
+}
+
+type ffmpegLogOptionsKey struct{}
+
+// withFFmpegLogOptions returns a context that makes runFFmpegCommand apply and record to opts.
+func withFFmpegLogOptions(ctx context.Context, opts *ffmpegLogOptions) context.Context {
+	return context.WithValue(ctx, ffmpegLogOptionsKey{}, opts)
+}
+
+// ffmpegLogOptionsFromContext returns the logging options for the current request, if any.
+func ffmpegLogOptionsFromContext(ctx context.Context) *ffmpegLogOptions {
+	opts, _ := ctx.Value(ffmpegLogOptionsKey{}).(*ffmpegLogOptions)
+	return opts
+}
+
+func (o *ffmpegLogOptions) record(args []string, output string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.logs = append(o.logs, fmt.Sprintf("$ ffmpeg %s\n%s", strings.Join(args, " "), output))
+}
+
+// fullLog returns the captured output of all FFMpeg commands run so far.
+func (o *ffmpegLogOptions) fullLog() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return strings.Join(o.logs, "\n")
+}
+
+// applyFFmpegLogLevel prepends '-loglevel <level>' to the arguments. Commands that already
+// set their own log level (because they parse FFMpeg's output) are left unchanged.
+func applyFFmpegLogLevel(args []string, level string) []string {
+	if level == "" {
+		return args
+	}
+	for _, arg := range args {
+		if arg == "-loglevel" || arg == "-v" {
+			return args
+		}
+	}
+	return append([]string{"-loglevel", level}, args...)
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent writes. FFMpeg's stdout and
+// stderr are copied by separate goroutines once stderr is also streamed.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// Limits for streaming FFMpeg log lines to the client. They are variables so tests can
+// shorten or lengthen them.
+var (
+	// ffmpegLogStreamMaxLines is how many lines are sent per ffmpegLogStreamInterval;
+	// the rest are counted and reported as skipped.
+	ffmpegLogStreamMaxLines = 20
+	ffmpegLogStreamInterval = time.Second
+	// ffmpegLogStreamMaxLineBytes truncates long lines, such as FFMpeg's stream dumps.
+	ffmpegLogStreamMaxLineBytes = 512
+)
+
+// ffmpegLogStreamer is an io.Writer that splits FFMpeg's stderr into lines and passes them
+// to send, rate-limited and truncated. Both '\n' and '\r' end a line, since FFMpeg rewrites
+// its progress line with carriage returns.
+type ffmpegLogStreamer struct {
+	send func(line string)
+	now  func() time.Time
+
+	mu          sync.Mutex
+	partial     []byte
+	windowStart time.Time
+	sent        int
+	skipped     int
+}
+
+func newFFmpegLogStreamer(send func(line string)) *ffmpegLogStreamer {
+	return &ffmpegLogStreamer{send: send, now: time.Now}
+}
+
+// ffmpegLogStreamerFromContext returns a streamer that sends FFMpeg log lines to the current
+// MCP client as debug-level logging notifications, or nil when the client has not asked for
+// debug logging. Notifications go through the server's session, which serializes them with
+// the JSON-RPC responses, so stdio framing is never interleaved with raw output.
+func ffmpegLogStreamerFromContext(ctx context.Context) *ffmpegLogStreamer {
+	mcpServer := server.ServerFromContext(ctx)
+	session, ok := server.ClientSessionFromContext(ctx).(server.SessionWithLogging)
+	if mcpServer == nil || !ok || !session.Initialized() || !mcp.LoggingLevelDebug.ShouldSendTo(session.GetLogLevel()) {
+		return nil
+	}
+	return newFFmpegLogStreamer(func(line string) {
+		notification := mcp.NewLoggingMessageNotification(mcp.LoggingLevelDebug, "ffmpeg", line)
+		if err := mcpServer.SendLogMessageToClient(ctx, notification); err != nil {
+			log.Printf("Failed to stream FFMpeg log line: %v", err)
+		}
+	})
+}
+
+func (s *ffmpegLogStreamer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range p {
+		if c == '\n' || c == '\r' {
+			s.emit()
+			continue
+		}
+		// keep one byte past the limit so emit knows the line was cut
+		if len(s.partial) <= ffmpegLogStreamMaxLineBytes {
+			s.partial = append(s.partial, c)
+		}
+	}
+	return len(p), nil
+}
+
+// Close sends any unterminated last line and reports lines skipped by the rate limit.
+func (s *ffmpegLogStreamer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emit()
+	s.reportSkipped()
+	return nil
+}
+
+func (s *ffmpegLogStreamer) emit() {
+	line := strings.TrimSpace(string(s.partial))
+	truncated := len(s.partial) > ffmpegLogStreamMaxLineBytes
+	s.partial = s.partial[:0]
+	if line == "" {
+		return
+	}
+	if truncated {
+		line = strings.ToValidUTF8(line[:min(len(line), ffmpegLogStreamMaxLineBytes)], "") + " [truncated]"
+	}
+
+	now := s.now()
+	if s.windowStart.IsZero() || now.Sub(s.windowStart) >= ffmpegLogStreamInterval {
+		s.reportSkipped()
+		s.windowStart = now
+		s.sent = 0
+	}
+	if s.sent >= ffmpegLogStreamMaxLines {
+		s.skipped++
+		return
+	}
+	s.sent++
+	s.send(line)
+}
+
+func (s *ffmpegLogStreamer) reportSkipped() {
+	if s.skipped > 0 {
+		s.send(fmt.Sprintf("[%d FFMpeg log lines skipped by the rate limit]", s.skipped))
+		s.skipped = 0
+	}
+}
+
 // Note: Specific ffmpeg command functions (like convertAudioToMP3, createGIF etc.) will be added here later.
 // For now, this file only contains the generic runFFmpegCommand.
 // The handlers in mcp_handlers.go will still call runFFmpegCommand directly in this phase.
@@ -34,3 +271,1722 @@ func runFFmpegCommand(ctx context.Context, args ...string) (string, error) {
 // func executeConvertAudioToMP3(ctx context.Context, localInputAudio, tempOutputFile string) (string, error) {
 // 	 return runFFmpegCommand(ctx, "-y", "-i", localInputAudio, "-acodec", "libmp3lame", tempOutputFile)
 // }
+
+// silenceInterval is a stretch of silence reported by FFMpeg's silencedetect filter.
+// End is negative when the silence runs to the end of the input.
+type silenceInterval struct {
+	Start float64
+	End   float64
+}
+
+// mediaSegment is a time range, in seconds, within an input file.
+type mediaSegment struct {
+	Start float64
+	End   float64
+}
+
+var (
+	silenceStartRegex   = regexp.MustCompile(`silence_start:\s*(-?[0-9.]+)`)
+	silenceEndRegex     = regexp.MustCompile(`silence_end:\s*(-?[0-9.]+)`)
+	ffmpegDurationRegex = regexp.MustCompile(`Duration:\s*(\d+):(\d+):(\d+(?:\.\d+)?)`)
+)
+
+// executeDetectSilence runs the silencedetect filter over the input and returns
+// FFMpeg's combined output, which carries the silence markers on stderr.
+func executeDetectSilence(ctx context.Context, localInputAudio string, thresholdDB, minSilenceDuration float64) (string, error) {
+	silenceFilter := fmt.Sprintf("silencedetect=noise=%gdB:d=%g", thresholdDB, minSilenceDuration)
+	// silencedetect reports at info level, so pin it regardless of the requested ffmpeg_log_level.
+	return runFFmpegCommand(ctx, "-hide_banner", "-nostats", "-loglevel", "info", "-i", localInputAudio, "-af", silenceFilter, "-f", "null", "-")
+}
+
+// parseSilenceDetectOutput extracts the silence intervals from silencedetect's stderr.
+// A silence_start without a matching silence_end is returned with End set to -1.
+func parseSilenceDetectOutput(output string) []silenceInterval {
+	var intervals []silenceInterval
+	open := false
+	for _, line := range strings.Split(output, "\n") {
+		if m := silenceStartRegex.FindStringSubmatch(line); m != nil {
+			start, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			if start < 0 {
+				start = 0
+			}
+			intervals = append(intervals, silenceInterval{Start: start, End: -1})
+			open = true
+			continue
+		}
+		if m := silenceEndRegex.FindStringSubmatch(line); m != nil && open {
+			end, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			intervals[len(intervals)-1].End = end
+			open = false
+		}
+	}
+	return intervals
+}
+
+// parseFFmpegDuration reads the input duration from the "Duration: HH:MM:SS.xx"
+// line FFMpeg prints when it opens a file.
+func parseFFmpegDuration(output string) (float64, bool) {
+	m := ffmpegDurationRegex.FindStringSubmatch(output)
+	if m == nil {
+		return 0, false
+	}
+	hours, _ := strconv.ParseFloat(m[1], 64)
+	minutes, _ := strconv.ParseFloat(m[2], 64)
+	seconds, _ := strconv.ParseFloat(m[3], 64)
+	return hours*3600 + minutes*60 + seconds, true
+}
+
+// computeNonSilentSegments returns the ranges between the detected silences.
+// Segments shorter than minSegmentDuration are dropped so that brief blips
+// between two silences do not become their own files.
+func computeNonSilentSegments(silences []silenceInterval, totalDuration, minSegmentDuration float64) []mediaSegment {
+	var segments []mediaSegment
+	cursor := 0.0
+	for _, silence := range silences {
+		if silence.Start-cursor >= minSegmentDuration {
+			segments = append(segments, mediaSegment{Start: cursor, End: silence.Start})
+		}
+		if silence.End < 0 {
+			return segments
+		}
+		cursor = silence.End
+	}
+	if totalDuration-cursor >= minSegmentDuration {
+		segments = append(segments, mediaSegment{Start: cursor, End: totalDuration})
+	}
+	return segments
+}
+
+const (
+	// voiceNoteMinBitrateKbps is the lowest Opus bitrate that still gives intelligible speech.
+	voiceNoteMinBitrateKbps = 12
+	// voiceNoteMaxBitrateKbps caps the bitrate for short clips; mono speech gains nothing above it.
+	voiceNoteMaxBitrateKbps = 64
+	// voiceNoteContainerOverhead is the fraction of the size budget reserved for container framing.
+	voiceNoteContainerOverhead = 0.05
+)
+
+// computeVoiceNoteBitrate picks the highest Opus bitrate, in kbps, that keeps a clip of the
+// given duration under maxSizeBytes. It returns an error when even the minimum bitrate
+// would exceed the limit.
+func computeVoiceNoteBitrate(durationSecs float64, maxSizeBytes int64) (int, error) {
+	if durationSecs <= 0 {
+		return 0, fmt.Errorf("input duration must be positive, got %.3fs", durationSecs)
+	}
+	if maxSizeBytes <= 0 {
+		return 0, fmt.Errorf("maximum size must be positive, got %d bytes", maxSizeBytes)
+	}
+	usableBits := float64(maxSizeBytes) * 8 * (1 - voiceNoteContainerOverhead)
+	bitrateKbps := int(usableBits / durationSecs / 1000)
+	if bitrateKbps < voiceNoteMinBitrateKbps {
+		floorBytes := int64(float64(voiceNoteMinBitrateKbps*1000) * durationSecs / 8 / (1 - voiceNoteContainerOverhead))
+		return 0, fmt.Errorf("a %.1fs clip needs about %s even at the %d kbps floor, which exceeds the %s limit; shorten the audio or raise the limit",
+			durationSecs, common.FormatBytes(floorBytes), voiceNoteMinBitrateKbps, common.FormatBytes(maxSizeBytes))
+	}
+	if bitrateKbps > voiceNoteMaxBitrateKbps {
+		bitrateKbps = voiceNoteMaxBitrateKbps
+	}
+	return bitrateKbps, nil
+}
+
+// selectVoiceNoteContainer resolves the output container ("ogg" or "webm") from the
+// explicit container parameter and the extension of the requested output file name.
+func selectVoiceNoteContainer(container, outputFileName string) (string, error) {
+	container = strings.ToLower(strings.TrimSpace(container))
+	fileExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(outputFileName), "."))
+	if fileExt == "opus" {
+		fileExt = "ogg"
+	}
+	switch container {
+	case "":
+		if fileExt == "webm" {
+			return "webm", nil
+		}
+		return "ogg", nil
+	case "ogg", "webm":
+		if fileExt != "" && fileExt != container {
+			return "", fmt.Errorf("output_file_name extension '.%s' does not match container '%s'", fileExt, container)
+		}
+		return container, nil
+	default:
+		return "", fmt.Errorf("unsupported container '%s'; use 'ogg' or 'webm'", container)
+	}
+}
+
+// executeMakeVoiceNote encodes the input as mono Opus at the given bitrate into the chosen container.
+func executeMakeVoiceNote(ctx context.Context, localInputAudio, outputFile, container string, bitrateKbps int, normalize bool) (string, error) {
+	args := []string{"-y", "-i", localInputAudio, "-vn", "-ac", "1"}
+	if normalize {
+		args = append(args, "-af", "dynaudnorm")
+	}
+	args = append(args,
+		"-c:a", "libopus",
+		"-b:a", fmt.Sprintf("%dk", bitrateKbps),
+		"-application", "voip",
+		"-f", container,
+		outputFile,
+	)
+	return runFFmpegCommand(ctx, args...)
+}
+
+// executeExtractSubtitleStream converts one subtitle stream to a standalone text subtitle file.
+// format is "srt" or "vtt".
+func executeExtractSubtitleStream(ctx context.Context, localInputMedia string, streamIndex int, format, outputFile string) (string, error) {
+	codec := "srt"
+	if format == "vtt" {
+		codec = "webvtt"
+	}
+	return runFFmpegCommand(ctx, "-y", "-i", localInputMedia,
+		"-map", fmt.Sprintf("0:%d", streamIndex),
+		"-c:s", codec,
+		outputFile)
+}
+
+// executeExtractTranscriptionAudio extracts the first audio stream as 16 kHz mono FLAC,
+// the format most speech-to-text services handle best.
+func executeExtractTranscriptionAudio(ctx context.Context, localInputMedia, outputFile string) (string, error) {
+	return runFFmpegCommand(ctx, "-y", "-i", localInputMedia,
+		"-map", "0:a:0",
+		"-vn", "-ac", "1", "-ar", "16000",
+		"-c:a", "flac",
+		outputFile)
+}
+
+// resolveAudioGaps returns the silence, in seconds, to insert between each pair of consecutive
+// clips. A per-gap list takes precedence over the single gapSeconds value and must have exactly
+// one entry fewer than there are clips.
+func resolveAudioGaps(clipCount int, gapSeconds float64, perGapSeconds []float64) ([]float64, error) {
+	if clipCount < 1 {
+		return nil, fmt.Errorf("at least one audio clip is required")
+	}
+	gaps := make([]float64, clipCount-1)
+	if len(perGapSeconds) > 0 {
+		if len(perGapSeconds) != len(gaps) {
+			return nil, fmt.Errorf("expected %d per-gap durations for %d clips, got %d", len(gaps), clipCount, len(perGapSeconds))
+		}
+		copy(gaps, perGapSeconds)
+	} else {
+		for i := range gaps {
+			gaps[i] = gapSeconds
+		}
+	}
+	for i, gap := range gaps {
+		if gap < 0 {
+			return nil, fmt.Errorf("gap %d must not be negative, got %.3fs", i+1, gap)
+		}
+	}
+	return gaps, nil
+}
+
+// channelLayoutFor returns the FFmpeg channel layout name for a channel count.
+func channelLayoutFor(channels int) string {
+	switch channels {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	default:
+		return fmt.Sprintf("%dc", channels)
+	}
+}
+
+// anullsrcFilter returns a filter chain producing exactly durationSecs of silence in the given
+// format, trimmed by sample count so the gap length is sample-accurate.
+func anullsrcFilter(label string, durationSecs float64, format audioFormat) string {
+	samples := int64(math.Round(durationSecs * float64(format.SampleRate)))
+	return fmt.Sprintf("anullsrc=r=%d:cl=%s,atrim=end_sample=%d[%s]", format.SampleRate, channelLayoutFor(format.Channels), samples, label)
+}
+
+// buildGapConcatFilter builds the filter_complex graph that resamples every input clip to the
+// target format and concatenates them with generated silence in between, in the order
+// clip0, gap0, clip1, gap1, ... Zero-length gaps are left out.
+func buildGapConcatFilter(clipCount int, gaps []float64, format audioFormat) string {
+	layout := channelLayoutFor(format.Channels)
+	var chains []string
+	var segments []string
+	for i := 0; i < clipCount; i++ {
+		clipLabel := fmt.Sprintf("clip%d", i)
+		chains = append(chains, fmt.Sprintf("[%d:a]aresample=%d,aformat=sample_rates=%d:channel_layouts=%s[%s]", i, format.SampleRate, format.SampleRate, layout, clipLabel))
+		segments = append(segments, "["+clipLabel+"]")
+		if i < len(gaps) && gaps[i] > 0 {
+			gapLabel := fmt.Sprintf("gap%d", i)
+			chains = append(chains, anullsrcFilter(gapLabel, gaps[i], format))
+			segments = append(segments, "["+gapLabel+"]")
+		}
+	}
+	chains = append(chains, fmt.Sprintf("%sconcat=n=%d:v=0:a=1[out]", strings.Join(segments, ""), len(segments)))
+	return strings.Join(chains, ";")
+}
+
+// wavSampleFormats maps the 'sample_format' parameter of the audio tools to the PCM codec
+// written to WAV outputs.
+var wavSampleFormats = map[string]string{
+	"s16": "pcm_s16le",
+	"s24": "pcm_s24le",
+	"s32": "pcm_s32le",
+	"flt": "pcm_f32le",
+}
+
+// wavSampleFormatNames lists the keys of wavSampleFormats in order of precision.
+var wavSampleFormatNames = []string{"s16", "s24", "s32", "flt"}
+
+// defaultWAVSampleFormat is used for WAV output when no sample format is requested.
+const defaultWAVSampleFormat = "s16"
+
+// wavOutputCodec returns the PCM codec for a WAV output in the given sample format, which
+// defaults to defaultWAVSampleFormat when empty. Other containers return "", leaving the
+// codec to FFmpeg's default encoder for the extension.
+func wavOutputCodec(outputExt, sampleFormat string) string {
+	if strings.ToLower(outputExt) != "wav" {
+		return ""
+	}
+	if codec, ok := wavSampleFormats[sampleFormat]; ok {
+		return codec
+	}
+	return wavSampleFormats[defaultWAVSampleFormat]
+}
+
+// pcmOutputCodec picks the output codec for tools that keep an input's audio format, such as
+// ffmpeg_concat_audio_with_gaps, ffmpeg_equalizer, ffmpeg_pitch_shift, ffmpeg_denoise_audio and
+// ffmpeg_duck_audio.
+// A requested sample format wins; otherwise WAV output keeps the PCM codec of the input
+// (16-bit PCM if it was not PCM). Other containers use FFmpeg's default encoder for the extension.
+func pcmOutputCodec(outputExt string, format audioFormat, sampleFormat string) string {
+	if strings.ToLower(outputExt) != "wav" {
+		return ""
+	}
+	if sampleFormat == "" && strings.HasPrefix(format.CodecName, "pcm_") {
+		return format.CodecName
+	}
+	return wavOutputCodec(outputExt, sampleFormat)
+}
+
+// executeConcatAudioWithGaps concatenates the clips with the given gaps of silence between them.
+func executeConcatAudioWithGaps(ctx context.Context, localInputs []string, gaps []float64, format audioFormat, sampleFormat, outputFile string) (string, error) {
+	args := []string{"-y"}
+	for _, input := range localInputs {
+		args = append(args, "-i", input)
+	}
+	args = append(args,
+		"-filter_complex", buildGapConcatFilter(len(localInputs), gaps, format),
+		"-map", "[out]",
+		"-vn",
+	)
+	if codec := pcmOutputCodec(strings.TrimPrefix(filepath.Ext(outputFile), "."), format, sampleFormat); codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	args = append(args, outputFile)
+	return runFFmpegCommand(ctx, args...)
+}
+
+const (
+	// Target format for inputs standardized by ffmpeg_concatenate_media_files.
+	concatStandardWidth      = 1280
+	concatStandardHeight     = 720
+	concatStandardFPS        = "24"
+	concatStandardSampleRate = "48000"
+	concatStandardChannels   = "2"
+)
+
+// buildConcatStandardizeArgs returns the FFmpeg arguments that standardize one input to MP4/AAC
+// before concatenation. Audio-only inputs are only re-encoded to AAC. When cfr is set, the video
+// is forced to a constant frame rate and the audio is stretched/squeezed to its timestamps, so a
+// variable-frame-rate input does not drift out of sync over the length of the concat. A non-zero
+// targetLUFS also runs the audio through loudnorm, so that inputs recorded at different levels
+// play back at the same loudness once joined. Streams selected in streams are mapped explicitly;
+// otherwise FFmpeg picks them.
+func buildConcatStandardizeArgs(localInputFile, outputFile string, audioOnly, cfr bool, streams streamSelection, targetLUFS float64) []string {
+	var audioFilters []string
+	if cfr && !audioOnly {
+		audioFilters = append(audioFilters, "aresample=async=1:first_pts=0")
+	}
+	if targetLUFS != 0 {
+		audioFilters = append(audioFilters, loudnormFilter(targetLUFS))
+	}
+	var afArgs []string
+	if len(audioFilters) > 0 {
+		afArgs = []string{"-af", strings.Join(audioFilters, ",")}
+	}
+
+	if audioOnly {
+		args := []string{"-y", "-i", localInputFile}
+		if streams.Audio >= 0 {
+			args = append(args, "-map", fmt.Sprintf("0:%d", streams.Audio))
+		}
+		args = append(args, "-vn")
+		args = append(args, afArgs...)
+		return append(args, "-c:a", "aac", "-ar", concatStandardSampleRate, "-ac", concatStandardChannels, "-b:a", "192k", outputFile)
+	}
+	vfArgs := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:0:0,fps=%s", concatStandardWidth, concatStandardHeight, concatStandardWidth, concatStandardHeight, concatStandardFPS)
+	args := append([]string{"-y", "-i", localInputFile}, streams.mapArgs(0)...)
+	args = append(args, "-vf", vfArgs)
+	if cfr {
+		args = append(args, "-vsync", "cfr", "-r", concatStandardFPS)
+	}
+	args = append(args, afArgs...)
+	return append(args, "-c:v", "libx264", "-preset", "medium", "-crf", "23", "-c:a", "aac", "-ar", concatStandardSampleRate, "-ac", concatStandardChannels, "-b:a", "192k", outputFile)
+}
+
+// loudnormFilter returns the single-pass loudnorm filter that brings audio to targetLUFS
+// integrated loudness, with a -1.5 dBTP true peak ceiling and FFmpeg's default loudness range.
+func loudnormFilter(targetLUFS float64) string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", targetLUFS)
+}
+
+// buildCombineAudioVideoArgs returns the FFmpeg arguments that add the audio of audioFile to
+// videoFile, copying the video. A non-negative audioStream maps that stream of the audio input
+// instead of all of its audio streams.
+func buildCombineAudioVideoArgs(videoFile, audioFile, outputFile string, audioStream int) []string {
+	audioMap := "1:a"
+	if audioStream >= 0 {
+		audioMap = fmt.Sprintf("1:%d", audioStream)
+	}
+	return []string{"-y", "-i", videoFile, "-i", audioFile, "-map", "0", "-map", audioMap, "-c:v", "copy", "-shortest", outputFile}
+}
+
+// buildGIFPaletteArgs returns the FFmpeg arguments of the first pass of a GIF, which writes the
+// palette of the input given by inputArgs to palettePath. GIFs are made in two passes: palettegen
+// picks the 256 colors that best fit the frames, then paletteuse maps the frames to that palette.
+// frameFilter is the frame rate and scaling applied in both passes.
+func buildGIFPaletteArgs(inputArgs []string, frameFilter, palettePath string) []string {
+	args := append([]string{"-y"}, inputArgs...)
+	return append(args, "-vf", frameFilter+",palettegen", palettePath)
+}
+
+// buildGIFArgs returns the FFmpeg arguments of the second pass, which writes the GIF using the
+// palette. paletteUse is the paletteuse filter with its options.
+func buildGIFArgs(inputArgs []string, frameFilter, palettePath, paletteUse, outputFile string) []string {
+	args := append([]string{"-y"}, inputArgs...)
+	return append(args, "-i", palettePath, "-lavfi", frameFilter+" [x]; [x][1:v] "+paletteUse, outputFile)
+}
+
+// gifDitherModes are the paletteuse dither modes offered by ffmpeg_images_to_gif.
+var gifDitherModes = []string{"sierra2_4a", "sierra2", "floyd_steinberg", "heckbert", "bayer", "none"}
+
+// gifPaletteUse returns the paletteuse filter for a dither mode. bayerScale, from 0 to 5, only
+// applies to bayer dithering; lower values give a more visible crosshatch pattern.
+func gifPaletteUse(dither string, bayerScale int) string {
+	if dither == "bayer" {
+		return fmt.Sprintf("paletteuse=dither=bayer:bayer_scale=%d", bayerScale)
+	}
+	return "paletteuse=dither=" + dither
+}
+
+// imageSequenceInputArgs reads the images matching the image2 pattern as frames at fps.
+func imageSequenceInputArgs(pattern string, fps float64) []string {
+	return []string{"-framerate", strconv.FormatFloat(fps, 'f', -1, 64), "-i", pattern}
+}
+
+// imageSequenceFrameFilter scales every image to fit width x height, centered, so images of
+// another size or aspect ratio are letterboxed instead of changing the size of the GIF.
+func imageSequenceFrameFilter(width, height int) string {
+	return fmt.Sprintf("scale=%d:%d:flags=lanczos+accurate_rnd+full_chroma_inp:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2", width, height, width, height)
+}
+
+// buildAdjustVolumeArgs returns the FFmpeg arguments that change the volume of inputFile by
+// volumeDB decibels, encoding with codec when it is not empty. preserveMetadata copies the
+// input's container and audio stream tags (title, artist, album and so on) to the output and
+// keeps the input's timestamps with -copyts, so a stream that does not start at zero is not
+// shifted.
+func buildAdjustVolumeArgs(inputFile, outputFile string, volumeDB int, codec string, preserveMetadata bool) []string {
+	args := []string{"-y"}
+	if preserveMetadata {
+		args = append(args, "-copyts")
+	}
+	args = append(args, "-i", inputFile, "-af", fmt.Sprintf("volume=%ddB", volumeDB))
+	if codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	if preserveMetadata {
+		args = append(args, "-map_metadata", "0", "-map_metadata:s:a", "0:s:a")
+	}
+	return append(args, outputFile)
+}
+
+// pcmCodecPrecision lists the PCM codecs a WAV concatenation can resample to, from the least
+// to the most precise.
+var pcmCodecPrecision = []string{"pcm_u8", "pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le", "pcm_f64le"}
+
+// pcmConcatCompatible reports whether the concat demuxer can join the inputs with -c copy: all of
+// them must be PCM with the same codec, sample rate and channel count. The codec is compared
+// rather than ffprobe's sample_fmt, which is s32 for both 24- and 32-bit PCM.
+func pcmConcatCompatible(formats []audioFormat) bool {
+	if len(formats) == 0 {
+		return false
+	}
+	for _, f := range formats {
+		if !strings.HasPrefix(f.CodecName, "pcm_") || f != formats[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// wavResampleTarget returns the format WAV inputs are resampled to before concatenation: the
+// highest sample rate, the most channels and the most precise PCM codec among the inputs, so no
+// input loses quality. Inputs in other codecs count as 16-bit PCM. A positive sampleRate or
+// channels replaces the value taken from the inputs.
+func wavResampleTarget(formats []audioFormat, sampleRate, channels int) audioFormat {
+	target := audioFormat{CodecName: "pcm_s16le"}
+	for _, f := range formats {
+		target.SampleRate = max(target.SampleRate, f.SampleRate)
+		target.Channels = max(target.Channels, f.Channels)
+		if slices.Index(pcmCodecPrecision, f.CodecName) > slices.Index(pcmCodecPrecision, target.CodecName) {
+			target.CodecName = f.CodecName
+		}
+	}
+	if sampleRate > 0 {
+		target.SampleRate = sampleRate
+	}
+	if channels > 0 {
+		target.Channels = channels
+	}
+	return target
+}
+
+// buildWAVResampleArgs returns the FFmpeg arguments that convert the first audio stream of an
+// input to a PCM WAV in the target format. The soxr resampler is used for its quality; FFmpeg
+// must be built with libsoxr.
+func buildWAVResampleArgs(localInputFile, outputFile string, target audioFormat) []string {
+	return []string{"-y", "-i", localInputFile,
+		"-map", "0:a:0", "-vn",
+		"-af", "aresample=resampler=soxr",
+		"-ar", strconv.Itoa(target.SampleRate), "-ac", strconv.Itoa(target.Channels),
+		"-c:a", target.CodecName,
+		outputFile,
+	}
+}
+
+// buildConcatList returns the concat demuxer list joining the files in order, by absolute path.
+func buildConcatList(paths []string) (string, error) {
+	var list strings.Builder
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to get absolute path for %s: %w", path, err)
+		}
+		fmt.Fprintf(&list, "file '%s'\n", absPath)
+	}
+	return list.String(), nil
+}
+
+const (
+	// compressDefaultAudioBitrateKbps is the AAC bitrate reserved for the audio track.
+	compressDefaultAudioBitrateKbps = 128
+	// compressDefaultMinBitsPerPixel is the quality floor below which the output is downscaled.
+	compressDefaultMinBitsPerPixel = 0.05
+	// compressMaxBitsPerPixel caps the video bitrate for short inputs; libx264 gains nothing visible above it.
+	compressMaxBitsPerPixel = 0.2
+	// compressContainerOverhead is the fraction of the size budget reserved for MP4 framing.
+	compressContainerOverhead = 0.02
+	// compressMinVideoBitrateKbps is the lowest video bitrate worth encoding at.
+	compressMinVideoBitrateKbps = 50
+	// compressMinShortSide is the smallest short-side resolution the output is downscaled to.
+	compressMinShortSide = 144
+	// compressCorrectionMargin is the extra reduction applied on the corrective pass so it lands under the cap.
+	compressCorrectionMargin = 0.03
+)
+
+// compressionPlan is the bitrate split and output resolution chosen for a size-targeted encode.
+// AudioBitrateKbps is zero when the input has no audio.
+type compressionPlan struct {
+	VideoBitrateKbps int
+	AudioBitrateKbps int
+	Width            int
+	Height           int
+	Downscaled       bool
+}
+
+// bitsPerPixel is the number of video bits available per pixel per frame under the plan.
+func (p compressionPlan) bitsPerPixel(frameRate float64) float64 {
+	return float64(p.VideoBitrateKbps) * 1000 / (float64(p.Width*p.Height) * frameRate)
+}
+
+// planCompression splits the size budget for an input of the given duration into audio and
+// video bitrates, and picks the output resolution. When the video bitrate would give fewer than
+// minBitsPerPixel at the source resolution, the output is downscaled, keeping the aspect ratio,
+// to the largest size that meets the floor (but not below compressMinShortSide).
+func planCompression(durationSecs float64, targetBytes int64, audioBitrateKbps int, geometry videoGeometry, minBitsPerPixel float64) (compressionPlan, error) {
+	if durationSecs <= 0 {
+		return compressionPlan{}, fmt.Errorf("input duration must be positive, got %.3fs", durationSecs)
+	}
+	if targetBytes <= 0 {
+		return compressionPlan{}, fmt.Errorf("target size must be positive, got %d bytes", targetBytes)
+	}
+	if geometry.Width <= 0 || geometry.Height <= 0 || geometry.FrameRate <= 0 {
+		return compressionPlan{}, fmt.Errorf("input has no usable video geometry (%dx%d at %.2f fps)", geometry.Width, geometry.Height, geometry.FrameRate)
+	}
+	if minBitsPerPixel <= 0 {
+		return compressionPlan{}, fmt.Errorf("minimum bits per pixel must be positive, got %g", minBitsPerPixel)
+	}
+
+	totalKbps := int(float64(targetBytes) * 8 * (1 - compressContainerOverhead) / durationSecs / 1000)
+	plan := compressionPlan{Width: geometry.Width, Height: geometry.Height}
+	if geometry.HasAudio {
+		plan.AudioBitrateKbps = audioBitrateKbps
+	}
+	plan.VideoBitrateKbps = totalKbps - plan.AudioBitrateKbps
+	if plan.VideoBitrateKbps < compressMinVideoBitrateKbps {
+		return compressionPlan{}, fmt.Errorf("a %.1fs video leaves only %d kbps for video under %s after reserving %d kbps for audio (minimum %d kbps); lower the audio bitrate, shorten the video or raise the target",
+			durationSecs, plan.VideoBitrateKbps, common.FormatBytes(targetBytes), plan.AudioBitrateKbps, compressMinVideoBitrateKbps)
+	}
+
+	maxVideoKbps := int(compressMaxBitsPerPixel * float64(geometry.Width*geometry.Height) * geometry.FrameRate / 1000)
+	if plan.VideoBitrateKbps > maxVideoKbps {
+		plan.VideoBitrateKbps = maxVideoKbps
+	}
+
+	if plan.bitsPerPixel(geometry.FrameRate) < minBitsPerPixel {
+		maxPixels := float64(plan.VideoBitrateKbps) * 1000 / (geometry.FrameRate * minBitsPerPixel)
+		scale := math.Sqrt(maxPixels / float64(geometry.Width*geometry.Height))
+		shortSide := min(geometry.Width, geometry.Height)
+		if float64(shortSide)*scale < compressMinShortSide {
+			scale = float64(compressMinShortSide) / float64(shortSide)
+		}
+		if scale < 1 {
+			plan.Width = evenFloor(float64(geometry.Width) * scale)
+			plan.Height = evenFloor(float64(geometry.Height) * scale)
+			plan.Downscaled = true
+		}
+	}
+	return plan, nil
+}
+
+// evenFloor rounds down to an even number of pixels, as required by yuv420p.
+func evenFloor(v float64) int {
+	return int(v/2) * 2
+}
+
+// correctiveVideoBitrate returns a lower video bitrate for a re-encode that overshot the target,
+// removing the excess bits spread over the duration plus a small safety margin.
+func correctiveVideoBitrate(videoBitrateKbps int, durationSecs float64, actualBytes, targetBytes int64) (int, error) {
+	if actualBytes <= targetBytes {
+		return videoBitrateKbps, nil
+	}
+	excessKbps := float64(actualBytes-targetBytes) * 8 / durationSecs / 1000
+	corrected := int((float64(videoBitrateKbps) - excessKbps) * (1 - compressCorrectionMargin))
+	if corrected < compressMinVideoBitrateKbps {
+		return 0, fmt.Errorf("output is %s over the %s target and cannot be corrected above the %d kbps video minimum",
+			common.FormatBytes(actualBytes-targetBytes), common.FormatBytes(targetBytes), compressMinVideoBitrateKbps)
+	}
+	return corrected, nil
+}
+
+// buildTwoPassArgs returns the FFmpeg arguments for one pass (1 or 2) of a libx264 two-pass
+// encode. Pass 1 only writes the rate-control statistics to passLogPrefix and discards its output.
+func buildTwoPassArgs(localInputVideo, outputFile, passLogPrefix string, plan compressionPlan, pass int) []string {
+	args := []string{"-y", "-i", localInputVideo,
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-b:v", fmt.Sprintf("%dk", plan.VideoBitrateKbps),
+		"-pass", strconv.Itoa(pass),
+		"-passlogfile", passLogPrefix,
+		"-pix_fmt", "yuv420p",
+	}
+	if plan.Downscaled {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:%d", plan.Width, plan.Height))
+	}
+	if pass == 1 {
+		return append(args, "-an", "-f", "null", os.DevNull)
+	}
+	if plan.AudioBitrateKbps > 0 {
+		args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", plan.AudioBitrateKbps))
+	} else {
+		args = append(args, "-an")
+	}
+	return append(args, "-movflags", "+faststart", outputFile)
+}
+
+// executeTwoPassEncode runs both passes of a two-pass encode into outputFile. A reproducible encode
+// needs the same thread count in both passes, so the first pass gets the reproducible options too.
+func executeTwoPassEncode(ctx context.Context, localInputVideo, outputFile, passLogPrefix string, plan compressionPlan, reproducible bool) error {
+	if _, err := runFFmpegCommand(ctx, withReproducibleOutput(buildTwoPassArgs(localInputVideo, outputFile, passLogPrefix, plan, 1), reproducible)...); err != nil {
+		return fmt.Errorf("first pass failed: %w", err)
+	}
+	if _, err := runFFmpegCommand(ctx, withReproducibleOutput(buildTwoPassArgs(localInputVideo, outputFile, passLogPrefix, plan, 2), reproducible)...); err != nil {
+		return fmt.Errorf("second pass failed: %w", err)
+	}
+	return nil
+}
+
+// ffmpegColorRegex limits colors to FFmpeg color names and hex values (with an optional @alpha),
+// so the value cannot break out of the filter graph. It is used for progress bar and countdown colors.
+var ffmpegColorRegex = regexp.MustCompile(`^(#|0x)?[A-Za-z0-9]+(@[0-9.]+)?$`)
+
+// progressBarAlphaExpr is the geq alpha expression that reveals the bar up to the current
+// playback position: pixel columns left of W*T/duration are opaque, the rest transparent.
+func progressBarAlphaExpr(durationSecs float64) string {
+	return fmt.Sprintf("if(lt(X,W*T/%s),255,0)", strconv.FormatFloat(durationSecs, 'f', -1, 64))
+}
+
+// buildProgressBarFilter returns a filter graph that burns a progress bar into the video. A strip
+// of the frame at the top or bottom is filled with the bar color by drawbox, then made visible
+// only up to the playback position by a time-based geq alpha, and overlaid back onto the frame.
+func buildProgressBarFilter(position string, height int, color string, durationSecs float64) string {
+	y := "0"
+	if position == "bottom" {
+		y = fmt.Sprintf("ih-%d", height)
+	}
+	return fmt.Sprintf("[0:v]split[base][strip];"+
+		"[strip]crop=iw:%d:0:%s,drawbox=c=%s:t=fill,format=rgba,geq=r='r(X,Y)':g='g(X,Y)':b='b(X,Y)':a='%s'[bar];"+
+		"[base][bar]overlay=0:%s:format=auto[out]",
+		height, y, color, progressBarAlphaExpr(durationSecs), strings.ReplaceAll(y, "ih", "main_h"))
+}
+
+// executeProgressBar burns the progress bar into the video, copying any audio unchanged.
+func executeProgressBar(ctx context.Context, localInputVideo, outputFile, filter string) (string, error) {
+	return runFFmpegCommand(ctx, "-y", "-i", localInputVideo,
+		"-filter_complex", filter,
+		"-map", "[out]", "-map", "0:a?",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-c:a", "copy",
+		outputFile,
+	)
+}
+
+// sideBySideStackSize picks the shared dimension both inputs are scaled to before stacking: the
+// smaller height for a horizontal layout, or the smaller width for a vertical one, rounded down to
+// an even number so the stacked frame stays valid for yuv420p.
+func sideBySideStackSize(layout string, left, right videoGeometry) int {
+	if layout == "vertical" {
+		return evenFloor(math.Min(float64(left.Width), float64(right.Width)))
+	}
+	return evenFloor(math.Min(float64(left.Height), float64(right.Height)))
+}
+
+// resolveSideBySideAudio checks the requested audio mode against the audio streams that are
+// actually present. 'mix' falls back to the only input with audio, or to no audio at all.
+func resolveSideBySideAudio(mode string, leftHasAudio, rightHasAudio bool) (string, error) {
+	switch mode {
+	case "none":
+		return "none", nil
+	case "left":
+		if !leftHasAudio {
+			return "", fmt.Errorf("the left video has no audio stream")
+		}
+		return "left", nil
+	case "right":
+		if !rightHasAudio {
+			return "", fmt.Errorf("the right video has no audio stream")
+		}
+		return "right", nil
+	case "mix":
+		switch {
+		case leftHasAudio && rightHasAudio:
+			return "mix", nil
+		case leftHasAudio:
+			return "left", nil
+		case rightHasAudio:
+			return "right", nil
+		default:
+			return "none", nil
+		}
+	default:
+		return "", fmt.Errorf("invalid audio mode '%s'; use 'mix', 'left', 'right' or 'none'", mode)
+	}
+}
+
+// buildSideBySideFilter scales both videos to a common height (horizontal) or width (vertical)
+// with square pixels and a shared pixel format, then joins them with hstack or vstack into [v].
+// When audio is 'mix', both audio streams are mixed into [a].
+func buildSideBySideFilter(layout string, size int, audio string) string {
+	scale, stack := fmt.Sprintf("scale=-2:%d", size), "hstack"
+	if layout == "vertical" {
+		scale, stack = fmt.Sprintf("scale=%d:-2", size), "vstack"
+	}
+	filter := fmt.Sprintf("[0:v]%[1]s,setsar=1,format=yuv420p[left];[1:v]%[1]s,setsar=1,format=yuv420p[right];"+
+		"[left][right]%[2]s=inputs=2:shortest=1[v]", scale, stack)
+	if audio == "mix" {
+		filter += ";[0:a][1:a]amix=inputs=2:duration=shortest[a]"
+	}
+	return filter
+}
+
+// buildSideBySideArgs returns the FFmpeg arguments for a side-by-side render. -shortest ends the
+// output with the shorter input so the stack never shows a frozen frame.
+func buildSideBySideArgs(localLeftVideo, localRightVideo, outputFile, filter, audio string) []string {
+	args := []string{"-y", "-i", localLeftVideo, "-i", localRightVideo, "-filter_complex", filter, "-map", "[v]"}
+	switch audio {
+	case "mix":
+		args = append(args, "-map", "[a]")
+	case "left":
+		args = append(args, "-map", "0:a:0")
+	case "right":
+		args = append(args, "-map", "1:a:0")
+	}
+	args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p")
+	if audio == "none" {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-c:a", "aac")
+	}
+	return append(args, "-shortest", "-movflags", "+faststart", outputFile)
+}
+
+// equalizerBand is one peaking band of ffmpeg_equalizer. Width is the band's Q factor, FFmpeg's
+// default width type for the equalizer filter.
+type equalizerBand struct {
+	Frequency float64
+	Width     float64
+	GainDB    float64
+}
+
+// buildEqualizerFilter chains the EQ filters: the highpass first, then one equalizer per band in
+// order, then the lowpass. A zero cutoff leaves that filter out.
+func buildEqualizerFilter(bands []equalizerBand, highpassHz, lowpassHz float64) string {
+	var filters []string
+	if highpassHz > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%g", highpassHz))
+	}
+	for _, band := range bands {
+		filters = append(filters, fmt.Sprintf("equalizer=f=%g:t=q:w=%g:g=%g", band.Frequency, band.Width, band.GainDB))
+	}
+	if lowpassHz > 0 {
+		filters = append(filters, fmt.Sprintf("lowpass=f=%g", lowpassHz))
+	}
+	return strings.Join(filters, ",")
+}
+
+// buildEqualizerArgs returns the FFmpeg arguments that apply the EQ filter chain to the first audio
+// stream, keeping the input's sample rate and channel count. The WAV codec is chosen by pcmOutputCodec.
+func buildEqualizerArgs(localInputAudio, outputFile, filter string, format audioFormat, sampleFormat string) []string {
+	args := []string{"-y", "-i", localInputAudio, "-map", "0:a:0", "-af", filter,
+		"-ar", strconv.Itoa(format.SampleRate), "-ac", strconv.Itoa(format.Channels)}
+	if codec := pcmOutputCodec(strings.TrimPrefix(filepath.Ext(outputFile), "."), format, sampleFormat); codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	return append(args, outputFile)
+}
+
+// maxPitchShiftSemitones bounds ffmpeg_pitch_shift to two octaves either way; beyond that the
+// resampled audio is mostly artifacts.
+const maxPitchShiftSemitones = 24
+
+// pitchShiftFactors returns the asetrate sample rate and the overall atempo factor that shift the
+// pitch by the given number of semitones. asetrate plays the samples back at sampleRate*2^(n/12),
+// which raises the pitch and the tempo together; atempo then restores the original tempo, so the
+// duration is unchanged.
+func pitchShiftFactors(semitones float64, sampleRate int) (asetrate int, atempo float64) {
+	ratio := math.Pow(2, semitones/12)
+	return int(math.Round(float64(sampleRate) * ratio)), 1 / ratio
+}
+
+// atempoChain splits a tempo factor into atempo filters that each stay within 0.5 to 2.0, the
+// range every FFmpeg version accepts.
+func atempoChain(factor float64) []string {
+	var filters []string
+	for factor > 2 {
+		filters = append(filters, "atempo=2")
+		factor /= 2
+	}
+	for factor < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		factor /= 0.5
+	}
+	if math.Abs(factor-1) > 1e-9 {
+		filters = append(filters, "atempo="+strconv.FormatFloat(factor, 'f', -1, 64))
+	}
+	return filters
+}
+
+// buildPitchShiftFilter returns the asetrate+aresample+atempo chain for the shift. aresample
+// brings the audio back to the input's sample rate after asetrate relabels it.
+func buildPitchShiftFilter(semitones float64, sampleRate int) string {
+	asetrate, atempo := pitchShiftFactors(semitones, sampleRate)
+	filters := []string{fmt.Sprintf("asetrate=%d", asetrate), fmt.Sprintf("aresample=%d", sampleRate)}
+	return strings.Join(append(filters, atempoChain(atempo)...), ",")
+}
+
+// buildPitchShiftArgs returns the FFmpeg arguments that pitch-shift the first audio stream,
+// keeping the input's channel count. The WAV codec is chosen by pcmOutputCodec.
+func buildPitchShiftArgs(localInputAudio, outputFile, filter string, format audioFormat, sampleFormat string) []string {
+	args := []string{"-y", "-i", localInputAudio, "-map", "0:a:0", "-af", filter, "-ac", strconv.Itoa(format.Channels)}
+	if codec := pcmOutputCodec(strings.TrimPrefix(filepath.Ext(outputFile), "."), format, sampleFormat); codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	return append(args, outputFile)
+}
+
+// duckSettings are the sidechain compressor settings of ffmpeg_duck_audio, plus the gain applied
+// to the music before it is compressed.
+type duckSettings struct {
+	ThresholdDB     float64
+	Ratio           float64
+	AttackMs        float64
+	ReleaseMs       float64
+	MusicBaseGainDB float64
+}
+
+// defaultDuckSettings pull a music bed down by roughly 10-15 dB under speech at a typical
+// narration level, fast enough to clear the first syllable and slow enough to avoid pumping
+// between words.
+var defaultDuckSettings = duckSettings{ThresholdDB: -30, Ratio: 8, AttackMs: 20, ReleaseMs: 400, MusicBaseGainDB: 0}
+
+func (d duckSettings) String() string {
+	return fmt.Sprintf("threshold %g dB, ratio %g:1, attack %g ms, release %g ms, music base gain %g dB",
+		d.ThresholdDB, d.Ratio, d.AttackMs, d.ReleaseMs, d.MusicBaseGainDB)
+}
+
+// duckOutputFormat returns the format of the ducked mix: the higher sample rate of the two inputs,
+// at most two channels and the more precise PCM codec, so neither input loses quality.
+func duckOutputFormat(voice, music audioFormat) audioFormat {
+	target := wavResampleTarget([]audioFormat{voice, music}, 0, 0)
+	target.Channels = min(target.Channels, 2)
+	return target
+}
+
+// buildDuckFilter returns the filter graph that ducks the music (input 1) under the voice
+// (input 0) into [a]. Both inputs are converted to the output format first, because
+// sidechaincompress needs its two inputs to match, and the shorter one is padded with silence to
+// the length of the longer one: sidechaincompress stops when either input ends. The voice is
+// split so one copy drives the compressor and the other is mixed over the ducked music. amix
+// does not normalize, so the voice keeps its level.
+func buildDuckFilter(settings duckSettings, voiceDuration, musicDuration float64, format audioFormat) string {
+	layout := "stereo"
+	if format.Channels == 1 {
+		layout = "mono"
+	}
+	conform := fmt.Sprintf("aresample=%d,aformat=channel_layouts=%s", format.SampleRate, layout)
+	voiceChain, musicChain := conform, conform
+	if settings.MusicBaseGainDB != 0 {
+		musicChain += fmt.Sprintf(",volume=%gdB", settings.MusicBaseGainDB)
+	}
+	if voiceDuration < musicDuration {
+		voiceChain += fmt.Sprintf(",apad=whole_dur=%.3f", musicDuration)
+	} else if musicDuration < voiceDuration {
+		musicChain += fmt.Sprintf(",apad=whole_dur=%.3f", voiceDuration)
+	}
+	// sidechaincompress takes a linear threshold; 10^(dB/20) converts it.
+	threshold := math.Pow(10, settings.ThresholdDB/20)
+	return fmt.Sprintf("[0:a]%s[voice];[1:a]%s[music];[voice]asplit=2[sidechain][narration];"+
+		"[music][sidechain]sidechaincompress=threshold=%.6f:ratio=%g:attack=%g:release=%g[ducked];"+
+		"[ducked][narration]amix=inputs=2:duration=longest:normalize=0[a]",
+		voiceChain, musicChain, threshold, settings.Ratio, settings.AttackMs, settings.ReleaseMs)
+}
+
+// buildDuckArgs returns the FFmpeg arguments that render the ducked mix. The WAV codec is chosen
+// by pcmOutputCodec.
+func buildDuckArgs(localVoiceAudio, localMusicAudio, outputFile, filter string, format audioFormat, sampleFormat string) []string {
+	args := []string{"-y", "-i", localVoiceAudio, "-i", localMusicAudio, "-filter_complex", filter, "-map", "[a]",
+		"-ar", strconv.Itoa(format.SampleRate), "-ac", strconv.Itoa(format.Channels)}
+	if codec := pcmOutputCodec(strings.TrimPrefix(filepath.Ext(outputFile), "."), format, sampleFormat); codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	return append(args, outputFile)
+}
+
+// denoiseStrengths are the ffmpeg_denoise_audio presets, from least to most aggressive.
+var denoiseStrengths = []string{"light", "medium", "aggressive"}
+
+// denoisePresets maps each strength to afftdn options: nr is the reduction in dB applied to the
+// noise, nf the assumed noise floor in dB, and tn=1 makes afftdn track a changing noise floor,
+// such as a laptop fan spinning up.
+var denoisePresets = map[string]string{
+	"light":      "afftdn=nr=6:nf=-60",
+	"medium":     "afftdn=nr=12:nf=-50:tn=1",
+	"aggressive": "afftdn=nr=24:nf=-40:tn=1",
+}
+
+// buildDenoiseFilter returns the denoise filter: the strength's afftdn preset or, when a local
+// RNNoise model file is given, arnndn with that model. The model path must pass filterPathSafe.
+func buildDenoiseFilter(strength, localModelFile string) string {
+	if localModelFile != "" {
+		return fmt.Sprintf("arnndn=m='%s'", escapeFilterPath(localModelFile))
+	}
+	return denoisePresets[strength]
+}
+
+// buildDenoiseArgs returns the FFmpeg arguments that denoise the first audio stream, keeping the
+// input's sample rate (arnndn works at 48 kHz internally) and channel count; the WAV codec is
+// chosen by pcmOutputCodec. A positive previewSecs reads only that many seconds of the input.
+func buildDenoiseArgs(localInputAudio, outputFile, filter string, format audioFormat, previewSecs float64, sampleFormat string) []string {
+	args := []string{"-y"}
+	if previewSecs > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.3f", previewSecs))
+	}
+	args = append(args, "-i", localInputAudio, "-map", "0:a:0", "-af", filter,
+		"-ar", strconv.Itoa(format.SampleRate), "-ac", strconv.Itoa(format.Channels))
+	if codec := pcmOutputCodec(strings.TrimPrefix(filepath.Ext(outputFile), "."), format, sampleFormat); codec != "" {
+		args = append(args, "-c:a", codec)
+	}
+	return append(args, outputFile)
+}
+
+// tonemapAlgorithms are the tone-mapping curves offered by ffmpeg_tonemap_hdr_to_sdr.
+var tonemapAlgorithms = []string{"hable", "reinhard", "mobius"}
+
+// buildTonemapFilter returns the HDR to SDR filter chain. zscale converts the input to linear
+// light (with a nominal peak of 100 nits) in float RGB and to BT.709 primaries, tonemap compresses
+// the highlights with the chosen curve, and a final zscale applies the BT.709 transfer and matrix
+// in limited range before converting to yuv420p.
+func buildTonemapFilter(algorithm string) string {
+	return "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709," +
+		fmt.Sprintf("tonemap=tonemap=%s:desat=0,", algorithm) +
+		"zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+}
+
+// buildTonemapArgs returns the FFmpeg arguments for the tone-mapping encode. The output is tagged
+// as BT.709 so players do not treat it as HDR, and any audio is copied unchanged.
+func buildTonemapArgs(localInputVideo, outputFile, filter string) []string {
+	return []string{"-y", "-i", localInputVideo,
+		"-map", "0:v:0", "-map", "0:a?",
+		"-vf", filter,
+		"-c:v", "libx264", "-preset", "medium", "-crf", "18",
+		"-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709",
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		outputFile,
+	}
+}
+
+// kenBurnsDirections are the camera moves offered by ffmpeg_ken_burns.
+var kenBurnsDirections = []string{"zoom_in", "zoom_out", "pan_left", "pan_right", "pan_up", "pan_down"}
+
+const (
+	// kenBurnsMaxZoom is the zoom at the tight end of a zoom, and the fixed zoom of a pan, which
+	// leaves a quarter of the frame to pan across.
+	kenBurnsMaxZoom = 1.25
+	// kenBurnsOversample is how much larger than the output the image is scaled before zoompan.
+	// zoompan rounds the crop position to whole pixels, so at the output size a slow move
+	// visibly jitters; at four times the size the steps are too small to see.
+	kenBurnsOversample = 4
+)
+
+// kenBurnsZoompan returns the zoompan z, x and y expressions for a move over frames output frames.
+// The expressions use the output frame number 'on' rather than the previous zoom, so the move
+// runs linearly from its first frame to exactly its last one.
+func kenBurnsZoompan(direction string, frames int) (z, x, y string) {
+	progress := fmt.Sprintf("on/%d", max(frames-1, 1))
+	centerX, centerY := "iw/2-(iw/zoom/2)", "ih/2-(ih/zoom/2)"
+	fixedZoom := fmt.Sprintf("%g", kenBurnsMaxZoom)
+	switch direction {
+	case "zoom_out":
+		return fmt.Sprintf("%g-%g*%s", kenBurnsMaxZoom, kenBurnsMaxZoom-1, progress), centerX, centerY
+	case "pan_left":
+		return fixedZoom, fmt.Sprintf("(iw-iw/zoom)*(1-%s)", progress), centerY
+	case "pan_right":
+		return fixedZoom, fmt.Sprintf("(iw-iw/zoom)*%s", progress), centerY
+	case "pan_up":
+		return fixedZoom, centerX, fmt.Sprintf("(ih-ih/zoom)*(1-%s)", progress)
+	case "pan_down":
+		return fixedZoom, centerX, fmt.Sprintf("(ih-ih/zoom)*%s", progress)
+	default: // zoom_in
+		return fmt.Sprintf("1+%g*%s", kenBurnsMaxZoom-1, progress), centerX, centerY
+	}
+}
+
+// buildKenBurnsFilter returns the filter chain that animates a still image: the image is scaled
+// and cropped to fill kenBurnsOversample times the output size, then zoompan renders the move
+// at the output size and frame rate.
+func buildKenBurnsFilter(direction string, width, height, fps int, durationSecs float64) string {
+	frames := kenBurnsFrameCount(fps, durationSecs)
+	z, x, y := kenBurnsZoompan(direction, frames)
+	bigW, bigH := width*kenBurnsOversample, height*kenBurnsOversample
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,", bigW, bigH, bigW, bigH) +
+		fmt.Sprintf("zoompan=z='%s':x='%s':y='%s':d=%d:s=%dx%d:fps=%d,format=yuv420p", z, x, y, frames, width, height, fps)
+}
+
+// kenBurnsFrameCount is the number of frames in a move of durationSecs at fps, at least one.
+func kenBurnsFrameCount(fps int, durationSecs float64) int {
+	return max(int(math.Round(durationSecs*float64(fps))), 1)
+}
+
+// buildKenBurnsArgs returns the FFmpeg arguments that render the Ken Burns move of a single image
+// as a silent H.264 MP4.
+func buildKenBurnsArgs(localInputImage, outputFile, filter string, frames int) []string {
+	return []string{"-y", "-i", localInputImage,
+		"-vf", filter,
+		"-frames:v", strconv.Itoa(frames),
+		"-c:v", "libx264", "-preset", "medium", "-crf", "18",
+		"-movflags", "+faststart",
+		outputFile,
+	}
+}
+
+// thumbnailCandidates is the number of frames the thumbnail filter compares. The searched
+// segment is resampled to about this many frames whatever its length, so a long video costs no
+// more to score than a short window.
+const thumbnailCandidates = 60
+
+// thumbnailSearchWindow returns the segment of a video of the given duration searched for a
+// thumbnail: windowSecs either side of near, clamped to the video, or the whole video when near
+// is negative.
+func thumbnailSearchWindow(near, windowSecs, duration float64) mediaSegment {
+	if near < 0 {
+		return mediaSegment{Start: 0, End: duration}
+	}
+	return mediaSegment{Start: math.Max(near-windowSecs, 0), End: math.Min(near+windowSecs, duration)}
+}
+
+// buildThumbnailArgs returns the FFmpeg arguments that trim the input to seg, resample it to
+// thumbnailCandidates frames and write the one the thumbnail filter scores as most representative.
+// showinfo logs the chosen frame's pts_time, relative to seg.Start, which needs the info log level.
+func buildThumbnailArgs(localInputVideo, outputFile string, seg mediaSegment) []string {
+	length := seg.End - seg.Start
+	filter := fmt.Sprintf("fps=%s,thumbnail=n=%d,showinfo", strconv.FormatFloat(thumbnailCandidates/length, 'f', 6, 64), thumbnailCandidates)
+	return []string{"-hide_banner", "-nostats", "-loglevel", "info", "-y",
+		"-ss", strconv.FormatFloat(seg.Start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(length, 'f', 3, 64),
+		"-i", localInputVideo,
+		"-map", "0:v:0", "-an",
+		"-vf", filter,
+		"-frames:v", "1", "-update", "1", "-q:v", "2",
+		outputFile,
+	}
+}
+
+// parseThumbnailTime returns the pts_time showinfo logged for the chosen frame.
+func parseThumbnailTime(output string) (float64, bool) {
+	m := scenePTSTimeRegex.FindStringSubmatch(output)
+	if m == nil {
+		return 0, false
+	}
+	t, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return math.Max(t, 0), true
+}
+
+// parseAspectRatio reads an aspect ratio written as W:H with positive whole numbers, e.g. "9:16".
+func parseAspectRatio(ratio string) (w, h int, ok bool) {
+	var rest string
+	n, _ := fmt.Sscanf(strings.TrimSpace(ratio), "%d:%d%s", &w, &h, &rest)
+	if n != 2 || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// blurFillDimensions returns the output size of a blur fill to the aspect ratio aspectW:aspectH.
+// The longer side of the output is the longer side of the input, so a 1920x1080 source becomes
+// 1080x1920 at 9:16; both sides are even, as libx264 requires.
+func blurFillDimensions(inputW, inputH, aspectW, aspectH int) (width, height int) {
+	long := float64(max(inputW, inputH))
+	if aspectH >= aspectW {
+		return evenFloor(long * float64(aspectW) / float64(aspectH)), evenFloor(long)
+	}
+	return evenFloor(long), evenFloor(long * float64(aspectH) / float64(aspectW))
+}
+
+// buildBlurFillFilter splits the video in two: the background copy is scaled to cover the whole
+// width x height frame, cropped and blurred with gblur, and the foreground copy is scaled to fit
+// inside the frame and overlaid in the middle, so the bars show a blurred version of the picture.
+func buildBlurFillFilter(width, height int, sigma float64) string {
+	return fmt.Sprintf("[0:v]split=2[bg][fg];"+
+		"[bg]scale=%[1]d:%[2]d:force_original_aspect_ratio=increase,crop=%[1]d:%[2]d,gblur=sigma=%[3]g[blurred];"+
+		"[fg]scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease[sharp];"+
+		"[blurred][sharp]overlay=(W-w)/2:(H-h)/2,setsar=1,format=yuv420p[v]", width, height, sigma)
+}
+
+// buildBlurFillArgs returns the FFmpeg arguments that render a blur fill, keeping the input's
+// first audio stream if it has one.
+func buildBlurFillArgs(localInputVideo, outputFile, filter string) []string {
+	return []string{"-y", "-i", localInputVideo,
+		"-filter_complex", filter,
+		"-map", "[v]", "-map", "0:a:0?",
+		"-c:v", "libx264", "-preset", "medium", "-crf", "18",
+		"-c:a", "aac",
+		"-movflags", "+faststart",
+		outputFile,
+	}
+}
+
+// countdownPositions maps the countdown position presets to drawtext x/y expressions. The corner
+// and edge presets keep a margin of 1/20 of the frame height.
+var countdownPositions = map[string][2]string{
+	"center":        {"(w-text_w)/2", "(h-text_h)/2"},
+	"top_left":      {"h/20", "h/20"},
+	"top_center":    {"(w-text_w)/2", "h/20"},
+	"top_right":     {"w-text_w-h/20", "h/20"},
+	"bottom_left":   {"h/20", "h-text_h-h/20"},
+	"bottom_center": {"(w-text_w)/2", "h-text_h-h/20"},
+	"bottom_right":  {"w-text_w-h/20", "h-text_h-h/20"},
+}
+
+// countdownPositionNames lists the presets in countdownPositions, in the order they are documented.
+var countdownPositionNames = []string{"center", "top_left", "top_center", "top_right", "bottom_left", "bottom_center", "bottom_right"}
+
+// countdownOverlay describes the countdown burned in by ffmpeg_countdown_overlay.
+type countdownOverlay struct {
+	DurationSecs float64
+	StartSecs    float64
+	Format       string // "seconds" or "mm:ss"
+	Position     string // a key of countdownPositions
+	FontSize     int    // 0 scales the text with the frame height
+	FontColor    string
+	BoxColor     string // empty draws no box behind the text
+	FontFile     string // empty uses the fontconfig default
+}
+
+// countdownTextExpr returns the drawtext text that shows the remaining time. The remaining seconds
+// are ceil(end - t), so a 10-second timer shows 10 for its first second and 1 for its last. The
+// colons that separate the arguments of %{eif} are escaped, as drawtext requires.
+func countdownTextExpr(durationSecs, startSecs float64, format string) string {
+	remaining := fmt.Sprintf("ceil(%s-t)", strconv.FormatFloat(startSecs+durationSecs, 'f', -1, 64))
+	if format == "mm:ss" {
+		return fmt.Sprintf(`%%{eif\:floor(%s/60)\:d\:2}\:%%{eif\:mod(%s,60)\:d\:2}`, remaining, remaining)
+	}
+	return fmt.Sprintf(`%%{eif\:%s\:d}`, remaining)
+}
+
+// buildCountdownFilter returns the drawtext filter for the countdown. It is only enabled while the
+// timer runs, from StartSecs until StartSecs+DurationSecs.
+func buildCountdownFilter(c countdownOverlay) string {
+	pos := countdownPositions[c.Position]
+	fontSize := "h/8"
+	if c.FontSize > 0 {
+		fontSize = strconv.Itoa(c.FontSize)
+	}
+	start := strconv.FormatFloat(c.StartSecs, 'f', -1, 64)
+	end := strconv.FormatFloat(c.StartSecs+c.DurationSecs, 'f', -1, 64)
+	opts := []string{}
+	if c.FontFile != "" {
+		opts = append(opts, fmt.Sprintf("fontfile='%s'", escapeFilterPath(c.FontFile)))
+	}
+	opts = append(opts,
+		fmt.Sprintf("text='%s'", countdownTextExpr(c.DurationSecs, c.StartSecs, c.Format)),
+		"fontsize="+fontSize,
+		"fontcolor="+c.FontColor,
+		"x="+pos[0],
+		"y="+pos[1],
+	)
+	if c.BoxColor != "" {
+		opts = append(opts, "box=1", "boxcolor="+c.BoxColor, "boxborderw=20")
+	}
+	opts = append(opts, fmt.Sprintf("enable='gte(t,%s)*lt(t,%s)'", start, end))
+	return "drawtext=" + strings.Join(opts, ":")
+}
+
+// escapeFilterPath escapes the colons of a file path for use inside a single-quoted filter option
+// value. Paths containing quotes or backslashes cannot be escaped reliably for both parsing levels
+// of a filter graph and must be rejected by the caller (see filterPathSafe).
+func escapeFilterPath(path string) string {
+	return strings.ReplaceAll(path, ":", `\:`)
+}
+
+// filterPathSafe reports whether escapeFilterPath can make path safe for a filter option.
+func filterPathSafe(path string) bool {
+	return !strings.ContainsAny(path, `'\`)
+}
+
+// buildDrawtextArgs returns the FFmpeg arguments that burn a drawtext filter chain, such as the
+// countdown or timed captions, into the video, copying any audio unchanged.
+func buildDrawtextArgs(localInputVideo, outputFile, filter string) []string {
+	return []string{"-y", "-i", localInputVideo,
+		"-map", "0:v:0", "-map", "0:a?",
+		"-vf", filter,
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		outputFile,
+	}
+}
+
+// Defaults and limits for ffmpeg_caption_text. A screen is at most two or three lines at the
+// default 42 characters per line, the usual limit for broadcast captions. maxCaptionScreens keeps
+// the filter chain, one drawtext per screen, well under the command-line length limit.
+const (
+	defaultCaptionWordsPerScreen = 10
+	maxCaptionWordsPerScreen     = 50
+	defaultCaptionCharsPerLine   = 42
+	minCaptionCharsPerLine       = 10
+	maxCaptionCharsPerLine       = 200
+	maxCaptionScreens            = 300
+)
+
+// captionScreen is one screen of caption text and the time it is shown, from StartSecs up to
+// but not including EndSecs.
+type captionScreen struct {
+	Text      string
+	StartSecs float64
+	EndSecs   float64
+}
+
+// captionStyle is the look of the captions burned in by ffmpeg_caption_text.
+type captionStyle struct {
+	FontSize  int // 0 scales the text with the frame height
+	FontColor string
+	BoxColor  string // empty draws no box behind the text
+	FontFile  string // empty uses the fontconfig default
+}
+
+// wrapCaptionLines joins words into lines of at most maxChars characters, breaking only between
+// words. A word longer than maxChars gets a line of its own.
+func wrapCaptionLines(words []string, maxChars int) []string {
+	var lines []string
+	line := ""
+	for _, word := range words {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= maxChars:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// buildCaptionScreens splits the transcript into screens of wordsPerScreen words, each wrapped to
+// maxCharsPerLine, and times them from startSecs. With a positive secondsPerScreen every screen is
+// shown for that long. Otherwise the screens share spanSecs in proportion to their word count, so
+// the shorter last screen is shown for less time and the captions end with spanSecs.
+func buildCaptionScreens(transcript string, wordsPerScreen, maxCharsPerLine int, startSecs, secondsPerScreen, spanSecs float64) []captionScreen {
+	words := strings.Fields(transcript)
+	var screens []captionScreen
+	for first := 0; first < len(words); first += wordsPerScreen {
+		last := min(first+wordsPerScreen, len(words))
+		screen := captionScreen{Text: strings.Join(wrapCaptionLines(words[first:last], maxCharsPerLine), "\n")}
+		if secondsPerScreen > 0 {
+			n := float64(len(screens))
+			screen.StartSecs = startSecs + n*secondsPerScreen
+			screen.EndSecs = startSecs + (n+1)*secondsPerScreen
+		} else {
+			// Times come from the word offsets, so consecutive screens meet exactly.
+			screen.StartSecs = startSecs + spanSecs*float64(first)/float64(len(words))
+			screen.EndSecs = startSecs + spanSecs*float64(last)/float64(len(words))
+		}
+		screens = append(screens, screen)
+	}
+	return screens
+}
+
+// buildCaptionTextFilter returns the drawtext filter chain for the caption screens, one drawtext
+// per screen enabled only while the screen is shown. Each screen's text is read from its entry in
+// textFiles with expansion disabled, so the transcript needs no filter escaping. The text is
+// centered in the lower third of the frame.
+func buildCaptionTextFilter(screens []captionScreen, textFiles []string, style captionStyle) string {
+	fontSize := "h/20"
+	if style.FontSize > 0 {
+		fontSize = strconv.Itoa(style.FontSize)
+	}
+	filters := make([]string, 0, len(screens))
+	for i, screen := range screens {
+		opts := []string{}
+		if style.FontFile != "" {
+			opts = append(opts, fmt.Sprintf("fontfile='%s'", escapeFilterPath(style.FontFile)))
+		}
+		opts = append(opts,
+			fmt.Sprintf("textfile='%s'", escapeFilterPath(textFiles[i])),
+			"expansion=none",
+			"fontsize="+fontSize,
+			"fontcolor="+style.FontColor,
+			"x=(w-text_w)/2",
+			"y=h-text_h-h/10",
+		)
+		if style.BoxColor != "" {
+			opts = append(opts, "box=1", "boxcolor="+style.BoxColor, "boxborderw=12")
+		}
+		opts = append(opts, fmt.Sprintf("enable='gte(t,%.3f)*lt(t,%.3f)'", screen.StartSecs, screen.EndSecs))
+		filters = append(filters, "drawtext="+strings.Join(opts, ":"))
+	}
+	return strings.Join(filters, ",")
+}
+
+// buildTrimArgs returns the FFmpeg arguments that cut startSecs to endSecs out of the input; an
+// endSecs of 0 keeps everything up to the end.
+//
+// By default -ss comes before -i, so FFmpeg seeks in the input and copies the streams without
+// decoding them. That is fast and lossless, but the cut can only start on a keyframe for video,
+// or on a frame boundary (about 20-26ms) for compressed audio, so it may start a little early.
+// With sampleAccurate, -ss and -to come after -i: FFmpeg decodes from the start of the input,
+// drops everything before the cut point and re-encodes, so the cut lands on the exact sample.
+// That costs a decode of everything up to the cut and one generation of re-encoding loss.
+func buildTrimArgs(localInput, outputFile string, startSecs, endSecs float64, sampleAccurate bool) []string {
+	start := fmt.Sprintf("%.3f", startSecs)
+	if sampleAccurate {
+		args := []string{"-y", "-i", localInput, "-ss", start}
+		if endSecs > 0 {
+			args = append(args, "-to", fmt.Sprintf("%.3f", endSecs))
+		}
+		return append(args, outputFile)
+	}
+	args := []string{"-y", "-ss", start, "-i", localInput}
+	if endSecs > 0 {
+		// Input seeking resets timestamps to zero at the cut, so the end is given as a duration.
+		args = append(args, "-t", fmt.Sprintf("%.3f", endSecs-startSecs))
+	}
+	return append(args, "-c", "copy", "-avoid_negative_ts", "make_zero", outputFile)
+}
+
+// hlsMasterPlaylistName is the name of the master playlist in an HLS package. Each rendition's
+// playlist and segments go in a 'stream_<height>p' directory next to it.
+const hlsMasterPlaylistName = "master.m3u8"
+
+const (
+	maxHLSRenditions      = 6
+	maxHLSSegmentDuration = 30
+)
+
+// packageNameRegex limits an HLS package name to a single safe path segment, since it names both
+// a local directory and a GCS object prefix.
+var packageNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// hlsRendition is one variant of an HLS package: the source scaled to Height (keeping the aspect
+// ratio) and encoded at Bitrate, an FFmpeg bitrate string such as "5M" or "800k".
+type hlsRendition struct {
+	Height  int
+	Bitrate string
+}
+
+// name is the rendition's name in the var_stream_map, which also names its directory.
+func (r hlsRendition) name() string {
+	return fmt.Sprintf("%dp", r.Height)
+}
+
+var bitrateRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)([kKmM]?)$`)
+
+// parseBitrate converts an FFmpeg bitrate string ("5M", "2.5M", "800k" or plain bits per second)
+// to bits per second.
+func parseBitrate(bitrate string) (int64, error) {
+	m := bitrateRegex.FindStringSubmatch(strings.TrimSpace(bitrate))
+	if m == nil {
+		return 0, fmt.Errorf("must be a bitrate such as '5M', '800k' or '2500000', got '%s'", bitrate)
+	}
+	value, _ := strconv.ParseFloat(m[1], 64)
+	switch strings.ToLower(m[2]) {
+	case "k":
+		value *= 1000
+	case "m":
+		value *= 1000 * 1000
+	}
+	if value < 1000 {
+		return 0, fmt.Errorf("must be at least 1k, got '%s'", bitrate)
+	}
+	return int64(value), nil
+}
+
+// buildHLSFilterGraph splits the source video once per rendition and scales each copy to its
+// height; the outputs are labelled [v0out], [v1out], ... in rendition order. The width is derived
+// from the aspect ratio and rounded to an even number, as libx264 requires.
+func buildHLSFilterGraph(renditions []hlsRendition) string {
+	var splits strings.Builder
+	parts := make([]string, 0, len(renditions)+1)
+	for i := range renditions {
+		fmt.Fprintf(&splits, "[v%d]", i)
+	}
+	parts = append(parts, fmt.Sprintf("[0:v]split=%d%s", len(renditions), splits.String()))
+	for i, r := range renditions {
+		parts = append(parts, fmt.Sprintf("[v%d]scale=-2:%d[v%dout]", i, r.Height, i))
+	}
+	return strings.Join(parts, ";")
+}
+
+// buildHLSVarStreamMap pairs each scaled video stream with its own copy of the audio and names the
+// variant after its height, e.g. "v:0,a:0,name:1080p v:1,a:1,name:720p".
+func buildHLSVarStreamMap(renditions []hlsRendition, hasAudio bool) string {
+	variants := make([]string, len(renditions))
+	for i, r := range renditions {
+		if hasAudio {
+			variants[i] = fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.name())
+		} else {
+			variants[i] = fmt.Sprintf("v:%d,name:%s", i, r.name())
+		}
+	}
+	return strings.Join(variants, " ")
+}
+
+// buildHLSArgs returns the FFmpeg arguments that package the input as VOD HLS in outputDir.
+// Keyframes are forced every segmentSecs and scene-cut keyframes are disabled, so every rendition
+// has its segment boundaries at the same times and players can switch between them cleanly. Each
+// rendition is capped at its bitrate with a two-second buffer. Callers must have parsed each
+// rendition's bitrate with parseBitrate.
+func buildHLSArgs(localInputVideo, outputDir string, renditions []hlsRendition, segmentSecs float64, hasAudio bool) []string {
+	segment := strconv.FormatFloat(segmentSecs, 'f', -1, 64)
+	args := []string{"-y", "-i", localInputVideo, "-filter_complex", buildHLSFilterGraph(renditions)}
+	for i := range renditions {
+		args = append(args, "-map", fmt.Sprintf("[v%dout]", i))
+		if hasAudio {
+			args = append(args, "-map", "0:a:0")
+		}
+	}
+	args = append(args,
+		"-c:v", "libx264", "-preset", "medium", "-pix_fmt", "yuv420p",
+		"-sc_threshold", "0", "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%s)", segment),
+	)
+	for i, r := range renditions {
+		bps, _ := parseBitrate(r.Bitrate)
+		args = append(args,
+			fmt.Sprintf("-b:v:%d", i), r.Bitrate,
+			fmt.Sprintf("-maxrate:v:%d", i), r.Bitrate,
+			fmt.Sprintf("-bufsize:v:%d", i), strconv.FormatInt(2*bps, 10),
+		)
+	}
+	if hasAudio {
+		args = append(args, "-c:a", "aac", "-b:a", "128k", "-ac", "2")
+	}
+	return append(args,
+		"-f", "hls",
+		"-hls_time", segment,
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(outputDir, "stream_%v", "segment_%03d.ts"),
+		"-master_pl_name", hlsMasterPlaylistName,
+		"-var_stream_map", buildHLSVarStreamMap(renditions, hasAudio),
+		filepath.Join(outputDir, "stream_%v", "playlist.m3u8"),
+	)
+}
+
+// videoAnomaly is a stretch of black or frozen video. End is negative when it runs to the end
+// of the input.
+type videoAnomaly struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+}
+
+var (
+	blackDetectRegex    = regexp.MustCompile(`black_start:\s*(-?[0-9.]+)\s+black_end:\s*(-?[0-9.]+)\s+black_duration:\s*([0-9.]+)`)
+	freezeStartRegex    = regexp.MustCompile(`lavfi\.freezedetect\.freeze_start:\s*(-?[0-9.]+)`)
+	freezeDurationRegex = regexp.MustCompile(`lavfi\.freezedetect\.freeze_duration:\s*([0-9.]+)`)
+	freezeEndRegex      = regexp.MustCompile(`lavfi\.freezedetect\.freeze_end:\s*(-?[0-9.]+)`)
+)
+
+// buildDetectAnomaliesArgs returns the FFmpeg arguments that run blackdetect and freezedetect
+// over the first video stream, both with the same minimum interval length.
+func buildDetectAnomaliesArgs(localInputVideo string, minDuration float64) []string {
+	videoFilter := fmt.Sprintf("blackdetect=d=%g,freezedetect=d=%g", minDuration, minDuration)
+	// Both filters report at info level, so pin it regardless of the requested ffmpeg_log_level.
+	return []string{"-hide_banner", "-nostats", "-loglevel", "info", "-i", localInputVideo, "-map", "0:v:0", "-vf", videoFilter, "-f", "null", "-"}
+}
+
+// executeDetectAnomalies runs blackdetect and freezedetect over the input and returns FFMpeg's
+// combined output, which carries the markers of both filters on stderr.
+func executeDetectAnomalies(ctx context.Context, localInputVideo string, minDuration float64) (string, error) {
+	return runFFmpegCommand(ctx, buildDetectAnomaliesArgs(localInputVideo, minDuration)...)
+}
+
+// parseBlackDetectOutput extracts the black intervals from blackdetect's stderr, which logs one
+// "black_start:S black_end:E black_duration:D" line per interval.
+func parseBlackDetectOutput(output string) []videoAnomaly {
+	var intervals []videoAnomaly
+	for _, m := range blackDetectRegex.FindAllStringSubmatch(output, -1) {
+		start, errStart := strconv.ParseFloat(m[1], 64)
+		end, errEnd := strconv.ParseFloat(m[2], 64)
+		duration, errDuration := strconv.ParseFloat(m[3], 64)
+		if errStart != nil || errEnd != nil || errDuration != nil {
+			continue
+		}
+		intervals = append(intervals, videoAnomaly{Start: math.Max(start, 0), End: end, Duration: duration})
+	}
+	return intervals
+}
+
+// parseFreezeDetectOutput extracts the frozen intervals from freezedetect's stderr. Each freeze
+// is logged as a freeze_start line and, once motion resumes, freeze_duration and freeze_end
+// lines. A freeze still running at the end of the input has no end; it is returned with End and
+// Duration set to -1.
+func parseFreezeDetectOutput(output string) []videoAnomaly {
+	var intervals []videoAnomaly
+	open := false
+	for _, line := range strings.Split(output, "\n") {
+		if m := freezeStartRegex.FindStringSubmatch(line); m != nil {
+			start, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			intervals = append(intervals, videoAnomaly{Start: math.Max(start, 0), End: -1, Duration: -1})
+			open = true
+			continue
+		}
+		if !open {
+			continue
+		}
+		if m := freezeDurationRegex.FindStringSubmatch(line); m != nil {
+			if duration, err := strconv.ParseFloat(m[1], 64); err == nil {
+				intervals[len(intervals)-1].Duration = duration
+			}
+			continue
+		}
+		if m := freezeEndRegex.FindStringSubmatch(line); m != nil {
+			if end, err := strconv.ParseFloat(m[1], 64); err == nil {
+				intervals[len(intervals)-1].End = end
+				if intervals[len(intervals)-1].Duration < 0 {
+					intervals[len(intervals)-1].Duration = end - intervals[len(intervals)-1].Start
+				}
+			}
+			open = false
+		}
+	}
+	return intervals
+}
+
+// closeOpenAnomalies ends the intervals still running at the end of the input at totalDuration.
+// They are left open when the duration is unknown.
+func closeOpenAnomalies(intervals []videoAnomaly, totalDuration float64) {
+	if totalDuration <= 0 {
+		return
+	}
+	for i := range intervals {
+		if intervals[i].End < 0 {
+			intervals[i].End = totalDuration
+			intervals[i].Duration = math.Max(totalDuration-intervals[i].Start, 0)
+		}
+	}
+}
+
+// Speed limits of ffmpeg_speed_ramp. Below 0.1x a clip shows the same frame for too long to read
+// as motion; above 10x the audio is unintelligible even when retimed.
+const (
+	minSpeedRampSpeed = 0.1
+	maxSpeedRampSpeed = 10
+)
+
+// speedKeyframe sets the playback speed at a time in the input, in seconds. Between two
+// keyframes the speed changes linearly; before the first and after the last it is constant.
+type speedKeyframe struct {
+	Time  float64
+	Speed float64
+}
+
+// speedRampSegment is the stretch of input from one keyframe to the next. Slope is the change of
+// speed per input second, and OutputStart is the output time of Start.
+type speedRampSegment struct {
+	Start, End  float64
+	Speed       float64
+	Slope       float64
+	OutputStart float64
+}
+
+// outputOffset returns how far into the output the segment has reached at input time t. At speed
+// s(t) = Speed + Slope*(t-Start) an input instant dt lasts dt/s(t) in the output, which
+// integrates to ln(s(t)/Speed)/Slope, or (t-Start)/Speed at a constant speed.
+func (s speedRampSegment) outputOffset(t float64) float64 {
+	if math.Abs(s.Slope) < 1e-9 {
+		return (t - s.Start) / s.Speed
+	}
+	return math.Log((s.Speed+s.Slope*(t-s.Start))/s.Speed) / s.Slope
+}
+
+// speedRampSegments splits the input at the keyframes, which must be in increasing order of
+// time. The first segment runs from 0 at the first keyframe's speed, and the last from the last
+// keyframe to the end of the input (math.Inf(1)) at its speed.
+func speedRampSegments(keyframes []speedKeyframe) []speedRampSegment {
+	var segments []speedRampSegment
+	if first := keyframes[0]; first.Time > 0 {
+		segments = append(segments, speedRampSegment{Start: 0, End: first.Time, Speed: first.Speed})
+	}
+	for i, kf := range keyframes {
+		segment := speedRampSegment{Start: kf.Time, End: math.Inf(1), Speed: kf.Speed}
+		if i+1 < len(keyframes) {
+			next := keyframes[i+1]
+			segment.End = next.Time
+			segment.Slope = (next.Speed - kf.Speed) / (next.Time - kf.Time)
+		}
+		segments = append(segments, segment)
+	}
+	for i := 1; i < len(segments); i++ {
+		prev := segments[i-1]
+		segments[i].OutputStart = prev.OutputStart + prev.outputOffset(prev.End)
+	}
+	return segments
+}
+
+// speedRampOutputTime returns the output time of input time t.
+func speedRampOutputTime(segments []speedRampSegment, t float64) float64 {
+	for _, s := range segments {
+		if t <= s.End {
+			return s.OutputStart + s.outputOffset(t)
+		}
+	}
+	last := segments[len(segments)-1]
+	return last.OutputStart + last.outputOffset(t)
+}
+
+// speedRampPTSExpr returns the setpts expression that maps each frame's input time T to its
+// output time, as nested if(lt(T,end),...) over the segments. T must count from 0, so the
+// timestamps are reset with PTS-STARTPTS first.
+func speedRampPTSExpr(segments []speedRampSegment) string {
+	var pieces []string
+	for _, s := range segments {
+		piece := fmt.Sprintf("(T-%g)/%g", s.Start, s.Speed)
+		if math.Abs(s.Slope) >= 1e-9 {
+			piece = fmt.Sprintf("log((%g%+.6f*(T-%g))/%g)/(%.6f)", s.Speed, s.Slope, s.Start, s.Speed, s.Slope)
+		}
+		if s.OutputStart != 0 {
+			piece = fmt.Sprintf("%.6f+%s", s.OutputStart, piece)
+		}
+		pieces = append(pieces, piece)
+	}
+	expr := pieces[len(pieces)-1]
+	for i := len(segments) - 2; i >= 0; i-- {
+		expr = fmt.Sprintf("if(lt(T,%g),%s,%s)", segments[i].End, pieces[i], expr)
+	}
+	return expr
+}
+
+// buildSpeedRampFilter returns the filter graph that retimes the video into [v] and, when
+// retimeAudio is set, the audio into [a]. The video is resampled to frameRate, duplicating frames
+// in the slow parts and dropping them in the fast ones, or with smooth set, interpolated with
+// minterpolate's motion compensation, which is much slower. atempo cannot vary continuously, so the
+// audio is cut at the keyframes and each piece is played at the constant tempo that makes it last
+// as long as the video over it; audio and video meet again at every keyframe.
+func buildSpeedRampFilter(segments []speedRampSegment, inputDuration, frameRate float64, smooth, retimeAudio bool) string {
+	resample := fmt.Sprintf("fps=%g", frameRate)
+	if smooth {
+		resample = fmt.Sprintf("minterpolate=fps=%g:mi_mode=mci:mc_mode=aobmc:me_mode=bidir:vsbmc=1", frameRate)
+	}
+	filter := fmt.Sprintf("[0:v]setpts=PTS-STARTPTS,setpts='(%s)/TB',%s[v]", speedRampPTSExpr(segments), resample)
+	if !retimeAudio {
+		return filter
+	}
+
+	var pieces []mediaSegment
+	for _, s := range segments {
+		if s.Start >= inputDuration {
+			break
+		}
+		pieces = append(pieces, mediaSegment{Start: s.Start, End: math.Min(s.End, inputDuration)})
+	}
+	var chains []string
+	for i, p := range pieces {
+		tempo := (p.End - p.Start) / (speedRampOutputTime(segments, p.End) - speedRampOutputTime(segments, p.Start))
+		var chain []string
+		switch {
+		case len(pieces) == 1:
+		case i == len(pieces)-1:
+			// Leave the end open, in case the audio runs a little past the probed duration.
+			chain = append(chain, fmt.Sprintf("atrim=start=%.3f", p.Start))
+		default:
+			chain = append(chain, fmt.Sprintf("atrim=start=%.3f:end=%.3f", p.Start, p.End))
+		}
+		chains = append(chains, strings.Join(append(append(chain, "asetpts=PTS-STARTPTS"), atempoChain(tempo)...), ","))
+	}
+	if len(chains) == 1 {
+		return filter + ";[0:a]" + chains[0] + "[a]"
+	}
+	filter += fmt.Sprintf(";[0:a]asplit=%d", len(chains))
+	for i := range chains {
+		filter += fmt.Sprintf("[as%d]", i)
+	}
+	var concatInputs string
+	for i, chain := range chains {
+		filter += fmt.Sprintf(";[as%d]%s[ar%d]", i, chain, i)
+		concatInputs += fmt.Sprintf("[ar%d]", i)
+	}
+	return filter + fmt.Sprintf(";%sconcat=n=%d:v=0:a=1[a]", concatInputs, len(chains))
+}
+
+// buildSpeedRampArgs returns the FFmpeg arguments for a speed ramp. Without retimed audio the
+// output has no audio track.
+func buildSpeedRampArgs(localInputVideo, outputFile, filter string, retimeAudio bool) []string {
+	args := []string{"-y", "-i", localInputVideo, "-filter_complex", filter, "-map", "[v]"}
+	if retimeAudio {
+		args = append(args, "-map", "[a]", "-c:a", "aac")
+	} else {
+		args = append(args, "-an")
+	}
+	return append(args, "-c:v", "libx264", "-preset", "medium", "-crf", "18", "-pix_fmt", "yuv420p", "-movflags", "+faststart", outputFile)
+}
@@ -2,7 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 func TestRunFFmpegCommand(t *testing.T) {
@@ -13,3 +24,1236 @@ func TestRunFFmpegCommand(t *testing.T) {
 		t.Errorf("expected no error, but got: %v", err)
 	}
 }
+
+func TestParseSilenceDetectOutput(t *testing.T) {
+	output := `Input #0, wav, from 'podcast.wav':
+  Duration: 00:01:05.50, bitrate: 1411 kb/s
+[silencedetect @ 0x600000c3c000] silence_start: -0.0120181
+[silencedetect @ 0x600000c3c000] silence_end: 1.25 | silence_duration: 1.26202
+[silencedetect @ 0x600000c3c000] silence_start: 20.5
+[silencedetect @ 0x600000c3c000] silence_end: 23 | silence_duration: 2.5
+size=N/A time=00:01:05.50 bitrate=N/A speed= 300x
+[silencedetect @ 0x600000c3c000] silence_start: 62.75
+`
+	expected := []silenceInterval{{0, 1.25}, {20.5, 23}, {62.75, -1}}
+
+	actual := parseSilenceDetectOutput(output)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d intervals, but got %d: %v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("interval %d: expected %v, but got %v", i, expected[i], actual[i])
+		}
+	}
+
+	duration, ok := parseFFmpegDuration(output)
+	if !ok || duration != 65.5 {
+		t.Errorf("expected duration 65.5, but got %v (found: %t)", duration, ok)
+	}
+
+	segments := computeNonSilentSegments(actual, duration, 0.1)
+	expectedSegments := []mediaSegment{{1.25, 20.5}, {23, 62.75}}
+	if len(segments) != len(expectedSegments) {
+		t.Fatalf("expected %d segments, but got %d: %v", len(expectedSegments), len(segments), segments)
+	}
+	for i := range expectedSegments {
+		if segments[i] != expectedSegments[i] {
+			t.Errorf("segment %d: expected %v, but got %v", i, expectedSegments[i], segments[i])
+		}
+	}
+}
+
+func TestParseBlackDetectOutput(t *testing.T) {
+	output := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'clip.mp4':
+  Duration: 00:00:08.00, start: 0.000000, bitrate: 2411 kb/s
+[blackdetect @ 0x600001d4c0b0] black_start:0 black_end:1.04 black_duration:1.04
+frame=  120 fps=0.0 q=-0.0 size=N/A time=00:00:04.00 bitrate=N/A speed=8.1x
+[blackdetect @ 0x600001d4c0b0] black_start:5.5 black_end:8 black_duration:2.5
+`
+	expected := []videoAnomaly{{0, 1.04, 1.04}, {5.5, 8, 2.5}}
+
+	actual := parseBlackDetectOutput(output)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d intervals, but got %d: %v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("interval %d: expected %v, but got %v", i, expected[i], actual[i])
+		}
+	}
+
+	if actual := parseBlackDetectOutput("[blackdetect @ 0x1] black_start:1"); len(actual) != 0 {
+		t.Errorf("expected a partial line to be ignored, got %v", actual)
+	}
+}
+
+func TestParseFreezeDetectOutput(t *testing.T) {
+	output := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'clip.mp4':
+  Duration: 00:00:10.00, start: 0.000000, bitrate: 2411 kb/s
+[freezedetect @ 0x6000038d8000] lavfi.freezedetect.freeze_start: 2.002
+[freezedetect @ 0x6000038d8000] lavfi.freezedetect.freeze_duration: 1.5015
+[freezedetect @ 0x6000038d8000] lavfi.freezedetect.freeze_end: 3.5035
+frame=  240 fps=0.0 q=-0.0 size=N/A time=00:00:08.00 bitrate=N/A speed=7.9x
+[freezedetect @ 0x6000038d8000] lavfi.freezedetect.freeze_start: 7.5
+`
+	expected := []videoAnomaly{{2.002, 3.5035, 1.5015}, {7.5, -1, -1}}
+
+	actual := parseFreezeDetectOutput(output)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d intervals, but got %d: %v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("interval %d: expected %v, but got %v", i, expected[i], actual[i])
+		}
+	}
+
+	duration, ok := parseFFmpegDuration(output)
+	if !ok || duration != 10 {
+		t.Fatalf("expected duration 10, but got %v (found: %t)", duration, ok)
+	}
+	closeOpenAnomalies(actual, duration)
+	if actual[1] != (videoAnomaly{7.5, 10, 2.5}) {
+		t.Errorf("expected the open freeze to run to the end, got %v", actual[1])
+	}
+	if actual[0] != expected[0] {
+		t.Errorf("expected the closed freeze to be unchanged, got %v", actual[0])
+	}
+}
+
+func TestBuildDetectAnomaliesArgs(t *testing.T) {
+	line := strings.Join(buildDetectAnomaliesArgs("in.mp4", 0.5), " ")
+	for _, want := range []string{"-loglevel info -i in.mp4", "-map 0:v:0", "-vf blackdetect=d=0.5,freezedetect=d=0.5", "-f null -"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("command line is missing %q: %s", want, line)
+		}
+	}
+}
+
+func TestComputeNonSilentSegmentsWithoutSilence(t *testing.T) {
+	segments := computeNonSilentSegments(nil, 10, 0.1)
+	if len(segments) != 1 || segments[0] != (mediaSegment{0, 10}) {
+		t.Errorf("expected a single segment covering the input, but got %v", segments)
+	}
+}
+
+func TestComputeVoiceNoteBitrate(t *testing.T) {
+	testCases := []struct {
+		name         string
+		durationSecs float64
+		maxSizeBytes int64
+		expected     int
+		expectErr    bool
+	}{
+		{"short clip is capped at max bitrate", 10, 1024 * 1024, voiceNoteMaxBitrateKbps, false},
+		{"three minutes under 1 MB", 180, 1024 * 1024, 44, false},
+		{"five minutes under 1 MB", 300, 1024 * 1024, 26, false},
+		{"exactly at floor", 600, 947_369, 12, false},
+		{"floor exceeds limit", 900, 1024 * 1024, 0, true},
+		{"zero duration", 0, 1024 * 1024, 0, true},
+		{"zero size", 60, 0, 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := computeVoiceNoteBitrate(tc.durationSecs, tc.maxSizeBytes)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, but got bitrate %d", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %d kbps, but got %d kbps", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSelectVoiceNoteContainer(t *testing.T) {
+	testCases := []struct {
+		name           string
+		container      string
+		outputFileName string
+		expected       string
+		expectErr      bool
+	}{
+		{"default", "", "", "ogg", false},
+		{"explicit webm", "webm", "", "webm", false},
+		{"inferred from file name", "", "note.webm", "webm", false},
+		{"opus extension maps to ogg", "", "note.opus", "ogg", false},
+		{"case insensitive", "OGG", "note.ogg", "ogg", false},
+		{"mismatched extension", "ogg", "note.webm", "", true},
+		{"unsupported container", "mp3", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := selectVoiceNoteContainer(tc.container, tc.outputFileName)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, but got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %q, but got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestApplyFFmpegLogLevel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		level    string
+		expected string
+	}{
+		{"no level keeps args", []string{"-y", "-i", "in.wav", "out.mp3"}, "", "-y -i in.wav out.mp3"},
+		{"level is prepended", []string{"-y", "-i", "in.wav", "out.mp3"}, "debug", "-loglevel debug -y -i in.wav out.mp3"},
+		{"explicit loglevel wins", []string{"-loglevel", "info", "-i", "in.wav"}, "error", "-loglevel info -i in.wav"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := strings.Join(applyFFmpegLogLevel(tc.args, tc.level), " ")
+			if actual != tc.expected {
+				t.Errorf("expected '%s', but got '%s'", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestResolveAudioGaps(t *testing.T) {
+	gaps, err := resolveAudioGaps(3, 1.5, nil)
+	if err != nil || len(gaps) != 2 || gaps[0] != 1.5 || gaps[1] != 1.5 {
+		t.Errorf("expected two uniform 1.5s gaps, got %v (err: %v)", gaps, err)
+	}
+	gaps, err = resolveAudioGaps(3, 1.5, []float64{0.25, 2})
+	if err != nil || gaps[0] != 0.25 || gaps[1] != 2 {
+		t.Errorf("expected per-gap durations to take precedence, got %v (err: %v)", gaps, err)
+	}
+	if gaps, err := resolveAudioGaps(1, 1, nil); err != nil || len(gaps) != 0 {
+		t.Errorf("expected no gaps for a single clip, got %v (err: %v)", gaps, err)
+	}
+	if _, err := resolveAudioGaps(3, 1, []float64{1}); err == nil {
+		t.Error("expected an error when the per-gap list does not match the clip count")
+	}
+	if _, err := resolveAudioGaps(2, -1, nil); err == nil {
+		t.Error("expected an error for a negative gap")
+	}
+}
+
+func TestBuildGapConcatFilter(t *testing.T) {
+	format := audioFormat{SampleRate: 44100, Channels: 1, CodecName: "pcm_s16le"}
+
+	if got, want := anullsrcFilter("gap0", 0.5, format), "anullsrc=r=44100:cl=mono,atrim=end_sample=22050[gap0]"; got != want {
+		t.Errorf("anullsrcFilter:\n got %s\nwant %s", got, want)
+	}
+
+	filter := buildGapConcatFilter(3, []float64{1, 0}, format)
+	expected := strings.Join([]string{
+		"[0:a]aresample=44100,aformat=sample_rates=44100:channel_layouts=mono[clip0]",
+		"anullsrc=r=44100:cl=mono,atrim=end_sample=44100[gap0]",
+		"[1:a]aresample=44100,aformat=sample_rates=44100:channel_layouts=mono[clip1]",
+		"[2:a]aresample=44100,aformat=sample_rates=44100:channel_layouts=mono[clip2]",
+		"[clip0][gap0][clip1][clip2]concat=n=4:v=0:a=1[out]",
+	}, ";")
+	if filter != expected {
+		t.Errorf("buildGapConcatFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	if codec := pcmOutputCodec("wav", format, ""); codec != "pcm_s16le" {
+		t.Errorf("expected WAV output to keep the PCM codec, got %q", codec)
+	}
+	if codec := pcmOutputCodec("wav", audioFormat{SampleRate: 48000, Channels: 2, CodecName: "mp3"}, ""); codec != "pcm_s16le" {
+		t.Errorf("expected WAV output of compressed input to use 16-bit PCM, got %q", codec)
+	}
+	if codec := pcmOutputCodec("mp3", format, ""); codec != "" {
+		t.Errorf("expected the default encoder for non-WAV output, got %q", codec)
+	}
+}
+
+func TestBuildConcatStandardizeArgsForVFRInput(t *testing.T) {
+	cfrJSON := `{"streams": [
+		{"codec_type": "video", "r_frame_rate": "30000/1001", "avg_frame_rate": "30000/1001"},
+		{"codec_type": "audio"}
+	]}`
+	vfrJSON := `{"streams": [
+		{"codec_type": "video", "r_frame_rate": "60/1", "avg_frame_rate": "2147/77"},
+		{"codec_type": "audio"}
+	]}`
+
+	argsFor := func(mediaInfoJSON string) []string {
+		timing, err := parseVideoTiming(mediaInfoJSON)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return buildConcatStandardizeArgs("in.mp4", "out.mp4", false, timing.isVFR(), defaultStreams, 0)
+	}
+	cfrArgs := strings.Join(argsFor(cfrJSON), " ")
+	vfrArgs := strings.Join(argsFor(vfrJSON), " ")
+
+	for _, flag := range []string{"-vsync cfr", "-r 24", "-af aresample=async=1:first_pts=0"} {
+		if strings.Contains(cfrArgs, flag) {
+			t.Errorf("CFR input should not get %q: %s", flag, cfrArgs)
+		}
+		if !strings.Contains(vfrArgs, flag) {
+			t.Errorf("VFR input should get %q: %s", flag, vfrArgs)
+		}
+	}
+	if !strings.HasSuffix(vfrArgs, "-b:a 192k out.mp4") || !strings.HasSuffix(cfrArgs, "-b:a 192k out.mp4") {
+		t.Errorf("expected the output file last in both commands:\n%s\n%s", cfrArgs, vfrArgs)
+	}
+
+	audioArgs := strings.Join(buildConcatStandardizeArgs("in.wav", "out.mp4", true, true, defaultStreams, 0), " ")
+	if strings.Contains(audioArgs, "-vsync") || !strings.Contains(audioArgs, "-vn") {
+		t.Errorf("audio-only inputs should not get frame rate handling: %s", audioArgs)
+	}
+}
+
+func TestBuildConcatStandardizeArgsWithStreams(t *testing.T) {
+	testCases := []struct {
+		name      string
+		audioOnly bool
+		streams   streamSelection
+		want      string
+	}{
+		{"default streams", false, defaultStreams, "-y -i in.mkv -vf "},
+		{"audio track", false, streamSelection{Video: -1, Audio: 2}, "-y -i in.mkv -map 0:v:0 -map 0:2 -vf "},
+		{"video track", false, streamSelection{Video: 1, Audio: -1}, "-y -i in.mkv -map 0:1 -map 0:a:0? -vf "},
+		{"both tracks", false, streamSelection{Video: 0, Audio: 3}, "-y -i in.mkv -map 0:0 -map 0:3 -vf "},
+		{"audio-only track", true, streamSelection{Video: -1, Audio: 1}, "-y -i in.mkv -map 0:1 -vn "},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := strings.Join(buildConcatStandardizeArgs("in.mkv", "out.mp4", tc.audioOnly, false, tc.streams, 0), " ")
+			if !strings.HasPrefix(args, tc.want) {
+				t.Errorf("got %q, want it to start with %q", args, tc.want)
+			}
+			if strings.Count(args, "-map") != strings.Count(tc.want, "-map") {
+				t.Errorf("unexpected -map arguments: %s", args)
+			}
+		})
+	}
+}
+
+func TestBuildConcatStandardizeArgsWithLoudnorm(t *testing.T) {
+	testCases := []struct {
+		name      string
+		audioOnly bool
+		cfr       bool
+		want      string
+	}{
+		{"video", false, false, "fps=24 -af loudnorm=I=-23:TP=-1.5:LRA=11 -c:v libx264"},
+		{"cfr video", false, true, "-af aresample=async=1:first_pts=0,loudnorm=I=-23:TP=-1.5:LRA=11 -c:v libx264"},
+		{"audio only", true, true, "-vn -af loudnorm=I=-23:TP=-1.5:LRA=11 -c:a aac"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := strings.Join(buildConcatStandardizeArgs("in.mp4", "out.mp4", tc.audioOnly, tc.cfr, defaultStreams, -23), " ")
+			if !strings.Contains(args, tc.want) || strings.Count(args, "-af") != 1 {
+				t.Errorf("got %q, want a single audio filter chain %q", args, tc.want)
+			}
+		})
+	}
+	if args := strings.Join(buildConcatStandardizeArgs("in.mp4", "out.mp4", false, false, defaultStreams, 0), " "); strings.Contains(args, "-af") {
+		t.Errorf("expected no audio filter without a loudness target, got %q", args)
+	}
+}
+
+func TestBuildCombineAudioVideoArgs(t *testing.T) {
+	if got, want := strings.Join(buildCombineAudioVideoArgs("in.mp4", "dub.mkv", "out.mp4", -1), " "), "-y -i in.mp4 -i dub.mkv -map 0 -map 1:a -c:v copy -shortest out.mp4"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(buildCombineAudioVideoArgs("in.mp4", "dub.mkv", "out.mp4", 2), " "), "-y -i in.mp4 -i dub.mkv -map 0 -map 1:2 -c:v copy -shortest out.mp4"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBuildAdjustVolumeArgs(t *testing.T) {
+	if got, want := strings.Join(buildAdjustVolumeArgs("in.mp3", "out.mp3", -6, "", false), " "), "-y -i in.mp3 -af volume=-6dB out.mp3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(buildAdjustVolumeArgs("in.wav", "out.wav", 3, "pcm_s24le", true), " "), "-y -copyts -i in.wav -af volume=3dB -c:a pcm_s24le -map_metadata 0 -map_metadata:s:a 0:s:a out.wav"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBuildGIFArgsForImageSequence(t *testing.T) {
+	inputArgs := imageSequenceInputArgs("/tmp/gif/frames/frame_%04d.png", 2.5)
+	frameFilter := imageSequenceFrameFilter(512, 288)
+	scale := "scale=512:288:flags=lanczos+accurate_rnd+full_chroma_inp:force_original_aspect_ratio=decrease,pad=512:288:(ow-iw)/2:(oh-ih)/2"
+
+	palette := strings.Join(buildGIFPaletteArgs(inputArgs, frameFilter, "/tmp/gif/palette.png"), " ")
+	if want := "-y -framerate 2.5 -i /tmp/gif/frames/frame_%04d.png -vf " + scale + ",palettegen /tmp/gif/palette.png"; palette != want {
+		t.Errorf("palette pass:\n got %q\nwant %q", palette, want)
+	}
+	gif := strings.Join(buildGIFArgs(inputArgs, frameFilter, "/tmp/gif/palette.png", gifPaletteUse("bayer", 3), "/tmp/gif/out.gif"), " ")
+	if want := "-y -framerate 2.5 -i /tmp/gif/frames/frame_%04d.png -i /tmp/gif/palette.png -lavfi " + scale + " [x]; [x][1:v] paletteuse=dither=bayer:bayer_scale=3 /tmp/gif/out.gif"; gif != want {
+		t.Errorf("GIF pass:\n got %q\nwant %q", gif, want)
+	}
+	if got := gifPaletteUse("none", 3); got != "paletteuse=dither=none" {
+		t.Errorf("bayer_scale should only apply to bayer dithering, got %q", got)
+	}
+}
+
+func TestPlanCompression(t *testing.T) {
+	const target25MB = 25 * 1024 * 1024
+	hd := videoGeometry{Width: 1920, Height: 1080, FrameRate: 30, HasAudio: true}
+	hdSilent := videoGeometry{Width: 1920, Height: 1080, FrameRate: 30}
+	portrait := videoGeometry{Width: 1080, Height: 1920, FrameRate: 30, HasAudio: true}
+
+	testCases := []struct {
+		name      string
+		duration  float64
+		target    int64
+		geometry  videoGeometry
+		minBPP    float64
+		expected  compressionPlan
+		expectErr bool
+	}{
+		{"short input is capped at the bits-per-pixel ceiling", 10, target25MB, hd, 0.05,
+			compressionPlan{VideoBitrateKbps: 12441, AudioBitrateKbps: 128, Width: 1920, Height: 1080}, false},
+		{"two minutes downscales to meet the floor", 120, target25MB, hd, 0.05,
+			compressionPlan{VideoBitrateKbps: 1584, AudioBitrateKbps: 128, Width: 1370, Height: 770, Downscaled: true}, false},
+		{"ten minutes downscales further", 600, target25MB, hd, 0.05,
+			compressionPlan{VideoBitrateKbps: 214, AudioBitrateKbps: 128, Width: 502, Height: 282, Downscaled: true}, false},
+		{"portrait keeps its aspect ratio", 600, target25MB, portrait, 0.05,
+			compressionPlan{VideoBitrateKbps: 214, AudioBitrateKbps: 128, Width: 282, Height: 502, Downscaled: true}, false},
+		{"silent hour spends the whole budget on video", 3600, target25MB, hdSilent, 0.05,
+			compressionPlan{VideoBitrateKbps: 57, Width: 258, Height: 146, Downscaled: true}, false},
+		{"downscale stops at the minimum short side", 3600, target25MB, hdSilent, 0.2,
+			compressionPlan{VideoBitrateKbps: 57, Width: 256, Height: 144, Downscaled: true}, false},
+		{"hour with audio leaves no video budget", 3600, target25MB, hd, 0.05, compressionPlan{}, true},
+		{"zero duration", 0, target25MB, hd, 0.05, compressionPlan{}, true},
+		{"zero target", 60, 0, hd, 0.05, compressionPlan{}, true},
+		{"no video geometry", 60, target25MB, videoGeometry{HasAudio: true}, 0.05, compressionPlan{}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := planCompression(tc.duration, tc.target, compressDefaultAudioBitrateKbps, tc.geometry, tc.minBPP)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, but got plan %+v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected plan %+v, but got %+v", tc.expected, actual)
+			}
+			if actual.Downscaled && actual.Height > compressMinShortSide && actual.Width > compressMinShortSide {
+				if bpp := actual.bitsPerPixel(tc.geometry.FrameRate); bpp < tc.minBPP {
+					t.Errorf("downscaled plan gives %.4f bits per pixel, below the %.4f floor", bpp, tc.minBPP)
+				}
+			}
+		})
+	}
+}
+
+func TestCorrectiveVideoBitrate(t *testing.T) {
+	// 1 MB over a 25 MB target across 100s is about 84 kbps of excess
+	corrected, err := correctiveVideoBitrate(2000, 100, 26*1024*1024, 25*1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if corrected != 1858 {
+		t.Errorf("expected 1858 kbps, got %d", corrected)
+	}
+
+	if unchanged, _ := correctiveVideoBitrate(2000, 100, 24*1024*1024, 25*1024*1024); unchanged != 2000 {
+		t.Errorf("expected an output under the target to keep its bitrate, got %d", unchanged)
+	}
+	if _, err := correctiveVideoBitrate(60, 100, 26*1024*1024, 25*1024*1024); err == nil {
+		t.Error("expected an error when the correction falls below the minimum video bitrate")
+	}
+}
+
+func TestBuildTwoPassArgs(t *testing.T) {
+	plan := compressionPlan{VideoBitrateKbps: 1584, AudioBitrateKbps: 128, Width: 1370, Height: 770, Downscaled: true}
+
+	pass1 := strings.Join(buildTwoPassArgs("in.mov", "out.mp4", "/tmp/log", plan, 1), " ")
+	for _, flag := range []string{"-b:v 1584k", "-pass 1", "-passlogfile /tmp/log", "-vf scale=1370:770", "-an -f null"} {
+		if !strings.Contains(pass1, flag) {
+			t.Errorf("first pass should contain %q: %s", flag, pass1)
+		}
+	}
+	if strings.Contains(pass1, "out.mp4") {
+		t.Errorf("first pass should not write the output file: %s", pass1)
+	}
+
+	pass2 := strings.Join(buildTwoPassArgs("in.mov", "out.mp4", "/tmp/log", plan, 2), " ")
+	for _, flag := range []string{"-pass 2", "-c:a aac -b:a 128k"} {
+		if !strings.Contains(pass2, flag) {
+			t.Errorf("second pass should contain %q: %s", flag, pass2)
+		}
+	}
+	if !strings.HasSuffix(pass2, "out.mp4") {
+		t.Errorf("expected the output file last: %s", pass2)
+	}
+
+	silent := strings.Join(buildTwoPassArgs("in.mov", "out.mp4", "/tmp/log", compressionPlan{VideoBitrateKbps: 800, Width: 640, Height: 360}, 2), " ")
+	if strings.Contains(silent, "-vf") || strings.Contains(silent, "-c:a") || !strings.Contains(silent, "-an") {
+		t.Errorf("silent, full-resolution plan should neither scale nor encode audio: %s", silent)
+	}
+}
+
+func TestProgressBarAlphaExpr(t *testing.T) {
+	testCases := []struct {
+		durationSecs float64
+		expected     string
+	}{
+		{10, "if(lt(X,W*T/10),255,0)"},
+		{8.341667, "if(lt(X,W*T/8.341667),255,0)"},
+	}
+	for _, tc := range testCases {
+		if actual := progressBarAlphaExpr(tc.durationSecs); actual != tc.expected {
+			t.Errorf("progressBarAlphaExpr(%g) = %s, want %s", tc.durationSecs, actual, tc.expected)
+		}
+	}
+}
+
+func TestBuildProgressBarFilter(t *testing.T) {
+	bottom := buildProgressBarFilter("bottom", 8, "red", 12.5)
+	expected := "[0:v]split[base][strip];" +
+		"[strip]crop=iw:8:0:ih-8,drawbox=c=red:t=fill,format=rgba,geq=r='r(X,Y)':g='g(X,Y)':b='b(X,Y)':a='if(lt(X,W*T/12.5),255,0)'[bar];" +
+		"[base][bar]overlay=0:main_h-8:format=auto[out]"
+	if bottom != expected {
+		t.Errorf("bottom bar filter:\n got %s\nwant %s", bottom, expected)
+	}
+
+	top := buildProgressBarFilter("top", 4, "#FF0050@0.8", 30)
+	if !strings.Contains(top, "crop=iw:4:0:0,drawbox=c=#FF0050@0.8:t=fill") || !strings.HasSuffix(top, "overlay=0:0:format=auto[out]") {
+		t.Errorf("unexpected top bar filter: %s", top)
+	}
+
+	for _, color := range []string{"white", "#FF0050", "0xFF0050", "red@0.5"} {
+		if !ffmpegColorRegex.MatchString(color) {
+			t.Errorf("expected color %q to be accepted", color)
+		}
+	}
+	for _, color := range []string{"red:t=2", "red,drawtext", "", "red[out]"} {
+		if ffmpegColorRegex.MatchString(color) {
+			t.Errorf("expected color %q to be rejected", color)
+		}
+	}
+}
+
+func TestBuildSideBySideFilter(t *testing.T) {
+	horizontal := buildSideBySideFilter("horizontal", 720, "mix")
+	expected := "[0:v]scale=-2:720,setsar=1,format=yuv420p[left];[1:v]scale=-2:720,setsar=1,format=yuv420p[right];" +
+		"[left][right]hstack=inputs=2:shortest=1[v];" +
+		"[0:a][1:a]amix=inputs=2:duration=shortest[a]"
+	if horizontal != expected {
+		t.Errorf("horizontal filter:\n got %s\nwant %s", horizontal, expected)
+	}
+
+	vertical := buildSideBySideFilter("vertical", 1080, "left")
+	expected = "[0:v]scale=1080:-2,setsar=1,format=yuv420p[left];[1:v]scale=1080:-2,setsar=1,format=yuv420p[right];" +
+		"[left][right]vstack=inputs=2:shortest=1[v]"
+	if vertical != expected {
+		t.Errorf("vertical filter:\n got %s\nwant %s", vertical, expected)
+	}
+
+	left := videoGeometry{Width: 1920, Height: 1080}
+	right := videoGeometry{Width: 1281, Height: 721}
+	if got := sideBySideStackSize("horizontal", left, right); got != 720 {
+		t.Errorf("horizontal stack size = %d, want 720", got)
+	}
+	if got := sideBySideStackSize("vertical", left, right); got != 1280 {
+		t.Errorf("vertical stack size = %d, want 1280", got)
+	}
+
+	args := strings.Join(buildSideBySideArgs("l.mp4", "r.mp4", "out.mp4", vertical, "right"), " ")
+	if !strings.Contains(args, "-map [v] -map 1:a:0") || !strings.HasSuffix(args, "-c:a aac -shortest -movflags +faststart out.mp4") {
+		t.Errorf("unexpected side-by-side args: %s", args)
+	}
+	args = strings.Join(buildSideBySideArgs("l.mp4", "r.mp4", "out.mp4", vertical, "none"), " ")
+	if strings.Contains(args, "0:a") || !strings.Contains(args, "-an -shortest") {
+		t.Errorf("expected no audio mapping, got: %s", args)
+	}
+}
+
+func TestResolveSideBySideAudio(t *testing.T) {
+	testCases := []struct {
+		mode        string
+		left, right bool
+		expected    string
+		expectErr   bool
+	}{
+		{"mix", true, true, "mix", false},
+		{"mix", false, true, "right", false},
+		{"mix", true, false, "left", false},
+		{"mix", false, false, "none", false},
+		{"left", true, false, "left", false},
+		{"left", false, true, "", true},
+		{"right", true, false, "", true},
+		{"none", true, true, "none", false},
+		{"both", true, true, "", true},
+	}
+	for _, tc := range testCases {
+		got, err := resolveSideBySideAudio(tc.mode, tc.left, tc.right)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s (left %t, right %t): expected an error, got %q", tc.mode, tc.left, tc.right, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s (left %t, right %t) = %q, %v; want %q", tc.mode, tc.left, tc.right, got, err, tc.expected)
+		}
+	}
+}
+
+// loggingSession is a client session that records notifications at a fixed log level.
+type loggingSession struct {
+	notifications chan mcp.JSONRPCNotification
+	level         mcp.LoggingLevel
+}
+
+func (s *loggingSession) Initialize()                                         {}
+func (s *loggingSession) Initialized() bool                                   { return true }
+func (s *loggingSession) NotificationChannel() chan<- mcp.JSONRPCNotification { return s.notifications }
+func (s *loggingSession) SessionID() string                                   { return "test-session" }
+func (s *loggingSession) SetLogLevel(level mcp.LoggingLevel)                  { s.level = level }
+func (s *loggingSession) GetLogLevel() mcp.LoggingLevel                       { return s.level }
+
+// runFakeFFmpegTool runs a fake FFMpeg that writes the given stderr through a tools/call on a
+// real MCP server, so the streamed lines take the same path as in production.
+func runFakeFFmpegTool(t *testing.T, stderr string, level mcp.LoggingLevel) (string, error, []mcp.JSONRPCNotification) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nprintf '"+stderr+"' >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	originalBinary := ffmpegBinary
+	ffmpegBinary = script
+	t.Cleanup(func() { ffmpegBinary = originalBinary })
+
+	var output string
+	var runErr error
+	s := server.NewMCPServer("test", "0.0.0", server.WithLogging())
+	s.AddTool(mcp.NewTool("run_fake_ffmpeg"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		output, runErr = runFFmpegCommand(ctx, "-i", "in.mp4", "out.mp4")
+		return mcp.NewToolResultText("done"), nil
+	})
+
+	session := &loggingSession{notifications: make(chan mcp.JSONRPCNotification, 100), level: level}
+	message := json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "run_fake_ffmpeg"}}`)
+	s.HandleMessage(s.WithContext(context.Background(), session), message)
+	close(session.notifications)
+
+	var notifications []mcp.JSONRPCNotification
+	for n := range session.notifications {
+		notifications = append(notifications, n)
+	}
+	return output, runErr, notifications
+}
+
+func TestRunFFmpegCommandStreamsStderr(t *testing.T) {
+	originalMax, originalInterval := ffmpegLogStreamMaxLines, ffmpegLogStreamInterval
+	// a long interval keeps every line of the fake run in one rate-limit window
+	ffmpegLogStreamMaxLines, ffmpegLogStreamInterval = 3, time.Hour
+	t.Cleanup(func() { ffmpegLogStreamMaxLines, ffmpegLogStreamInterval = originalMax, originalInterval })
+
+	stderr := "line one\\nframe=1\\rframe=2\\n\\nline four\\nline five\\nline six"
+	output, err, notifications := runFakeFFmpegTool(t, stderr, mcp.LoggingLevelDebug)
+	if err == nil {
+		t.Fatal("expected the failing command to return an error")
+	}
+	if !strings.Contains(output, "line six") || !strings.Contains(err.Error(), "line four") {
+		t.Errorf("expected the buffered output to keep every line, got output %q and error %v", output, err)
+	}
+
+	var lines []string
+	for _, n := range notifications {
+		if n.Method != "notifications/message" {
+			t.Fatalf("unexpected notification %s", n.Method)
+		}
+		if level := n.Params.AdditionalFields["level"]; level != mcp.LoggingLevelDebug {
+			t.Errorf("expected debug level, got %v", level)
+		}
+		if logger := n.Params.AdditionalFields["logger"]; logger != "ffmpeg" {
+			t.Errorf("expected the ffmpeg logger, got %v", logger)
+		}
+		lines = append(lines, fmt.Sprint(n.Params.AdditionalFields["data"]))
+	}
+	expected := []string{"line one", "frame=1", "frame=2", "[3 FFMpeg log lines skipped by the rate limit]"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("streamed lines:\n got %q\nwant %q", lines, expected)
+	}
+}
+
+func TestRunFFmpegCommandStreamingNeedsDebugLevel(t *testing.T) {
+	_, err, notifications := runFakeFFmpegTool(t, "line one\\nline two\\n", mcp.LoggingLevelInfo)
+	if err == nil || !strings.Contains(err.Error(), "line two") {
+		t.Errorf("expected the error to include the output tail, got %v", err)
+	}
+	if len(notifications) != 0 {
+		t.Errorf("expected no notifications below debug level, got %d", len(notifications))
+	}
+}
+
+func TestFFmpegLogStreamer(t *testing.T) {
+	originalMax, originalBytes := ffmpegLogStreamMaxLines, ffmpegLogStreamMaxLineBytes
+	ffmpegLogStreamMaxLines, ffmpegLogStreamMaxLineBytes = 2, 10
+	t.Cleanup(func() { ffmpegLogStreamMaxLines, ffmpegLogStreamMaxLineBytes = originalMax, originalBytes })
+
+	var sent []string
+	streamer := newFFmpegLogStreamer(func(line string) { sent = append(sent, line) })
+	now := time.Unix(0, 0)
+	streamer.now = func() time.Time { return now }
+
+	streamer.Write([]byte("a\nb\nc\nd"))
+	streamer.Write([]byte("\n"))
+	now = now.Add(ffmpegLogStreamInterval)
+	streamer.Write([]byte("0123456789abcdef\npartial"))
+	streamer.Close()
+
+	expected := []string{
+		"a", "b",
+		"[2 FFMpeg log lines skipped by the rate limit]",
+		"0123456789 [truncated]", "partial",
+	}
+	if strings.Join(sent, "|") != strings.Join(expected, "|") {
+		t.Errorf("sent lines:\n got %q\nwant %q", sent, expected)
+	}
+}
+
+func TestBuildEqualizerFilter(t *testing.T) {
+	bands := []equalizerBand{
+		{Frequency: 250, Width: 1, GainDB: -3},
+		{Frequency: 4000, Width: 2.5, GainDB: 2.5},
+	}
+	filter := buildEqualizerFilter(bands, 80, 0)
+	expected := "highpass=f=80,equalizer=f=250:t=q:w=1:g=-3,equalizer=f=4000:t=q:w=2.5:g=2.5"
+	if filter != expected {
+		t.Errorf("buildEqualizerFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	args := strings.Join(buildEqualizerArgs("in.wav", "out.wav", filter, audioFormat{SampleRate: 24000, Channels: 1, CodecName: "pcm_s16le"}, ""), " ")
+	expectedArgs := "-y -i in.wav -map 0:a:0 -af " + expected + " -ar 24000 -ac 1 -c:a pcm_s16le out.wav"
+	if args != expectedArgs {
+		t.Errorf("buildEqualizerArgs:\n got %s\nwant %s", args, expectedArgs)
+	}
+}
+
+func TestPitchShiftFactorsOctave(t *testing.T) {
+	asetrate, atempo := pitchShiftFactors(12, 44100)
+	if asetrate != 88200 || atempo != 0.5 {
+		t.Errorf("pitchShiftFactors(12, 44100) = %d, %g, want 88200, 0.5", asetrate, atempo)
+	}
+	expected := "asetrate=88200,aresample=44100,atempo=0.5"
+	if filter := buildPitchShiftFilter(12, 44100); filter != expected {
+		t.Errorf("buildPitchShiftFilter(12, 44100):\n got %s\nwant %s", filter, expected)
+	}
+
+	args := strings.Join(buildPitchShiftArgs("in.wav", "out.wav", expected, audioFormat{SampleRate: 44100, Channels: 2, CodecName: "pcm_s24le"}, ""), " ")
+	expectedArgs := "-y -i in.wav -map 0:a:0 -af " + expected + " -ac 2 -c:a pcm_s24le out.wav"
+	if args != expectedArgs {
+		t.Errorf("buildPitchShiftArgs:\n got %s\nwant %s", args, expectedArgs)
+	}
+}
+
+func TestBuildPitchShiftFilter(t *testing.T) {
+	testCases := []struct {
+		semitones  float64
+		sampleRate int
+		expected   string
+	}{
+		{-12, 44100, "asetrate=22050,aresample=44100,atempo=2"},
+		{24, 24000, "asetrate=96000,aresample=24000,atempo=0.5,atempo=0.5"},
+		{-24, 48000, "asetrate=12000,aresample=48000,atempo=2,atempo=2"},
+		{7, 48000, "asetrate=71919,aresample=48000,atempo=0.6674199270850172"},
+	}
+	for _, tc := range testCases {
+		if filter := buildPitchShiftFilter(tc.semitones, tc.sampleRate); filter != tc.expected {
+			t.Errorf("buildPitchShiftFilter(%g, %d):\n got %s\nwant %s", tc.semitones, tc.sampleRate, filter, tc.expected)
+		}
+	}
+}
+
+func TestBuildDenoiseFilter(t *testing.T) {
+	for strength, expected := range map[string]string{
+		"light":      "afftdn=nr=6:nf=-60",
+		"medium":     "afftdn=nr=12:nf=-50:tn=1",
+		"aggressive": "afftdn=nr=24:nf=-40:tn=1",
+	} {
+		if filter := buildDenoiseFilter(strength, ""); filter != expected {
+			t.Errorf("buildDenoiseFilter(%q): got %s, want %s", strength, filter, expected)
+		}
+	}
+	for _, strength := range denoiseStrengths {
+		if _, ok := denoisePresets[strength]; !ok {
+			t.Errorf("no afftdn preset for strength %q", strength)
+		}
+	}
+
+	// The model replaces the preset, and the colons of its path are escaped.
+	expected := `arnndn=m='/tmp/models\:v2/bd.rnnn'`
+	if filter := buildDenoiseFilter("light", "/tmp/models:v2/bd.rnnn"); filter != expected {
+		t.Errorf("buildDenoiseFilter with a model:\n got %s\nwant %s", filter, expected)
+	}
+}
+
+func TestBuildDenoiseArgs(t *testing.T) {
+	format := audioFormat{SampleRate: 44100, Channels: 1, CodecName: "pcm_s16le"}
+	args := strings.Join(buildDenoiseArgs("in.wav", "out.wav", "afftdn=nr=12:nf=-50:tn=1", format, 0, ""), " ")
+	expected := "-y -i in.wav -map 0:a:0 -af afftdn=nr=12:nf=-50:tn=1 -ar 44100 -ac 1 -c:a pcm_s16le out.wav"
+	if args != expected {
+		t.Errorf("buildDenoiseArgs:\n got %s\nwant %s", args, expected)
+	}
+
+	args = strings.Join(buildDenoiseArgs("in.mp3", "out.mp3", "afftdn=nr=6:nf=-60", format, 7.5, ""), " ")
+	expected = "-y -t 7.500 -i in.mp3 -map 0:a:0 -af afftdn=nr=6:nf=-60 -ar 44100 -ac 1 out.mp3"
+	if args != expected {
+		t.Errorf("buildDenoiseArgs with a preview:\n got %s\nwant %s", args, expected)
+	}
+}
+
+func TestBuildDuckFilter(t *testing.T) {
+	format := audioFormat{SampleRate: 48000, Channels: 2, CodecName: "pcm_s24le"}
+
+	// The voice is shorter, so it is padded to the music's length.
+	filter := buildDuckFilter(defaultDuckSettings, 12.5, 30, format)
+	expected := "[0:a]aresample=48000,aformat=channel_layouts=stereo,apad=whole_dur=30.000[voice];" +
+		"[1:a]aresample=48000,aformat=channel_layouts=stereo[music];" +
+		"[voice]asplit=2[sidechain][narration];" +
+		"[music][sidechain]sidechaincompress=threshold=0.031623:ratio=8:attack=20:release=400[ducked];" +
+		"[ducked][narration]amix=inputs=2:duration=longest:normalize=0[a]"
+	if filter != expected {
+		t.Errorf("buildDuckFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	// The music is shorter and gets its base gain before the padding.
+	settings := duckSettings{ThresholdDB: -20, Ratio: 4, AttackMs: 5, ReleaseMs: 1000, MusicBaseGainDB: -6}
+	filter = buildDuckFilter(settings, 45, 30, audioFormat{SampleRate: 44100, Channels: 1})
+	for _, want := range []string{
+		"[0:a]aresample=44100,aformat=channel_layouts=mono[voice]",
+		"[1:a]aresample=44100,aformat=channel_layouts=mono,volume=-6dB,apad=whole_dur=45.000[music]",
+		"sidechaincompress=threshold=0.100000:ratio=4:attack=5:release=1000[ducked]",
+	} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("filter is missing %q: %s", want, filter)
+		}
+	}
+
+	// Inputs of the same length are not padded.
+	if filter := buildDuckFilter(defaultDuckSettings, 30, 30, format); strings.Contains(filter, "apad") {
+		t.Errorf("expected no padding for inputs of the same length: %s", filter)
+	}
+}
+
+func TestDuckOutputFormat(t *testing.T) {
+	voice := audioFormat{SampleRate: 24000, Channels: 1, CodecName: "pcm_s16le"}
+	music := audioFormat{SampleRate: 48000, Channels: 6, CodecName: "aac"}
+	expected := audioFormat{SampleRate: 48000, Channels: 2, CodecName: "pcm_s16le"}
+	if format := duckOutputFormat(voice, music); format != expected {
+		t.Errorf("duckOutputFormat = %v, want %v", format, expected)
+	}
+}
+
+func TestBuildDuckArgs(t *testing.T) {
+	format := audioFormat{SampleRate: 48000, Channels: 2, CodecName: "pcm_s24le"}
+	args := strings.Join(buildDuckArgs("voice.wav", "music.mp3", "out.wav", "F", format, ""), " ")
+	expected := "-y -i voice.wav -i music.mp3 -filter_complex F -map [a] -ar 48000 -ac 2 -c:a pcm_s24le out.wav"
+	if args != expected {
+		t.Errorf("buildDuckArgs:\n got %s\nwant %s", args, expected)
+	}
+
+	args = strings.Join(buildDuckArgs("voice.wav", "music.mp3", "out.mp3", "F", format, ""), " ")
+	expected = "-y -i voice.wav -i music.mp3 -filter_complex F -map [a] -ar 48000 -ac 2 out.mp3"
+	if args != expected {
+		t.Errorf("buildDuckArgs for MP3:\n got %s\nwant %s", args, expected)
+	}
+}
+
+func TestWAVSampleFormat24Bit(t *testing.T) {
+	if codec := wavOutputCodec("WAV", "s24"); codec != "pcm_s24le" {
+		t.Errorf("wavOutputCodec(WAV, s24) = %q, want pcm_s24le", codec)
+	}
+	if codec := wavOutputCodec("wav", ""); codec != "pcm_s16le" {
+		t.Errorf("expected 16-bit PCM by default, got %q", codec)
+	}
+	if codec := wavOutputCodec("flac", "s24"); codec != "" {
+		t.Errorf("expected no codec override for non-WAV output, got %q", codec)
+	}
+	for _, name := range wavSampleFormatNames {
+		if _, ok := wavSampleFormats[name]; !ok {
+			t.Errorf("no PCM codec for sample format %q", name)
+		}
+	}
+
+	// A requested sample format overrides the PCM codec of the input.
+	format := audioFormat{SampleRate: 48000, Channels: 2, CodecName: "pcm_s16le"}
+	if codec := pcmOutputCodec("wav", format, "s24"); codec != "pcm_s24le" {
+		t.Errorf("pcmOutputCodec with s24 = %q, want pcm_s24le", codec)
+	}
+	args := strings.Join(buildDenoiseArgs("in.wav", "out.wav", "afftdn=nr=6:nf=-60", format, 0, "s24"), " ")
+	expected := "-y -i in.wav -map 0:a:0 -af afftdn=nr=6:nf=-60 -ar 48000 -ac 2 -c:a pcm_s24le out.wav"
+	if args != expected {
+		t.Errorf("buildDenoiseArgs with s24:\n got %s\nwant %s", args, expected)
+	}
+}
+
+func TestBuildKenBurnsFilterZoomIn(t *testing.T) {
+	// 5 seconds at 25 fps is 125 frames; the zoom goes from 1 on frame 0 to 1.25 on frame 124.
+	filter := buildKenBurnsFilter("zoom_in", 1280, 720, 25, 5)
+	expected := "scale=5120:2880:force_original_aspect_ratio=increase,crop=5120:2880," +
+		"zoompan=z='1+0.25*on/124':x='iw/2-(iw/zoom/2)':y='ih/2-(ih/zoom/2)':d=125:s=1280x720:fps=25,format=yuv420p"
+	if filter != expected {
+		t.Errorf("buildKenBurnsFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	args := strings.Join(buildKenBurnsArgs("still.png", "out.mp4", filter, 125), " ")
+	if !strings.HasPrefix(args, "-y -i still.png -vf "+filter+" -frames:v 125 -c:v libx264") {
+		t.Errorf("unexpected arguments %s", args)
+	}
+}
+
+func TestThumbnailTrimWindow(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		near, window, length float64
+		want                 mediaSegment
+	}{
+		{"centered", 12, 2, 60, mediaSegment{Start: 10, End: 14}},
+		{"near the start", 1, 3, 60, mediaSegment{Start: 0, End: 4}},
+		{"near the end", 59, 2, 60, mediaSegment{Start: 57, End: 60}},
+		{"whole video", -1, 2, 42.5, mediaSegment{Start: 0, End: 42.5}},
+	}
+	for _, tc := range testCases {
+		if got := thumbnailSearchWindow(tc.near, tc.window, tc.length); got != tc.want {
+			t.Errorf("%s: thumbnailSearchWindow(%g, %g, %g) = %+v, want %+v", tc.name, tc.near, tc.window, tc.length, got, tc.want)
+		}
+	}
+
+	// The 1.5s window is resampled to 60 candidate frames, i.e. 40 fps.
+	args := strings.Join(buildThumbnailArgs("in.mp4", "poster.jpg", mediaSegment{Start: 10.25, End: 11.75}), " ")
+	expected := "-hide_banner -nostats -loglevel info -y -ss 10.250 -t 1.500 -i in.mp4 -map 0:v:0 -an " +
+		"-vf fps=40.000000,thumbnail=n=60,showinfo -frames:v 1 -update 1 -q:v 2 poster.jpg"
+	if args != expected {
+		t.Errorf("buildThumbnailArgs:\n got %s\nwant %s", args, expected)
+	}
+
+	output := "[Parsed_showinfo_2 @ 0x1] n:   0 pts:     23 pts_time:0.575   duration:1 fmt:yuv420p\n"
+	if got, ok := parseThumbnailTime(output); !ok || got != 0.575 {
+		t.Errorf("parseThumbnailTime = %g, %v; want 0.575", got, ok)
+	}
+	if _, ok := parseThumbnailTime("Output #0, image2"); ok {
+		t.Error("expected no time without a showinfo line")
+	}
+}
+
+func TestBuildBlurFillFilter(t *testing.T) {
+	width, height := blurFillDimensions(1920, 1080, 9, 16)
+	if width != 1080 || height != 1920 {
+		t.Fatalf("blurFillDimensions(1920x1080, 9:16) = %dx%d, want 1080x1920", width, height)
+	}
+	if w, h := blurFillDimensions(1080, 1920, 16, 9); w != 1920 || h != 1080 {
+		t.Errorf("blurFillDimensions(1080x1920, 16:9) = %dx%d, want 1920x1080", w, h)
+	}
+	if w, h := blurFillDimensions(1280, 720, 4, 5); w != 1024 || h != 1280 {
+		t.Errorf("blurFillDimensions(1280x720, 4:5) = %dx%d, want 1024x1280", w, h)
+	}
+
+	filter := buildBlurFillFilter(width, height, 20)
+	expected := "[0:v]split=2[bg][fg];" +
+		"[bg]scale=1080:1920:force_original_aspect_ratio=increase,crop=1080:1920,gblur=sigma=20[blurred];" +
+		"[fg]scale=1080:1920:force_original_aspect_ratio=decrease[sharp];" +
+		"[blurred][sharp]overlay=(W-w)/2:(H-h)/2,setsar=1,format=yuv420p[v]"
+	if filter != expected {
+		t.Errorf("buildBlurFillFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	args := strings.Join(buildBlurFillArgs("in.mp4", "story.mp4", filter), " ")
+	if !strings.HasPrefix(args, "-y -i in.mp4 -filter_complex "+filter+" -map [v] -map 0:a:0? -c:v libx264") {
+		t.Errorf("unexpected arguments %s", args)
+	}
+
+	for ratio, ok := range map[string]bool{"9:16": true, " 4:5 ": true, "16/9": false, "0:1": false, "9:16x": false} {
+		if _, _, got := parseAspectRatio(ratio); got != ok {
+			t.Errorf("parseAspectRatio(%q) ok = %v, want %v", ratio, got, ok)
+		}
+	}
+}
+
+func TestKenBurnsZoompan(t *testing.T) {
+	testCases := []struct {
+		direction string
+		z, x, y   string
+	}{
+		{"zoom_out", "1.25-0.25*on/59", "iw/2-(iw/zoom/2)", "ih/2-(ih/zoom/2)"},
+		{"pan_left", "1.25", "(iw-iw/zoom)*(1-on/59)", "ih/2-(ih/zoom/2)"},
+		{"pan_right", "1.25", "(iw-iw/zoom)*on/59", "ih/2-(ih/zoom/2)"},
+		{"pan_up", "1.25", "iw/2-(iw/zoom/2)", "(ih-ih/zoom)*(1-on/59)"},
+		{"pan_down", "1.25", "iw/2-(iw/zoom/2)", "(ih-ih/zoom)*on/59"},
+	}
+	for _, tc := range testCases {
+		z, x, y := kenBurnsZoompan(tc.direction, 60)
+		if z != tc.z || x != tc.x || y != tc.y {
+			t.Errorf("kenBurnsZoompan(%q) = %s, %s, %s; want %s, %s, %s", tc.direction, z, x, y, tc.z, tc.x, tc.y)
+		}
+	}
+	if frames := kenBurnsFrameCount(30, 0.01); frames != 1 {
+		t.Errorf("expected at least one frame, got %d", frames)
+	}
+}
+
+func TestBuildTonemapFilter(t *testing.T) {
+	expected := "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=mobius:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+	if filter := buildTonemapFilter("mobius"); filter != expected {
+		t.Errorf("buildTonemapFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	args := strings.Join(buildTonemapArgs("in.mov", "out.mp4", expected), " ")
+	if !strings.Contains(args, "-vf "+expected+" ") || !strings.Contains(args, "-color_primaries bt709 -color_trc bt709 -colorspace bt709") {
+		t.Errorf("expected the filter and BT.709 tags in the arguments, got %s", args)
+	}
+}
+
+func TestCountdownTextExpr(t *testing.T) {
+	if expr := countdownTextExpr(10, 0, "seconds"); expr != `%{eif\:ceil(10-t)\:d}` {
+		t.Errorf("10-second timer: got %s", expr)
+	}
+	if expr := countdownTextExpr(90, 2.5, "mm:ss"); expr != `%{eif\:floor(ceil(92.5-t)/60)\:d\:2}\:%{eif\:mod(ceil(92.5-t),60)\:d\:2}` {
+		t.Errorf("mm:ss timer: got %s", expr)
+	}
+
+	filter := buildCountdownFilter(countdownOverlay{DurationSecs: 10, Format: "seconds", Position: "bottom_right", FontColor: "white", BoxColor: "black@0.5"})
+	expected := `drawtext=text='%{eif\:ceil(10-t)\:d}':fontsize=h/8:fontcolor=white:x=w-text_w-h/20:y=h-text_h-h/20:box=1:boxcolor=black@0.5:boxborderw=20:enable='gte(t,0)*lt(t,10)'`
+	if filter != expected {
+		t.Errorf("buildCountdownFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	withFont := buildCountdownFilter(countdownOverlay{DurationSecs: 5, StartSecs: 3, Format: "seconds", Position: "center", FontSize: 72, FontColor: "#FFD700", FontFile: "C:/fonts/Inter.ttf"})
+	if !strings.HasPrefix(withFont, `drawtext=fontfile='C\:/fonts/Inter.ttf':text='%{eif\:ceil(8-t)\:d}':fontsize=72:`) || strings.Contains(withFont, "box=") || !strings.HasSuffix(withFont, `enable='gte(t,3)*lt(t,8)'`) {
+		t.Errorf("unexpected filter with font file and no box: %s", withFont)
+	}
+	if filterPathSafe(`/tmp/it's.ttf`) || !filterPathSafe("/tmp/input_1/Inter.ttf") {
+		t.Error("filterPathSafe should reject quotes and accept plain paths")
+	}
+}
+
+func TestBuildCaptionScreens(t *testing.T) {
+	transcript := "one two three four five\n six  seven eight nine ten eleven twelve"
+
+	// Without seconds_per_screen, 12 words over 24s is 2s per word: screens of 5, 5 and 2 words.
+	screens := buildCaptionScreens(transcript, 5, 42, 6, 0, 24)
+	want := []captionScreen{
+		{Text: "one two three four five", StartSecs: 6, EndSecs: 16},
+		{Text: "six seven eight nine ten", StartSecs: 16, EndSecs: 26},
+		{Text: "eleven twelve", StartSecs: 26, EndSecs: 30},
+	}
+	if !slices.Equal(screens, want) {
+		t.Errorf("proportional timing:\n got %+v\nwant %+v", screens, want)
+	}
+
+	fixed := buildCaptionScreens(transcript, 5, 42, 1.5, 2.5, 24)
+	if len(fixed) != 3 || fixed[0].StartSecs != 1.5 || fixed[0].EndSecs != 4 || fixed[2].StartSecs != 6.5 || fixed[2].EndSecs != 9 {
+		t.Errorf("fixed timing: got %+v", fixed)
+	}
+
+	// Consecutive screens meet exactly, even when the span does not divide evenly.
+	uneven := buildCaptionScreens(transcript, 5, 42, 0, 0, 10)
+	for i := 1; i < len(uneven); i++ {
+		if uneven[i].StartSecs != uneven[i-1].EndSecs {
+			t.Errorf("screen %d starts at %g, after the previous one ends at %g", i, uneven[i].StartSecs, uneven[i-1].EndSecs)
+		}
+	}
+	if last := uneven[len(uneven)-1]; last.EndSecs != 10 {
+		t.Errorf("the last screen should end with the span, got %g", last.EndSecs)
+	}
+
+	if wrapped := buildCaptionScreens("the quick brown fox jumps over the lazy dog", 9, 16, 0, 0, 9); wrapped[0].Text != "the quick brown\nfox jumps over\nthe lazy dog" {
+		t.Errorf("unexpected wrapping: %q", wrapped[0].Text)
+	}
+	if lines := wrapCaptionLines([]string{"a", "supercalifragilistic", "b"}, 10); !slices.Equal(lines, []string{"a", "supercalifragilistic", "b"}) {
+		t.Errorf("a long word should get its own line, got %q", lines)
+	}
+	if lines := wrapCaptionLines([]string{"日本語の", "字幕です"}, 9); len(lines) != 1 {
+		t.Errorf("line length should count characters, not bytes, got %q", lines)
+	}
+}
+
+func TestBuildCaptionTextFilter(t *testing.T) {
+	screens := []captionScreen{{Text: "hello", StartSecs: 0, EndSecs: 1.25}, {Text: "world", StartSecs: 1.25, EndSecs: 2.5}}
+	filter := buildCaptionTextFilter(screens, []string{"/tmp/c/screen_000.txt", "/tmp/c/screen_001.txt"}, captionStyle{FontColor: "white", BoxColor: "black@0.6"})
+	expected := "drawtext=textfile='/tmp/c/screen_000.txt':expansion=none:fontsize=h/20:fontcolor=white:x=(w-text_w)/2:y=h-text_h-h/10:box=1:boxcolor=black@0.6:boxborderw=12:enable='gte(t,0.000)*lt(t,1.250)'," +
+		"drawtext=textfile='/tmp/c/screen_001.txt':expansion=none:fontsize=h/20:fontcolor=white:x=(w-text_w)/2:y=h-text_h-h/10:box=1:boxcolor=black@0.6:boxborderw=12:enable='gte(t,1.250)*lt(t,2.500)'"
+	if filter != expected {
+		t.Errorf("buildCaptionTextFilter:\n got %s\nwant %s", filter, expected)
+	}
+
+	styled := buildCaptionTextFilter(screens[:1], []string{"C:/tmp/screen_000.txt"}, captionStyle{FontSize: 48, FontColor: "yellow", FontFile: "/fonts/Noto.ttf"})
+	if !strings.HasPrefix(styled, `drawtext=fontfile='/fonts/Noto.ttf':textfile='C\:/tmp/screen_000.txt':expansion=none:fontsize=48:fontcolor=yellow:`) || strings.Contains(styled, "box=") {
+		t.Errorf("unexpected filter with font file and no box: %s", styled)
+	}
+}
+
+func TestBuildTrimArgs(t *testing.T) {
+	fast := buildTrimArgs("in.mp3", "out.mp3", 12.5, 20, false)
+	accurate := buildTrimArgs("in.mp3", "out.mp3", 12.5, 20, true)
+
+	// The seek is an input option in fast mode and an output option in sample-accurate mode.
+	if slices.Index(fast, "-ss") > slices.Index(fast, "-i") {
+		t.Errorf("fast mode should seek before -i: %v", fast)
+	}
+	if slices.Index(accurate, "-ss") < slices.Index(accurate, "-i") {
+		t.Errorf("sample-accurate mode should seek after -i: %v", accurate)
+	}
+
+	if got, want := strings.Join(fast, " "), "-y -ss 12.500 -i in.mp3 -t 7.500 -c copy -avoid_negative_ts make_zero out.mp3"; got != want {
+		t.Errorf("fast trim:\n got %s\nwant %s", got, want)
+	}
+	if got, want := strings.Join(accurate, " "), "-y -i in.mp3 -ss 12.500 -to 20.000 out.mp3"; got != want {
+		t.Errorf("sample-accurate trim:\n got %s\nwant %s", got, want)
+	}
+	if slices.Contains(accurate, "copy") {
+		t.Error("sample-accurate mode must re-encode rather than copy")
+	}
+
+	if toEnd := buildTrimArgs("in.mp4", "out.mp4", 3, 0, false); slices.Contains(toEnd, "-t") || slices.Contains(toEnd, "-to") {
+		t.Errorf("an end of 0 should keep the rest of the input: %v", toEnd)
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for input, want := range map[string]int64{"5M": 5000000, "2.5m": 2500000, "800k": 800000, "2500000": 2500000} {
+		if got, err := parseBitrate(input); err != nil || got != want {
+			t.Errorf("parseBitrate(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "fast", "5G", "-3M", "999"} {
+		if _, err := parseBitrate(input); err == nil {
+			t.Errorf("parseBitrate(%q) should fail", input)
+		}
+	}
+}
+
+func TestBuildHLSArgs(t *testing.T) {
+	renditions := []hlsRendition{{Height: 1080, Bitrate: "5M"}, {Height: 720, Bitrate: "3M"}}
+
+	if got, want := buildHLSFilterGraph(renditions), "[0:v]split=2[v0][v1];[v0]scale=-2:1080[v0out];[v1]scale=-2:720[v1out]"; got != want {
+		t.Errorf("filter graph:\n got %s\nwant %s", got, want)
+	}
+	if got, want := buildHLSVarStreamMap(renditions, true), "v:0,a:0,name:1080p v:1,a:1,name:720p"; got != want {
+		t.Errorf("var_stream_map with audio: got %q, want %q", got, want)
+	}
+	if got, want := buildHLSVarStreamMap(renditions, false), "v:0,name:1080p v:1,name:720p"; got != want {
+		t.Errorf("var_stream_map without audio: got %q, want %q", got, want)
+	}
+
+	args := strings.Join(buildHLSArgs("in.mp4", "/tmp/pkg", renditions, 6, true), " ")
+	for _, want := range []string{
+		"-filter_complex [0:v]split=2[v0][v1];[v0]scale=-2:1080[v0out];[v1]scale=-2:720[v1out]",
+		"-map [v0out] -map 0:a:0 -map [v1out] -map 0:a:0",
+		"-sc_threshold 0 -force_key_frames expr:gte(t,n_forced*6)",
+		"-b:v:0 5M -maxrate:v:0 5M -bufsize:v:0 10000000 -b:v:1 3M -maxrate:v:1 3M -bufsize:v:1 6000000",
+		"-c:a aac",
+		"-f hls -hls_time 6 -hls_playlist_type vod",
+		"-hls_segment_filename " + filepath.Join("/tmp/pkg", "stream_%v", "segment_%03d.ts"),
+		"-master_pl_name master.m3u8 -var_stream_map v:0,a:0,name:1080p v:1,a:1,name:720p " + filepath.Join("/tmp/pkg", "stream_%v", "playlist.m3u8"),
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in the arguments:\n%s", want, args)
+		}
+	}
+
+	silent := strings.Join(buildHLSArgs("in.mp4", "/tmp/pkg", renditions[1:], 4, false), " ")
+	if strings.Contains(silent, "0:a") || strings.Contains(silent, "-c:a") || !strings.Contains(silent, "-hls_time 4") {
+		t.Errorf("a silent input should map no audio: %s", silent)
+	}
+}
+
+func TestSpeedRampPTSExpr(t *testing.T) {
+	// Slow motion at 0.5x ramping up to 2x over the first two seconds, then holding at 2x.
+	segments := speedRampSegments([]speedKeyframe{{Time: 0, Speed: 0.5}, {Time: 2, Speed: 2}})
+	expected := "if(lt(T,2),log((0.5+0.750000*(T-0))/0.5)/(0.750000),1.848392+(T-2)/2)"
+	if expr := speedRampPTSExpr(segments); expr != expected {
+		t.Errorf("speedRampPTSExpr:\n got %s\nwant %s", expr, expected)
+	}
+	// The ramp takes ln(2/0.5)/0.75 seconds of output; the rest of a 10s input plays at 2x.
+	if got := speedRampOutputTime(segments, 10); math.Abs(got-(math.Log(4)/0.75+4)) > 1e-9 {
+		t.Errorf("speedRampOutputTime(10) = %v", got)
+	}
+
+	// A first keyframe after 0 holds its speed from the start, and a ramp down slopes negatively.
+	segments = speedRampSegments([]speedKeyframe{{Time: 1, Speed: 1}, {Time: 3, Speed: 0.25}})
+	expected = "if(lt(T,1),(T-0)/1,if(lt(T,3),1.000000+log((1-0.375000*(T-1))/1)/(-0.375000),4.696785+(T-3)/0.25))"
+	if expr := speedRampPTSExpr(segments); expr != expected {
+		t.Errorf("speedRampPTSExpr with a lead-in:\n got %s\nwant %s", expr, expected)
+	}
+}
+
+func TestBuildSpeedRampFilter(t *testing.T) {
+	segments := speedRampSegments([]speedKeyframe{{Time: 0, Speed: 0.5}, {Time: 2, Speed: 2}})
+
+	filter := buildSpeedRampFilter(segments, 6, 30, false, false)
+	if !strings.HasPrefix(filter, "[0:v]setpts=PTS-STARTPTS,setpts='(if(lt(T,2),") || !strings.HasSuffix(filter, ")/TB',fps=30[v]") {
+		t.Errorf("unexpected video filter: %s", filter)
+	}
+	if strings.Contains(filter, "[a]") {
+		t.Errorf("expected no audio when it is dropped: %s", filter)
+	}
+
+	filter = buildSpeedRampFilter(segments, 6, 24, true, true)
+	for _, want := range []string{
+		"minterpolate=fps=24:mi_mode=mci",
+		"[0:a]asplit=2[as0][as1]",
+		// 2s of audio stretched over the 1.848s the ramp lasts, then the rest at 2x.
+		"[as0]atrim=start=0.000:end=2.000,asetpts=PTS-STARTPTS,atempo=1.0820212806",
+		"[as1]atrim=start=2.000,asetpts=PTS-STARTPTS,atempo=2[ar1]",
+		"[ar0][ar1]concat=n=2:v=0:a=1[a]",
+	} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("filter is missing %q: %s", want, filter)
+		}
+	}
+
+	args := strings.Join(buildSpeedRampArgs("in.mp4", "out.mp4", "F", false), " ")
+	if args != "-y -i in.mp4 -filter_complex F -map [v] -an -c:v libx264 -preset medium -crf 18 -pix_fmt yuv420p -movflags +faststart out.mp4" {
+		t.Errorf("unexpected buildSpeedRampArgs: %s", args)
+	}
+}
+
+func TestResolveFFmpegBinaries(t *testing.T) {
+	originalFFmpeg, originalFFprobe := ffmpegBinary, ffprobeBinary
+	t.Cleanup(func() { ffmpegBinary, ffprobeBinary = originalFFmpeg, originalFFprobe })
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"ffmpeg": 0o755, "ffprobe": 0o755, "ffprobe.txt": 0o644} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := resolveFFmpegBinaries(filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe.txt")); err == nil || !strings.Contains(err.Error(), "FFPROBE_PATH") {
+		t.Errorf("expected a non-executable ffprobe to be rejected, got %v", err)
+	}
+	if err := resolveFFmpegBinaries(filepath.Join(dir, "missing"), filepath.Join(dir, "ffprobe")); err == nil || !strings.Contains(err.Error(), "FFMPEG_PATH") {
+		t.Errorf("expected a missing FFmpeg to be rejected, got %v", err)
+	}
+	if ffmpegBinary != originalFFmpeg || ffprobeBinary != originalFFprobe {
+		t.Error("expected the binaries to be left alone after a failure")
+	}
+
+	t.Setenv("PATH", dir)
+	if err := resolveFFmpegBinaries(filepath.Join(dir, "ffmpeg"), "ffprobe"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ffmpegBinary != filepath.Join(dir, "ffmpeg") || ffprobeBinary != filepath.Join(dir, "ffprobe") {
+		t.Errorf("expected the configured path and the PATH lookup, got %s and %s", ffmpegBinary, ffprobeBinary)
+	}
+}
@@ -5,16 +5,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
+// ffprobeBinary is the ffprobe executable run by runFFprobeCommand. It is a variable so tests
+// can substitute a fake command.
+var ffprobeBinary = "ffprobe"
+
 // runFFprobeCommand executes an FFprobe command and returns its combined output.
+// The command is killed if it runs past the ffprobe time limit, e.g. on a corrupt file.
 func runFFprobeCommand(ctx context.Context, args ...string) (string, error) {
-	cmd := exec.CommandContext(ctx, "ffprobe", args...)
+	op := startOperation(ctx, phaseFFprobe)
+	defer op.cancel()
+	cmd := exec.CommandContext(op.ctx, ffprobeBinary, args...)
+	cmd.WaitDelay = subprocessWaitDelay
 	log.Printf("Running FFprobe command: ffprobe %s", strings.Join(args, " "))
 
 	output, err := cmd.CombinedOutput()
+	if timeout := op.timeoutErr(); timeout != nil {
+		log.Printf("FFprobe command killed: %v", timeout)
+		return string(output), timeout
+	}
 	if err != nil {
 		log.Printf("FFprobe command execution failed. Error: %v\nFFprobe Output:\n%s", err, string(output))
 		return string(output), fmt.Errorf("ffprobe command execution failed: %w. Output: %s", err, string(output))
@@ -42,3 +56,535 @@ func executeGetMediaInfo(ctx context.Context, localInputMedia string) (string, e
 	}
 	return runFFprobeCommand(ctx, ffprobeArgs...)
 }
+
+// probeMediaDuration returns the container duration of a media file in seconds,
+// as reported in the 'format' section of ffprobe's JSON output.
+func probeMediaDuration(ctx context.Context, localInputMedia string) (float64, error) {
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputMedia)
+	if err != nil {
+		return 0, err
+	}
+	duration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", localInputMedia, err)
+	}
+	return duration, nil
+}
+
+// parseMediaDuration reads the container duration, in seconds, from the JSON produced by
+// executeGetMediaInfo.
+func parseMediaDuration(mediaInfoJSON string) (float64, error) {
+	var info struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	duration, err := strconv.ParseFloat(info.Format.Duration, 64)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("ffprobe did not report a usable duration (got %q)", info.Format.Duration)
+	}
+	return duration, nil
+}
+
+// executeGetChapters uses ffprobe to read the chapter markers of a media file, in the same JSON
+// format as executeGetMediaInfo.
+func executeGetChapters(ctx context.Context, localInputMedia string) (string, error) {
+	ffprobeArgs := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_chapters",
+		localInputMedia,
+	}
+	return runFFprobeCommand(ctx, ffprobeArgs...)
+}
+
+// mediaChapter is one chapter marker, with its start and end in seconds.
+type mediaChapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+}
+
+// parseChapters reads the chapters from the JSON produced by executeGetChapters. ffprobe reports
+// 'start' and 'end' in units of each chapter's time base, so the times are taken from
+// 'start_time' and 'end_time', which are in seconds. A chapter without a title tag has an empty
+// title. A file without chapters gives an empty, non-nil slice.
+func parseChapters(chaptersJSON string) ([]mediaChapter, error) {
+	var info struct {
+		Chapters []struct {
+			StartTime string            `json:"start_time"`
+			EndTime   string            `json:"end_time"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal([]byte(chaptersJSON), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	chapters := make([]mediaChapter, 0, len(info.Chapters))
+	for i, c := range info.Chapters {
+		start, errStart := strconv.ParseFloat(c.StartTime, 64)
+		end, errEnd := strconv.ParseFloat(c.EndTime, 64)
+		if errStart != nil || errEnd != nil {
+			return nil, fmt.Errorf("chapter %d has no usable start and end time (got %q and %q)", i+1, c.StartTime, c.EndTime)
+		}
+		chapters = append(chapters, mediaChapter{Start: start, End: end, Title: c.Tags["title"]})
+	}
+	return chapters, nil
+}
+
+// subtitleStream describes one subtitle stream from ffprobe's stream listing.
+// Index is the absolute stream index within the container, as used by '-map 0:<index>'.
+type subtitleStream struct {
+	Index     int
+	CodecName string
+	Language  string
+	Title     string
+}
+
+// bitmapSubtitleCodecs are image-based subtitle formats that cannot be converted to SRT or WebVTT
+// without OCR.
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// isText reports whether the stream holds text subtitles that FFmpeg can convert to SRT/WebVTT.
+func (s subtitleStream) isText() bool {
+	return !bitmapSubtitleCodecs[s.CodecName]
+}
+
+func (s subtitleStream) String() string {
+	desc := fmt.Sprintf("#%d (%s", s.Index, s.CodecName)
+	if s.Language != "" {
+		desc += ", " + s.Language
+	}
+	if s.Title != "" {
+		desc += fmt.Sprintf(", %q", s.Title)
+	}
+	return desc + ")"
+}
+
+// outputFileName names the file a stream is extracted to. The language comes from the input's
+// container tags, so it is only included when it is a well-formed language tag; anything else,
+// such as a path separator, is dropped.
+func (s subtitleStream) outputFileName(prefix, format string) string {
+	name := fmt.Sprintf("%s_s%d", prefix, s.Index)
+	if subtitleLanguagePattern.MatchString(s.Language) {
+		name += "_" + s.Language
+	}
+	return name + "." + format
+}
+
+// parseMediaStreams extracts the subtitle streams, and whether any audio stream is present,
+// from the JSON produced by executeGetMediaInfo.
+func parseMediaStreams(mediaInfoJSON string) (subtitles []subtitleStream, hasAudio bool, err error) {
+	var info struct {
+		Streams []struct {
+			Index     int               `json:"index"`
+			CodecType string            `json:"codec_type"`
+			CodecName string            `json:"codec_name"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return nil, false, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	for _, stream := range info.Streams {
+		switch stream.CodecType {
+		case "audio":
+			hasAudio = true
+		case "subtitle":
+			subtitles = append(subtitles, subtitleStream{
+				Index:     stream.Index,
+				CodecName: stream.CodecName,
+				Language:  stream.Tags["language"],
+				Title:     stream.Tags["title"],
+			})
+		}
+	}
+	return subtitles, hasAudio, nil
+}
+
+// planSubtitleExtraction decides what ffmpeg_extract_subtitles will produce. A negative
+// streamIndex selects every text subtitle stream. When the media has no subtitle streams at
+// all, the audio fallback is used if it was requested; otherwise an error explains why.
+func planSubtitleExtraction(subtitles []subtitleStream, hasAudio bool, streamIndex int, fallbackAudio bool) (selected []subtitleStream, useAudioFallback bool, err error) {
+	if len(subtitles) == 0 {
+		if !fallbackAudio {
+			return nil, false, fmt.Errorf("the input has no subtitle streams; set 'fallback_audio_for_transcription' to true to extract audio for transcription instead")
+		}
+		if !hasAudio {
+			return nil, false, fmt.Errorf("the input has neither subtitle nor audio streams, so there is nothing to extract")
+		}
+		return nil, true, nil
+	}
+
+	var available []string
+	for _, s := range subtitles {
+		available = append(available, s.String())
+	}
+	if streamIndex >= 0 {
+		for _, s := range subtitles {
+			if s.Index != streamIndex {
+				continue
+			}
+			if !s.isText() {
+				return nil, false, fmt.Errorf("subtitle stream #%d is an image-based %s stream and cannot be converted to text", s.Index, s.CodecName)
+			}
+			return []subtitleStream{s}, false, nil
+		}
+		return nil, false, fmt.Errorf("stream #%d is not a subtitle stream; available subtitle streams: %s", streamIndex, strings.Join(available, ", "))
+	}
+
+	for _, s := range subtitles {
+		if s.isText() {
+			selected = append(selected, s)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, false, fmt.Errorf("all subtitle streams are image-based and cannot be converted to text: %s", strings.Join(available, ", "))
+	}
+	return selected, false, nil
+}
+
+// mediaStream is one stream of an input, as listed when a requested stream does not exist.
+type mediaStream struct {
+	Index     int
+	CodecType string
+	CodecName string
+	Language  string
+}
+
+func (s mediaStream) String() string {
+	if s.Language != "" {
+		return fmt.Sprintf("#%d (%s, %s)", s.Index, s.CodecName, s.Language)
+	}
+	return fmt.Sprintf("#%d (%s)", s.Index, s.CodecName)
+}
+
+// streamSelection picks the video and audio streams of an input by the index reported by
+// ffmpeg_get_media_info. -1 leaves the choice to FFmpeg.
+type streamSelection struct {
+	Video int
+	Audio int
+}
+
+// defaultStreams lets FFmpeg choose both streams, as the tools did before streams could be selected.
+var defaultStreams = streamSelection{Video: -1, Audio: -1}
+
+// explicit reports whether either stream was selected.
+func (s streamSelection) explicit() bool {
+	return s.Video >= 0 || s.Audio >= 0
+}
+
+// checkStreamSelection confirms that each selected stream exists in the JSON produced by
+// executeGetMediaInfo and is of the right type. The error lists the streams of that type.
+func checkStreamSelection(mediaInfoJSON string, selection streamSelection) error {
+	var info struct {
+		Streams []struct {
+			Index     int               `json:"index"`
+			CodecType string            `json:"codec_type"`
+			CodecName string            `json:"codec_name"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	var streams []mediaStream
+	for _, stream := range info.Streams {
+		streams = append(streams, mediaStream{Index: stream.Index, CodecType: stream.CodecType, CodecName: stream.CodecName, Language: stream.Tags["language"]})
+	}
+	for _, wanted := range []struct {
+		codecType, noun string
+		index           int
+	}{{"video", "a video", selection.Video}, {"audio", "an audio", selection.Audio}} {
+		if wanted.index < 0 {
+			continue
+		}
+		var available []string
+		found := false
+		for _, stream := range streams {
+			if stream.CodecType != wanted.codecType {
+				continue
+			}
+			available = append(available, stream.String())
+			if stream.Index == wanted.index {
+				found = true
+			}
+		}
+		switch {
+		case found:
+		case len(available) == 0:
+			return fmt.Errorf("stream #%d is not %s stream; the input has no %s streams", wanted.index, wanted.noun, wanted.codecType)
+		default:
+			return fmt.Errorf("stream #%d is not %s stream; available %s streams: %s", wanted.index, wanted.noun, wanted.codecType, strings.Join(available, ", "))
+		}
+	}
+	return nil
+}
+
+// mapArgs returns the -map arguments that select the streams of the given FFmpeg input, or
+// nothing when no stream was selected. Once one stream is selected, FFmpeg no longer picks the
+// other, so the first stream of that type is mapped instead; audio is optional so that silent
+// inputs still work.
+func (s streamSelection) mapArgs(input int) []string {
+	if !s.explicit() {
+		return nil
+	}
+	video := fmt.Sprintf("%d:v:0", input)
+	if s.Video >= 0 {
+		video = fmt.Sprintf("%d:%d", input, s.Video)
+	}
+	audio := fmt.Sprintf("%d:a:0?", input)
+	if s.Audio >= 0 {
+		audio = fmt.Sprintf("%d:%d", input, s.Audio)
+	}
+	return []string{"-map", video, "-map", audio}
+}
+
+// audioFormat is the sample rate, channel count and codec of an audio stream.
+type audioFormat struct {
+	SampleRate int
+	Channels   int
+	CodecName  string
+	// ChannelLayout is the layout ffprobe names, e.g. "5.1(side)"; it is empty or "unknown" for
+	// files that do not record one.
+	ChannelLayout string
+}
+
+func (f audioFormat) String() string {
+	return fmt.Sprintf("%d Hz, %d ch, %s", f.SampleRate, f.Channels, f.CodecName)
+}
+
+// parseAudioFormat returns the format of the first audio stream in the JSON produced by
+// executeGetMediaInfo.
+func parseAudioFormat(mediaInfoJSON string) (audioFormat, error) {
+	var info struct {
+		Streams []struct {
+			CodecType     string `json:"codec_type"`
+			CodecName     string `json:"codec_name"`
+			SampleRate    string `json:"sample_rate"`
+			Channels      int    `json:"channels"`
+			ChannelLayout string `json:"channel_layout"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return audioFormat{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	for _, stream := range info.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		sampleRate, err := strconv.Atoi(stream.SampleRate)
+		if err != nil || sampleRate <= 0 || stream.Channels <= 0 {
+			return audioFormat{}, fmt.Errorf("ffprobe did not report a usable sample rate and channel count (got %q, %d)", stream.SampleRate, stream.Channels)
+		}
+		return audioFormat{SampleRate: sampleRate, Channels: stream.Channels, CodecName: stream.CodecName, ChannelLayout: stream.ChannelLayout}, nil
+	}
+	return audioFormat{}, fmt.Errorf("no audio stream found")
+}
+
+// vfrTolerance is the relative difference between a video stream's r_frame_rate and
+// avg_frame_rate above which the stream is treated as variable frame rate.
+const vfrTolerance = 0.01
+
+// videoTiming summarizes the stream layout and frame timing of an input, as used by the
+// concatenation standardization pass.
+type videoTiming struct {
+	StreamCount  int
+	HasVideo     bool
+	RFrameRate   string
+	AvgFrameRate string
+}
+
+// parseVideoTiming reads the stream count and the frame rates of the first video stream from
+// the JSON produced by executeGetMediaInfo.
+func parseVideoTiming(mediaInfoJSON string) (videoTiming, error) {
+	var info struct {
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			RFrameRate   string `json:"r_frame_rate"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return videoTiming{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	timing := videoTiming{StreamCount: len(info.Streams)}
+	for _, stream := range info.Streams {
+		if stream.CodecType == "video" {
+			timing.HasVideo = true
+			timing.RFrameRate = stream.RFrameRate
+			timing.AvgFrameRate = stream.AvgFrameRate
+			break
+		}
+	}
+	return timing, nil
+}
+
+// isVFR reports whether the video stream looks variable frame rate: ffprobe's r_frame_rate is
+// the lowest rate that represents every timestamp, so for VFR sources it disagrees with the
+// average rate. Streams with unknown rates are assumed to be constant.
+func (v videoTiming) isVFR() bool {
+	if !v.HasVideo {
+		return false
+	}
+	r, okR := parseFrameRate(v.RFrameRate)
+	avg, okAvg := parseFrameRate(v.AvgFrameRate)
+	if !okR || !okAvg {
+		return false
+	}
+	return math.Abs(r-avg)/r > vfrTolerance
+}
+
+// String describes the frame rates for result notes, e.g. "r_frame_rate 30/1 vs avg_frame_rate 27.88 fps".
+func (v videoTiming) String() string {
+	avg, _ := parseFrameRate(v.AvgFrameRate)
+	return fmt.Sprintf("r_frame_rate %s vs avg_frame_rate %.2f fps", v.RFrameRate, avg)
+}
+
+// parseFrameRate converts an ffprobe rational such as "30000/1001" to frames per second.
+// It returns false for missing or zero rates ("0/0").
+func parseFrameRate(rate string) (float64, bool) {
+	num, den, found := strings.Cut(rate, "/")
+	if !found {
+		den = "1"
+	}
+	n, errN := strconv.ParseFloat(num, 64)
+	d, errD := strconv.ParseFloat(den, 64)
+	if errN != nil || errD != nil || n <= 0 || d <= 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// videoGeometry is the frame size and rate of the first video stream, and whether the input
+// also carries audio, as needed to plan a size-targeted encode.
+type videoGeometry struct {
+	Width     int
+	Height    int
+	FrameRate float64
+	HasAudio  bool
+}
+
+// parseVideoGeometry reads the dimensions and frame rate of the first video stream from the
+// JSON produced by executeGetMediaInfo. The average frame rate is preferred; r_frame_rate is
+// used when the average is not reported.
+func parseVideoGeometry(mediaInfoJSON string) (videoGeometry, error) {
+	var info struct {
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			RFrameRate   string `json:"r_frame_rate"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return videoGeometry{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	var geometry videoGeometry
+	foundVideo := false
+	for _, stream := range info.Streams {
+		switch stream.CodecType {
+		case "audio":
+			geometry.HasAudio = true
+		case "video":
+			if foundVideo {
+				continue
+			}
+			foundVideo = true
+			if stream.Width <= 0 || stream.Height <= 0 {
+				return videoGeometry{}, fmt.Errorf("ffprobe did not report usable video dimensions (got %dx%d)", stream.Width, stream.Height)
+			}
+			geometry.Width, geometry.Height = stream.Width, stream.Height
+			rate, ok := parseFrameRate(stream.AvgFrameRate)
+			if !ok {
+				rate, ok = parseFrameRate(stream.RFrameRate)
+			}
+			if !ok {
+				return videoGeometry{}, fmt.Errorf("ffprobe did not report a usable frame rate (got %q, %q)", stream.AvgFrameRate, stream.RFrameRate)
+			}
+			geometry.FrameRate = rate
+		}
+	}
+	if !foundVideo {
+		return videoGeometry{}, fmt.Errorf("no video stream found")
+	}
+	return geometry, nil
+}
+
+// videoColorInfo is the color metadata ffprobe reports for the first video stream.
+type videoColorInfo struct {
+	ColorTransfer  string
+	ColorPrimaries string
+	ColorSpace     string
+	PixFmt         string
+	HasAudio       bool
+}
+
+// isHDR reports whether the stream uses an HDR transfer function: PQ (SMPTE ST 2084) or HLG
+// (ARIB STD-B67). BT.2020 primaries alone do not make a stream HDR, as they are also used for SDR.
+func (c videoColorInfo) isHDR() bool {
+	switch c.ColorTransfer {
+	case "smpte2084", "arib-std-b67":
+		return true
+	}
+	return false
+}
+
+// String describes the color metadata for tool results, e.g. "smpte2084/bt2020/bt2020nc".
+// Missing values are reported as "unknown".
+func (c videoColorInfo) String() string {
+	values := []string{c.ColorTransfer, c.ColorPrimaries, c.ColorSpace}
+	for i, v := range values {
+		if v == "" {
+			values[i] = "unknown"
+		}
+	}
+	return strings.Join(values, "/")
+}
+
+// parseVideoColorInfo reads the color metadata of the first video stream from the JSON produced
+// by executeGetMediaInfo.
+func parseVideoColorInfo(mediaInfoJSON string) (videoColorInfo, error) {
+	var info struct {
+		Streams []struct {
+			CodecType      string `json:"codec_type"`
+			ColorTransfer  string `json:"color_transfer"`
+			ColorPrimaries string `json:"color_primaries"`
+			ColorSpace     string `json:"color_space"`
+			PixFmt         string `json:"pix_fmt"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
+		return videoColorInfo{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	var color videoColorInfo
+	foundVideo := false
+	for _, stream := range info.Streams {
+		switch stream.CodecType {
+		case "audio":
+			color.HasAudio = true
+		case "video":
+			if foundVideo {
+				continue
+			}
+			foundVideo = true
+			color.ColorTransfer = stream.ColorTransfer
+			color.ColorPrimaries = stream.ColorPrimaries
+			color.ColorSpace = stream.ColorSpace
+			color.PixFmt = stream.PixFmt
+		}
+	}
+	if !foundVideo {
+		return videoColorInfo{}, fmt.Errorf("no video stream found")
+	}
+	return color, nil
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -13,3 +16,256 @@ func TestExecuteGetMediaInfo(t *testing.T) {
 		t.Errorf("expected no error, but got: %v", err)
 	}
 }
+
+const mkvWithSubtitlesJSON = `{
+  "streams": [
+    {"index": 0, "codec_name": "h264", "codec_type": "video"},
+    {"index": 1, "codec_name": "aac", "codec_type": "audio", "tags": {"language": "eng"}},
+    {"index": 2, "codec_name": "subrip", "codec_type": "subtitle", "tags": {"language": "eng", "title": "English"}},
+    {"index": 3, "codec_name": "hdmv_pgs_subtitle", "codec_type": "subtitle", "tags": {"language": "fra"}},
+    {"index": 4, "codec_name": "ass", "codec_type": "subtitle", "tags": {"language": "spa"}}
+  ],
+  "format": {"duration": "12.000000"}
+}`
+
+const mp4WithoutSubtitlesJSON = `{
+  "streams": [
+    {"index": 0, "codec_name": "h264", "codec_type": "video"},
+    {"index": 1, "codec_name": "aac", "codec_type": "audio"}
+  ],
+  "format": {"duration": "8.000000"}
+}`
+
+func TestPlanSubtitleExtraction(t *testing.T) {
+	t.Run("stream present", func(t *testing.T) {
+		subtitles, hasAudio, err := parseMediaStreams(mkvWithSubtitlesJSON)
+		if err != nil {
+			t.Fatalf("unexpected parse error: %v", err)
+		}
+		if len(subtitles) != 3 || !hasAudio {
+			t.Fatalf("expected 3 subtitle streams and audio, got %v (audio=%t)", subtitles, hasAudio)
+		}
+
+		selected, fallback, err := planSubtitleExtraction(subtitles, hasAudio, -1, true)
+		if err != nil || fallback {
+			t.Fatalf("expected subtitle path, got fallback=%t err=%v", fallback, err)
+		}
+		// The image-based PGS stream is skipped when extracting all streams.
+		if len(selected) != 2 || selected[0].Index != 2 || selected[1].Index != 4 {
+			t.Errorf("expected text streams #2 and #4, got %v", selected)
+		}
+
+		selected, _, err = planSubtitleExtraction(subtitles, hasAudio, 4, false)
+		if err != nil || len(selected) != 1 || selected[0].Language != "spa" {
+			t.Errorf("expected stream #4 (spa), got %v, %v", selected, err)
+		}
+	})
+
+	t.Run("stream absent with fallback", func(t *testing.T) {
+		subtitles, hasAudio, err := parseMediaStreams(mp4WithoutSubtitlesJSON)
+		if err != nil {
+			t.Fatalf("unexpected parse error: %v", err)
+		}
+		selected, fallback, err := planSubtitleExtraction(subtitles, hasAudio, -1, true)
+		if err != nil || !fallback || len(selected) != 0 {
+			t.Errorf("expected audio fallback, got selected=%v fallback=%t err=%v", selected, fallback, err)
+		}
+
+		if _, _, err := planSubtitleExtraction(subtitles, hasAudio, -1, false); err == nil || !strings.Contains(err.Error(), "fallback_audio_for_transcription") {
+			t.Errorf("expected an error pointing at the fallback option, got %v", err)
+		}
+		if _, _, err := planSubtitleExtraction(subtitles, false, -1, true); err == nil {
+			t.Error("expected an error when there is no audio to fall back to")
+		}
+	})
+
+	t.Run("invalid index", func(t *testing.T) {
+		subtitles, hasAudio, _ := parseMediaStreams(mkvWithSubtitlesJSON)
+		_, _, err := planSubtitleExtraction(subtitles, hasAudio, 1, false)
+		if err == nil || !strings.Contains(err.Error(), "not a subtitle stream") || !strings.Contains(err.Error(), "#2 (subrip, eng, \"English\")") {
+			t.Errorf("expected an error listing the available streams, got %v", err)
+		}
+		_, _, err = planSubtitleExtraction(subtitles, hasAudio, 3, false)
+		if err == nil || !strings.Contains(err.Error(), "image-based") {
+			t.Errorf("expected an image-based subtitle error, got %v", err)
+		}
+	})
+}
+
+func TestCheckStreamSelection(t *testing.T) {
+	mediaInfo := `{"streams": [
+		{"index": 0, "codec_type": "video", "codec_name": "h264"},
+		{"index": 1, "codec_type": "audio", "codec_name": "aac", "tags": {"language": "eng"}},
+		{"index": 2, "codec_type": "audio", "codec_name": "aac", "tags": {"language": "deu"}},
+		{"index": 3, "codec_type": "subtitle", "codec_name": "subrip"}
+	]}`
+	testCases := []struct {
+		selection streamSelection
+		wantErr   string
+	}{
+		{defaultStreams, ""},
+		{streamSelection{Video: 0, Audio: 2}, ""},
+		{streamSelection{Video: -1, Audio: 3}, "stream #3 is not an audio stream; available audio streams: #1 (aac, eng), #2 (aac, deu)"},
+		{streamSelection{Video: 4, Audio: 1}, "stream #4 is not a video stream; available video streams: #0 (h264)"},
+	}
+	for _, tc := range testCases {
+		err := checkStreamSelection(mediaInfo, tc.selection)
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%+v: unexpected error %v", tc.selection, err)
+		}
+		if tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+			t.Errorf("%+v: got error %v, want %q", tc.selection, err, tc.wantErr)
+		}
+	}
+
+	audioOnly := `{"streams": [{"index": 0, "codec_type": "audio", "codec_name": "mp3"}]}`
+	if err := checkStreamSelection(audioOnly, streamSelection{Video: 0, Audio: -1}); err == nil || err.Error() != "stream #0 is not a video stream; the input has no video streams" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseAudioFormat(t *testing.T) {
+	format, err := parseAudioFormat(`{"streams": [
+		{"index": 0, "codec_type": "video", "codec_name": "h264"},
+		{"index": 1, "codec_type": "audio", "codec_name": "pcm_s24le", "sample_rate": "48000", "channels": 2, "channel_layout": "stereo"}
+	]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != (audioFormat{SampleRate: 48000, Channels: 2, CodecName: "pcm_s24le", ChannelLayout: "stereo"}) {
+		t.Errorf("unexpected audio format: %+v", format)
+	}
+	if _, err := parseAudioFormat(`{"streams": [{"codec_type": "video"}]}`); err == nil {
+		t.Error("expected an error when there is no audio stream")
+	}
+}
+
+func TestVideoTimingIsVFR(t *testing.T) {
+	testCases := []struct {
+		name     string
+		timing   videoTiming
+		expected bool
+	}{
+		{"constant NTSC", videoTiming{HasVideo: true, RFrameRate: "30000/1001", AvgFrameRate: "30000/1001"}, false},
+		{"within tolerance", videoTiming{HasVideo: true, RFrameRate: "25/1", AvgFrameRate: "2499/100"}, false},
+		{"screen recording", videoTiming{HasVideo: true, RFrameRate: "60/1", AvgFrameRate: "2147/77"}, true},
+		{"unknown average", videoTiming{HasVideo: true, RFrameRate: "30/1", AvgFrameRate: "0/0"}, false},
+		{"audio only", videoTiming{StreamCount: 1}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.timing.isVFR(); got != tc.expected {
+				t.Errorf("expected isVFR %t, got %t", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseVideoGeometry(t *testing.T) {
+	geometry, err := parseVideoGeometry(`{"streams": [
+		{"codec_type": "audio", "sample_rate": "48000", "channels": 2},
+		{"codec_type": "video", "width": 1920, "height": 1080, "r_frame_rate": "30/1", "avg_frame_rate": "30000/1001"}
+	]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if geometry.Width != 1920 || geometry.Height != 1080 || !geometry.HasAudio {
+		t.Errorf("unexpected geometry: %+v", geometry)
+	}
+	if geometry.FrameRate < 29.96 || geometry.FrameRate > 29.98 {
+		t.Errorf("expected the average frame rate, got %.3f", geometry.FrameRate)
+	}
+
+	geometry, err = parseVideoGeometry(`{"streams": [{"codec_type": "video", "width": 640, "height": 360, "r_frame_rate": "25/1", "avg_frame_rate": "0/0"}]}`)
+	if err != nil || geometry.FrameRate != 25 || geometry.HasAudio {
+		t.Errorf("expected r_frame_rate fallback without audio, got %+v (%v)", geometry, err)
+	}
+
+	if _, err := parseVideoGeometry(`{"streams": [{"codec_type": "audio"}]}`); err == nil {
+		t.Error("expected an error for an input without video")
+	}
+}
+
+func TestParseVideoColorInfo(t *testing.T) {
+	testCases := []struct {
+		name     string
+		json     string
+		expected bool
+		summary  string
+	}{
+		{"pq", `{"streams": [{"codec_type": "video", "pix_fmt": "yuv420p10le", "color_transfer": "smpte2084", "color_primaries": "bt2020", "color_space": "bt2020nc"}, {"codec_type": "audio"}]}`, true, "smpte2084/bt2020/bt2020nc"},
+		{"hlg", `{"streams": [{"codec_type": "video", "color_transfer": "arib-std-b67", "color_primaries": "bt2020", "color_space": "bt2020nc"}]}`, true, "arib-std-b67/bt2020/bt2020nc"},
+		{"sdr bt709", `{"streams": [{"codec_type": "video", "color_transfer": "bt709", "color_primaries": "bt709", "color_space": "bt709"}]}`, false, "bt709/bt709/bt709"},
+		{"untagged", `{"streams": [{"codec_type": "video", "pix_fmt": "yuv420p"}]}`, false, "unknown/unknown/unknown"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			color, err := parseVideoColorInfo(tc.json)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if color.isHDR() != tc.expected || color.String() != tc.summary {
+				t.Errorf("expected HDR %t (%s), got %t (%s)", tc.expected, tc.summary, color.isHDR(), color)
+			}
+		})
+	}
+
+	if _, err := parseVideoColorInfo(`{"streams": [{"codec_type": "audio"}]}`); err == nil {
+		t.Error("expected an error for an input without video")
+	}
+}
+
+func TestParseChapters(t *testing.T) {
+	chaptersJSON := `{
+  "chapters": [
+    {"id": 0, "time_base": "1/1000", "start": 0, "start_time": "0.000000", "end": 95500, "end_time": "95.500000", "tags": {"title": "Intro"}},
+    {"id": 1, "time_base": "1/44100", "start": 4211550, "start_time": "95.500000", "end": 13230000, "end_time": "300.000000", "tags": {"title": "Interview"}},
+    {"id": 2, "time_base": "1/1000", "start": 300000, "start_time": "300.000000", "end": 312000, "end_time": "312.000000"}
+  ]
+}`
+	chapters, err := parseChapters(chaptersJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []mediaChapter{{0, 95.5, "Intro"}, {95.5, 300, "Interview"}, {300, 312, ""}}
+	if !reflect.DeepEqual(chapters, want) {
+		t.Errorf("got %+v, want %+v", chapters, want)
+	}
+	encoded, _ := json.Marshal(chapters[:1])
+	if string(encoded) != `[{"start":0,"end":95.5,"title":"Intro"}]` {
+		t.Errorf("unexpected JSON %s", encoded)
+	}
+
+	for _, noChapters := range []string{`{"chapters": []}`, `{}`} {
+		chapters, err := parseChapters(noChapters)
+		if err != nil || chapters == nil || len(chapters) != 0 {
+			t.Errorf("%s: expected an empty list, got %v, %v", noChapters, chapters, err)
+		}
+	}
+	if _, err := parseChapters(`{"chapters": [{"id": 0, "start": 0, "end": 1000}]}`); err == nil {
+		t.Error("expected an error for a chapter without start_time and end_time")
+	}
+	if _, err := parseChapters("not json"); err == nil {
+		t.Error("expected an error for output that is not JSON")
+	}
+}
+
+func TestSubtitleStreamOutputFileName(t *testing.T) {
+	testCases := []struct {
+		language string
+		expected string
+	}{
+		{"eng", "clip_s2_eng.srt"},
+		{"pt-BR", "clip_s2_pt-BR.srt"},
+		{"", "clip_s2.srt"},
+		{"../../etc/cron.d/x", "clip_s2.srt"},
+		{"en/..", "clip_s2.srt"},
+		{"english language", "clip_s2.srt"},
+	}
+	for _, tc := range testCases {
+		stream := subtitleStream{Index: 2, CodecName: "subrip", Language: tc.language}
+		if actual := stream.outputFileName("clip", "srt"); actual != tc.expected {
+			t.Errorf("language %q: expected %q, got %q", tc.language, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// gcsImpersonator mints the tokens of the service accounts that tool calls may impersonate for
+// GCS access. It is set from IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS at startup; when nil or
+// empty, a call naming a service account is rejected.
+var gcsImpersonator *common.Impersonator
+
+// withImpersonationParam adds the optional 'impersonate_service_account' parameter.
+func withImpersonationParam() mcp.ToolOption {
+	return mcp.WithString(common.ImpersonateServiceAccountParam,
+		mcp.Description("Optional. Email of a service account to read inputs from and write outputs to GCS as, instead of the server's own identity, e.g. to reach a tenant's buckets. It must be allowed by the server's IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS."),
+	)
+}
+
+// impersonationMiddleware reads 'impersonate_service_account' from the request and, when it is
+// set, hands the handler a context carrying that service account's credentials. The GCS
+// helpers of mcp-common build their storage clients from those credentials, so every download
+// and upload of the call runs as the service account. Without the parameter the server's
+// Application Default Credentials are used.
+func impersonationMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		argsMap, ok := request.Params.Arguments.(map[string]interface{})
+		if !ok {
+			return next(ctx, request)
+		}
+		ctx, err := gcsImpersonator.ContextFromArguments(ctx, argsMap)
+		if err != nil {
+			return invalidParamResult(common.ImpersonateServiceAccountParam, "%v", err), nil
+		}
+		return next(ctx, request)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tenantServiceAccount = "tenant-a@project.iam.gserviceaccount.com"
+	otherServiceAccount  = "tenant-b@project.iam.gserviceaccount.com"
+)
+
+// fakeTokenGenerator mints a token named after the service account instead of calling IAM.
+type fakeTokenGenerator struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (g *fakeTokenGenerator) GenerateAccessToken(ctx context.Context, serviceAccount string, scopes []string, lifetime time.Duration) (*oauth2.Token, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = append(g.calls, serviceAccount)
+	return &oauth2.Token{AccessToken: "token-for-" + serviceAccount, Expiry: time.Now().Add(lifetime)}, nil
+}
+
+func useFakeImpersonator(t *testing.T, allowList ...string) *fakeTokenGenerator {
+	t.Helper()
+	original := gcsImpersonator
+	t.Cleanup(func() { gcsImpersonator = original })
+	generator := &fakeTokenGenerator{}
+	gcsImpersonator = common.NewImpersonator(allowList, generator)
+	return generator
+}
+
+// credentialsRecorder is a tool handler that records the GCS credentials of each call.
+type credentialsRecorder struct {
+	mu    sync.Mutex
+	creds []*common.GCSCredentials
+}
+
+func (r *credentialsRecorder) handle(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.creds = append(r.creds, common.GCSCredentialsFromContext(ctx))
+	return mcp.NewToolResultText("done"), nil
+}
+
+func TestImpersonationMiddleware(t *testing.T) {
+	generator := useFakeImpersonator(t, tenantServiceAccount)
+	recorder := &credentialsRecorder{}
+	handler := impersonationMiddleware(recorder.handle)
+	call := func(args map[string]interface{}) *mcp.CallToolResult {
+		result, err := handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+
+	if result := call(map[string]interface{}{"input_video_uri": "gs://b/in.mp4"}); result.IsError || recorder.creds[0] != nil {
+		t.Errorf("expected ambient credentials without the parameter, got %+v", recorder.creds[0])
+	}
+
+	result := call(map[string]interface{}{common.ImpersonateServiceAccountParam: " Tenant-A@project.iam.gserviceaccount.com "})
+	if result.IsError {
+		t.Fatalf("unexpected error result: %+v", result)
+	}
+	creds := recorder.creds[1]
+	if creds == nil || creds.ServiceAccount != tenantServiceAccount {
+		t.Fatalf("expected credentials for %s, got %+v", tenantServiceAccount, creds)
+	}
+	token, err := creds.TokenSource.Token()
+	if err != nil || token.AccessToken != "token-for-"+tenantServiceAccount {
+		t.Errorf("expected the impersonated token, got %+v, %v", token, err)
+	}
+	if len(generator.calls) != 1 || generator.calls[0] != tenantServiceAccount {
+		t.Errorf("expected one token minted for %s, got %v", tenantServiceAccount, generator.calls)
+	}
+
+	result = call(map[string]interface{}{common.ImpersonateServiceAccountParam: otherServiceAccount})
+	if !result.IsError || len(recorder.creds) != 2 {
+		t.Fatalf("expected a service account off the allow-list to be rejected before the handler runs, got %+v", result)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "'impersonate_service_account'") || !strings.Contains(text, otherServiceAccount) {
+		t.Errorf("unexpected error text %q", text)
+	}
+}
+
+func TestImpersonationMiddlewareDisabled(t *testing.T) {
+	useFakeImpersonator(t)
+	gcsImpersonator = nil
+	recorder := &credentialsRecorder{}
+	args := map[string]interface{}{common.ImpersonateServiceAccountParam: tenantServiceAccount}
+	result, err := impersonationMiddleware(recorder.handle)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+	if err != nil || !result.IsError || len(recorder.creds) != 0 {
+		t.Fatalf("expected the call to be rejected, got %+v, %v", result, err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS") {
+		t.Errorf("expected the error to name IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS, got %q", text)
+	}
+}
+
+func TestBatchItemsImpersonate(t *testing.T) {
+	useFakeImpersonator(t, tenantServiceAccount, otherServiceAccount)
+	recorder := &credentialsRecorder{}
+	batchOperations["test_record_credentials"] = func(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+		return recorder.handle(ctx, request)
+	}
+	t.Cleanup(func() { delete(batchOperations, "test_record_credentials") })
+
+	// The batch's own service account is applied by the server middleware.
+	ctx, err := gcsImpersonator.ContextFromArguments(context.Background(), map[string]interface{}{common.ImpersonateServiceAccountParam: tenantServiceAccount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"operation":   "test_record_credentials",
+		"concurrency": float64(1),
+		"inputs": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{common.ImpersonateServiceAccountParam: otherServiceAccount},
+		},
+	}}}
+	result, err := ffmpegBatchHandler(ctx, request, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	got := map[string]bool{}
+	for _, creds := range recorder.creds {
+		if creds != nil {
+			got[creds.ServiceAccount] = true
+		}
+	}
+	if len(recorder.creds) != 2 || !got[tenantServiceAccount] || !got[otherServiceAccount] {
+		t.Errorf("expected one item as the batch's service account and one as its own, got %v", got)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// jobHistorySizeEnvVar sets how many jobs the history keeps.
+	jobHistorySizeEnvVar = "AVTOOL_JOB_HISTORY_SIZE"
+	// jobHistoryGCSPrefixEnvVar is the gs:// prefix under which finished jobs are mirrored as JSONL.
+	jobHistoryGCSPrefixEnvVar = "AVTOOL_JOB_HISTORY_GCS_PREFIX"
+
+	defaultJobHistorySize = 200
+	defaultJobListLimit   = 50
+	// jobResultSummaryLength is the longest result summary kept, in characters.
+	jobResultSummaryLength = 500
+
+	jobIDParam = "job_id"
+)
+
+// Job statuses.
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobIDRegex limits caller-assigned job IDs to characters that are safe in logs and object names.
+var jobIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// jobOutputGCSPattern and jobOutputLocalPattern find the outputs a tool reports in its result
+// text: the GCS URIs, and the local paths of "... saved locally to: <path>." messages.
+var (
+	jobOutputGCSPattern   = regexp.MustCompile(`gs://[^\s,'"]+`)
+	jobOutputLocalPattern = regexp.MustCompile(`saved locally to: (\S+)`)
+)
+
+// jobHistoryTools are the tools that read the history; their own calls are not recorded.
+var jobHistoryTools = map[string]bool{"avtool_list_jobs": true, "avtool_get_job": true}
+
+// errJobIDInUse is returned when a caller-assigned job ID is already in the history.
+var errJobIDInUse = errors.New("is already in use")
+
+// jobRecord is one tool call in the job history.
+type jobRecord struct {
+	ID   string `json:"id"`
+	Tool string `json:"tool"`
+	// ArgsHash is the SHA-256 of the call's arguments, other than job_id, as JSON with sorted
+	// keys, so repeated calls can be spotted without storing their arguments.
+	ArgsHash string `json:"args_hash"`
+	// ServiceAccount is the service account the call impersonated, empty for the server's own
+	// identity. Only callers impersonating the same account can read the job back.
+	ServiceAccount string     `json:"service_account,omitempty"`
+	Status         string     `json:"status"`
+	StartTime      time.Time  `json:"start_time"`
+	EndTime        *time.Time `json:"end_time,omitempty"`
+	// ResultSummary is the start of the result's first text.
+	ResultSummary string   `json:"result_summary,omitempty"`
+	OutputURIs    []string `json:"output_uris,omitempty"`
+}
+
+// jobRecordSink writes one object of JSONL job records.
+type jobRecordSink interface {
+	Write(ctx context.Context, objectURI string, data []byte) error
+}
+
+// gcsJobRecordSink writes job record objects to GCS with the server's own credentials.
+type gcsJobRecordSink struct{}
+
+func (gcsJobRecordSink) Write(ctx context.Context, objectURI string, data []byte) error {
+	bucket, object, err := common.ParseGCSPath(objectURI)
+	if err != nil {
+		return err
+	}
+	return common.UploadToGCS(ctx, bucket, object, "application/x-ndjson", data)
+}
+
+// jobHistory keeps the latest tool calls of this process in a ring buffer: once it holds
+// capacity jobs, each new job evicts the oldest. The history lives in memory only and is lost
+// when the server restarts; with a sink, each finished job is also written under prefix, one
+// object per job in the partition of its day, as GCS objects can't be appended to. Mirroring
+// is best effort: a failed write is logged and the record stays in memory.
+type jobHistory struct {
+	capacity int
+	sink     jobRecordSink
+	prefix   string
+	now      func() time.Time
+
+	mu   sync.Mutex
+	ring []*jobRecord // in insertion order once next wraps around
+	next int          // the slot the next job replaces once the ring is full
+	byID map[string]*jobRecord
+
+	writes sync.WaitGroup
+}
+
+// jobs is the job history of the server, replaced at startup from the environment.
+var jobs = newJobHistory(defaultJobHistorySize, nil, "")
+
+// newJobHistory creates a history of capacity jobs; a nil sink keeps it in memory only.
+func newJobHistory(capacity int, sink jobRecordSink, prefix string) *jobHistory {
+	return &jobHistory{
+		capacity: capacity,
+		sink:     sink,
+		prefix:   strings.TrimSuffix(prefix, "/"),
+		now:      time.Now,
+		byID:     make(map[string]*jobRecord),
+	}
+}
+
+// loadJobHistory creates the job history from the environment.
+func loadJobHistory(lookup func(string) (string, bool)) (*jobHistory, error) {
+	size := defaultJobHistorySize
+	if value, ok := lookup(jobHistorySizeEnvVar); ok && strings.TrimSpace(value) != "" {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("%s must be a positive number of jobs, got '%s'", jobHistorySizeEnvVar, value)
+		}
+		size = parsed
+	}
+	prefix, _ := lookup(jobHistoryGCSPrefixEnvVar)
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return newJobHistory(size, nil, ""), nil
+	}
+	if !strings.HasPrefix(prefix, "gs://") {
+		return nil, fmt.Errorf("%s must be a gs:// URI, e.g. gs://bucket/avtool/jobs, got '%s'", jobHistoryGCSPrefixEnvVar, prefix)
+	}
+	return newJobHistory(size, gcsJobRecordSink{}, prefix), nil
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "job-" + hex.EncodeToString(b)
+}
+
+// start records a running job of serviceAccount and returns it. An empty id is replaced by a
+// random one; an id already in the history is rejected with errJobIDInUse.
+func (h *jobHistory) start(id, tool, argsHash, serviceAccount string) (jobRecord, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id != "" && h.byID[id] != nil {
+		return jobRecord{}, errJobIDInUse
+	}
+	for id == "" || h.byID[id] != nil {
+		id = newJobID()
+	}
+	record := &jobRecord{ID: id, Tool: tool, ArgsHash: argsHash, ServiceAccount: serviceAccount, Status: jobRunning, StartTime: h.now().UTC()}
+	if len(h.ring) < h.capacity {
+		h.ring = append(h.ring, record)
+	} else {
+		delete(h.byID, h.ring[h.next].ID)
+		h.ring[h.next] = record
+		h.next = (h.next + 1) % h.capacity
+	}
+	h.byID[id] = record
+	return *record, nil
+}
+
+// finish records the outcome of a job. A job evicted while it ran is not recorded again.
+func (h *jobHistory) finish(id string, result *mcp.CallToolResult, err error) {
+	h.mu.Lock()
+	record, ok := h.byID[id]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	end := h.now().UTC()
+	record.EndTime = &end
+	record.Status = jobSucceeded
+	switch {
+	case err != nil:
+		record.Status = jobFailed
+		record.ResultSummary = truncateSummary(err.Error())
+	case result == nil:
+		record.Status = jobFailed
+	default:
+		if result.IsError {
+			record.Status = jobFailed
+		}
+		record.ResultSummary, record.OutputURIs = summarizeJobResult(result)
+	}
+	finished := *record
+	h.mu.Unlock()
+
+	if h.sink != nil {
+		h.writes.Add(1)
+		go func() {
+			defer h.writes.Done()
+			h.mirror(finished)
+		}()
+	}
+}
+
+// mirror writes a finished job to the sink.
+func (h *jobHistory) mirror(record jobRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Not mirroring job %s: %v", record.ID, err)
+		return
+	}
+	objectURI := fmt.Sprintf("%s/dt=%s/%s-%s.jsonl", h.prefix, record.StartTime.Format("2006-01-02"), record.StartTime.Format("20060102T150405.000Z"), record.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeouts[phaseGCSTransfer])
+	defer cancel()
+	if err := h.sink.Write(ctx, objectURI, append(line, '\n')); err != nil {
+		log.Printf("Could not mirror job %s to %s: %v", record.ID, objectURI, err)
+	}
+}
+
+// wait blocks until the finished jobs have been mirrored.
+func (h *jobHistory) wait() {
+	h.writes.Wait()
+}
+
+// get returns the job with the given ID if it ran as serviceAccount.
+func (h *jobHistory) get(id, serviceAccount string) (jobRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	record, ok := h.byID[id]
+	if !ok || record.ServiceAccount != serviceAccount {
+		return jobRecord{}, false
+	}
+	return *record, true
+}
+
+// list returns up to limit jobs of serviceAccount, newest first, of the given tool (any when
+// empty) that started within [since, until]; a zero time leaves that end open.
+func (h *jobHistory) list(tool, serviceAccount string, since, until time.Time, limit int) []jobRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := []jobRecord{}
+	for i := len(h.ring) - 1; i >= 0 && len(records) < limit; i-- {
+		record := h.ring[(h.next+i)%len(h.ring)]
+		if record.ServiceAccount != serviceAccount || (tool != "" && record.Tool != tool) {
+			continue
+		}
+		if (!since.IsZero() && record.StartTime.Before(since)) || (!until.IsZero() && record.StartTime.After(until)) {
+			continue
+		}
+		records = append(records, *record)
+	}
+	return records
+}
+
+// hashArguments returns the SHA-256 of a call's arguments other than job_id. encoding/json
+// sorts map keys, so equal arguments hash alike.
+func hashArguments(argsMap map[string]interface{}) string {
+	args := make(map[string]interface{}, len(argsMap))
+	for key, value := range argsMap {
+		if key != jobIDParam {
+			args[key] = value
+		}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// summarizeJobResult returns the start of a result's first text and the outputs its texts name.
+func summarizeJobResult(result *mcp.CallToolResult) (summary string, outputURIs []string) {
+	seen := map[string]bool{}
+	addOutput := func(uri string) {
+		uri = strings.TrimRight(uri, ".,;:)")
+		if uri != "" && !seen[uri] {
+			seen[uri] = true
+			outputURIs = append(outputURIs, uri)
+		}
+	}
+	for _, content := range result.Content {
+		text, ok := content.(mcp.TextContent)
+		if !ok {
+			continue
+		}
+		if summary == "" {
+			summary = truncateSummary(text.Text)
+		}
+		for _, uri := range jobOutputGCSPattern.FindAllString(text.Text, -1) {
+			addOutput(uri)
+		}
+		for _, match := range jobOutputLocalPattern.FindAllStringSubmatch(text.Text, -1) {
+			addOutput(match[1])
+		}
+	}
+	return summary, outputURIs
+}
+
+// truncateSummary shortens text to jobResultSummaryLength characters.
+func truncateSummary(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= jobResultSummaryLength {
+		return string(runes)
+	}
+	return string(runes[:jobResultSummaryLength]) + "..."
+}
+
+// withJobIDParam adds the optional 'job_id' parameter.
+func withJobIDParam() mcp.ToolOption {
+	return mcp.WithString(jobIDParam,
+		mcp.Description("Optional. ID to record this call under in the job history, so its outcome and outputs can be read back with avtool_get_job if the response is lost. 1 to 64 letters, digits, '.', '_' or '-', not already in the history. Defaults to a random ID, which is reported in the result."),
+	)
+}
+
+// jobHistoryMiddleware records every tool call in the job history: it is added as running
+// before the handler runs and updated with the outcome once it returns. The job ID is appended
+// to the result. A 'job_id' argument pre-assigns the ID. The job is recorded under the call's
+// 'impersonate_service_account', so tenants only see their own jobs.
+func jobHistoryMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if jobHistoryTools[request.Params.Name] {
+			return next(ctx, request)
+		}
+		argsMap, _ := request.Params.Arguments.(map[string]interface{})
+		jobID, _ := argsMap[jobIDParam].(string)
+		jobID = strings.TrimSpace(jobID)
+		if jobID != "" && !jobIDRegex.MatchString(jobID) {
+			return invalidParamResult(jobIDParam, "must be 1 to 64 letters, digits, '.', '_' or '-', got '%s'", jobID), nil
+		}
+		serviceAccount, _ := argsMap[common.ImpersonateServiceAccountParam].(string)
+		serviceAccount = strings.ToLower(strings.TrimSpace(serviceAccount))
+		record, err := jobs.start(jobID, request.Params.Name, hashArguments(argsMap), serviceAccount)
+		if err != nil {
+			return invalidParamResult(jobIDParam, "'%s' %v; read that job with avtool_get_job, or pick another ID", jobID, err), nil
+		}
+		result, err := next(ctx, request)
+		jobs.finish(record.ID, result, err)
+		if result != nil {
+			result.Content = append(result.Content, mcp.NewTextContent(fmt.Sprintf("Job ID: %s", record.ID)))
+		}
+		return result, err
+	}
+}
+
+// callerServiceAccount returns the service account the caller impersonates, or "" for the
+// server's own identity.
+func callerServiceAccount(ctx context.Context) string {
+	if creds := common.GCSCredentialsFromContext(ctx); creds != nil {
+		return creds.ServiceAccount
+	}
+	return ""
+}
+
+// addListJobsTool defines and registers the 'avtool_list_jobs' tool.
+func addListJobsTool(s *server.MCPServer) {
+	tool := mcp.NewTool("avtool_list_jobs",
+		mcp.WithDescription("Lists the latest tool calls of this server process made as the same service account (see 'impersonate_service_account'), newest first, with their status, result summary and output URIs. The history is kept in memory, holds a limited number of jobs and is lost when the server restarts."),
+		mcp.WithString("tool", mcp.Description("Optional. Only list calls of this tool, e.g. 'ffmpeg_compress_to_size'.")),
+		mcp.WithString("since", mcp.Description("Optional. Only list calls started at or after this time, in RFC 3339 format (e.g. '2025-06-01T12:00:00Z').")),
+		mcp.WithString("until", mcp.Description("Optional. Only list calls started at or before this time, in RFC 3339 format.")),
+		mcp.WithNumber("limit", mcp.DefaultNumber(defaultJobListLimit), mcp.Description(fmt.Sprintf("Optional. The most calls to list. Defaults to %d.", defaultJobListLimit))),
+		withImpersonationParam(),
+	)
+	s.AddTool(tool, listJobsHandler)
+}
+
+// listJobsHandler is the handler for the list jobs tool.
+func listJobsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	argsMap, err := getArguments(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	tool, _ := argsMap["tool"].(string)
+	var bounds [2]time.Time
+	for i, field := range []string{"since", "until"} {
+		value, _ := argsMap[field].(string)
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		if bounds[i], err = time.Parse(time.RFC3339, strings.TrimSpace(value)); err != nil {
+			return invalidParamResult(field, "must be an RFC 3339 time such as '2025-06-01T12:00:00Z', got '%s'", value), nil
+		}
+	}
+	limit := defaultJobListLimit
+	if value, ok := argsMap["limit"].(float64); ok {
+		if value < 1 || value != float64(int(value)) {
+			return invalidParamResult("limit", "must be a positive whole number, got %g", value), nil
+		}
+		limit = int(value)
+	}
+	records := jobs.list(strings.TrimSpace(tool), callerServiceAccount(ctx), bounds[0], bounds[1], limit)
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal the job list: %v", err)), nil
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.NewTextContent(fmt.Sprintf("Found %d job(s).", len(records))),
+			mcp.NewTextContent(string(data)),
+		},
+	}, nil
+}
+
+// addGetJobTool defines and registers the 'avtool_get_job' tool.
+func addGetJobTool(s *server.MCPServer) {
+	tool := mcp.NewTool("avtool_get_job",
+		mcp.WithDescription("Returns a tool call from this server process's job history by its ID, if it was made as the same service account (see 'impersonate_service_account'): its status, start and end times, result summary and output URIs. Use it to recover the outputs of a call whose response was lost. The history is kept in memory and is lost when the server restarts."),
+		mcp.WithString(jobIDParam, mcp.Required(), mcp.Description("ID of the job, as reported in the tool's result or pre-assigned with its 'job_id' parameter.")),
+		withImpersonationParam(),
+	)
+	s.AddTool(tool, getJobHandler)
+}
+
+// getJobHandler is the handler for the get job tool.
+func getJobHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	argsMap, err := getArguments(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	jobID, _ := argsMap[jobIDParam].(string)
+	jobID = strings.TrimSpace(jobID)
+	if jobID == "" {
+		return invalidParamResult(jobIDParam, reasonRequired), nil
+	}
+	record, ok := jobs.get(jobID, callerServiceAccount(ctx))
+	if !ok {
+		return invalidParamResult(jobIDParam, "'%s' is not in the job history, which only holds the latest calls of this server process", jobID), nil
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal the job: %v", err)), nil
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
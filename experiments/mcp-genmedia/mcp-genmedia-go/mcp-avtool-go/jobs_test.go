@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// useJobHistory replaces the server's job history for the duration of a test.
+func useJobHistory(t *testing.T, h *jobHistory) *jobHistory {
+	t.Helper()
+	original := jobs
+	t.Cleanup(func() { jobs = original })
+	jobs = h
+	return h
+}
+
+// memoryJobSink records the job objects written to it.
+type memoryJobSink struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (s *memoryJobSink) Write(ctx context.Context, objectURI string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[objectURI] = string(data)
+	return nil
+}
+
+func callTool(t *testing.T, handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), name string, args map[string]interface{}) *mcp.CallToolResult {
+	t.Helper()
+	request := mcp.CallToolRequest{}
+	request.Params.Name = name
+	request.Params.Arguments = args
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return result
+}
+
+func TestJobHistoryEviction(t *testing.T) {
+	h := newJobHistory(3, nil, "")
+	for i := 1; i <= 5; i++ {
+		if _, err := h.start(fmt.Sprintf("job-%d", i), "ffmpeg_trim_media", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"job-1", "job-2"} {
+		if _, ok := h.get(id, ""); ok {
+			t.Errorf("expected %s to be evicted", id)
+		}
+	}
+	var listed []string
+	for _, record := range h.list("", "", time.Time{}, time.Time{}, 10) {
+		listed = append(listed, record.ID)
+	}
+	if strings.Join(listed, ",") != "job-5,job-4,job-3" {
+		t.Errorf("expected the three latest jobs, newest first, got %v", listed)
+	}
+	// An evicted ID can be used again, and a job evicted while running is not resurrected.
+	if _, err := h.start("job-1", "ffmpeg_trim_media", "", ""); err != nil {
+		t.Errorf("expected an evicted ID to be free, got %v", err)
+	}
+	h.finish("job-3", mcp.NewToolResultText("done"), nil)
+	if _, ok := h.get("job-3", ""); ok {
+		t.Error("expected job-3 to stay evicted")
+	}
+}
+
+func TestJobHistoryList(t *testing.T) {
+	h := newJobHistory(10, nil, "")
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, tool := range []string{"ffmpeg_trim_media", "ffmpeg_ken_burns", "ffmpeg_trim_media"} {
+		h.now = func() time.Time { return start.Add(time.Duration(i) * time.Hour) }
+		h.start(fmt.Sprintf("job-%d", i), tool, "", "")
+	}
+	ids := func(records []jobRecord) string {
+		var ids []string
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	if got := ids(h.list("ffmpeg_trim_media", "", time.Time{}, time.Time{}, 10)); got != "job-2,job-0" {
+		t.Errorf("tool filter: got %s", got)
+	}
+	if got := ids(h.list("", "", start.Add(time.Hour), start.Add(time.Hour), 10)); got != "job-1" {
+		t.Errorf("time filter: got %s", got)
+	}
+	if got := ids(h.list("", "", time.Time{}, time.Time{}, 1)); got != "job-2" {
+		t.Errorf("limit: got %s", got)
+	}
+}
+
+func TestJobHistoryMiddleware(t *testing.T) {
+	sink := &memoryJobSink{objects: map[string]string{}}
+	useJobHistory(t, newJobHistory(10, sink, "gs://bucket/avtool/jobs/"))
+	release := make(chan struct{})
+	handler := jobHistoryMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		<-release
+		return mcp.NewToolResultText("Compression finished. Output saved locally to: /tmp/out/small.mp4. Output uploaded to GCS: gs://bucket/small.mp4."), nil
+	})
+	args := map[string]interface{}{"input_video_uri": "gs://bucket/big.mp4", jobIDParam: "encode-42"}
+
+	done := make(chan *mcp.CallToolResult)
+	go func() { done <- callTool(t, handler, "ffmpeg_compress_to_size", args) }()
+	var running jobRecord
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		var ok bool
+		if running, ok = jobs.get("encode-42", ""); ok || time.Now().After(deadline) {
+			break
+		}
+	}
+	if running.Status != jobRunning || running.Tool != "ffmpeg_compress_to_size" {
+		t.Fatalf("expected the pre-assigned job to be running, got %+v", running)
+	}
+	if result := callTool(t, handler, "ffmpeg_compress_to_size", args); !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "'encode-42' is already in use") {
+		t.Errorf("expected a job ID in use to be rejected, got %+v", result)
+	}
+	close(release)
+	result := <-done
+	if last := result.Content[len(result.Content)-1].(mcp.TextContent).Text; last != "Job ID: encode-42" {
+		t.Errorf("expected the job ID at the end of the result, got %q", last)
+	}
+
+	// The client lost the response: the job is read back by its ID.
+	result = callTool(t, getJobHandler, "avtool_get_job", map[string]interface{}{jobIDParam: "encode-42"})
+	var record jobRecord
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &record); err != nil {
+		t.Fatalf("unexpected result %+v: %v", result, err)
+	}
+	if record.Status != jobSucceeded || record.EndTime == nil || !strings.HasPrefix(record.ResultSummary, "Compression finished.") {
+		t.Errorf("expected a succeeded job, got %+v", record)
+	}
+	if strings.Join(record.OutputURIs, ",") != "gs://bucket/small.mp4,/tmp/out/small.mp4" {
+		t.Errorf("expected the local and GCS outputs, got %v", record.OutputURIs)
+	}
+	if record.ArgsHash != hashArguments(map[string]interface{}{"input_video_uri": "gs://bucket/big.mp4"}) {
+		t.Errorf("expected the arguments hash to leave out job_id, got %s", record.ArgsHash)
+	}
+
+	jobs.wait()
+	if len(sink.objects) != 1 {
+		t.Fatalf("expected one mirrored object, got %v", sink.objects)
+	}
+	for uri, data := range sink.objects {
+		if !strings.HasPrefix(uri, "gs://bucket/avtool/jobs/dt=") || !strings.HasSuffix(uri, "-encode-42.jsonl") || !strings.Contains(data, `"status":"succeeded"`) {
+			t.Errorf("unexpected mirrored object %s: %s", uri, data)
+		}
+	}
+}
+
+func TestJobHistoryMiddlewareFailuresAndIDs(t *testing.T) {
+	useJobHistory(t, newJobHistory(10, nil, ""))
+	handler := jobHistoryMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if request.GetArguments()["fail"] == true {
+			return nil, errors.New("ffmpeg crashed")
+		}
+		return mcp.NewToolResultError("input_video_uri is required"), nil
+	})
+
+	result := callTool(t, handler, "ffmpeg_trim_media", map[string]interface{}{})
+	last := result.Content[len(result.Content)-1].(mcp.TextContent).Text
+	id := strings.TrimPrefix(last, "Job ID: ")
+	if record, ok := jobs.get(id, ""); !ok || !strings.HasPrefix(id, "job-") || record.Status != jobFailed {
+		t.Errorf("expected a generated ID for a failed job, got %q: %+v", last, record)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "ffmpeg_trim_media"
+	request.Params.Arguments = map[string]interface{}{"fail": true, jobIDParam: "crash"}
+	if _, err := handler(context.Background(), request); err == nil {
+		t.Error("expected the handler error to be returned")
+	}
+	if record, _ := jobs.get("crash", ""); record.Status != jobFailed || record.ResultSummary != "ffmpeg crashed" {
+		t.Errorf("expected the handler error to be recorded, got %+v", record)
+	}
+
+	if result := callTool(t, handler, "ffmpeg_trim_media", map[string]interface{}{jobIDParam: "../etc"}); !result.IsError {
+		t.Errorf("expected an invalid job ID to be rejected, got %+v", result)
+	}
+	if result := callTool(t, getJobHandler, "avtool_get_job", map[string]interface{}{jobIDParam: "unknown"}); !result.IsError {
+		t.Errorf("expected an unknown job to be an error, got %+v", result)
+	}
+	before := len(jobs.list("", "", time.Time{}, time.Time{}, 100))
+	callTool(t, jobHistoryMiddleware(listJobsHandler), "avtool_list_jobs", nil)
+	if after := len(jobs.list("", "", time.Time{}, time.Time{}, 100)); after != before {
+		t.Errorf("expected avtool_list_jobs not to be recorded, went from %d to %d jobs", before, after)
+	}
+}
+
+func TestLoadJobHistory(t *testing.T) {
+	env := func(values map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			value, ok := values[key]
+			return value, ok
+		}
+	}
+	h, err := loadJobHistory(env(nil))
+	if err != nil || h.capacity != defaultJobHistorySize || h.sink != nil {
+		t.Errorf("expected the in-memory default, got %+v, %v", h, err)
+	}
+	h, err = loadJobHistory(env(map[string]string{jobHistorySizeEnvVar: "25", jobHistoryGCSPrefixEnvVar: "gs://bucket/jobs/"}))
+	if err != nil || h.capacity != 25 || h.sink == nil || h.prefix != "gs://bucket/jobs" {
+		t.Errorf("expected a mirrored history of 25, got %+v, %v", h, err)
+	}
+	for _, values := range []map[string]string{{jobHistorySizeEnvVar: "0"}, {jobHistoryGCSPrefixEnvVar: "bucket/jobs"}} {
+		if _, err := loadJobHistory(env(values)); err == nil {
+			t.Errorf("expected %v to be rejected", values)
+		}
+	}
+}
+
+func TestJobHistoryServiceAccounts(t *testing.T) {
+	useJobHistory(t, newJobHistory(10, nil, ""))
+	handler := jobHistoryMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("done"), nil
+	})
+	callTool(t, handler, "ffmpeg_trim_media", map[string]interface{}{jobIDParam: "own"})
+	callTool(t, handler, "ffmpeg_trim_media", map[string]interface{}{jobIDParam: "tenant-a", common.ImpersonateServiceAccountParam: " Tenant-A@proj.iam.gserviceaccount.com"})
+	callTool(t, handler, "ffmpeg_trim_media", map[string]interface{}{jobIDParam: "tenant-b", common.ImpersonateServiceAccountParam: "tenant-b@proj.iam.gserviceaccount.com"})
+
+	call := func(handler server.ToolHandlerFunc, name, serviceAccount string, args map[string]interface{}) string {
+		ctx := context.Background()
+		if serviceAccount != "" {
+			ctx = common.WithGCSCredentials(ctx, &common.GCSCredentials{ServiceAccount: serviceAccount})
+		}
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		request.Params.Arguments = args
+		result, err := handler(ctx, request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result.Content[len(result.Content)-1].(mcp.TextContent).Text
+	}
+	for serviceAccount, want := range map[string]string{"": "own", "tenant-a@proj.iam.gserviceaccount.com": "tenant-a", "tenant-b@proj.iam.gserviceaccount.com": "tenant-b"} {
+		var records []jobRecord
+		if err := json.Unmarshal([]byte(call(listJobsHandler, "avtool_list_jobs", serviceAccount, nil)), &records); err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].ID != want || records[0].ServiceAccount != serviceAccount {
+			t.Errorf("%q: expected only job %s to be listed, got %+v", serviceAccount, want, records)
+		}
+	}
+	if text := call(getJobHandler, "avtool_get_job", "tenant-b@proj.iam.gserviceaccount.com", map[string]interface{}{jobIDParam: "tenant-a"}); !strings.Contains(text, "is not in the job history") {
+		t.Errorf("expected another tenant's job to be hidden, got %s", text)
+	}
+	if text := call(getJobHandler, "avtool_get_job", "", map[string]interface{}{jobIDParam: "tenant-a"}); !strings.Contains(text, "is not in the job history") {
+		t.Errorf("expected a tenant's job to be hidden from the server's own identity, got %s", text)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -34,12 +36,78 @@ func getArguments(request mcp.CallToolRequest) (map[string]interface{}, error) {
 	return argsMap, nil
 }
 
+// redactDataURIArguments returns a copy of the arguments, for logging, with data: URI values
+// shortened to their MIME type and size.
+func redactDataURIArguments(argsMap map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(argsMap))
+	for key, value := range argsMap {
+		if uri, ok := value.(string); ok {
+			value = common.RedactDataURI(uri)
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// withFFmpegLogParams adds the shared 'ffmpeg_log_level' and 'include_full_ffmpeg_log' parameters
+// to a tool definition. They are applied to the request by ffmpegLogMiddleware.
+func withFFmpegLogParams() mcp.ToolOption {
+	return func(t *mcp.Tool) {
+		mcp.WithString("ffmpeg_log_level",
+			mcp.Enum(ffmpegLogLevels...),
+			mcp.Description("Optional. FFMpeg log verbosity (-loglevel): 'error', 'warning', 'info', or 'debug'. Defaults to FFMpeg's own default."),
+		)(t)
+		mcp.WithBoolean("include_full_ffmpeg_log",
+			mcp.Description("Optional. If true and the tool fails, the full captured FFMpeg output is included in the error result. Useful for debugging filter-graph errors."),
+		)(t)
+	}
+}
+
+// ffmpegLogMiddleware reads the FFMpeg logging parameters from the request, makes them available
+// to runFFmpegCommand through the context, and appends the captured log to failed results
+// when 'include_full_ffmpeg_log' is set.
+func ffmpegLogMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		argsMap, ok := request.Params.Arguments.(map[string]interface{})
+		if !ok {
+			return next(ctx, request)
+		}
+		level, _ := argsMap["ffmpeg_log_level"].(string)
+		level = strings.ToLower(strings.TrimSpace(level))
+		includeFullLog, _ := argsMap["include_full_ffmpeg_log"].(bool)
+		if level == "" && !includeFullLog {
+			return next(ctx, request)
+		}
+		validLevel := level == ""
+		for _, l := range ffmpegLogLevels {
+			if level == l {
+				validLevel = true
+			}
+		}
+		if !validLevel {
+			return invalidParamResult("ffmpeg_log_level", "must be one of %s", strings.Join(ffmpegLogLevels, ", ")), nil
+		}
+
+		opts := &ffmpegLogOptions{Level: level, IncludeFullLog: includeFullLog}
+		result, err := next(withFFmpegLogOptions(ctx, opts), request)
+		if err == nil && result != nil && result.IsError && includeFullLog {
+			if fullLog := opts.fullLog(); fullLog != "" {
+				result.Content = append(result.Content, mcp.NewTextContent("Full FFMpeg log:\n"+fullLog))
+			}
+		}
+		return result, err
+	}
+}
+
 // addGetMediaInfoTool defines and registers the 'ffmpeg_get_media_info' tool with the MCP server.
 // This tool is designed to extract media information using ffprobe.
 func addGetMediaInfoTool(s *server.MCPServer, cfg *common.Config) {
 	tool := mcp.NewTool("ffmpeg_get_media_info",
 		mcp.WithDescription("Gets media information (streams, format, etc.) from a media file using ffprobe. Returns JSON output."),
 		mcp.WithString("input_media_uri", mcp.Required(), mcp.Description("URI of the input media file (local path or gs://).")),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegGetMediaInfoHandler(ctx, request, cfg)
@@ -63,12 +131,12 @@ func ffmpegGetMediaInfoHandler(ctx context.Context, request mcp.CallToolRequest,
 
 	inputMediaURI, _ := argsMap["input_media_uri"].(string)
 	if strings.TrimSpace(inputMediaURI) == "" {
-		return mcp.NewToolResultError("Parameter 'input_media_uri' is required."), nil
+		return invalidParamResult("input_media_uri", reasonRequired), nil
 	}
 
 	span.SetAttributes(attribute.String("input_media_uri", inputMediaURI))
 
-	localInputMedia, inputCleanup, err := common.PrepareInputFile(ctx, inputMediaURI, "media_info_input", cfg.ProjectID)
+	localInputMedia, inputCleanup, err := prepareInputFile(ctx, inputMediaURI, "media_info_input", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input media for ffprobe: %v", err)), nil
@@ -93,6 +161,75 @@ func ffmpegGetMediaInfoHandler(ctx context.Context, request mcp.CallToolRequest,
 	return mcp.NewToolResultText(outputJSON), nil
 }
 
+// addGetChaptersTool defines and registers the 'ffmpeg_get_chapters' tool with the MCP server.
+// This tool reads the chapter markers of a media file using ffprobe.
+func addGetChaptersTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_get_chapters",
+		mcp.WithDescription("Reads the chapter markers of a media file using ffprobe. Returns a JSON array of {start, end, title}, with times in seconds; the array is empty when the file has no chapters."),
+		mcp.WithString("input_media_uri", mcp.Required(), mcp.Description("URI of the input media file (local path or gs://).")),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegGetChaptersHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegGetChaptersHandler is the handler function for the 'ffmpeg_get_chapters' tool.
+// It prepares the input file, runs ffprobe with -show_chapters, and returns the chapters as a JSON array.
+func ffmpegGetChaptersHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_get_chapters")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_get_chapters", argsMap)
+
+	inputMediaURI, _ := argsMap["input_media_uri"].(string)
+	if strings.TrimSpace(inputMediaURI) == "" {
+		return invalidParamResult("input_media_uri", reasonRequired), nil
+	}
+
+	span.SetAttributes(attribute.String("input_media_uri", inputMediaURI))
+
+	localInputMedia, inputCleanup, err := prepareInputFile(ctx, inputMediaURI, "chapters_input", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input media for ffprobe: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	chaptersJSON, ffprobeErr := executeGetChapters(ctx, localInputMedia)
+	if ffprobeErr != nil {
+		span.RecordError(ffprobeErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFprobe execution failed: %v. Output: %s", ffprobeErr, chaptersJSON)), nil
+	}
+	chapters, err := parseChapters(chaptersJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read the chapters of %s: %v", inputMediaURI, err)), nil
+	}
+	outputJSON, err := json.Marshal(chapters)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to encode chapters: %v", err)), nil
+	}
+
+	duration := time.Since(startTime)
+	log.Printf("Read %d chapters of %s in %v.", len(chapters), inputMediaURI, duration)
+	span.SetAttributes(
+		attribute.Int("chapter_count", len(chapters)),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+	return mcp.NewToolResultText(string(outputJSON)), nil
+}
+
 // addConvertAudioTool defines and registers the 'ffmpeg_convert_audio_wav_to_mp3' tool.
 // This tool converts WAV audio files to MP3 format.
 func addConvertAudioTool(s *server.MCPServer, cfg *common.Config) {
@@ -102,6 +239,12 @@ func addConvertAudioTool(s *server.MCPServer, cfg *common.Config) {
 		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output MP3 file (e.g., 'converted.mp3'). If omitted, a unique name is generated.")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output MP3 file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output MP3 file to.")),
+		withReproducibleParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegConvertAudioHandler(ctx, request, cfg)
@@ -129,15 +272,19 @@ func ffmpegConvertAudioHandler(ctx context.Context, request mcp.CallToolRequest,
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
 
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_convert_audio_wav_to_mp3: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_convert_audio_wav_to_mp3: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	reproducible, _ := argsMap["reproducible"].(bool)
 	if inputAudioURI == "" {
-		return mcp.NewToolResultError("Parameter 'input_audio_uri' is required."), nil
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
 	}
 
 	span.SetAttributes(
@@ -145,9 +292,10 @@ func ffmpegConvertAudioHandler(ctx context.Context, request mcp.CallToolRequest,
 		attribute.String("output_file_name", outputFileName),
 		attribute.String("output_local_dir", outputLocalDir),
 		attribute.String("output_gcs_bucket", outputGCSBucket),
+		attribute.Bool("reproducible", reproducible),
 	)
 
-	localInputAudio, inputCleanup, err := common.PrepareInputFile(ctx, inputAudioURI, "input_audio", cfg.ProjectID)
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
@@ -161,17 +309,23 @@ func ffmpegConvertAudioHandler(ctx context.Context, request mcp.CallToolRequest,
 	}
 	defer outputCleanup()
 
-	_, ffmpegErr := runFFmpegCommand(ctx, "-y", "-i", localInputAudio, "-acodec", "libmp3lame", tempOutputFile)
+	_, ffmpegErr := runFFmpegCommand(ctx, withReproducibleOutput([]string{"-y", "-i", localInputAudio, "-acodec", "libmp3lame", tempOutputFile}, reproducible)...)
 	if ffmpegErr != nil {
 		span.RecordError(ffmpegErr)
 		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg conversion failed: %v", ffmpegErr)), nil
 	}
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	outputSHA256, err := reproducibleOutputSHA256(ctx, tempOutputFile, reproducible)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to hash output file: %v", err)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
@@ -188,9 +342,15 @@ func ffmpegConvertAudioHandler(ctx context.Context, request mcp.CallToolRequest,
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
 	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
 	if len(messageParts) == 1 {
 		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
 	}
+	if outputSHA256 != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Reproducible output SHA-256: %s.", outputSHA256))
+	}
 	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
 }
 
@@ -205,6 +365,11 @@ func addCreateGifTool(s *server.MCPServer, cfg *common.Config) {
 		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output GIF file (e.g., 'animation.gif'). If omitted, a unique name is generated.")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output GIF file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output GIF file to (uses GENMEDIA_BUCKET if set and this is empty).")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegVideoToGifHandler(ctx, request, cfg)
@@ -228,7 +393,7 @@ func ffmpegVideoToGifHandler(ctx context.Context, request mcp.CallToolRequest, c
 
 	inputVideoURI, _ := argsMap["input_video_uri"].(string)
 	if strings.TrimSpace(inputVideoURI) == "" {
-		return mcp.NewToolResultError("Parameter 'input_video_uri' is required."), nil
+		return invalidParamResult("input_video_uri", reasonRequired), nil
 	}
 
 	scaleFactorParam, _ := argsMap["scale_width_factor"].(float64)
@@ -250,13 +415,16 @@ func ffmpegVideoToGifHandler(ctx context.Context, request mcp.CallToolRequest, c
 	outputLocalDir, _ := argsMap["output_local_dir"].(string)
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_video_to_gif: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryGIF); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_video_to_gif: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
 
 	span.SetAttributes(
 		attribute.String("input_video_uri", inputVideoURI),
@@ -267,7 +435,7 @@ func ffmpegVideoToGifHandler(ctx context.Context, request mcp.CallToolRequest, c
 		attribute.String("output_gcs_bucket", outputGCSBucket),
 	)
 
-	localInputVideo, inputCleanup, err := common.PrepareInputFile(ctx, inputVideoURI, "input_video_for_gif", cfg.ProjectID)
+	localInputVideo, inputCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_for_gif", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
@@ -285,9 +453,10 @@ func ffmpegVideoToGifHandler(ctx context.Context, request mcp.CallToolRequest, c
 	}()
 
 	palettePath := filepath.Join(gifProcessingTempDir, "palette.png")
-	paletteVFFilter := fmt.Sprintf("fps=%.2f,scale=iw*%.2f:-1:flags=lanczos+accurate_rnd+full_chroma_inp,palettegen", fpsParam, scaleFactorParam)
-	log.Printf("Generating palette with VF filter: %s", paletteVFFilter)
-	_, ffmpegErrPalette := runFFmpegCommand(ctx, "-y", "-i", localInputVideo, "-vf", paletteVFFilter, palettePath)
+	inputArgs := []string{"-i", localInputVideo}
+	frameFilter := fmt.Sprintf("fps=%.2f,scale=iw*%.2f:-1:flags=lanczos+accurate_rnd+full_chroma_inp", fpsParam, scaleFactorParam)
+	log.Printf("Generating palette with frame filter: %s", frameFilter)
+	_, ffmpegErrPalette := runFFmpegCommand(ctx, buildGIFPaletteArgs(inputArgs, frameFilter, palettePath)...)
 	if ffmpegErrPalette != nil {
 		span.RecordError(ffmpegErrPalette)
 		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg palette generation failed: %v", ffmpegErrPalette)), nil
@@ -306,20 +475,19 @@ func ffmpegVideoToGifHandler(ctx context.Context, request mcp.CallToolRequest, c
 	}
 	tempGifOutputPath := filepath.Join(gifProcessingTempDir, finalGifFilename)
 
-	gifLavfiFilter := fmt.Sprintf("fps=%.2f,scale=iw*%.2f:-1:flags=lanczos+accurate_rnd+full_chroma_inp [x]; [x][1:v] paletteuse", fpsParam, scaleFactorParam)
-	log.Printf("Creating GIF with LAVFI filter: %s", gifLavfiFilter)
-	_, ffmpegErrGif := runFFmpegCommand(ctx, "-y", "-i", localInputVideo, "-i", palettePath, "-lavfi", gifLavfiFilter, tempGifOutputPath)
+	_, ffmpegErrGif := runFFmpegCommand(ctx, buildGIFArgs(inputArgs, frameFilter, palettePath, "paletteuse", tempGifOutputPath)...)
 	if ffmpegErrGif != nil {
 		span.RecordError(ffmpegErrGif)
 		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg GIF creation failed: %v", ffmpegErrGif)), nil
 	}
 	log.Printf("GIF created successfully in temp location: %s", tempGifOutputPath)
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempGifOutputPath, finalGifFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempGifOutputPath, finalGifFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process generated GIF: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
@@ -329,19 +497,262 @@ func ffmpegVideoToGifHandler(ctx context.Context, request mcp.CallToolRequest, c
 	if finalLocalPath != "" {
 		if outputLocalDir != "" {
 			messageParts = append(messageParts, fmt.Sprintf("Output GIF saved locally to: %s.", finalLocalPath))
-		} else if !(outputGCSBucket != "" && finalGCSPath != "") {
+		} else if !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
 			messageParts = append(messageParts, fmt.Sprintf("Temporary GIF output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
 		}
 	}
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output GIF uploaded to GCS: %s.", finalGCSPath))
 	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
 	if len(messageParts) == 1 {
 		messageParts = append(messageParts, "No specific output location (local/GCS) was processed or an issue occurred in processing.")
 	}
 	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
 }
 
+const (
+	maxGIFImages           = 500
+	defaultImagesGIFFPS    = 2.0
+	defaultImagesGIFScale  = 0.5
+	defaultGIFBayerScale   = 2
+	defaultGIFDitherMode   = "sierra2_4a"
+	imagesToGIFFramePrefix = "frame_"
+)
+
+// gifImageFormats maps the image extensions ffmpeg_images_to_gif reads to their format. The
+// image2 demuxer decodes every frame with the decoder of the first, so the images must share one.
+var gifImageFormats = map[string]string{".png": "png", ".jpg": "jpeg", ".jpeg": "jpeg", ".webp": "webp", ".bmp": "bmp"}
+
+// addImagesToGifTool defines and registers the 'ffmpeg_images_to_gif' tool.
+// This tool turns a list of still images into a GIF animation.
+func addImagesToGifTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_images_to_gif",
+		mcp.WithDescription("Creates an animated GIF from a list of images, one frame per image in the given order, using the same two-pass FFMpeg process (palette generation and palette use) as ffmpeg_video_to_gif."),
+		mcp.WithArray("image_uris", mcp.Required(), mcp.WithStringItems(), mcp.Description(fmt.Sprintf("URIs of the images (local paths or gs://), in frame order, 2 to %d of them. They must all be the same format: PNG, JPEG, WebP or BMP. Images of a different size from the first are scaled to fit its size and letterboxed.", maxGIFImages))),
+		mcp.WithNumber("fps", mcp.DefaultNumber(defaultImagesGIFFPS), mcp.Min(0.1), mcp.Max(50), mcp.Description("Frames (images) per second, from 0.1 to 50. Defaults to 2, showing each image for half a second.")),
+		mcp.WithNumber("scale_width_factor", mcp.DefaultNumber(defaultImagesGIFScale), mcp.Description("Factor to scale the first image's width by (e.g., 0.5 for 50%). Height is scaled to maintain aspect ratio. Use 1.0 for original width. Defaults to 0.5.")),
+		mcp.WithString("dither", mcp.DefaultString(defaultGIFDitherMode), mcp.Enum(gifDitherModes...), mcp.Description("Optional. How colors missing from the 256-color palette are approximated. Error-diffusion modes (sierra2_4a, sierra2, floyd_steinberg, heckbert) give smooth gradients but larger files; 'bayer' gives an ordered pattern that compresses better; 'none' gives banding. Defaults to 'sierra2_4a'.")),
+		mcp.WithNumber("bayer_scale", mcp.DefaultNumber(defaultGIFBayerScale), mcp.Min(0), mcp.Max(5), mcp.Description("Optional. Strength of the bayer dither pattern, from 0 (most visible) to 5. Only used with dither 'bayer'. Defaults to 2.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output GIF file (e.g., 'animation.gif'). If omitted, a unique name is generated.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output GIF file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output GIF file to (uses GENMEDIA_BUCKET if set and this is empty).")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegImagesToGifHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegImagesToGifHandler creates a GIF from a list of images. The images are linked into a
+// temporary directory as a numbered sequence, read with the image2 demuxer at the requested frame
+// rate and run through the same palette passes as ffmpegVideoToGifHandler, scaled to the size of
+// the first image.
+func ffmpegImagesToGifHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_images_to_gif")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_images_to_gif", argsMap)
+
+	rawImageURIs, _ := argsMap["image_uris"].([]interface{})
+	if len(rawImageURIs) < 2 || len(rawImageURIs) > maxGIFImages {
+		return invalidParamResult("image_uris", "must list 2 to %d images, got %d", maxGIFImages, len(rawImageURIs)), nil
+	}
+	imageURIs := make([]string, len(rawImageURIs))
+	imageExt, imageFormat := "", ""
+	for i, raw := range rawImageURIs {
+		uri, _ := raw.(string)
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			return invalidParamResult(fmt.Sprintf("image_uris[%d]", i), "must be a non-empty string"), nil
+		}
+		ext := strings.ToLower(filepath.Ext(uri))
+		format, ok := gifImageFormats[ext]
+		if !ok {
+			return invalidParamResult(fmt.Sprintf("image_uris[%d]", i), "must be a .png, .jpg, .jpeg, .webp or .bmp image, got '%s'", uri), nil
+		}
+		if i == 0 {
+			imageExt, imageFormat = ext, format
+		} else if format != imageFormat {
+			return invalidParamResult(fmt.Sprintf("image_uris[%d]", i), "must be the same format as the first image (%s), got '%s'", imageFormat, uri), nil
+		}
+		imageURIs[i] = uri
+	}
+	fpsParam := defaultImagesGIFFPS
+	if v, ok := argsMap["fps"].(float64); ok {
+		if v < 0.1 || v > 50 {
+			return invalidParamResult("fps", "must be between 0.1 and 50, got %g", v), nil
+		}
+		fpsParam = v
+	}
+	scaleFactorParam := defaultImagesGIFScale
+	if v, ok := argsMap["scale_width_factor"].(float64); ok {
+		if v <= 0 {
+			return invalidParamResult("scale_width_factor", "must be greater than 0, got %g", v), nil
+		}
+		scaleFactorParam = v
+	}
+	dither := defaultGIFDitherMode
+	if v, _ := argsMap["dither"].(string); strings.TrimSpace(v) != "" {
+		dither = strings.TrimSpace(v)
+		if !slices.Contains(gifDitherModes, dither) {
+			return invalidParamResult("dither", "must be one of '%s', got '%s'", strings.Join(gifDitherModes, "', '"), dither), nil
+		}
+	}
+	bayerScale := defaultGIFBayerScale
+	if v, ok := argsMap["bayer_scale"].(float64); ok {
+		if v != math.Trunc(v) || v < 0 || v > 5 {
+			return invalidParamResult("bayer_scale", "must be a whole number from 0 to 5, got %g", v), nil
+		}
+		bayerScale = int(v)
+	}
+
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryGIF); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_images_to_gif: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	paletteUse := gifPaletteUse(dither, bayerScale)
+	span.SetAttributes(
+		attribute.Int("image_count", len(imageURIs)),
+		attribute.Float64("fps", fpsParam),
+		attribute.Float64("scale_width_factor", scaleFactorParam),
+		attribute.String("paletteuse", paletteUse),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	gifProcessingTempDir, err := os.MkdirTemp("", "gif_processing_")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to create temp directory for GIF processing: %v", err)), nil
+	}
+	defer os.RemoveAll(gifProcessingTempDir)
+
+	var inputCleanups []func()
+	defer func() {
+		for _, c := range inputCleanups {
+			c()
+		}
+	}()
+	framesDir := filepath.Join(gifProcessingTempDir, "frames")
+	if err := os.Mkdir(framesDir, 0o755); err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to create temp directory for GIF frames: %v", err)), nil
+	}
+	var firstImage string
+	for i, uri := range imageURIs {
+		localPath, cleanup, errPrep := prepareInputFile(ctx, uri, fmt.Sprintf("gif_image_%d", i), cfg.ProjectID)
+		if errPrep != nil {
+			span.RecordError(errPrep)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input image %s: %v", uri, errPrep)), nil
+		}
+		inputCleanups = append(inputCleanups, cleanup)
+		absPath, errAbs := filepath.Abs(localPath)
+		if errAbs != nil {
+			span.RecordError(errAbs)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to resolve input image %s: %v", uri, errAbs)), nil
+		}
+		framePath := filepath.Join(framesDir, fmt.Sprintf("%s%04d%s", imagesToGIFFramePrefix, i+1, imageExt))
+		if errLink := os.Symlink(absPath, framePath); errLink != nil {
+			span.RecordError(errLink)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to stage input image %s: %v", uri, errLink)), nil
+		}
+		if i == 0 {
+			firstImage = localPath
+		}
+	}
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, firstImage)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe the first image: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read the size of the first image: %v", err)), nil
+	}
+	width := max(1, int(math.Round(float64(geometry.Width)*scaleFactorParam)))
+	height := max(1, int(math.Round(float64(geometry.Height)*scaleFactorParam)))
+	span.SetAttributes(attribute.Int("width", width), attribute.Int("height", height))
+
+	inputArgs := imageSequenceInputArgs(filepath.Join(framesDir, imagesToGIFFramePrefix+"%04d"+imageExt), fpsParam)
+	frameFilter := imageSequenceFrameFilter(width, height)
+	palettePath := filepath.Join(gifProcessingTempDir, "palette.png")
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildGIFPaletteArgs(inputArgs, frameFilter, palettePath)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg palette generation failed: %v", ffmpegErr)), nil
+	}
+
+	var finalGifFilename string
+	if strings.TrimSpace(outputFileName) == "" {
+		uid, _ := shortid.Generate()
+		finalGifFilename = fmt.Sprintf("ffmpeg_gif_%s.gif", uid)
+	} else {
+		finalGifFilename = outputFileName
+		if !strings.HasSuffix(strings.ToLower(finalGifFilename), ".gif") {
+			finalGifFilename += ".gif"
+		}
+	}
+	tempGifOutputPath := filepath.Join(gifProcessingTempDir, finalGifFilename)
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildGIFArgs(inputArgs, frameFilter, palettePath, paletteUse, tempGifOutputPath)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg GIF creation failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempGifOutputPath, finalGifFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process generated GIF: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("GIF of %d images (%dx%d at %g fps, dither %s) created in %v.", len(imageURIs), width, height, fpsParam, dither, duration.Round(time.Millisecond)))
+	if finalLocalPath != "" {
+		if outputLocalDir != "" {
+			messageParts = append(messageParts, fmt.Sprintf("Output GIF saved locally to: %s.", finalLocalPath))
+		} else if !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+			messageParts = append(messageParts, fmt.Sprintf("Temporary GIF output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+		}
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output GIF uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
 // addCombineAudioVideoTool defines and registers the 'ffmpeg_combine_audio_and_video' tool.
 // This tool merges a video stream from one file and an audio stream from another into a single video file.
 func addCombineAudioVideoTool(s *server.MCPServer, cfg *common.Config) {
@@ -349,9 +760,16 @@ func addCombineAudioVideoTool(s *server.MCPServer, cfg *common.Config) {
 		mcp.WithDescription("Combines separate audio and video files into a single video file."),
 		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
 		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithNumber("audio_stream_index", mcp.Description("Optional. Stream index (as reported by ffmpeg_get_media_info) of the audio stream to take from input_audio_uri, e.g. one language track of a multi-track file. If omitted, all of its audio streams are used.")),
 		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'combined.mp4').")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegCombineAudioVideoHandler(ctx, request, cfg)
@@ -377,43 +795,73 @@ func ffmpegCombineAudioVideoHandler(ctx context.Context, request mcp.CallToolReq
 	inputVideoURI, _ := argsMap["input_video_uri"].(string)
 	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
 	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
 	outputLocalDir, _ := argsMap["output_local_dir"].(string)
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
 
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_combine_audio_and_video: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_combine_audio_and_video: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
-	if inputVideoURI == "" || inputAudioURI == "" {
-		return mcp.NewToolResultError("Parameters 'input_video_uri' and 'input_audio_uri' are required."), nil
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	if inputVideoURI == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	if inputAudioURI == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	audioStreamIndex := -1
+	if indexArg, ok := argsMap["audio_stream_index"]; ok {
+		index, isNumber := indexArg.(float64)
+		if !isNumber || index < 0 || index != math.Trunc(index) {
+			return invalidParamResult("audio_stream_index", "must be a non-negative integer, got %v", indexArg), nil
+		}
+		audioStreamIndex = int(index)
 	}
 
 	span.SetAttributes(
 		attribute.String("input_video_uri", inputVideoURI),
 		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.Int("audio_stream_index", audioStreamIndex),
 		attribute.String("output_file_name", outputFileName),
 		attribute.String("output_local_dir", outputLocalDir),
 		attribute.String("output_gcs_bucket", outputGCSBucket),
 	)
 
-	localInputVideo, videoCleanup, err := common.PrepareInputFile(ctx, inputVideoURI, "input_video", cfg.ProjectID)
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
 	}
 	defer videoCleanup()
 
-	localInputAudio, audioCleanup, err := common.PrepareInputFile(ctx, inputAudioURI, "input_audio", cfg.ProjectID)
+	localInputAudio, audioCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
 	}
 	defer audioCleanup()
 
+	if audioStreamIndex >= 0 {
+		mediaInfoJSON, probeErr := executeGetMediaInfo(ctx, localInputAudio)
+		if probeErr != nil {
+			span.RecordError(probeErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read the streams of the input audio: %v", probeErr)), nil
+		}
+		if err := checkStreamSelection(mediaInfoJSON, streamSelection{Video: -1, Audio: audioStreamIndex}); err != nil {
+			return invalidParamResult("audio_stream_index", "%v", err), nil
+		}
+	}
+
 	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
 	if err != nil {
 		span.RecordError(err)
@@ -421,17 +869,22 @@ func ffmpegCombineAudioVideoHandler(ctx context.Context, request mcp.CallToolReq
 	}
 	defer outputCleanup()
 
-	_, ffmpegErr := runFFmpegCommand(ctx, "-y", "-i", localInputVideo, "-i", localInputAudio, "-map", "0", "-map", "1:a", "-c:v", "copy", "-shortest", tempOutputFile)
+	_, ffmpegErr := runFFmpegCommand(ctx, buildCombineAudioVideoArgs(localInputVideo, localInputAudio, tempOutputFile, audioStreamIndex)...)
 	if ffmpegErr != nil {
 		span.RecordError(ffmpegErr)
 		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg combine audio/video failed: %v", ffmpegErr)), nil
 	}
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
@@ -446,9 +899,15 @@ func ffmpegCombineAudioVideoHandler(ctx context.Context, request mcp.CallToolReq
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
 	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
 	if len(messageParts) == 1 {
 		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
 	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
 	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
 }
 
@@ -456,14 +915,21 @@ func ffmpegCombineAudioVideoHandler(ctx context.Context, request mcp.CallToolReq
 // This tool places an image on top of a video at specified coordinates.
 func addOverlayImageOnVideoTool(s *server.MCPServer, cfg *common.Config) {
 	tool := mcp.NewTool("ffmpeg_overlay_image_on_video",
-		mcp.WithDescription("Overlays an image onto a video at specified coordinates."),
+		mcp.WithDescription("Overlays an image onto a video at specified coordinates. The overlay may also be a video, such as a lower-third; its transparency is detected and blended, for PNGs with alpha, VP9 WebM with alpha and ProRes 4444."),
 		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
-		mcp.WithString("input_image_uri", mcp.Required(), mcp.Description("URI of the input image file (local path or gs://).")),
+		mcp.WithString("input_image_uri", mcp.Required(), mcp.Description("URI of the overlay image or video file (local path, gs://, or a data:image/png;base64,... URI of up to 2 MB for small images such as a logo generated in memory).")),
 		mcp.WithNumber("x_coordinate", mcp.DefaultNumber(0), mcp.Description("X coordinate for the overlay (top-left).")),
 		mcp.WithNumber("y_coordinate", mcp.DefaultNumber(0), mcp.Description("Y coordinate for the overlay (top-left).")),
-		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'overlayed_video.mp4').")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'overlayed_video.mp4', or 'lower_third.webm' with output_preserve_alpha).")),
+		mcp.WithBoolean("output_preserve_alpha", mcp.Description("Optional. If true, the output keeps an alpha channel, for when the composite itself must stay transparent: it is written as VP9 with alpha in a WebM (output_file_name must end in .webm) instead of an H.264 MP4. Cannot be combined with platform.")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegOverlayImageHandler(ctx, request, cfg)
@@ -483,7 +949,7 @@ func ffmpegOverlayImageHandler(ctx context.Context, request mcp.CallToolRequest,
 		span.RecordError(err)
 		return mcp.NewToolResultError(err.Error()), nil
 	}
-	log.Printf("Handling %s request with arguments: %v", "ffmpeg_overlay_image_on_video", argsMap)
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_overlay_image_on_video", redactDataURIArguments(argsMap))
 
 	inputVideoURI, _ := argsMap["input_video_uri"].(string)
 	inputImageURI, _ := argsMap["input_image_uri"].(string)
@@ -492,70 +958,114 @@ func ffmpegOverlayImageHandler(ctx context.Context, request mcp.CallToolRequest,
 	xCoord := int(xCoordFloat)
 	yCoord := int(yCoordFloat)
 	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
 	outputLocalDir, _ := argsMap["output_local_dir"].(string)
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
 
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_overlay_image_on_video: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_overlay_image_on_video: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
-	if inputVideoURI == "" || inputImageURI == "" {
-		return mcp.NewToolResultError("Parameters 'input_video_uri' and 'input_image_uri' are required."), nil
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	if inputVideoURI == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	if inputImageURI == "" {
+		return invalidParamResult("input_image_uri", reasonRequired), nil
+	}
+	preserveAlpha, _ := argsMap["output_preserve_alpha"].(bool)
+	if preserveAlpha {
+		if platform != nil {
+			return invalidParamResult("output_preserve_alpha", "cannot be combined with platform, whose presets write H.264 MP4 without alpha"), nil
+		}
+		if err := checkAlphaOutputFileName(outputFileName); err != nil {
+			return invalidParamResult("output_file_name", "%v", err), nil
+		}
+		if ffmpegEncoders != nil && !ffmpegEncoders[alphaVideoEncoder] {
+			return mcp.NewToolResultError(fmt.Sprintf("output_preserve_alpha needs FFmpeg's %s encoder, which this FFmpeg build does not have.", alphaVideoEncoder)), nil
+		}
 	}
 
 	span.SetAttributes(
 		attribute.String("input_video_uri", inputVideoURI),
-		attribute.String("input_image_uri", inputImageURI),
+		attribute.String("input_image_uri", common.RedactDataURI(inputImageURI)),
 		attribute.Int("x_coordinate", xCoord),
 		attribute.Int("y_coordinate", yCoord),
 		attribute.String("output_file_name", outputFileName),
 		attribute.String("output_local_dir", outputLocalDir),
 		attribute.String("output_gcs_bucket", outputGCSBucket),
+		attribute.Bool("output_preserve_alpha", preserveAlpha),
 	)
 
-	localInputVideo, videoCleanup, err := common.PrepareInputFile(ctx, inputVideoURI, "input_video", cfg.ProjectID)
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
 	}
 	defer videoCleanup()
 
-	localInputImage, imageCleanup, err := common.PrepareInputFile(ctx, inputImageURI, "input_image", cfg.ProjectID)
+	localInputImage, imageCleanup, err := prepareInputFile(ctx, inputImageURI, "input_image", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input image: %v", err)), nil
 	}
 	defer imageCleanup()
 
-	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	overlayAlpha := probeStreamAlpha(ctx, localInputImage, "overlay")
+	var videoAlpha streamAlpha
+	if preserveAlpha {
+		videoAlpha = probeStreamAlpha(ctx, localInputVideo, "video")
+	}
+	span.SetAttributes(attribute.Bool("overlay_has_alpha", overlayAlpha.HasAlpha))
+
+	outputExtension := "mp4"
+	if preserveAlpha {
+		outputExtension = "webm"
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, outputExtension)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
 	}
 	defer outputCleanup()
 
-	overlayFilter := fmt.Sprintf("[0:v][1:v]overlay=%d:%d", xCoord, yCoord)
-	_, ffmpegErr := runFFmpegCommand(ctx, "-y", "-i", localInputVideo, "-i", localInputImage, "-filter_complex", overlayFilter, tempOutputFile)
+	_, ffmpegErr := runFFmpegCommand(ctx, buildOverlayArgs(localInputVideo, localInputImage, tempOutputFile, xCoord, yCoord, videoAlpha, overlayAlpha, preserveAlpha)...)
 	if ffmpegErr != nil {
 		span.RecordError(ffmpegErr)
 		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg overlay image failed: %v", ffmpegErr)), nil
 	}
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
 
 	var messageParts []string
 	messageParts = append(messageParts, fmt.Sprintf("Image overlay on video completed in %v.", duration))
+	if overlayAlpha.HasAlpha {
+		messageParts = append(messageParts, "The overlay's alpha channel was used for blending.")
+	}
+	if preserveAlpha {
+		messageParts = append(messageParts, "The output is a VP9 WebM with an alpha channel.")
+	}
 	if outputLocalDir != "" && finalLocalPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
 	} else if finalLocalPath != "" {
@@ -564,22 +1074,64 @@ func ffmpegOverlayImageHandler(ctx context.Context, request mcp.CallToolRequest,
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
 	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
 	if len(messageParts) == 1 {
 		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
 	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
 	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
 }
 
+const (
+	minTargetSampleRate = 8000
+	maxTargetSampleRate = 192000
+	maxTargetChannels   = 8
+
+	// Integrated loudness targets accepted by loudnorm; -16 LUFS suits streaming and web playback.
+	defaultTargetLUFS = -16
+	minTargetLUFS     = -70
+	maxTargetLUFS     = -5
+)
+
 // addConcatenateMediaTool defines and registers the 'ffmpeg_concatenate_media_files' tool.
 // This tool is capable of joining multiple media files into a single file.
 // It has special handling for WAV files to ensure compatibility.
 func addConcatenateMediaTool(s *server.MCPServer, cfg *common.Config) {
 	tool := mcp.NewTool("ffmpeg_concatenate_media_files",
-		mcp.WithDescription("Concatenates multiple media files. If output is WAV, inputs must be PCM WAV; otherwise, inputs are standardized to MP4/AAC before concatenation."),
-		mcp.WithArray("input_media_uris", mcp.Required(), mcp.Description("Array of URIs for the input media files (local paths or gs://)."), mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithDescription("Concatenates multiple media files. If output is WAV, inputs must be PCM WAV in the same format, unless 'auto_resample' converts them; otherwise, inputs are standardized to MP4/AAC before concatenation."),
+		mcp.WithArray("input_media_uris", mcp.Description("Array of URIs for the input media files (local paths or gs://). Either this or 'input_list_uri' is required. An item can also be an object {\"uri\": ..., \"video_stream\": n, \"audio_stream\": n} that picks streams of a multi-track input by the index reported by ffmpeg_get_media_info; streams apply when inputs are standardized, not to WAV output."),
+			mcp.Items(map[string]any{"anyOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"uri":          map[string]any{"type": "string", "description": "URI of the input media file (local path or gs://)."},
+						"video_stream": map[string]any{"type": "integer", "description": "Index of the video stream to use."},
+						"audio_stream": map[string]any{"type": "integer", "description": "Index of the audio stream to use."},
+					},
+					"required": []string{"uri"},
+				},
+			}})),
+		mcp.WithString("input_list_uri", mcp.Description("Optional. Text file (local path or gs://) listing the input media URIs, one per line, for concatenations too large to pass as an array. Blank lines and lines starting with '#' are ignored. Cannot be combined with 'input_media_uris'.")),
+		mcp.WithBoolean("force_cfr", mcp.Description("Optional. Override variable-frame-rate detection: true converts every video input to a constant frame rate with audio sync compensation, false never does. By default only inputs detected as VFR are converted.")),
+		mcp.WithBoolean("auto_resample", mcp.Description("Optional. For WAV output: convert inputs that differ in sample rate, channel count or sample format to a common PCM format with FFmpeg's soxr resampler before joining them, instead of rejecting the request. The common format is the highest sample rate, channel count and bit depth among the inputs, unless 'target_sample_rate' or 'target_channels' is set.")),
+		mcp.WithNumber("target_sample_rate", mcp.Description(fmt.Sprintf("Optional. Sample rate in Hz (%d-%d) that 'auto_resample' converts every input to.", minTargetSampleRate, maxTargetSampleRate))),
+		mcp.WithNumber("target_channels", mcp.Description(fmt.Sprintf("Optional. Channel count (1-%d) that 'auto_resample' converts every input to.", maxTargetChannels))),
+		mcp.WithBoolean("normalize_loudness", mcp.Description("Optional. Run FFmpeg's loudnorm filter on each input while it is standardized, so clips from different sources join at a consistent loudness. Not available for WAV output, whose inputs are joined without re-encoding.")),
+		mcp.WithNumber("target_lufs", mcp.Description(fmt.Sprintf("Optional. Integrated loudness in LUFS (%d to %d) that 'normalize_loudness' brings every input to. Defaults to %d.", minTargetLUFS, maxTargetLUFS, defaultTargetLUFS))),
 		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output file (e.g., 'concatenated.mp4'). Extension determines behavior for audio concatenation.")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output file to.")),
+		withReproducibleParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegConcatenateMediaHandler(ctx, request, cfg)
@@ -590,6 +1142,11 @@ func addConcatenateMediaTool(s *server.MCPServer, cfg *common.Config) {
 // It handles two primary cases: direct concatenation of compatible PCM WAV files, and
 // a more general case where inputs are first standardized to a common format (MP4/AAC)
 // before being concatenated. This ensures a reliable join for a variety of input formats.
+// Variable-frame-rate video inputs are converted to a constant frame rate during
+// standardization so their audio stays in sync; 'force_cfr' overrides the detection.
+// With 'auto_resample', WAV inputs in different formats are first converted to a common
+// PCM format in the processing temp dir, so they can still be joined without re-encoding.
+// With 'normalize_loudness', each input is loudness-normalized as it is standardized.
 func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
 	tr := otel.Tracer(serviceName)
 	ctx, span := tr.Start(ctx, "ffmpeg_concatenate_media_files")
@@ -604,11 +1161,30 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 	log.Printf("Handling %s request with arguments: %v", "ffmpeg_concatenate_media_files", argsMap)
 
 	inputMediaURIsRaw, _ := argsMap["input_media_uris"].([]interface{})
+	concatInputs, err := parseConcatInputs(inputMediaURIsRaw)
+	if err != nil {
+		return invalidParamResult("input_media_uris", "%v", err), nil
+	}
 	var inputMediaURIs []string
-	for _, item := range inputMediaURIsRaw {
-		if strItem, ok := item.(string); ok {
-			inputMediaURIs = append(inputMediaURIs, strItem)
+	for _, input := range concatInputs {
+		inputMediaURIs = append(inputMediaURIs, input.URI)
+	}
+	inputListURI, _ := argsMap["input_list_uri"].(string)
+	inputListURI = strings.TrimSpace(inputListURI)
+	if inputListURI != "" {
+		if len(inputMediaURIs) > 0 {
+			return invalidParamResult("input_list_uri", "cannot be combined with input_media_uris"), nil
 		}
+		inputMediaURIs, err = readConcatInputList(ctx, inputListURI, cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return invalidParamResult("input_list_uri", "%v", err), nil
+		}
+		concatInputs = nil
+		for _, uri := range inputMediaURIs {
+			concatInputs = append(concatInputs, concatInput{URI: uri, Streams: defaultStreams})
+		}
+		log.Printf("Read %d input URIs from %s", len(inputMediaURIs), inputListURI)
 	}
 
 	outputFileName, _ := argsMap["output_file_name"].(string)
@@ -616,16 +1192,60 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
 
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_concatenate_media_files: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(concatOutputCategory(inputMediaURIs, outputFileName)); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_concatenate_media_files: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	forceCFR, forceCFRSet := argsMap["force_cfr"].(bool)
+	reproducible, _ := argsMap["reproducible"].(bool)
+	autoResample, _ := argsMap["auto_resample"].(bool)
+	targetSampleRate, targetChannels := 0, 0
+	if rateParam, ok := argsMap["target_sample_rate"]; ok {
+		rate, ok := rateParam.(float64)
+		if !ok || rate != math.Trunc(rate) || rate < minTargetSampleRate || rate > maxTargetSampleRate {
+			return invalidParamResult("target_sample_rate", "must be a whole number of Hz from %d to %d, got %v", minTargetSampleRate, maxTargetSampleRate, rateParam), nil
+		}
+		targetSampleRate = int(rate)
+	}
+	if channelsParam, ok := argsMap["target_channels"]; ok {
+		channels, ok := channelsParam.(float64)
+		if !ok || channels != math.Trunc(channels) || channels < 1 || channels > maxTargetChannels {
+			return invalidParamResult("target_channels", "must be a whole number from 1 to %d, got %v", maxTargetChannels, channelsParam), nil
+		}
+		targetChannels = int(channels)
+	}
+	if (targetSampleRate > 0 || targetChannels > 0) && !autoResample {
+		field := "target_sample_rate"
+		if targetSampleRate == 0 {
+			field = "target_channels"
+		}
+		return invalidParamResult(field, "requires 'auto_resample': true"), nil
+	}
+	normalizeLoudness, _ := argsMap["normalize_loudness"].(bool)
+	targetLUFS := 0.0
+	if normalizeLoudness {
+		targetLUFS = defaultTargetLUFS
+	}
+	if lufsParam, ok := argsMap["target_lufs"]; ok {
+		lufs, ok := lufsParam.(float64)
+		if !ok || lufs < minTargetLUFS || lufs > maxTargetLUFS {
+			return invalidParamResult("target_lufs", "must be a loudness from %d to %d LUFS, got %v", minTargetLUFS, maxTargetLUFS, lufsParam), nil
+		}
+		if !normalizeLoudness {
+			return invalidParamResult("target_lufs", "requires 'normalize_loudness': true"), nil
+		}
+		targetLUFS = lufs
+	}
+	var vfrNotes, resampleNotes []string
 	if len(inputMediaURIs) < 1 {
 		if len(inputMediaURIs) == 0 {
-			return mcp.NewToolResultError("At least one media file is required for concatenation."), nil
+			return invalidParamResult("input_media_uris", "at least one media file is required for concatenation, given here or in input_list_uri"), nil
 		}
 		log.Println("Warning: Only one input file provided for concatenation. Will process it as a single file operation.")
 	}
@@ -635,9 +1255,13 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 
 	span.SetAttributes(
 		attribute.StringSlice("input_media_uris", inputMediaURIs),
+		attribute.String("input_list_uri", inputListURI),
 		attribute.String("output_file_name", outputFileName),
 		attribute.String("output_local_dir", outputLocalDir),
 		attribute.String("output_gcs_bucket", outputGCSBucket),
+		attribute.Bool("reproducible", reproducible),
+		attribute.Bool("auto_resample", autoResample),
+		attribute.Float64("target_lufs", targetLUFS),
 	)
 
 	var localInputFilePaths []string
@@ -649,7 +1273,7 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 	}()
 
 	for i, uri := range inputMediaURIs {
-		localPath, cleanup, errPrep := common.PrepareInputFile(ctx, uri, fmt.Sprintf("concat_input_%d", i), cfg.ProjectID)
+		localPath, cleanup, errPrep := prepareInputFile(ctx, uri, fmt.Sprintf("concat_input_%d", i), cfg.ProjectID)
 		if errPrep != nil {
 			span.RecordError(errPrep)
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input media file %s: %v", uri, errPrep)), nil
@@ -658,10 +1282,25 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 		localInputFilePaths = append(localInputFilePaths, localPath)
 	}
 
+	// Selected streams are checked before any input is processed, so a wrong index fails fast.
+	for i, input := range concatInputs {
+		if !input.Streams.explicit() {
+			continue
+		}
+		mediaInfoJSON, probeErr := executeGetMediaInfo(ctx, localInputFilePaths[i])
+		if probeErr != nil {
+			span.RecordError(probeErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read the streams of input %d (%s): %v", i+1, input.URI, probeErr)), nil
+		}
+		if err := checkStreamSelection(mediaInfoJSON, input.Streams); err != nil {
+			return invalidParamResult("input_media_uris", "input %d (%s): %v", i+1, input.URI, err), nil
+		}
+	}
+
 	defaultOutputExt := "mp4"
 	if len(localInputFilePaths) > 0 {
 		firstExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputFilePaths[0]), "."))
-		if firstExt == "wav" || firstExt == "mp3" || firstExt == "aac" || firstExt == "m4a" {
+		if concatAudioExtensions[firstExt] {
 			defaultOutputExt = firstExt
 		}
 	}
@@ -682,147 +1321,95 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 	isOutputWav := strings.ToLower(defaultOutputExt) == "wav"
 
 	if isOutputWav {
-		log.Println("Output is WAV. Checking if all inputs are compatible PCM WAV for direct concatenation.")
-		allInputsAreCompatiblePcmWav := true
-		var firstPcmInfo struct {
-			SampleFmt   string
-			SampleRate  string
-			Channels    int
-			CodecName   string
-			Initialized bool
+		for i, input := range concatInputs {
+			if input.Streams.explicit() {
+				return invalidParamResult("input_media_uris", "input %d (%s): video_stream and audio_stream apply only when inputs are standardized, not to WAV output", i+1, input.URI), nil
+			}
 		}
-		var actualPcmInputPaths []string
-
-		if len(localInputFilePaths) == 0 {
-			allInputsAreCompatiblePcmWav = false
+		if normalizeLoudness {
+			return invalidParamResult("normalize_loudness", "applies only when inputs are standardized, not to WAV output"), nil
 		}
-
+		log.Println("Output is WAV. Checking if all inputs are compatible PCM WAV for direct concatenation.")
+		var formats []audioFormat
+		var probeErr error
 		for i, path := range localInputFilePaths {
 			log.Printf("Checking codec and properties for input %d: %s", i+1, path)
 			mediaInfoJSON, ffprobeErr := executeGetMediaInfo(ctx, path)
 			if ffprobeErr != nil {
-				allInputsAreCompatiblePcmWav = false
-				log.Printf("Failed to get media info for input %s: %v. Cannot ensure PCM WAV compatibility.", path, ffprobeErr)
+				probeErr = fmt.Errorf("input %d (%s): %w", i+1, inputMediaURIs[i], ffprobeErr)
 				break
 			}
-
-			var info struct {
-				Streams []struct {
-					CodecType  string `json:"codec_type"`
-					CodecName  string `json:"codec_name"`
-					SampleFmt  string `json:"sample_fmt"`
-					SampleRate string `json:"sample_rate"`
-					Channels   int    `json:"channels"`
-				} `json:"streams"`
-			}
-			if err := json.Unmarshal([]byte(mediaInfoJSON), &info); err != nil {
-				allInputsAreCompatiblePcmWav = false
-				log.Printf("Failed to parse media info for input %s: %v. Cannot ensure PCM WAV compatibility.", path, err)
+			format, parseErr := parseAudioFormat(mediaInfoJSON)
+			if parseErr != nil {
+				probeErr = fmt.Errorf("input %d (%s): %w", i+1, inputMediaURIs[i], parseErr)
 				break
 			}
+			log.Printf("Audio stream found for %s: %s", path, format)
+			formats = append(formats, format)
+		}
+		if probeErr != nil {
+			log.Printf("Cannot ensure PCM WAV compatibility: %v", probeErr)
+			span.RecordError(probeErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Error: could not read the audio format of every input for WAV concatenation: %v", probeErr)), nil
+		}
 
-			isCurrentFilePcm := false
-			var currentStreamInfo struct {
-				SampleFmt  string
-				SampleRate string
-				Channels   int
-				CodecName  string
-			}
-			audioStreamFound := false
-
-			for _, stream := range info.Streams {
-				if stream.CodecType == "audio" {
-					audioStreamFound = true
-					log.Printf("Audio stream found for %s: codec_name='%s', sample_fmt='%s', sample_rate='%s', channels=%d",
-						path, stream.CodecName, stream.SampleFmt, stream.SampleRate, stream.Channels)
-					if strings.HasPrefix(stream.CodecName, "pcm_") {
-						isCurrentFilePcm = true
-						currentStreamInfo.SampleFmt = stream.SampleFmt
-						currentStreamInfo.SampleRate = stream.SampleRate
-						currentStreamInfo.Channels = stream.Channels
-						currentStreamInfo.CodecName = stream.CodecName
-					} else {
-						isCurrentFilePcm = false
-					}
-					break
-				}
-			}
-
-			if !audioStreamFound {
-				allInputsAreCompatiblePcmWav = false
-				log.Printf("No audio stream found in input %s. Cannot treat as compatible PCM WAV.", path)
-				break
-			}
-			if !isCurrentFilePcm {
-				allInputsAreCompatiblePcmWav = false
-				log.Printf("Input file %s is not PCM WAV (audio codec: %s).", path, currentStreamInfo.CodecName)
-				break
-			}
+		concatListTempDir, errListTempDir := os.MkdirTemp("", "concat_list_pcm_")
+		if errListTempDir != nil {
+			span.RecordError(errListTempDir)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to create temp dir for PCM concat list: %v", errListTempDir)), nil
+		}
+		defer func() {
+			log.Printf("Cleaning up PCM concat list temporary directory: %s", concatListTempDir)
+			os.RemoveAll(concatListTempDir)
+		}()
 
-			if !firstPcmInfo.Initialized {
-				firstPcmInfo.SampleFmt = currentStreamInfo.SampleFmt
-				firstPcmInfo.SampleRate = currentStreamInfo.SampleRate
-				firstPcmInfo.Channels = currentStreamInfo.Channels
-				firstPcmInfo.CodecName = currentStreamInfo.CodecName
-				firstPcmInfo.Initialized = true
-				log.Printf("First PCM WAV input %s (%s) sets standard: SR=%s, Fmt=%s, Ch=%d",
-					path, firstPcmInfo.CodecName, firstPcmInfo.SampleRate, firstPcmInfo.SampleFmt, firstPcmInfo.Channels)
-			} else {
-				if currentStreamInfo.SampleRate != firstPcmInfo.SampleRate ||
-					currentStreamInfo.Channels != firstPcmInfo.Channels ||
-					currentStreamInfo.SampleFmt != firstPcmInfo.SampleFmt {
-					allInputsAreCompatiblePcmWav = false
-					log.Printf("Input PCM WAV file %s (%s, SR=%s, Fmt=%s, Ch=%d) is incompatible with the first PCM WAV file (%s, SR=%s, Fmt=%s, Ch=%d).",
-						path, currentStreamInfo.CodecName, currentStreamInfo.SampleRate, currentStreamInfo.SampleFmt, currentStreamInfo.Channels,
-						firstPcmInfo.CodecName, firstPcmInfo.SampleRate, firstPcmInfo.SampleFmt, firstPcmInfo.Channels)
-					break
+		pcmInputPaths := localInputFilePaths
+		if autoResample {
+			target := wavResampleTarget(formats, targetSampleRate, targetChannels)
+			span.SetAttributes(attribute.String("resample_target", target.String()))
+			pcmInputPaths = make([]string, len(localInputFilePaths))
+			for i, path := range localInputFilePaths {
+				if formats[i] == target {
+					pcmInputPaths[i] = path
+					continue
 				}
-				log.Printf("Input PCM WAV file %s is compatible with the first.", path)
+				resampledPath := filepath.Join(concatListTempDir, fmt.Sprintf("resampled_%d.wav", i))
+				log.Printf("Resampling input %d ('%s') from %s to %s: '%s'", i+1, path, formats[i], target, resampledPath)
+				if _, resampleErr := runFFmpegCommand(ctx, buildWAVResampleArgs(path, resampledPath, target)...); resampleErr != nil {
+					span.RecordError(resampleErr)
+					return mcp.NewToolResultError(fmt.Sprintf("Failed to resample input %d (%s) to %s: %v", i+1, inputMediaURIs[i], target, resampleErr)), nil
+				}
+				pcmInputPaths[i] = resampledPath
+				formats[i] = target
+				resampleNotes = append(resampleNotes, fmt.Sprintf("Input %d (%s): resampled to %s.", i+1, inputMediaURIs[i], target))
 			}
-			actualPcmInputPaths = append(actualPcmInputPaths, path)
 		}
 
-		if allInputsAreCompatiblePcmWav && firstPcmInfo.Initialized {
-			log.Println("All inputs are compatible PCM WAV. Proceeding with direct PCM concatenation.")
-
-			concatListTempDir, errListTempDir := os.MkdirTemp("", "concat_list_pcm_")
-			if errListTempDir != nil {
-				span.RecordError(errListTempDir)
-				return mcp.NewToolResultError(fmt.Sprintf("Failed to create temp dir for PCM concat list: %v", errListTempDir)), nil
-			}
-			defer func() {
-				log.Printf("Cleaning up PCM concat list temporary directory: %s", concatListTempDir)
-				os.RemoveAll(concatListTempDir)
-			}()
-
-			concatListPath := filepath.Join(concatListTempDir, "concat_list_pcm.txt")
-			var fileListContent strings.Builder
-			for _, pcmPath := range actualPcmInputPaths {
-				absPath, absErr := filepath.Abs(pcmPath)
-				if absErr != nil {
-					span.RecordError(absErr)
-					return mcp.NewToolResultError(fmt.Sprintf("Failed to get absolute path for PCM file %s: %v", pcmPath, absErr)), nil
-				}
-				fileListContent.WriteString(fmt.Sprintf("file '%s'\n", absPath))
-			}
-			if errWriteList := os.WriteFile(concatListPath, []byte(fileListContent.String()), 0644); errWriteList != nil {
-				span.RecordError(errWriteList)
-				return mcp.NewToolResultError(fmt.Sprintf("Failed to write PCM concat list file: %v", errWriteList)), nil
-			}
+		if !pcmConcatCompatible(formats) {
+			log.Println("Output is WAV, but not all inputs are compatible PCM WAV. Rejecting operation.")
+			return mcp.NewToolResultError("Error: When outputting to WAV, all input files must be PCM WAV with identical characteristics (sample rate, sample format, and channel count). Set 'auto_resample' to true to convert them to a common format, convert inputs to a common PCM WAV format yourself, or choose a different output format (e.g., M4A, MP4)."), nil
+		}
+		log.Println("All inputs are compatible PCM WAV. Proceeding with direct PCM concatenation.")
 
-			concatCmdArgs := []string{"-y", "-f", "concat", "-safe", "0", "-i", concatListPath, "-c", "copy", tempOutputFile}
-			log.Printf("Attempting direct PCM concatenation of WAV files using concat demuxer (-c copy).")
-			_, ffmpegErr := runFFmpegCommand(ctx, concatCmdArgs...)
-			if ffmpegErr != nil {
-				span.RecordError(ffmpegErr)
-				return mcp.NewToolResultError(fmt.Sprintf("FFMpeg direct PCM WAV concatenation failed: %v. Ensure input WAVs have compatible PCM formats (sample rate, channels, bit depth).", ffmpegErr)), nil
-			}
-			log.Println("Direct PCM WAV concatenation successful.")
+		concatListPath := filepath.Join(concatListTempDir, "concat_list_pcm.txt")
+		fileList, errList := buildConcatList(pcmInputPaths)
+		if errList != nil {
+			span.RecordError(errList)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to build PCM concat list: %v", errList)), nil
+		}
+		if errWriteList := os.WriteFile(concatListPath, []byte(fileList), 0644); errWriteList != nil {
+			span.RecordError(errWriteList)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to write PCM concat list file: %v", errWriteList)), nil
+		}
 
-		} else {
-			log.Println("Output is WAV, but not all inputs are compatible PCM WAV, or an error occurred checking. Rejecting operation.")
-			return mcp.NewToolResultError("Error: When outputting to WAV, all input files must be PCM WAV with identical characteristics (sample rate, sample format, and channel count). Please convert inputs to a common PCM WAV format or choose a different output format (e.g., M4A, MP4)."), nil
+		concatCmdArgs := withReproducibleOutput([]string{"-y", "-f", "concat", "-safe", "0", "-i", concatListPath, "-c", "copy", tempOutputFile}, reproducible)
+		log.Printf("Attempting direct PCM concatenation of WAV files using concat demuxer (-c copy).")
+		_, ffmpegErr := runFFmpegCommand(ctx, concatCmdArgs...)
+		if ffmpegErr != nil {
+			span.RecordError(ffmpegErr)
+			return mcp.NewToolResultError(fmt.Sprintf("FFMpeg direct PCM WAV concatenation failed: %v. Ensure input WAVs have compatible PCM formats (sample rate, channels, bit depth).", ffmpegErr)), nil
 		}
+		log.Println("Direct PCM WAV concatenation successful.")
 
 	} else {
 		log.Println("Output is not WAV. Proceeding with standardization to MP4/AAC before concatenation.")
@@ -837,49 +1424,43 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 			os.RemoveAll(standardizationTempDir)
 		}()
 
-		commonWidth := 1280
-		commonHeight := 720
-		commonFPS := "24"
-		commonSampleRate := "48000"
-		commonChannels := "2"
-
 		for i, localInputFile := range localInputFilePaths {
 			baseName := filepath.Base(localInputFile)
 			ext := filepath.Ext(baseName)
 			standardizedOutputName := fmt.Sprintf("standardized_%d_%s.mp4", i, strings.TrimSuffix(baseName, ext))
 			standardizedOutputPath := filepath.Join(standardizationTempDir, standardizedOutputName)
 
-			mediaInfoJSON, ffprobeErr := executeGetMediaInfo(ctx, localInputFile)
-			isAudioOnly := false
-			if ffprobeErr == nil {
-				var info struct {
-					Streams []struct {
-						CodecType string `json:"codec_type"`
-					} `json:"streams"`
+			var timing videoTiming
+			if mediaInfoJSON, ffprobeErr := executeGetMediaInfo(ctx, localInputFile); ffprobeErr == nil {
+				if parsed, parseErr := parseVideoTiming(mediaInfoJSON); parseErr == nil {
+					timing = parsed
 				}
-				if json.Unmarshal([]byte(mediaInfoJSON), &info) == nil {
-					hasVideo := false
-					for _, s := range info.Streams {
-						if s.CodecType == "video" {
-							hasVideo = true
-							break
-						}
-					}
-					if !hasVideo && len(info.Streams) > 0 {
-						isAudioOnly = true
-					}
+			}
+			isAudioOnly := !timing.HasVideo && timing.StreamCount > 0
+			vfrDetected := timing.isVFR()
+			applyCFR := vfrDetected
+			if forceCFRSet {
+				applyCFR = forceCFR
+			}
+			if vfrDetected {
+				log.Printf("Input %d ('%s') looks variable frame rate (%s).", i+1, localInputFile, timing)
+				note := fmt.Sprintf("Input %d (%s): vfr_detected (%s);", i+1, inputMediaURIs[i], timing)
+				if applyCFR {
+					note += fmt.Sprintf(" converted to constant %s fps with audio sync compensation.", concatStandardFPS)
+				} else {
+					note += " left as-is because force_cfr is false."
 				}
+				vfrNotes = append(vfrNotes, note)
+			} else if applyCFR && !isAudioOnly {
+				vfrNotes = append(vfrNotes, fmt.Sprintf("Input %d (%s): constant frame rate forced by force_cfr.", i+1, inputMediaURIs[i]))
 			}
 
-			var standardizeCmdArgs []string
 			if isAudioOnly {
 				log.Printf("Standardizing audio-only input %d ('%s') to AAC in MP4 container: '%s'", i+1, localInputFile, standardizedOutputPath)
-				standardizeCmdArgs = []string{"-y", "-i", localInputFile, "-vn", "-c:a", "aac", "-ar", commonSampleRate, "-ac", commonChannels, "-b:a", "192k", standardizedOutputPath}
 			} else {
-				log.Printf("Standardizing video/mixed input %d ('%s') to H264/AAC in MP4 container: '%s'", i+1, localInputFile, standardizedOutputPath)
-				vfArgs := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:0:0,fps=%s", commonWidth, commonHeight, commonWidth, commonHeight, commonFPS)
-				standardizeCmdArgs = []string{"-y", "-i", localInputFile, "-vf", vfArgs, "-c:v", "libx264", "-preset", "medium", "-crf", "23", "-c:a", "aac", "-ar", commonSampleRate, "-ac", commonChannels, "-b:a", "192k", standardizedOutputPath}
+				log.Printf("Standardizing video/mixed input %d ('%s') to H264/AAC in MP4 container (cfr: %t): '%s'", i+1, localInputFile, applyCFR, standardizedOutputPath)
 			}
+			standardizeCmdArgs := withReproducibleOutput(buildConcatStandardizeArgs(localInputFile, standardizedOutputPath, isAudioOnly, applyCFR, concatInputs[i].Streams, targetLUFS), reproducible)
 
 			_, stdErr := runFFmpegCommand(ctx, standardizeCmdArgs...)
 			if stdErr != nil {
@@ -904,21 +1485,17 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 		}()
 
 		concatListPath := filepath.Join(concatListTempDir, "concat_list_std.txt")
-		var fileListContent strings.Builder
-		for _, sf := range standardizedFiles {
-			absPath, absErr := filepath.Abs(sf)
-			if absErr != nil {
-				span.RecordError(absErr)
-				return mcp.NewToolResultError(fmt.Sprintf("Failed to get absolute path for standardized file %s: %v", sf, absErr)), nil
-			}
-			fileListContent.WriteString(fmt.Sprintf("file '%s'\n", absPath))
+		fileList, errList := buildConcatList(standardizedFiles)
+		if errList != nil {
+			span.RecordError(errList)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to build standardized concat list: %v", errList)), nil
 		}
-		if errWriteList := os.WriteFile(concatListPath, []byte(fileListContent.String()), 0644); errWriteList != nil {
+		if errWriteList := os.WriteFile(concatListPath, []byte(fileList), 0644); errWriteList != nil {
 			span.RecordError(errWriteList)
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to write standardized concat list file: %v", errWriteList)), nil
 		}
 
-		concatDemuxerCmdArgs := []string{"-y", "-f", "concat", "-safe", "0", "-i", concatListPath, "-c", "copy", tempOutputFile}
+		concatDemuxerCmdArgs := withReproducibleOutput([]string{"-y", "-f", "concat", "-safe", "0", "-i", concatListPath, "-c", "copy", tempOutputFile}, reproducible)
 		log.Printf("Attempting concatenation of standardized files using concat demuxer (-c copy).")
 		_, ffmpegErr := runFFmpegCommand(ctx, concatDemuxerCmdArgs...)
 		if ffmpegErr != nil {
@@ -928,28 +1505,46 @@ func ffmpegConcatenateMediaHandler(ctx context.Context, request mcp.CallToolRequ
 		log.Println("Concatenation of standardized files successful.")
 	}
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	outputSHA256, err := reproducibleOutputSHA256(ctx, tempOutputFile, reproducible)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to hash output file: %v", err)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
 
 	var messageParts []string
 	messageParts = append(messageParts, fmt.Sprintf("Media concatenation completed in %v.", duration))
+	messageParts = append(messageParts, vfrNotes...)
+	messageParts = append(messageParts, resampleNotes...)
+	if normalizeLoudness {
+		messageParts = append(messageParts, fmt.Sprintf("Inputs loudness-normalized to %g LUFS.", targetLUFS))
+	}
+	noteCount := len(messageParts)
 	if outputLocalDir != "" && finalLocalPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
-	} else if finalLocalPath != "" && !(outputGCSBucket != "" && finalGCSPath != "") {
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
 		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
 	}
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
 	}
-	if len(messageParts) == 1 {
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == noteCount {
 		messageParts = append(messageParts, "No specific output location requested beyond temporary processing, or an issue occurred.")
 	}
+	if outputSHA256 != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Reproducible output SHA-256: %s.", outputSHA256))
+	}
 	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
 }
 
@@ -963,6 +1558,13 @@ func addAdjustVolumeTool(s *server.MCPServer, cfg *common.Config) {
 		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output audio file.")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output audio file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output audio file to.")),
+		mcp.WithBoolean("preserve_metadata", mcp.DefaultBool(false), mcp.Description("Optional. If true, the input's tags (title, artist, album and so on) are copied to the output and its original timestamps are kept. Defaults to false.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegAdjustVolumeHandler(ctx, request, cfg)
@@ -987,34 +1589,43 @@ func ffmpegAdjustVolumeHandler(ctx context.Context, request mcp.CallToolRequest,
 	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
 	volumeDBChangeFloat, paramOK := argsMap["volume_db_change"].(float64)
 	if !paramOK {
-		return mcp.NewToolResultError("Parameter 'volume_db_change' is required and must be a number."), nil
+		return invalidParamResult("volume_db_change", "a number is required"), nil
 	}
 	volumeDBChange := int(volumeDBChangeFloat)
+	preserveMetadata, _ := argsMap["preserve_metadata"].(bool)
 	outputFileName, _ := argsMap["output_file_name"].(string)
 	outputLocalDir, _ := argsMap["output_local_dir"].(string)
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
 
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_adjust_volume: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_adjust_volume: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
 	if inputAudioURI == "" {
-		return mcp.NewToolResultError("Parameter 'input_audio_uri' is required."), nil
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
 	}
 
 	span.SetAttributes(
 		attribute.String("input_audio_uri", inputAudioURI),
 		attribute.Int("volume_db_change", volumeDBChange),
+		attribute.Bool("preserve_metadata", preserveMetadata),
 		attribute.String("output_file_name", outputFileName),
 		attribute.String("output_local_dir", outputLocalDir),
 		attribute.String("output_gcs_bucket", outputGCSBucket),
 	)
 
-	localInputAudio, inputCleanup, err := common.PrepareInputFile(ctx, inputAudioURI, "input_audio_vol", cfg.ProjectID)
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_vol", cfg.ProjectID)
 	if err != nil {
 		span.RecordError(err)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
@@ -1043,18 +1654,19 @@ func ffmpegAdjustVolumeHandler(ctx context.Context, request mcp.CallToolRequest,
 	}
 	defer outputCleanup()
 
-	volumeFilter := fmt.Sprintf("volume=%ddB", volumeDBChange)
-	_, ffmpegErr := runFFmpegCommand(ctx, "-y", "-i", localInputAudio, "-af", volumeFilter, tempOutputFile)
+	volumeArgs := buildAdjustVolumeArgs(localInputAudio, tempOutputFile, volumeDBChange, wavOutputCodec(defaultOutputExt, sampleFormat), preserveMetadata)
+	_, ffmpegErr := runFFmpegCommand(ctx, volumeArgs...)
 	if ffmpegErr != nil {
 		span.RecordError(ffmpegErr)
 		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg adjust volume failed: %v", ffmpegErr)), nil
 	}
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
@@ -1063,12 +1675,15 @@ func ffmpegAdjustVolumeHandler(ctx context.Context, request mcp.CallToolRequest,
 	messageParts = append(messageParts, fmt.Sprintf("Volume adjustment (%ddB) completed in %v.", volumeDBChange, duration))
 	if outputLocalDir != "" && finalLocalPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
-	} else if finalLocalPath != "" && !(outputGCSBucket != "" && finalGCSPath != "") {
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
 		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
 	}
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
 	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
 	if len(messageParts) == 1 {
 		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
 	}
@@ -1084,6 +1699,12 @@ func addLayerAudioTool(s *server.MCPServer, cfg *common.Config) {
 		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output mixed audio file (e.g., 'layered_audio.mp3').")),
 		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output file.")),
 		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
 	)
 	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return ffmpegLayerAudioHandler(ctx, request, cfg)
@@ -1111,7 +1732,7 @@ func addLayerAudioTool(s *server.MCPServer, cfg *common.Config) {
 			args[k] = v
 		}
 		toolRequest := mcp.CallToolRequest{
-			Params:   mcp.CallToolParams{Arguments: args},
+			Params: mcp.CallToolParams{Arguments: args},
 		}
 		result, err := ffmpegVideoToGifHandler(ctx, toolRequest, cfg)
 		if err != nil {
@@ -1162,16 +1783,23 @@ func ffmpegLayerAudioHandler(ctx context.Context, request mcp.CallToolRequest, c
 	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
 	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
 
-	if outputGCSBucket == "" && cfg.GenmediaBucket != "" {
-		outputGCSBucket = cfg.GenmediaBucket
-		log.Printf("Handler ffmpeg_layer_audio_files: 'output_gcs_bucket' parameter not provided, using default from GENMEDIA_BUCKET: %s", outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_layer_audio_files: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
 	}
 	if outputGCSBucket != "" {
 		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
 	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
 	if len(inputAudioURIs) < 1 {
 		if len(inputAudioURIs) == 0 {
-			return mcp.NewToolResultError("At least one audio file is required for layering."), nil
+			return invalidParamResult("input_audio_uris", "at least one audio file is required for layering"), nil
 		}
 		log.Println("Warning: Only one input file provided for layering. The 'layering' will essentially be a copy or re-encode of this single file.")
 	}
@@ -1193,7 +1821,7 @@ func ffmpegLayerAudioHandler(ctx context.Context, request mcp.CallToolRequest, c
 
 	var ffmpegInputArgs []string
 	for i, uri := range inputAudioURIs {
-		localPath, cleanup, errPrep := common.PrepareInputFile(ctx, uri, fmt.Sprintf("layer_input_%d", i), cfg.ProjectID)
+		localPath, cleanup, errPrep := prepareInputFile(ctx, uri, fmt.Sprintf("layer_input_%d", i), cfg.ProjectID)
 		if errPrep != nil {
 			span.RecordError(errPrep)
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio file %s: %v", uri, errPrep)), nil
@@ -1228,9 +1856,16 @@ func ffmpegLayerAudioHandler(ctx context.Context, request mcp.CallToolRequest, c
 	commandArgs = append(commandArgs, "-y")
 	commandArgs = append(commandArgs, ffmpegInputArgs...)
 
+	wavCodec := wavOutputCodec(defaultOutputExt, sampleFormat)
 	if len(localInputFiles) > 1 {
 		amixFilter := fmt.Sprintf("amix=inputs=%d:duration=longest", len(localInputFiles))
-		commandArgs = append(commandArgs, "-filter_complex", amixFilter, tempOutputFile)
+		commandArgs = append(commandArgs, "-filter_complex", amixFilter)
+		if wavCodec != "" {
+			commandArgs = append(commandArgs, "-c:a", wavCodec)
+		}
+		commandArgs = append(commandArgs, tempOutputFile)
+	} else if len(localInputFiles) == 1 && sampleFormat != "" && wavCodec != "" {
+		commandArgs = append(commandArgs, "-c:a", wavCodec, tempOutputFile)
 	} else if len(localInputFiles) == 1 {
 		commandArgs = append(commandArgs, "-c:a", "copy", tempOutputFile)
 		log.Println("Layering with single input: attempting codec copy. FFMpeg may re-encode if necessary for container.")
@@ -1245,7 +1880,7 @@ func ffmpegLayerAudioHandler(ctx context.Context, request mcp.CallToolRequest, c
 			var reencodeArgs []string
 			reencodeArgs = append(reencodeArgs, "-y", "-i", localInputFiles[0])
 			if defaultOutputExt == "wav" {
-				reencodeArgs = append(reencodeArgs, "-c:a", "pcm_s16le", tempOutputFile)
+				reencodeArgs = append(reencodeArgs, "-c:a", wavCodec, tempOutputFile)
 			} else {
 				reencodeArgs = append(reencodeArgs, "-c:a", "aac", "-b:a", "192k", tempOutputFile)
 			}
@@ -1257,11 +1892,12 @@ func ffmpegLayerAudioHandler(ctx context.Context, request mcp.CallToolRequest, c
 		}
 	}
 
-	finalLocalPath, finalGCSPath, processErr := common.ProcessOutputAfterFFmpeg(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBucket, cfg.ProjectID)
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
 	if processErr != nil {
 		span.RecordError(processErr)
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
 	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
 
 	duration := time.Since(startTime)
 	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
@@ -1270,14 +1906,4123 @@ func ffmpegLayerAudioHandler(ctx context.Context, request mcp.CallToolRequest, c
 	messageParts = append(messageParts, fmt.Sprintf("Audio layering of %d files completed in %v.", len(localInputFiles), duration))
 	if outputLocalDir != "" && finalLocalPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
-	} else if finalLocalPath != "" && !(outputGCSBucket != "" && finalGCSPath != "") {
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addSplitOnSilenceTool defines and registers the 'ffmpeg_split_on_silence' tool.
+// This tool detects long silences in an audio file and exports the audio between them as separate segments.
+func addSplitOnSilenceTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_split_on_silence",
+		mcp.WithDescription("Splits an audio file into segments at silences longer than a minimum duration (e.g., for podcast chaptering). Each segment is exported as its own file."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithNumber("silence_threshold_db", mcp.DefaultNumber(-30), mcp.Description("Optional. Noise level in dB below which audio counts as silence (e.g., -30). Defaults to -30.")),
+		mcp.WithNumber("min_silence_duration", mcp.DefaultNumber(1), mcp.Description("Optional. Minimum length of a silence, in seconds, for it to become a split point. Defaults to 1.")),
+		mcp.WithBoolean("sample_accurate", mcp.DefaultBool(true), mcp.Description("Optional. If true, segments are decoded and re-encoded so each cut lands on the exact sample. If false, segments are stream-copied, which is much faster and lossless but can shift each cut by up to one compressed audio frame (about 20-26ms). Defaults to true.")),
+		mcp.WithString("output_file_name_prefix", mcp.Description("Optional. Prefix for the segment file names. Segments are named '<prefix>_001.<ext>', '<prefix>_002.<ext>', and so on.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the segment files.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the segment files to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegSplitOnSilenceHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegSplitOnSilenceHandler is the handler for the silence splitting tool.
+// It runs FFmpeg's silencedetect filter, derives the non-silent segments from its output,
+// and then exports and uploads each segment in turn.
+func ffmpegSplitOnSilenceHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_split_on_silence")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_split_on_silence", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	if strings.TrimSpace(inputAudioURI) == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	thresholdDB, ok := argsMap["silence_threshold_db"].(float64)
+	if !ok {
+		thresholdDB = -30
+	}
+	minSilenceDuration, ok := argsMap["min_silence_duration"].(float64)
+	if !ok || minSilenceDuration <= 0 {
+		minSilenceDuration = 1
+	}
+	sampleAccurate := true
+	if v, ok := argsMap["sample_accurate"].(bool); ok {
+		sampleAccurate = v
+	}
+	outputPrefix, _ := argsMap["output_file_name_prefix"].(string)
+	outputPrefix = strings.TrimSpace(outputPrefix)
+	if outputPrefix == "" {
+		uid, _ := shortid.Generate()
+		outputPrefix = fmt.Sprintf("ffmpeg_segment_%s", uid)
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_split_on_silence: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.Float64("silence_threshold_db", thresholdDB),
+		attribute.Float64("min_silence_duration", minSilenceDuration),
+		attribute.Bool("sample_accurate", sampleAccurate),
+		attribute.String("output_file_name_prefix", outputPrefix),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_split", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	detectOutput, ffmpegErr := executeDetectSilence(ctx, localInputAudio, thresholdDB, minSilenceDuration)
+	if ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg silence detection failed: %v", ffmpegErr)), nil
+	}
+	totalDuration, found := parseFFmpegDuration(detectOutput)
+	if !found {
+		return mcp.NewToolResultError("Could not determine the duration of the input audio from FFMpeg output."), nil
+	}
+	silences := parseSilenceDetectOutput(detectOutput)
+	// Anything shorter than 100ms between two silences is treated as noise rather than a segment.
+	segments := computeNonSilentSegments(silences, totalDuration, 0.1)
+	log.Printf("Detected %d silences and %d segments in %s (duration %.3fs)", len(silences), len(segments), inputAudioURI, totalDuration)
+	if len(segments) == 0 {
+		return mcp.NewToolResultError(fmt.Sprintf("No non-silent audio found at threshold %gdB; nothing to export.", thresholdDB)), nil
+	}
+
+	outputExt := "mp3"
+	switch inputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputAudio), ".")); inputExt {
+	case "wav", "mp3", "aac", "m4a", "ogg", "flac":
+		outputExt = inputExt
+	}
+
+	var segmentLines []string
+	var uploadIssues []string
+	for i, segment := range segments {
+		segmentName := fmt.Sprintf("%s_%03d.%s", outputPrefix, i+1, outputExt)
+		finalLocalPath, gcsUploads, segErr := exportAudioSegment(ctx, localInputAudio, segment, sampleAccurate, segmentName, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+		if segErr != nil {
+			span.RecordError(segErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to export segment %d (%.3fs-%.3fs): %v", i+1, segment.Start, segment.End, segErr)), nil
+		}
+		finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+		if gcsUploadIssues != "" {
+			uploadIssues = append(uploadIssues, fmt.Sprintf("Segment %d: %s", i+1, gcsUploadIssues))
+		}
+		location := finalGCSPath
+		if location == "" && outputLocalDir != "" {
+			location = finalLocalPath
+		}
+		line := fmt.Sprintf("%d: %.3fs-%.3fs", i+1, segment.Start, segment.End)
+		if location != "" {
+			line += fmt.Sprintf(" (%s)", location)
+		}
+		segmentLines = append(segmentLines, line)
+	}
+
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.Int("segment_count", len(segments)),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Split on silence completed in %v.", duration))
+	messageParts = append(messageParts, fmt.Sprintf("Exported %d segments.", len(segments)))
+	messageParts = append(messageParts, fmt.Sprintf("Segment boundaries: %s.", strings.Join(segmentLines, "; ")))
+	messageParts = append(messageParts, uploadIssues...)
+	if outputLocalDir == "" && len(outputGCSBuckets) == 0 {
+		messageParts = append(messageParts, "No output location requested; segments were only written to temporary files.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// exportAudioSegment cuts one segment out of the input and moves/uploads it like any other tool output.
+// See buildTrimArgs for the sampleAccurate tradeoff.
+func exportAudioSegment(ctx context.Context, localInputAudio string, segment mediaSegment, sampleAccurate bool, segmentName, outputLocalDir string, outputGCSBuckets []string, projectID string) (string, []common.GCSUploadResult, error) {
+	return exportFFmpegOutput(ctx, segmentName, outputLocalDir, outputGCSBuckets, projectID, func(tempOutputFile string) error {
+		_, err := runFFmpegCommand(ctx, buildTrimArgs(localInputAudio, tempOutputFile, segment.Start, segment.End, sampleAccurate)...)
+		return err
+	})
+}
+
+// addMakeVoiceNoteTool defines and registers the 'ffmpeg_make_voice_note' tool.
+// This tool produces a small mono Opus voice note suitable for delivery into chat apps.
+func addMakeVoiceNoteTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_make_voice_note",
+		mcp.WithDescription("Converts an audio file into a mono Opus voice note (OGG or WebM) sized to fit under a maximum file size, for chat apps that only accept small voice notes."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithNumber("max_size_kb", mcp.DefaultNumber(1024), mcp.Description("Optional. Maximum size of the voice note in kilobytes. The bitrate is computed from the input duration to fit under this limit. Defaults to 1024 (1 MB).")),
+		mcp.WithString("container", mcp.Enum("ogg", "webm"), mcp.Description("Optional. Output container: 'ogg' or 'webm'. Defaults to 'ogg', or to the extension of output_file_name if it is provided.")),
+		mcp.WithBoolean("normalize", mcp.DefaultBool(true), mcp.Description("Optional. Apply light dynamic loudness normalization (dynaudnorm). Defaults to true.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output voice note file.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output file to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegMakeVoiceNoteHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegMakeVoiceNoteHandler is the handler for the voice note tool.
+// It probes the input duration, derives an Opus bitrate that fits the size cap,
+// encodes the voice note, and confirms the result is under the limit before saving it.
+func ffmpegMakeVoiceNoteHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_make_voice_note")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_make_voice_note", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	if strings.TrimSpace(inputAudioURI) == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	maxSizeKB, ok := argsMap["max_size_kb"].(float64)
+	if !ok {
+		maxSizeKB = 1024
+	}
+	if maxSizeKB <= 0 {
+		return invalidParamResult("max_size_kb", "must be a positive number"), nil
+	}
+	maxSizeBytes := int64(maxSizeKB * 1024)
+	normalize := true
+	if normalizeParam, ok := argsMap["normalize"].(bool); ok {
+		normalize = normalizeParam
+	}
+	containerParam, _ := argsMap["container"].(string)
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_make_voice_note: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	container, err := selectVoiceNoteContainer(containerParam, outputFileName)
+	if err != nil {
+		return invalidParamResult("container", "%v", err), nil
+	}
+
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.Int64("max_size_bytes", maxSizeBytes),
+		attribute.String("container", container),
+		attribute.Bool("normalize", normalize),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_voice_note", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	inputDuration, err := probeMediaDuration(ctx, localInputAudio)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	bitrateKbps, err := computeVoiceNoteBitrate(inputDuration, maxSizeBytes)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Cannot fit voice note under the size limit: %v", err)), nil
+	}
+	span.SetAttributes(
+		attribute.Float64("input_duration_secs", inputDuration),
+		attribute.Int("bitrate_kbps", bitrateKbps),
+	)
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, container)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	_, ffmpegErr := executeMakeVoiceNote(ctx, localInputAudio, tempOutputFile, container, bitrateKbps, normalize)
+	if ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg voice note encoding failed: %v", ffmpegErr)), nil
+	}
+
+	outputInfo, err := os.Stat(tempOutputFile)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read encoded voice note: %v", err)), nil
+	}
+	if outputInfo.Size() > maxSizeBytes {
+		return mcp.NewToolResultError(fmt.Sprintf("Encoded voice note is %s, which exceeds the %s limit even at %d kbps.", common.FormatBytes(outputInfo.Size()), common.FormatBytes(maxSizeBytes), bitrateKbps)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Voice note (%s, mono Opus at %d kbps, %s for %.1fs of audio) created in %v.", container, bitrateKbps, common.FormatBytes(outputInfo.Size()), inputDuration, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
 		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
 	}
 	if finalGCSPath != "" {
 		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
 	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
 	if len(messageParts) == 1 {
 		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
 	}
 	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
-}
\ No newline at end of file
+}
+
+// addExtractSubtitlesTool defines and registers the 'ffmpeg_extract_subtitles' tool.
+// This tool pulls embedded subtitles out as sidecar files, or hands off audio for transcription.
+func addExtractSubtitlesTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_extract_subtitles",
+		mcp.WithDescription("Extracts embedded subtitle streams from a video to sidecar .srt or .vtt files. If the video has no subtitle streams and 'fallback_audio_for_transcription' is true, extracts the audio as 16 kHz mono FLAC for transcription instead. The result states which path was taken."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithNumber("subtitle_stream_index", mcp.Description("Optional. Stream index (as reported by ffmpeg_get_media_info) of the subtitle stream to extract. If omitted, all text subtitle streams are extracted.")),
+		mcp.WithString("subtitle_format", mcp.DefaultString("srt"), mcp.Enum("srt", "vtt"), mcp.Description("Optional. Subtitle file format: 'srt' or 'vtt'. Defaults to 'srt'.")),
+		mcp.WithBoolean("fallback_audio_for_transcription", mcp.DefaultBool(false), mcp.Description("Optional. When the input has no subtitle streams, extract its audio as 16 kHz mono FLAC instead of failing. Defaults to false.")),
+		mcp.WithString("output_file_name_prefix", mcp.Description("Optional. Prefix for the output file names. Subtitle files are named '<prefix>_s<stream index>[_<language>].<format>'; the audio fallback is named '<prefix>_transcription.flac'.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output files.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output files to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegExtractSubtitlesHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegExtractSubtitlesHandler is the handler for the subtitle extraction tool.
+// It lists the input's streams with ffprobe, decides between subtitle extraction and the
+// audio fallback, and then exports and uploads each output file in turn.
+func ffmpegExtractSubtitlesHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_extract_subtitles")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_extract_subtitles", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	streamIndex := -1
+	if indexArg, ok := argsMap["subtitle_stream_index"].(float64); ok {
+		if indexArg < 0 || indexArg != float64(int(indexArg)) {
+			return invalidParamResult("subtitle_stream_index", "must be a non-negative integer, got %v", indexArg), nil
+		}
+		streamIndex = int(indexArg)
+	}
+	subtitleFormat, _ := argsMap["subtitle_format"].(string)
+	subtitleFormat = strings.ToLower(strings.TrimSpace(subtitleFormat))
+	if subtitleFormat == "" {
+		subtitleFormat = "srt"
+	}
+	if subtitleFormat != "srt" && subtitleFormat != "vtt" {
+		return invalidParamResult("subtitle_format", "must be 'srt' or 'vtt', got '%s'", subtitleFormat), nil
+	}
+	fallbackAudio, _ := argsMap["fallback_audio_for_transcription"].(bool)
+	outputPrefix, _ := argsMap["output_file_name_prefix"].(string)
+	outputPrefix = strings.TrimSpace(outputPrefix)
+	if outputPrefix == "" {
+		uid, _ := shortid.Generate()
+		outputPrefix = fmt.Sprintf("ffmpeg_subtitles_%s", uid)
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryGeneral); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_extract_subtitles: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Int("subtitle_stream_index", streamIndex),
+		attribute.String("subtitle_format", subtitleFormat),
+		attribute.Bool("fallback_audio_for_transcription", fallbackAudio),
+		attribute.String("output_file_name_prefix", outputPrefix),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, inputCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_subtitles", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("FFprobe failed to list streams: %v", err)), nil
+	}
+	subtitles, hasAudio, err := parseMediaStreams(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	selected, useAudioFallback, err := planSubtitleExtraction(subtitles, hasAudio, streamIndex, fallbackAudio)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	var outputLines []string
+	var uploadIssues []string
+	recordOutput := func(label, finalLocalPath string, gcsUploads []common.GCSUploadResult) {
+		finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+		if gcsUploadIssues != "" {
+			uploadIssues = append(uploadIssues, fmt.Sprintf("%s: %s", label, gcsUploadIssues))
+		}
+		location := finalGCSPath
+		if location == "" && outputLocalDir != "" {
+			location = finalLocalPath
+		}
+		if location != "" {
+			label += fmt.Sprintf(" (%s)", location)
+		}
+		outputLines = append(outputLines, label)
+	}
+
+	var pathTaken string
+	if useAudioFallback {
+		pathTaken = "audio_fallback"
+		outputName := fmt.Sprintf("%s_transcription.flac", outputPrefix)
+		finalLocalPath, gcsUploads, exportErr := exportFFmpegOutput(ctx, outputName, outputLocalDir, outputGCSBuckets, cfg.ProjectID, func(tempOutputFile string) error {
+			_, ffmpegErr := executeExtractTranscriptionAudio(ctx, localInputVideo, tempOutputFile)
+			return ffmpegErr
+		})
+		if exportErr != nil {
+			span.RecordError(exportErr)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to extract audio for transcription: %v", exportErr)), nil
+		}
+		recordOutput("16 kHz mono FLAC", finalLocalPath, gcsUploads)
+	} else {
+		pathTaken = "subtitles"
+		for _, stream := range selected {
+			outputName := stream.outputFileName(outputPrefix, subtitleFormat)
+			finalLocalPath, gcsUploads, exportErr := exportFFmpegOutput(ctx, outputName, outputLocalDir, outputGCSBuckets, cfg.ProjectID, func(tempOutputFile string) error {
+				_, ffmpegErr := executeExtractSubtitleStream(ctx, localInputVideo, stream.Index, subtitleFormat, tempOutputFile)
+				return ffmpegErr
+			})
+			if exportErr != nil {
+				span.RecordError(exportErr)
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to extract subtitle stream %s: %v", stream, exportErr)), nil
+			}
+			recordOutput("stream "+stream.String(), finalLocalPath, gcsUploads)
+		}
+	}
+
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.String("path_taken", pathTaken),
+		attribute.Int("output_count", len(outputLines)),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Subtitle extraction completed in %v.", duration))
+	if useAudioFallback {
+		messageParts = append(messageParts, "Path taken: audio_fallback (the input has no subtitle streams, so its audio was extracted for transcription).")
+	} else {
+		messageParts = append(messageParts, fmt.Sprintf("Path taken: subtitles (extracted %d %s file(s)).", len(outputLines), subtitleFormat))
+	}
+	messageParts = append(messageParts, fmt.Sprintf("Outputs: %s.", strings.Join(outputLines, "; ")))
+	messageParts = append(messageParts, uploadIssues...)
+	if outputLocalDir == "" && len(outputGCSBuckets) == 0 {
+		messageParts = append(messageParts, "No output location requested; outputs were only written to temporary files.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addConcatAudioWithGapsTool defines and registers the 'ffmpeg_concat_audio_with_gaps' tool.
+// This tool joins audio clips with configurable silence between them, e.g. for announcement tracks.
+func addConcatAudioWithGapsTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_concat_audio_with_gaps",
+		mcp.WithDescription("Concatenates audio clips in order with generated silence between them. Use 'gap_seconds' for a uniform gap or 'per_gap_seconds' to set each gap individually. All clips are resampled to the sample rate and channel count of the first clip; WAV output keeps its PCM format."),
+		mcp.WithArray("input_audio_uris", mcp.Required(), mcp.Description("Array of URIs for the input audio files, in playback order (local paths or gs://)."), mcp.WithStringItems()),
+		mcp.WithNumber("gap_seconds", mcp.DefaultNumber(1), mcp.Min(0), mcp.Description("Optional. Seconds of silence inserted between every pair of clips. Defaults to 1. Ignored when 'per_gap_seconds' is provided.")),
+		mcp.WithArray("per_gap_seconds", mcp.Description("Optional. Seconds of silence for each gap, in order; must have one entry fewer than 'input_audio_uris'."), mcp.Items(map[string]any{"type": "number"})),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output file (e.g., 'announcements.wav'). Defaults to the extension of the first clip.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegConcatAudioWithGapsHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegConcatAudioWithGapsHandler is the handler for the gap concatenation tool.
+// It resolves the gap list, probes the first clip for its sample rate and channel count,
+// and runs a single filter graph that interleaves the clips with anullsrc silence.
+func ffmpegConcatAudioWithGapsHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_concat_audio_with_gaps")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_concat_audio_with_gaps", argsMap)
+
+	inputAudioURIsRaw, _ := argsMap["input_audio_uris"].([]interface{})
+	var inputAudioURIs []string
+	for _, item := range inputAudioURIsRaw {
+		if strItem, ok := item.(string); ok && strings.TrimSpace(strItem) != "" {
+			inputAudioURIs = append(inputAudioURIs, strItem)
+		}
+	}
+	if len(inputAudioURIs) == 0 {
+		return invalidParamResult("input_audio_uris", "must contain at least one audio file"), nil
+	}
+	gapSeconds, ok := argsMap["gap_seconds"].(float64)
+	if !ok {
+		gapSeconds = 1
+	}
+	var perGapSeconds []float64
+	if perGapRaw, ok := argsMap["per_gap_seconds"].([]interface{}); ok {
+		for i, item := range perGapRaw {
+			gap, ok := item.(float64)
+			if !ok {
+				return invalidParamResult("per_gap_seconds", "entry %d is not a number", i+1), nil
+			}
+			perGapSeconds = append(perGapSeconds, gap)
+		}
+	}
+	gaps, err := resolveAudioGaps(len(inputAudioURIs), gapSeconds, perGapSeconds)
+	if err != nil {
+		gapField := "gap_seconds"
+		if len(perGapSeconds) > 0 {
+			gapField = "per_gap_seconds"
+		}
+		return invalidParamResult(gapField, "%v", err), nil
+	}
+
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_concat_audio_with_gaps: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	span.SetAttributes(
+		attribute.StringSlice("input_audio_uris", inputAudioURIs),
+		attribute.Float64Slice("gaps_seconds", gaps),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	var localInputFilePaths []string
+	var inputCleanups []func()
+	defer func() {
+		for _, c := range inputCleanups {
+			c()
+		}
+	}()
+	for i, uri := range inputAudioURIs {
+		localPath, cleanup, errPrep := prepareInputFile(ctx, uri, fmt.Sprintf("gap_concat_input_%d", i), cfg.ProjectID)
+		if errPrep != nil {
+			span.RecordError(errPrep)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio file %s: %v", uri, errPrep)), nil
+		}
+		inputCleanups = append(inputCleanups, cleanup)
+		localInputFilePaths = append(localInputFilePaths, localPath)
+	}
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputFilePaths[0])
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe the first audio clip: %v", err)), nil
+	}
+	format, err := parseAudioFormat(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read the audio format of %s: %v", inputAudioURIs[0], err)), nil
+	}
+	span.SetAttributes(
+		attribute.Int("sample_rate", format.SampleRate),
+		attribute.Int("channels", format.Channels),
+	)
+
+	outputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputFilePaths[0]), "."))
+	if outputExt == "" {
+		outputExt = "wav"
+	}
+	if userExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(outputFileName), ".")); userExt != "" {
+		outputExt = userExt
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, outputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	_, ffmpegErr := executeConcatAudioWithGaps(ctx, localInputFilePaths, gaps, format, sampleFormat, tempOutputFile)
+	if ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg audio concatenation with gaps failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var totalGap float64
+	for _, gap := range gaps {
+		totalGap += gap
+	}
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Concatenated %d audio clips with %.3fs of inserted silence (%d Hz, %s) in %v.", len(inputAudioURIs), totalGap, format.SampleRate, channelLayoutFor(format.Channels), duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addCompressToSizeTool defines and registers the 'ffmpeg_compress_to_size' tool.
+// This tool re-encodes a video so that it fits under a file size cap, such as an email attachment limit.
+func addCompressToSizeTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_compress_to_size",
+		mcp.WithDescription("Compresses a video to fit under a target file size (e.g., 25 MB for email) using a two-pass H.264 encode at a bitrate computed from the input duration. If the result is still over the target, one corrective pass at a reduced bitrate is run. The resolution is lowered automatically when the bitrate would be too low for the source resolution. Outputs MP4."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithNumber("target_size_mb", mcp.Required(), mcp.Description("Maximum size of the output in megabytes (1 MB = 1,048,576 bytes).")),
+		mcp.WithNumber("audio_bitrate_kbps", mcp.DefaultNumber(compressDefaultAudioBitrateKbps), mcp.Description("Optional. AAC bitrate in kbps reserved for the audio track; the rest of the budget goes to video. Ignored if the input has no audio. Defaults to 128.")),
+		mcp.WithNumber("min_bits_per_pixel", mcp.DefaultNumber(compressDefaultMinBitsPerPixel), mcp.Description("Optional. Quality floor in video bits per pixel per frame. If the computed bitrate falls below it at the source resolution, the output is downscaled until it is met. Defaults to 0.05.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output MP4 file.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output file to.")),
+		withReproducibleParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegCompressToSizeHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegCompressToSizeHandler is the handler for the compress-to-size tool.
+// It probes the input, plans the audio/video bitrate split and output resolution, runs a
+// two-pass encode, and re-runs the second pass once at a lower bitrate if the output is too big.
+func ffmpegCompressToSizeHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_compress_to_size")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_compress_to_size", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	targetSizeMB, ok := argsMap["target_size_mb"].(float64)
+	if !ok || targetSizeMB <= 0 {
+		return invalidParamResult("target_size_mb", "a positive number is required"), nil
+	}
+	targetBytes := int64(targetSizeMB * 1024 * 1024)
+	audioBitrateKbps := compressDefaultAudioBitrateKbps
+	if audioParam, ok := argsMap["audio_bitrate_kbps"].(float64); ok {
+		if audioParam <= 0 {
+			return invalidParamResult("audio_bitrate_kbps", "must be a positive number"), nil
+		}
+		audioBitrateKbps = int(audioParam)
+	}
+	minBitsPerPixel := compressDefaultMinBitsPerPixel
+	if bppParam, ok := argsMap["min_bits_per_pixel"].(float64); ok {
+		if bppParam <= 0 {
+			return invalidParamResult("min_bits_per_pixel", "must be a positive number"), nil
+		}
+		minBitsPerPixel = bppParam
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_compress_to_size: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	reproducible, _ := argsMap["reproducible"].(bool)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Int64("target_size_bytes", targetBytes),
+		attribute.Int("audio_bitrate_kbps", audioBitrateKbps),
+		attribute.Float64("min_bits_per_pixel", minBitsPerPixel),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+		attribute.Bool("reproducible", reproducible),
+	)
+
+	localInputVideo, inputCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_compress", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	inputDuration, err := probeMediaDuration(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	plan, err := planCompression(inputDuration, targetBytes, audioBitrateKbps, geometry, minBitsPerPixel)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Cannot fit video under the target size: %v", err)), nil
+	}
+	span.SetAttributes(
+		attribute.Float64("input_duration_secs", inputDuration),
+		attribute.Int("video_bitrate_kbps", plan.VideoBitrateKbps),
+		attribute.Bool("downscaled", plan.Downscaled),
+	)
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	passLogDir, err := os.MkdirTemp("", "avtool_passlog_")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to create pass log directory: %v", err)), nil
+	}
+	defer os.RemoveAll(passLogDir)
+	passLogPrefix := filepath.Join(passLogDir, "ffmpeg2pass")
+
+	if err := executeTwoPassEncode(ctx, localInputVideo, tempOutputFile, passLogPrefix, plan, reproducible); err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg two-pass encoding failed: %v", err)), nil
+	}
+	passes := 2
+	initialBitrateKbps := plan.VideoBitrateKbps
+
+	outputInfo, err := os.Stat(tempOutputFile)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read encoded video: %v", err)), nil
+	}
+	if outputInfo.Size() > targetBytes {
+		// container overhead or rate-control drift pushed the file over; redo the second pass once,
+		// reusing the first-pass statistics at a reduced bitrate
+		corrected, err := correctiveVideoBitrate(plan.VideoBitrateKbps, inputDuration, outputInfo.Size(), targetBytes)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Encoded video is %s, over the %s target: %v", common.FormatBytes(outputInfo.Size()), common.FormatBytes(targetBytes), err)), nil
+		}
+		log.Printf("Handler ffmpeg_compress_to_size: output is %s, over the %s target; corrective pass at %d kbps (was %d kbps)", common.FormatBytes(outputInfo.Size()), common.FormatBytes(targetBytes), corrected, plan.VideoBitrateKbps)
+		plan.VideoBitrateKbps = corrected
+		if _, err := runFFmpegCommand(ctx, withReproducibleOutput(buildTwoPassArgs(localInputVideo, tempOutputFile, passLogPrefix, plan, 2), reproducible)...); err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("FFMpeg corrective pass failed: %v", err)), nil
+		}
+		passes++
+		if outputInfo, err = os.Stat(tempOutputFile); err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read encoded video: %v", err)), nil
+		}
+		if outputInfo.Size() > targetBytes {
+			return mcp.NewToolResultError(fmt.Sprintf("Encoded video is %s, which still exceeds the %s target after a corrective pass at %d kbps.", common.FormatBytes(outputInfo.Size()), common.FormatBytes(targetBytes), corrected)), nil
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("passes", passes),
+		attribute.Int64("output_size_bytes", outputInfo.Size()),
+	)
+
+	outputSHA256, err := reproducibleOutputSHA256(ctx, tempOutputFile, reproducible)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to hash output file: %v", err)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Video compressed to %s (target %s) in %v.", common.FormatBytes(outputInfo.Size()), common.FormatBytes(targetBytes), duration))
+	bitrateNote := fmt.Sprintf("Video bitrate: %d kbps", plan.VideoBitrateKbps)
+	if plan.VideoBitrateKbps != initialBitrateKbps {
+		bitrateNote += fmt.Sprintf(" (reduced from %d kbps)", initialBitrateKbps)
+	}
+	if plan.AudioBitrateKbps > 0 {
+		bitrateNote += fmt.Sprintf(", audio bitrate: %d kbps", plan.AudioBitrateKbps)
+	}
+	messageParts = append(messageParts, fmt.Sprintf("%s, passes executed: %d.", bitrateNote, passes))
+	if plan.Downscaled {
+		messageParts = append(messageParts, fmt.Sprintf("Downscaled from %dx%d to %dx%d to stay above %g bits per pixel.", geometry.Width, geometry.Height, plan.Width, plan.Height, minBitsPerPixel))
+	}
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if outputSHA256 != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Reproducible output SHA-256: %s.", outputSHA256))
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addProgressBarTool defines and registers the 'ffmpeg_progress_bar' tool.
+// This tool burns a playback progress bar into a video, as often seen on social clips.
+func addProgressBarTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_progress_bar",
+		mcp.WithDescription("Burns a progress bar into a video along the top or bottom edge. The bar grows from left to right and spans the full width when playback reaches the end of the video. Audio is copied unchanged."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithString("position", mcp.DefaultString("bottom"), mcp.Enum("top", "bottom"), mcp.Description("Optional. Edge of the frame to draw the bar along: 'top' or 'bottom'. Defaults to 'bottom'.")),
+		mcp.WithNumber("height", mcp.DefaultNumber(8), mcp.Description("Optional. Height of the bar in pixels. Defaults to 8.")),
+		mcp.WithString("color", mcp.DefaultString("white"), mcp.Description("Optional. Bar color as an FFmpeg color name or hex value, with optional opacity (e.g., 'red', '#FF0050', 'white@0.8'). Defaults to 'white'.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'with_progress.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegProgressBarHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegProgressBarHandler handles the request to overlay a progress bar onto a video.
+// It probes the video's duration and height, then draws a bar whose width tracks the playback position.
+func ffmpegProgressBarHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_progress_bar")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_progress_bar", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	position, _ := argsMap["position"].(string)
+	position = strings.ToLower(strings.TrimSpace(position))
+	if position == "" {
+		position = "bottom"
+	}
+	if position != "top" && position != "bottom" {
+		return invalidParamResult("position", "must be 'top' or 'bottom', got '%s'", position), nil
+	}
+	height := 8
+	if heightParam, ok := argsMap["height"].(float64); ok {
+		height = int(heightParam)
+	}
+	if height <= 0 {
+		return invalidParamResult("height", "must be a positive number of pixels"), nil
+	}
+	color, _ := argsMap["color"].(string)
+	color = strings.TrimSpace(color)
+	if color == "" {
+		color = "white"
+	}
+	if !ffmpegColorRegex.MatchString(color) {
+		return invalidParamResult("color", "must be an FFmpeg color name or hex value such as 'red' or '#FF0050', got '%s'", color), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_progress_bar: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.String("position", position),
+		attribute.Int("height", height),
+		attribute.String("color", color),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_progress", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	videoDuration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	if height >= geometry.Height {
+		return invalidParamResult("height", "must be less than the video height (%dpx), got %dpx", geometry.Height, height), nil
+	}
+	span.SetAttributes(attribute.Float64("input_duration_secs", videoDuration))
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	filter := buildProgressBarFilter(position, height, color, videoDuration)
+	if _, ffmpegErr := executeProgressBar(ctx, localInputVideo, tempOutputFile, filter); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg progress bar failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Progress bar (%s, %dpx, %s, over %.1fs) added to video in %v.", position, height, color, videoDuration, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addSideBySideTool defines and registers the 'ffmpeg_side_by_side' tool.
+func addSideBySideTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_side_by_side",
+		mcp.WithDescription("Places two videos next to each other (or one above the other) for before/after comparisons. Both videos are scaled to a common height (horizontal) or width (vertical) before stacking, and the output ends with the shorter video."),
+		mcp.WithString("left_video_uri", mcp.Required(), mcp.Description("URI of the left (or top) video file (local path or gs://).")),
+		mcp.WithString("right_video_uri", mcp.Required(), mcp.Description("URI of the right (or bottom) video file (local path or gs://).")),
+		mcp.WithString("layout", mcp.DefaultString("horizontal"), mcp.Enum("horizontal", "vertical"), mcp.Description("Optional. 'horizontal' places the videos side by side, 'vertical' stacks them top to bottom. Defaults to 'horizontal'.")),
+		mcp.WithString("audio", mcp.DefaultString("mix"), mcp.Enum("mix", "left", "right", "none"), mcp.Description("Optional. Audio for the output: 'mix' both tracks, keep only the 'left' or 'right' track, or 'none'. With 'mix', a single available track is used as-is. Defaults to 'mix'.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'before_after.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegSideBySideHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegSideBySideHandler handles the request to stack two videos into a split-screen comparison.
+// It probes both inputs to pick the shared scale dimension and the available audio tracks.
+func ffmpegSideBySideHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_side_by_side")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_side_by_side", argsMap)
+
+	leftVideoURI, _ := argsMap["left_video_uri"].(string)
+	rightVideoURI, _ := argsMap["right_video_uri"].(string)
+	if strings.TrimSpace(leftVideoURI) == "" {
+		return invalidParamResult("left_video_uri", reasonRequired), nil
+	}
+	if strings.TrimSpace(rightVideoURI) == "" {
+		return invalidParamResult("right_video_uri", reasonRequired), nil
+	}
+	layout, _ := argsMap["layout"].(string)
+	layout = strings.ToLower(strings.TrimSpace(layout))
+	if layout == "" {
+		layout = "horizontal"
+	}
+	if layout != "horizontal" && layout != "vertical" {
+		return invalidParamResult("layout", "must be 'horizontal' or 'vertical', got '%s'", layout), nil
+	}
+	audioMode, _ := argsMap["audio"].(string)
+	audioMode = strings.ToLower(strings.TrimSpace(audioMode))
+	if audioMode == "" {
+		audioMode = "mix"
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_side_by_side: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("left_video_uri", leftVideoURI),
+		attribute.String("right_video_uri", rightVideoURI),
+		attribute.String("layout", layout),
+		attribute.String("audio", audioMode),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localLeftVideo, leftCleanup, err := prepareInputFile(ctx, leftVideoURI, "input_video_left", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare left video: %v", err)), nil
+	}
+	defer leftCleanup()
+	localRightVideo, rightCleanup, err := prepareInputFile(ctx, rightVideoURI, "input_video_right", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare right video: %v", err)), nil
+	}
+	defer rightCleanup()
+
+	var geometries [2]videoGeometry
+	for i, localVideo := range []string{localLeftVideo, localRightVideo} {
+		side := []string{"left", "right"}[i]
+		mediaInfoJSON, err := executeGetMediaInfo(ctx, localVideo)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to probe %s video: %v", side, err)), nil
+		}
+		geometries[i], err = parseVideoGeometry(mediaInfoJSON)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read %s video stream: %v", side, err)), nil
+		}
+	}
+	effectiveAudio, err := resolveSideBySideAudio(audioMode, geometries[0].HasAudio, geometries[1].HasAudio)
+	if err != nil {
+		return invalidParamResult("audio", "cannot use '%s': %v", audioMode, err), nil
+	}
+	stackSize := sideBySideStackSize(layout, geometries[0], geometries[1])
+	span.SetAttributes(
+		attribute.Int("stack_size", stackSize),
+		attribute.String("effective_audio", effectiveAudio),
+	)
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	filter := buildSideBySideFilter(layout, stackSize, effectiveAudio)
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildSideBySideArgs(localLeftVideo, localRightVideo, tempOutputFile, filter, effectiveAudio)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg side-by-side failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	dimension := "height"
+	if layout == "vertical" {
+		dimension = "width"
+	}
+	audioSummary := effectiveAudio
+	if effectiveAudio != audioMode {
+		audioSummary = fmt.Sprintf("%s, as '%s' needs audio in both inputs", effectiveAudio, audioMode)
+	}
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Videos stacked %s (both scaled to %s %dpx, audio: %s) in %v.", layout, dimension, stackSize, audioSummary, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addShiftAudioSyncTool defines and registers the 'ffmpeg_shift_audio_sync' tool.
+func addShiftAudioSyncTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_shift_audio_sync",
+		mcp.WithDescription("Shifts a video's audio track against the picture to fix audio/video sync, copying the video stream unchanged. Pass 'offset_seconds', or set 'detect' to estimate the offset from scene changes and pauses in the audio (a coarse heuristic; the result states its confidence)."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file with an audio track (local path or gs://).")),
+		mcp.WithNumber("offset_seconds", mcp.Description("Seconds to shift the audio by. Positive delays the audio (use when the voice leads the picture), negative advances it. Required unless 'detect' is true.")),
+		mcp.WithBoolean("detect", mcp.DefaultBool(false), mcp.Description("Optional. Estimate the offset by lining up pauses and onsets in the audio with scene changes in the video, then apply it. Needs a video with several cuts that fall on speech boundaries. Defaults to false.")),
+		mcp.WithNumber("max_offset_seconds", mcp.DefaultNumber(1), mcp.Description("Optional. With 'detect', the largest offset searched for in either direction, between 0.05 and 5 seconds. Defaults to 1.")),
+		mcp.WithNumber("min_confidence", mcp.DefaultNumber(0.5), mcp.Description("Optional. With 'detect', the confidence (0 to 1) the estimate needs before it is applied. Below it, the estimate is reported and no output is written. Defaults to 0.5.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'synced.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegShiftAudioSyncHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegShiftAudioSyncHandler handles the request to shift a video's audio against its picture.
+// The offset is either given, or estimated from the scene changes and audio pauses (see estimateAudioSyncOffset).
+func ffmpegShiftAudioSyncHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_shift_audio_sync")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_shift_audio_sync", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	detect, _ := argsMap["detect"].(bool)
+	offsetSeconds, offsetSet := argsMap["offset_seconds"].(float64)
+	if detect && offsetSet {
+		return invalidParamResult("offset_seconds", "cannot be combined with 'detect': true; omit it to use the detected offset"), nil
+	}
+	if !detect && !offsetSet {
+		return invalidParamResult("offset_seconds", "a value is required unless 'detect' is true"), nil
+	}
+	if offsetSet && math.Abs(offsetSeconds) < 0.001 {
+		return invalidParamResult("offset_seconds", "must be non-zero, got %g", offsetSeconds), nil
+	}
+	maxOffsetSeconds := 1.0
+	if maxOffsetParam, ok := argsMap["max_offset_seconds"].(float64); ok {
+		maxOffsetSeconds = maxOffsetParam
+	}
+	if maxOffsetSeconds < 0.05 || maxOffsetSeconds > 5 {
+		return invalidParamResult("max_offset_seconds", "must be between 0.05 and 5 seconds, got %g", maxOffsetSeconds), nil
+	}
+	minConfidence := 0.5
+	if minConfidenceParam, ok := argsMap["min_confidence"].(float64); ok {
+		minConfidence = minConfidenceParam
+	}
+	if minConfidence < 0 || minConfidence > 1 {
+		return invalidParamResult("min_confidence", "must be between 0 and 1, got %g", minConfidence), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_shift_audio_sync: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Bool("detect", detect),
+		attribute.Float64("offset_seconds", offsetSeconds),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_sync", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	if !geometry.HasAudio {
+		return invalidParamResult("input_video_uri", "the video has no audio stream to shift"), nil
+	}
+	videoDuration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	if offsetSet && math.Abs(offsetSeconds) >= videoDuration {
+		return invalidParamResult("offset_seconds", "must be shorter than the video (%.2fs), got %g", videoDuration, offsetSeconds), nil
+	}
+
+	var messageParts []string
+	if detect {
+		sceneOutput, err := executeDetectSceneChanges(ctx, localInputVideo, audioSyncSceneThreshold)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Scene change detection failed: %v", err)), nil
+		}
+		silenceOutput, err := executeDetectSilence(ctx, localInputVideo, audioSyncSilenceThresholdDB, audioSyncMinSilenceSecs)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Silence detection failed: %v", err)), nil
+		}
+		estimate, err := estimateAudioSyncOffset(parseSceneChanges(sceneOutput), audioEnvelopeEvents(parseSilenceDetectOutput(silenceOutput), videoDuration), videoDuration, maxOffsetSeconds)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Could not estimate the audio offset: %v. Pass 'offset_seconds' to apply a shift directly.", err)), nil
+		}
+		span.SetAttributes(
+			attribute.Float64("estimated_offset_seconds", estimate.OffsetSeconds),
+			attribute.Float64("confidence", estimate.Confidence),
+		)
+		summary := fmt.Sprintf("Estimated audio offset: %+.3fs (confidence %.2f, %s; %d of %d scene changes line up with one of %d audio pauses or onsets).",
+			estimate.OffsetSeconds, estimate.Confidence, estimate.confidenceLabel(), estimate.Matched, estimate.SceneChanges, estimate.AudioEvents)
+		if estimate.AtSearchLimit {
+			summary += fmt.Sprintf(" The estimate is at the edge of the ±%gs search range, so the real offset may be larger.", maxOffsetSeconds)
+		}
+		messageParts = append(messageParts, summary)
+		if estimate.Confidence < minConfidence || estimate.OffsetSeconds == 0 {
+			reason := fmt.Sprintf("The confidence is below min_confidence %.2f", minConfidence)
+			if estimate.OffsetSeconds == 0 {
+				reason = "No shift is needed"
+			}
+			messageParts = append(messageParts, reason+", so no output was written. To apply a shift anyway, call again with 'offset_seconds'.")
+			return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+		}
+		offsetSeconds = estimate.OffsetSeconds
+	}
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildShiftAudioSyncArgs(localInputVideo, tempOutputFile, offsetSeconds)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg audio shift failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	direction := "delayed"
+	if offsetSeconds < 0 {
+		direction = "advanced"
+	}
+	messageParts = append(messageParts, fmt.Sprintf("Audio %s by %.3fs in %v.", direction, math.Abs(offsetSeconds), duration))
+	headerParts := len(messageParts)
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == headerParts {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addEqualizerTool defines and registers the 'ffmpeg_equalizer' tool.
+func addEqualizerTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_equalizer",
+		mcp.WithDescription("Applies a parametric equalizer to an audio file, e.g. to clean up TTS or music. Each band boosts or cuts around a center frequency; optional highpass and lowpass cutoffs remove rumble or hiss. The output keeps the input's format, sample rate and channel count."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithArray("bands", mcp.Description("Optional. EQ bands, applied in order. Each band is an object with 'frequency' (center frequency in Hz), 'width' (Q factor, higher is narrower; defaults to 1) and 'gain_db' (boost or cut in dB, between -30 and 30)."),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"frequency": map[string]any{"type": "number"},
+					"width":     map[string]any{"type": "number"},
+					"gain_db":   map[string]any{"type": "number"},
+				},
+				"required": []string{"frequency", "gain_db"},
+			})),
+		mcp.WithNumber("highpass_hz", mcp.Description("Optional. Cutoff in Hz below which frequencies are removed (e.g., 80 to remove rumble).")),
+		mcp.WithNumber("lowpass_hz", mcp.Description("Optional. Cutoff in Hz above which frequencies are removed (e.g., 12000 to tame hiss).")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output audio file. Defaults to the input's format.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output audio file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output audio file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegEqualizerHandler(ctx, request, cfg)
+	})
+}
+
+// parseEqualizerBands reads the 'bands' argument. Failures name the offending entry, e.g. 'bands[2].gain_db'.
+func parseEqualizerBands(raw []interface{}) ([]equalizerBand, *validationError) {
+	var bands []equalizerBand
+	for i, item := range raw {
+		field := fmt.Sprintf("bands[%d]", i)
+		bandMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, newValidationError(field, "must be an object with 'frequency', 'width' and 'gain_db'")
+		}
+		band := equalizerBand{Width: 1}
+		var hasFrequency, hasGain bool
+		band.Frequency, hasFrequency = bandMap["frequency"].(float64)
+		if !hasFrequency || band.Frequency <= 0 {
+			return nil, newValidationError(field+".frequency", "a positive number of Hz is required")
+		}
+		if width, ok := bandMap["width"]; ok {
+			band.Width, ok = width.(float64)
+			if !ok || band.Width <= 0 {
+				return nil, newValidationError(field+".width", "must be a positive Q factor")
+			}
+		}
+		band.GainDB, hasGain = bandMap["gain_db"].(float64)
+		if !hasGain {
+			return nil, newValidationError(field+".gain_db", "a number is required")
+		}
+		if band.GainDB < -30 || band.GainDB > 30 {
+			return nil, newValidationError(field+".gain_db", "must be between -30 and 30 dB, got %g", band.GainDB)
+		}
+		bands = append(bands, band)
+	}
+	return bands, nil
+}
+
+// ffmpegEqualizerHandler handles the request to equalize an audio file.
+// It probes the input's format so the output can keep it, and checks the frequencies against its Nyquist limit.
+func ffmpegEqualizerHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_equalizer")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_equalizer", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	if strings.TrimSpace(inputAudioURI) == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	var bands []equalizerBand
+	if bandsParam, ok := argsMap["bands"]; ok {
+		bandsRaw, ok := bandsParam.([]interface{})
+		if !ok {
+			return invalidParamResult("bands", "must be an array of band objects"), nil
+		}
+		var validationErr *validationError
+		if bands, validationErr = parseEqualizerBands(bandsRaw); validationErr != nil {
+			return validationErrorResult(validationErr), nil
+		}
+	}
+	highpassHz, highpassSet := argsMap["highpass_hz"].(float64)
+	if highpassSet && highpassHz <= 0 {
+		return invalidParamResult("highpass_hz", "must be a positive number of Hz, got %g", highpassHz), nil
+	}
+	lowpassHz, lowpassSet := argsMap["lowpass_hz"].(float64)
+	if lowpassSet && lowpassHz <= 0 {
+		return invalidParamResult("lowpass_hz", "must be a positive number of Hz, got %g", lowpassHz), nil
+	}
+	if highpassSet && lowpassSet && highpassHz >= lowpassHz {
+		return invalidParamResult("lowpass_hz", "must be above highpass_hz (%g Hz), got %g", highpassHz, lowpassHz), nil
+	}
+	if len(bands) == 0 && !highpassSet && !lowpassSet {
+		return invalidParamResult("bands", "at least one band, or a highpass_hz or lowpass_hz cutoff, is required"), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_equalizer: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	filter := buildEqualizerFilter(bands, highpassHz, lowpassHz)
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.String("filter", filter),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_eq", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputAudio)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input audio: %v", err)), nil
+	}
+	format, err := parseAudioFormat(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input audio format: %v", err)), nil
+	}
+	nyquistHz := float64(format.SampleRate) / 2
+	for i, band := range bands {
+		if band.Frequency >= nyquistHz {
+			return invalidParamResult(fmt.Sprintf("bands[%d].frequency", i), "must be below %g Hz, half the input's %d Hz sample rate, got %g", nyquistHz, format.SampleRate, band.Frequency), nil
+		}
+	}
+	if highpassSet && highpassHz >= nyquistHz {
+		return invalidParamResult("highpass_hz", "must be below %g Hz, half the input's %d Hz sample rate, got %g", nyquistHz, format.SampleRate, highpassHz), nil
+	}
+	if lowpassSet && lowpassHz >= nyquistHz {
+		return invalidParamResult("lowpass_hz", "must be below %g Hz, half the input's %d Hz sample rate, got %g", nyquistHz, format.SampleRate, lowpassHz), nil
+	}
+
+	defaultOutputExt := "mp3"
+	inputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputAudio), "."))
+	switch inputExt {
+	case "wav", "mp3", "aac", "m4a", "ogg", "flac":
+		defaultOutputExt = inputExt
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, defaultOutputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildEqualizerArgs(localInputAudio, tempOutputFile, filter, format, sampleFormat)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg equalizer failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Equalizer (%s) applied in %v.", filter, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addTonemapHDRToSDRTool defines and registers the 'ffmpeg_tonemap_hdr_to_sdr' tool.
+func addTonemapHDRToSDRTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_tonemap_hdr_to_sdr",
+		mcp.WithDescription("Converts HDR video (BT.2020 with PQ or HLG) to SDR BT.709 with tone mapping, so it does not look washed out on SDR displays. The input's color metadata is checked first; SDR inputs are left alone and no output is written."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithString("algorithm", mcp.DefaultString("hable"), mcp.Enum(tonemapAlgorithms...), mcp.Description("Optional. Tone-mapping curve: 'hable' keeps detail in both shadows and highlights, 'reinhard' is simpler and brighter, 'mobius' keeps in-range colors most accurate. Defaults to 'hable'.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'sdr.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withReproducibleParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegTonemapHDRToSDRHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegTonemapHDRToSDRHandler handles the request to tone-map an HDR video to SDR.
+// It reads the input's color transfer with ffprobe and only converts HDR inputs.
+func ffmpegTonemapHDRToSDRHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_tonemap_hdr_to_sdr")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_tonemap_hdr_to_sdr", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	algorithm, _ := argsMap["algorithm"].(string)
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	if algorithm == "" {
+		algorithm = "hable"
+	}
+	if !slices.Contains(tonemapAlgorithms, algorithm) {
+		return invalidParamResult("algorithm", "must be one of '%s', got '%s'", strings.Join(tonemapAlgorithms, "', '"), algorithm), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_tonemap_hdr_to_sdr: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	reproducible, _ := argsMap["reproducible"].(bool)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.String("algorithm", algorithm),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+		attribute.Bool("reproducible", reproducible),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_hdr", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	color, err := parseVideoColorInfo(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	span.SetAttributes(
+		attribute.String("input_color", color.String()),
+		attribute.Bool("input_hdr", color.isHDR()),
+	)
+	if !color.isHDR() {
+		return mcp.NewToolResultText(fmt.Sprintf("Input is not HDR (transfer/primaries/matrix: %s), so no tone mapping was applied and no output was written.", color)), nil
+	}
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, withReproducibleOutput(buildTonemapArgs(localInputVideo, tempOutputFile, buildTonemapFilter(algorithm)), reproducible)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg tone mapping failed: %v", ffmpegErr)), nil
+	}
+
+	outputSHA256, err := reproducibleOutputSHA256(ctx, tempOutputFile, reproducible)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to hash output file: %v", err)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("HDR video (%s) tone-mapped to SDR BT.709 with '%s' in %v.", color, algorithm, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if outputSHA256 != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Reproducible output SHA-256: %s.", outputSHA256))
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addCountdownOverlayTool defines and registers the 'ffmpeg_countdown_overlay' tool.
+// This tool burns a countdown timer into a video, e.g. for livestream-style intros.
+func addCountdownOverlayTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_countdown_overlay",
+		mcp.WithDescription("Burns a countdown timer into a video with FFmpeg's drawtext filter, e.g. for livestream-style intros. The timer counts down the remaining whole seconds (10, 9, ... 1 for a 10-second timer) and disappears when it reaches zero. Audio is copied unchanged."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithNumber("duration_seconds", mcp.Required(), mcp.Description("Length of the countdown in seconds.")),
+		mcp.WithNumber("start_seconds", mcp.DefaultNumber(0), mcp.Description("Optional. Time in the video at which the countdown starts. Defaults to 0.")),
+		mcp.WithString("format", mcp.DefaultString("seconds"), mcp.Enum("seconds", "mm:ss"), mcp.Description("Optional. 'seconds' shows the remaining seconds (e.g., 90); 'mm:ss' shows minutes and seconds (e.g., 01:30). Defaults to 'seconds'.")),
+		mcp.WithString("position", mcp.DefaultString("center"), mcp.Enum(countdownPositionNames...), mcp.Description("Optional. Position preset for the timer. Defaults to 'center'.")),
+		mcp.WithNumber("font_size", mcp.Description("Optional. Font size in pixels. By default the text is 1/8 of the frame height.")),
+		mcp.WithString("font_color", mcp.DefaultString("white"), mcp.Description("Optional. Text color as an FFmpeg color name or hex value, with optional opacity (e.g., 'white', '#FFD700', 'white@0.9'). Defaults to 'white'.")),
+		mcp.WithString("box_color", mcp.DefaultString("black@0.5"), mcp.Description("Optional. Color of the box drawn behind the text, in the same format as font_color. Set to 'none' to draw no box. Defaults to 'black@0.5'.")),
+		mcp.WithString("font_file", mcp.Description("Optional. TrueType/OpenType font file (local path or gs://). By default the fontconfig default font is used.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'intro_countdown.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegCountdownOverlayHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegCountdownOverlayHandler handles the request to burn a countdown timer into a video.
+// It checks that the countdown starts within the video before drawing it.
+func ffmpegCountdownOverlayHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_countdown_overlay")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_countdown_overlay", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	countdown := countdownOverlay{Format: "seconds", Position: "center", FontColor: "white", BoxColor: "black@0.5"}
+	durationParam, ok := argsMap["duration_seconds"].(float64)
+	if !ok || durationParam <= 0 {
+		return invalidParamResult("duration_seconds", "a positive number is required"), nil
+	}
+	countdown.DurationSecs = durationParam
+	if startParam, ok := argsMap["start_seconds"].(float64); ok {
+		if startParam < 0 {
+			return invalidParamResult("start_seconds", "must not be negative, got %g", startParam), nil
+		}
+		countdown.StartSecs = startParam
+	}
+	if format, _ := argsMap["format"].(string); strings.TrimSpace(format) != "" {
+		countdown.Format = strings.ToLower(strings.TrimSpace(format))
+		if countdown.Format != "seconds" && countdown.Format != "mm:ss" {
+			return invalidParamResult("format", "must be 'seconds' or 'mm:ss', got '%s'", countdown.Format), nil
+		}
+	}
+	if position, _ := argsMap["position"].(string); strings.TrimSpace(position) != "" {
+		countdown.Position = strings.ToLower(strings.TrimSpace(position))
+		if _, ok := countdownPositions[countdown.Position]; !ok {
+			return invalidParamResult("position", "must be one of '%s', got '%s'", strings.Join(countdownPositionNames, "', '"), countdown.Position), nil
+		}
+	}
+	if fontSizeParam, ok := argsMap["font_size"].(float64); ok {
+		if fontSizeParam < 1 {
+			return invalidParamResult("font_size", "must be a positive number of pixels"), nil
+		}
+		countdown.FontSize = int(fontSizeParam)
+	}
+	if fontColor, _ := argsMap["font_color"].(string); strings.TrimSpace(fontColor) != "" {
+		countdown.FontColor = strings.TrimSpace(fontColor)
+	}
+	if !ffmpegColorRegex.MatchString(countdown.FontColor) {
+		return invalidParamResult("font_color", "must be an FFmpeg color name or hex value such as 'white' or '#FFD700', got '%s'", countdown.FontColor), nil
+	}
+	if boxColor, ok := argsMap["box_color"].(string); ok && strings.TrimSpace(boxColor) != "" {
+		countdown.BoxColor = strings.TrimSpace(boxColor)
+	}
+	if strings.EqualFold(countdown.BoxColor, "none") {
+		countdown.BoxColor = ""
+	} else if !ffmpegColorRegex.MatchString(countdown.BoxColor) {
+		return invalidParamResult("box_color", "must be 'none' or an FFmpeg color name or hex value such as 'black@0.5', got '%s'", countdown.BoxColor), nil
+	}
+	fontFileURI, _ := argsMap["font_file"].(string)
+	fontFileURI = strings.TrimSpace(fontFileURI)
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_countdown_overlay: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Float64("duration_seconds", countdown.DurationSecs),
+		attribute.Float64("start_seconds", countdown.StartSecs),
+		attribute.String("format", countdown.Format),
+		attribute.String("position", countdown.Position),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_countdown", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	if fontFileURI != "" {
+		localFontFile, fontCleanup, err := prepareInputFile(ctx, fontFileURI, "font_file", cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare font file: %v", err)), nil
+		}
+		defer fontCleanup()
+		if !filterPathSafe(localFontFile) {
+			return invalidParamResult("font_file", "the path must not contain quotes or backslashes, got '%s'", localFontFile), nil
+		}
+		countdown.FontFile = localFontFile
+	}
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	videoDuration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	if countdown.StartSecs >= videoDuration {
+		return invalidParamResult("start_seconds", "must be before the end of the video (%.2fs), got %g", videoDuration, countdown.StartSecs), nil
+	}
+	var notes []string
+	if end := countdown.StartSecs + countdown.DurationSecs; end > videoDuration {
+		notes = append(notes, fmt.Sprintf("The video ends at %.2fs, before the countdown reaches zero at %gs.", videoDuration, end))
+	}
+	span.SetAttributes(attribute.Float64("input_duration_secs", videoDuration))
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildDrawtextArgs(localInputVideo, tempOutputFile, buildCountdownFilter(countdown))...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg countdown overlay failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Countdown of %gs (%s, %s) added from %gs in %v.", countdown.DurationSecs, countdown.Format, countdown.Position, countdown.StartSecs, duration))
+	messageParts = append(messageParts, notes...)
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1+len(notes) {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addTrimMediaTool defines and registers the 'ffmpeg_trim_media' tool.
+// This tool cuts a time range out of an audio or video file.
+func addTrimMediaTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_trim_media",
+		mcp.WithDescription("Cuts a time range out of an audio or video file. By default the streams are copied without re-encoding, which is fast and lossless but can only cut on a video keyframe or a compressed audio frame, so the clip may start slightly early. Set 'sample_accurate' to re-encode and cut on the exact sample instead."),
+		mcp.WithString("input_media_uri", mcp.Required(), mcp.Description("URI of the input audio or video file (local path or gs://).")),
+		mcp.WithNumber("start_seconds", mcp.DefaultNumber(0), mcp.Description("Optional. Start of the range to keep, in seconds. Defaults to 0.")),
+		mcp.WithNumber("end_seconds", mcp.Description("Optional. End of the range to keep, in seconds. Defaults to the end of the input.")),
+		mcp.WithBoolean("sample_accurate", mcp.DefaultBool(false), mcp.Description("Optional. If true, the input is decoded from the start and re-encoded so the cut points are exact. This is slower, especially for late cut points in long inputs, and re-encoding loses a little quality. Defaults to false (fast stream copy).")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output file. Defaults to the input's extension.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output file to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegTrimMediaHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegTrimMediaHandler handles the request to trim an audio or video file.
+// It checks the range against the probed input duration before cutting.
+func ffmpegTrimMediaHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_trim_media")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_trim_media", argsMap)
+
+	inputMediaURI, _ := argsMap["input_media_uri"].(string)
+	if strings.TrimSpace(inputMediaURI) == "" {
+		return invalidParamResult("input_media_uri", reasonRequired), nil
+	}
+	startSecs, _ := argsMap["start_seconds"].(float64)
+	if startSecs < 0 {
+		return invalidParamResult("start_seconds", "must not be negative, got %g", startSecs), nil
+	}
+	endSecs, hasEnd := argsMap["end_seconds"].(float64)
+	if hasEnd && endSecs <= startSecs {
+		return invalidParamResult("end_seconds", "must be greater than start_seconds (%g), got %g", startSecs, endSecs), nil
+	}
+	sampleAccurate, _ := argsMap["sample_accurate"].(bool)
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(concatOutputCategory([]string{inputMediaURI}, outputFileName)); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_trim_media: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_media_uri", inputMediaURI),
+		attribute.Float64("start_seconds", startSecs),
+		attribute.Float64("end_seconds", endSecs),
+		attribute.Bool("sample_accurate", sampleAccurate),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputMedia, inputCleanup, err := prepareInputFile(ctx, inputMediaURI, "input_media_trim", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input media: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	mediaDuration, err := probeMediaDuration(ctx, localInputMedia)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	if startSecs >= mediaDuration {
+		return invalidParamResult("start_seconds", "must be before the end of the input (%.3fs), got %g", mediaDuration, startSecs), nil
+	}
+	var notes []string
+	if hasEnd && endSecs > mediaDuration {
+		notes = append(notes, fmt.Sprintf("end_seconds (%g) is past the end of the input, so the clip runs to the end at %.3fs.", endSecs, mediaDuration))
+		endSecs = 0
+	}
+	span.SetAttributes(attribute.Float64("input_duration_secs", mediaDuration))
+
+	outputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputMedia), "."))
+	if outputExt == "" {
+		outputExt = "mp4"
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, outputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildTrimArgs(localInputMedia, tempOutputFile, startSecs, endSecs, sampleAccurate)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg trim failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	rangeEnd := "the end"
+	if endSecs > 0 {
+		rangeEnd = fmt.Sprintf("%gs", endSecs)
+	}
+	mode := "stream copy; cut points snap to the nearest earlier keyframe or audio frame"
+	if sampleAccurate {
+		mode = "sample-accurate re-encode"
+	}
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Trimmed %gs to %s (%s) in %v.", startSecs, rangeEnd, mode, duration))
+	messageParts = append(messageParts, notes...)
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1+len(notes) {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addPackageHLSTool defines and registers the 'ffmpeg_package_hls' tool.
+// This tool packages a video for adaptive streaming in web players.
+func addPackageHLSTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_package_hls",
+		mcp.WithDescription("Packages a video as HLS for adaptive streaming: one H.264 rendition per requested height and bitrate, each with its own variant playlist and segments, plus a master playlist that lists them. Renditions are never upscaled; heights above the source's are rejected. The package is written as a directory tree."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithArray("renditions", mcp.Required(), mcp.Description(fmt.Sprintf("Renditions to encode, e.g. [{\"height\": 1080, \"bitrate\": \"5M\"}, {\"height\": 720, \"bitrate\": \"3M\"}]. 'height' is in pixels and must be even and no larger than the source; the width follows the aspect ratio. 'bitrate' is the video bitrate, such as '5M' or '800k'. At most %d renditions.", maxHLSRenditions)),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"height":  map[string]any{"type": "number"},
+					"bitrate": map[string]any{"type": "string"},
+				},
+				"required": []string{"height", "bitrate"},
+			})),
+		mcp.WithNumber("segment_duration_seconds", mcp.DefaultNumber(6), mcp.Description("Optional. Target segment length in seconds, between 1 and 30. Defaults to 6.")),
+		mcp.WithString("output_package_name", mcp.Description("Optional. Name of the package directory, created under output_local_dir and used as the object prefix in the GCS buckets. Letters, digits, '.', '_' and '-' only. Defaults to 'hls_<id>'.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the package directory to.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the package to, under output_package_name.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegPackageHLSHandler(ctx, request, cfg)
+	})
+}
+
+// parseHLSRenditions reads the 'renditions' argument. Failures name the offending entry, e.g.
+// 'renditions[1].bitrate'. Heights are checked against the source later, once it is probed.
+func parseHLSRenditions(raw []interface{}) ([]hlsRendition, *validationError) {
+	if len(raw) == 0 {
+		return nil, newValidationError("renditions", "at least one rendition is required")
+	}
+	if len(raw) > maxHLSRenditions {
+		return nil, newValidationError("renditions", "at most %d renditions are allowed, got %d", maxHLSRenditions, len(raw))
+	}
+	var renditions []hlsRendition
+	seen := make(map[int]bool)
+	for i, item := range raw {
+		field := fmt.Sprintf("renditions[%d]", i)
+		renditionMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, newValidationError(field, "must be an object with 'height' and 'bitrate'")
+		}
+		height, ok := renditionMap["height"].(float64)
+		if !ok || height <= 0 || height != math.Trunc(height) || int(height)%2 != 0 {
+			return nil, newValidationError(field+".height", "a positive even number of pixels is required")
+		}
+		if seen[int(height)] {
+			return nil, newValidationError(field+".height", "duplicates another rendition's height (%d)", int(height))
+		}
+		seen[int(height)] = true
+		bitrate, _ := renditionMap["bitrate"].(string)
+		if _, err := parseBitrate(bitrate); err != nil {
+			return nil, newValidationError(field+".bitrate", "%v", err)
+		}
+		renditions = append(renditions, hlsRendition{Height: int(height), Bitrate: strings.TrimSpace(bitrate)})
+	}
+	return renditions, nil
+}
+
+// ffmpegPackageHLSHandler handles the request to package a video as HLS.
+// It probes the source so no rendition is upscaled, encodes every rendition in one FFmpeg run,
+// and then copies/uploads the whole package directory.
+func ffmpegPackageHLSHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_package_hls")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_package_hls", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	rawRenditions, _ := argsMap["renditions"].([]interface{})
+	renditions, verr := parseHLSRenditions(rawRenditions)
+	if verr != nil {
+		return validationErrorResult(verr), nil
+	}
+	segmentSecs := 6.0
+	if v, ok := argsMap["segment_duration_seconds"].(float64); ok {
+		if v < 1 || v > maxHLSSegmentDuration {
+			return invalidParamResult("segment_duration_seconds", "must be between 1 and %d, got %g", maxHLSSegmentDuration, v), nil
+		}
+		segmentSecs = v
+	}
+	packageName, _ := argsMap["output_package_name"].(string)
+	packageName = strings.TrimSpace(packageName)
+	if packageName == "" {
+		uid, _ := shortid.Generate()
+		packageName = fmt.Sprintf("hls_%s", uid)
+	} else if !packageNameRegex.MatchString(packageName) || packageName == "." || packageName == ".." {
+		return invalidParamResult("output_package_name", "must contain only letters, digits, '.', '_' and '-', got '%s'", packageName), nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_package_hls: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	renditionNames := make([]string, len(renditions))
+	for i, r := range renditions {
+		renditionNames[i] = fmt.Sprintf("%s@%s", r.name(), r.Bitrate)
+	}
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.StringSlice("renditions", renditionNames),
+		attribute.Float64("segment_duration_seconds", segmentSecs),
+		attribute.String("output_package_name", packageName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_hls", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	for i, r := range renditions {
+		if r.Height > geometry.Height {
+			return invalidParamResult(fmt.Sprintf("renditions[%d].height", i), "must not exceed the source height of %d pixels (renditions are never upscaled), got %d", geometry.Height, r.Height), nil
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("input_height", geometry.Height),
+		attribute.Bool("input_has_audio", geometry.HasAudio),
+	)
+
+	packageDir, err := os.MkdirTemp("", "hls_")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output directory: %v", err)), nil
+	}
+	defer os.RemoveAll(packageDir)
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildHLSArgs(localInputVideo, packageDir, renditions, segmentSecs, geometry.HasAudio)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg HLS packaging failed: %v", ffmpegErr)), nil
+	}
+
+	localPackageDir := ""
+	if outputLocalDir != "" {
+		localPackageDir = filepath.Join(outputLocalDir, packageName)
+	}
+	files, gcsUploads, processErr := processOutputDirToBuckets(ctx, packageDir, localPackageDir, outputGCSBuckets, packageName, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	var segmentCount int
+	var segmentBytes, totalBytes int64
+	for _, f := range files {
+		totalBytes += f.Size
+		if strings.HasSuffix(f.RelPath, ".ts") {
+			segmentCount++
+			segmentBytes += f.Size
+		}
+	}
+
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.Int("segment_count", segmentCount),
+		attribute.Int64("package_bytes", totalBytes),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("HLS package with %d rendition(s) (%s) created in %v.", len(renditions), strings.Join(renditionNames, ", "), duration))
+	messageParts = append(messageParts, fmt.Sprintf("%d segments of about %gs, %s in segments (%s with playlists).", segmentCount, segmentSecs, common.FormatBytes(segmentBytes), common.FormatBytes(totalBytes)))
+	if localPackageDir != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Master playlist saved locally to: %s.", filepath.Join(localPackageDir, hlsMasterPlaylistName)))
+	}
+	for _, upload := range gcsUploads {
+		if upload.Err == nil {
+			messageParts = append(messageParts, fmt.Sprintf("Master playlist uploaded to GCS: %s%s.", upload.GCSPath, hlsMasterPlaylistName))
+		}
+	}
+	if finalGCSPath != "" {
+		span.SetAttributes(attribute.String("output_gcs_prefix", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if outputLocalDir == "" && len(outputGCSBuckets) == 0 {
+		messageParts = append(messageParts, "No output location requested; the package was only written to temporary files.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addCaptionTextTool defines and registers the 'ffmpeg_caption_text' tool.
+// This tool burns timed captions into a video straight from transcript text.
+func addCaptionTextTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_caption_text",
+		mcp.WithDescription("Burns closed-caption-style text into the lower third of a video from a plain transcript, without authoring a subtitle file first. The transcript is split into screens of 'words_per_screen' words, each word-wrapped, centered at the bottom of the frame and drawn with FFmpeg's drawtext filter. Screens are shown back to back: for 'seconds_per_screen' each if given, otherwise spread over the rest of the video in proportion to their word count. Audio is copied unchanged."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithString("transcript", mcp.Required(), mcp.Description("The text to show. Line breaks and repeated spaces are treated as single spaces; the text is re-wrapped for each screen.")),
+		mcp.WithNumber("words_per_screen", mcp.DefaultNumber(defaultCaptionWordsPerScreen), mcp.Description(fmt.Sprintf("Optional. Number of words on each screen, from 1 to %d. Defaults to %d.", maxCaptionWordsPerScreen, defaultCaptionWordsPerScreen))),
+		mcp.WithNumber("seconds_per_screen", mcp.Description("Optional. How long each screen is shown, in seconds. By default the screens fill the video from start_seconds to its end, each shown for a time proportional to its number of words.")),
+		mcp.WithNumber("start_seconds", mcp.DefaultNumber(0), mcp.Description("Optional. Time in the video at which the first screen appears. Defaults to 0.")),
+		mcp.WithNumber("max_chars_per_line", mcp.DefaultNumber(defaultCaptionCharsPerLine), mcp.Description(fmt.Sprintf("Optional. Lines are wrapped between words to at most this many characters, from %d to %d. Defaults to %d.", minCaptionCharsPerLine, maxCaptionCharsPerLine, defaultCaptionCharsPerLine))),
+		mcp.WithNumber("font_size", mcp.Description("Optional. Font size in pixels. By default the text is 1/20 of the frame height.")),
+		mcp.WithString("font_color", mcp.DefaultString("white"), mcp.Description("Optional. Text color as an FFmpeg color name or hex value, with optional opacity (e.g., 'white', '#FFD700', 'white@0.9'). Defaults to 'white'.")),
+		mcp.WithString("box_color", mcp.DefaultString("black@0.6"), mcp.Description("Optional. Color of the box drawn behind the text, in the same format as font_color. Set to 'none' to draw no box. Defaults to 'black@0.6'.")),
+		mcp.WithString("font_file", mcp.Description("Optional. TrueType/OpenType font file (local path or gs://). By default the fontconfig default font is used; pick a font that covers the transcript's script.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'captioned.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegCaptionTextHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegCaptionTextHandler handles the request to burn transcript captions into a video. The
+// screens are timed once the video is probed, and each screen's text is written to its own file
+// for drawtext to read.
+func ffmpegCaptionTextHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_caption_text")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_caption_text", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	transcript, _ := argsMap["transcript"].(string)
+	wordCount := len(strings.Fields(transcript))
+	if wordCount == 0 {
+		return invalidParamResult("transcript", reasonRequired), nil
+	}
+	wordsPerScreen := defaultCaptionWordsPerScreen
+	if wordsParam, ok := argsMap["words_per_screen"].(float64); ok {
+		if wordsParam < 1 || wordsParam > maxCaptionWordsPerScreen || wordsParam != math.Trunc(wordsParam) {
+			return invalidParamResult("words_per_screen", "must be a whole number from 1 to %d, got %g", maxCaptionWordsPerScreen, wordsParam), nil
+		}
+		wordsPerScreen = int(wordsParam)
+	}
+	var secondsPerScreen float64
+	if secondsParam, ok := argsMap["seconds_per_screen"].(float64); ok {
+		if secondsParam <= 0 {
+			return invalidParamResult("seconds_per_screen", "must be a positive number, got %g", secondsParam), nil
+		}
+		secondsPerScreen = secondsParam
+	}
+	var startSecs float64
+	if startParam, ok := argsMap["start_seconds"].(float64); ok {
+		if startParam < 0 {
+			return invalidParamResult("start_seconds", "must not be negative, got %g", startParam), nil
+		}
+		startSecs = startParam
+	}
+	charsPerLine := defaultCaptionCharsPerLine
+	if charsParam, ok := argsMap["max_chars_per_line"].(float64); ok {
+		if charsParam < minCaptionCharsPerLine || charsParam > maxCaptionCharsPerLine {
+			return invalidParamResult("max_chars_per_line", "must be from %d to %d, got %g", minCaptionCharsPerLine, maxCaptionCharsPerLine, charsParam), nil
+		}
+		charsPerLine = int(charsParam)
+	}
+	if screenCount := (wordCount + wordsPerScreen - 1) / wordsPerScreen; screenCount > maxCaptionScreens {
+		return invalidParamResult("transcript", "needs %d screens at %d words per screen, more than the limit of %d; raise words_per_screen or caption the video in parts", screenCount, wordsPerScreen, maxCaptionScreens), nil
+	}
+	style := captionStyle{FontColor: "white", BoxColor: "black@0.6"}
+	if fontSizeParam, ok := argsMap["font_size"].(float64); ok {
+		if fontSizeParam < 1 {
+			return invalidParamResult("font_size", "must be a positive number of pixels"), nil
+		}
+		style.FontSize = int(fontSizeParam)
+	}
+	if fontColor, _ := argsMap["font_color"].(string); strings.TrimSpace(fontColor) != "" {
+		style.FontColor = strings.TrimSpace(fontColor)
+	}
+	if !ffmpegColorRegex.MatchString(style.FontColor) {
+		return invalidParamResult("font_color", "must be an FFmpeg color name or hex value such as 'white' or '#FFD700', got '%s'", style.FontColor), nil
+	}
+	if boxColor, ok := argsMap["box_color"].(string); ok && strings.TrimSpace(boxColor) != "" {
+		style.BoxColor = strings.TrimSpace(boxColor)
+	}
+	if strings.EqualFold(style.BoxColor, "none") {
+		style.BoxColor = ""
+	} else if !ffmpegColorRegex.MatchString(style.BoxColor) {
+		return invalidParamResult("box_color", "must be 'none' or an FFmpeg color name or hex value such as 'black@0.6', got '%s'", style.BoxColor), nil
+	}
+	fontFileURI, _ := argsMap["font_file"].(string)
+	fontFileURI = strings.TrimSpace(fontFileURI)
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_caption_text: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Int("transcript_words", wordCount),
+		attribute.Int("words_per_screen", wordsPerScreen),
+		attribute.Float64("seconds_per_screen", secondsPerScreen),
+		attribute.Float64("start_seconds", startSecs),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_caption", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	if fontFileURI != "" {
+		localFontFile, fontCleanup, err := prepareInputFile(ctx, fontFileURI, "font_file", cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare font file: %v", err)), nil
+		}
+		defer fontCleanup()
+		if !filterPathSafe(localFontFile) {
+			return invalidParamResult("font_file", "the path must not contain quotes or backslashes, got '%s'", localFontFile), nil
+		}
+		style.FontFile = localFontFile
+	}
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	videoDuration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to determine input duration: %v", err)), nil
+	}
+	if startSecs >= videoDuration {
+		return invalidParamResult("start_seconds", "must be before the end of the video (%.2fs), got %g", videoDuration, startSecs), nil
+	}
+	span.SetAttributes(attribute.Float64("input_duration_secs", videoDuration))
+
+	screens := buildCaptionScreens(transcript, wordsPerScreen, charsPerLine, startSecs, secondsPerScreen, videoDuration-startSecs)
+	var notes []string
+	if secondsPerScreen > 0 {
+		shown := len(screens)
+		for shown > 0 && screens[shown-1].StartSecs >= videoDuration {
+			shown--
+		}
+		if shown < len(screens) {
+			notes = append(notes, fmt.Sprintf("The video ends at %.2fs, so the last %d of %d screens were left out; lower seconds_per_screen or raise words_per_screen to fit the whole transcript.", videoDuration, len(screens)-shown, len(screens)))
+			screens = screens[:shown]
+		}
+	} else if perScreen := (videoDuration - startSecs) * float64(min(wordsPerScreen, wordCount)) / float64(wordCount); perScreen < 1 {
+		notes = append(notes, fmt.Sprintf("Each screen is shown for only %.2fs; raise words_per_screen for captions that are easier to read.", perScreen))
+	}
+
+	textDir, err := os.MkdirTemp("", "caption_text_")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare caption text files: %v", err)), nil
+	}
+	defer os.RemoveAll(textDir)
+	if !filterPathSafe(textDir) {
+		return mcp.NewToolResultError(fmt.Sprintf("The temporary directory '%s' contains quotes or backslashes and cannot be used in a filter; set TMPDIR to a plain path.", textDir)), nil
+	}
+	textFiles := make([]string, len(screens))
+	for i, screen := range screens {
+		textFiles[i] = filepath.Join(textDir, fmt.Sprintf("screen_%03d.txt", i))
+		if err := os.WriteFile(textFiles[i], []byte(screen.Text), 0o600); err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to write caption text: %v", err)), nil
+		}
+	}
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildDrawtextArgs(localInputVideo, tempOutputFile, buildCaptionTextFilter(screens, textFiles, style))...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg caption text failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.Int("screen_count", len(screens)),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("%d caption screen(s) of up to %d words burned in from %.2fs to %.2fs in %v.", len(screens), wordsPerScreen, screens[0].StartSecs, min(screens[len(screens)-1].EndSecs, videoDuration), duration))
+	messageParts = append(messageParts, notes...)
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1+len(notes) {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addPitchShiftTool defines and registers the 'ffmpeg_pitch_shift' tool.
+func addPitchShiftTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_pitch_shift",
+		mcp.WithDescription("Shifts the pitch of an audio file up or down by a number of semitones without changing its tempo or duration, e.g. to lower a TTS voice or transpose a music bed to another key. The output keeps the input's format, sample rate and channel count."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithNumber("semitones", mcp.Required(), mcp.Description(fmt.Sprintf("Pitch shift in semitones, between -%d and %d: positive raises the pitch, negative lowers it, 12 is one octave. Fractions are allowed for fine tuning.", maxPitchShiftSemitones, maxPitchShiftSemitones))),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output audio file. Defaults to the input's format.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output audio file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output audio file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegPitchShiftHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegPitchShiftHandler handles the request to shift the pitch of an audio file.
+// The asetrate factor depends on the input's sample rate, so the input is probed before the filter is built.
+func ffmpegPitchShiftHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_pitch_shift")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_pitch_shift", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	if strings.TrimSpace(inputAudioURI) == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	semitones, ok := argsMap["semitones"].(float64)
+	if !ok {
+		return invalidParamResult("semitones", "a number of semitones is required"), nil
+	}
+	if semitones == 0 {
+		return invalidParamResult("semitones", "must not be 0; a shift of 0 semitones leaves the audio unchanged"), nil
+	}
+	if math.Abs(semitones) > maxPitchShiftSemitones {
+		return invalidParamResult("semitones", "must be between -%d and %d, got %g", maxPitchShiftSemitones, maxPitchShiftSemitones, semitones), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_pitch_shift: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.Float64("semitones", semitones),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_pitch", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputAudio)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input audio: %v", err)), nil
+	}
+	format, err := parseAudioFormat(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input audio format: %v", err)), nil
+	}
+	filter := buildPitchShiftFilter(semitones, format.SampleRate)
+	span.SetAttributes(attribute.String("filter", filter))
+
+	defaultOutputExt := "mp3"
+	inputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputAudio), "."))
+	switch inputExt {
+	case "wav", "mp3", "aac", "m4a", "ogg", "flac":
+		defaultOutputExt = inputExt
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, defaultOutputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildPitchShiftArgs(localInputAudio, tempOutputFile, filter, format, sampleFormat)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg pitch shift failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Pitch shifted by %+g semitones (%s) in %v.", semitones, filter, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addDenoiseAudioTool defines and registers the 'ffmpeg_denoise_audio' tool.
+func addDenoiseAudioTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_denoise_audio",
+		mcp.WithDescription("Removes steady background noise, such as fan or room hum, from an audio file, e.g. a voiceover recorded on a laptop. Uses FFmpeg's afftdn filter with a strength preset, or the arnndn filter with an RNNoise model file. The output keeps the input's format, sample rate and channel count."),
+		mcp.WithString("input_audio_uri", mcp.Required(), mcp.Description("URI of the input audio file (local path or gs://).")),
+		mcp.WithString("strength", mcp.DefaultString("medium"), mcp.Enum(denoiseStrengths...), mcp.Description("Optional. How much noise afftdn removes: 'light' keeps the voice most natural, 'aggressive' removes more noise but can make speech sound watery. Defaults to 'medium'. Not used with use_rnn_model_uri.")),
+		mcp.WithString("use_rnn_model_uri", mcp.Description("Optional. URI (local path or gs://) of an RNNoise model file (.rnnn). When given, the arnndn filter is used with this model instead of afftdn.")),
+		mcp.WithNumber("preview_seconds", mcp.Description("Optional. Only process the first N seconds of the input, for a quick A/B check of the settings.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output audio file. Defaults to the input's format.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output audio file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output audio file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegDenoiseAudioHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegDenoiseAudioHandler handles the request to denoise an audio file.
+// An RNNoise model is downloaded like any other input and takes precedence over the strength preset.
+func ffmpegDenoiseAudioHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_denoise_audio")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_denoise_audio", argsMap)
+
+	inputAudioURI, _ := argsMap["input_audio_uri"].(string)
+	if strings.TrimSpace(inputAudioURI) == "" {
+		return invalidParamResult("input_audio_uri", reasonRequired), nil
+	}
+	strength, _ := argsMap["strength"].(string)
+	strength = strings.ToLower(strings.TrimSpace(strength))
+	if strength == "" {
+		strength = "medium"
+	}
+	if !slices.Contains(denoiseStrengths, strength) {
+		return invalidParamResult("strength", "must be one of '%s', got '%s'", strings.Join(denoiseStrengths, "', '"), strength), nil
+	}
+	modelURI, _ := argsMap["use_rnn_model_uri"].(string)
+	modelURI = strings.TrimSpace(modelURI)
+	var previewSecs float64
+	if previewParam, ok := argsMap["preview_seconds"]; ok {
+		previewSecs, ok = previewParam.(float64)
+		if !ok || previewSecs <= 0 {
+			return invalidParamResult("preview_seconds", "must be a positive number of seconds, got %v", previewParam), nil
+		}
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_denoise_audio: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	span.SetAttributes(
+		attribute.String("input_audio_uri", inputAudioURI),
+		attribute.String("strength", strength),
+		attribute.String("use_rnn_model_uri", modelURI),
+		attribute.Float64("preview_seconds", previewSecs),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputAudio, inputCleanup, err := prepareInputFile(ctx, inputAudioURI, "input_audio_denoise", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input audio: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	var localModelFile string
+	if modelURI != "" {
+		var modelCleanup func()
+		localModelFile, modelCleanup, err = prepareInputFile(ctx, modelURI, "rnn_model", cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare RNN model file: %v", err)), nil
+		}
+		defer modelCleanup()
+		if !filterPathSafe(localModelFile) {
+			return invalidParamResult("use_rnn_model_uri", "the path must not contain quotes or backslashes, got '%s'", localModelFile), nil
+		}
+	}
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputAudio)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input audio: %v", err)), nil
+	}
+	format, err := parseAudioFormat(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input audio format: %v", err)), nil
+	}
+	filter := buildDenoiseFilter(strength, localModelFile)
+	span.SetAttributes(attribute.String("filter", filter))
+
+	defaultOutputExt := "mp3"
+	inputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localInputAudio), "."))
+	switch inputExt {
+	case "wav", "mp3", "aac", "m4a", "ogg", "flac":
+		defaultOutputExt = inputExt
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, defaultOutputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildDenoiseArgs(localInputAudio, tempOutputFile, filter, format, previewSecs, sampleFormat)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg denoise failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	method := fmt.Sprintf("afftdn, %s", strength)
+	if modelURI != "" {
+		method = fmt.Sprintf("arnndn, model %s", modelURI)
+	}
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Audio denoised (%s) in %v.", method, duration))
+	if previewSecs > 0 {
+		messageParts = append(messageParts, fmt.Sprintf("Preview of the first %gs only.", previewSecs))
+	}
+	headerParts := len(messageParts)
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == headerParts {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+const (
+	maxKenBurnsDurationSecs = 60
+	maxKenBurnsFPS          = 60
+	maxKenBurnsDimension    = 3840
+)
+
+// addKenBurnsTool defines and registers the 'ffmpeg_ken_burns' tool.
+func addKenBurnsTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_ken_burns",
+		mcp.WithDescription("Turns a still image into a video with a slow Ken Burns zoom or pan, e.g. to animate a generated image. The image is cropped to fill the output resolution. The output is a silent H.264 MP4."),
+		mcp.WithString("input_image_uri", mcp.Required(), mcp.Description("URI of the input image file (local path or gs://).")),
+		mcp.WithNumber("duration_seconds", mcp.DefaultNumber(5), mcp.Description(fmt.Sprintf("Optional. Length of the video in seconds, up to %d. Defaults to 5.", maxKenBurnsDurationSecs))),
+		mcp.WithString("direction", mcp.DefaultString("zoom_in"), mcp.Enum(kenBurnsDirections...), mcp.Description(fmt.Sprintf("Optional. Camera move: 'zoom_in' and 'zoom_out' zoom on the center between 1x and %gx; the pans move across the image at a fixed %gx zoom. Defaults to 'zoom_in'.", kenBurnsMaxZoom, kenBurnsMaxZoom))),
+		mcp.WithNumber("fps", mcp.DefaultNumber(30), mcp.Description(fmt.Sprintf("Optional. Frame rate of the output video, up to %d. Defaults to 30.", maxKenBurnsFPS))),
+		mcp.WithString("resolution", mcp.DefaultString("1920x1080"), mcp.Description(fmt.Sprintf("Optional. Output size as WIDTHxHEIGHT with even numbers up to %d, e.g. '1080x1920' for portrait. Defaults to '1920x1080'.", maxKenBurnsDimension))),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'ken_burns.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegKenBurnsHandler(ctx, request, cfg)
+	})
+}
+
+// parseResolution reads a WIDTHxHEIGHT resolution. Both sides must be even, as H.264 with 4:2:0
+// chroma requires, and at most maxDimension pixels.
+func parseResolution(resolution string, maxDimension int) (width, height int, ok bool) {
+	var rest string
+	n, _ := fmt.Sscanf(strings.ToLower(strings.TrimSpace(resolution)), "%dx%d%s", &width, &height, &rest)
+	if n != 2 || width <= 0 || height <= 0 || width%2 != 0 || height%2 != 0 || width > maxDimension || height > maxDimension {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// ffmpegKenBurnsHandler handles the request to animate a still image with a Ken Burns move.
+func ffmpegKenBurnsHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_ken_burns")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_ken_burns", argsMap)
+
+	inputImageURI, _ := argsMap["input_image_uri"].(string)
+	if strings.TrimSpace(inputImageURI) == "" {
+		return invalidParamResult("input_image_uri", reasonRequired), nil
+	}
+	durationSecs := 5.0
+	if durationParam, ok := argsMap["duration_seconds"]; ok {
+		durationSecs, ok = durationParam.(float64)
+		if !ok || durationSecs <= 0 || durationSecs > maxKenBurnsDurationSecs {
+			return invalidParamResult("duration_seconds", "must be a positive number of seconds up to %d, got %v", maxKenBurnsDurationSecs, durationParam), nil
+		}
+	}
+	direction, _ := argsMap["direction"].(string)
+	direction = strings.ToLower(strings.TrimSpace(direction))
+	if direction == "" {
+		direction = "zoom_in"
+	}
+	if !slices.Contains(kenBurnsDirections, direction) {
+		return invalidParamResult("direction", "must be one of '%s', got '%s'", strings.Join(kenBurnsDirections, "', '"), direction), nil
+	}
+	fps := 30
+	if fpsParam, ok := argsMap["fps"]; ok {
+		fpsValue, ok := fpsParam.(float64)
+		if !ok || fpsValue != math.Trunc(fpsValue) || fpsValue < 1 || fpsValue > maxKenBurnsFPS {
+			return invalidParamResult("fps", "must be a whole number from 1 to %d, got %v", maxKenBurnsFPS, fpsParam), nil
+		}
+		fps = int(fpsValue)
+	}
+	width, height := 1920, 1080
+	if resolution, _ := argsMap["resolution"].(string); strings.TrimSpace(resolution) != "" {
+		var ok bool
+		if width, height, ok = parseResolution(resolution, maxKenBurnsDimension); !ok {
+			return invalidParamResult("resolution", "must be WIDTHxHEIGHT with even numbers up to %d, got '%s'", maxKenBurnsDimension, resolution), nil
+		}
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_ken_burns: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	frames := kenBurnsFrameCount(fps, durationSecs)
+	filter := buildKenBurnsFilter(direction, width, height, fps, durationSecs)
+	span.SetAttributes(
+		attribute.String("input_image_uri", inputImageURI),
+		attribute.String("direction", direction),
+		attribute.Float64("duration_seconds", durationSecs),
+		attribute.Int("fps", fps),
+		attribute.String("resolution", fmt.Sprintf("%dx%d", width, height)),
+		attribute.String("filter", filter),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputImage, imageCleanup, err := prepareInputFile(ctx, inputImageURI, "input_image_ken_burns", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input image: %v", err)), nil
+	}
+	defer imageCleanup()
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildKenBurnsArgs(localInputImage, tempOutputFile, filter, frames)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg Ken Burns render failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Ken Burns '%s' video of %d frames (%gs at %d fps, %dx%d) rendered in %v.", direction, frames, durationSecs, fps, width, height, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+const (
+	defaultThumbnailWindowSecs = 2.0
+	maxThumbnailWindowSecs     = 30
+)
+
+// addGenerateThumbnailTool defines and registers the 'ffmpeg_generate_thumbnail' tool.
+func addGenerateThumbnailTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_generate_thumbnail",
+		mcp.WithDescription(fmt.Sprintf("Extracts a representative frame of a video as a JPEG or PNG poster image, using FFmpeg's thumbnail filter to score %d frames sampled across the video. With near_timestamp, only a window around that moment is searched, e.g. to pick a header image for a specific scene. Returns the time of the chosen frame.", thumbnailCandidates)),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithNumber("near_timestamp", mcp.Description("Optional. Time in seconds to search around. When omitted, the whole video is searched.")),
+		mcp.WithNumber("window_seconds", mcp.DefaultNumber(defaultThumbnailWindowSecs), mcp.Description(fmt.Sprintf("Optional. Seconds searched either side of near_timestamp, up to %d. Defaults to %g. Ignored without near_timestamp.", maxThumbnailWindowSecs, defaultThumbnailWindowSecs))),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output image (e.g., 'poster.jpg'). A '.png' name writes a PNG; otherwise the image is a JPEG.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output image.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output image to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegGenerateThumbnailHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegGenerateThumbnailHandler handles the request to extract a poster frame from a video.
+func ffmpegGenerateThumbnailHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_generate_thumbnail")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_generate_thumbnail", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	nearTimestamp := -1.0
+	if nearParam, ok := argsMap["near_timestamp"]; ok && nearParam != nil {
+		nearTimestamp, ok = nearParam.(float64)
+		if !ok || nearTimestamp < 0 {
+			return invalidParamResult("near_timestamp", "must be a non-negative number of seconds, got %v", nearParam), nil
+		}
+	}
+	windowSecs := defaultThumbnailWindowSecs
+	if windowParam, ok := argsMap["window_seconds"]; ok {
+		windowSecs, ok = windowParam.(float64)
+		if !ok || windowSecs <= 0 || windowSecs > maxThumbnailWindowSecs {
+			return invalidParamResult("window_seconds", "must be a positive number of seconds up to %d, got %v", maxThumbnailWindowSecs, windowParam), nil
+		}
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputExt := "jpg"
+	switch ext := strings.ToLower(filepath.Ext(outputFileName)); ext {
+	case "", ".jpg", ".jpeg":
+	case ".png":
+		outputExt = "png"
+	default:
+		return invalidParamResult("output_file_name", "must end in '.jpg', '.jpeg' or '.png', got '%s'", outputFileName), nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryGeneral); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_generate_thumbnail: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Float64("near_timestamp", nearTimestamp),
+		attribute.Float64("window_seconds", windowSecs),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_thumbnail", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	videoDuration, err := probeMediaDuration(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read the duration of the input video: %v", err)), nil
+	}
+	if nearTimestamp >= videoDuration {
+		return invalidParamResult("near_timestamp", "must be before the end of the %.3fs video, got %g", videoDuration, nearTimestamp), nil
+	}
+	window := thumbnailSearchWindow(nearTimestamp, windowSecs, videoDuration)
+	span.SetAttributes(attribute.Float64("window_start", window.Start), attribute.Float64("window_end", window.End))
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, outputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	output, ffmpegErr := runFFmpegCommand(ctx, buildThumbnailArgs(localInputVideo, tempOutputFile, window)...)
+	if ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg thumbnail extraction failed: %v", ffmpegErr)), nil
+	}
+	frameTime, ok := parseThumbnailTime(output)
+	if !ok {
+		err := fmt.Errorf("no frame was selected between %.3fs and %.3fs", window.Start, window.End)
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg thumbnail extraction failed: %v", err)), nil
+	}
+	chosenTime := window.Start + frameTime
+	span.SetAttributes(attribute.Float64("chosen_time", chosenTime))
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Thumbnail extracted at %.3fs, chosen from %.3fs-%.3fs of the video, in %v.", chosenTime, window.Start, window.End, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+const (
+	defaultBlurFillSigma = 20.0
+	maxBlurFillSigma     = 100
+)
+
+// addBlurFillVerticalTool defines and registers the 'ffmpeg_blur_fill_vertical' tool.
+func addBlurFillVerticalTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_blur_fill_vertical",
+		mcp.WithDescription("Reframes a video to another aspect ratio, such as 9:16 for stories and shorts, without cropping or black bars: the whole picture is centered over a blurred, enlarged copy of itself that fills the frame. Returns the output dimensions."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithString("aspect_ratio", mcp.DefaultString("9:16"), mcp.Description("Optional. Target aspect ratio as W:H, e.g. '9:16' or '4:5'. The output's longer side is the input's longer side. Defaults to '9:16'.")),
+		mcp.WithNumber("blur_sigma", mcp.DefaultNumber(defaultBlurFillSigma), mcp.Description(fmt.Sprintf("Optional. Strength of the background blur, as the gblur sigma in pixels, up to %d. Defaults to %g.", maxBlurFillSigma, defaultBlurFillSigma))),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'story.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegBlurFillVerticalHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegBlurFillVerticalHandler handles the request to reframe a video over a blurred copy of itself.
+func ffmpegBlurFillVerticalHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_blur_fill_vertical")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_blur_fill_vertical", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	aspectRatio, _ := argsMap["aspect_ratio"].(string)
+	if strings.TrimSpace(aspectRatio) == "" {
+		aspectRatio = "9:16"
+	}
+	aspectW, aspectH, ok := parseAspectRatio(aspectRatio)
+	if !ok {
+		return invalidParamResult("aspect_ratio", "must be W:H with positive whole numbers, e.g. '9:16', got '%s'", aspectRatio), nil
+	}
+	sigma := defaultBlurFillSigma
+	if sigmaParam, ok := argsMap["blur_sigma"]; ok {
+		sigma, ok = sigmaParam.(float64)
+		if !ok || sigma <= 0 || sigma > maxBlurFillSigma {
+			return invalidParamResult("blur_sigma", "must be a positive number up to %d, got %v", maxBlurFillSigma, sigmaParam), nil
+		}
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_blur_fill_vertical: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.String("aspect_ratio", fmt.Sprintf("%d:%d", aspectW, aspectH)),
+		attribute.Float64("blur_sigma", sigma),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_blur_fill", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	width, height := blurFillDimensions(geometry.Width, geometry.Height, aspectW, aspectH)
+	filter := buildBlurFillFilter(width, height, sigma)
+	span.SetAttributes(
+		attribute.String("input_resolution", fmt.Sprintf("%dx%d", geometry.Width, geometry.Height)),
+		attribute.String("output_resolution", fmt.Sprintf("%dx%d", width, height)),
+		attribute.String("filter", filter),
+	)
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildBlurFillArgs(localInputVideo, tempOutputFile, filter)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg blur fill failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Video reframed from %dx%d to %dx%d (%d:%d) over a blurred background in %v.", geometry.Width, geometry.Height, width, height, aspectW, aspectH, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addDetectAnomaliesTool defines and registers the 'ffmpeg_detect_anomalies' tool.
+// This tool reports the black and frozen stretches of a video, e.g. to QA generated clips.
+func addDetectAnomaliesTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_detect_anomalies",
+		mcp.WithDescription("Detects black frames and freeze frames in a video with FFmpeg's blackdetect and freezedetect filters. Returns the intervals of each as JSON (start, end and duration in seconds) without writing any output file."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithNumber("min_duration", mcp.DefaultNumber(1), mcp.Description("Optional. Minimum length, in seconds, of a black or frozen stretch for it to be reported. Defaults to 1.")),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegDetectAnomaliesHandler(ctx, request, cfg)
+	})
+}
+
+// videoAnomalyReport is the structured result of ffmpeg_detect_anomalies. Duration is the length
+// of the input, or 0 when FFMpeg did not report it.
+type videoAnomalyReport struct {
+	BlackFrames  []videoAnomaly `json:"black_frames"`
+	FreezeFrames []videoAnomaly `json:"freeze_frames"`
+	MinDuration  float64        `json:"min_duration"`
+	Duration     float64        `json:"duration"`
+}
+
+// ffmpegDetectAnomaliesHandler is the handler for the anomaly detection tool.
+// It runs blackdetect and freezedetect in a single decode pass and parses both filters' output.
+func ffmpegDetectAnomaliesHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_detect_anomalies")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_detect_anomalies", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	minDuration, ok := argsMap["min_duration"].(float64)
+	if !ok {
+		minDuration = 1
+	}
+	if minDuration <= 0 {
+		return invalidParamResult("min_duration", "must be a positive number of seconds, got %v", minDuration), nil
+	}
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Float64("min_duration", minDuration),
+	)
+
+	localInputVideo, inputCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_anomalies", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer inputCleanup()
+
+	detectOutput, ffmpegErr := executeDetectAnomalies(ctx, localInputVideo, minDuration)
+	if ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg anomaly detection failed: %v", ffmpegErr)), nil
+	}
+	report := videoAnomalyReport{
+		BlackFrames:  parseBlackDetectOutput(detectOutput),
+		FreezeFrames: parseFreezeDetectOutput(detectOutput),
+		MinDuration:  minDuration,
+	}
+	if totalDuration, found := parseFFmpegDuration(detectOutput); found {
+		report.Duration = totalDuration
+		closeOpenAnomalies(report.FreezeFrames, totalDuration)
+	}
+	// Encode empty results as [] rather than null.
+	if report.BlackFrames == nil {
+		report.BlackFrames = []videoAnomaly{}
+	}
+	if report.FreezeFrames == nil {
+		report.FreezeFrames = []videoAnomaly{}
+	}
+
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.Int("black_frame_count", len(report.BlackFrames)),
+		attribute.Int("freeze_frame_count", len(report.FreezeFrames)),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+	log.Printf("Detected %d black and %d frozen intervals in %s", len(report.BlackFrames), len(report.FreezeFrames), inputVideoURI)
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to encode the anomaly report: %v", err)), nil
+	}
+	message := fmt.Sprintf("Anomaly detection completed in %v. Found %d black and %d frozen interval(s) of at least %gs: %s",
+		duration, len(report.BlackFrames), len(report.FreezeFrames), minDuration, reportJSON)
+	return mcp.NewToolResultStructured(report, message), nil
+}
+
+// addDuckAudioTool defines and registers the 'ffmpeg_duck_audio' tool.
+// This tool mixes a voiceover over music, lowering the music wherever the voice is speaking.
+func addDuckAudioTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_duck_audio",
+		mcp.WithDescription("Mixes a voiceover over a music bed and automatically lowers (ducks) the music while the voice is speaking, using sidechain compression driven by the voice. The output lasts as long as the longer input."),
+		mcp.WithString("voice_audio_uri", mcp.Required(), mcp.Description("URI of the voice audio file that drives the ducking (local path or gs://).")),
+		mcp.WithString("music_audio_uri", mcp.Required(), mcp.Description("URI of the music audio file to duck (local path or gs://).")),
+		mcp.WithNumber("threshold_db", mcp.DefaultNumber(defaultDuckSettings.ThresholdDB), mcp.Description("Optional. Voice level in dBFS above which the music is ducked, between -60 and 0. Defaults to -30.")),
+		mcp.WithNumber("ratio", mcp.DefaultNumber(defaultDuckSettings.Ratio), mcp.Description("Optional. Compression ratio applied to the music while ducked, between 1 and 20; higher ducks harder. Defaults to 8.")),
+		mcp.WithNumber("attack_ms", mcp.DefaultNumber(defaultDuckSettings.AttackMs), mcp.Description("Optional. Time in milliseconds for the music to duck once the voice starts, between 0.01 and 2000. Defaults to 20.")),
+		mcp.WithNumber("release_ms", mcp.DefaultNumber(defaultDuckSettings.ReleaseMs), mcp.Description("Optional. Time in milliseconds for the music to come back once the voice stops, between 0.01 and 9000. Defaults to 400.")),
+		mcp.WithNumber("music_base_gain_db", mcp.DefaultNumber(defaultDuckSettings.MusicBaseGainDB), mcp.Description("Optional. Gain in dB applied to the music before ducking, between -40 and 20 (e.g., -6 to sit the bed lower overall). Defaults to 0.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output audio file. Defaults to the voice input's format.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output audio file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output audio file to.")),
+		withSampleFormatParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegDuckAudioHandler(ctx, request, cfg)
+	})
+}
+
+// duckSettingsArg reads the compressor settings, falling back to defaultDuckSettings for the ones
+// not given, and checks each against the range sidechaincompress accepts.
+func duckSettingsArg(argsMap map[string]interface{}) (duckSettings, *mcp.CallToolResult) {
+	settings := defaultDuckSettings
+	params := []struct {
+		field    string
+		value    *float64
+		min, max float64
+	}{
+		{"threshold_db", &settings.ThresholdDB, -60, 0},
+		{"ratio", &settings.Ratio, 1, 20},
+		{"attack_ms", &settings.AttackMs, 0.01, 2000},
+		{"release_ms", &settings.ReleaseMs, 0.01, 9000},
+		{"music_base_gain_db", &settings.MusicBaseGainDB, -40, 20},
+	}
+	for _, p := range params {
+		raw, ok := argsMap[p.field]
+		if !ok {
+			continue
+		}
+		v, ok := raw.(float64)
+		if !ok || v < p.min || v > p.max {
+			return settings, invalidParamResult(p.field, "must be a number from %g to %g, got %v", p.min, p.max, raw)
+		}
+		*p.value = v
+	}
+	return settings, nil
+}
+
+// ffmpegDuckAudioHandler handles the request to duck music under a voiceover.
+// It probes both inputs for their format and duration, pads the shorter one and renders the mix in one pass.
+func ffmpegDuckAudioHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_duck_audio")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_duck_audio", argsMap)
+
+	voiceAudioURI, _ := argsMap["voice_audio_uri"].(string)
+	if strings.TrimSpace(voiceAudioURI) == "" {
+		return invalidParamResult("voice_audio_uri", reasonRequired), nil
+	}
+	musicAudioURI, _ := argsMap["music_audio_uri"].(string)
+	if strings.TrimSpace(musicAudioURI) == "" {
+		return invalidParamResult("music_audio_uri", reasonRequired), nil
+	}
+	settings, invalid := duckSettingsArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryAudio); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_duck_audio: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+	sampleFormat, invalid := sampleFormatArg(argsMap)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	span.SetAttributes(
+		attribute.String("voice_audio_uri", voiceAudioURI),
+		attribute.String("music_audio_uri", musicAudioURI),
+		attribute.String("duck_settings", settings.String()),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localVoiceAudio, voiceCleanup, err := prepareInputFile(ctx, voiceAudioURI, "input_voice_duck", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare voice audio: %v", err)), nil
+	}
+	defer voiceCleanup()
+	localMusicAudio, musicCleanup, err := prepareInputFile(ctx, musicAudioURI, "input_music_duck", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare music audio: %v", err)), nil
+	}
+	defer musicCleanup()
+
+	var formats [2]audioFormat
+	var durations [2]float64
+	for i, input := range []struct{ name, path string }{{"voice", localVoiceAudio}, {"music", localMusicAudio}} {
+		mediaInfoJSON, err := executeGetMediaInfo(ctx, input.path)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to probe %s audio: %v", input.name, err)), nil
+		}
+		if formats[i], err = parseAudioFormat(mediaInfoJSON); err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read %s audio format: %v", input.name, err)), nil
+		}
+		if durations[i], err = parseMediaDuration(mediaInfoJSON); err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read %s audio duration: %v", input.name, err)), nil
+		}
+	}
+	format := duckOutputFormat(formats[0], formats[1])
+	filter := buildDuckFilter(settings, durations[0], durations[1], format)
+	span.SetAttributes(attribute.String("filter_complex", filter))
+
+	defaultOutputExt := "mp3"
+	switch inputExt := strings.ToLower(strings.TrimPrefix(filepath.Ext(localVoiceAudio), ".")); inputExt {
+	case "wav", "mp3", "aac", "m4a", "ogg", "flac":
+		defaultOutputExt = inputExt
+	}
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, defaultOutputExt)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildDuckArgs(localVoiceAudio, localMusicAudio, tempOutputFile, filter, format, sampleFormat)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg audio ducking failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Music ducked under the voice (%s) in %v.", settings, duration))
+	messageParts = append(messageParts, fmt.Sprintf("Output is %.3fs at %d Hz, %d ch.", math.Max(durations[0], durations[1]), format.SampleRate, format.Channels))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 2 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// addSpeedRampTool defines and registers the 'ffmpeg_speed_ramp' tool.
+// This tool changes a video's playback speed over time, e.g. slow motion easing into fast forward.
+func addSpeedRampTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_speed_ramp",
+		mcp.WithDescription("Speed-ramps a video: the playback speed follows keyframes set at times in the input, changing smoothly between them, e.g. from slow motion into fast forward. Returns the output duration."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithArray("keyframes", mcp.Required(), mcp.Description(fmt.Sprintf("Speed keyframes in increasing order of time. Each is an object with 'time' (seconds into the input) and 'speed' (playback speed from %g to %g, where 0.5 is half speed and 2 is double speed). The speed changes linearly between keyframes and holds before the first and after the last.", minSpeedRampSpeed, float64(maxSpeedRampSpeed))),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"time":  map[string]any{"type": "number"},
+					"speed": map[string]any{"type": "number"},
+				},
+				"required": []string{"time", "speed"},
+			})),
+		mcp.WithBoolean("smooth_slow_motion", mcp.DefaultBool(false), mcp.Description("Optional. Synthesizes in-between frames with motion interpolation (minterpolate) instead of repeating frames in the slow parts. Much slower to render. Defaults to false.")),
+		mcp.WithString("audio", mcp.DefaultString("drop"), mcp.Enum("drop", "retime"), mcp.Description("Optional. 'drop' removes the audio; 'retime' time-stretches it between keyframes to stay in step with the video, keeping its pitch. Defaults to 'drop'.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'ramped.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withPlatformParam(),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegSpeedRampHandler(ctx, request, cfg)
+	})
+}
+
+// parseSpeedKeyframes reads the 'keyframes' argument. Failures name the offending entry, e.g. 'keyframes[1].speed'.
+func parseSpeedKeyframes(raw []interface{}) ([]speedKeyframe, *validationError) {
+	if len(raw) == 0 {
+		return nil, newValidationError("keyframes", "at least one keyframe is required")
+	}
+	var keyframes []speedKeyframe
+	for i, item := range raw {
+		field := fmt.Sprintf("keyframes[%d]", i)
+		keyframeMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, newValidationError(field, "must be an object with 'time' and 'speed'")
+		}
+		var kf speedKeyframe
+		kf.Time, ok = keyframeMap["time"].(float64)
+		if !ok || kf.Time < 0 {
+			return nil, newValidationError(field+".time", "a non-negative number of seconds is required")
+		}
+		if i > 0 && kf.Time <= keyframes[i-1].Time {
+			return nil, newValidationError(field+".time", "must be after the previous keyframe's %g seconds, got %g", keyframes[i-1].Time, kf.Time)
+		}
+		kf.Speed, ok = keyframeMap["speed"].(float64)
+		if !ok || kf.Speed < minSpeedRampSpeed || kf.Speed > maxSpeedRampSpeed {
+			return nil, newValidationError(field+".speed", "must be a number from %g to %g, got %v", minSpeedRampSpeed, float64(maxSpeedRampSpeed), keyframeMap["speed"])
+		}
+		keyframes = append(keyframes, kf)
+	}
+	return keyframes, nil
+}
+
+// ffmpegSpeedRampHandler handles the request to speed-ramp a video.
+// It probes the input for its duration, frame rate and audio, then renders the retimed video in one pass.
+func ffmpegSpeedRampHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_speed_ramp")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_speed_ramp", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	if strings.TrimSpace(inputVideoURI) == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	keyframesRaw, ok := argsMap["keyframes"].([]interface{})
+	if !ok {
+		return invalidParamResult("keyframes", "an array of {time, speed} objects is required"), nil
+	}
+	keyframes, validationErr := parseSpeedKeyframes(keyframesRaw)
+	if validationErr != nil {
+		return validationErrorResult(validationErr), nil
+	}
+	smooth, _ := argsMap["smooth_slow_motion"].(bool)
+	audioMode, _ := argsMap["audio"].(string)
+	audioMode = strings.ToLower(strings.TrimSpace(audioMode))
+	if audioMode == "" {
+		audioMode = "drop"
+	}
+	if audioMode != "drop" && audioMode != "retime" {
+		return invalidParamResult("audio", "must be 'drop' or 'retime', got '%s'", audioMode), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	platform, outputFileName, platformErr := platformArg(argsMap, outputFileName)
+	if platformErr != nil {
+		return platformErr, nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_speed_ramp: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Int("keyframe_count", len(keyframes)),
+		attribute.Bool("smooth_slow_motion", smooth),
+		attribute.String("audio", audioMode),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_speed_ramp", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	videoDuration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video duration: %v", err)), nil
+	}
+	if keyframes[0].Time >= videoDuration {
+		return invalidParamResult("keyframes[0].time", "must be before the end of the %.3fs input video, got %g", videoDuration, keyframes[0].Time), nil
+	}
+	retimeAudio := audioMode == "retime" && geometry.HasAudio
+	var notes []string
+	if audioMode == "retime" && !geometry.HasAudio {
+		notes = append(notes, "The input has no audio track to retime.")
+	}
+
+	segments := speedRampSegments(keyframes)
+	outputDuration := speedRampOutputTime(segments, videoDuration)
+	filter := buildSpeedRampFilter(segments, videoDuration, geometry.FrameRate, smooth, retimeAudio)
+	span.SetAttributes(
+		attribute.Float64("output_duration_seconds", outputDuration),
+		attribute.String("filter_complex", filter),
+	)
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildSpeedRampArgs(localInputVideo, tempOutputFile, filter, retimeAudio)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg speed ramp failed: %v", ffmpegErr)), nil
+	}
+
+	if platformErr := applyPlatform(ctx, tempOutputFile, platform); platformErr != nil {
+		return platformErr, nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Video speed-ramped over %d keyframe(s) from %.3fs to %.3fs in %v.", len(keyframes), videoDuration, outputDuration, duration))
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	if len(messageParts) == 1 {
+		messageParts = append(messageParts, "No specific output location requested beyond temporary processing.")
+	}
+	messageParts = append(messageParts, notes...)
+	if platform != nil {
+		messageParts = append(messageParts, platform.summary())
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
+
+// exportFFmpegOutput runs one FFmpeg step into a temporary file named after outputName and then
+// moves/uploads the result like any other tool output. It is used by tools that produce several files.
+func exportFFmpegOutput(ctx context.Context, outputName, outputLocalDir string, outputGCSBuckets []string, projectID string, run func(tempOutputFile string) error) (string, []common.GCSUploadResult, error) {
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputName, strings.TrimPrefix(filepath.Ext(outputName), "."))
+	if err != nil {
+		return "", nil, err
+	}
+	defer outputCleanup()
+
+	if err := run(tempOutputFile); err != nil {
+		return "", nil, err
+	}
+	return processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, projectID)
+}
+
+// withOutputGCSBucketsParam is the shared 'output_gcs_buckets' tool option, which lets a single
+// call upload its output to several buckets (e.g., in different regions for CDN pre-warming).
+func withOutputGCSBucketsParam() mcp.ToolOption {
+	return mcp.WithArray("output_gcs_buckets",
+		mcp.Description("Optional. Additional GCS buckets to upload the output file to, concurrently. Combined with output_gcs_bucket; failures are reported per bucket."),
+		mcp.WithStringItems(),
+	)
+}
+
+// withSampleFormatParam adds the 'sample_format' parameter of the audio tools that can write WAV.
+func withSampleFormatParam() mcp.ToolOption {
+	return mcp.WithString("sample_format",
+		mcp.Enum(wavSampleFormatNames...),
+		mcp.Description("Optional. Sample format of WAV output: 's16' (16-bit), 's24' (24-bit, e.g. for mastering), 's32' (32-bit) or 'flt' (32-bit float). Defaults to 's16', or to the input's PCM format for tools that keep the input's format. Ignored for other output formats."),
+	)
+}
+
+// sampleFormatArg reads the optional 'sample_format' argument; "" means it was not given.
+func sampleFormatArg(argsMap map[string]interface{}) (string, *mcp.CallToolResult) {
+	sampleFormat, _ := argsMap["sample_format"].(string)
+	sampleFormat = strings.ToLower(strings.TrimSpace(sampleFormat))
+	if sampleFormat != "" && !slices.Contains(wavSampleFormatNames, sampleFormat) {
+		return "", invalidParamResult("sample_format", "must be one of '%s', got '%s'", strings.Join(wavSampleFormatNames, "', '"), sampleFormat)
+	}
+	return sampleFormat, nil
+}
+
+// hasOutputGCSBuckets reports whether 'output_gcs_buckets' names at least one bucket. The default
+// bucket is only used when neither it nor 'output_gcs_bucket' is given, so a caller who lists
+// their own buckets does not also get a copy in the default one.
+func hasOutputGCSBuckets(argsMap map[string]interface{}) bool {
+	return len(collectOutputGCSBuckets("", argsMap)) > 0
+}
+
+// collectOutputGCSBuckets merges the single output bucket with the optional 'output_gcs_buckets'
+// array, trimming any gs:// prefix and dropping empty entries and duplicates.
+func collectOutputGCSBuckets(outputGCSBucket string, argsMap map[string]interface{}) []string {
+	var buckets []string
+	seen := make(map[string]bool)
+	add := func(bucket string) {
+		bucket = strings.TrimPrefix(strings.TrimSpace(bucket), "gs://")
+		bucket = strings.TrimSuffix(bucket, "/")
+		if bucket == "" || seen[bucket] {
+			return
+		}
+		seen[bucket] = true
+		buckets = append(buckets, bucket)
+	}
+	add(outputGCSBucket)
+	if rawBuckets, ok := argsMap["output_gcs_buckets"].([]interface{}); ok {
+		for _, item := range rawBuckets {
+			if bucket, ok := item.(string); ok {
+				add(bucket)
+			}
+		}
+	}
+	return buckets
+}
+
+// concatAudioExtensions are the extensions for which concatenation produces an audio file.
+var concatAudioExtensions = map[string]bool{"wav": true, "mp3": true, "aac": true, "m4a": true}
+
+// concatOutputCategory reports whether a concatenation writes audio or video, from the extension
+// of the requested output file name or, failing that, of the first input, matching how the
+// handler picks its output format.
+func concatOutputCategory(inputMediaURIs []string, outputFileName string) common.OutputCategory {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(outputFileName), "."))
+	if ext == "" && len(inputMediaURIs) > 0 {
+		ext = strings.ToLower(strings.TrimPrefix(filepath.Ext(inputMediaURIs[0]), "."))
+	}
+	if concatAudioExtensions[ext] {
+		return common.OutputCategoryAudio
+	}
+	return common.OutputCategoryVideo
+}
+
+// summarizeGCSUploads joins the GCS paths of the successful uploads and describes any
+// per-bucket failures, for inclusion in a tool's result message.
+func summarizeGCSUploads(uploads []common.GCSUploadResult) (string, string) {
+	var uploadedPaths, failures []string
+	for _, upload := range uploads {
+		if upload.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%v)", upload.Bucket, upload.Err))
+			continue
+		}
+		uploadedPaths = append(uploadedPaths, upload.GCSPath)
+	}
+	var issues string
+	if len(failures) > 0 {
+		issues = fmt.Sprintf("Upload failed for %d of %d GCS buckets: %s.", len(failures), len(uploads), strings.Join(failures, "; "))
+	}
+	return strings.Join(uploadedPaths, ", "), issues
+}
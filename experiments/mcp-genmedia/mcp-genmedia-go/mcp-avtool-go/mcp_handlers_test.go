@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
@@ -28,3 +31,391 @@ func TestFfmpegGetMediaInfoHandler(t *testing.T) {
 		t.Errorf("expected no error, but got: %v", err)
 	}
 }
+
+func TestFFmpegLogMiddleware(t *testing.T) {
+	failingHandler := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		opts := ffmpegLogOptionsFromContext(ctx)
+		if opts != nil {
+			opts.record([]string{"-loglevel", opts.Level, "-i", "in.mp4"}, "Invalid filtergraph")
+		}
+		return mcp.NewToolResultError("FFMpeg failed"), nil
+	}
+	handler := ffmpegLogMiddleware(failingHandler)
+
+	newRequest := func(args map[string]interface{}) mcp.CallToolRequest {
+		return mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+	}
+
+	t.Run("default leaves result unchanged", func(t *testing.T) {
+		result, _ := handler(context.Background(), newRequest(map[string]interface{}{}))
+		if len(result.Content) != 1 {
+			t.Errorf("expected only the original error content, but got %d items", len(result.Content))
+		}
+	})
+
+	t.Run("full log appended on failure", func(t *testing.T) {
+		result, _ := handler(context.Background(), newRequest(map[string]interface{}{
+			"ffmpeg_log_level":        "debug",
+			"include_full_ffmpeg_log": true,
+		}))
+		if len(result.Content) != 2 {
+			t.Fatalf("expected the full log to be appended, but got %d items", len(result.Content))
+		}
+		text := result.Content[1].(mcp.TextContent).Text
+		if !strings.Contains(text, "-loglevel debug") || !strings.Contains(text, "Invalid filtergraph") {
+			t.Errorf("unexpected log content: %s", text)
+		}
+	})
+
+	t.Run("invalid level is rejected", func(t *testing.T) {
+		result, _ := handler(context.Background(), newRequest(map[string]interface{}{"ffmpeg_log_level": "verbose"}))
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "ffmpeg_log_level") {
+			t.Errorf("expected a validation error, but got %+v", result)
+		}
+	})
+}
+
+func TestConcatOutputCategory(t *testing.T) {
+	testCases := []struct {
+		inputs         []string
+		outputFileName string
+		expected       common.OutputCategory
+	}{
+		{[]string{"gs://b/a.wav", "gs://b/b.wav"}, "", common.OutputCategoryAudio},
+		{[]string{"gs://b/a.mp4", "gs://b/b.mp4"}, "", common.OutputCategoryVideo},
+		{[]string{"gs://b/a.mp4"}, "soundtrack.MP3", common.OutputCategoryAudio},
+		{[]string{"gs://b/a.wav"}, "clip.mp4", common.OutputCategoryVideo},
+	}
+	for _, tc := range testCases {
+		if actual := concatOutputCategory(tc.inputs, tc.outputFileName); actual != tc.expected {
+			t.Errorf("concatOutputCategory(%v, %q) = %q, want %q", tc.inputs, tc.outputFileName, actual, tc.expected)
+		}
+	}
+}
+
+func TestHandlerValidationErrors(t *testing.T) {
+	cfg := &common.Config{}
+	testCases := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest, *common.Config) (*mcp.CallToolResult, error)
+		args    map[string]interface{}
+		field   string
+		reason  string
+	}{
+		{"missing input", ffmpegProgressBarHandler, map[string]interface{}{}, "input_video_uri", reasonRequired},
+		{"invalid position", ffmpegProgressBarHandler, map[string]interface{}{"input_video_uri": "in.mp4", "position": "left"}, "position", "must be 'top' or 'bottom', got 'left'"},
+		{"second of two inputs", ffmpegCombineAudioVideoHandler, map[string]interface{}{"input_video_uri": "in.mp4"}, "input_audio_uri", reasonRequired},
+		{"invalid layout", ffmpegSideBySideHandler, map[string]interface{}{"left_video_uri": "a.mp4", "right_video_uri": "b.mp4", "layout": "diagonal"}, "layout", "must be 'horizontal' or 'vertical', got 'diagonal'"},
+		{"missing number", ffmpegCompressToSizeHandler, map[string]interface{}{"input_video_uri": "in.mp4"}, "target_size_mb", "a positive number is required"},
+		{"offset or detect", ffmpegShiftAudioSyncHandler, map[string]interface{}{"input_video_uri": "in.mp4"}, "offset_seconds", "a value is required unless 'detect' is true"},
+		{"offset with detect", ffmpegShiftAudioSyncHandler, map[string]interface{}{"input_video_uri": "in.mp4", "detect": true, "offset_seconds": 0.2}, "offset_seconds", "cannot be combined with 'detect': true; omit it to use the detected offset"},
+		{"band entry", ffmpegEqualizerHandler, map[string]interface{}{"input_audio_uri": "in.wav", "bands": []interface{}{map[string]interface{}{"frequency": 250.0, "gain_db": -3.0}, map[string]interface{}{"frequency": 4000.0, "gain_db": 45.0}}}, "bands[1].gain_db", "must be between -30 and 30 dB, got 45"},
+		{"no eq", ffmpegEqualizerHandler, map[string]interface{}{"input_audio_uri": "in.wav"}, "bands", "at least one band, or a highpass_hz or lowpass_hz cutoff, is required"},
+		{"resample target without auto_resample", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{"a.wav", "b.wav"}, "target_channels": 2.0}, "target_channels", "requires 'auto_resample': true"},
+		{"resample target rate", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{"a.wav", "b.wav"}, "auto_resample": true, "target_sample_rate": 44.1}, "target_sample_rate", "must be a whole number of Hz from 8000 to 192000, got 44.1"},
+		{"loudness target without normalize_loudness", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{"a.mp4", "b.mp4"}, "target_lufs": -23.0}, "target_lufs", "requires 'normalize_loudness': true"},
+		{"loudness target range", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{"a.mp4", "b.mp4"}, "normalize_loudness": true, "target_lufs": 0.0}, "target_lufs", "must be a loudness from -70 to -5 LUFS, got 0"},
+		{"input stream index", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{"a.mp4", map[string]interface{}{"uri": "b.mkv", "audio_stream": 1.5}}}, "input_media_uris", "item 2: 'audio_stream' must be a non-negative integer, got 1.5"},
+		{"input object key", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{map[string]interface{}{"uri": "a.mp4", "start": 2.0}}}, "input_media_uris", "item 1: unsupported key 'start'; objects take 'uri', 'video_stream' and 'audio_stream'"},
+		{"combine audio stream", ffmpegCombineAudioVideoHandler, map[string]interface{}{"input_video_uri": "in.mp4", "input_audio_uri": "in.mkv", "audio_stream_index": -1.0}, "audio_stream_index", "must be a non-negative integer, got -1"},
+		{"editorial profile", ffmpegExportEditorialHandler, map[string]interface{}{"input_video_uri": "in.mp4", "profile": "dnxhd"}, "profile", "must be one of 'prores_422', 'prores_4444', 'png_sequence', got 'dnxhd'"},
+		{"editorial mov name", ffmpegExportEditorialHandler, map[string]interface{}{"input_video_uri": "in.mp4", "profile": "prores_422", "output_file_name": "master.mp4"}, "output_file_name", "must end in .mov for the 'prores_422' profile, got 'master.mp4'"},
+		{"editorial sequence name", ffmpegExportEditorialHandler, map[string]interface{}{"input_video_uri": "in.mp4", "profile": "png_sequence", "output_sequence_name": "../frames"}, "output_sequence_name", "must contain only letters, digits, '.', '_' and '-', got '../frames'"},
+		{"gif one image", ffmpegImagesToGifHandler, map[string]interface{}{"image_uris": []interface{}{"a.png"}}, "image_uris", "must list 2 to 500 images, got 1"},
+		{"gif mixed formats", ffmpegImagesToGifHandler, map[string]interface{}{"image_uris": []interface{}{"a.jpg", "b.jpeg", "c.png"}}, "image_uris[2]", "must be the same format as the first image (jpeg), got 'c.png'"},
+		{"gif bayer scale", ffmpegImagesToGifHandler, map[string]interface{}{"image_uris": []interface{}{"a.png", "b.png"}, "bayer_scale": 6.0}, "bayer_scale", "must be a whole number from 0 to 5, got 6"},
+		{"chapters input", ffmpegGetChaptersHandler, map[string]interface{}{"input_media_uri": " "}, "input_media_uri", reasonRequired},
+		{"cover art container", ffmpegSetCoverArtHandler, map[string]interface{}{"input_audio_uri": "episode.wav", "image_uri": "cover.jpg"}, "input_audio_uri", "must be an .mp3 or .m4a file, got 'episode.wav'"},
+		{"cover art output extension", ffmpegSetCoverArtHandler, map[string]interface{}{"input_audio_uri": "episode.mp3", "image_uri": "cover.jpg", "output_file_name": "episode.m4a"}, "output_file_name", "must end in .mp3, the input's container, got 'episode.m4a'"},
+		{"seamless loop overlap", ffmpegSeamlessLoopHandler, map[string]interface{}{"input_video_uri": "clip.mp4", "overlap_seconds": -1.0}, "overlap_seconds", "must be a positive number of seconds, got -1"},
+		{"overlay alpha container", ffmpegOverlayImageHandler, map[string]interface{}{"input_video_uri": "clip.mp4", "input_image_uri": "lower_third.webm", "output_preserve_alpha": true, "output_file_name": "out.mp4"}, "output_file_name", "must end in .webm when output_preserve_alpha is set, as the transparent output is VP9 in WebM, got 'out.mp4'"},
+		{"overlay alpha platform", ffmpegOverlayImageHandler, map[string]interface{}{"input_video_uri": "clip.mp4", "input_image_uri": "lower_third.webm", "output_preserve_alpha": true, "platform": "tiktok"}, "output_preserve_alpha", "cannot be combined with platform, whose presets write H.264 MP4 without alpha"},
+		{"visualizer audio", ffmpegAudioVisualizerHandler, map[string]interface{}{"audio_uri": " "}, "audio_uri", reasonRequired},
+		{"visualizer mode", ffmpegAudioVisualizerHandler, map[string]interface{}{"audio_uri": "song.mp3", "mode": "waveform"}, "mode", "must be one of 'cqt', 'vectorscope', got 'waveform'"},
+		{"visualizer resolution", ffmpegAudioVisualizerHandler, map[string]interface{}{"audio_uri": "song.mp3", "resolution": "1281x720"}, "resolution", "must be WIDTHxHEIGHT with even numbers up to 3840, got '1281x720'"},
+		{"visualizer color", ffmpegAudioVisualizerHandler, map[string]interface{}{"audio_uri": "song.mp3", "color": "cyan;drawtext"}, "color", "must be an FFmpeg color name or hex value such as 'cyan' or '#FF0050', got 'cyan;drawtext'"},
+		{"batch operation", ffmpegBatchHandler, map[string]interface{}{"operation": "ffmpeg_batch", "inputs": []interface{}{map[string]interface{}{}}}, "operation", "must be one of the avtool tools other than ffmpeg_batch, got 'ffmpeg_batch'"},
+		{"batch item", ffmpegBatchHandler, map[string]interface{}{"operation": "ffmpeg_trim_media", "inputs": []interface{}{map[string]interface{}{}, "clip.mp4"}}, "inputs[1]", "must be an object, got string"},
+		{"batch concurrency", ffmpegBatchHandler, map[string]interface{}{"operation": "ffmpeg_trim_media", "inputs": []interface{}{map[string]interface{}{}}, "concurrency": 16.0}, "concurrency", "must be a whole number from 1 to 8, got 16"},
+		{"list with array", ffmpegConcatenateMediaHandler, map[string]interface{}{"input_media_uris": []interface{}{"a.mp4"}, "input_list_uri": "list.txt"}, "input_list_uri", "cannot be combined with input_media_uris"},
+		{"countdown duration", ffmpegCountdownOverlayHandler, map[string]interface{}{"input_video_uri": "in.mp4"}, "duration_seconds", "a positive number is required"},
+		{"countdown position", ffmpegCountdownOverlayHandler, map[string]interface{}{"input_video_uri": "in.mp4", "duration_seconds": 10.0, "position": "middle"}, "position", "must be one of 'center', 'top_left', 'top_center', 'top_right', 'bottom_left', 'bottom_center', 'bottom_right', got 'middle'"},
+		{"trim range", ffmpegTrimMediaHandler, map[string]interface{}{"input_media_uri": "in.mp3", "start_seconds": 12.0, "end_seconds": 5.0}, "end_seconds", "must be greater than start_seconds (12), got 5"},
+		{"hls renditions", ffmpegPackageHLSHandler, map[string]interface{}{"input_video_uri": "in.mp4"}, "renditions", "at least one rendition is required"},
+		{"hls odd height", ffmpegPackageHLSHandler, map[string]interface{}{"input_video_uri": "in.mp4", "renditions": []interface{}{map[string]interface{}{"height": 1080.0, "bitrate": "5M"}, map[string]interface{}{"height": 721.0, "bitrate": "3M"}}}, "renditions[1].height", "a positive even number of pixels is required"},
+		{"hls bitrate", ffmpegPackageHLSHandler, map[string]interface{}{"input_video_uri": "in.mp4", "renditions": []interface{}{map[string]interface{}{"height": 720.0, "bitrate": "fast"}}}, "renditions[0].bitrate", "must be a bitrate such as '5M', '800k' or '2500000', got 'fast'"},
+		{"caption transcript", ffmpegCaptionTextHandler, map[string]interface{}{"input_video_uri": "in.mp4", "transcript": " \n "}, "transcript", reasonRequired},
+		{"caption words per screen", ffmpegCaptionTextHandler, map[string]interface{}{"input_video_uri": "in.mp4", "transcript": "hello world", "words_per_screen": 2.5}, "words_per_screen", "must be a whole number from 1 to 50, got 2.5"},
+		{"caption screen limit", ffmpegCaptionTextHandler, map[string]interface{}{"input_video_uri": "in.mp4", "transcript": strings.Repeat("word ", 301), "words_per_screen": 1.0}, "transcript", "needs 301 screens at 1 words per screen, more than the limit of 300; raise words_per_screen or caption the video in parts"},
+		{"pitch semitones", ffmpegPitchShiftHandler, map[string]interface{}{"input_audio_uri": "in.wav"}, "semitones", "a number of semitones is required"},
+		{"pitch zero", ffmpegPitchShiftHandler, map[string]interface{}{"input_audio_uri": "in.wav", "semitones": 0.0}, "semitones", "must not be 0; a shift of 0 semitones leaves the audio unchanged"},
+		{"pitch range", ffmpegPitchShiftHandler, map[string]interface{}{"input_audio_uri": "in.wav", "semitones": -30.0}, "semitones", "must be between -24 and 24, got -30"},
+		{"pitch sample format", ffmpegPitchShiftHandler, map[string]interface{}{"input_audio_uri": "in.wav", "semitones": 3.0, "sample_format": "s8"}, "sample_format", "must be one of 's16', 's24', 's32', 'flt', got 's8'"},
+		{"volume sample format", ffmpegAdjustVolumeHandler, map[string]interface{}{"input_audio_uri": "in.wav", "volume_db_change": 3.0, "sample_format": "u8"}, "sample_format", "must be one of 's16', 's24', 's32', 'flt', got 'u8'"},
+		{"denoise strength", ffmpegDenoiseAudioHandler, map[string]interface{}{"input_audio_uri": "in.wav", "strength": "extreme"}, "strength", "must be one of 'light', 'medium', 'aggressive', got 'extreme'"},
+		{"downmix input", ffmpegDownmixSurroundHandler, map[string]interface{}{"input_audio_uri": ""}, "input_audio_uri", reasonRequired},
+		{"downmix layout", ffmpegDownmixSurroundHandler, map[string]interface{}{"input_audio_uri": "in.wav", "target_layout": "7.1"}, "target_layout", "must be one of 'stereo', 'binaural', got '7.1'"},
+		{"downmix binaural sofa", ffmpegDownmixSurroundHandler, map[string]interface{}{"input_audio_uri": "in.wav", "target_layout": "binaural"}, "sofa_uri", "a SOFA file is required for the 'binaural' target_layout"},
+		{"denoise preview", ffmpegDenoiseAudioHandler, map[string]interface{}{"input_audio_uri": "in.wav", "preview_seconds": -5.0}, "preview_seconds", "must be a positive number of seconds, got -5"},
+		{"mux subtitles tracks", ffmpegMuxSubtitlesHandler, map[string]interface{}{"input_video_uri": "in.mp4", "subtitle_tracks": []interface{}{}}, "subtitle_tracks", "must list 1 to 32 tracks, got 0"},
+		{"mux subtitles format", ffmpegMuxSubtitlesHandler, map[string]interface{}{"input_video_uri": "in.mp4", "subtitle_tracks": []interface{}{map[string]interface{}{"subtitle_uri": "en.txt", "language": "en"}}}, "subtitle_tracks[0].subtitle_uri", "must be an .srt, .vtt, .ass or .ssa file, got 'en.txt'"},
+		{"mux subtitles language", ffmpegMuxSubtitlesHandler, map[string]interface{}{"input_video_uri": "in.mp4", "subtitle_tracks": []interface{}{map[string]interface{}{"subtitle_uri": "en.srt", "language": "../en"}}}, "subtitle_tracks[0].language", "must be a language code such as 'en', 'eng' or 'pt-BR', got '../en'"},
+		{"mux subtitles container", ffmpegMuxSubtitlesHandler, map[string]interface{}{"input_video_uri": "in.mp4", "output_file_name": "out.webm", "subtitle_tracks": []interface{}{map[string]interface{}{"subtitle_uri": "en.srt", "language": "en"}}}, "output_file_name", "must end in .mkv, .mp4, .m4v or .mov, got 'out.webm'"},
+		{"platform", ffmpegCombineAudioVideoHandler, map[string]interface{}{"input_video_uri": "in.mp4", "input_audio_uri": "in.wav", "platform": "vimeo"}, "platform", "must be one of 'youtube', 'instagram_reel', 'tiktok', 'x', got 'vimeo'"},
+		{"ken burns direction", ffmpegKenBurnsHandler, map[string]interface{}{"input_image_uri": "still.png", "direction": "spin"}, "direction", "must be one of 'zoom_in', 'zoom_out', 'pan_left', 'pan_right', 'pan_up', 'pan_down', got 'spin'"},
+		{"ken burns resolution", ffmpegKenBurnsHandler, map[string]interface{}{"input_image_uri": "still.png", "resolution": "1921x1080"}, "resolution", "must be WIDTHxHEIGHT with even numbers up to 3840, got '1921x1080'"},
+		{"ken burns fps", ffmpegKenBurnsHandler, map[string]interface{}{"input_image_uri": "still.png", "fps": 29.97}, "fps", "must be a whole number from 1 to 60, got 29.97"},
+		{"ken burns duration", ffmpegKenBurnsHandler, map[string]interface{}{"input_image_uri": "still.png", "duration_seconds": 0.0}, "duration_seconds", "must be a positive number of seconds up to 60, got 0"},
+		{"thumbnail near timestamp", ffmpegGenerateThumbnailHandler, map[string]interface{}{"input_video_uri": "in.mp4", "near_timestamp": -2.0}, "near_timestamp", "must be a non-negative number of seconds, got -2"},
+		{"thumbnail window", ffmpegGenerateThumbnailHandler, map[string]interface{}{"input_video_uri": "in.mp4", "near_timestamp": 12.0, "window_seconds": 45.0}, "window_seconds", "must be a positive number of seconds up to 30, got 45"},
+		{"thumbnail format", ffmpegGenerateThumbnailHandler, map[string]interface{}{"input_video_uri": "in.mp4", "output_file_name": "poster.gif"}, "output_file_name", "must end in '.jpg', '.jpeg' or '.png', got 'poster.gif'"},
+		{"blur fill aspect", ffmpegBlurFillVerticalHandler, map[string]interface{}{"input_video_uri": "in.mp4", "aspect_ratio": "vertical"}, "aspect_ratio", "must be W:H with positive whole numbers, e.g. '9:16', got 'vertical'"},
+		{"blur fill sigma", ffmpegBlurFillVerticalHandler, map[string]interface{}{"input_video_uri": "in.mp4", "blur_sigma": 250.0}, "blur_sigma", "must be a positive number up to 100, got 250"},
+		{"duck voice", ffmpegDuckAudioHandler, map[string]interface{}{"music_audio_uri": "bed.mp3"}, "voice_audio_uri", reasonRequired},
+		{"duck music", ffmpegDuckAudioHandler, map[string]interface{}{"voice_audio_uri": "vo.wav"}, "music_audio_uri", reasonRequired},
+		{"duck ratio", ffmpegDuckAudioHandler, map[string]interface{}{"voice_audio_uri": "vo.wav", "music_audio_uri": "bed.mp3", "ratio": 50.0}, "ratio", "must be a number from 1 to 20, got 50"},
+		{"duck threshold", ffmpegDuckAudioHandler, map[string]interface{}{"voice_audio_uri": "vo.wav", "music_audio_uri": "bed.mp3", "threshold_db": 3.0}, "threshold_db", "must be a number from -60 to 0, got 3"},
+		{"duck base gain", ffmpegDuckAudioHandler, map[string]interface{}{"voice_audio_uri": "vo.wav", "music_audio_uri": "bed.mp3", "music_base_gain_db": "loud"}, "music_base_gain_db", "must be a number from -40 to 20, got loud"},
+		{"speed ramp keyframes", ffmpegSpeedRampHandler, map[string]interface{}{"input_video_uri": "in.mp4"}, "keyframes", "an array of {time, speed} objects is required"},
+		{"speed ramp order", ffmpegSpeedRampHandler, map[string]interface{}{"input_video_uri": "in.mp4", "keyframes": []interface{}{map[string]interface{}{"time": 2.0, "speed": 1.0}, map[string]interface{}{"time": 1.0, "speed": 2.0}}}, "keyframes[1].time", "must be after the previous keyframe's 2 seconds, got 1"},
+		{"speed ramp speed", ffmpegSpeedRampHandler, map[string]interface{}{"input_video_uri": "in.mp4", "keyframes": []interface{}{map[string]interface{}{"time": 0.0, "speed": 0.0}}}, "keyframes[0].speed", "must be a number from 0.1 to 10, got 0"},
+		{"speed ramp audio", ffmpegSpeedRampHandler, map[string]interface{}{"input_video_uri": "in.mp4", "keyframes": []interface{}{map[string]interface{}{"time": 0.0, "speed": 2.0}}, "audio": "mute"}, "audio", "must be 'drop' or 'retime', got 'mute'"},
+		{"detect anomalies input", ffmpegDetectAnomaliesHandler, map[string]interface{}{}, "input_video_uri", reasonRequired},
+		{"detect anomalies min duration", ffmpegDetectAnomaliesHandler, map[string]interface{}{"input_video_uri": "in.mp4", "min_duration": 0.0}, "min_duration", "must be a positive number of seconds, got 0"},
+		{"tonemap algorithm", ffmpegTonemapHDRToSDRHandler, map[string]interface{}{"input_video_uri": "in.mov", "algorithm": "aces"}, "algorithm", "must be one of 'hable', 'reinhard', 'mobius', got 'aces'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tc.args}}, cfg)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if !result.IsError {
+				t.Fatalf("expected an error result, but got %+v", result)
+			}
+			expectedText := "Invalid parameter '" + tc.field + "': " + tc.reason + "."
+			if text := result.Content[0].(mcp.TextContent).Text; text != expectedText {
+				t.Errorf("expected text %q, but got %q", expectedText, text)
+			}
+			structured, _ := result.StructuredContent.(map[string]interface{})
+			errorObject, _ := structured["error"].(map[string]interface{})
+			if errorObject["type"] != "validation" || errorObject["field"] != tc.field || errorObject["reason"] != tc.reason {
+				t.Errorf("unexpected structured error: %v", result.StructuredContent)
+			}
+		})
+	}
+}
+
+func TestDetectAnomaliesHandler(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ffmpeg")
+	ffmpeg := `#!/bin/sh
+cat >&2 <<'OUT'
+  Duration: 00:00:06.00, start: 0.000000, bitrate: 1205 kb/s
+[blackdetect @ 0x1] black_start:0 black_end:1.5 black_duration:1.5
+[freezedetect @ 0x2] lavfi.freezedetect.freeze_start: 4
+OUT
+`
+	if err := os.WriteFile(script, []byte(ffmpeg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	originalBinary := ffmpegBinary
+	ffmpegBinary = script
+	t.Cleanup(func() { ffmpegBinary = originalBinary })
+	video := filepath.Join(dir, "in.mp4")
+	if err := os.WriteFile(video, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ffmpegDetectAnomaliesHandler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_video_uri": video,
+	}}}, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	report, ok := result.StructuredContent.(videoAnomalyReport)
+	if !ok {
+		t.Fatalf("expected a videoAnomalyReport, got %T", result.StructuredContent)
+	}
+	if len(report.BlackFrames) != 1 || report.BlackFrames[0] != (videoAnomaly{0, 1.5, 1.5}) {
+		t.Errorf("unexpected black frames %v", report.BlackFrames)
+	}
+	if len(report.FreezeFrames) != 1 || report.FreezeFrames[0] != (videoAnomaly{4, 6, 2}) {
+		t.Errorf("expected the open freeze to end with the video, got %v", report.FreezeFrames)
+	}
+	if report.MinDuration != 1 || report.Duration != 6 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, `"freeze_frames":[{"start":4,"end":6,"duration":2}]`) {
+		t.Errorf("expected the report JSON in the text, got %q", text)
+	}
+}
+
+func TestDuckAudioHandler(t *testing.T) {
+	dir := t.TempDir()
+	commandLog := filepath.Join(dir, "ffmpeg.log")
+	// ffprobe reports a 10s mono voice and a 42.5s stereo bed.
+	ffprobe := "#!/bin/sh\nfor last; do :; done\ncase \"$last\" in\n" +
+		"*vo.wav) echo '{\"format\": {\"duration\": \"10.0\"}, \"streams\": [{\"codec_type\": \"audio\", \"codec_name\": \"pcm_s16le\", \"sample_rate\": \"24000\", \"channels\": 1}]}' ;;\n" +
+		"*) echo '{\"format\": {\"duration\": \"42.5\"}, \"streams\": [{\"codec_type\": \"audio\", \"codec_name\": \"mp3\", \"sample_rate\": \"44100\", \"channels\": 2}]}' ;;\n" +
+		"esac\n"
+	ffmpeg := "#!/bin/sh\necho \"$*\" >> '" + commandLog + "'\nfor last; do :; done\n: > \"$last\"\n"
+	for name, script := range map[string]string{"ffprobe": ffprobe, "ffmpeg": ffmpeg} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	originalFFmpeg, originalFFprobe := ffmpegBinary, ffprobeBinary
+	ffmpegBinary, ffprobeBinary = filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe")
+	t.Cleanup(func() { ffmpegBinary, ffprobeBinary = originalFFmpeg, originalFFprobe })
+	voice, music := filepath.Join(dir, "vo.wav"), filepath.Join(dir, "bed.mp3")
+	for _, path := range []string{voice, music} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := ffmpegDuckAudioHandler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"voice_audio_uri":    voice,
+		"music_audio_uri":    music,
+		"release_ms":         250.0,
+		"music_base_gain_db": -3.0,
+		"output_local_dir":   filepath.Join(dir, "out"),
+	}}}, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{
+		"threshold -30 dB, ratio 8:1, attack 20 ms, release 250 ms, music base gain -3 dB",
+		"Output is 42.500s at 44100 Hz, 2 ch.",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the result, got %q", want, text)
+		}
+	}
+	logData, _ := os.ReadFile(commandLog)
+	if !strings.Contains(string(logData), "apad=whole_dur=42.500[voice]") || !strings.Contains(string(logData), ".wav") {
+		t.Errorf("expected the voice to be padded to the bed and a WAV output, got %s", logData)
+	}
+}
+
+func TestImagesToGifHandler(t *testing.T) {
+	dir := t.TempDir()
+	commandLog, frameLog := filepath.Join(dir, "ffmpeg.log"), filepath.Join(dir, "frames.log")
+	// ffprobe reports a 1000x500 image; ffmpeg logs its arguments and the images its frames link to.
+	ffprobe := "#!/bin/sh\necho '{\"streams\": [{\"codec_type\": \"video\", \"codec_name\": \"png\", \"width\": 1000, \"height\": 500, \"r_frame_rate\": \"25/1\"}]}'\n"
+	ffmpeg := "#!/bin/sh\necho \"$*\" >> '" + commandLog + "'\nprev=''\nfor a; do\n" +
+		"  if [ \"$prev\" = -framerate ]; then rate=1; fi\n" +
+		"  if [ -n \"$rate\" ] && [ \"$prev\" = -i ]; then for f in \"$(dirname \"$a\")\"/*; do basename \"$(readlink \"$f\")\" >> '" + frameLog + "'; done; rate=''; fi\n" +
+		"  prev=\"$a\"\ndone\n: > \"$a\"\n"
+	for name, script := range map[string]string{"ffprobe": ffprobe, "ffmpeg": ffmpeg} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	originalFFmpeg, originalFFprobe := ffmpegBinary, ffprobeBinary
+	ffmpegBinary, ffprobeBinary = filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe")
+	t.Cleanup(func() { ffmpegBinary, ffprobeBinary = originalFFmpeg, originalFFprobe })
+	var images []interface{}
+	for _, name := range []string{"c.png", "a.png", "b.png"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, path)
+	}
+
+	result, err := ffmpegImagesToGifHandler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"image_uris":       images,
+		"fps":              4.0,
+		"dither":           "bayer",
+		"output_file_name": "storyboard.gif",
+		"output_local_dir": filepath.Join(dir, "out"),
+	}}}, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "storyboard.gif")); err != nil {
+		t.Errorf("expected the GIF in the output directory: %v", err)
+	}
+	// both passes read the images in the order given
+	if frames, _ := os.ReadFile(frameLog); string(frames) != "c.png\na.png\nb.png\nc.png\na.png\nb.png\n" {
+		t.Errorf("expected the frames in request order, got %q", frames)
+	}
+	commands, _ := os.ReadFile(commandLog)
+	for _, want := range []string{"-framerate 4 -i", "scale=500:250:", ",palettegen ", "paletteuse=dither=bayer:bayer_scale=2 "} {
+		if !strings.Contains(string(commands), want) {
+			t.Errorf("expected %q in the FFmpeg commands, got %s", want, commands)
+		}
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "GIF of 3 images (500x250 at 4 fps, dither bayer)") {
+		t.Errorf("unexpected result %q", text)
+	}
+}
+
+func TestDefaultBucketOnlyWithoutRequestedBuckets(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nfor last; do :; done\necho mp3 > \"$last\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "intro.wav")
+	if err := os.WriteFile(input, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	originalBinary, originalProcess := ffmpegBinary, commonProcessOutputToBuckets
+	t.Cleanup(func() { ffmpegBinary, commonProcessOutputToBuckets = originalBinary, originalProcess })
+	ffmpegBinary = script
+	var uploadedTo []string
+	commonProcessOutputToBuckets = func(ctx context.Context, ffmpegOutputPath, finalOutputFilename, outputLocalDir string, outputGCSBuckets []string, projectID string) (string, []common.GCSUploadResult, error) {
+		uploadedTo = outputGCSBuckets
+		return "", nil, nil
+	}
+	cfg := &common.Config{GenmediaBucket: "default-bucket"}
+
+	testCases := []struct {
+		name     string
+		args     map[string]interface{}
+		expected []string
+	}{
+		{"no bucket requested", map[string]interface{}{}, []string{"default-bucket"}},
+		{"output_gcs_buckets only", map[string]interface{}{"output_gcs_buckets": []interface{}{"gs://tenant-eu", "tenant-us"}}, []string{"tenant-eu", "tenant-us"}},
+		{"output_gcs_bucket and output_gcs_buckets", map[string]interface{}{"output_gcs_bucket": "primary", "output_gcs_buckets": []interface{}{"tenant-eu"}}, []string{"primary", "tenant-eu"}},
+		{"blank output_gcs_buckets", map[string]interface{}{"output_gcs_buckets": []interface{}{" "}}, []string{"default-bucket"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			uploadedTo = nil
+			tc.args["input_audio_uri"] = input
+			request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tc.args}}
+			result, err := ffmpegConvertAudioHandler(context.Background(), request, cfg)
+			if err != nil || result.IsError {
+				t.Fatalf("unexpected error: %v %+v", err, result)
+			}
+			if strings.Join(uploadedTo, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected uploads to %v, got %v", tc.expected, uploadedTo)
+			}
+		})
+	}
+}
+
+func TestRedactDataURIArguments(t *testing.T) {
+	args := map[string]interface{}{
+		"input_video_uri": "gs://bucket/in.mp4",
+		"input_image_uri": "data:image/png;base64,MTIzNDU=",
+		"x_coordinate":    float64(10),
+	}
+	redacted := redactDataURIArguments(args)
+	if got := redacted["input_image_uri"]; got != "data:image/png;base64,<5 bytes>" {
+		t.Errorf("input_image_uri = %v, want the redacted data URI", got)
+	}
+	if redacted["input_video_uri"] != "gs://bucket/in.mp4" || redacted["x_coordinate"] != float64(10) {
+		t.Errorf("expected other arguments unchanged, got %v", redacted)
+	}
+	if args["input_image_uri"] != "data:image/png;base64,MTIzNDU=" {
+		t.Error("expected the request arguments to be left as they were")
+	}
+}
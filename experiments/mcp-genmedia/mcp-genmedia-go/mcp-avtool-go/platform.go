@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// platformPreset is the delivery format of one publishing platform: an H.264/AAC MP4 at a fixed
+// frame size and rate, with a capped video bitrate.
+type platformPreset struct {
+	Name            string
+	Width, Height   int
+	FPS             int
+	VideoBitrate    string
+	H264Level       string
+	AudioBitrate    string
+	AudioSampleRate int
+}
+
+// platformPresets are the 'platform' values, following each platform's published upload
+// recommendations. Vertical platforms get 1080x1920; the bitrates leave headroom under the limits
+// the platforms re-encode above.
+var platformPresets = map[string]platformPreset{
+	"youtube":        {Name: "youtube", Width: 1920, Height: 1080, FPS: 30, VideoBitrate: "8M", H264Level: "4.1", AudioBitrate: "384k", AudioSampleRate: 48000},
+	"instagram_reel": {Name: "instagram_reel", Width: 1080, Height: 1920, FPS: 30, VideoBitrate: "5M", H264Level: "4.1", AudioBitrate: "128k", AudioSampleRate: 44100},
+	"tiktok":         {Name: "tiktok", Width: 1080, Height: 1920, FPS: 30, VideoBitrate: "6M", H264Level: "4.1", AudioBitrate: "128k", AudioSampleRate: 44100},
+	"x":              {Name: "x", Width: 1280, Height: 720, FPS: 30, VideoBitrate: "5M", H264Level: "3.1", AudioBitrate: "128k", AudioSampleRate: 44100},
+}
+
+// platformNames lists the presets in platformPresets, in the order they are documented.
+var platformNames = []string{"youtube", "instagram_reel", "tiktok", "x"}
+
+// unsafePlatformFileNameChars matches the runs of characters replaced in an output file name
+// when a platform preset is applied; some upload forms reject spaces and non-ASCII names.
+var unsafePlatformFileNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// withPlatformParam is the shared 'platform' tool option for the tools that write a video.
+func withPlatformParam() mcp.ToolOption {
+	return mcp.WithString("platform",
+		mcp.Enum(platformNames...),
+		mcp.Description("Optional. Conforms the output to a platform's upload recommendations: 'youtube' (1920x1080), 'instagram_reel' and 'tiktok' (1080x1920) or 'x' (1280x720), all H.264/AAC MP4 at 30 fps. The picture is scaled to fit and padded, and the output file name is reduced to letters, digits, '-' and '_' with an .mp4 extension. Costs one extra encode."),
+	)
+}
+
+// platformArg reads the optional 'platform' argument, nil when it was not given, and returns the
+// requested output file name reduced for it by platformOutputFileName.
+func platformArg(argsMap map[string]interface{}, outputFileName string) (*platformPreset, string, *mcp.CallToolResult) {
+	name, _ := argsMap["platform"].(string)
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil, outputFileName, nil
+	}
+	preset, ok := platformPresets[name]
+	if !ok {
+		return nil, outputFileName, invalidParamResult("platform", "must be one of '%s', got '%s'", strings.Join(platformNames, "', '"), name)
+	}
+	return &preset, platformOutputFileName(outputFileName, &preset), nil
+}
+
+// platformOutputFileName reduces a requested output file name to characters every platform
+// accepts, with an .mp4 extension. The name is unchanged without a preset, and "" (or a name with
+// nothing usable left) lets the tool generate one.
+func platformOutputFileName(name string, preset *platformPreset) string {
+	if preset == nil || name == "" {
+		return name
+	}
+	stem := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	stem = strings.Trim(unsafePlatformFileNameChars.ReplaceAllString(stem, "_"), "_")
+	if stem == "" {
+		return ""
+	}
+	return stem + ".mp4"
+}
+
+// buildPlatformArgs returns the FFmpeg arguments that re-encode inputFile to the preset: scaled to
+// fit the frame and padded, at a constant frame rate, with a capped H.264 bitrate, stereo AAC and
+// the moov atom at the front for progressive playback.
+func buildPlatformArgs(inputFile, outputFile string, preset platformPreset) []string {
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%d,format=yuv420p",
+		preset.Width, preset.Height, preset.Width, preset.Height, preset.FPS)
+	return []string{
+		"-y", "-i", inputFile,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", filter,
+		"-c:v", "libx264", "-preset", "medium", "-profile:v", "high", "-level:v", preset.H264Level,
+		"-b:v", preset.VideoBitrate, "-maxrate", preset.VideoBitrate, "-bufsize", doubleBitrate(preset.VideoBitrate),
+		"-g", strconv.Itoa(preset.FPS * 2),
+		"-c:a", "aac", "-b:a", preset.AudioBitrate, "-ar", strconv.Itoa(preset.AudioSampleRate), "-ac", "2",
+		"-movflags", "+faststart",
+		outputFile,
+	}
+}
+
+// doubleBitrate returns twice an FFmpeg bitrate such as "8M", the VBV buffer size used with it.
+func doubleBitrate(bitrate string) string {
+	digits := strings.TrimRight(bitrate, "kKmM")
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return bitrate
+	}
+	return strconv.Itoa(n*2) + bitrate[len(digits):]
+}
+
+// conformToPlatform re-encodes the video at path to the preset in place. It does nothing when
+// preset is nil.
+func conformToPlatform(ctx context.Context, path string, preset *platformPreset) error {
+	if preset == nil {
+		return nil
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("platform", preset.Name))
+	conformed := strings.TrimSuffix(path, filepath.Ext(path)) + ".platform.mp4"
+	if _, err := runFFmpegCommand(ctx, buildPlatformArgs(path, conformed, *preset)...); err != nil {
+		os.Remove(conformed)
+		return err
+	}
+	return os.Rename(conformed, path)
+}
+
+// applyPlatform conforms a tool's output at path to the preset with conformToPlatform, and returns
+// the tool's error result when that fails.
+func applyPlatform(ctx context.Context, path string, preset *platformPreset) *mcp.CallToolResult {
+	if err := conformToPlatform(ctx, path, preset); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to apply the %s platform preset: %v", preset.Name, err))
+	}
+	return nil
+}
+
+// summary describes the preset for a tool's result message.
+func (p *platformPreset) summary() string {
+	return fmt.Sprintf("Conformed to the %s preset: %dx%d at %d fps, H.264 at %s and AAC at %s, %d Hz stereo.",
+		p.Name, p.Width, p.Height, p.FPS, p.VideoBitrate, p.AudioBitrate, p.AudioSampleRate)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestBuildPlatformArgs(t *testing.T) {
+	testCases := map[string][]string{
+		"youtube": {
+			"scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuv420p",
+			"-c:v libx264", "-profile:v high -level:v 4.1", "-b:v 8M -maxrate 8M -bufsize 16M", "-g 60",
+			"-c:a aac -b:a 384k -ar 48000 -ac 2",
+		},
+		"instagram_reel": {
+			"scale=1080:1920:force_original_aspect_ratio=decrease,pad=1080:1920:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuv420p",
+			"-level:v 4.1", "-b:v 5M -maxrate 5M -bufsize 10M",
+			"-c:a aac -b:a 128k -ar 44100 -ac 2",
+		},
+		"tiktok": {
+			"scale=1080:1920:force_original_aspect_ratio=decrease,pad=1080:1920",
+			"-level:v 4.1", "-b:v 6M -maxrate 6M -bufsize 12M",
+			"-c:a aac -b:a 128k -ar 44100 -ac 2",
+		},
+		"x": {
+			"scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720",
+			"-level:v 3.1", "-b:v 5M -maxrate 5M -bufsize 10M",
+			"-c:a aac -b:a 128k -ar 44100 -ac 2",
+		},
+	}
+	if len(testCases) != len(platformPresets) || len(platformNames) != len(platformPresets) {
+		t.Fatalf("every preset needs a test case and a documented name")
+	}
+	for name, wants := range testCases {
+		t.Run(name, func(t *testing.T) {
+			args := buildPlatformArgs("in.mp4", "out.mp4", platformPresets[name])
+			line := strings.Join(args, " ")
+			for _, want := range append(wants, "-map 0:v:0 -map 0:a:0?", "-movflags +faststart") {
+				if !strings.Contains(line, want) {
+					t.Errorf("command line is missing %q: %s", want, line)
+				}
+			}
+			if args[len(args)-1] != "out.mp4" {
+				t.Errorf("the output file must be last, got %q", args[len(args)-1])
+			}
+		})
+	}
+}
+
+func TestPlatformOutputFileName(t *testing.T) {
+	youtube := platformPresets["youtube"]
+	testCases := []struct {
+		name   string
+		preset *platformPreset
+		want   string
+	}{
+		{"My Clip (final).mov", nil, "My Clip (final).mov"},
+		{"My Clip (final).mov", &youtube, "My_Clip_final.mp4"},
+		{"résumé-teaser", &youtube, "r_sum_-teaser.mp4"},
+		{"../../promo.mp4", &youtube, "promo.mp4"},
+		{"!!!.mp4", &youtube, ""},
+		{"", &youtube, ""},
+	}
+	for _, tc := range testCases {
+		if got := platformOutputFileName(tc.name, tc.preset); got != tc.want {
+			t.Errorf("platformOutputFileName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCombineAudioVideoWithPlatform(t *testing.T) {
+	dir := t.TempDir()
+	commandLog := filepath.Join(dir, "ffmpeg.log")
+	script := filepath.Join(dir, "ffmpeg")
+	// Log the arguments and write the name of the preset step into the output file.
+	ffmpeg := "#!/bin/sh\necho \"$*\" >> '" + commandLog + "'\nfor last; do :; done\ncase \"$*\" in\n*-movflags*) echo conformed > \"$last\" ;;\n*) echo combined > \"$last\" ;;\nesac\n"
+	if err := os.WriteFile(script, []byte(ffmpeg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	originalBinary := ffmpegBinary
+	ffmpegBinary = script
+	t.Cleanup(func() { ffmpegBinary = originalBinary })
+
+	video, audio := filepath.Join(dir, "in.mp4"), filepath.Join(dir, "in.wav")
+	for _, path := range []string{video, audio} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outDir := filepath.Join(dir, "out")
+
+	result, err := ffmpegCombineAudioVideoHandler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"input_video_uri":  video,
+		"input_audio_uri":  audio,
+		"output_file_name": "launch teaser.mov",
+		"output_local_dir": outDir,
+		"platform":         "tiktok",
+	}}}, &common.Config{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "launch_teaser.mp4"))
+	if err != nil || strings.TrimSpace(string(data)) != "conformed" {
+		t.Errorf("expected the conformed video at launch_teaser.mp4, got %q, %v", data, err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "Conformed to the tiktok preset: 1080x1920 at 30 fps") {
+		t.Errorf("expected the preset in the result, got %q", text)
+	}
+	logData, _ := os.ReadFile(commandLog)
+	if lines := strings.Split(strings.TrimSpace(string(logData)), "\n"); len(lines) != 2 {
+		t.Errorf("expected the combine and the preset encodes, got %q", lines)
+	}
+}
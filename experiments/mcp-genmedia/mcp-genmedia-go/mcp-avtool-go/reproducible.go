@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// reproducibleOutputArgs make an encode byte-identical across runs and machines. -map_metadata -1
+// drops the metadata copied from the inputs (such as creation_time), the bitexact flags keep the
+// muxer and the encoders from writing their version tags, and -threads 1 stops libx264's output
+// from depending on the number of CPU cores.
+var reproducibleOutputArgs = []string{
+	"-map_metadata", "-1",
+	"-fflags", "+bitexact",
+	"-flags:v", "+bitexact",
+	"-flags:a", "+bitexact",
+	"-threads", "1",
+}
+
+// withReproducibleParam is the shared 'reproducible' tool option for the transcoding tools.
+func withReproducibleParam() mcp.ToolOption {
+	return mcp.WithBoolean("reproducible",
+		mcp.Description("Optional. If true, the encode is deterministic: the same inputs and arguments give a byte-identical output, and its SHA-256 is included in the result. Metadata and encoder version tags are left out, and video is encoded on a single thread, which is several times slower on multi-core machines."),
+	)
+}
+
+// withReproducibleOutput inserts reproducibleOutputArgs just before the output file, which must be
+// the last argument. args is returned unchanged when reproducible is false.
+func withReproducibleOutput(args []string, reproducible bool) []string {
+	if !reproducible || len(args) == 0 {
+		return args
+	}
+	out := make([]string, 0, len(args)+len(reproducibleOutputArgs))
+	out = append(out, args[:len(args)-1]...)
+	out = append(out, reproducibleOutputArgs...)
+	return append(out, args[len(args)-1])
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWithReproducibleOutput(t *testing.T) {
+	plan := compressionPlan{VideoBitrateKbps: 900, AudioBitrateKbps: 128}
+	builders := map[string]func() []string{
+		"concat standardize video": func() []string {
+			return buildConcatStandardizeArgs("in.mov", "std.mp4", false, true, defaultStreams, 0)
+		},
+		"concat standardize audio": func() []string {
+			return buildConcatStandardizeArgs("in.wav", "std.mp4", true, false, defaultStreams, 0)
+		},
+		"two-pass first pass":  func() []string { return buildTwoPassArgs("in.mp4", "out.mp4", "/tmp/passlog", plan, 1) },
+		"two-pass second pass": func() []string { return buildTwoPassArgs("in.mp4", "out.mp4", "/tmp/passlog", plan, 2) },
+		"tonemap":              func() []string { return buildTonemapArgs("in.mov", "out.mp4", buildTonemapFilter("hable")) },
+	}
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			first := withReproducibleOutput(build(), true)
+			second := withReproducibleOutput(build(), true)
+			if !reflect.DeepEqual(first, second) {
+				t.Fatalf("command lines differ between runs:\n%q\n%q", first, second)
+			}
+			line := strings.Join(first, " ")
+			for _, want := range []string{"-map_metadata -1", "-fflags +bitexact", "-flags:v +bitexact", "-flags:a +bitexact", "-threads 1"} {
+				if !strings.Contains(line, want) {
+					t.Errorf("command line is missing %q: %s", want, line)
+				}
+			}
+			plain := build()
+			if first[len(first)-1] != plain[len(plain)-1] {
+				t.Errorf("the output file must stay last, got %q", first[len(first)-1])
+			}
+			if !slices.Equal(withReproducibleOutput(plain, false), plain) {
+				t.Error("args should be unchanged when reproducible is false")
+			}
+		})
+	}
+}
+
+func TestFileSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bin")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileSHA256(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultLoopOverlapSecs is how long the end of the clip is crossfaded into its start.
+const defaultLoopOverlapSecs = 1.0
+
+// seamlessLoopOffset returns the xfade offset that makes a clip of the given duration loop
+// seamlessly with the given overlap. The loop is the clip from overlap to its end, with its last
+// overlap seconds crossfaded into the clip's first overlap seconds: it ends on the frame it
+// starts on, overlap seconds into the input. The crossfade starts at duration-2*overlap into
+// the loop, which lasts duration-overlap, so the clip must be longer than twice the overlap.
+func seamlessLoopOffset(duration, overlap float64) (float64, error) {
+	if overlap <= 0 {
+		return 0, fmt.Errorf("the overlap must be positive, got %g", overlap)
+	}
+	if duration <= 2*overlap {
+		return 0, fmt.Errorf("the %.3fs clip is too short for a %gs overlap, it must be longer than twice the overlap", duration, overlap)
+	}
+	return duration - 2*overlap, nil
+}
+
+// buildSeamlessLoopFilter returns the filter graph of the loop: the clip is split in two, the
+// body trimmed to start at overlap and the head trimmed to its first overlap seconds, and the
+// body crossfades into the head at offset. The video is first brought to a constant frame rate,
+// as xfade needs both inputs at the same rate and time base. With audio, the same cut is
+// crossfaded with acrossfade. The outputs are labeled [v] and [a].
+func buildSeamlessLoopFilter(overlap, offset, frameRate float64, withAudio bool) string {
+	filter := fmt.Sprintf("[0:v]fps=%g,settb=AVTB,split=2[body][head];"+
+		"[body]trim=start=%.3f,setpts=PTS-STARTPTS[main];"+
+		"[head]trim=end=%.3f,setpts=PTS-STARTPTS[intro];"+
+		"[main][intro]xfade=transition=fade:duration=%.3f:offset=%.3f,format=yuv420p[v]",
+		frameRate, overlap, overlap, overlap, offset)
+	if withAudio {
+		filter += fmt.Sprintf(";[0:a]asplit=2[abody][ahead];"+
+			"[abody]atrim=start=%.3f,asetpts=PTS-STARTPTS[amain];"+
+			"[ahead]atrim=end=%.3f,asetpts=PTS-STARTPTS[aintro];"+
+			"[amain][aintro]acrossfade=d=%.3f[a]",
+			overlap, overlap, overlap)
+	}
+	return filter
+}
+
+// buildSeamlessLoopArgs returns the FFmpeg arguments that render the loop filter of
+// buildSeamlessLoopFilter as an H.264 MP4, with AAC audio when the filter has it.
+func buildSeamlessLoopArgs(localInputVideo, outputFile, filter string, withAudio bool) []string {
+	args := []string{"-y", "-i", localInputVideo, "-filter_complex", filter, "-map", "[v]"}
+	if withAudio {
+		args = append(args, "-map", "[a]", "-c:a", "aac")
+	} else {
+		args = append(args, "-an")
+	}
+	return append(args, "-c:v", "libx264", "-preset", "medium", "-crf", "18", "-pix_fmt", "yuv420p", "-movflags", "+faststart", outputFile)
+}
+
+// addSeamlessLoopTool defines and registers the 'ffmpeg_seamless_loop' tool.
+// This tool turns a clip into one that loops without a visible seam.
+func addSeamlessLoopTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_seamless_loop",
+		mcp.WithDescription("Makes a video clip loop without a visible seam, e.g. for ambient backgrounds: the end of the clip is crossfaded into its start over overlap_seconds, so the last frame of the output leads straight into its first. The output is overlap_seconds shorter than the input."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://).")),
+		mcp.WithNumber("overlap_seconds", mcp.DefaultNumber(defaultLoopOverlapSecs), mcp.Description("Optional. Length of the crossfade in seconds. The clip must be longer than twice the overlap. Defaults to 1.")),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file (e.g., 'loop.mp4').")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegSeamlessLoopHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegSeamlessLoopHandler is the handler for the seamless loop tool.
+// It probes the input for its duration, frame rate and audio, then renders the loop in one pass.
+func ffmpegSeamlessLoopHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_seamless_loop")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_seamless_loop", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	inputVideoURI = strings.TrimSpace(inputVideoURI)
+	if inputVideoURI == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	overlap := defaultLoopOverlapSecs
+	if raw, ok := argsMap["overlap_seconds"]; ok && raw != nil {
+		value, ok := raw.(float64)
+		if !ok || value <= 0 {
+			return invalidParamResult("overlap_seconds", "must be a positive number of seconds, got %v", raw), nil
+		}
+		overlap = value
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_seamless_loop: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.Float64("overlap_seconds", overlap),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localInputVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_seamless_loop", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+
+	mediaInfoJSON, err := executeGetMediaInfo(ctx, localInputVideo)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to probe input video: %v", err)), nil
+	}
+	geometry, err := parseVideoGeometry(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video stream: %v", err)), nil
+	}
+	videoDuration, err := parseMediaDuration(mediaInfoJSON)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read input video duration: %v", err)), nil
+	}
+	offset, err := seamlessLoopOffset(videoDuration, overlap)
+	if err != nil {
+		return invalidParamResult("overlap_seconds", "%v", err), nil
+	}
+	loopDuration := videoDuration - overlap
+	filter := buildSeamlessLoopFilter(overlap, offset, geometry.FrameRate, geometry.HasAudio)
+	span.SetAttributes(
+		attribute.Float64("xfade_offset_seconds", offset),
+		attribute.Float64("output_duration_seconds", loopDuration),
+		attribute.String("filter_complex", filter),
+	)
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, "mp4")
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildSeamlessLoopArgs(localInputVideo, tempOutputFile, filter, geometry.HasAudio)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg seamless loop failed: %v", ffmpegErr)), nil
+	}
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	messageParts := []string{fmt.Sprintf("Seamless loop of %.3fs made from the %.3fs input with a %gs crossfade in %v.", loopDuration, videoDuration, overlap, time.Since(startTime))}
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+
+	span.SetAttributes(attribute.Float64("duration_ms", float64(time.Since(startTime).Milliseconds())))
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSeamlessLoopOffset(t *testing.T) {
+	testCases := []struct {
+		name     string
+		duration float64
+		overlap  float64
+		want     float64
+		wantErr  string
+	}{
+		{"one second overlap", 10, 1, 8, ""},
+		{"fractional", 8.5, 0.75, 7, ""},
+		{"just long enough", 2.002, 1, 0.002, ""},
+		{"twice the overlap", 4, 2, 0, "must be longer than twice the overlap"},
+		{"shorter than the overlap", 0.5, 1, 0, "too short for a 1s overlap"},
+		{"zero overlap", 10, 0, 0, "must be positive"},
+	}
+	for _, tc := range testCases {
+		got, err := seamlessLoopOffset(tc.duration, tc.overlap)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: seamlessLoopOffset(%g, %g) = %g, %v; want %g", tc.name, tc.duration, tc.overlap, got, err, tc.want)
+		}
+	}
+}
+
+func TestBuildSeamlessLoopFilter(t *testing.T) {
+	want := "[0:v]fps=24,settb=AVTB,split=2[body][head];" +
+		"[body]trim=start=1.500,setpts=PTS-STARTPTS[main];" +
+		"[head]trim=end=1.500,setpts=PTS-STARTPTS[intro];" +
+		"[main][intro]xfade=transition=fade:duration=1.500:offset=5.000,format=yuv420p[v]"
+	if got := buildSeamlessLoopFilter(1.5, 5, 24, false); got != want {
+		t.Errorf("video only:\ngot  %s\nwant %s", got, want)
+	}
+	wantAudio := want + ";[0:a]asplit=2[abody][ahead];" +
+		"[abody]atrim=start=1.500,asetpts=PTS-STARTPTS[amain];" +
+		"[ahead]atrim=end=1.500,asetpts=PTS-STARTPTS[aintro];" +
+		"[amain][aintro]acrossfade=d=1.500[a]"
+	if got := buildSeamlessLoopFilter(1.5, 5, 24, true); got != wantAudio {
+		t.Errorf("with audio:\ngot  %s\nwant %s", got, wantAudio)
+	}
+
+	args := strings.Join(buildSeamlessLoopArgs("in.mp4", "out.mp4", "F", true), " ")
+	if !strings.Contains(args, "-filter_complex F -map [v] -map [a] -c:a aac") || !strings.HasSuffix(args, "out.mp4") {
+		t.Errorf("unexpected arguments %q", args)
+	}
+	if args := strings.Join(buildSeamlessLoopArgs("in.mp4", "out.mp4", "F", false), " "); !strings.Contains(args, "-map [v] -an") {
+		t.Errorf("expected no audio, got %q", args)
+	}
+}
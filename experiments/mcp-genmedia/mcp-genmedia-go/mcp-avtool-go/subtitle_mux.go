@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxSubtitleTracks is the number of subtitle tracks ffmpeg_mux_subtitles muxes in one call.
+const maxSubtitleTracks = 32
+
+// subtitleTrackExtensions are the subtitle file formats ffmpeg_mux_subtitles reads.
+var subtitleTrackExtensions = []string{".srt", ".vtt", ".ass", ".ssa"}
+
+// subtitleLanguagePattern matches the language tags accepted for subtitle streams: an ISO 639
+// code, optionally followed by BCP 47 subtags, e.g. 'en', 'eng' or 'pt-BR'. Anything else,
+// including path separators, is rejected.
+var subtitleLanguagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]+)*$`)
+
+// iso6392Pattern matches the three-letter ISO 639-2 codes, the only language codes MP4 stores.
+var iso6392Pattern = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+// subtitleTrack is one soft subtitle track to mux into a video.
+type subtitleTrack struct {
+	URI      string
+	Language string
+}
+
+// subtitleMuxContainer is an output container of ffmpeg_mux_subtitles and the subtitle codec it
+// stores: Matroska keeps the subtitle files as they are, and MP4 only holds mov_text.
+type subtitleMuxContainer struct {
+	Extension     string
+	SubtitleCodec string
+}
+
+var (
+	subtitleMuxMKV = subtitleMuxContainer{Extension: "mkv", SubtitleCodec: "copy"}
+	subtitleMuxMP4 = subtitleMuxContainer{Extension: "mp4", SubtitleCodec: "mov_text"}
+)
+
+// subtitleMuxContainerFor picks the container from the output file name's extension. Without an
+// extension the output is MKV, which holds any number of tracks in their own formats.
+func subtitleMuxContainerFor(outputFileName string) (subtitleMuxContainer, error) {
+	switch ext := strings.ToLower(filepath.Ext(outputFileName)); ext {
+	case "", ".mkv":
+		return subtitleMuxMKV, nil
+	case ".mp4", ".m4v", ".mov":
+		return subtitleMuxContainer{Extension: strings.TrimPrefix(ext, "."), SubtitleCodec: subtitleMuxMP4.SubtitleCodec}, nil
+	default:
+		return subtitleMuxContainer{}, fmt.Errorf("must end in .mkv, .mp4, .m4v or .mov, got '%s'", outputFileName)
+	}
+}
+
+// parseSubtitleTracks reads the 'subtitle_tracks' argument. Failures name the offending entry,
+// e.g. 'subtitle_tracks[1].language'. MP4 outputs need three-letter language codes.
+func parseSubtitleTracks(raw []interface{}, container subtitleMuxContainer) ([]subtitleTrack, *validationError) {
+	if len(raw) == 0 || len(raw) > maxSubtitleTracks {
+		return nil, newValidationError("subtitle_tracks", "must list 1 to %d tracks, got %d", maxSubtitleTracks, len(raw))
+	}
+	var tracks []subtitleTrack
+	for i, item := range raw {
+		field := fmt.Sprintf("subtitle_tracks[%d]", i)
+		trackMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, newValidationError(field, "must be an object with 'subtitle_uri' and 'language'")
+		}
+		var track subtitleTrack
+		track.URI, _ = trackMap["subtitle_uri"].(string)
+		track.URI = strings.TrimSpace(track.URI)
+		if track.URI == "" {
+			return nil, newValidationError(field+".subtitle_uri", reasonRequired)
+		}
+		if ext := strings.ToLower(filepath.Ext(track.URI)); !slices.Contains(subtitleTrackExtensions, ext) {
+			return nil, newValidationError(field+".subtitle_uri", "must be an .srt, .vtt, .ass or .ssa file, got '%s'", track.URI)
+		}
+		track.Language, _ = trackMap["language"].(string)
+		track.Language = strings.TrimSpace(track.Language)
+		if !subtitleLanguagePattern.MatchString(track.Language) {
+			return nil, newValidationError(field+".language", "must be a language code such as 'en', 'eng' or 'pt-BR', got '%s'", track.Language)
+		}
+		if container.SubtitleCodec == subtitleMuxMP4.SubtitleCodec && !iso6392Pattern.MatchString(track.Language) {
+			return nil, newValidationError(field+".language", "must be a three-letter ISO 639-2 code such as 'eng' for %s output, got '%s'", container.Extension, track.Language)
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// buildMuxSubtitlesArgs returns the FFmpeg arguments that add each subtitle file as its own
+// subtitle stream of the video, tagged with its language. Input N+1 is track N; the video and
+// audio are copied, any subtitles the video already has are dropped, and the first track is
+// marked as the default.
+func buildMuxSubtitlesArgs(localVideo string, localSubtitles []string, tracks []subtitleTrack, outputFile string, container subtitleMuxContainer) []string {
+	args := []string{"-y", "-i", localVideo}
+	for _, subtitle := range localSubtitles {
+		args = append(args, "-i", subtitle)
+	}
+	args = append(args, "-map", "0:v?", "-map", "0:a?")
+	for i := range localSubtitles {
+		args = append(args, "-map", fmt.Sprintf("%d:s", i+1))
+	}
+	args = append(args, "-c:v", "copy", "-c:a", "copy", "-c:s", container.SubtitleCodec)
+	for i, track := range tracks {
+		args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+track.Language)
+	}
+	for i := range tracks {
+		disposition := "0"
+		if i == 0 {
+			disposition = "default"
+		}
+		args = append(args, fmt.Sprintf("-disposition:s:%d", i), disposition)
+	}
+	if container.SubtitleCodec == subtitleMuxMP4.SubtitleCodec {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, outputFile)
+}
+
+// addMuxSubtitlesTool defines and registers the 'ffmpeg_mux_subtitles' tool.
+// This tool adds soft subtitle tracks, e.g. one per translation, to a video.
+func addMuxSubtitlesTool(s *server.MCPServer, cfg *common.Config) {
+	tool := mcp.NewTool("ffmpeg_mux_subtitles",
+		mcp.WithDescription("Adds subtitle files to a video as soft subtitle tracks, one per file, each tagged with its language, e.g. to ship several translations in one file. The video and audio are copied without re-encoding. The container follows the output file name: MKV (the default) keeps the subtitles in their own format, MP4 converts them to mov_text."),
+		mcp.WithString("input_video_uri", mcp.Required(), mcp.Description("URI of the input video file (local path or gs://). Subtitle streams it already has are not kept.")),
+		mcp.WithArray("subtitle_tracks", mcp.Required(), mcp.Description(fmt.Sprintf("Subtitle tracks in the order they should appear, up to %d. Each is an object with 'subtitle_uri' (local path or gs:// of an .srt, .vtt, .ass or .ssa file) and 'language' (e.g. 'en', 'eng' or 'pt-BR'; MP4 needs three-letter ISO 639-2 codes such as 'eng'). The first track is the default.", maxSubtitleTracks)),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"subtitle_uri": map[string]any{"type": "string"},
+					"language":     map[string]any{"type": "string"},
+				},
+				"required": []string{"subtitle_uri", "language"},
+			})),
+		mcp.WithString("output_file_name", mcp.Description("Optional. Desired name for the output video file, ending in .mkv (preferred for many tracks) or .mp4. Defaults to an .mkv name.")),
+		mcp.WithString("output_local_dir", mcp.Description("Optional. Local directory to save the output video file.")),
+		mcp.WithString("output_gcs_bucket", mcp.Description("Optional. GCS bucket to upload the output video file to.")),
+		withOutputGCSBucketsParam(),
+		withFFmpegLogParams(),
+		withTimeoutParam(),
+		withImpersonationParam(),
+		withJobIDParam(),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegMuxSubtitlesHandler(ctx, request, cfg)
+	})
+}
+
+// ffmpegMuxSubtitlesHandler is the handler for the subtitle muxing tool.
+// It fetches the video and every subtitle file, then muxes them in a single stream-copy pass.
+func ffmpegMuxSubtitlesHandler(ctx context.Context, request mcp.CallToolRequest, cfg *common.Config) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "ffmpeg_mux_subtitles")
+	defer span.End()
+
+	startTime := time.Now()
+	argsMap, err := getArguments(request)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	log.Printf("Handling %s request with arguments: %v", "ffmpeg_mux_subtitles", argsMap)
+
+	inputVideoURI, _ := argsMap["input_video_uri"].(string)
+	inputVideoURI = strings.TrimSpace(inputVideoURI)
+	if inputVideoURI == "" {
+		return invalidParamResult("input_video_uri", reasonRequired), nil
+	}
+	outputFileName, _ := argsMap["output_file_name"].(string)
+	outputFileName = strings.TrimSpace(outputFileName)
+	container, err := subtitleMuxContainerFor(outputFileName)
+	if err != nil {
+		return invalidParamResult("output_file_name", "%v", err), nil
+	}
+	tracksRaw, ok := argsMap["subtitle_tracks"].([]interface{})
+	if !ok {
+		return invalidParamResult("subtitle_tracks", "an array of {subtitle_uri, language} objects is required"), nil
+	}
+	tracks, validationErr := parseSubtitleTracks(tracksRaw, container)
+	if validationErr != nil {
+		return validationErrorResult(validationErr), nil
+	}
+	outputLocalDir, _ := argsMap["output_local_dir"].(string)
+	outputGCSBucket, _ := argsMap["output_gcs_bucket"].(string)
+	outputGCSBucket = strings.TrimSpace(outputGCSBucket)
+	if outputGCSBucket == "" && !hasOutputGCSBuckets(argsMap) {
+		if bucket, source := cfg.DefaultBucketFor(common.OutputCategoryVideo); bucket != "" {
+			outputGCSBucket = bucket
+			log.Printf("Handler ffmpeg_mux_subtitles: 'output_gcs_bucket' parameter not provided, using default from %s: %s", source, outputGCSBucket)
+		}
+	}
+	if outputGCSBucket != "" {
+		outputGCSBucket = strings.TrimPrefix(outputGCSBucket, "gs://")
+	}
+	outputGCSBuckets := collectOutputGCSBuckets(outputGCSBucket, argsMap)
+
+	languages := make([]string, len(tracks))
+	for i, track := range tracks {
+		languages[i] = track.Language
+	}
+	span.SetAttributes(
+		attribute.String("input_video_uri", inputVideoURI),
+		attribute.StringSlice("subtitle_languages", languages),
+		attribute.String("container", container.Extension),
+		attribute.String("output_file_name", outputFileName),
+		attribute.String("output_local_dir", outputLocalDir),
+		attribute.String("output_gcs_bucket", outputGCSBucket),
+	)
+
+	localVideo, videoCleanup, err := prepareInputFile(ctx, inputVideoURI, "input_video_mux_subtitles", cfg.ProjectID)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare input video: %v", err)), nil
+	}
+	defer videoCleanup()
+	localSubtitles := make([]string, len(tracks))
+	for i, track := range tracks {
+		localSubtitle, subtitleCleanup, err := prepareInputFile(ctx, track.URI, fmt.Sprintf("subtitle_track_%d", i), cfg.ProjectID)
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare subtitle track %d (%s): %v", i, track.URI, err)), nil
+		}
+		defer subtitleCleanup()
+		localSubtitles[i] = localSubtitle
+	}
+
+	tempOutputFile, finalOutputFilename, outputCleanup, err := common.HandleOutputPreparation(outputFileName, container.Extension)
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to prepare output file: %v", err)), nil
+	}
+	defer outputCleanup()
+
+	if _, ffmpegErr := runFFmpegCommand(ctx, buildMuxSubtitlesArgs(localVideo, localSubtitles, tracks, tempOutputFile, container)...); ffmpegErr != nil {
+		span.RecordError(ffmpegErr)
+		return mcp.NewToolResultError(fmt.Sprintf("FFMpeg subtitle mux failed: %v", ffmpegErr)), nil
+	}
+
+	finalLocalPath, gcsUploads, processErr := processOutputToBuckets(ctx, tempOutputFile, finalOutputFilename, outputLocalDir, outputGCSBuckets, cfg.ProjectID)
+	if processErr != nil {
+		span.RecordError(processErr)
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to process FFMpeg output: %v", processErr)), nil
+	}
+	finalGCSPath, gcsUploadIssues := summarizeGCSUploads(gcsUploads)
+
+	duration := time.Since(startTime)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(duration.Milliseconds())))
+
+	messageParts := []string{fmt.Sprintf("Muxed %d subtitle track(s) (%s) into %s in %v.", len(tracks), strings.Join(languages, ", "), strings.ToUpper(container.Extension), duration)}
+	if outputLocalDir != "" && finalLocalPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output saved locally to: %s.", finalLocalPath))
+	} else if finalLocalPath != "" && !(len(outputGCSBuckets) > 0 && finalGCSPath != "") {
+		messageParts = append(messageParts, fmt.Sprintf("Temporary output was at: %s (cleaned up if not moved/uploaded).", finalLocalPath))
+	}
+	if finalGCSPath != "" {
+		messageParts = append(messageParts, fmt.Sprintf("Output uploaded to GCS: %s.", finalGCSPath))
+	}
+	if gcsUploadIssues != "" {
+		messageParts = append(messageParts, gcsUploadIssues)
+	}
+	return mcp.NewToolResultText(strings.Join(messageParts, " ")), nil
+}
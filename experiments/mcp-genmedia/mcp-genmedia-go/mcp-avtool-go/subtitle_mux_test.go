@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildMuxSubtitlesArgsThreeTracks(t *testing.T) {
+	tracks := []subtitleTrack{
+		{URI: "gs://b/en.srt", Language: "eng"},
+		{URI: "gs://b/fr.vtt", Language: "fra"},
+		{URI: "gs://b/ja.ass", Language: "jpn"},
+	}
+	subtitles := []string{"/tmp/en.srt", "/tmp/fr.vtt", "/tmp/ja.ass"}
+	testCases := []struct {
+		name      string
+		container subtitleMuxContainer
+		codec     []string
+		tail      []string
+	}{
+		{"mkv", subtitleMuxMKV, []string{"-c:s", "copy"}, []string{"out.mkv"}},
+		{"mp4", subtitleMuxMP4, []string{"-c:s", "mov_text"}, []string{"-movflags", "+faststart", "out.mp4"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := []string{"-y", "-i", "in.mp4", "-i", "/tmp/en.srt", "-i", "/tmp/fr.vtt", "-i", "/tmp/ja.ass",
+				"-map", "0:v?", "-map", "0:a?", "-map", "1:s", "-map", "2:s", "-map", "3:s",
+				"-c:v", "copy", "-c:a", "copy"}
+			want = append(want, tc.codec...)
+			want = append(want,
+				"-metadata:s:s:0", "language=eng", "-metadata:s:s:1", "language=fra", "-metadata:s:s:2", "language=jpn",
+				"-disposition:s:0", "default", "-disposition:s:1", "0", "-disposition:s:2", "0")
+			want = append(want, tc.tail...)
+			got := buildMuxSubtitlesArgs("in.mp4", subtitles, tracks, "out."+tc.container.Extension, tc.container)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected args:\n got: %q\nwant: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSubtitleMuxContainerFor(t *testing.T) {
+	testCases := map[string]string{"": "mkv", "multi.MKV": "mkv", "out.mp4": "mp4", "clip.mov": "mov"}
+	for name, want := range testCases {
+		container, err := subtitleMuxContainerFor(name)
+		if err != nil || container.Extension != want {
+			t.Errorf("%q: expected %s, got %+v %v", name, want, container, err)
+		}
+	}
+	if _, err := subtitleMuxContainerFor("out.webm"); err == nil {
+		t.Error("expected an error for a .webm output")
+	}
+}
+
+func TestParseSubtitleTracks(t *testing.T) {
+	raw := []interface{}{
+		map[string]interface{}{"subtitle_uri": "en.srt", "language": "en"},
+		map[string]interface{}{"subtitle_uri": " pt.vtt ", "language": "pt-BR"},
+	}
+	tracks, err := parseSubtitleTracks(raw, subtitleMuxMKV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []subtitleTrack{{URI: "en.srt", Language: "en"}, {URI: "pt.vtt", Language: "pt-BR"}}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Errorf("expected %+v, got %+v", want, tracks)
+	}
+	if _, err := parseSubtitleTracks(raw, subtitleMuxMP4); err == nil || err.Field != "subtitle_tracks[0].language" || !strings.Contains(err.Reason, "three-letter") {
+		t.Errorf("expected MP4 to reject two-letter codes, got %v", err)
+	}
+}
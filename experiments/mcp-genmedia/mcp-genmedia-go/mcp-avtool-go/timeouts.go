@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The operation phases that have their own time limit. They name the phase in timeout errors.
+const (
+	phaseFFprobe     = "ffprobe"
+	phaseFFmpeg      = "ffmpeg"
+	phaseGCSTransfer = "gcs_transfer"
+)
+
+// phaseTimeoutEnvVars are the environment variables that set each phase's limit.
+var phaseTimeoutEnvVars = map[string]string{
+	phaseFFprobe:     "FFPROBE_TIMEOUT",
+	phaseFFmpeg:      "FFMPEG_TIMEOUT",
+	phaseGCSTransfer: "GCS_TRANSFER_TIMEOUT",
+}
+
+// defaultOperationTimeouts are the limits used when the environment does not set them. ffprobe
+// only reads headers and should finish in seconds; a long encode can legitimately take minutes.
+var defaultOperationTimeouts = map[string]time.Duration{
+	phaseFFprobe:     30 * time.Second,
+	phaseFFmpeg:      10 * time.Minute,
+	phaseGCSTransfer: 5 * time.Minute,
+}
+
+// operationTimeouts are the limits in effect, set from the environment at startup.
+var operationTimeouts = defaultOperationTimeouts
+
+// subprocessWaitDelay bounds how long a killed FFmpeg or ffprobe may keep its output pipes open,
+// e.g. through a child process, before the call returns anyway.
+const subprocessWaitDelay = 2 * time.Second
+
+// loadOperationTimeouts reads the phase limits from the environment. A value is a Go duration
+// such as "45s" or "15m", or a plain number of seconds.
+func loadOperationTimeouts(lookup func(string) (string, bool)) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(defaultOperationTimeouts))
+	for phase, limit := range defaultOperationTimeouts {
+		timeouts[phase] = limit
+		envVar := phaseTimeoutEnvVars[phase]
+		value, ok := lookup(envVar)
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+		parsed, err := parseTimeout(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", envVar, err)
+		}
+		timeouts[phase] = parsed
+	}
+	return timeouts, nil
+}
+
+// parseTimeout parses a positive duration, given as a Go duration or a number of seconds.
+func parseTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		secs, numErr := strconv.ParseFloat(value, 64)
+		if numErr != nil {
+			return 0, fmt.Errorf("'%s' is not a duration such as '45s' or '10m', or a number of seconds", value)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive, got '%s'", value)
+	}
+	return d, nil
+}
+
+// timeoutError reports an operation that was stopped because it ran past its time limit.
+type timeoutError struct {
+	Phase string
+	Limit time.Duration
+	// Source is what set the limit: the phase's environment variable or 'timeout_seconds'.
+	Source string
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("TIMEOUT: the %s phase did not finish within %v (limit set by %s)", e.Phase, e.Limit, e.Source)
+}
+
+// Unwrap lets errors.Is match a timeout against context.DeadlineExceeded.
+func (e *timeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// requestTimeouts carries the per-request 'timeout_seconds' cap and records the first operation
+// that timed out while handling the request, for timeoutMiddleware to report.
+type requestTimeouts struct {
+	Limit time.Duration // 0 when the request sets no cap
+
+	mu       sync.Mutex
+	timedOut *timeoutError
+}
+
+type requestTimeoutsKey struct{}
+
+func withRequestTimeouts(ctx context.Context, rt *requestTimeouts) context.Context {
+	return context.WithValue(ctx, requestTimeoutsKey{}, rt)
+}
+
+func requestTimeoutsFromContext(ctx context.Context) *requestTimeouts {
+	rt, _ := ctx.Value(requestTimeoutsKey{}).(*requestTimeouts)
+	return rt
+}
+
+func (rt *requestTimeouts) record(err *timeoutError) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.timedOut == nil {
+		rt.timedOut = err
+	}
+}
+
+func (rt *requestTimeouts) firstTimeout() *timeoutError {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.timedOut
+}
+
+// effectiveTimeout returns the limit for one operation of the phase and what set it. The
+// request's 'timeout_seconds' can only lower the configured limit, never raise it.
+func effectiveTimeout(ctx context.Context, phase string) (time.Duration, string) {
+	limit, source := operationTimeouts[phase], phaseTimeoutEnvVars[phase]
+	if rt := requestTimeoutsFromContext(ctx); rt != nil && rt.Limit > 0 && rt.Limit < limit {
+		limit, source = rt.Limit, "timeout_seconds"
+	}
+	return limit, source
+}
+
+// timedOperation is one bounded operation: its context is cancelled at the phase's limit, which
+// also kills a subprocess started with exec.CommandContext.
+type timedOperation struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	parent context.Context
+	phase  string
+	limit  time.Duration
+	source string
+}
+
+// startOperation bounds an operation of the phase. The caller must call cancel when it is done.
+func startOperation(ctx context.Context, phase string) *timedOperation {
+	limit, source := effectiveTimeout(ctx, phase)
+	opCtx, cancel := context.WithTimeout(ctx, limit)
+	return &timedOperation{ctx: opCtx, cancel: cancel, parent: ctx, phase: phase, limit: limit, source: source}
+}
+
+// timeoutErr returns a *timeoutError when the operation ran out of time, and records it for the
+// request. It returns nil when the operation finished, failed on its own, or was cancelled from
+// outside, e.g. by the client.
+func (op *timedOperation) timeoutErr() *timeoutError {
+	if !errors.Is(op.ctx.Err(), context.DeadlineExceeded) || op.parent.Err() != nil {
+		return nil
+	}
+	err := &timeoutError{Phase: op.phase, Limit: op.limit, Source: op.source}
+	if rt := requestTimeoutsFromContext(op.parent); rt != nil {
+		rt.record(err)
+	}
+	return err
+}
+
+// The GCS helpers of mcp-common, as variables so tests can substitute slow fakes.
+var (
+	commonPrepareInputFile          = common.PrepareInputFile
+	commonProcessOutputToBuckets    = common.ProcessOutputAfterFFmpegToBuckets
+	commonProcessOutputDirToBuckets = common.ProcessOutputDirToBuckets
+)
+
+// prepareInputFile is common.PrepareInputFile bounded by the GCS transfer limit.
+func prepareInputFile(ctx context.Context, fileURI, purpose, projectID string) (string, func(), error) {
+	op := startOperation(ctx, phaseGCSTransfer)
+	defer op.cancel()
+	localPath, cleanup, err := commonPrepareInputFile(op.ctx, fileURI, purpose, projectID)
+	if err != nil {
+		if timeout := op.timeoutErr(); timeout != nil {
+			return "", func() {}, timeout
+		}
+	}
+	return localPath, cleanup, err
+}
+
+// processOutputToBuckets is common.ProcessOutputAfterFFmpegToBuckets bounded by the GCS transfer
+// limit. An upload that runs out of time fails the whole call rather than being reported as one
+// bucket's upload issue.
+func processOutputToBuckets(ctx context.Context, ffmpegOutputPath, finalOutputFilename, outputLocalDir string, outputGCSBuckets []string, projectID string) (string, []common.GCSUploadResult, error) {
+	op := startOperation(ctx, phaseGCSTransfer)
+	defer op.cancel()
+	finalLocalPath, uploads, err := commonProcessOutputToBuckets(op.ctx, ffmpegOutputPath, finalOutputFilename, outputLocalDir, outputGCSBuckets, projectID)
+	if timeout := op.timeoutErr(); timeout != nil {
+		return finalLocalPath, uploads, timeout
+	}
+	return finalLocalPath, uploads, err
+}
+
+// reproducibleOutputSHA256 returns the hex-encoded SHA-256 of a reproducible encode's output
+// before it is moved by processOutputToBuckets, and records it on the call's span. It returns ""
+// when the encode is not reproducible.
+func reproducibleOutputSHA256(ctx context.Context, outputPath string, reproducible bool) (string, error) {
+	if !reproducible {
+		return "", nil
+	}
+	span := trace.SpanFromContext(ctx)
+	sum, err := fileSHA256(outputPath)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	span.SetAttributes(attribute.String("output_sha256", sum))
+	return sum, nil
+}
+
+// processOutputDirToBuckets is common.ProcessOutputDirToBuckets bounded by the GCS transfer limit.
+func processOutputDirToBuckets(ctx context.Context, outputDir, outputLocalDir string, outputGCSBuckets []string, gcsPrefix, projectID string) ([]common.OutputDirFile, []common.GCSUploadResult, error) {
+	op := startOperation(ctx, phaseGCSTransfer)
+	defer op.cancel()
+	files, uploads, err := commonProcessOutputDirToBuckets(op.ctx, outputDir, outputLocalDir, outputGCSBuckets, gcsPrefix, projectID)
+	if timeout := op.timeoutErr(); timeout != nil {
+		return files, uploads, timeout
+	}
+	return files, uploads, err
+}
+
+// withTimeoutParam adds the shared 'timeout_seconds' parameter to a tool definition. It is applied
+// to the request by timeoutMiddleware.
+func withTimeoutParam() mcp.ToolOption {
+	return mcp.WithNumber("timeout_seconds",
+		mcp.Description("Optional. Time limit in seconds for each FFmpeg, ffprobe and GCS transfer step of this call. It can only lower the server's limits (FFMPEG_TIMEOUT, FFPROBE_TIMEOUT, GCS_TRANSFER_TIMEOUT), never raise them. A step that runs out of time is stopped and the call fails with a TIMEOUT error naming the phase."),
+	)
+}
+
+// timeoutMiddleware reads 'timeout_seconds' from the request and makes it available to the bounded
+// operations through the context. When an operation timed out and the tool failed, the result
+// carries the timeout as structured content:
+//
+//	{"error": {"type": "timeout", "phase": "<phase>", "timeout_seconds": <limit>, "limit_source": "<source>", "message": "<text>"}}
+func timeoutMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		rt := &requestTimeouts{}
+		if argsMap, ok := request.Params.Arguments.(map[string]interface{}); ok {
+			if raw, present := argsMap["timeout_seconds"]; present && raw != nil {
+				secs, ok := raw.(float64)
+				if !ok || secs <= 0 {
+					return invalidParamResult("timeout_seconds", "must be a positive number of seconds"), nil
+				}
+				rt.Limit = time.Duration(secs * float64(time.Second))
+			}
+		}
+		result, err := next(withRequestTimeouts(ctx, rt), request)
+		if timeout := rt.firstTimeout(); timeout != nil && err == nil && result != nil && result.IsError {
+			result.StructuredContent = map[string]interface{}{
+				"error": map[string]interface{}{
+					"type":            "timeout",
+					"phase":           timeout.Phase,
+					"timeout_seconds": timeout.Limit.Seconds(),
+					"limit_source":    timeout.Source,
+					"message":         timeout.Error(),
+				},
+			}
+		}
+		return result, err
+	}
+}
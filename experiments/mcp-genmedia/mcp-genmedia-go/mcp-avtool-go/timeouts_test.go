@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// setOperationTimeouts replaces the phase limits for the duration of a test.
+func setOperationTimeouts(t *testing.T, timeouts map[string]time.Duration) {
+	t.Helper()
+	original := operationTimeouts
+	merged := make(map[string]time.Duration, len(defaultOperationTimeouts))
+	for phase, limit := range defaultOperationTimeouts {
+		merged[phase] = limit
+	}
+	for phase, limit := range timeouts {
+		merged[phase] = limit
+	}
+	operationTimeouts = merged
+	t.Cleanup(func() { operationTimeouts = original })
+}
+
+// fakeSlowCommand writes a script that sleeps well past any limit used in the tests.
+func fakeSlowCommand(t *testing.T, name string) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestLoadOperationTimeouts(t *testing.T) {
+	lookup := func(env map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			value, ok := env[key]
+			return value, ok
+		}
+	}
+
+	timeouts, err := loadOperationTimeouts(lookup(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if timeouts[phaseFFprobe] != 30*time.Second || timeouts[phaseFFmpeg] != 10*time.Minute || timeouts[phaseGCSTransfer] != 5*time.Minute {
+		t.Errorf("unexpected defaults: %v", timeouts)
+	}
+
+	timeouts, err = loadOperationTimeouts(lookup(map[string]string{"FFPROBE_TIMEOUT": "45s", "GCS_TRANSFER_TIMEOUT": "90"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if timeouts[phaseFFprobe] != 45*time.Second || timeouts[phaseGCSTransfer] != 90*time.Second || timeouts[phaseFFmpeg] != 10*time.Minute {
+		t.Errorf("unexpected overrides: %v", timeouts)
+	}
+
+	for _, value := range []string{"soon", "0", "-5s"} {
+		if _, err := loadOperationTimeouts(lookup(map[string]string{"FFMPEG_TIMEOUT": value})); err == nil || !strings.Contains(err.Error(), "FFMPEG_TIMEOUT") {
+			t.Errorf("FFMPEG_TIMEOUT=%q: expected an error naming the variable, got %v", value, err)
+		}
+	}
+}
+
+func TestRunFFmpegCommandTimeout(t *testing.T) {
+	setOperationTimeouts(t, map[string]time.Duration{phaseFFmpeg: 200 * time.Millisecond})
+	originalBinary := ffmpegBinary
+	ffmpegBinary = fakeSlowCommand(t, "ffmpeg")
+	t.Cleanup(func() { ffmpegBinary = originalBinary })
+
+	start := time.Now()
+	_, err := runFFmpegCommand(context.Background(), "-i", "in.mp4", "out.mp4")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the slow FFmpeg to be killed, but the call took %v", elapsed)
+	}
+	var timeout *timeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if timeout.Phase != phaseFFmpeg || timeout.Source != "FFMPEG_TIMEOUT" {
+		t.Errorf("unexpected timeout attribution: %+v", timeout)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the timeout to match context.DeadlineExceeded")
+	}
+}
+
+func TestExecuteGetMediaInfoTimeout(t *testing.T) {
+	setOperationTimeouts(t, map[string]time.Duration{phaseFFprobe: 200 * time.Millisecond})
+	originalBinary := ffprobeBinary
+	ffprobeBinary = fakeSlowCommand(t, "ffprobe")
+	t.Cleanup(func() { ffprobeBinary = originalBinary })
+
+	start := time.Now()
+	_, err := executeGetMediaInfo(context.Background(), "corrupt.mp4")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the slow ffprobe to be killed, but the call took %v", elapsed)
+	}
+	var timeout *timeoutError
+	if !errors.As(err, &timeout) || timeout.Phase != phaseFFprobe {
+		t.Fatalf("expected an ffprobe timeout, got %v", err)
+	}
+}
+
+func TestRequestTimeoutOnlyLowersLimit(t *testing.T) {
+	setOperationTimeouts(t, map[string]time.Duration{phaseFFprobe: 30 * time.Second})
+
+	lowered := withRequestTimeouts(context.Background(), &requestTimeouts{Limit: time.Second})
+	if limit, source := effectiveTimeout(lowered, phaseFFprobe); limit != time.Second || source != "timeout_seconds" {
+		t.Errorf("expected the request to lower the limit to 1s, got %v from %s", limit, source)
+	}
+
+	raised := withRequestTimeouts(context.Background(), &requestTimeouts{Limit: time.Hour})
+	if limit, source := effectiveTimeout(raised, phaseFFprobe); limit != 30*time.Second || source != "FFPROBE_TIMEOUT" {
+		t.Errorf("expected the request not to raise the limit, got %v from %s", limit, source)
+	}
+}
+
+func TestPrepareInputFileTimeout(t *testing.T) {
+	setOperationTimeouts(t, map[string]time.Duration{phaseGCSTransfer: 100 * time.Millisecond})
+	original := commonPrepareInputFile
+	commonPrepareInputFile = func(ctx context.Context, fileURI, purpose, projectID string) (string, func(), error) {
+		<-ctx.Done()
+		return "", func() {}, ctx.Err()
+	}
+	t.Cleanup(func() { commonPrepareInputFile = original })
+
+	_, cleanup, err := prepareInputFile(context.Background(), "gs://bucket/in.mp4", "input", "project")
+	cleanup()
+	var timeout *timeoutError
+	if !errors.As(err, &timeout) || timeout.Phase != phaseGCSTransfer || timeout.Source != "GCS_TRANSFER_TIMEOUT" {
+		t.Fatalf("expected a gcs_transfer timeout, got %v", err)
+	}
+}
+
+func TestCancelledRequestIsNotATimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	op := startOperation(ctx, phaseFFmpeg)
+	defer op.cancel()
+	cancel()
+	if err := op.timeoutErr(); err != nil {
+		t.Errorf("expected a client cancellation not to be reported as a timeout, got %v", err)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	setOperationTimeouts(t, map[string]time.Duration{phaseFFprobe: 30 * time.Second})
+	originalBinary := ffprobeBinary
+	ffprobeBinary = fakeSlowCommand(t, "ffprobe")
+	t.Cleanup(func() { ffprobeBinary = originalBinary })
+
+	cfg := &common.Config{}
+	handler := timeoutMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return ffmpegGetMediaInfoHandler(ctx, request, cfg)
+	})
+	newRequest := func(args map[string]interface{}) mcp.CallToolRequest {
+		return mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+	}
+
+	t.Run("timeout reported as structured content", func(t *testing.T) {
+		input := filepath.Join(t.TempDir(), "corrupt.mp4")
+		if err := os.WriteFile(input, []byte("not media"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		result, err := handler(context.Background(), newRequest(map[string]interface{}{
+			"input_media_uri": input,
+			"timeout_seconds": 0.2,
+		}))
+		if err != nil || !result.IsError {
+			t.Fatalf("expected an error result, got %v, %v", result, err)
+		}
+		structured, _ := result.StructuredContent.(map[string]interface{})
+		details, _ := structured["error"].(map[string]interface{})
+		if details["type"] != "timeout" || details["phase"] != phaseFFprobe || details["limit_source"] != "timeout_seconds" || details["timeout_seconds"] != 0.2 {
+			t.Errorf("unexpected structured error: %v", result.StructuredContent)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !strings.Contains(text, "TIMEOUT") || !strings.Contains(text, "ffprobe phase") {
+			t.Errorf("expected the error text to name the ffprobe phase, got %q", text)
+		}
+	})
+
+	t.Run("invalid timeout_seconds", func(t *testing.T) {
+		for _, value := range []interface{}{0.0, -3.0, "ten"} {
+			result, _ := handler(context.Background(), newRequest(map[string]interface{}{
+				"input_media_uri": "in.mp4",
+				"timeout_seconds": value,
+			}))
+			structured, _ := result.StructuredContent.(map[string]interface{})
+			details, _ := structured["error"].(map[string]interface{})
+			if !result.IsError || details["type"] != "validation" || details["field"] != "timeout_seconds" {
+				t.Errorf("timeout_seconds=%v: unexpected result %v", value, result.StructuredContent)
+			}
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// reasonRequired is the validation reason for a missing or blank required parameter.
+const reasonRequired = "a value is required"
+
+// validationError reports a tool parameter that failed validation. Field is the parameter name
+// exactly as it appears in the tool schema, so programmatic callers can tell which one to fix.
+type validationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func newValidationError(field, format string, args ...interface{}) *validationError {
+	return &validationError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Error formats every validation failure the same way: "Invalid parameter '<field>': <reason>."
+func (e *validationError) Error() string {
+	return fmt.Sprintf("Invalid parameter '%s': %s.", e.Field, e.Reason)
+}
+
+// validationErrorResult returns the MCP error result for a validation failure. The text content
+// is the formatted message, and the structured content carries the same failure as
+//
+//	{"error": {"type": "validation", "field": "<field>", "reason": "<reason>", "message": "<text>"}}
+//
+// for clients that read structured tool results.
+func validationErrorResult(err *validationError) *mcp.CallToolResult {
+	result := mcp.NewToolResultError(err.Error())
+	result.StructuredContent = map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":    "validation",
+			"field":   err.Field,
+			"reason":  err.Reason,
+			"message": err.Error(),
+		},
+	}
+	return result
+}
+
+// invalidParamResult is shorthand for validationErrorResult(newValidationError(...)).
+func invalidParamResult(field, format string, args ...interface{}) *mcp.CallToolResult {
+	return validationErrorResult(newValidationError(field, format, args...))
+}
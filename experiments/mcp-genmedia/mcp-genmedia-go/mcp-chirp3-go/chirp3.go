@@ -31,7 +31,7 @@ var (
 	availableVoices     []*texttospeechpb.Voice
 	transport           string
 	port                string
-	version             = "0.1.0" // Add prompt support
+	version             = "0.3.0" // Add chirp_list_controls and markup validation
 )
 
 const (
@@ -82,7 +82,6 @@ func init() {
 	flag.StringVar(&transport, "t", "stdio", "Transport type (stdio, sse, or http)")
 	flag.StringVar(&transport, "transport", "stdio", "Transport type (stdio, sse, or http)")
 	flag.StringVar(&port, "p", "8080", "Port for SSE server if transport is sse") // This port is for SSE, HTTP will use its own.
-	flag.Parse()
 
 	titleCaser := cases.Title(language.Und)
 	for k := range LanguageNameToCodeMap {
@@ -194,9 +193,11 @@ func parseMcpPronunciations(pronunciationsParam interface{}, encodingStr string)
 // main is the entry point for the mcp-chirp3-go service.
 // It initializes the OpenTelemetry provider, the Google Cloud Text-to-Speech client,
 // and caches the available Chirp3-HD voices. It then sets up an MCP server, registers
-// the 'chirp_tts' and 'list_chirp_voices' tools, and starts listening for requests
+// the 'chirp_tts', 'list_chirp_voices' and 'chirp_voice_sample' tools, and starts listening for requests
 // on the configured transport (stdio, sse, or http).
 func main() {
+	flag.Parse()
+
 	// Initialize OpenTelemetry
 	tp, err := common.InitTracerProvider(serviceName, version)
 	if err != nil {
@@ -232,7 +233,7 @@ func main() {
 		mcp.WithDescription("Synthesizes speech from text using Google Cloud TTS with Chirp3-HD voices. Returns audio data and optionally saves it locally."),
 		mcp.WithString("text",
 			mcp.Required(),
-			mcp.Description("The text to synthesize into speech."),
+			mcp.Description("The text to synthesize into speech. Plain text can contain the pause tags [pause short], [pause] and [pause long]; an SSML document wrapped in <speak> can use the supported SSML tags. Use chirp_list_controls to see the supported markup."),
 		),
 		mcp.WithString("voice_name",
 			mcp.Description(fmt.Sprintf("Optional. The specific Chirp3-HD voice name to use (e.g., '%s'). If not provided, defaults to '%s' if available, otherwise the first available Chirp3-HD voice.", defaultChirpVoiceName, defaultChirpVoiceName)),
@@ -253,6 +254,10 @@ func main() {
 			mcp.Description("Optional. The phonetic encoding used for the 'pronunciations' array. Can be 'ipa' or 'xsampa'. Defaults to 'ipa'."),
 			mcp.Enum("ipa", "xsampa"), // Specify allowed values
 		),
+		mcp.WithBoolean("strict",
+			mcp.DefaultBool(true),
+			mcp.Description("Optional. If true (the default), text with markup Chirp3-HD voices don't support is rejected with a list of the offending tags and suggested alternatives. If false, unsupported tags are stripped and the rest of the text is synthesized."),
+		),
 	)
 	s.AddTool(chirpTool, func(toolCtx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return chirpTTSHandler(ttsClient, toolCtx, request)
@@ -267,6 +272,25 @@ func main() {
 	)
 	s.AddTool(listVoicesTool, listChirpVoicesHandler)
 
+	listControlsTool := mcp.NewTool("chirp_list_controls",
+		mcp.WithDescription("Lists the markup chirp_tts accepts for Chirp3-HD voices (pause tags and supported SSML tags) with their constraints, from a versioned built-in table. Returns JSON."),
+	)
+	s.AddTool(listControlsTool, chirpListControlsHandler)
+
+	voiceSamples = newVoiceSampleCache()
+	voiceSampleTool := mcp.NewTool("chirp_voice_sample",
+		mcp.WithDescription("Returns a short standard sample of a Chirp3-HD voice, reading a fixed sentence in the voice's language, to compare voices before choosing one. Samples are generated once per voice and cached in GENMEDIA_BUCKET under voice_samples/, or in a local cache directory when no bucket is set; later calls return the cached sample's URI immediately."),
+		mcp.WithString("voice_name",
+			mcp.Required(),
+			mcp.Description(fmt.Sprintf("The Chirp3-HD voice to sample (e.g., '%s'). Use list_chirp_voices to find voice names.", defaultChirpVoiceName)),
+		),
+		mcp.WithBoolean("force_regenerate",
+			mcp.DefaultBool(false),
+			mcp.Description("Optional. Generate the sample again and replace the cached one."),
+		),
+	)
+	s.AddTool(voiceSampleTool, chirpVoiceSampleHandler)
+
 	// Add the new list-voices prompt
 	s.AddPrompt(mcp.NewPrompt("list-voices",
 		mcp.WithPromptDescription("Lists available Chirp3-HD voices, with an option to filter by language."),
@@ -352,7 +376,7 @@ func main() {
 			log.Printf("Transport is SSE but no port specified, defaulting to %s", port)
 		}
 		sseServer := server.NewSSEServer(s, server.WithBaseURL(fmt.Sprintf("http://localhost:%s", port)))
-		log.Printf("%s MCP Server listening on SSE at :%s with tools: chirp_tts, list_chirp_voices", serviceName, port)
+		log.Printf("%s MCP Server listening on SSE at :%s with tools: chirp_tts, list_chirp_voices, chirp_list_controls, chirp_voice_sample", serviceName, port)
 		if err := sseServer.Start(fmt.Sprintf(":%s", port)); err != nil {
 			log.Fatalf("SSE Server error: %v", err)
 		}
@@ -375,7 +399,7 @@ func main() {
 
 		httpPort := common.GetEnv("PORT", "8080")
 		listenAddr := fmt.Sprintf(":%s", httpPort)
-		log.Printf("%s MCP Server listening on HTTP at %s/mcp with tools: chirp_tts, list_chirp_voices and CORS enabled", serviceName, listenAddr)
+		log.Printf("%s MCP Server listening on HTTP at %s/mcp with tools: chirp_tts, list_chirp_voices, chirp_list_controls, chirp_voice_sample and CORS enabled", serviceName, listenAddr)
 		// Start the server using the wrapped handler
 		if err := http.ListenAndServe(listenAddr, handlerWithCORS); err != nil {
 			log.Fatalf("HTTP Server error: %v", err)
@@ -384,7 +408,7 @@ func main() {
 		if transport != "stdio" && transport != "" {
 			log.Printf("Unsupported transport type '%s' specified, defaulting to stdio.", transport)
 		}
-		log.Printf("%s MCP Server listening on STDIO with tools: chirp_tts, list_chirp_voices", serviceName)
+		log.Printf("%s MCP Server listening on STDIO with tools: chirp_tts, list_chirp_voices, chirp_list_controls, chirp_voice_sample", serviceName)
 		if err := server.ServeStdio(s); err != nil {
 			log.Fatalf("STDIO Server error: %v", err)
 		}
@@ -419,6 +443,28 @@ func chirpTTSHandler(client *texttospeech.Client, ctx context.Context, request m
 		return &mcp.CallToolResult{Content: contentItems}, nil
 	}
 
+	strict := true
+	if strictParam, ok := request.GetArguments()["strict"].(bool); ok {
+		strict = strictParam
+	}
+	var strippedMarkupMessage string
+	if unsupported := findUnsupportedMarkup(text); len(unsupported) > 0 {
+		if strict {
+			log.Printf("Rejecting chirp_tts text with %d unsupported tags.", len(unsupported))
+			return unsupportedMarkupResult(unsupported), nil
+		}
+		var tags []string
+		for _, tag := range unsupported {
+			tags = append(tags, tag.Tag)
+		}
+		text = stripUnsupportedMarkup(text)
+		if strings.TrimSpace(text) == "" {
+			return mcp.NewToolResultError("text parameter has nothing left to synthesize once unsupported markup is stripped"), nil
+		}
+		strippedMarkupMessage = fmt.Sprintf("Stripped unsupported markup: %s.", strings.Join(tags, ", "))
+		log.Print(strippedMarkupMessage)
+	}
+
 	// Handle custom pronunciations
 	pronunciationsParam, _ := request.GetArguments()["pronunciations"] // This will be []interface{} or nil
 	pronunciationEncodingStr, _ := request.GetArguments()["pronunciation_encoding"].(string)
@@ -553,9 +599,10 @@ func chirpTTSHandler(client *texttospeech.Client, ctx context.Context, request m
 		fileSaveMessage = "Audio data is included in the response."
 	}
 
-	resultText := fmt.Sprintf("Speech synthesized successfully with voice %s. %s",
+	resultText := fmt.Sprintf("Speech synthesized successfully with voice %s. %s %s",
 		selectedVoice.Name,
 		fileSaveMessage,
+		strippedMarkupMessage,
 	)
 	textItem := mcp.TextContent{Type: "text", Text: strings.TrimSpace(resultText)}
 
@@ -578,11 +625,10 @@ func chirpTTSHandler(client *texttospeech.Client, ctx context.Context, request m
 // It constructs the synthesis request with the specified voice, text, and custom pronunciations,
 // sends it to the API, and returns the raw audio content as a byte slice.
 func synthesizeWithVoice(ctx context.Context, client *texttospeech.Client, voice *texttospeechpb.Voice, textToSynthesize string, customPronos *texttospeechpb.CustomPronunciations) ([]byte, error) {
+	input := synthesisInputFor(textToSynthesize)
+	input.CustomPronunciations = customPronos // Set custom pronunciations here
 	req := texttospeechpb.SynthesizeSpeechRequest{
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource:          &texttospeechpb.SynthesisInput_Text{Text: textToSynthesize},
-			CustomPronunciations: customPronos, // Set custom pronunciations here
-		},
+		Input: input,
 		Voice: &texttospeechpb.VoiceSelectionParams{
 			LanguageCode: voice.GetLanguageCodes()[0],
 			Name:         voice.GetName(),
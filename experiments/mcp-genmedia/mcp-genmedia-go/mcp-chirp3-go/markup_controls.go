@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// markupControlsVersion identifies the contents of supportedMarkupControls. Bump it whenever the
+// table changes, so callers that cached the output of chirp_list_controls know to refresh it.
+const markupControlsVersion = "2025-10-01"
+
+// Kinds of markup control.
+const (
+	controlPause = "pause" // bracketed pause tag, sent as markup input
+	controlSSML  = "ssml"  // SSML element, sent as SSML input inside <speak>
+)
+
+// markupControl is one piece of markup Chirp 3 HD voices understand. Name is the bracket text of
+// a pause tag or the element name of an SSML tag.
+type markupControl struct {
+	Tag         string `json:"tag"`
+	Name        string `json:"-"`
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	Constraints string `json:"constraints"`
+}
+
+// supportedMarkupControls is the markup chirp_tts accepts. Anything else in the text is rejected
+// (or stripped with strict: false) rather than sent to the API, which reads unknown bracket tags
+// aloud or ignores unknown SSML without telling anyone.
+var supportedMarkupControls = []markupControl{
+	{Tag: "[pause short]", Name: "pause short", Kind: controlPause, Description: "A short pause, about the length of a comma.", Constraints: "Plain text (markup) input only, not inside <speak>."},
+	{Tag: "[pause]", Name: "pause", Kind: controlPause, Description: "A medium pause, about the length of a sentence break.", Constraints: "Plain text (markup) input only, not inside <speak>."},
+	{Tag: "[pause long]", Name: "pause long", Kind: controlPause, Description: "A long pause, e.g. for a change of topic.", Constraints: "Plain text (markup) input only, not inside <speak>."},
+	{Tag: "<speak>", Name: "speak", Kind: controlSSML, Description: "Root element of an SSML document.", Constraints: "Must wrap the whole text; other SSML tags are only recognized inside it."},
+	{Tag: "<p>", Name: "p", Kind: controlSSML, Description: "A paragraph.", Constraints: "SSML input only."},
+	{Tag: "<s>", Name: "s", Kind: controlSSML, Description: "A sentence.", Constraints: "SSML input only."},
+	{Tag: "<say-as>", Name: "say-as", Kind: controlSSML, Description: "How to read a value, e.g. interpret-as=\"characters\", \"cardinal\" or \"date\".", Constraints: "SSML input only; requires interpret-as."},
+	{Tag: "<sub>", Name: "sub", Kind: controlSSML, Description: "Reads the alias attribute instead of the enclosed text.", Constraints: "SSML input only; requires alias."},
+	{Tag: "<phoneme>", Name: "phoneme", Kind: controlSSML, Description: "Pronounces the enclosed word as given in IPA or X-SAMPA.", Constraints: "SSML input only; requires alphabet and ph. The 'pronunciations' parameter is an alternative that works with every input type."},
+}
+
+// markupAlternatives suggests a supported control for markup that other TTS engines accept but
+// Chirp 3 HD voices don't, by tag name. Names missing here fall back to the nearest supported
+// name of the same kind.
+var markupAlternatives = map[string]string{
+	"break":     "[pause]",
+	"silence":   "[pause]",
+	"sentence":  "<s>",
+	"paragraph": "<p>",
+}
+
+// bracketTagPattern matches bracketed tags that look like controls: lowercase words, such as
+// [pause] or [laughs]. Bracketed text with capitals, digits or punctuation, such as [1] or
+// [Editor's note], is treated as ordinary text.
+var bracketTagPattern = regexp.MustCompile(`\[([a-z]+(?:[ _-][a-z]+)*)\]`)
+
+// ssmlTagPattern matches SSML opening, closing and self-closing tags.
+var ssmlTagPattern = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9:_-]*)[^<>]*>`)
+
+// unsupportedTag is markup in the text that Chirp 3 HD voices won't interpret.
+type unsupportedTag struct {
+	Tag        string `json:"tag"`
+	Reason     string `json:"reason"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// isSSMLInput reports whether text is an SSML document, which chirp_tts sends as SSML input.
+func isSSMLInput(text string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(text)), "<speak")
+}
+
+// findUnsupportedMarkup returns the tags of text that Chirp 3 HD voices won't interpret, in the
+// order they first appear, each listed once. Pause tags are markup and SSML tags are only
+// understood inside <speak>, so each kind is unsupported in the other kind of input.
+func findUnsupportedMarkup(text string) []unsupportedTag {
+	ssml := isSSMLInput(text)
+	type match struct {
+		offset int
+		tag    unsupportedTag
+	}
+	var matches []match
+	seen := map[string]bool{}
+	add := func(offset int, tag unsupportedTag) {
+		if !seen[tag.Tag] {
+			seen[tag.Tag] = true
+			matches = append(matches, match{offset, tag})
+		}
+	}
+
+	for _, loc := range bracketTagPattern.FindAllStringSubmatchIndex(text, -1) {
+		tag, name := text[loc[0]:loc[1]], text[loc[2]:loc[3]]
+		control, known := lookupControl(controlPause, name)
+		switch {
+		case known && ssml:
+			add(loc[0], unsupportedTag{Tag: tag, Reason: "pause tags are not recognized inside <speak>", Suggestion: "remove <speak> and send plain text with " + control.Tag})
+		case !known:
+			add(loc[0], unsupportedTag{Tag: tag, Reason: "not a supported pause tag", Suggestion: suggestControl(controlPause, name)})
+		}
+	}
+
+	for _, loc := range ssmlTagPattern.FindAllStringSubmatchIndex(text, -1) {
+		name := strings.ToLower(text[loc[4]:loc[5]])
+		tag := "<" + name + ">"
+		if _, known := lookupControl(controlSSML, name); !known {
+			add(loc[0], unsupportedTag{Tag: tag, Reason: "not a supported SSML tag", Suggestion: suggestControl(controlSSML, name)})
+		} else if !ssml {
+			add(loc[0], unsupportedTag{Tag: tag, Reason: "SSML tags are only recognized when the whole text is wrapped in <speak>", Suggestion: "wrap the text in <speak>...</speak>"})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].offset < matches[j].offset })
+	tags := make([]unsupportedTag, len(matches))
+	for i, m := range matches {
+		tags[i] = m.tag
+	}
+	return tags
+}
+
+// stripUnsupportedMarkup removes the tags findUnsupportedMarkup reports. Bracket tags are removed
+// whole; SSML tags are removed but the text they enclose is kept. Runs of spaces left behind are
+// collapsed.
+func stripUnsupportedMarkup(text string) string {
+	unsupported := map[string]bool{}
+	for _, tag := range findUnsupportedMarkup(text) {
+		unsupported[tag.Tag] = true
+	}
+	if len(unsupported) == 0 {
+		return text
+	}
+	text = bracketTagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		if unsupported[tag] {
+			return ""
+		}
+		return tag
+	})
+	text = ssmlTagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		name := strings.ToLower(ssmlTagPattern.FindStringSubmatch(tag)[2])
+		if unsupported["<"+name+">"] {
+			return ""
+		}
+		return tag
+	})
+	return strings.TrimSpace(multipleSpaces.ReplaceAllString(text, " "))
+}
+
+var multipleSpaces = regexp.MustCompile(`[ \t]{2,}`)
+
+// lookupControl returns the supported control of a kind with the given name.
+func lookupControl(kind, name string) (markupControl, bool) {
+	for _, control := range supportedMarkupControls {
+		if control.Kind == kind && control.Name == strings.ToLower(name) {
+			return control, true
+		}
+	}
+	return markupControl{}, false
+}
+
+// suggestControl returns the supported control closest to an unsupported tag name: a known
+// alternative first, then the supported name of the same kind with the smallest edit distance,
+// if it is close enough to be a likely typo. It returns "" when nothing is close.
+func suggestControl(kind, name string) string {
+	name = strings.ToLower(name)
+	if alternative, ok := markupAlternatives[name]; ok {
+		return alternative
+	}
+	if kind == controlPause {
+		// word order, separators and extra qualifiers vary between engines: [long pause],
+		// [pause-long] and [pause medium] all have the words of a supported tag
+		words := map[string]bool{}
+		for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == ' ' || r == '_' || r == '-' }) {
+			words[word] = true
+		}
+		best, bestWords := "", 0
+		for _, control := range supportedMarkupControls {
+			controlWords := strings.Fields(control.Name)
+			if control.Kind != kind || len(controlWords) <= bestWords {
+				continue
+			}
+			allFound := true
+			for _, word := range controlWords {
+				allFound = allFound && words[word]
+			}
+			if allFound {
+				best, bestWords = control.Tag, len(controlWords)
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	best, bestDistance := "", 0
+	for _, control := range supportedMarkupControls {
+		if control.Kind != kind {
+			continue
+		}
+		if d := editDistance(name, control.Name); best == "" || d < bestDistance {
+			best, bestDistance = control.Tag, d
+		}
+	}
+	if bestDistance > max(2, len(name)/3) {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// synthesisInputFor returns the input to send for text: SSML for an SSML document, markup when
+// the text has pause tags, and plain text otherwise.
+func synthesisInputFor(text string) *texttospeechpb.SynthesisInput {
+	switch {
+	case isSSMLInput(text):
+		return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Ssml{Ssml: text}}
+	case bracketTagPattern.MatchString(text):
+		return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Markup{Markup: text}}
+	default:
+		return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Text{Text: text}}
+	}
+}
+
+// unsupportedMarkupResult returns the error result for text with unsupported tags. The text
+// content lists them, and the structured content carries them as
+//
+//	{"error": {"type": "unsupported_markup", "field": "text", "tags": [{"tag", "reason", "suggestion"}], "message"}}
+func unsupportedMarkupResult(tags []unsupportedTag) *mcp.CallToolResult {
+	var parts []string
+	for _, tag := range tags {
+		part := fmt.Sprintf("%s (%s", tag.Tag, tag.Reason)
+		if tag.Suggestion != "" {
+			part += "; try " + tag.Suggestion
+		}
+		parts = append(parts, part+")")
+	}
+	message := fmt.Sprintf("Unsupported markup in 'text': %s. Use chirp_list_controls to see the supported tags, or set 'strict' to false to strip unsupported tags.", strings.Join(parts, ", "))
+	result := mcp.NewToolResultError(message)
+	result.StructuredContent = map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":    "unsupported_markup",
+			"field":   "text",
+			"tags":    tags,
+			"message": message,
+		},
+	}
+	return result
+}
+
+// chirpListControlsHandler returns the supported markup table for the 'chirp_list_controls' tool.
+func chirpListControlsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	listing := struct {
+		Version  string          `json:"version"`
+		Controls []markupControl `json:"controls"`
+	}{markupControlsVersion, supportedMarkupControls}
+	var jsonData strings.Builder
+	encoder := json.NewEncoder(&jsonData)
+	encoder.SetEscapeHTML(false) // keep the SSML tags readable
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(listing); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to encode the supported controls: %v", err)), nil
+	}
+	result := mcp.NewToolResultText(strings.TrimSpace(jsonData.String()))
+	result.StructuredContent = listing
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestFindUnsupportedMarkup(t *testing.T) {
+	testCases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"plain text", "Hello there. See note [1] and [Editor's note].", nil},
+		{"supported pauses", "Welcome back. [pause] Today [pause short] we cook. [pause long] Let's go.", nil},
+		{"supported ssml", `<speak><p><s>Call <say-as interpret-as="characters">SQL</say-as>.</s></p></speak>`, nil},
+		{"unknown pause tags", "Hi [laughs] there [pause medium] and [laughs] again.", []string{"[laughs]", "[pause medium]"}},
+		{"unknown ssml", `<speak>Wait <break time="1s"/> then <prosody rate="slow">slowly</prosody>.</speak>`, []string{"<break>", "<prosody>"}},
+		{"pause inside ssml", "<speak>One [pause] two.</speak>", []string{"[pause]"}},
+		{"ssml without speak", "One <s>two</s> [pause] three.", []string{"<s>"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, tag := range findUnsupportedMarkup(tc.text) {
+				got = append(got, tag.Tag)
+				if tag.Reason == "" {
+					t.Errorf("%s has no reason", tag.Tag)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSuggestControl(t *testing.T) {
+	testCases := []struct {
+		kind, name, want string
+	}{
+		{controlSSML, "break", "[pause]"},
+		{controlPause, "silence", "[pause]"},
+		{controlPause, "pause medium", "[pause]"},
+		{controlPause, "long pause", "[pause long]"},
+		{controlPause, "pause_short", "[pause short]"},
+		{controlPause, "puase", "[pause]"},
+		{controlSSML, "say_as", "<say-as>"},
+		{controlSSML, "phonme", "<phoneme>"},
+		{controlPause, "laughs", ""},
+		{controlSSML, "emphasis", ""},
+	}
+	for _, tc := range testCases {
+		if got := suggestControl(tc.kind, tc.name); got != tc.want {
+			t.Errorf("suggestControl(%s, %q) = %q, want %q", tc.kind, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestStripUnsupportedMarkup(t *testing.T) {
+	testCases := []struct {
+		text, want string
+	}{
+		{"Hi [laughs] there. [pause] Bye.", "Hi there. [pause] Bye."},
+		{`<speak>Wait <break time="1s"/> then <prosody rate="slow">slowly</prosody>.</speak>`, "<speak>Wait then slowly.</speak>"},
+		{"<speak>One [pause] two.</speak>", "<speak>One two.</speak>"},
+		{"One <s>two</s> three.", "One two three."},
+		{"Nothing to strip [1].", "Nothing to strip [1]."},
+	}
+	for _, tc := range testCases {
+		got := stripUnsupportedMarkup(tc.text)
+		if got != tc.want {
+			t.Errorf("stripUnsupportedMarkup(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+		if left := findUnsupportedMarkup(got); len(left) != 0 {
+			t.Errorf("stripping %q left unsupported markup %v", tc.text, left)
+		}
+	}
+}
+
+func TestSynthesisInputFor(t *testing.T) {
+	if _, ok := synthesisInputFor("  <speak>Hi</speak>").GetInputSource().(*texttospeechpb.SynthesisInput_Ssml); !ok {
+		t.Error("expected SSML input for a <speak> document")
+	}
+	if _, ok := synthesisInputFor("Hi [pause] there").GetInputSource().(*texttospeechpb.SynthesisInput_Markup); !ok {
+		t.Error("expected markup input for text with pause tags")
+	}
+	if _, ok := synthesisInputFor("Hi there [1]").GetInputSource().(*texttospeechpb.SynthesisInput_Text); !ok {
+		t.Error("expected text input for plain text")
+	}
+}
+
+func TestChirpTTSRejectsUnsupportedMarkup(t *testing.T) {
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"text": `Hello <break time="500ms"/> world [laughs].`,
+	}}}
+	// the markup is checked before a voice is picked or the API is called, so no client is needed
+	result, err := chirpTTSHandler(nil, context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !result.IsError || !strings.Contains(text, "<break> (not a supported SSML tag; try [pause])") || !strings.Contains(text, "[laughs] (not a supported pause tag)") {
+		t.Errorf("unexpected result (IsError %t): %s", result.IsError, text)
+	}
+	structured := result.StructuredContent.(map[string]interface{})["error"].(map[string]interface{})
+	tags := structured["tags"].([]unsupportedTag)
+	if structured["type"] != "unsupported_markup" || len(tags) != 2 || tags[0].Suggestion != "[pause]" || tags[1].Suggestion != "" {
+		t.Errorf("unexpected structured error %+v", structured)
+	}
+
+	// with strict: false the tags are stripped and synthesis goes ahead; with no voices available
+	// it stops there, after validation
+	original := availableVoices
+	availableVoices = nil
+	t.Cleanup(func() { availableVoices = original })
+	request.Params.Arguments.(map[string]interface{})["strict"] = false
+	result, _ = chirpTTSHandler(nil, context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "No Chirp3-HD voices available") {
+		t.Errorf("expected validation to pass with strict false, got %q", text)
+	}
+}
+
+func TestChirpListControls(t *testing.T) {
+	result, err := chirpListControlsHandler(context.Background(), mcp.CallToolRequest{})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{`"version": "` + markupControlsVersion + `"`, `"tag": "[pause long]"`, `"tag": "<say-as>"`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %s in %s", want, text)
+		}
+	}
+	for _, control := range supportedMarkupControls {
+		if _, ok := lookupControl(control.Kind, control.Name); !ok || control.Description == "" || control.Constraints == "" {
+			t.Errorf("incomplete control %+v", control)
+		}
+	}
+}
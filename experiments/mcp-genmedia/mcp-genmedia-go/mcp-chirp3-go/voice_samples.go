@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// sampleFallbackLanguage is the sentence table entry used for languages the table doesn't cover.
+const sampleFallbackLanguage = "en"
+
+// voiceSampleSentences is the fixed sentence each voice reads for its sample, by primary language
+// subtag. It is the same for every voice of a language so samples can be compared side by side.
+// Where a well-known pangram exists it follows the greeting, so the sample covers most sounds.
+var voiceSampleSentences = map[string]string{
+	"en":  "Hello, this is a sample of my voice. The quick brown fox jumps over the lazy dog.",
+	"de":  "Hallo, das ist eine Hörprobe meiner Stimme. Victor jagt zwölf Boxkämpfer quer über den großen Sylter Deich.",
+	"es":  "Hola, esta es una muestra de mi voz. El veloz murciélago hindú comía feliz cardillo y kiwi.",
+	"fr":  "Bonjour, voici un échantillon de ma voix. Portez ce vieux whisky au juge blond qui fume.",
+	"it":  "Ciao, questo è un campione della mia voce. Quel vituperabile xenofobo zelante assaggia il whisky ed esclama: alleluja!",
+	"pt":  "Olá, esta é uma amostra da minha voz. Um pequeno jabuti xereta viu dez cegonhas felizes.",
+	"nl":  "Hallo, dit is een voorbeeld van mijn stem. Pa's wijze lynx bezag vroom het fikse aquaduct.",
+	"pl":  "Cześć, to jest próbka mojego głosu. Pchnąć w tę łódź jeża lub ośm skrzyń fig.",
+	"ru":  "Здравствуйте, это образец моего голоса. Съешь же ещё этих мягких французских булок, да выпей чаю.",
+	"tr":  "Merhaba, bu benim sesimden bir örnek. Pijamalı hasta yağız şoföre çabucak güvendi.",
+	"id":  "Halo, ini adalah contoh suara saya.",
+	"vi":  "Xin chào, đây là mẫu giọng nói của tôi.",
+	"ja":  "こんにちは。これは私の声のサンプルです。",
+	"ko":  "안녕하세요. 제 목소리 샘플입니다.",
+	"cmn": "你好，这是我的声音样本。",
+	"hi":  "नमस्ते, यह मेरी आवाज़ का एक नमूना है।",
+	"ar":  "مرحبًا، هذه عينة من صوتي.",
+	"th":  "สวัสดี นี่คือตัวอย่างเสียงของฉัน",
+}
+
+// sampleSentence returns the sample sentence for a BCP-47 language code and the table entry it
+// came from. Languages missing from the table get the English sentence, with fellBack set.
+func sampleSentence(languageCode string) (sentence, tableLanguage string, fellBack bool) {
+	primary := strings.ToLower(strings.SplitN(strings.TrimSpace(languageCode), "-", 2)[0])
+	if sentence, ok := voiceSampleSentences[primary]; ok {
+		return sentence, primary, false
+	}
+	return voiceSampleSentences[sampleFallbackLanguage], sampleFallbackLanguage, true
+}
+
+// voiceSampleObjectName is the cache key of a voice's sample: voice_samples/<voice>.wav, with
+// characters that are unsafe in file and object names replaced.
+func voiceSampleObjectName(voiceName string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', ' ':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(voiceName))
+	return "voice_samples/" + safe + ".wav"
+}
+
+// voiceSampleCache stores one generated sample per voice.
+type voiceSampleCache interface {
+	// lookup returns the location of the voice's cached sample, if there is one.
+	lookup(ctx context.Context, voiceName string) (location string, found bool, err error)
+	// store saves the voice's sample and returns its location.
+	store(ctx context.Context, voiceName string, audio []byte) (location string, err error)
+}
+
+// gcsSampleCache keeps the samples under voice_samples/ in a GCS bucket.
+type gcsSampleCache struct {
+	bucket string
+}
+
+func (c gcsSampleCache) uri(voiceName string) string {
+	return fmt.Sprintf("gs://%s/%s", c.bucket, voiceSampleObjectName(voiceName))
+}
+
+func (c gcsSampleCache) lookup(ctx context.Context, voiceName string) (string, bool, error) {
+	uri := c.uri(voiceName)
+	found, err := common.GCSObjectExists(ctx, uri)
+	return uri, found, err
+}
+
+func (c gcsSampleCache) store(ctx context.Context, voiceName string, audio []byte) (string, error) {
+	if err := common.UploadToGCS(ctx, c.bucket, voiceSampleObjectName(voiceName), "audio/wav", audio); err != nil {
+		return "", err
+	}
+	return c.uri(voiceName), nil
+}
+
+// localSampleCache keeps the samples under voice_samples/ in a local directory.
+type localSampleCache struct {
+	dir string
+}
+
+func (c localSampleCache) path(voiceName string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(voiceSampleObjectName(voiceName)))
+}
+
+func (c localSampleCache) lookup(ctx context.Context, voiceName string) (string, bool, error) {
+	path := c.path(voiceName)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return path, false, nil
+	}
+	if err != nil {
+		return path, false, err
+	}
+	return path, info.Size() > 0, nil
+}
+
+func (c localSampleCache) store(ctx context.Context, voiceName string, audio []byte) (string, error) {
+	path := c.path(voiceName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, audio, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// newVoiceSampleCache caches samples in GENMEDIA_BUCKET when it is set, otherwise in
+// VOICE_SAMPLE_CACHE_DIR, which defaults to the user's cache directory.
+func newVoiceSampleCache() voiceSampleCache {
+	if bucket := strings.TrimPrefix(common.GetEnv("GENMEDIA_BUCKET", ""), "gs://"); bucket != "" {
+		return gcsSampleCache{bucket: strings.TrimSuffix(bucket, "/")}
+	}
+	dir := common.GetEnv("VOICE_SAMPLE_CACHE_DIR", "")
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			base = os.TempDir()
+		}
+		dir = filepath.Join(base, serviceName)
+	}
+	return localSampleCache{dir: dir}
+}
+
+// voiceSamples is the sample cache used by the chirp_voice_sample tool.
+var voiceSamples voiceSampleCache
+
+// synthesizeSample voices a sample sentence; it is a variable so tests can avoid the API.
+var synthesizeSample = func(ctx context.Context, voice *texttospeechpb.Voice, text string) ([]byte, error) {
+	return synthesizeWithVoice(ctx, ttsClient, voice, text, nil)
+}
+
+// chirpVoiceSampleHandler is the handler for the 'chirp_voice_sample' tool. The sample of each
+// voice is generated once and then served from the cache, unless force_regenerate is set.
+func chirpVoiceSampleHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	voiceName, _ := request.GetArguments()["voice_name"].(string)
+	voiceName = strings.TrimSpace(voiceName)
+	if voiceName == "" {
+		return mcp.NewToolResultError("'voice_name' parameter must be provided and non-empty."), nil
+	}
+	forceRegenerate, _ := request.GetArguments()["force_regenerate"].(bool)
+
+	var voice *texttospeechpb.Voice
+	for _, v := range availableVoices {
+		if strings.EqualFold(v.GetName(), voiceName) {
+			voice = v
+			break
+		}
+	}
+	if voice == nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Unknown Chirp3-HD voice '%s'. Use list_chirp_voices to find voice names.", voiceName)), nil
+	}
+
+	if !forceRegenerate {
+		location, found, err := voiceSamples.lookup(ctx, voice.GetName())
+		if err != nil {
+			log.Printf("Could not check the cached sample of %s, generating it: %v", voice.GetName(), err)
+		} else if found {
+			return mcp.NewToolResultText(fmt.Sprintf("Sample of voice %s (cached): %s", voice.GetName(), location)), nil
+		}
+	}
+
+	var languageCode string
+	if len(voice.GetLanguageCodes()) > 0 {
+		languageCode = voice.GetLanguageCodes()[0]
+	}
+	sentence, tableLanguage, fellBack := sampleSentence(languageCode)
+	if fellBack {
+		log.Printf("No sample sentence for %s, using the '%s' sentence for %s.", languageCode, tableLanguage, voice.GetName())
+	}
+
+	synthesisCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	audio, err := synthesizeSample(synthesisCtx, voice, sentence)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Error synthesizing the sample of %s: %v", voice.GetName(), err)), nil
+	}
+	if len(audio) == 0 {
+		return mcp.NewToolResultError(fmt.Sprintf("Synthesized sample is empty for voice %s.", voice.GetName())), nil
+	}
+	location, err := voiceSamples.store(ctx, voice.GetName(), audio)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Error caching the sample of %s: %v", voice.GetName(), err)), nil
+	}
+	log.Printf("Generated sample of %s (%d bytes) at %s", voice.GetName(), len(audio), location)
+
+	text := fmt.Sprintf("Sample of voice %s (generated): %s\nSentence (%s): %s", voice.GetName(), location, tableLanguage, sentence)
+	if fellBack {
+		text += fmt.Sprintf("\nThere is no sample sentence for %s yet, so the English sentence was used.", languageCode)
+	}
+	return mcp.NewToolResultText(text), nil
+}
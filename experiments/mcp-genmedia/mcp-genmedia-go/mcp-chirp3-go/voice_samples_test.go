@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestSampleSentence(t *testing.T) {
+	testCases := []struct {
+		languageCode  string
+		wantLanguage  string
+		wantFellBack  bool
+		wantSubstring string
+	}{
+		{"en-US", "en", false, "quick brown fox"},
+		{"en-GB", "en", false, "quick brown fox"},
+		{"fr-CA", "fr", false, "juge blond"},
+		{"DE-de", "de", false, "Sylter Deich"},
+		{"cmn-CN", "cmn", false, "你好"},
+		{"ta-IN", "en", true, "quick brown fox"},
+		{"", "en", true, "quick brown fox"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.languageCode, func(t *testing.T) {
+			sentence, language, fellBack := sampleSentence(tc.languageCode)
+			if language != tc.wantLanguage || fellBack != tc.wantFellBack || !strings.Contains(sentence, tc.wantSubstring) {
+				t.Errorf("sampleSentence(%q) = %q, %q, %t", tc.languageCode, sentence, language, fellBack)
+			}
+		})
+	}
+}
+
+func TestVoiceSampleSentencesCoverLanguageTable(t *testing.T) {
+	for name, code := range LanguageNameToCodeMap {
+		sentence, _, _ := sampleSentence(code)
+		if strings.TrimSpace(sentence) == "" {
+			t.Errorf("no sample sentence for %s (%s)", name, code)
+		}
+	}
+	for language, sentence := range voiceSampleSentences {
+		if strings.TrimSpace(sentence) == "" || language != strings.ToLower(language) {
+			t.Errorf("bad table entry %q: %q", language, sentence)
+		}
+	}
+}
+
+func TestVoiceSampleObjectName(t *testing.T) {
+	for voice, want := range map[string]string{
+		"en-US-Chirp3-HD-Zephyr":   "voice_samples/en-US-Chirp3-HD-Zephyr.wav",
+		" en-US-Chirp3-HD-Puck ":   "voice_samples/en-US-Chirp3-HD-Puck.wav",
+		"projects/x/voices:custom": "voice_samples/projects_x_voices_custom.wav",
+	} {
+		if got := voiceSampleObjectName(voice); got != want {
+			t.Errorf("voiceSampleObjectName(%q) = %q, want %q", voice, got, want)
+		}
+	}
+	gcs := gcsSampleCache{bucket: "media"}
+	if uri := gcs.uri("en-US-Chirp3-HD-Zephyr"); uri != "gs://media/voice_samples/en-US-Chirp3-HD-Zephyr.wav" {
+		t.Errorf("unexpected GCS URI %s", uri)
+	}
+}
+
+func TestLocalSampleCache(t *testing.T) {
+	cache := localSampleCache{dir: t.TempDir()}
+	ctx := context.Background()
+
+	if _, found, err := cache.lookup(ctx, "en-US-Chirp3-HD-Zephyr"); err != nil || found {
+		t.Fatalf("expected an empty cache, got found=%t err=%v", found, err)
+	}
+	stored, err := cache.store(ctx, "en-US-Chirp3-HD-Zephyr", []byte("RIFF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(cache.dir, "voice_samples", "en-US-Chirp3-HD-Zephyr.wav"); stored != want {
+		t.Errorf("stored at %s, want %s", stored, want)
+	}
+	location, found, err := cache.lookup(ctx, "en-US-Chirp3-HD-Zephyr")
+	if err != nil || !found || location != stored {
+		t.Errorf("expected a cache hit at %s, got %s found=%t err=%v", stored, location, found, err)
+	}
+	if _, found, _ := cache.lookup(ctx, "en-US-Chirp3-HD-Puck"); found {
+		t.Error("expected a miss for another voice")
+	}
+}
+
+func TestChirpVoiceSampleHandler(t *testing.T) {
+	origVoices, origCache, origSynthesize := availableVoices, voiceSamples, synthesizeSample
+	t.Cleanup(func() { availableVoices, voiceSamples, synthesizeSample = origVoices, origCache, origSynthesize })
+
+	availableVoices = []*texttospeechpb.Voice{
+		{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCodes: []string{"fr-FR"}},
+		{Name: "ta-IN-Chirp3-HD-Kore", LanguageCodes: []string{"ta-IN"}},
+	}
+	cache := localSampleCache{dir: t.TempDir()}
+	voiceSamples = cache
+	var synthesized []string
+	synthesizeSample = func(ctx context.Context, voice *texttospeechpb.Voice, text string) ([]byte, error) {
+		synthesized = append(synthesized, text)
+		return []byte("RIFF" + voice.GetName()), nil
+	}
+	call := func(args map[string]interface{}) string {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		result, err := chirpVoiceSampleHandler(context.Background(), request)
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error: %v %v", err, result.Content)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	text := call(map[string]interface{}{"voice_name": "fr-FR-Chirp3-HD-Aoede"})
+	if !strings.Contains(text, "(generated)") || len(synthesized) != 1 || !strings.Contains(synthesized[0], "juge blond") {
+		t.Errorf("expected a French sample to be generated, got %q and %q", text, synthesized)
+	}
+	text = call(map[string]interface{}{"voice_name": "fr-FR-Chirp3-HD-Aoede"})
+	if !strings.Contains(text, "(cached)") || len(synthesized) != 1 {
+		t.Errorf("expected the cached sample, got %q after %d syntheses", text, len(synthesized))
+	}
+	text = call(map[string]interface{}{"voice_name": "fr-FR-Chirp3-HD-Aoede", "force_regenerate": true})
+	if !strings.Contains(text, "(generated)") || len(synthesized) != 2 {
+		t.Errorf("expected force_regenerate to bypass the cache, got %q after %d syntheses", text, len(synthesized))
+	}
+
+	text = call(map[string]interface{}{"voice_name": "ta-IN-Chirp3-HD-Kore"})
+	if !strings.Contains(synthesized[2], "quick brown fox") || !strings.Contains(text, "English sentence was used") {
+		t.Errorf("expected the English fallback for ta-IN, got %q and %q", text, synthesized[2])
+	}
+	if data, err := os.ReadFile(cache.path("ta-IN-Chirp3-HD-Kore")); err != nil || string(data) != "RIFFta-IN-Chirp3-HD-Kore" {
+		t.Errorf("expected the sample to be cached, got %q, %v", data, err)
+	}
+}
+
+func TestChirpVoiceSampleHandlerErrors(t *testing.T) {
+	origVoices, origCache, origSynthesize := availableVoices, voiceSamples, synthesizeSample
+	t.Cleanup(func() { availableVoices, voiceSamples, synthesizeSample = origVoices, origCache, origSynthesize })
+
+	availableVoices = []*texttospeechpb.Voice{{Name: "fr-FR-Chirp3-HD-Aoede", LanguageCodes: []string{"fr-FR"}}}
+	voiceSamples = localSampleCache{dir: t.TempDir()}
+	synthesizeSample = func(ctx context.Context, voice *texttospeechpb.Voice, text string) ([]byte, error) {
+		return nil, errors.New("quota exceeded")
+	}
+
+	for args, want := range map[string]string{
+		"":                       "'voice_name' parameter must be provided",
+		"en-US-Chirp3-HD-Nobody": "Unknown Chirp3-HD voice",
+		"fr-FR-Chirp3-HD-Aoede":  "quota exceeded",
+	} {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"voice_name": args}
+		result, _ := chirpVoiceSampleHandler(context.Background(), request)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, want) {
+			t.Errorf("voice_name %q: expected an error containing %q, got %v", args, want, result.Content)
+		}
+	}
+}
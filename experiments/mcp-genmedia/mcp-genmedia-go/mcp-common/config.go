@@ -3,6 +3,7 @@ package common
 import (
 	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -11,6 +12,22 @@ type Config struct {
 	Location       string
 	GenmediaBucket string
 	ApiEndpoint    string // New field
+	// GenmediaBucketGIF, GenmediaBucketAudio and GenmediaBucketVideo override GenmediaBucket
+	// as the default output bucket for tools producing that kind of media.
+	GenmediaBucketGIF   string
+	GenmediaBucketAudio string
+	GenmediaBucketVideo string
+	// ImpersonationAllowList holds the service accounts that tool calls may impersonate
+	// for GCS access, from the comma-separated IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS.
+	ImpersonationAllowList []string
+	// CallbackAllowedHosts holds the host patterns that completion callbacks may be sent to,
+	// from the comma-separated CALLBACK_ALLOWED_HOSTS. A pattern is a host name, optionally
+	// with a port, or *.domain for any subdomain of domain.
+	CallbackAllowedHosts []string
+	// FFmpegPath and FFprobePath are the FFmpeg and ffprobe executables, from FFMPEG_PATH and
+	// FFPROBE_PATH. They default to the bare names, which are looked up in PATH.
+	FFmpegPath  string
+	FFprobePath string
 }
 
 func LoadConfig() *Config {
@@ -24,12 +41,66 @@ func LoadConfig() *Config {
 		genmediaBucket = strings.TrimPrefix(genmediaBucket, "gs://")
 	}
 
-	return &Config{
-		ProjectID:      projectID,
-		Location:       GetEnv("LOCATION", "us-central1"),
-		GenmediaBucket: genmediaBucket,
-		ApiEndpoint:    os.Getenv("VERTEX_API_ENDPOINT"), // Use os.Getenv for optional value
+	apiEndpoint := strings.TrimSpace(os.Getenv("VERTEX_API_ENDPOINT"))
+	if apiEndpoint != "" {
+		allowCustom, _ := strconv.ParseBool(os.Getenv("ALLOW_CUSTOM_ENDPOINT"))
+		if err := ValidateAPIEndpoint(apiEndpoint, allowCustom); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		if allowCustom {
+			log.Printf("WARNING: ALLOW_CUSTOM_ENDPOINT is set, VERTEX_API_ENDPOINT is not restricted to googleapis.com")
+		}
 	}
+
+	cfg := &Config{
+		ProjectID:              projectID,
+		Location:               GetEnv("LOCATION", "us-central1"),
+		GenmediaBucket:         genmediaBucket,
+		GenmediaBucketGIF:      strings.TrimPrefix(os.Getenv("GENMEDIA_BUCKET_GIF"), "gs://"),
+		GenmediaBucketAudio:    strings.TrimPrefix(os.Getenv("GENMEDIA_BUCKET_AUDIO"), "gs://"),
+		GenmediaBucketVideo:    strings.TrimPrefix(os.Getenv("GENMEDIA_BUCKET_VIDEO"), "gs://"),
+		ApiEndpoint:            apiEndpoint,
+		ImpersonationAllowList: parseList(os.Getenv("IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS")),
+		CallbackAllowedHosts:   parseList(os.Getenv("CALLBACK_ALLOWED_HOSTS")),
+		FFmpegPath:             strings.TrimSpace(GetEnv("FFMPEG_PATH", "ffmpeg")),
+		FFprobePath:            strings.TrimSpace(GetEnv("FFPROBE_PATH", "ffprobe")),
+	}
+	effectiveAPIEndpoint = cfg.EffectiveAPIEndpoint()
+	log.Printf("Vertex AI endpoint: %s", effectiveAPIEndpoint)
+	return cfg
+}
+
+// OutputCategory is the kind of media a tool writes, used to pick its default output bucket.
+type OutputCategory string
+
+const (
+	// OutputCategoryGeneral has no override and always uses GENMEDIA_BUCKET.
+	OutputCategoryGeneral OutputCategory = ""
+	OutputCategoryGIF     OutputCategory = "gif"
+	OutputCategoryAudio   OutputCategory = "audio"
+	OutputCategoryVideo   OutputCategory = "video"
+)
+
+// DefaultBucketFor returns the output bucket to use when a request does not name one, and the
+// environment variable it came from. The per-category bucket (e.g. GENMEDIA_BUCKET_AUDIO) takes
+// precedence over GENMEDIA_BUCKET. It returns an empty bucket when neither is set.
+func (c *Config) DefaultBucketFor(category OutputCategory) (bucket, source string) {
+	var override, overrideSource string
+	switch category {
+	case OutputCategoryGIF:
+		override, overrideSource = c.GenmediaBucketGIF, "GENMEDIA_BUCKET_GIF"
+	case OutputCategoryAudio:
+		override, overrideSource = c.GenmediaBucketAudio, "GENMEDIA_BUCKET_AUDIO"
+	case OutputCategoryVideo:
+		override, overrideSource = c.GenmediaBucketVideo, "GENMEDIA_BUCKET_VIDEO"
+	}
+	if override = strings.TrimSpace(override); override != "" {
+		return override, overrideSource
+	}
+	if c.GenmediaBucket != "" {
+		return c.GenmediaBucket, "GENMEDIA_BUCKET"
+	}
+	return "", ""
 }
 
 // GetEnv retrieves an environment variable by its key.
@@ -46,3 +117,14 @@ func GetEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// parseList splits a comma-separated value into its trimmed, non-empty elements.
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -46,3 +46,69 @@ func TestLoadConfig(t *testing.T) {
 		
 	})
 }
+
+func TestLoadConfigPerCategoryBuckets(t *testing.T) {
+	t.Setenv("PROJECT_ID", "test-project")
+	t.Setenv("GENMEDIA_BUCKET", "gs://default-bucket")
+	t.Setenv("GENMEDIA_BUCKET_GIF", "gs://gif-bucket")
+	t.Setenv("GENMEDIA_BUCKET_AUDIO", "audio-bucket")
+	t.Setenv("GENMEDIA_BUCKET_VIDEO", "")
+
+	cfg := LoadConfig()
+
+	if cfg.GenmediaBucketGIF != "gif-bucket" {
+		t.Errorf("expected GenmediaBucketGIF to be 'gif-bucket', but got '%s'", cfg.GenmediaBucketGIF)
+	}
+	if cfg.GenmediaBucketAudio != "audio-bucket" {
+		t.Errorf("expected GenmediaBucketAudio to be 'audio-bucket', but got '%s'", cfg.GenmediaBucketAudio)
+	}
+	if cfg.GenmediaBucketVideo != "" {
+		t.Errorf("expected GenmediaBucketVideo to be '', but got '%s'", cfg.GenmediaBucketVideo)
+	}
+
+	testCases := []struct {
+		category       OutputCategory
+		expectedBucket string
+		expectedSource string
+	}{
+		{OutputCategoryGIF, "gif-bucket", "GENMEDIA_BUCKET_GIF"},
+		{OutputCategoryAudio, "audio-bucket", "GENMEDIA_BUCKET_AUDIO"},
+		{OutputCategoryVideo, "default-bucket", "GENMEDIA_BUCKET"},
+		{OutputCategoryGeneral, "default-bucket", "GENMEDIA_BUCKET"},
+	}
+	for _, tc := range testCases {
+		bucket, source := cfg.DefaultBucketFor(tc.category)
+		if bucket != tc.expectedBucket || source != tc.expectedSource {
+			t.Errorf("DefaultBucketFor(%q) = (%q, %q), want (%q, %q)", tc.category, bucket, source, tc.expectedBucket, tc.expectedSource)
+		}
+	}
+}
+
+func TestDefaultBucketForWithoutGlobalBucket(t *testing.T) {
+	cfg := &Config{GenmediaBucketVideo: "video-bucket"}
+
+	if bucket, source := cfg.DefaultBucketFor(OutputCategoryVideo); bucket != "video-bucket" || source != "GENMEDIA_BUCKET_VIDEO" {
+		t.Errorf("expected the video override without GENMEDIA_BUCKET, got (%q, %q)", bucket, source)
+	}
+	if bucket, source := cfg.DefaultBucketFor(OutputCategoryAudio); bucket != "" || source != "" {
+		t.Errorf("expected no default bucket for audio, got (%q, %q)", bucket, source)
+	}
+}
+
+func TestLoadConfigFFmpegPaths(t *testing.T) {
+	t.Setenv("PROJECT_ID", "test-project")
+	t.Setenv("FFMPEG_PATH", "")
+	t.Setenv("FFPROBE_PATH", "")
+
+	cfg := LoadConfig()
+	if cfg.FFmpegPath != "ffmpeg" || cfg.FFprobePath != "ffprobe" {
+		t.Errorf("expected the PATH lookup defaults, got (%q, %q)", cfg.FFmpegPath, cfg.FFprobePath)
+	}
+
+	t.Setenv("FFMPEG_PATH", " /opt/ffmpeg-static/ffmpeg ")
+	t.Setenv("FFPROBE_PATH", "/opt/ffmpeg-static/ffprobe")
+	cfg = LoadConfig()
+	if cfg.FFmpegPath != "/opt/ffmpeg-static/ffmpeg" || cfg.FFprobePath != "/opt/ffmpeg-static/ffprobe" {
+		t.Errorf("expected the configured binaries, got (%q, %q)", cfg.FFmpegPath, cfg.FFprobePath)
+	}
+}
@@ -0,0 +1,132 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultDataURIMaxBytes is the largest decoded data: URI payload PrepareInputFile accepts
+// unless DATA_URI_MAX_BYTES says otherwise. Data URIs are meant for small inputs, such as a
+// logo generated in memory; anything bigger should go through GCS.
+const DefaultDataURIMaxBytes = 2 << 20
+
+// dataURIExtensions maps the MIME types accepted in data: URIs to the extension of the temp
+// file they are decoded to, which FFmpeg uses to pick a demuxer.
+var dataURIExtensions = map[string]string{
+	"image/png":            "png",
+	"image/jpeg":           "jpg",
+	"image/gif":            "gif",
+	"image/webp":           "webp",
+	"image/bmp":            "bmp",
+	"image/svg+xml":        "svg",
+	"audio/wav":            "wav",
+	"audio/x-wav":          "wav",
+	"audio/mpeg":           "mp3",
+	"audio/ogg":            "ogg",
+	"text/plain":           "txt",
+	"application/x-subrip": "srt",
+	"text/vtt":             "vtt",
+}
+
+// IsDataURI reports whether fileURI is a data: URI.
+func IsDataURI(fileURI string) bool {
+	return len(fileURI) >= 5 && strings.EqualFold(fileURI[:5], "data:")
+}
+
+// RedactDataURI shortens a data: URI to its MIME type and payload size, e.g.
+// data:image/png;base64,<1234 bytes>, so logs and trace attributes do not carry the payload.
+// Other URIs are returned unchanged.
+func RedactDataURI(fileURI string) string {
+	if !IsDataURI(fileURI) {
+		return fileURI
+	}
+	header, payload, found := strings.Cut(fileURI[len("data:"):], ",")
+	if !found {
+		return "data:<malformed>"
+	}
+	size := len(payload)
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		size = base64.StdEncoding.DecodedLen(len(payload)) - strings.Count(payload[max(0, len(payload)-2):], "=")
+	}
+	return fmt.Sprintf("data:%s,<%d bytes>", header, size)
+}
+
+// dataURIMaxBytes returns the payload limit from DATA_URI_MAX_BYTES, or the default when it is
+// unset or not a positive number of bytes.
+func dataURIMaxBytes() int {
+	value := os.Getenv("DATA_URI_MAX_BYTES")
+	if value == "" {
+		return DefaultDataURIMaxBytes
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		log.Printf("Ignoring invalid DATA_URI_MAX_BYTES %q, using %d", value, DefaultDataURIMaxBytes)
+		return DefaultDataURIMaxBytes
+	}
+	return limit
+}
+
+// decodeDataURI parses a data:<mime>;base64,<payload> URI and returns its payload and the file
+// extension for its MIME type. Only base64 payloads of a known MIME type, at most maxBytes once
+// decoded, are accepted.
+func decodeDataURI(fileURI string, maxBytes int) (data []byte, ext string, err error) {
+	header, payload, found := strings.Cut(fileURI[len("data:"):], ",")
+	if !found {
+		return nil, "", errors.New("malformed data URI: missing ',' before the payload")
+	}
+	params := strings.Split(header, ";")
+	if len(params) < 2 || !strings.EqualFold(strings.TrimSpace(params[len(params)-1]), "base64") {
+		return nil, "", errors.New("data URI must be base64-encoded (data:<mime>;base64,<payload>)")
+	}
+	mimeType := strings.ToLower(strings.TrimSpace(params[0]))
+	ext, ok := dataURIExtensions[mimeType]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported data URI MIME type %q", mimeType)
+	}
+	// Check the encoded length first so an oversized payload is never decoded.
+	if base64.StdEncoding.DecodedLen(len(payload)) > maxBytes+2 {
+		return nil, "", fmt.Errorf("data URI payload is larger than the %s limit (DATA_URI_MAX_BYTES); upload the file to GCS instead", FormatBytes(int64(maxBytes)))
+	}
+	data, err = base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("data URI payload is not valid base64: %w", err)
+	}
+	if len(data) > maxBytes {
+		return nil, "", fmt.Errorf("data URI payload is larger than the %s limit (DATA_URI_MAX_BYTES); upload the file to GCS instead", FormatBytes(int64(maxBytes)))
+	}
+	if len(data) == 0 {
+		return nil, "", errors.New("data URI payload is empty")
+	}
+	return data, ext, nil
+}
+
+// prepareDataURIInput decodes a data: URI into a file in a new temp directory and returns the
+// file's path and a cleanup function removing the directory.
+func prepareDataURIInput(fileURI, purpose string) (localPath string, cleanupFunc func(), err error) {
+	cleanupFunc = func() {}
+	data, ext, err := decodeDataURI(fileURI, dataURIMaxBytes())
+	if err != nil {
+		return "", cleanupFunc, fmt.Errorf("invalid data URI for %s: %w", purpose, err)
+	}
+	tempDir, err := os.MkdirTemp("", "input_")
+	if err != nil {
+		return "", cleanupFunc, fmt.Errorf("failed to create temp dir for data URI: %w", err)
+	}
+	localPath = filepath.Join(tempDir, fmt.Sprintf("data_%s.%s", purpose, ext))
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		os.RemoveAll(tempDir)
+		return "", cleanupFunc, fmt.Errorf("failed to write data URI to %s: %w", localPath, err)
+	}
+	log.Printf("Decoded data URI (%s) to temporary path %s for %s", FormatBytes(int64(len(data))), localPath, purpose)
+	cleanupFunc = func() {
+		log.Printf("Cleaning up temporary directory for data URI: %s", tempDir)
+		os.RemoveAll(tempDir)
+	}
+	return localPath, cleanupFunc, nil
+}
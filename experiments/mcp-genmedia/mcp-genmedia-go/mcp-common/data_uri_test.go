@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A 1x1 transparent PNG and the first bytes of a JPEG.
+var (
+	tinyPNG  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+	tinyJPEG = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00\xff\xd9")
+)
+
+func TestPrepareInputFileDataURI(t *testing.T) {
+	testCases := []struct {
+		uri     string
+		data    []byte
+		wantExt string
+	}{
+		{"data:image/png;base64," + base64.StdEncoding.EncodeToString(tinyPNG), tinyPNG, ".png"},
+		{"data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(tinyJPEG), tinyJPEG, ".jpg"},
+		{"DATA:Image/JPEG;name=logo.jpg;BASE64," + base64.StdEncoding.EncodeToString(tinyJPEG), tinyJPEG, ".jpg"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.wantExt, func(t *testing.T) {
+			localPath, cleanup, err := PrepareInputFile(context.Background(), tc.uri, "overlay_image", "")
+			if err != nil {
+				t.Fatalf("PrepareInputFile: %v", err)
+			}
+			if filepath.Ext(localPath) != tc.wantExt {
+				t.Errorf("expected a %s file, got %s", tc.wantExt, localPath)
+			}
+			if data, err := os.ReadFile(localPath); err != nil || string(data) != string(tc.data) {
+				t.Errorf("expected the decoded payload, got %q, %v", data, err)
+			}
+			cleanup()
+			if _, err := os.Stat(filepath.Dir(localPath)); !os.IsNotExist(err) {
+				t.Errorf("expected cleanup to remove %s", filepath.Dir(localPath))
+			}
+		})
+	}
+}
+
+func TestPrepareInputFileDataURIErrors(t *testing.T) {
+	t.Setenv("DATA_URI_MAX_BYTES", "16")
+	testCases := map[string]string{
+		"data:image/png;base64,not*base64!":                                              "not valid base64",
+		"data:image/png," + base64.StdEncoding.EncodeToString(tinyPNG[:8]):               "must be base64-encoded",
+		"data:image/png;base64":                                                          "missing ','",
+		"data:application/zip;base64," + base64.StdEncoding.EncodeToString([]byte("PK")): "unsupported data URI MIME type \"application/zip\"",
+		"data:image/png;base64,":                                                         "payload is empty",
+		"data:image/png;base64," + base64.StdEncoding.EncodeToString(tinyPNG):            "larger than the 16 B limit",
+		"data:image/png;base64," + base64.StdEncoding.EncodeToString(make([]byte, 17)):   "larger than the 16 B limit",
+	}
+	for uri, want := range testCases {
+		localPath, cleanup, err := PrepareInputFile(context.Background(), uri, "overlay_image", "")
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("PrepareInputFile(%.40s): expected an error containing %q, got %q, %v", uri, want, localPath, err)
+		}
+	}
+
+	// The limit is inclusive.
+	_, cleanup, err := PrepareInputFile(context.Background(), "data:image/png;base64,"+base64.StdEncoding.EncodeToString(tinyPNG[:16]), "overlay_image", "")
+	defer cleanup()
+	if err != nil {
+		t.Errorf("expected a payload of exactly the limit to be accepted, got %v", err)
+	}
+}
+
+func TestDataURIMaxBytes(t *testing.T) {
+	for value, want := range map[string]int{"": DefaultDataURIMaxBytes, "1024": 1024, "-1": DefaultDataURIMaxBytes, "2MB": DefaultDataURIMaxBytes} {
+		t.Setenv("DATA_URI_MAX_BYTES", value)
+		if got := dataURIMaxBytes(); got != want {
+			t.Errorf("DATA_URI_MAX_BYTES=%q: got %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestRedactDataURI(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString([]byte("12345"))
+	for uri, want := range map[string]string{
+		"data:image/png;base64," + png: "data:image/png;base64,<5 bytes>",
+		"DATA:text/plain,hello":        "data:text/plain,<5 bytes>",
+		"data:image/png;base64":        "data:<malformed>",
+		"gs://bucket/logo.png":         "gs://bucket/logo.png",
+		"/tmp/logo.png":                "/tmp/logo.png",
+	} {
+		if got := RedactDataURI(uri); got != want {
+			t.Errorf("RedactDataURI(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// apiEndpointFormat is shown when VERTEX_API_ENDPOINT is rejected.
+const apiEndpointFormat = "https://<location>-aiplatform.googleapis.com or https://aiplatform.googleapis.com"
+
+// effectiveAPIEndpoint is the Vertex AI endpoint resolved by LoadConfig. InitTracerProvider
+// records it on the OTel resource so traces show which endpoint a server was talking to.
+var effectiveAPIEndpoint string
+
+// ValidateAPIEndpoint checks a VERTEX_API_ENDPOINT override. It must be an https URL on a
+// googleapis.com host, so a typo fails at startup instead of as auth errors on every call.
+// allowCustom (ALLOW_CUSTOM_ENDPOINT=1) accepts any http or https URL, for emulators and proxies.
+func ValidateAPIEndpoint(endpoint string, allowCustom bool) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("VERTEX_API_ENDPOINT %q is not a URL; expected %s", endpoint, apiEndpointFormat)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("VERTEX_API_ENDPOINT %q must not have credentials, a query or a fragment; expected %s", endpoint, apiEndpointFormat)
+	}
+	if allowCustom {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("VERTEX_API_ENDPOINT %q must be an http or https URL", endpoint)
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("VERTEX_API_ENDPOINT %q must use https; expected %s (set ALLOW_CUSTOM_ENDPOINT=1 for an emulator or proxy)", endpoint, apiEndpointFormat)
+	}
+	host := strings.ToLower(u.Hostname())
+	if !strings.HasSuffix(host, ".googleapis.com") || !validHostLabels(strings.TrimSuffix(host, ".googleapis.com")) {
+		return fmt.Errorf("VERTEX_API_ENDPOINT %q is not a googleapis.com endpoint; expected %s (set ALLOW_CUSTOM_ENDPOINT=1 for an emulator or proxy)", endpoint, apiEndpointFormat)
+	}
+	return nil
+}
+
+// validHostLabels reports whether the dot-separated labels of a host name are all non-empty and
+// made of letters, digits and inner hyphens.
+func validHostLabels(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// EffectiveAPIEndpoint returns the Vertex AI endpoint the GenAI clients use: the
+// VERTEX_API_ENDPOINT override if there is one, otherwise the SDK's default for Location.
+func (c *Config) EffectiveAPIEndpoint() string {
+	if c.ApiEndpoint != "" {
+		return c.ApiEndpoint
+	}
+	if c.Location == "" || c.Location == "global" {
+		return "https://aiplatform.googleapis.com/"
+	}
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com/", c.Location)
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAPIEndpoint(t *testing.T) {
+	testCases := []struct {
+		name        string
+		endpoint    string
+		allowCustom bool
+		wantErr     string
+	}{
+		{name: "regional endpoint", endpoint: "https://us-central1-aiplatform.googleapis.com"},
+		{name: "regional endpoint with path", endpoint: "https://europe-west4-aiplatform.googleapis.com/"},
+		{name: "global endpoint", endpoint: "https://aiplatform.googleapis.com"},
+		{name: "sandbox endpoint", endpoint: "https://us-central1-autopush-aiplatform.sandbox.googleapis.com"},
+		{name: "typo in domain", endpoint: "https://us-central1-aiplatform.googleapi.com", wantErr: "not a googleapis.com endpoint"},
+		{name: "domain used as label", endpoint: "https://googleapis.com.example.com", wantErr: "not a googleapis.com endpoint"},
+		{name: "bare domain", endpoint: "https://googleapis.com", wantErr: "not a googleapis.com endpoint"},
+		{name: "empty label", endpoint: "https://us-central1..googleapis.com", wantErr: "not a googleapis.com endpoint"},
+		{name: "missing scheme", endpoint: "us-central1-aiplatform.googleapis.com", wantErr: "is not a URL"},
+		{name: "http rejected", endpoint: "http://us-central1-aiplatform.googleapis.com", wantErr: "must use https"},
+		{name: "query rejected", endpoint: "https://aiplatform.googleapis.com/?key=abc", wantErr: "must not have credentials"},
+		{name: "escape hatch allows emulator", endpoint: "http://localhost:8085", allowCustom: true},
+		{name: "escape hatch allows proxy", endpoint: "https://vertex-proxy.internal.example.com", allowCustom: true},
+		{name: "escape hatch still needs a URL", endpoint: "localhost:8085", allowCustom: true, wantErr: "is not a URL"},
+		{name: "escape hatch still needs http", endpoint: "grpc://localhost:8085", allowCustom: true, wantErr: "http or https URL"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateAPIEndpoint(tc.endpoint, tc.allowCustom)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateAPIEndpoint(%q) returned unexpected error: %v", tc.endpoint, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ValidateAPIEndpoint(%q) = %v, want an error containing %q", tc.endpoint, err, tc.wantErr)
+			}
+			if !tc.allowCustom && !strings.Contains(err.Error(), "aiplatform.googleapis.com") {
+				t.Errorf("expected the error to show the expected format, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEffectiveAPIEndpoint(t *testing.T) {
+	testCases := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Location: "us-central1"}, "https://us-central1-aiplatform.googleapis.com/"},
+		{Config{Location: "global"}, "https://aiplatform.googleapis.com/"},
+		{Config{Location: "us-central1", ApiEndpoint: "https://europe-west4-aiplatform.googleapis.com"}, "https://europe-west4-aiplatform.googleapis.com"},
+	}
+	for _, tc := range testCases {
+		if got := tc.cfg.EffectiveAPIEndpoint(); got != tc.want {
+			t.Errorf("EffectiveAPIEndpoint() for %+v = %s, want %s", tc.cfg, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfigAPIEndpoint(t *testing.T) {
+	t.Setenv("PROJECT_ID", "test-project")
+	t.Setenv("LOCATION", "asia-northeast1")
+	t.Setenv("VERTEX_API_ENDPOINT", " https://us-east5-aiplatform.googleapis.com ")
+
+	cfg := LoadConfig()
+
+	if cfg.ApiEndpoint != "https://us-east5-aiplatform.googleapis.com" {
+		t.Errorf("expected the trimmed override, got %q", cfg.ApiEndpoint)
+	}
+	if effectiveAPIEndpoint != cfg.ApiEndpoint {
+		t.Errorf("expected the override to be recorded for the OTel resource, got %q", effectiveAPIEndpoint)
+	}
+}
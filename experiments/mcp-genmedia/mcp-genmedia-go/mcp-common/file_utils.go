@@ -8,18 +8,27 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/teris-io/shortid"
 )
 
 // PrepareInputFile handles the logic for making a file available locally for processing.
 // It checks if the given file URI is a GCS path (gs://...) or a local path.
-// If it's a GCS path, it downloads the file to a temporary local directory.
+// If it's a GCS path, it downloads the file to a temporary local directory. When INPUT_CACHE_DIR
+// is set, GCS files are kept in a local cache of up to INPUT_CACHE_MAX_MB (1024 by default), and
+// an unchanged object is linked from the cache instead of being downloaded again.
+// If it's a data:<mime>;base64,<payload> URI, it decodes the payload to a temporary file; payloads
+// are limited to DATA_URI_MAX_BYTES (2 MB by default).
 // If it's a local path, it verifies that the file exists.
 // It returns the local path to the file and a cleanup function to remove any temporary files.
 func PrepareInputFile(ctx context.Context, fileURI, purpose string, gcpProjectID string) (localPath string, cleanupFunc func(), err error) {
 	cleanupFunc = func() {}
 
+	if IsDataURI(fileURI) {
+		return prepareDataURIInput(fileURI, purpose)
+	}
+
 	if strings.HasPrefix(fileURI, "gs://") {
 		if gcpProjectID == "" {
 			return "", cleanupFunc, errors.New("PROJECT_ID not set, cannot download from GCS")
@@ -36,9 +45,14 @@ func PrepareInputFile(ctx context.Context, fileURI, purpose string, gcpProjectID
 		}
 		localPath = filepath.Join(tempDir, base)
 
-		log.Printf("Downloading GCS file %s to temporary path %s for %s", fileURI, localPath, purpose)
-
-		gcsErr := DownloadFromGCS(ctx, fileURI, localPath)
+		var gcsErr error
+		if cache := defaultInputCache(); cache != nil {
+			log.Printf("Fetching GCS file %s through the input cache to temporary path %s for %s", fileURI, localPath, purpose)
+			gcsErr = cache.fetch(ctx, fileURI, localPath)
+		} else {
+			log.Printf("Downloading GCS file %s to temporary path %s for %s", fileURI, localPath, purpose)
+			gcsErr = DownloadFromGCS(ctx, fileURI, localPath)
+		}
 		if gcsErr != nil {
 			os.RemoveAll(tempDir)
 			return "", cleanupFunc, fmt.Errorf("failed to download %s from GCS: %w", fileURI, gcsErr)
@@ -99,11 +113,41 @@ func HandleOutputPreparation(desiredOutputFilename, defaultExt string) (tempLoca
 // It can move the file to a specified local directory and/or upload it to a GCS bucket.
 // It returns the final local path and the GCS path of the file.
 func ProcessOutputAfterFFmpeg(ctx context.Context, ffmpegOutputActualPath, finalOutputFilename, outputLocalDir, outputGCSBucket string, gcpProjectID string) (finalLocalPath string, finalGCSPath string, err error) {
+	var buckets []string
+	if outputGCSBucket != "" {
+		buckets = []string{outputGCSBucket}
+	}
+	finalLocalPath, uploads, err := ProcessOutputAfterFFmpegToBuckets(ctx, ffmpegOutputActualPath, finalOutputFilename, outputLocalDir, buckets, gcpProjectID)
+	if err != nil {
+		return finalLocalPath, "", err
+	}
+	if len(uploads) > 0 {
+		finalGCSPath = uploads[0].GCSPath
+	}
+	return finalLocalPath, finalGCSPath, nil
+}
+
+// GCSUploadResult records the outcome of uploading an output file to one bucket.
+type GCSUploadResult struct {
+	Bucket  string
+	GCSPath string
+	Err     error
+}
+
+// gcsUploader performs the actual upload. It is a variable so tests can substitute a fake.
+var gcsUploader = UploadToGCS
+
+// ProcessOutputAfterFFmpegToBuckets is like ProcessOutputAfterFFmpeg but uploads the output
+// to every bucket in outputGCSBuckets concurrently, returning one result per bucket.
+// With a single bucket an upload failure is returned as an error, as in ProcessOutputAfterFFmpeg.
+// With several buckets, failures are reported per bucket in the results and an error is
+// only returned if every upload failed.
+func ProcessOutputAfterFFmpegToBuckets(ctx context.Context, ffmpegOutputActualPath, finalOutputFilename, outputLocalDir string, outputGCSBuckets []string, gcpProjectID string) (finalLocalPath string, uploads []GCSUploadResult, err error) {
 	currentLocalPath := ffmpegOutputActualPath
 
 	if outputLocalDir != "" {
 		if errMkdir := os.MkdirAll(outputLocalDir, 0755); errMkdir != nil {
-			return "", "", fmt.Errorf("failed to create specified output local directory %s: %w", outputLocalDir, errMkdir)
+			return "", nil, fmt.Errorf("failed to create specified output local directory %s: %w", outputLocalDir, errMkdir)
 		}
 		destLocalPath := filepath.Join(outputLocalDir, finalOutputFilename)
 		log.Printf("Moving FFMpeg output from %s to %s", currentLocalPath, destLocalPath)
@@ -112,10 +156,10 @@ func ProcessOutputAfterFFmpeg(ctx context.Context, ffmpegOutputActualPath, final
 			log.Printf("Rename failed (%v), attempting copy and remove for %s to %s", errRename, currentLocalPath, destLocalPath)
 			inputBytes, readErr := os.ReadFile(currentLocalPath)
 			if readErr != nil {
-				return "", "", fmt.Errorf("failed to read source for copy %s: %w", currentLocalPath, readErr)
+				return "", nil, fmt.Errorf("failed to read source for copy %s: %w", currentLocalPath, readErr)
 			}
 			if writeErr := os.WriteFile(destLocalPath, inputBytes, 0644); writeErr != nil {
-				return "", "", fmt.Errorf("failed to write destination for copy %s: %w", destLocalPath, writeErr)
+				return "", nil, fmt.Errorf("failed to write destination for copy %s: %w", destLocalPath, writeErr)
 			}
 			if removeErr := os.Remove(currentLocalPath); removeErr != nil {
 				log.Printf("Warning: failed to remove original file %s after copy: %v", currentLocalPath, removeErr)
@@ -130,31 +174,146 @@ func ProcessOutputAfterFFmpeg(ctx context.Context, ffmpegOutputActualPath, final
 		log.Printf("Output generated at temporary location: %s (will be cleaned up if not moved or uploaded)", finalLocalPath)
 	}
 
-	if outputGCSBucket != "" {
-		if gcpProjectID == "" {
-			return finalLocalPath, "", errors.New("PROJECT_ID not set, cannot upload to GCS")
-		}
-		if _, errStat := os.Stat(currentLocalPath); os.IsNotExist(errStat) {
-			return finalLocalPath, "", fmt.Errorf("ffmpeg output file %s not found for GCS upload", currentLocalPath)
+	if len(outputGCSBuckets) == 0 {
+		return finalLocalPath, nil, nil
+	}
+	if gcpProjectID == "" {
+		return finalLocalPath, nil, errors.New("PROJECT_ID not set, cannot upload to GCS")
+	}
+	if _, errStat := os.Stat(currentLocalPath); os.IsNotExist(errStat) {
+		return finalLocalPath, nil, fmt.Errorf("ffmpeg output file %s not found for GCS upload", currentLocalPath)
+	}
+
+	uploads, err = UploadFileToGCSBuckets(ctx, currentLocalPath, finalOutputFilename, outputGCSBuckets)
+	if err != nil {
+		return finalLocalPath, nil, err
+	}
+	failed := 0
+	for _, upload := range uploads {
+		if upload.Err != nil {
+			failed++
 		}
+	}
+	if len(uploads) == 1 && failed == 1 {
+		return finalLocalPath, nil, uploads[0].Err
+	}
+	if failed == len(uploads) {
+		return finalLocalPath, uploads, fmt.Errorf("failed to upload to all %d GCS buckets", failed)
+	}
+	return finalLocalPath, uploads, nil
+}
+
+// UploadFileToGCSBuckets uploads a local file to the same object name in each of the given
+// buckets concurrently. The results are returned in the same order as the buckets; a
+// failure for one bucket does not stop the others. An error is returned only if the local
+// file cannot be read.
+func UploadFileToGCSBuckets(ctx context.Context, localPath, objectName string, buckets []string) ([]GCSUploadResult, error) {
+	fileData, readErr := os.ReadFile(localPath)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read file %s for GCS upload: %w", localPath, readErr)
+	}
+
+	results := make([]GCSUploadResult, len(buckets))
+	var wg sync.WaitGroup
+	for i, bucket := range buckets {
+		wg.Add(1)
+		go func(i int, bucket string) {
+			defer wg.Done()
+			bucket = strings.TrimPrefix(strings.TrimSpace(bucket), "gs://")
+			results[i].Bucket = bucket
+			log.Printf("Uploading %s to GCS bucket %s as object %s", localPath, bucket, objectName)
+			contentType := "" // uploadToGCS will infer it
+			if errUpload := gcsUploader(ctx, bucket, objectName, contentType, fileData); errUpload != nil {
+				results[i].Err = fmt.Errorf("failed to upload to GCS (gs://%s/%s): %w", bucket, objectName, errUpload)
+				log.Print(results[i].Err)
+				return
+			}
+			results[i].GCSPath = fmt.Sprintf("gs://%s/%s", bucket, objectName)
+			log.Printf("Output uploaded to GCS: %s", results[i].GCSPath)
+		}(i, bucket)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// OutputDirFile is one file of a multi-file output, such as a segment of an HLS package.
+type OutputDirFile struct {
+	// RelPath is the path relative to the output directory, with forward slashes.
+	RelPath string
+	Size    int64
+}
 
-		log.Printf("Uploading %s to GCS bucket %s as object %s", currentLocalPath, outputGCSBucket, finalOutputFilename)
+// ProcessOutputDirToBuckets is the multi-file counterpart of ProcessOutputAfterFFmpegToBuckets, for
+// tools whose FFmpeg output is a directory tree (e.g. a playlist with its segments). Every file
+// under outputDir is copied to outputLocalDir and uploaded to each bucket under gcsPrefix, keeping
+// its relative path. Buckets are uploaded to concurrently, each as ProcessOutputDirAfterFFmpeg
+// uploads a directory; each result's GCSPath is the gs:// prefix the files were written under,
+// and its Err the bucket's upload error. Failures are reported as in
+// ProcessOutputAfterFFmpegToBuckets. The files are returned sorted by path.
+func ProcessOutputDirToBuckets(ctx context.Context, outputDir, outputLocalDir string, outputGCSBuckets []string, gcsPrefix, gcpProjectID string) (files []OutputDirFile, uploads []GCSUploadResult, err error) {
+	files, err = listOutputDir(outputDir)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		fileData, readErr := os.ReadFile(currentLocalPath)
-		if readErr != nil {
-			return finalLocalPath, "", fmt.Errorf("failed to read file %s for GCS upload: %w", currentLocalPath, readErr)
+	if outputLocalDir != "" {
+		for _, f := range files {
+			dest := filepath.Join(outputLocalDir, filepath.FromSlash(f.RelPath))
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return files, nil, fmt.Errorf("failed to create output directory %s: %w", filepath.Dir(dest), err)
+			}
+			data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(f.RelPath)))
+			if err != nil {
+				return files, nil, fmt.Errorf("failed to read output file %s: %w", f.RelPath, err)
+			}
+			if err := os.WriteFile(dest, data, 0644); err != nil {
+				return files, nil, fmt.Errorf("failed to write output file %s: %w", dest, err)
+			}
 		}
+		log.Printf("Copied %d output files to local directory: %s", len(files), outputLocalDir)
+	}
+
+	if len(outputGCSBuckets) == 0 {
+		return files, nil, nil
+	}
+	if gcpProjectID == "" {
+		return files, nil, errors.New("PROJECT_ID not set, cannot upload to GCS")
+	}
 
-		contentType := "" // uploadToGCS will infer it
+	uploads = make([]GCSUploadResult, len(outputGCSBuckets))
+	var wg sync.WaitGroup
+	for i, bucket := range outputGCSBuckets {
+		wg.Add(1)
+		go func(i int, bucket string) {
+			defer wg.Done()
+			bucket = strings.TrimPrefix(strings.TrimSpace(bucket), "gs://")
+			uploads[i].Bucket = bucket
+			if _, err := uploadOutputDir(ctx, outputDir, files, bucket, gcsPrefix, OutputDirOptions{}); err != nil {
+				uploads[i].Err = err
+				return
+			}
+			if prefix := strings.Trim(gcsPrefix, "/"); prefix != "" {
+				uploads[i].GCSPath = fmt.Sprintf("gs://%s/%s/", bucket, prefix)
+			} else {
+				uploads[i].GCSPath = fmt.Sprintf("gs://%s/", bucket)
+			}
+		}(i, bucket)
+	}
+	wg.Wait()
 
-		errUpload := UploadToGCS(ctx, outputGCSBucket, finalOutputFilename, contentType, fileData)
-		if errUpload != nil {
-			return finalLocalPath, "", fmt.Errorf("failed to upload to GCS (gs://%s/%s): %w", outputGCSBucket, finalOutputFilename, errUpload)
+	failed := 0
+	for _, upload := range uploads {
+		if upload.Err != nil {
+			failed++
 		}
-		finalGCSPath = fmt.Sprintf("gs://%s/%s", outputGCSBucket, finalOutputFilename)
-		log.Printf("Output uploaded to GCS: %s", finalGCSPath)
 	}
-	return finalLocalPath, finalGCSPath, nil
+	if len(uploads) == 1 && failed == 1 {
+		return files, nil, uploads[0].Err
+	}
+	if failed == len(uploads) {
+		return files, uploads, fmt.Errorf("failed to upload to all %d GCS buckets", failed)
+	}
+	return files, uploads, nil
 }
 
 // GetTail returns the last n lines of a string.
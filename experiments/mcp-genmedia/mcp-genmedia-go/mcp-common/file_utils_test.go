@@ -1,7 +1,12 @@
 package common
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -49,3 +54,121 @@ func TestGetTail(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessOutputAfterFFmpegToBuckets(t *testing.T) {
+	originalUploader := gcsUploader
+	defer func() { gcsUploader = originalUploader }()
+
+	var mu sync.Mutex
+	uploaded := make(map[string][]byte)
+	gcsUploader = func(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
+		if bucketName == "bad-bucket" {
+			return errors.New("permission denied")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		uploaded[bucketName+"/"+objectName] = data
+		return nil
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out.mp4")
+	if err := os.WriteFile(outputPath, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("partial failure", func(t *testing.T) {
+		_, uploads, err := ProcessOutputAfterFFmpegToBuckets(context.Background(), outputPath, "out.mp4", "", []string{"gs://good-bucket", "bad-bucket"}, "test-project")
+		if err != nil {
+			t.Fatalf("expected no error for a partial failure, but got: %v", err)
+		}
+		if len(uploads) != 2 {
+			t.Fatalf("expected 2 upload results, but got %d", len(uploads))
+		}
+		if uploads[0].Bucket != "good-bucket" || uploads[0].GCSPath != "gs://good-bucket/out.mp4" || uploads[0].Err != nil {
+			t.Errorf("unexpected result for good bucket: %+v", uploads[0])
+		}
+		if uploads[1].Bucket != "bad-bucket" || uploads[1].GCSPath != "" || uploads[1].Err == nil {
+			t.Errorf("unexpected result for bad bucket: %+v", uploads[1])
+		}
+		if string(uploaded["good-bucket/out.mp4"]) != "video" {
+			t.Errorf("expected the file contents to be uploaded to good-bucket")
+		}
+	})
+
+	t.Run("single bucket failure is an error", func(t *testing.T) {
+		_, _, err := ProcessOutputAfterFFmpeg(context.Background(), outputPath, "out.mp4", "", "bad-bucket", "test-project")
+		if err == nil {
+			t.Errorf("expected an error, but got none")
+		}
+	})
+
+	t.Run("all buckets failing is an error", func(t *testing.T) {
+		_, uploads, err := ProcessOutputAfterFFmpegToBuckets(context.Background(), outputPath, "out.mp4", "", []string{"bad-bucket", "bad-bucket"}, "test-project")
+		if err == nil {
+			t.Errorf("expected an error, but got none")
+		}
+		if len(uploads) != 2 {
+			t.Errorf("expected per-bucket results alongside the error, but got %d", len(uploads))
+		}
+	})
+}
+
+func TestProcessOutputDirToBuckets(t *testing.T) {
+	originalUploader, originalDelay := gcsUploader, outputDirRetryDelay
+	defer func() { gcsUploader, outputDirRetryDelay = originalUploader, originalDelay }()
+	outputDirRetryDelay = 0
+
+	var mu sync.Mutex
+	uploaded := make(map[string]string)
+	flaky := make(map[string]bool)
+	gcsUploader = func(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
+		if bucketName == "bad-bucket" {
+			return errors.New("permission denied")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// each object fails once, and is retried on its own
+		if !flaky[bucketName+"/"+objectName] {
+			flaky[bucketName+"/"+objectName] = true
+			return errors.New("503 backend error")
+		}
+		uploaded[bucketName+"/"+objectName] = string(data)
+		return nil
+	}
+
+	outputDir := t.TempDir()
+	for name, content := range map[string]string{"master.m3u8": "#EXTM3U", "stream_720p/playlist.m3u8": "#EXTM3U", "stream_720p/segment_000.ts": "ts-data"} {
+		path := filepath.Join(outputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	localDir := filepath.Join(t.TempDir(), "package")
+	files, uploads, err := ProcessOutputDirToBuckets(context.Background(), outputDir, localDir, []string{"gs://good-bucket", "bad-bucket"}, "/hls/run1/", "test-project")
+	if err != nil {
+		t.Fatalf("expected no error for a partial failure, but got: %v", err)
+	}
+	if len(files) != 3 || files[0].RelPath != "master.m3u8" || files[2].RelPath != "stream_720p/segment_000.ts" || files[2].Size != 7 {
+		t.Errorf("unexpected files: %+v", files)
+	}
+	if data, err := os.ReadFile(filepath.Join(localDir, "stream_720p", "segment_000.ts")); err != nil || string(data) != "ts-data" {
+		t.Errorf("expected the tree to be copied to the local directory, got %q, %v", data, err)
+	}
+	if uploads[0].GCSPath != "gs://good-bucket/hls/run1/" || uploads[0].Err != nil {
+		t.Errorf("unexpected result for good bucket: %+v", uploads[0])
+	}
+	if uploads[1].Err == nil || uploads[1].GCSPath != "" {
+		t.Errorf("unexpected result for bad bucket: %+v", uploads[1])
+	}
+	if len(uploaded) != 3 || uploaded["good-bucket/hls/run1/stream_720p/playlist.m3u8"] != "#EXTM3U" {
+		t.Errorf("unexpected uploads: %v", uploaded)
+	}
+
+	if _, _, err := ProcessOutputDirToBuckets(context.Background(), outputDir, "", []string{"bad-bucket"}, "hls", "test-project"); err == nil {
+		t.Error("expected a single failing bucket to be an error")
+	}
+}
@@ -18,12 +18,18 @@ import (
 // It parses the GCS URI, creates a GCS client, and then reads the object's contents,
 // writing them to a new local file. It also creates the destination directory if it doesn't exist.
 func DownloadFromGCS(ctx context.Context, gcsURI, localDestPath string) error {
+	return downloadGCSGeneration(ctx, gcsURI, -1, localDestPath)
+}
+
+// downloadGCSGeneration is DownloadFromGCS for one generation of the object, or the live
+// version when generation is negative.
+func downloadGCSGeneration(ctx context.Context, gcsURI string, generation int64, localDestPath string) error {
 	bucketName, objectName, err := ParseGCSPath(gcsURI)
 	if err != nil {
 		return err
 	}
 
-	client, err := storage.NewClient(ctx)
+	client, err := newStorageClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %w", err)
 	}
@@ -31,7 +37,11 @@ func DownloadFromGCS(ctx context.Context, gcsURI, localDestPath string) error {
 
 	gcsOpCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
-	rc, err := client.Bucket(bucketName).Object(objectName).NewReader(gcsOpCtx)
+	object := client.Bucket(bucketName).Object(objectName)
+	if generation >= 0 {
+		object = object.Generation(generation)
+	}
+	rc, err := object.NewReader(gcsOpCtx)
 	if err != nil {
 		return fmt.Errorf("Object(%q).NewReader: %w", objectName, err)
 	}
@@ -61,7 +71,7 @@ func DownloadFromGCSAsBytes(ctx context.Context, gcsURI string) ([]byte, error)
 		return nil, err
 	}
 
-	client, err := storage.NewClient(ctx)
+	client, err := newStorageClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("storage.NewClient: %w", err)
 	}
@@ -97,12 +107,155 @@ func DownloadFromGCSAsBytes(ctx context.Context, gcsURI string) ([]byte, error)
 	return data, nil
 }
 
+// OpenGCSObject opens a GCS object for reading, so large files such as generated videos can be
+// streamed to disk instead of being held in memory. Closing the reader also closes its client.
+func OpenGCSObject(ctx context.Context, gcsURI string) (io.ReadCloser, error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	rc, err := client.Bucket(bucketName).Object(objectName).NewReader(ctx)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Object(%q).NewReader: %w", objectName, err)
+	}
+	return &gcsObjectReader{Reader: rc, client: client}, nil
+}
+
+// gcsObjectReader is the reader returned by OpenGCSObject.
+type gcsObjectReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+func (r *gcsObjectReader) Close() error {
+	err := r.Reader.Close()
+	if closeErr := r.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GCSObjectReaderAt reads a GCS object at arbitrary offsets, issuing one ranged read per ReadAt
+// call, so parsers that only need a few headers of a large file do not download all of it.
+// io.ReaderAt takes no context, so the one passed to OpenGCSObjectAt is used for every read.
+type GCSObjectReaderAt struct {
+	ctx    context.Context
+	client *storage.Client
+	object *storage.ObjectHandle
+	size   int64
+}
+
+// OpenGCSObjectAt opens a GCS object for random access reads. Closing the reader closes its client.
+func OpenGCSObjectAt(ctx context.Context, gcsURI string) (*GCSObjectReaderAt, error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	object := client.Bucket(bucketName).Object(objectName)
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Object(%q).Attrs: %w", objectName, err)
+	}
+	return &GCSObjectReaderAt{ctx: ctx, client: client, object: object, size: attrs.Size}, nil
+}
+
+// Size returns the size of the object in bytes.
+func (r *GCSObjectReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes starting at off with a single ranged read.
+func (r *GCSObjectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if remaining := r.size - off; int64(want) > remaining {
+		want = int(remaining)
+	}
+	rc, err := r.object.NewRangeReader(r.ctx, off, int64(want))
+	if err != nil {
+		return 0, fmt.Errorf("Object(%q).NewRangeReader: %w", r.object.ObjectName(), err)
+	}
+	defer rc.Close()
+
+	n, err := io.ReadFull(rc, p[:want])
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes the reader's storage client.
+func (r *GCSObjectReaderAt) Close() error {
+	return r.client.Close()
+}
+
+// CreateGCSObject opens a GCS object for writing, the counterpart of OpenGCSObject for outputs that
+// are produced in pieces and need not be held in memory. The content type is inferred from the
+// object name when it is empty. The object is only created once Close returns nil; cancel ctx
+// before closing to abandon it. Closing the writer also closes its client.
+func CreateGCSObject(ctx context.Context, gcsURI, contentType string) (io.WriteCloser, error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	wc := client.Bucket(bucketName).Object(objectName).NewWriter(ctx)
+	if contentType == "" {
+		contentType = inferContentType(objectName)
+	}
+	wc.ContentType = contentType
+	return &gcsObjectWriter{Writer: wc, client: client}, nil
+}
+
+// gcsObjectWriter is the writer returned by CreateGCSObject.
+type gcsObjectWriter struct {
+	*storage.Writer
+	client *storage.Client
+}
+
+func (w *gcsObjectWriter) Close() error {
+	err := w.Writer.Close()
+	if closeErr := w.client.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Writer.Close: %w", err)
+	}
+	return nil
+}
+
 // UploadToGCS uploads data to a specified GCS bucket and object.
 // It takes the data as a byte slice and infers the content type from the object name's extension
 // if it's not explicitly provided. This is useful for ensuring that GCS objects have the correct
 // metadata, which is important for serving them correctly.
+// Objects smaller than the writer's chunk size (16 MiB by default) are sent in a single
+// multipart request; only larger ones pay for the extra round-trips of a resumable upload.
 func UploadToGCS(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
-	client, err := storage.NewClient(ctx)
+	client, err := newStorageClient(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.NewClient: %w", err)
 	}
@@ -113,28 +266,9 @@ func UploadToGCS(ctx context.Context, bucketName, objectName, contentType string
 
 	finalContentType := contentType
 	if finalContentType == "" {
-		ext := strings.ToLower(filepath.Ext(objectName))
-		switch ext {
-		case ".mp3":
-			finalContentType = "audio/mpeg"
-		case ".wav":
-			finalContentType = "audio/wav"
-		case ".mp4":
-			finalContentType = "video/mp4"
-		case ".mov":
-			finalContentType = "video/quicktime"
-		case ".mkv":
-			finalContentType = "video/x-matroska"
-		case ".webm":
-			finalContentType = "video/webm"
-		case ".png":
-			finalContentType = "image/png"
-		case ".jpg", ".jpeg":
-			finalContentType = "image/jpeg"
-		case ".gif":
-			finalContentType = "image/gif"
-		default:
-			log.Printf("uploadToGCS: Could not infer ContentType for extension '%s' of object '%s'. Uploading without explicit ContentType.", ext, objectName)
+		finalContentType = inferContentType(objectName)
+		if finalContentType == "" {
+			log.Printf("uploadToGCS: Could not infer ContentType for extension '%s' of object '%s'. Uploading without explicit ContentType.", filepath.Ext(objectName), objectName)
 		}
 	}
 
@@ -153,6 +287,119 @@ func UploadToGCS(ctx context.Context, bucketName, objectName, contentType string
 	return nil
 }
 
+// inferContentType returns the content type of a media object from the extension of its name,
+// or "" for an extension it does not know.
+func inferContentType(objectName string) string {
+	switch strings.ToLower(filepath.Ext(objectName)) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	case ".mp4":
+		return "video/mp4"
+	case ".mov":
+		return "video/quicktime"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".webm":
+		return "video/webm"
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".gif":
+		return "image/gif"
+	case ".pdf":
+		return "application/pdf"
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	}
+	return ""
+}
+
+// GetGCSObjectSize returns the size in bytes of a GCS object without downloading it.
+// It only reads the object's metadata, so it is cheap to call on large media files.
+func GetGCSObjectSize(ctx context.Context, gcsURI string) (int64, error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucketName).Object(objectName).Attrs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("Object(%q).Attrs: %w", objectName, err)
+	}
+	return attrs.Size, nil
+}
+
+// GCSObjectExists reports whether a GCS object exists, reading only its metadata.
+func GCSObjectExists(ctx context.Context, gcsURI string) (bool, error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return false, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	_, err = client.Bucket(bucketName).Object(objectName).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Object(%q).Attrs: %w", objectName, err)
+	}
+	return true, nil
+}
+
+// ReadGCSObjectIfChanged reads a GCS object unless its ETag still equals etag, so callers can
+// cache a small object, such as a configuration file, and revalidate it with a metadata request.
+// It returns the object's current ETag; modified is false, and data nil, when the object is
+// unchanged. The data is read from the generation the ETag belongs to, so the two always match.
+func ReadGCSObjectIfChanged(ctx context.Context, gcsURI, etag string) (data []byte, currentETag string, modified bool, err error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	obj := client.Bucket(bucketName).Object(objectName)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("Object(%q).Attrs: %w", objectName, err)
+	}
+	if etag != "" && attrs.Etag == etag {
+		return nil, attrs.Etag, false, nil
+	}
+
+	rc, err := obj.Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("Object(%q).NewReader: %w", objectName, err)
+	}
+	defer rc.Close()
+	data, err = io.ReadAll(rc)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("io.ReadAll: %w", err)
+	}
+	return data, attrs.Etag, true, nil
+}
+
 // ParseGCSPath extracts the bucket and object names from a GCS URI.
 // It validates that the URI has the correct format (gs://bucket/object)
 // and returns the two components. This is a helper function to make working
@@ -3,9 +3,13 @@ package common
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -92,3 +96,25 @@ func TestDownloadFromGCS(t *testing.T) {
 		t.Errorf("expected downloaded content to be '%s', but got '%s'", string(content), string(downloadedContent))
 	}
 }
+
+func TestUploadToGCSSmallObjectSingleRequest(t *testing.T) {
+	var mu sync.Mutex
+	var uploadTypes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		uploadTypes = append(uploadTypes, r.URL.Query().Get("uploadType"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"bucket": "test-bucket", "name": "clip.wav"}`)
+	}))
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+
+	if err := UploadToGCS(context.Background(), "test-bucket", "clip.wav", "", make([]byte, 64*1024)); err != nil {
+		t.Fatalf("UploadToGCS: %v", err)
+	}
+	if len(uploadTypes) != 1 || uploadTypes[0] != "multipart" {
+		t.Errorf("expected one multipart request, got upload types %q", uploadTypes)
+	}
+}
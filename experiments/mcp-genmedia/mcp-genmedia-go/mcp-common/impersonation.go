@@ -0,0 +1,258 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// ImpersonateServiceAccountParam is the tool argument that asks for a call's GCS reads and
+// writes to be performed as the given service account instead of the server's own identity.
+const ImpersonateServiceAccountParam = "impersonate_service_account"
+
+const (
+	// impersonatedTokenLifetime is how long minted tokens are requested to live for.
+	impersonatedTokenLifetime = time.Hour
+	// impersonatedTokenRefreshSkew is how close to expiry a cached token may get before a new
+	// one is minted, so a token is never handed to a GCS operation that may outlive it.
+	impersonatedTokenRefreshSkew = 5 * time.Minute
+	// impersonatedTokenMintTimeout bounds a single mint. A mint is shared by every caller
+	// waiting on the service account, so it does not stop when the caller that started it
+	// goes away.
+	impersonatedTokenMintTimeout = 30 * time.Second
+)
+
+// gcsImpersonationScopes are the OAuth scopes requested for impersonated GCS tokens.
+var gcsImpersonationScopes = []string{"https://www.googleapis.com/auth/devstorage.read_write"}
+
+// ErrImpersonationNotAllowed is returned when a request names a service account that is not
+// on the configured allow-list.
+var ErrImpersonationNotAllowed = errors.New("service account is not allowed for impersonation")
+
+// IAMTokenGenerator mints short-lived access tokens for a service account. The production
+// implementation calls the IAM Credentials API; tests substitute a fake.
+type IAMTokenGenerator interface {
+	GenerateAccessToken(ctx context.Context, serviceAccount string, scopes []string, lifetime time.Duration) (*oauth2.Token, error)
+}
+
+// iamCredentialsTokenGenerator calls projects.serviceAccounts.generateAccessToken using the
+// server's ambient credentials, which need roles/iam.serviceAccountTokenCreator on each
+// allow-listed service account.
+type iamCredentialsTokenGenerator struct{}
+
+// NewIAMCredentialsTokenGenerator returns an IAMTokenGenerator backed by the IAM Credentials API.
+func NewIAMCredentialsTokenGenerator() IAMTokenGenerator {
+	return iamCredentialsTokenGenerator{}
+}
+
+func (iamCredentialsTokenGenerator) GenerateAccessToken(ctx context.Context, serviceAccount string, scopes []string, lifetime time.Duration) (*oauth2.Token, error) {
+	svc, err := iamcredentials.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("iamcredentials.NewService: %w", err)
+	}
+	name := "projects/-/serviceAccounts/" + serviceAccount
+	resp, err := svc.Projects.ServiceAccounts.GenerateAccessToken(name, &iamcredentials.GenerateAccessTokenRequest{
+		Scope:    scopes,
+		Lifetime: fmt.Sprintf("%ds", int(lifetime.Seconds())),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("generateAccessToken for %s: %w", serviceAccount, err)
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("parsing token expiry %q: %w", resp.ExpireTime, err)
+	}
+	return &oauth2.Token{AccessToken: resp.AccessToken, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+// Impersonator mints and caches impersonated tokens for allow-listed service accounts.
+// A token is reused until it is within impersonatedTokenRefreshSkew of expiring.
+// An Impersonator with an empty allow-list rejects every impersonation request.
+type Impersonator struct {
+	allowed   map[string]bool
+	generator IAMTokenGenerator
+	now       func() time.Time
+
+	// mu guards tokens and minting only; it is never held while calling the generator, so a
+	// slow mint for one service account does not block requests for another.
+	mu      sync.Mutex
+	tokens  map[string]*oauth2.Token
+	minting map[string]*tokenMint
+}
+
+// tokenMint is an in-flight token request that concurrent callers for the same service
+// account wait on instead of minting their own.
+type tokenMint struct {
+	done chan struct{}
+	tok  *oauth2.Token
+	err  error
+}
+
+// NewImpersonator creates an Impersonator that only accepts the given service accounts.
+func NewImpersonator(allowList []string, generator IAMTokenGenerator) *Impersonator {
+	allowed := make(map[string]bool, len(allowList))
+	for _, sa := range allowList {
+		if sa = normalizeServiceAccount(sa); sa != "" {
+			allowed[sa] = true
+		}
+	}
+	return &Impersonator{
+		allowed:   allowed,
+		generator: generator,
+		now:       time.Now,
+		tokens:    make(map[string]*oauth2.Token),
+		minting:   make(map[string]*tokenMint),
+	}
+}
+
+// NewImpersonatorFromConfig creates an Impersonator using the config's allow-list and the
+// IAM Credentials API.
+func NewImpersonatorFromConfig(cfg *Config) *Impersonator {
+	return NewImpersonator(cfg.ImpersonationAllowList, NewIAMCredentialsTokenGenerator())
+}
+
+// Enabled reports whether any service account may be impersonated.
+func (i *Impersonator) Enabled() bool {
+	return i != nil && len(i.allowed) > 0
+}
+
+// Allowed reports whether the service account is on the allow-list.
+func (i *Impersonator) Allowed(serviceAccount string) bool {
+	return i != nil && i.allowed[normalizeServiceAccount(serviceAccount)]
+}
+
+// Token returns a valid access token for the service account, minting a new one when there is
+// no cached token or the cached one is about to expire. Concurrent callers for the same
+// service account share a single mint, and receive its error if it fails. ctx only bounds how
+// long this caller waits; the mint itself runs until impersonatedTokenMintTimeout.
+func (i *Impersonator) Token(ctx context.Context, serviceAccount string) (*oauth2.Token, error) {
+	serviceAccount = normalizeServiceAccount(serviceAccount)
+	if !i.Allowed(serviceAccount) {
+		return nil, fmt.Errorf("%w: %s", ErrImpersonationNotAllowed, serviceAccount)
+	}
+
+	i.mu.Lock()
+	if tok, ok := i.tokens[serviceAccount]; ok && tok.Expiry.After(i.now().Add(impersonatedTokenRefreshSkew)) {
+		i.mu.Unlock()
+		return tok, nil
+	}
+	mint, ok := i.minting[serviceAccount]
+	if !ok {
+		mint = &tokenMint{done: make(chan struct{})}
+		i.minting[serviceAccount] = mint
+		go i.mint(context.WithoutCancel(ctx), serviceAccount, mint)
+	}
+	i.mu.Unlock()
+
+	select {
+	case <-mint.done:
+		return mint.tok, mint.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// mint requests a token for the service account, stores it in the cache and wakes the
+// callers waiting on m.
+func (i *Impersonator) mint(ctx context.Context, serviceAccount string, m *tokenMint) {
+	ctx, cancel := context.WithTimeout(ctx, impersonatedTokenMintTimeout)
+	defer cancel()
+	m.tok, m.err = i.generator.GenerateAccessToken(ctx, serviceAccount, gcsImpersonationScopes, impersonatedTokenLifetime)
+
+	i.mu.Lock()
+	delete(i.minting, serviceAccount)
+	if m.err != nil {
+		delete(i.tokens, serviceAccount)
+	} else {
+		i.tokens[serviceAccount] = m.tok
+	}
+	i.mu.Unlock()
+	close(m.done)
+
+	if m.err == nil {
+		log.Printf("Minted impersonated token for %s, expires %s", serviceAccount, m.tok.Expiry.Format(time.RFC3339))
+	}
+}
+
+// ContextFromArguments returns a context carrying impersonated GCS credentials when the tool
+// arguments contain a non-empty 'impersonate_service_account'. Without the argument the
+// context is returned unchanged and GCS calls use the server's ambient credentials.
+func (i *Impersonator) ContextFromArguments(ctx context.Context, args map[string]interface{}) (context.Context, error) {
+	serviceAccount, _ := args[ImpersonateServiceAccountParam].(string)
+	serviceAccount = normalizeServiceAccount(serviceAccount)
+	if serviceAccount == "" {
+		return ctx, nil
+	}
+	if !i.Enabled() {
+		return ctx, fmt.Errorf("'%s' was provided but impersonation is not enabled on this server (set IMPERSONATION_ALLOWED_SERVICE_ACCOUNTS)", ImpersonateServiceAccountParam)
+	}
+	if !i.Allowed(serviceAccount) {
+		return ctx, fmt.Errorf("%w: %s", ErrImpersonationNotAllowed, serviceAccount)
+	}
+	return WithGCSCredentials(ctx, &GCSCredentials{
+		ServiceAccount: serviceAccount,
+		TokenSource:    &impersonatedTokenSource{impersonator: i, serviceAccount: serviceAccount},
+	}), nil
+}
+
+// impersonatedTokenSource adapts an Impersonator to oauth2.TokenSource for one request.
+// oauth2.TokenSource has no context, and the source may be used after the request that
+// created it is done, so it waits on a background context bounded by the mint timeout.
+type impersonatedTokenSource struct {
+	impersonator   *Impersonator
+	serviceAccount string
+}
+
+func (s *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), impersonatedTokenMintTimeout)
+	defer cancel()
+	return s.impersonator.Token(ctx, s.serviceAccount)
+}
+
+// GCSCredentials are request-scoped credentials for the GCS helpers in this package.
+type GCSCredentials struct {
+	ServiceAccount string
+	TokenSource    oauth2.TokenSource
+}
+
+type gcsCredentialsKey struct{}
+
+// WithGCSCredentials returns a context whose GCS operations (PrepareInputFile, the upload
+// helpers, downloads and metadata reads) use creds instead of ambient credentials.
+func WithGCSCredentials(ctx context.Context, creds *GCSCredentials) context.Context {
+	return context.WithValue(ctx, gcsCredentialsKey{}, creds)
+}
+
+// GCSCredentialsFromContext returns the request-scoped credentials, or nil if there are none.
+func GCSCredentialsFromContext(ctx context.Context) *GCSCredentials {
+	creds, _ := ctx.Value(gcsCredentialsKey{}).(*GCSCredentials)
+	return creds
+}
+
+// storageClientOptions returns the client options for the credentials carried by ctx.
+func storageClientOptions(ctx context.Context) []option.ClientOption {
+	creds := GCSCredentialsFromContext(ctx)
+	if creds == nil || creds.TokenSource == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithTokenSource(creds.TokenSource)}
+}
+
+// newStorageClient creates a storage client for a single call, using request-scoped
+// credentials from ctx when present and ambient credentials otherwise.
+func newStorageClient(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx, storageClientOptions(ctx)...)
+}
+
+func normalizeServiceAccount(serviceAccount string) string {
+	return strings.ToLower(strings.TrimSpace(serviceAccount))
+}
@@ -0,0 +1,250 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeIAMClient mints tokens that expire after its configured lifetime and counts the calls.
+type fakeIAMClient struct {
+	now      func() time.Time
+	lifetime time.Duration
+	err      error
+	calls    map[string]int
+}
+
+func (f *fakeIAMClient) GenerateAccessToken(ctx context.Context, serviceAccount string, scopes []string, lifetime time.Duration) (*oauth2.Token, error) {
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[serviceAccount]++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("%s-token-%d", serviceAccount, f.calls[serviceAccount]),
+		Expiry:      f.now().Add(f.lifetime),
+	}, nil
+}
+
+func TestImpersonatorAllowList(t *testing.T) {
+	fake := &fakeIAMClient{now: time.Now, lifetime: time.Hour}
+	imp := NewImpersonator([]string{" Tenant-A@proj.iam.gserviceaccount.com ", ""}, fake)
+
+	if !imp.Allowed("tenant-a@proj.iam.gserviceaccount.com") {
+		t.Error("expected allow-listed service account to be allowed regardless of case and whitespace")
+	}
+	if _, err := imp.Token(context.Background(), "tenant-b@proj.iam.gserviceaccount.com"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("expected ErrImpersonationNotAllowed for unlisted account, got %v", err)
+	}
+	if fake.calls["tenant-b@proj.iam.gserviceaccount.com"] != 0 {
+		t.Error("IAM must not be called for a service account that is not allow-listed")
+	}
+
+	t.Run("no argument keeps ambient credentials", func(t *testing.T) {
+		ctx, err := imp.ContextFromArguments(context.Background(), map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if GCSCredentialsFromContext(ctx) != nil || storageClientOptions(ctx) != nil {
+			t.Error("expected no request-scoped credentials without the argument")
+		}
+	})
+
+	t.Run("allowed argument attaches credentials", func(t *testing.T) {
+		ctx, err := imp.ContextFromArguments(context.Background(), map[string]interface{}{
+			ImpersonateServiceAccountParam: "tenant-a@proj.iam.gserviceaccount.com",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		creds := GCSCredentialsFromContext(ctx)
+		if creds == nil || creds.ServiceAccount != "tenant-a@proj.iam.gserviceaccount.com" {
+			t.Fatalf("expected credentials for tenant-a, got %+v", creds)
+		}
+		if len(storageClientOptions(ctx)) != 1 {
+			t.Error("expected a token source client option")
+		}
+		tok, err := creds.TokenSource.Token()
+		if err != nil || tok.AccessToken == "" {
+			t.Errorf("expected a minted token, got %v, %v", tok, err)
+		}
+	})
+
+	t.Run("unlisted argument is rejected", func(t *testing.T) {
+		_, err := imp.ContextFromArguments(context.Background(), map[string]interface{}{
+			ImpersonateServiceAccountParam: "intruder@other.iam.gserviceaccount.com",
+		})
+		if !errors.Is(err, ErrImpersonationNotAllowed) {
+			t.Errorf("expected ErrImpersonationNotAllowed, got %v", err)
+		}
+	})
+
+	t.Run("disabled impersonator rejects any argument", func(t *testing.T) {
+		disabled := NewImpersonator(nil, fake)
+		_, err := disabled.ContextFromArguments(context.Background(), map[string]interface{}{
+			ImpersonateServiceAccountParam: "tenant-a@proj.iam.gserviceaccount.com",
+		})
+		if err == nil {
+			t.Error("expected an error when impersonation is not enabled")
+		}
+	})
+}
+
+func TestImpersonatorTokenCacheExpiry(t *testing.T) {
+	const sa = "tenant-a@proj.iam.gserviceaccount.com"
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	fake := &fakeIAMClient{now: now, lifetime: time.Hour}
+	imp := NewImpersonator([]string{sa}, fake)
+	imp.now = now
+	ctx := context.Background()
+
+	first, err := imp.Token(ctx, sa)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clock = clock.Add(30 * time.Minute)
+	second, err := imp.Token(ctx, sa)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.AccessToken != first.AccessToken || fake.calls[sa] != 1 {
+		t.Errorf("expected the cached token to be reused, got %d IAM calls", fake.calls[sa])
+	}
+
+	// Inside the refresh skew the token is still valid but must not be handed out.
+	clock = clock.Add(26 * time.Minute)
+	third, err := imp.Token(ctx, sa)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third.AccessToken == first.AccessToken || fake.calls[sa] != 2 {
+		t.Errorf("expected a new token near expiry, got %q after %d IAM calls", third.AccessToken, fake.calls[sa])
+	}
+
+	// A failed refresh drops the stale token rather than returning it.
+	clock = clock.Add(2 * time.Hour)
+	fake.err = errors.New("permission denied")
+	if _, err := imp.Token(ctx, sa); err == nil {
+		t.Error("expected the IAM error to be returned")
+	}
+	if _, cached := imp.tokens[sa]; cached {
+		t.Error("expected the expired token to be evicted after a failed refresh")
+	}
+}
+
+// blockingIAMClient holds mints for the blocked service account until release is closed.
+type blockingIAMClient struct {
+	blocked string
+	started chan struct{}
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *blockingIAMClient) GenerateAccessToken(ctx context.Context, serviceAccount string, scopes []string, lifetime time.Duration) (*oauth2.Token, error) {
+	f.mu.Lock()
+	f.calls[serviceAccount]++
+	f.mu.Unlock()
+	if serviceAccount == f.blocked {
+		close(f.started)
+		<-f.release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return &oauth2.Token{AccessToken: serviceAccount + "-token", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestImpersonatorTokenMintsOutsideLock(t *testing.T) {
+	const slow, fast = "slow@proj.iam.gserviceaccount.com", "fast@proj.iam.gserviceaccount.com"
+	fake := &blockingIAMClient{blocked: slow, started: make(chan struct{}), release: make(chan struct{}), calls: map[string]int{}}
+	imp := NewImpersonator([]string{slow, fast}, fake)
+
+	const waiters = 3
+	var wg sync.WaitGroup
+	errs := make(chan error, waiters)
+	for n := 0; n < waiters; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := imp.Token(context.Background(), slow)
+			if err == nil && tok.AccessToken != slow+"-token" {
+				err = fmt.Errorf("unexpected token %q", tok.AccessToken)
+			}
+			errs <- err
+		}()
+	}
+	<-fake.started
+
+	fastDone := make(chan error, 1)
+	go func() {
+		_, err := imp.Token(context.Background(), fast)
+		fastDone <- err
+	}()
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", fast, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a slow mint for %s blocked the token request for %s", slow, fast)
+	}
+
+	close(fake.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", slow, err)
+		}
+	}
+	if fake.calls[slow] != 1 {
+		t.Errorf("expected concurrent requests for %s to share one mint, got %d", slow, fake.calls[slow])
+	}
+}
+
+func TestImpersonatorSharedMintOutlivesFirstCaller(t *testing.T) {
+	const sa = "slow@proj.iam.gserviceaccount.com"
+	fake := &blockingIAMClient{blocked: sa, started: make(chan struct{}), release: make(chan struct{}), calls: map[string]int{}}
+	imp := NewImpersonator([]string{sa}, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := imp.Token(ctx, sa)
+		firstDone <- err
+	}()
+	<-fake.started
+
+	secondDone := make(chan error, 1)
+	go func() {
+		tok, err := imp.Token(context.Background(), sa)
+		if err == nil && tok.AccessToken != sa+"-token" {
+			err = fmt.Errorf("unexpected token %q", tok.AccessToken)
+		}
+		secondDone <- err
+	}()
+
+	// the caller that started the mint gives up, but the mint carries on for the other
+	cancel()
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled caller to get context.Canceled, got %v", err)
+	}
+	close(fake.release)
+	if err := <-secondDone; err != nil {
+		t.Errorf("unexpected error for the waiting caller: %v", err)
+	}
+	if fake.calls[sa] != 1 {
+		t.Errorf("expected one shared mint, got %d", fake.calls[sa])
+	}
+}
@@ -0,0 +1,344 @@
+package common
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultInputCacheMaxMB is the size of the GCS input cache unless INPUT_CACHE_MAX_MB says otherwise.
+const DefaultInputCacheMaxMB = 1024
+
+// gcsObjectVersion identifies the content of a GCS object: a new upload to the same name gets
+// a new generation, and CRC32C and Size catch a cached copy that no longer matches it.
+type gcsObjectVersion struct {
+	Generation int64
+	CRC32C     uint32
+	Size       int64
+}
+
+// cacheEntry is a downloaded object in the cache directory.
+type cacheEntry struct {
+	key     string // bucket/object/generation
+	object  string // bucket/object
+	path    string
+	version gcsObjectVersion
+	elem    *list.Element
+}
+
+// cacheFill is a download in progress; requests for the same object wait for it instead of
+// downloading it again.
+type cacheFill struct {
+	done chan struct{}
+	err  error
+}
+
+// inputCache keeps GCS inputs that tools read again and again, such as background music and
+// logos, on local disk. Entries are keyed by bucket, object and generation, and the object's
+// attributes are read on every request before an entry is reused: this finds a newer
+// generation, and it checks that the caller can still read the object, so a cached copy is
+// never handed to a caller whose credentials could not download it. The least recently used
+// entries are evicted once the cache is larger than maxBytes.
+type inputCache struct {
+	dir      string
+	maxBytes int64
+
+	// stat and download reach GCS. They are fields so tests can substitute fakes.
+	stat     func(ctx context.Context, gcsURI string) (gcsObjectVersion, error)
+	download func(ctx context.Context, gcsURI string, generation int64, localDestPath string) error
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry
+	lru      *list.List // front is the most recently used
+	size     int64
+	inflight map[string]*cacheFill
+}
+
+// newInputCache returns a cache of at most maxBytes in a directory of its own under root, named
+// after the process ID, so servers sharing INPUT_CACHE_DIR never remove each other's files. The
+// cache keeps no index across restarts: the directories left by processes that are no longer
+// running, including an earlier process with this ID, are removed.
+func newInputCache(root string, maxBytes int64) (*inputCache, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create input cache directory %s: %w", root, err)
+	}
+	removeStaleInputCacheDirs(root)
+	dir := filepath.Join(root, strconv.Itoa(os.Getpid()))
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear input cache directory %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create input cache directory %s: %w", dir, err)
+	}
+	return &inputCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		stat:     statGCSObject,
+		download: downloadGCSGeneration,
+		entries:  make(map[string]*cacheEntry),
+		lru:      list.New(),
+		inflight: make(map[string]*cacheFill),
+	}, nil
+}
+
+// removeStaleInputCacheDirs removes the cache directories under root of processes that are no
+// longer running. Entries not named after a process ID are left alone.
+func removeStaleInputCacheDirs(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		log.Printf("Could not list input cache directory %s: %v", root, err)
+		return
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || pid == os.Getpid() || processRunning(pid) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			log.Printf("Could not remove stale input cache directory %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// processRunning reports whether the process with the given ID still exists. Signal 0 checks
+// for it without signalling it; where that check is not supported the process is assumed to be
+// running, so its files are kept. It is a variable so tests can substitute a fake.
+var processRunning = func(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
+
+var (
+	sharedInputCacheOnce sync.Once
+	sharedInputCache     *inputCache
+)
+
+// defaultInputCache returns the cache configured by INPUT_CACHE_DIR and INPUT_CACHE_MAX_MB, or
+// nil when INPUT_CACHE_DIR is unset or the cache cannot be created.
+func defaultInputCache() *inputCache {
+	sharedInputCacheOnce.Do(func() {
+		dir := strings.TrimSpace(os.Getenv("INPUT_CACHE_DIR"))
+		if dir == "" {
+			return
+		}
+		maxMB := inputCacheMaxMB()
+		cache, err := newInputCache(filepath.Join(dir, "gcs-inputs"), maxMB<<20)
+		if err != nil {
+			log.Printf("Input cache disabled: %v", err)
+			return
+		}
+		log.Printf("Caching GCS inputs in %s (up to %d MB)", cache.dir, maxMB)
+		sharedInputCache = cache
+	})
+	return sharedInputCache
+}
+
+// inputCacheMaxMB returns the cache size from INPUT_CACHE_MAX_MB, or the default when it is
+// unset or not a positive number of megabytes.
+func inputCacheMaxMB() int64 {
+	value := os.Getenv("INPUT_CACHE_MAX_MB")
+	if value == "" {
+		return DefaultInputCacheMaxMB
+	}
+	maxMB, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxMB <= 0 {
+		log.Printf("Ignoring invalid INPUT_CACHE_MAX_MB %q, using %d", value, DefaultInputCacheMaxMB)
+		return DefaultInputCacheMaxMB
+	}
+	return maxMB
+}
+
+// statGCSObject reads the generation, checksum and size of a GCS object.
+func statGCSObject(ctx context.Context, gcsURI string) (gcsObjectVersion, error) {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return gcsObjectVersion{}, err
+	}
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		return gcsObjectVersion{}, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucketName).Object(objectName).Attrs(ctx)
+	if err != nil {
+		return gcsObjectVersion{}, fmt.Errorf("Object(%q).Attrs: %w", objectName, err)
+	}
+	return gcsObjectVersion{Generation: attrs.Generation, CRC32C: attrs.CRC32C, Size: attrs.Size}, nil
+}
+
+// fetch makes the current generation of gcsURI available at localDestPath, from the cache when
+// it holds that generation and downloading it into the cache otherwise. Concurrent requests for
+// the same generation share one download. A cache hit is recorded as an event on the span in
+// ctx. Objects larger than the whole cache are downloaded straight to localDestPath.
+func (c *inputCache) fetch(ctx context.Context, gcsURI, localDestPath string) error {
+	bucketName, objectName, err := ParseGCSPath(gcsURI)
+	if err != nil {
+		return err
+	}
+	version, err := c.stat(ctx, gcsURI)
+	if err != nil {
+		return err
+	}
+	if version.Size > c.maxBytes {
+		log.Printf("GCS input %s (%s) is larger than the input cache, downloading it without caching", gcsURI, FormatBytes(version.Size))
+		return c.download(ctx, gcsURI, version.Generation, localDestPath)
+	}
+	object := bucketName + "/" + objectName
+	key := fmt.Sprintf("%s/%d", object, version.Generation)
+
+	for {
+		c.mu.Lock()
+		if entry, ok := c.entries[key]; ok {
+			if entry.version == version {
+				src, err := linkOrOpen(entry.path, localDestPath)
+				if os.IsNotExist(err) {
+					log.Printf("Input cache file for %s is gone, downloading it again", gcsURI)
+					c.removeLocked(entry)
+					c.mu.Unlock()
+					continue
+				}
+				c.lru.MoveToFront(entry.elem)
+				c.mu.Unlock()
+				if err == nil {
+					err = copyAndClose(src, localDestPath)
+				}
+				if err != nil {
+					return err
+				}
+				log.Printf("Input cache hit for %s (generation %d), skipped the download", gcsURI, version.Generation)
+				trace.SpanFromContext(ctx).AddEvent("input_cache_hit", trace.WithAttributes(
+					attribute.String("gcs.uri", gcsURI),
+					attribute.Int64("gcs.generation", version.Generation),
+					attribute.Int64("gcs.object_bytes", version.Size),
+				))
+				return nil
+			}
+			log.Printf("Input cache entry for %s does not match the object's checksum, downloading it again", gcsURI)
+			c.removeLocked(entry)
+		}
+		if fill, ok := c.inflight[key]; ok {
+			c.mu.Unlock()
+			select {
+			case <-fill.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if fill.err != nil && !(ctx.Err() == nil && (errors.Is(fill.err, context.Canceled) || errors.Is(fill.err, context.DeadlineExceeded))) {
+				return fill.err
+			}
+			// the entry is in the cache now, unless it has already been evicted, or the request
+			// that was downloading it gave up and this one can try again
+			continue
+		}
+		fill := &cacheFill{done: make(chan struct{})}
+		c.inflight[key] = fill
+		c.mu.Unlock()
+
+		fill.err = c.fill(ctx, gcsURI, object, key, version, localDestPath)
+		c.mu.Lock()
+		delete(c.inflight, key)
+		close(fill.done)
+		c.mu.Unlock()
+		return fill.err
+	}
+}
+
+// fill downloads one generation of an object into the cache, evicts entries to make room for
+// it, and links it to localDestPath.
+func (c *inputCache) fill(ctx context.Context, gcsURI, object, key string, version gcsObjectVersion, localDestPath string) error {
+	sum := sha256.Sum256([]byte(key))
+	entryPath := filepath.Join(c.dir, hex.EncodeToString(sum[:8])+"_"+filepath.Base(gcsURI))
+	tempPath := entryPath + ".download"
+	log.Printf("Input cache miss for %s (generation %d), downloading it", gcsURI, version.Generation)
+	if err := c.download(ctx, gcsURI, version.Generation, tempPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, entryPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to add %s to the input cache: %w", gcsURI, err)
+	}
+
+	c.mu.Lock()
+	// an object overwritten since it was cached is not requested by its old generation again
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if stale := elem.Value.(*cacheEntry); stale.object == object {
+			c.removeLocked(stale)
+		}
+		elem = next
+	}
+	entry := &cacheEntry{key: key, object: object, path: entryPath, version: version}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.size += version.Size
+	for c.size > c.maxBytes && c.lru.Len() > 1 {
+		oldest := c.lru.Back().Value.(*cacheEntry)
+		log.Printf("Evicting %s from the input cache", oldest.key)
+		c.removeLocked(oldest)
+	}
+	src, err := linkOrOpen(entryPath, localDestPath)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return copyAndClose(src, localDestPath)
+}
+
+// removeLocked drops an entry and deletes its file. c.mu must be held.
+func (c *inputCache) removeLocked(entry *cacheEntry) {
+	c.lru.Remove(entry.elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.version.Size
+	os.Remove(entry.path)
+}
+
+// linkOrOpen hard-links a cached file to dest, so that an eviction deleting src while the
+// caller still reads dest does not affect it. When src and dest are on different file systems it
+// opens src instead, for copyAndClose to copy once c.mu is released; the open file stays readable
+// even if src is evicted in the meantime. c.mu must be held.
+func linkOrOpen(src, dest string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll for directory %s: %w", filepath.Dir(dest), err)
+	}
+	if err := os.Link(src, dest); err == nil {
+		return nil, nil
+	}
+	return os.Open(src)
+}
+
+// copyAndClose copies the file opened by linkOrOpen to dest; it does nothing if linkOrOpen linked it.
+func copyAndClose(src *os.File, dest string) error {
+	if src == nil {
+		return nil
+	}
+	defer src.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("os.Create: %w", err)
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy cached input %s: %w", src.Name(), err)
+	}
+	return out.Close()
+}
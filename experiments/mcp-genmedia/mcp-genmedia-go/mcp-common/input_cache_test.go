@@ -0,0 +1,295 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeGCSObjects stands in for GCS behind an inputCache: objects have a generation and content,
+// and every download is counted. A non-nil gate holds downloads until it is closed.
+type fakeGCSObjects struct {
+	mu          sync.Mutex
+	generations map[string]int64
+	contents    map[string]string
+	downloads   map[string]int
+	gate        chan struct{}
+}
+
+func newFakeGCSCache(t *testing.T, maxBytes int64) (*inputCache, *fakeGCSObjects) {
+	t.Helper()
+	cache, err := newInputCache(filepath.Join(t.TempDir(), "cache"), maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeGCSObjects{generations: map[string]int64{}, contents: map[string]string{}, downloads: map[string]int{}}
+	cache.stat = func(ctx context.Context, gcsURI string) (gcsObjectVersion, error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		content, ok := fake.contents[gcsURI]
+		if !ok {
+			return gcsObjectVersion{}, fmt.Errorf("%s: object doesn't exist", gcsURI)
+		}
+		return gcsObjectVersion{Generation: fake.generations[gcsURI], CRC32C: uint32(len(content)), Size: int64(len(content))}, nil
+	}
+	cache.download = func(ctx context.Context, gcsURI string, generation int64, localDestPath string) error {
+		if fake.gate != nil {
+			<-fake.gate
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.downloads[gcsURI]++
+		if generation != fake.generations[gcsURI] {
+			return fmt.Errorf("%s: generation %d doesn't exist", gcsURI, generation)
+		}
+		return os.WriteFile(localDestPath, []byte(fake.contents[gcsURI]), 0644)
+	}
+	return cache, fake
+}
+
+func (f *fakeGCSObjects) put(gcsURI, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generations[gcsURI]++
+	f.contents[gcsURI] = content
+}
+
+func (f *fakeGCSObjects) downloadCount(gcsURI string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.downloads[gcsURI]
+}
+
+// fetchContent fetches gcsURI through the cache into a fresh directory and returns what was written.
+func fetchContent(t *testing.T, ctx context.Context, cache *inputCache, gcsURI string) string {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), filepath.Base(gcsURI))
+	if err := cache.fetch(ctx, gcsURI, dest); err != nil {
+		t.Fatalf("fetch(%s): %v", gcsURI, err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNewInputCacheKeepsOtherProcessesFiles(t *testing.T) {
+	original := processRunning
+	t.Cleanup(func() { processRunning = original })
+	processRunning = func(pid int) bool { return pid == 23456 }
+
+	root := t.TempDir()
+	own := filepath.Join(root, strconv.Itoa(os.Getpid()))
+	for _, file := range []string{"12345/stale.mp3", "23456/logo.png", filepath.Join(strconv.Itoa(os.Getpid()), "old.mp3"), "notes/readme.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err := newInputCache(root, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.dir != own {
+		t.Errorf("expected the cache in %s, got %s", own, cache.dir)
+	}
+	if files, err := os.ReadDir(own); err != nil || len(files) != 0 {
+		t.Errorf("expected an empty directory for this process, got %v (%v)", files, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "12345")); !os.IsNotExist(err) {
+		t.Errorf("expected the exited process's directory to be removed, got %v", err)
+	}
+	for _, file := range []string{"23456/logo.png", "notes/readme.txt"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(file))); err != nil {
+			t.Errorf("expected %s to be kept: %v", file, err)
+		}
+	}
+}
+
+func TestInputCacheHit(t *testing.T) {
+	cache, fake := newFakeGCSCache(t, 1<<20)
+	fake.put("gs://assets/music.mp3", "bed music")
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+	for i := 0; i < 3; i++ {
+		if got := fetchContent(t, ctx, cache, "gs://assets/music.mp3"); got != "bed music" {
+			t.Fatalf("fetch %d: got %q", i+1, got)
+		}
+	}
+	span.End()
+
+	if n := fake.downloadCount("gs://assets/music.mp3"); n != 1 {
+		t.Errorf("expected one download, got %d", n)
+	}
+	hits := 0
+	for _, event := range recorder.Ended()[0].Events() {
+		if event.Name == "input_cache_hit" {
+			hits++
+		}
+	}
+	if hits != 2 {
+		t.Errorf("expected 2 input_cache_hit span events, got %d", hits)
+	}
+}
+
+func TestInputCacheStaleGenerationMiss(t *testing.T) {
+	cache, fake := newFakeGCSCache(t, 1<<20)
+	fake.put("gs://assets/logo.png", "old logo")
+	if got := fetchContent(t, context.Background(), cache, "gs://assets/logo.png"); got != "old logo" {
+		t.Fatalf("got %q", got)
+	}
+
+	fake.put("gs://assets/logo.png", "new logo")
+	if got := fetchContent(t, context.Background(), cache, "gs://assets/logo.png"); got != "new logo" {
+		t.Errorf("expected the new generation, got %q", got)
+	}
+	if n := fake.downloadCount("gs://assets/logo.png"); n != 2 {
+		t.Errorf("expected the new generation to be downloaded, got %d downloads", n)
+	}
+	if len(cache.entries) != 1 || cache.size != int64(len("new logo")) {
+		t.Errorf("expected only the new generation to be cached, got %d entries of %d bytes", len(cache.entries), cache.size)
+	}
+	if files, _ := os.ReadDir(cache.dir); len(files) != 1 {
+		t.Errorf("expected the old generation's file to be removed, got %d files", len(files))
+	}
+}
+
+func TestInputCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, fake := newFakeGCSCache(t, 25)
+	for _, name := range []string{"a", "b", "c"} {
+		fake.put("gs://assets/"+name, fmt.Sprintf("%s-0123456789", name)) // 12 bytes each
+	}
+
+	fetchContent(t, context.Background(), cache, "gs://assets/a")
+	fetchContent(t, context.Background(), cache, "gs://assets/b")
+	fetchContent(t, context.Background(), cache, "gs://assets/a") // a is now more recently used than b
+	fetchContent(t, context.Background(), cache, "gs://assets/c") // 36 bytes > 25: b goes
+
+	if cache.size != 24 || len(cache.entries) != 2 {
+		t.Errorf("expected two entries of 24 bytes, got %d entries of %d bytes", len(cache.entries), cache.size)
+	}
+	fetchContent(t, context.Background(), cache, "gs://assets/a")
+	fetchContent(t, context.Background(), cache, "gs://assets/b")
+	if n := fake.downloadCount("gs://assets/a"); n != 1 {
+		t.Errorf("expected a to stay cached, got %d downloads", n)
+	}
+	if n := fake.downloadCount("gs://assets/b"); n != 2 {
+		t.Errorf("expected b to be evicted and downloaded again, got %d downloads", n)
+	}
+
+	// an object larger than the whole cache is passed through without evicting anything
+	fake.put("gs://assets/huge", "this object is larger than the cache")
+	if got := fetchContent(t, context.Background(), cache, "gs://assets/huge"); got != "this object is larger than the cache" {
+		t.Errorf("got %q", got)
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("expected the large object not to be cached, got %d entries", len(cache.entries))
+	}
+}
+
+func TestInputCacheConcurrentRequestsShareOneDownload(t *testing.T) {
+	cache, fake := newFakeGCSCache(t, 1<<20)
+	fake.put("gs://assets/music.mp3", "bed music")
+	fake.gate = make(chan struct{})
+
+	const requests = 8
+	var wg sync.WaitGroup
+	errs := make([]error, requests)
+	dests := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		dests[i] = filepath.Join(t.TempDir(), "music.mp3")
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cache.fetch(context.Background(), "gs://assets/music.mp3", dests[i])
+		}(i)
+	}
+	// let every request reach the cache before the download finishes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cache.mu.Lock()
+		started := len(cache.inflight) == 1
+		cache.mu.Unlock()
+		if started || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(fake.gate)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if data, _ := os.ReadFile(dests[i]); string(data) != "bed music" {
+			t.Errorf("request %d: got %q", i, data)
+		}
+	}
+	if n := fake.downloadCount("gs://assets/music.mp3"); n != 1 {
+		t.Errorf("expected one download for %d concurrent requests, got %d", requests, n)
+	}
+}
+
+func TestInputCacheWaiterRetriesAfterCancelledDownload(t *testing.T) {
+	cache, fake := newFakeGCSCache(t, 1<<20)
+	fake.put("gs://assets/music.mp3", "bed music")
+	fake.gate = make(chan struct{})
+	download := cache.download
+	cache.download = func(ctx context.Context, gcsURI string, generation int64, localDestPath string) error {
+		select {
+		case <-fake.gate:
+			return download(ctx, gcsURI, generation, localDestPath)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		leaderErr <- cache.fetch(leaderCtx, "gs://assets/music.mp3", filepath.Join(t.TempDir(), "music.mp3"))
+	}()
+	for {
+		cache.mu.Lock()
+		started := len(cache.inflight) == 1
+		cache.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waiterErr := make(chan error)
+	waiterDest := filepath.Join(t.TempDir(), "music.mp3")
+	go func() {
+		waiterErr <- cache.fetch(context.Background(), "gs://assets/music.mp3", waiterDest)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancelLeader()
+	if err := <-leaderErr; err == nil {
+		t.Errorf("expected the cancelled request to fail")
+	}
+	close(fake.gate)
+	if err := <-waiterErr; err != nil {
+		t.Fatalf("expected the waiting request to download the object itself, got %v", err)
+	}
+	if data, _ := os.ReadFile(waiterDest); string(data) != "bed music" {
+		t.Errorf("got %q", data)
+	}
+}
@@ -105,6 +105,8 @@ type VeoModelInfo struct {
 	DefaultDuration       int32
 	MaxVideos             int32
 	SupportedAspectRatios []string
+	SupportsAudio         bool
+	SupportsLastFrame     bool
 }
 
 // SupportedVeoModels is the single source of truth for all supported Veo models.
@@ -117,6 +119,7 @@ var SupportedVeoModels = map[string]VeoModelInfo{
 		DefaultDuration:       5,
 		MaxVideos:             4,
 		SupportedAspectRatios: []string{"16:9", "9:16"},
+		SupportsLastFrame:     true,
 	},
 	"veo-3.0-generate-preview": {
 		CanonicalName:         "veo-3.0-generate-preview",
@@ -126,6 +129,7 @@ var SupportedVeoModels = map[string]VeoModelInfo{
 		DefaultDuration:       8,
 		MaxVideos:             2,
 		SupportedAspectRatios: []string{"16:9"},
+		SupportsAudio:         true,
 	},
 	"veo-3.0-fast-generate-preview": {
 		CanonicalName:         "veo-3.0-fast-generate-preview",
@@ -135,6 +139,7 @@ var SupportedVeoModels = map[string]VeoModelInfo{
 		DefaultDuration:       8,
 		MaxVideos:             2,
 		SupportedAspectRatios: []string{"16:9"},
+		SupportsAudio:         true,
 	},
 }
 
@@ -167,8 +172,8 @@ func BuildVeoModelDescription() string {
 
 	for _, name := range sortedNames {
 		info := SupportedVeoModels[name]
-		sb.WriteString(fmt.Sprintf("- *%s* (Duration: %d-%ds, Max Videos: %d, Ratios: %s)",
-			info.CanonicalName, info.MinDuration, info.MaxDuration, info.MaxVideos, strings.Join(info.SupportedAspectRatios, ", ")))
+		sb.WriteString(fmt.Sprintf("- *%s* (Duration: %d-%ds, Max Videos: %d, Ratios: %s, Audio: %t)",
+			info.CanonicalName, info.MinDuration, info.MaxDuration, info.MaxVideos, strings.Join(info.SupportedAspectRatios, ", "), info.SupportsAudio))
 		if len(info.Aliases) > 0 {
 			sb.WriteString(fmt.Sprintf(" Aliases: *%s*", strings.Join(info.Aliases, "*, *")))
 		}
@@ -176,3 +181,29 @@ func BuildVeoModelDescription() string {
 	}
 	return sb.String()
 }
+
+// VeoModelsSupportingAudio returns the sorted canonical names of the Veo models
+// that can generate a synchronized audio track.
+func VeoModelsSupportingAudio() []string {
+	var names []string
+	for name, info := range SupportedVeoModels {
+		if info.SupportsAudio {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VeoModelsSupportingLastFrame returns the sorted canonical names of the Veo models
+// that accept a last frame to interpolate towards from the first image.
+func VeoModelsSupportingLastFrame() []string {
+	var names []string
+	for name, info := range SupportedVeoModels {
+		if info.SupportsLastFrame {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
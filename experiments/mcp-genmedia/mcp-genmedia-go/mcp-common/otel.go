@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -48,14 +49,19 @@ func InitTracerProvider(serviceName, serviceVersion string) (*sdktrace.TracerPro
 		log.Fatalf("failed to create OTLP trace exporter: %v", err)
 	}
 
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(serviceName),
+		semconv.ServiceVersionKey.String(serviceVersion),
+	}
+	// Set by LoadConfig; servers that don't load the common config have no Vertex AI endpoint.
+	if effectiveAPIEndpoint != "" {
+		attrs = append(attrs, attribute.String("gcp.vertex_ai.endpoint", effectiveAPIEndpoint))
+	}
+
 	// Create a new tracer provider.
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(serviceName),
-			semconv.ServiceVersionKey.String(serviceVersion),
-		)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
 	)
 
 	// Register the tracer provider as the global provider.
@@ -0,0 +1,237 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOutputDirConcurrency is the number of files ProcessOutputDirAfterFFmpeg uploads at once.
+	DefaultOutputDirConcurrency = 4
+	// DefaultOutputDirAttempts is how many times ProcessOutputDirAfterFFmpeg tries to upload a file.
+	DefaultOutputDirAttempts = 3
+	// OutputDirManifestName is the name of the manifest written under the prefix.
+	OutputDirManifestName = "index.json"
+)
+
+// outputDirRetryDelay is the wait before the second attempt at a file; it doubles for each
+// attempt after that. It is a variable so tests can retry without waiting.
+var outputDirRetryDelay = 500 * time.Millisecond
+
+// OutputDirOptions configures ProcessOutputDirAfterFFmpeg.
+type OutputDirOptions struct {
+	// Concurrency is the number of files uploaded at once. 0 means DefaultOutputDirConcurrency.
+	Concurrency int
+	// Attempts is how many times each file is tried before it is reported as failed.
+	// 0 means DefaultOutputDirAttempts.
+	Attempts int
+	// WriteManifest uploads an index.json under the prefix that lists every object with its
+	// size and content type, once all files are uploaded.
+	WriteManifest bool
+}
+
+// OutputDirFailure is a file that could not be uploaded.
+type OutputDirFailure struct {
+	// RelPath is the path relative to the directory, with forward slashes.
+	RelPath  string
+	Attempts int
+	Err      error
+}
+
+// OutputDirResult is the outcome of ProcessOutputDirAfterFFmpeg.
+type OutputDirResult struct {
+	// URIs are the gs:// URIs of the uploaded files, sorted by path. The manifest is not included.
+	URIs []string
+	// TotalBytes is the size of the uploaded files.
+	TotalBytes int64
+	// ManifestURI is the gs:// URI of the manifest, if one was written.
+	ManifestURI string
+	// Failures are the files that failed on every attempt, sorted by path.
+	Failures []OutputDirFailure
+}
+
+// OutputDirManifest is the content of the manifest written by ProcessOutputDirAfterFFmpeg.
+type OutputDirManifest struct {
+	Prefix     string                    `json:"prefix"`
+	TotalBytes int64                     `json:"total_bytes"`
+	Objects    []OutputDirManifestObject `json:"objects"`
+}
+
+// OutputDirManifestObject is one object of an OutputDirManifest.
+type OutputDirManifestObject struct {
+	// Path is the object name relative to the prefix.
+	Path        string `json:"path"`
+	URI         string `json:"uri"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// ProcessOutputDirAfterFFmpeg uploads every file under localDir to gcsBucket, keeping its path
+// relative to localDir under gcsPrefix. It is the directory counterpart of
+// ProcessOutputAfterFFmpeg, for tools whose FFmpeg output is many files, such as extracted frames
+// or the channels of a split audio file. Files are uploaded opts.Concurrency at a time, and a
+// failed upload is retried on its own, with a growing delay, up to opts.Attempts times. Files
+// that still fail are listed in the result's Failures and the error says how many failed; the
+// other files stay uploaded. The manifest is only written when every file was uploaded.
+func ProcessOutputDirAfterFFmpeg(ctx context.Context, localDir, gcsBucket, gcsPrefix string, opts OutputDirOptions) (*OutputDirResult, error) {
+	bucket := strings.TrimPrefix(strings.TrimSpace(gcsBucket), "gs://")
+	if bucket == "" {
+		return nil, errors.New("no GCS bucket to upload the output directory to")
+	}
+	files, err := listOutputDir(localDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.WriteManifest {
+		for _, f := range files {
+			if f.RelPath == OutputDirManifestName {
+				return nil, fmt.Errorf("output directory %s already has an %s, which the manifest would replace", localDir, OutputDirManifestName)
+			}
+		}
+	}
+	return uploadOutputDir(ctx, localDir, files, bucket, gcsPrefix, opts)
+}
+
+// listOutputDir returns the files under localDir, sorted by path, and fails when there are none.
+func listOutputDir(localDir string) ([]OutputDirFile, error) {
+	var files []OutputDirFile
+	err := filepath.WalkDir(localDir, func(path string, d os.DirEntry, walkErr error) error {
+		if walkErr != nil || d.IsDir() {
+			return walkErr
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, OutputDirFile{RelPath: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list output directory %s: %w", localDir, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("output directory %s is empty", localDir)
+	}
+	return files, nil
+}
+
+// uploadOutputDir uploads the listed files of localDir to the bucket as described for
+// ProcessOutputDirAfterFFmpeg.
+func uploadOutputDir(ctx context.Context, localDir string, files []OutputDirFile, bucket, gcsPrefix string, opts OutputDirOptions) (*OutputDirResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultOutputDirConcurrency
+	}
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = DefaultOutputDirAttempts
+	}
+	gcsPrefix = strings.Trim(gcsPrefix, "/")
+	objectName := func(rel string) string {
+		if gcsPrefix == "" {
+			return rel
+		}
+		return gcsPrefix + "/" + rel
+	}
+
+	contentTypes := make([]string, len(files))
+	errs := make([]error, len(files))
+	tries := make([]int, len(files))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, f := range files {
+		contentTypes[i] = outputDirContentType(f.RelPath)
+		wg.Add(1)
+		go func(i int, f OutputDirFile) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			tries[i], errs[i] = uploadOutputDirFile(ctx, filepath.Join(localDir, filepath.FromSlash(f.RelPath)), bucket, objectName(f.RelPath), contentTypes[i], attempts)
+		}(i, f)
+	}
+	wg.Wait()
+
+	result := &OutputDirResult{}
+	manifest := OutputDirManifest{Prefix: fmt.Sprintf("gs://%s/%s", bucket, objectName(""))}
+	for i, f := range files {
+		if errs[i] != nil {
+			result.Failures = append(result.Failures, OutputDirFailure{RelPath: f.RelPath, Attempts: tries[i], Err: errs[i]})
+			continue
+		}
+		uri := fmt.Sprintf("gs://%s/%s", bucket, objectName(f.RelPath))
+		result.URIs = append(result.URIs, uri)
+		result.TotalBytes += f.Size
+		manifest.Objects = append(manifest.Objects, OutputDirManifestObject{Path: f.RelPath, URI: uri, Size: f.Size, ContentType: contentTypes[i]})
+	}
+	manifest.TotalBytes = result.TotalBytes
+	log.Printf("Uploaded %d of %d output files (%s) to %s", len(result.URIs), len(files), FormatBytes(result.TotalBytes), manifest.Prefix)
+	if len(result.Failures) > 0 {
+		return result, fmt.Errorf("failed to upload %d of %d files to %s, first %s: %w", len(result.Failures), len(files), manifest.Prefix, result.Failures[0].RelPath, result.Failures[0].Err)
+	}
+
+	if opts.WriteManifest {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return result, err
+		}
+		name := objectName(OutputDirManifestName)
+		if err := gcsUploader(ctx, bucket, name, "application/json", data); err != nil {
+			return result, fmt.Errorf("failed to upload the manifest to gs://%s/%s: %w", bucket, name, err)
+		}
+		result.ManifestURI = fmt.Sprintf("gs://%s/%s", bucket, name)
+		log.Printf("Output manifest uploaded to GCS: %s", result.ManifestURI)
+	}
+	return result, nil
+}
+
+// uploadOutputDirFile uploads one file, trying up to attempts times, and returns the number of
+// attempts made with the last error.
+func uploadOutputDirFile(ctx context.Context, localPath, bucket, objectName, contentType string, attempts int) (int, error) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read output file %s: %w", localPath, err)
+	}
+	delay := outputDirRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = gcsUploader(ctx, bucket, objectName, contentType, data)
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt == attempts || ctx.Err() != nil {
+			log.Printf("Failed to upload gs://%s/%s after %d attempt(s): %v", bucket, objectName, attempt, err)
+			return attempt, err
+		}
+		log.Printf("Upload of gs://%s/%s failed (attempt %d of %d), retrying in %v: %v", bucket, objectName, attempt, attempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// outputDirContentType returns the content type a file of an output directory is uploaded with:
+// the media types UploadToGCS knows, then the system's MIME types, then application/octet-stream.
+func outputDirContentType(relPath string) string {
+	if contentType := inferContentType(relPath); contentType != "" {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(relPath)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
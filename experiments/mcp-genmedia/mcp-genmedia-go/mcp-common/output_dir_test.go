@@ -0,0 +1,177 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOutputDirGCS stands in for gcsUploader: it keeps the uploaded objects, fails the first
+// failuresBefore[object] attempts at an object, and tracks how many uploads run at once.
+type fakeOutputDirGCS struct {
+	mu             sync.Mutex
+	objects        map[string][]byte
+	contentTypes   map[string]string
+	attempts       map[string]int
+	failuresBefore map[string]int
+	running        int
+	maxRunning     int
+}
+
+func installFakeOutputDirGCS(t *testing.T, failuresBefore map[string]int) *fakeOutputDirGCS {
+	t.Helper()
+	fake := &fakeOutputDirGCS{objects: map[string][]byte{}, contentTypes: map[string]string{}, attempts: map[string]int{}, failuresBefore: failuresBefore}
+	originalUploader, originalDelay := gcsUploader, outputDirRetryDelay
+	gcsUploader = func(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
+		uri := "gs://" + bucketName + "/" + objectName
+		fake.mu.Lock()
+		fake.running++
+		fake.maxRunning = max(fake.maxRunning, fake.running)
+		fake.attempts[uri]++
+		fail := fake.attempts[uri] <= fake.failuresBefore[uri]
+		fake.mu.Unlock()
+		defer func() {
+			fake.mu.Lock()
+			fake.running--
+			fake.mu.Unlock()
+		}()
+		if fail {
+			return errors.New("503 backend error")
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.objects[uri] = data
+		fake.contentTypes[uri] = contentType
+		return nil
+	}
+	outputDirRetryDelay = 0
+	t.Cleanup(func() { gcsUploader, outputDirRetryDelay = originalUploader, originalDelay })
+	return fake
+}
+
+// writeOutputTree writes files, by slash-separated relative path, under a new directory.
+func writeOutputTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestProcessOutputDirAfterFFmpegNestedDirectories(t *testing.T) {
+	fake := installFakeOutputDirGCS(t, nil)
+	dir := writeOutputTree(t, map[string]string{
+		"frames/frame_0001.png":        "png-1",
+		"frames/frame_0002.png":        "png-2",
+		"frames/thumbs/frame_0001.jpg": "jpg",
+		"channels/left.wav":            "left-channel",
+		"notes.vtt":                    "WEBVTT",
+		"raw.bin":                      "?",
+	})
+
+	result, err := ProcessOutputDirAfterFFmpeg(context.Background(), dir, "gs://media", "/runs/42/", OutputDirOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"gs://media/runs/42/channels/left.wav",
+		"gs://media/runs/42/frames/frame_0001.png",
+		"gs://media/runs/42/frames/frame_0002.png",
+		"gs://media/runs/42/frames/thumbs/frame_0001.jpg",
+		"gs://media/runs/42/notes.vtt",
+		"gs://media/runs/42/raw.bin",
+	}
+	if strings.Join(result.URIs, " ") != strings.Join(want, " ") {
+		t.Errorf("got URIs %v, want %v", result.URIs, want)
+	}
+	if result.TotalBytes != 5+5+3+12+6+1 || result.ManifestURI != "" || len(result.Failures) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if string(fake.objects["gs://media/runs/42/frames/thumbs/frame_0001.jpg"]) != "jpg" {
+		t.Errorf("expected the nested file's content to be uploaded, got %v", fake.objects)
+	}
+	for uri, contentType := range map[string]string{
+		"gs://media/runs/42/channels/left.wav":     "audio/wav",
+		"gs://media/runs/42/frames/frame_0001.png": "image/png",
+		"gs://media/runs/42/raw.bin":               "application/octet-stream",
+	} {
+		if fake.contentTypes[uri] != contentType {
+			t.Errorf("%s uploaded as %q, want %q", uri, fake.contentTypes[uri], contentType)
+		}
+	}
+	if fake.maxRunning > 2 {
+		t.Errorf("expected at most 2 uploads at once, got %d", fake.maxRunning)
+	}
+}
+
+func TestProcessOutputDirAfterFFmpegRetries(t *testing.T) {
+	fake := installFakeOutputDirGCS(t, map[string]int{
+		"gs://media/split/left.wav":  2,  // succeeds on the last attempt
+		"gs://media/split/right.wav": 10, // never succeeds
+	})
+	dir := writeOutputTree(t, map[string]string{"left.wav": "L", "right.wav": "R", "center.wav": "C"})
+
+	result, err := ProcessOutputDirAfterFFmpeg(context.Background(), dir, "media", "split", OutputDirOptions{Attempts: 3, WriteManifest: true})
+	if err == nil || !strings.Contains(err.Error(), "failed to upload 1 of 3 files") {
+		t.Fatalf("expected an error for the failed file, got %v", err)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].RelPath != "right.wav" || result.Failures[0].Attempts != 3 || result.Failures[0].Err == nil {
+		t.Errorf("expected right.wav to be reported after 3 attempts, got %+v", result.Failures)
+	}
+	if strings.Join(result.URIs, " ") != "gs://media/split/center.wav gs://media/split/left.wav" || result.TotalBytes != 2 {
+		t.Errorf("expected the other files to be uploaded, got %v (%d bytes)", result.URIs, result.TotalBytes)
+	}
+	if fake.attempts["gs://media/split/left.wav"] != 3 || fake.attempts["gs://media/split/center.wav"] != 1 {
+		t.Errorf("expected only the failing files to be retried, got %v", fake.attempts)
+	}
+	if _, ok := fake.objects["gs://media/split/index.json"]; ok || result.ManifestURI != "" {
+		t.Error("expected no manifest for an incomplete upload")
+	}
+}
+
+func TestProcessOutputDirAfterFFmpegManifest(t *testing.T) {
+	fake := installFakeOutputDirGCS(t, nil)
+	dir := writeOutputTree(t, map[string]string{"hls/master.m3u8": "#EXTM3U", "hls/seg_000.ts": "ts-data"})
+
+	result, err := ProcessOutputDirAfterFFmpeg(context.Background(), dir, "media", "", OutputDirOptions{WriteManifest: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ManifestURI != "gs://media/index.json" || fake.contentTypes[result.ManifestURI] != "application/json" {
+		t.Fatalf("unexpected manifest %q (%s)", result.ManifestURI, fake.contentTypes[result.ManifestURI])
+	}
+	var manifest OutputDirManifest
+	if err := json.Unmarshal(fake.objects[result.ManifestURI], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	want := OutputDirManifest{
+		Prefix:     "gs://media/",
+		TotalBytes: 14,
+		Objects: []OutputDirManifestObject{
+			{Path: "hls/master.m3u8", URI: "gs://media/hls/master.m3u8", Size: 7, ContentType: "application/vnd.apple.mpegurl"},
+			{Path: "hls/seg_000.ts", URI: "gs://media/hls/seg_000.ts", Size: 7, ContentType: "video/mp2t"},
+		},
+	}
+	got, _ := json.Marshal(manifest)
+	wantJSON, _ := json.Marshal(want)
+	if string(got) != string(wantJSON) {
+		t.Errorf("got manifest %s, want %s", got, wantJSON)
+	}
+
+	clash := writeOutputTree(t, map[string]string{"index.json": "{}"})
+	if _, err := ProcessOutputDirAfterFFmpeg(context.Background(), clash, "media", "", OutputDirOptions{WriteManifest: true}); err == nil {
+		t.Error("expected an error for a directory with its own index.json")
+	}
+}
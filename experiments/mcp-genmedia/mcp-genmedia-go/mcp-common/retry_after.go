@@ -0,0 +1,148 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRetryAfterMaxRetries is how many times a throttled request is retried.
+	defaultRetryAfterMaxRetries = 3
+	// defaultRetryAfterMaxWait is the longest Retry-After delay that is honored. Longer
+	// delays are returned to the caller as the original 429/503 response.
+	defaultRetryAfterMaxWait = time.Minute
+)
+
+// RetryAfterTransport is an http.RoundTripper that retries requests rejected with
+// 429 Too Many Requests or 503 Service Unavailable when the response carries a Retry-After
+// header, waiting as long as the server asked before trying again. Responses without
+// Retry-After are returned unchanged, so it does not add blind retries of its own.
+//
+// It sits below the GenAI SDK, so it applies equally to unary calls and to streaming
+// calls, whose throttling response arrives before any of the stream is read.
+type RetryAfterTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int
+	MaxWait    time.Duration
+	now        func() time.Time
+}
+
+// NewRetryAfterTransport wraps base (http.DefaultTransport if nil) with Retry-After handling.
+func NewRetryAfterTransport(base http.RoundTripper) *RetryAfterTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RetryAfterTransport{
+		Base:       base,
+		MaxRetries: defaultRetryAfterMaxRetries,
+		MaxWait:    defaultRetryAfterMaxWait,
+		now:        time.Now,
+	}
+}
+
+// HonorRetryAfter makes client honor Retry-After by wrapping its transport in place. It is
+// used on the HTTP client the GenAI SDK builds, which is shared by every call the SDK makes.
+func HonorRetryAfter(client *http.Client) {
+	if client == nil {
+		return
+	}
+	if _, ok := client.Transport.(*RetryAfterTransport); ok {
+		return
+	}
+	client.Transport = NewRetryAfterTransport(client.Transport)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.Base.RoundTrip(req)
+		if err != nil || attempt >= t.MaxRetries || !isThrottled(resp.StatusCode) {
+			return resp, err
+		}
+		now := time.Now
+		if t.now != nil {
+			now = t.now
+		}
+		delay, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), now())
+		if !ok || delay > t.MaxWait {
+			return resp, nil
+		}
+		next, err := rewindRequest(req)
+		if err != nil {
+			// the body cannot be replayed, so hand the throttled response back
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		log.Printf("%s %s returned %d; honoring Retry-After and retrying in %v (attempt %d/%d)", req.Method, req.URL.Redacted(), resp.StatusCode, delay, attempt+1, t.MaxRetries)
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+func isThrottled(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// rewindRequest returns a copy of req with a fresh body for another attempt.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next := req.Clone(req.Context())
+	next.Body = body
+	return next, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header value, which is either a number of seconds
+// or an HTTP-date, into a delay relative to now. Dates in the past give a zero delay.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := at.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
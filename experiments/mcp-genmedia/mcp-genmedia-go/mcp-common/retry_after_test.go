@@ -0,0 +1,95 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"1", time.Second, true},
+		{" 120 ", 2 * time.Minute, true},
+		{"Mon, 01 Sep 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Sep 2025 11:59:00 GMT", 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tc := range testCases {
+		delay, ok := ParseRetryAfter(tc.value, now)
+		if delay != tc.expected || ok != tc.ok {
+			t.Errorf("ParseRetryAfter(%q) = %v, %t; want %v, %t", tc.value, delay, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestRetryAfterTransportReplaysBody(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"prompt":"hi"}` {
+			t.Errorf("attempt %d received body %q", atomic.LoadInt32(&calls)+1, body)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{}
+	HonorRetryAfter(client)
+	HonorRetryAfter(client) // wrapping twice must not double the retries
+
+	start := time.Now()
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"prompt":"hi"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	elapsed := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected a single retry ending in 200, got status %d after %d calls", resp.StatusCode, calls)
+	}
+	if elapsed < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After, but it took %v", elapsed)
+	}
+}
+
+func TestRetryAfterTransportLeavesOtherResponses(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/long" {
+			w.Header().Set("Retry-After", "3600")
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewRetryAfterTransport(nil)}
+	for _, path := range []string{"/no-header", "/long"} {
+		atomic.StoreInt32(&calls, 0)
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests || atomic.LoadInt32(&calls) != 1 {
+			t.Errorf("%s: expected the 429 to be returned without retrying, got %d after %d calls", path, resp.StatusCode, calls)
+		}
+	}
+}
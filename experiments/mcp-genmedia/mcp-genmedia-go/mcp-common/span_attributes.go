@@ -0,0 +1,93 @@
+package common
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attribute keys shared by the generation tools. The gen_ai.* and error.type keys follow
+// the OpenTelemetry semantic conventions for generative AI, so traces from every server can be
+// queried the same way.
+const (
+	AttrRequestModel       = attribute.Key("gen_ai.request.model")
+	AttrResponseModel      = attribute.Key("gen_ai.response.model")
+	AttrInputTokens        = attribute.Key("gen_ai.usage.input_tokens")
+	AttrOutputTokens       = attribute.Key("gen_ai.usage.output_tokens")
+	AttrTotalTokens        = attribute.Key("gen_ai.usage.total_tokens")
+	AttrFinishReasons      = attribute.Key("gen_ai.response.finish_reasons")
+	AttrOutputBytes        = attribute.Key("output.bytes")
+	AttrOutputDestinations = attribute.Key("output.destinations")
+	AttrErrorType          = attribute.Key("error.type")
+)
+
+// Output destinations recorded in AttrOutputDestinations.
+const (
+	OutputInline = "inline" // returned in the tool result
+	OutputLocal  = "local"  // written to a local directory
+	OutputGCS    = "gcs"    // uploaded to Cloud Storage
+)
+
+// GenerationSpanInfo describes the outcome of a generation call for its span. Zero fields are
+// left off the span, so a server only fills in what its API reports.
+type GenerationSpanInfo struct {
+	// RequestedModel is the model the caller asked for; ModelVersion is the one the API says served it.
+	RequestedModel string
+	ModelVersion   string
+	// Token counts from the response's usage metadata. HasUsage distinguishes a response
+	// without usage metadata from one that used no tokens.
+	HasUsage        bool
+	PromptTokens    int64
+	CandidateTokens int64
+	TotalTokens     int64
+	// FinishReasons has one entry per candidate, in candidate order.
+	FinishReasons []string
+	// OutputBytes is the size of the generated media, and OutputDestinations where it went
+	// (OutputInline, OutputLocal and/or OutputGCS).
+	OutputBytes        int64
+	OutputDestinations []string
+}
+
+// Attributes returns the span attributes for the set fields of info.
+func (info GenerationSpanInfo) Attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if info.RequestedModel != "" {
+		attrs = append(attrs, AttrRequestModel.String(info.RequestedModel))
+	}
+	if info.ModelVersion != "" {
+		attrs = append(attrs, AttrResponseModel.String(info.ModelVersion))
+	}
+	if info.HasUsage {
+		attrs = append(attrs,
+			AttrInputTokens.Int64(info.PromptTokens),
+			AttrOutputTokens.Int64(info.CandidateTokens),
+			AttrTotalTokens.Int64(info.TotalTokens),
+		)
+	}
+	if len(info.FinishReasons) > 0 {
+		attrs = append(attrs, AttrFinishReasons.StringSlice(info.FinishReasons))
+	}
+	if info.OutputBytes > 0 {
+		attrs = append(attrs, AttrOutputBytes.Int64(info.OutputBytes))
+	}
+	if len(info.OutputDestinations) > 0 {
+		attrs = append(attrs, AttrOutputDestinations.StringSlice(info.OutputDestinations))
+	}
+	return attrs
+}
+
+// SetGenerationSpanAttributes records info on span.
+func SetGenerationSpanAttributes(span trace.Span, info GenerationSpanInfo) {
+	span.SetAttributes(info.Attributes()...)
+}
+
+// RecordSpanFailure marks span as failed: it records err, sets the error status and tags the
+// span with errorType, a short, stable classification such as "quota" or "invalid_argument"
+// that traces can be grouped by.
+func RecordSpanFailure(span trace.Span, err error, errorType string) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	if errorType != "" {
+		span.SetAttributes(AttrErrorType.String(errorType))
+	}
+}
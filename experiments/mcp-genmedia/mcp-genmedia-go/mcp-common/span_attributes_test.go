@@ -0,0 +1,88 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestGenerationSpanInfoAttributes(t *testing.T) {
+	info := GenerationSpanInfo{
+		RequestedModel:     "gemini-2.5-flash",
+		ModelVersion:       "gemini-2.5-flash-001",
+		HasUsage:           true,
+		PromptTokens:       12,
+		CandidateTokens:    30,
+		TotalTokens:        42,
+		FinishReasons:      []string{"STOP", "MAX_TOKENS"},
+		OutputBytes:        2048,
+		OutputDestinations: []string{OutputLocal, OutputGCS},
+	}
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, span := tp.Tracer("test").Start(context.Background(), "op")
+	SetGenerationSpanAttributes(span, info)
+	span.End()
+
+	attrs := spanAttributes(recorder.Ended()[0])
+	if len(attrs) != 8 {
+		t.Errorf("expected 8 attributes, got %v", attrs)
+	}
+	if attrs[AttrRequestModel].AsString() != "gemini-2.5-flash" || attrs[AttrResponseModel].AsString() != "gemini-2.5-flash-001" {
+		t.Errorf("unexpected models %v", attrs)
+	}
+	if attrs[AttrInputTokens].AsInt64() != 12 || attrs[AttrOutputTokens].AsInt64() != 30 || attrs[AttrTotalTokens].AsInt64() != 42 {
+		t.Errorf("unexpected token counts %v", attrs)
+	}
+	if got := attrs[AttrFinishReasons].AsStringSlice(); len(got) != 2 || got[1] != "MAX_TOKENS" {
+		t.Errorf("unexpected finish reasons %v", got)
+	}
+	if attrs[AttrOutputBytes].AsInt64() != 2048 || len(attrs[AttrOutputDestinations].AsStringSlice()) != 2 {
+		t.Errorf("unexpected output attributes %v", attrs)
+	}
+}
+
+func TestGenerationSpanInfoOmitsUnsetFields(t *testing.T) {
+	attrs := GenerationSpanInfo{RequestedModel: "gemini-2.5-flash-preview-tts", OutputDestinations: []string{OutputInline}}.Attributes()
+	if len(attrs) != 2 {
+		t.Errorf("expected only the model and destination, got %v", attrs)
+	}
+
+	// Usage metadata reporting zero tokens is still recorded.
+	attrs = GenerationSpanInfo{HasUsage: true}.Attributes()
+	if len(attrs) != 3 || attrs[0].Key != AttrInputTokens || attrs[0].Value.AsInt64() != 0 {
+		t.Errorf("expected zero token counts, got %v", attrs)
+	}
+}
+
+func TestRecordSpanFailure(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, span := tp.Tracer("test").Start(context.Background(), "op")
+	RecordSpanFailure(span, errors.New("quota exceeded"), "quota")
+	span.End()
+
+	ended := recorder.Ended()[0]
+	if ended.Status().Code != codes.Error || ended.Status().Description != "quota exceeded" {
+		t.Errorf("unexpected status %+v", ended.Status())
+	}
+	if attrs := spanAttributes(ended); attrs[AttrErrorType].AsString() != "quota" {
+		t.Errorf("expected error.type quota, got %v", attrs)
+	}
+	if events := ended.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded as an exception event, got %v", events)
+	}
+}
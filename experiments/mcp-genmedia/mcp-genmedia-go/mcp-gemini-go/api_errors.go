@@ -0,0 +1,164 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// asGeminiAPIError extracts the SDK's structured error from err. The SDK returns APIError by
+// value, but a pointer is also accepted in case it has been wrapped that way.
+func asGeminiAPIError(err error) (genai.APIError, bool) {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) && apiErrPtr != nil {
+		return *apiErrPtr, true
+	}
+	return genai.APIError{}, false
+}
+
+// API error types, the short classification recorded as a span's error.type. Each maps to the
+// user-facing category in apiErrorCategories.
+const (
+	errorTypeQuota            = "quota"
+	errorTypeSafety           = "safety"
+	errorTypeInvalidArgument  = "invalid_argument"
+	errorTypePermissionDenied = "permission_denied"
+	errorTypeNotFound         = "not_found"
+	errorTypeServer           = "server_error"
+	errorTypeAPI              = "api_error"
+)
+
+var apiErrorCategories = map[string]string{
+	errorTypeQuota:            "quota or rate limit exceeded; retry later or request more quota",
+	errorTypeSafety:           "request blocked by safety filters; rephrase the prompt or change the inputs",
+	errorTypeInvalidArgument:  "invalid argument; check the model name, prompt and inputs",
+	errorTypePermissionDenied: "permission denied; check the credentials and that the API is enabled for the project",
+	errorTypeNotFound:         "not found; check the model name and location",
+	errorTypeServer:           "server error; the request may succeed if retried",
+	errorTypeAPI:              "API error",
+}
+
+// apiErrorType classifies an API error so that quota, safety and argument problems can be
+// told apart.
+func apiErrorType(apiErr genai.APIError) string {
+	status := strings.ToUpper(apiErr.Status)
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests || strings.Contains(status, "RESOURCE_EXHAUSTED"):
+		return errorTypeQuota
+	case strings.Contains(strings.ToLower(apiErr.Message), "safety") || strings.Contains(strings.ToLower(apiErr.Message), "blocked"):
+		return errorTypeSafety
+	case apiErr.Code == http.StatusBadRequest || strings.Contains(status, "INVALID_ARGUMENT") || strings.Contains(status, "FAILED_PRECONDITION"):
+		return errorTypeInvalidArgument
+	case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden || strings.Contains(status, "PERMISSION_DENIED") || strings.Contains(status, "UNAUTHENTICATED"):
+		return errorTypePermissionDenied
+	case apiErr.Code == http.StatusNotFound || strings.Contains(status, "NOT_FOUND"):
+		return errorTypeNotFound
+	case apiErr.Code >= 500:
+		return errorTypeServer
+	default:
+		return errorTypeAPI
+	}
+}
+
+// classifyAPIError gives a short, user-facing category for an API error.
+func classifyAPIError(apiErr genai.APIError) string {
+	return apiErrorCategories[apiErrorType(apiErr)]
+}
+
+// geminiErrorType classifies any error from a Gemini call for the span's error.type: the API
+// error type for structured API errors, otherwise whether the call timed out or was canceled.
+func geminiErrorType(err error) string {
+	if apiErr, ok := asGeminiAPIError(err); ok {
+		return apiErrorType(apiErr)
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "request_failed"
+	}
+}
+
+// formatGeminiError formats an error from a Gemini call for an MCP error result. Structured
+// API errors are expanded into their code, status, category and details; any other error is
+// reported as before.
+func formatGeminiError(prefix string, err error) string {
+	apiErr, ok := asGeminiAPIError(err)
+	if !ok {
+		return fmt.Sprintf("%s: %v", prefix, err)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s\n", prefix, apiErr.Message)
+	fmt.Fprintf(&sb, "Code: %d\n", apiErr.Code)
+	if apiErr.Status != "" {
+		fmt.Fprintf(&sb, "Status: %s\n", apiErr.Status)
+	}
+	fmt.Fprintf(&sb, "Category: %s", classifyAPIError(apiErr))
+	if len(apiErr.Details) > 0 {
+		sb.WriteString("\nDetails:")
+		for _, detail := range apiErr.Details {
+			detailJSON, jsonErr := json.Marshal(detail)
+			if jsonErr != nil {
+				fmt.Fprintf(&sb, "\n- %v", detail)
+				continue
+			}
+			fmt.Fprintf(&sb, "\n- %s", detailJSON)
+		}
+	}
+	return sb.String()
+}
+
+// parseAPIErrorBody turns the JSON error body of a failed REST call into a genai.APIError,
+// so REST-based handlers can report it the same way as SDK calls. ok is false when the body
+// is not a Google API error document.
+func parseAPIErrorBody(statusCode int, body []byte) (apiErr genai.APIError, ok bool) {
+	var wrapper struct {
+		Error *genai.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &wrapper); err != nil || wrapper.Error == nil {
+		return genai.APIError{}, false
+	}
+	if wrapper.Error.Code == 0 {
+		wrapper.Error.Code = statusCode
+	}
+	return *wrapper.Error, true
+}
+
+// describePromptBlock reports why a response carries no content because the prompt was
+// blocked, or returns "" when it was not blocked.
+func describePromptBlock(resp *genai.GenerateContentResponse) string {
+	if resp == nil || resp.PromptFeedback == nil || resp.PromptFeedback.BlockReason == "" {
+		return ""
+	}
+	msg := fmt.Sprintf("Gemini blocked the request (block reason: %s)", resp.PromptFeedback.BlockReason)
+	if resp.PromptFeedback.BlockReasonMessage != "" {
+		msg += ": " + resp.PromptFeedback.BlockReasonMessage
+	}
+	return msg + "\nCategory: request blocked by safety filters; rephrase the prompt or change the inputs"
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+func TestFormatGeminiError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		contains []string
+	}{
+		{
+			name: "quota",
+			err:  genai.APIError{Code: 429, Status: "RESOURCE_EXHAUSTED", Message: "Quota exceeded"},
+			contains: []string{
+				"error calling Gemini API: Quota exceeded", "Code: 429", "Status: RESOURCE_EXHAUSTED", "Category: quota or rate limit exceeded",
+			},
+		},
+		{
+			name: "invalid argument with details, wrapped",
+			err: fmt.Errorf("wrapped: %w", genai.APIError{
+				Code: 400, Status: "INVALID_ARGUMENT", Message: "Unsupported MIME type",
+				Details: []map[string]any{{"@type": "type.googleapis.com/google.rpc.BadRequest", "reason": "BAD_MIME"}},
+			}),
+			contains: []string{"Category: invalid argument", "Details:", `"reason":"BAD_MIME"`},
+		},
+		{
+			name:     "safety",
+			err:      &genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "The prompt was blocked due to safety reasons"},
+			contains: []string{"Category: request blocked by safety filters"},
+		},
+		{
+			name:     "plain error",
+			err:      errors.New("connection reset"),
+			contains: []string{"error calling Gemini API: connection reset"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := formatGeminiError("error calling Gemini API", tc.err)
+			for _, want := range tc.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected %q in:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAPIErrorBody(t *testing.T) {
+	apiErr, ok := parseAPIErrorBody(403, []byte(`{"error": {"message": "Permission denied", "status": "PERMISSION_DENIED"}}`))
+	if !ok {
+		t.Fatal("expected the error body to be parsed")
+	}
+	if apiErr.Code != 403 || apiErr.Status != "PERMISSION_DENIED" {
+		t.Errorf("unexpected APIError: %+v", apiErr)
+	}
+	if _, ok := parseAPIErrorBody(500, []byte("upstream connect error")); ok {
+		t.Error("expected a non-JSON body not to be parsed")
+	}
+}
+
+func TestGenerateContentHandlerSurfacesAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": 429, "message": "Resource has been exhausted", "status": "RESOURCE_EXHAUSTED",
+			"details": [{"@type": "type.googleapis.com/google.rpc.QuotaFailure", "violations": [{"subject": "generate_content_requests"}]}]}}`)
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"prompt": "a lighthouse at dusk", "model": "gemini-2.5-flash-image-preview"}
+	result, err := geminiGenerateContentHandler(client, context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected handler error: %v", err)
+	}
+	if !result.IsError {
+		t.Fatal("expected an error result")
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"Code: 429", "Status: RESOURCE_EXHAUSTED", "Category: quota", "generate_content_requests"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// genaiBackendEnvVar picks the backend explicitly when both a project and an API key are set.
+const genaiBackendEnvVar = "GENAI_BACKEND"
+
+// backendSetupHelp lists the two supported configurations, for startup errors.
+const backendSetupHelp = `mcp-gemini-go needs one of these setups:
+  1. Vertex AI: set PROJECT_ID (and optionally LOCATION) and provide Application Default Credentials, e.g. with 'gcloud auth application-default login'.
+  2. Gemini API (AI Studio): set GOOGLE_API_KEY or GEMINI_API_KEY to a key from https://aistudio.google.com/apikey.
+If both are set, Vertex AI is used unless GENAI_BACKEND=gemini.`
+
+// envLookup reads an environment variable, like os.LookupEnv. Tests substitute a map.
+type envLookup func(key string) (string, bool)
+
+// backendSelection is the GenAI backend chosen at startup and the settings it needs.
+type backendSelection struct {
+	Backend   genai.Backend
+	ProjectID string
+	APIKey    string
+	// APIKeySource is the environment variable the API key came from.
+	APIKeySource string
+}
+
+// activeBackend is the backend the server was started with. Handlers check it to reject
+// options that only work on Vertex AI; the zero value behaves as Vertex AI.
+var activeBackend backendSelection
+
+// geminiAPI reports whether the server is using the Gemini API backend.
+func (b backendSelection) geminiAPI() bool {
+	return b.Backend == genai.BackendGeminiAPI
+}
+
+// String names the backend for logs and results.
+func (b backendSelection) String() string {
+	if b.geminiAPI() {
+		return fmt.Sprintf("Gemini API (key from %s)", b.APIKeySource)
+	}
+	return fmt.Sprintf("Vertex AI (project %s)", b.ProjectID)
+}
+
+// selectBackend decides between Vertex AI and the Gemini API from the environment.
+// PROJECT_ID alone selects Vertex AI and GOOGLE_API_KEY or GEMINI_API_KEY alone selects the
+// Gemini API, with GOOGLE_API_KEY preferred as in the GenAI SDK. When both a project and a key
+// are present, Vertex AI is kept so existing deployments do not change backend because an
+// unrelated key is in the environment; GENAI_BACKEND (vertex or gemini) overrides that.
+func selectBackend(lookup envLookup) (backendSelection, error) {
+	get := func(key string) string {
+		v, _ := lookup(key)
+		return strings.TrimSpace(v)
+	}
+	sel := backendSelection{ProjectID: get("PROJECT_ID")}
+	for _, key := range []string{"GOOGLE_API_KEY", "GEMINI_API_KEY"} {
+		if v := get(key); v != "" {
+			sel.APIKey, sel.APIKeySource = v, key
+			break
+		}
+	}
+
+	switch choice := strings.ToLower(get(genaiBackendEnvVar)); choice {
+	case "":
+		switch {
+		case sel.ProjectID != "":
+			sel.Backend = genai.BackendVertexAI
+		case sel.APIKey != "":
+			sel.Backend = genai.BackendGeminiAPI
+		default:
+			return backendSelection{}, fmt.Errorf("neither PROJECT_ID nor an API key is set.\n%s", backendSetupHelp)
+		}
+	case "vertex", "vertexai", "vertex_ai":
+		if sel.ProjectID == "" {
+			return backendSelection{}, fmt.Errorf("%s=%s requires PROJECT_ID to be set.\n%s", genaiBackendEnvVar, choice, backendSetupHelp)
+		}
+		sel.Backend = genai.BackendVertexAI
+	case "gemini", "gemini_api", "geminiapi":
+		if sel.APIKey == "" {
+			return backendSelection{}, fmt.Errorf("%s=%s requires GOOGLE_API_KEY or GEMINI_API_KEY to be set.\n%s", genaiBackendEnvVar, choice, backendSetupHelp)
+		}
+		sel.Backend = genai.BackendGeminiAPI
+	default:
+		return backendSelection{}, fmt.Errorf("%s must be 'vertex' or 'gemini', got %q", genaiBackendEnvVar, choice)
+	}
+
+	if sel.Backend == genai.BackendVertexAI {
+		sel.APIKey, sel.APIKeySource = "", ""
+	} else {
+		sel.ProjectID = ""
+	}
+	return sel, nil
+}
+
+// checkGenerateRequestForBackend rejects gemini_image_generation options that need Vertex AI.
+// The Gemini API cannot read gs:// inputs, PDF and video inputs are only passed by GCS URI, and
+// there is no project to write GCS outputs to, so those requests fail here with a hint instead
+// of an opaque API or storage error.
+func checkGenerateRequestForBackend(b backendSelection, gcsBucketURI string, inputPaths []string) error {
+	if !b.geminiAPI() {
+		return nil
+	}
+	if gcsBucketURI != "" {
+		return fmt.Errorf("gcs_bucket_uri is not available on the Gemini API backend, which has no Google Cloud project to write to; use output_directory instead, or start the server with PROJECT_ID set to use Vertex AI")
+	}
+	for _, p := range inputPaths {
+		if strings.HasPrefix(p, "gs://") {
+			return fmt.Errorf("input %s is a GCS URI, which the Gemini API backend cannot read; pass a local image path instead, or start the server with PROJECT_ID set to use Vertex AI", p)
+		}
+		if _, isFileInput := fileInputMimeTypes[strings.ToLower(filepath.Ext(p))]; isFileInput {
+			return fmt.Errorf("PDF and video input %s is only supported on the Vertex AI backend, which reads it from GCS; start the server with PROJECT_ID set to use it", p)
+		}
+	}
+	return nil
+}
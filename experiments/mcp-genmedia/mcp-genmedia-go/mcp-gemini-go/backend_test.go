@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+func mapEnv(env map[string]string) envLookup {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestSelectBackend(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		backend    genai.Backend
+		apiKey     string
+		keySource  string
+		wantErr    string
+		wantErrAll []string
+	}{
+		{name: "project only", env: map[string]string{"PROJECT_ID": "my-project"}, backend: genai.BackendVertexAI},
+		{name: "google api key only", env: map[string]string{"GOOGLE_API_KEY": "g-key"}, backend: genai.BackendGeminiAPI, apiKey: "g-key", keySource: "GOOGLE_API_KEY"},
+		{name: "gemini api key only", env: map[string]string{"GEMINI_API_KEY": "m-key"}, backend: genai.BackendGeminiAPI, apiKey: "m-key", keySource: "GEMINI_API_KEY"},
+		{name: "google key preferred", env: map[string]string{"GOOGLE_API_KEY": "g-key", "GEMINI_API_KEY": "m-key"}, backend: genai.BackendGeminiAPI, apiKey: "g-key", keySource: "GOOGLE_API_KEY"},
+		{name: "blank key ignored", env: map[string]string{"GOOGLE_API_KEY": " ", "GEMINI_API_KEY": "m-key"}, backend: genai.BackendGeminiAPI, apiKey: "m-key", keySource: "GEMINI_API_KEY"},
+		{name: "both defaults to vertex", env: map[string]string{"PROJECT_ID": "my-project", "GEMINI_API_KEY": "m-key"}, backend: genai.BackendVertexAI},
+		{name: "both with explicit gemini", env: map[string]string{"PROJECT_ID": "my-project", "GEMINI_API_KEY": "m-key", "GENAI_BACKEND": "Gemini"}, backend: genai.BackendGeminiAPI, apiKey: "m-key", keySource: "GEMINI_API_KEY"},
+		{name: "both with explicit vertex", env: map[string]string{"PROJECT_ID": "my-project", "GEMINI_API_KEY": "m-key", "GENAI_BACKEND": "vertex"}, backend: genai.BackendVertexAI},
+		{name: "neither", env: map[string]string{}, wantErrAll: []string{"neither PROJECT_ID nor an API key is set", "1. Vertex AI: set PROJECT_ID", "2. Gemini API (AI Studio): set GOOGLE_API_KEY or GEMINI_API_KEY"}},
+		{name: "gemini without key", env: map[string]string{"PROJECT_ID": "my-project", "GENAI_BACKEND": "gemini"}, wantErr: "GENAI_BACKEND=gemini requires GOOGLE_API_KEY or GEMINI_API_KEY"},
+		{name: "vertex without project", env: map[string]string{"GOOGLE_API_KEY": "g-key", "GENAI_BACKEND": "vertex"}, wantErr: "GENAI_BACKEND=vertex requires PROJECT_ID"},
+		{name: "unknown backend", env: map[string]string{"PROJECT_ID": "my-project", "GENAI_BACKEND": "studio"}, wantErr: `GENAI_BACKEND must be 'vertex' or 'gemini', got "studio"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := selectBackend(mapEnv(tt.env))
+			if tt.wantErr != "" || tt.wantErrAll != nil {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", sel)
+				}
+				for _, want := range append(tt.wantErrAll, tt.wantErr) {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sel.Backend != tt.backend || sel.APIKey != tt.apiKey || sel.APIKeySource != tt.keySource {
+				t.Errorf("got %+v, want backend %s with key %q from %q", sel, tt.backend, tt.apiKey, tt.keySource)
+			}
+			if sel.Backend == genai.BackendVertexAI && sel.ProjectID != "my-project" {
+				t.Errorf("expected the project to be kept for Vertex AI, got %q", sel.ProjectID)
+			}
+		})
+	}
+}
+
+func TestCheckGenerateRequestForBackend(t *testing.T) {
+	gemini := backendSelection{Backend: genai.BackendGeminiAPI, APIKeySource: "GEMINI_API_KEY"}
+	vertex := backendSelection{Backend: genai.BackendVertexAI, ProjectID: "my-project"}
+	tests := []struct {
+		name    string
+		backend backendSelection
+		gcsURI  string
+		inputs  []string
+		wantErr string
+	}{
+		{name: "vertex allows gcs", backend: vertex, gcsURI: "gs://bucket/out/", inputs: []string{"gs://bucket/a.png", "gs://bucket/brief.pdf"}},
+		{name: "gemini allows local images", backend: gemini, inputs: []string{"photo.png"}},
+		{name: "gemini rejects gcs output", backend: gemini, gcsURI: "gs://bucket/out/", wantErr: "gcs_bucket_uri is not available on the Gemini API backend"},
+		{name: "gemini rejects gcs input", backend: gemini, inputs: []string{"photo.png", "gs://bucket/a.png"}, wantErr: "input gs://bucket/a.png is a GCS URI"},
+		{name: "gemini rejects local video", backend: gemini, inputs: []string{"clip.MP4"}, wantErr: "PDF and video input clip.MP4 is only supported on the Vertex AI backend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGenerateRequestForBackend(tt.backend, tt.gcsURI, tt.inputs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTTSHandlerRejectedOnGeminiAPIBackend(t *testing.T) {
+	original := activeBackend
+	activeBackend = backendSelection{Backend: genai.BackendGeminiAPI, APIKeySource: "GOOGLE_API_KEY"}
+	defer func() { activeBackend = original }()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"text": "hello"}
+	result, err := geminiAudioTTSHandler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "not available on the Gemini API backend") {
+		t.Errorf("expected a backend error, got %+v", result)
+	}
+}
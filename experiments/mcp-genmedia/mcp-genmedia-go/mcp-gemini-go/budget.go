@@ -0,0 +1,631 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/genai"
+)
+
+// toolLimitEnvVars maps each budgeted tool to the environment variable holding its limit.
+var toolLimitEnvVars = map[string]string{
+	"gemini_image_generation": "GEMINI_IMAGE_GEN_LIMIT",
+	"gemini_audio_tts":        "GEMINI_TTS_LIMIT",
+}
+
+const (
+	// modelLimitsEnvVar holds per-model limits as a comma-separated list of model=limit pairs.
+	modelLimitsEnvVar = "GEMINI_MODEL_LIMITS"
+	// budgetStateEnvVar is the gs:// URI of the object used to persist budget state.
+	budgetStateEnvVar = "GEMINI_BUDGET_STATE_URI"
+	// defaultUsageWindow is the window reported for tools and models without a limit.
+	defaultUsageWindow = 24 * time.Hour
+	// budgetSaveTimeout bounds one save of the budget state.
+	budgetSaveTimeout = 30 * time.Second
+)
+
+// budgetLimit caps the number of requests within a rolling window.
+type budgetLimit struct {
+	Max    int
+	Window time.Duration
+	// Source names the setting the limit came from, for error messages.
+	Source string
+}
+
+// parseBudgetLimit parses a limit such as "200/24h", "50/30m" or "1000/7d".
+func parseBudgetLimit(value string) (budgetLimit, error) {
+	countText, windowText, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		return budgetLimit{}, fmt.Errorf("limit %q must have the form <requests>/<window>, e.g. 200/24h", value)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countText))
+	if err != nil || count < 0 {
+		return budgetLimit{}, fmt.Errorf("limit %q must start with a non-negative request count", value)
+	}
+	windowText = strings.TrimSpace(windowText)
+	var window time.Duration
+	if days, ok := strings.CutSuffix(windowText, "d"); ok {
+		n, dErr := strconv.Atoi(days)
+		if dErr != nil {
+			err = dErr
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		window, err = time.ParseDuration(windowText)
+	}
+	if err != nil || window <= 0 {
+		return budgetLimit{}, fmt.Errorf("limit %q has an invalid window; use a duration such as 30m, 24h or 7d", value)
+	}
+	return budgetLimit{Max: count, Window: window}, nil
+}
+
+func (l budgetLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Max, formatWindow(l.Window))
+}
+
+// formatWindow prints whole-day and whole-hour windows compactly (7d, 24h) instead of 168h0m0s.
+func formatWindow(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour && d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// budgetConfig holds the configured limits and where the state is persisted.
+type budgetConfig struct {
+	ToolLimits  map[string]budgetLimit
+	ModelLimits map[string]budgetLimit
+	StateURI    string
+}
+
+// loadBudgetConfig reads the limits from the environment. Unset limits mean unlimited.
+func loadBudgetConfig() (budgetConfig, error) {
+	cfg := budgetConfig{
+		ToolLimits:  make(map[string]budgetLimit),
+		ModelLimits: make(map[string]budgetLimit),
+		StateURI:    strings.TrimSpace(os.Getenv(budgetStateEnvVar)),
+	}
+	for tool, envVar := range toolLimitEnvVars {
+		value := strings.TrimSpace(os.Getenv(envVar))
+		if value == "" {
+			continue
+		}
+		limit, err := parseBudgetLimit(value)
+		if err != nil {
+			return budgetConfig{}, fmt.Errorf("%s: %w", envVar, err)
+		}
+		limit.Source = envVar
+		cfg.ToolLimits[tool] = limit
+	}
+	for _, entry := range strings.Split(os.Getenv(modelLimitsEnvVar), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		model, value, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(model) == "" {
+			return budgetConfig{}, fmt.Errorf("%s: entry %q must have the form <model>=<requests>/<window>", modelLimitsEnvVar, entry)
+		}
+		limit, err := parseBudgetLimit(value)
+		if err != nil {
+			return budgetConfig{}, fmt.Errorf("%s: %w", modelLimitsEnvVar, err)
+		}
+		limit.Source = modelLimitsEnvVar
+		cfg.ModelLimits[canonicalModelName(model)] = limit
+	}
+	return cfg, nil
+}
+
+// budgetStore persists the budget state so a restart does not reset the windows.
+type budgetStore interface {
+	// Load returns the saved state, or nil if nothing has been saved yet.
+	Load(ctx context.Context) ([]byte, error)
+	Save(ctx context.Context, data []byte) error
+}
+
+// gcsBudgetStore keeps the budget state in a single GCS object.
+type gcsBudgetStore struct {
+	bucket string
+	object string
+}
+
+func newGCSBudgetStore(uri string) (*gcsBudgetStore, error) {
+	bucket, object, err := common.ParseGCSPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	if object == "" {
+		return nil, fmt.Errorf("%s must name an object, e.g. gs://bucket/mcp-gemini/budget.json", budgetStateEnvVar)
+	}
+	return &gcsBudgetStore{bucket: bucket, object: object}, nil
+}
+
+func (s *gcsBudgetStore) Load(ctx context.Context) ([]byte, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	rc, err := client.Bucket(s.bucket).Object(s.object).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading gs://%s/%s: %w", s.bucket, s.object, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (s *gcsBudgetStore) Save(ctx context.Context, data []byte) error {
+	return common.UploadToGCS(ctx, s.bucket, s.object, "application/json", data)
+}
+
+// tokenUsage accumulates the token counts reported by successful responses.
+type tokenUsage struct {
+	PromptTokens   int64 `json:"prompt_tokens"`
+	OutputTokens   int64 `json:"output_tokens"`
+	ThoughtsTokens int64 `json:"thoughts_tokens"`
+	TotalTokens    int64 `json:"total_tokens"`
+}
+
+func (u *tokenUsage) add(other tokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.OutputTokens += other.OutputTokens
+	u.ThoughtsTokens += other.ThoughtsTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// usageEvent is one request counted against the budget.
+type usageEvent struct {
+	Tool  string    `json:"tool"`
+	Model string    `json:"model"`
+	At    time.Time `json:"at"`
+}
+
+// tokenRecord is the accumulated token usage of one tool and model pair.
+type tokenRecord struct {
+	Tool  string     `json:"tool"`
+	Model string     `json:"model"`
+	Usage tokenUsage `json:"usage"`
+}
+
+// budgetState is the persisted form of a usageBudget.
+type budgetState struct {
+	Events []usageEvent  `json:"events"`
+	Tokens []tokenRecord `json:"tokens"`
+}
+
+// budgetExceededError is returned when a request would go over a tool or model limit.
+type budgetExceededError struct {
+	Scope   string // "tool" or "model"
+	Name    string
+	Limit   budgetLimit
+	Used    int
+	ResetAt time.Time
+	Now     time.Time
+}
+
+func (e *budgetExceededError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "budget exceeded for %s %s: %d of %d requests used in the last %s\n", e.Scope, e.Name, e.Used, e.Limit.Max, formatWindow(e.Limit.Window))
+	fmt.Fprintf(&sb, "Limit: %s (%s)\n", e.Limit, e.Limit.Source)
+	fmt.Fprintf(&sb, "Resets at: %s (in %s)\n", e.ResetAt.UTC().Format(time.RFC3339), e.ResetAt.Sub(e.Now).Round(time.Second))
+	sb.WriteString("Category: request budget exceeded; wait for the reset time or raise the limit")
+	return sb.String()
+}
+
+// usageBudget enforces request limits per tool and per model over rolling windows and
+// accumulates token usage. A nil *usageBudget allows everything and records nothing.
+type usageBudget struct {
+	mu          sync.Mutex
+	now         func() time.Time
+	toolLimits  map[string]budgetLimit
+	modelLimits map[string]budgetLimit
+	events      []usageEvent
+	tokens      map[[2]string]*tokenUsage
+
+	// store persists the state in the background: changes set dirty, and a single saver
+	// goroutine, running while saving is set, writes the latest state until nothing is left.
+	store  budgetStore
+	dirty  bool
+	saving bool
+	saves  sync.WaitGroup
+}
+
+// newUsageBudget creates a budget with the given limits, restoring any state saved in store.
+func newUsageBudget(ctx context.Context, cfg budgetConfig, store budgetStore, now func() time.Time) (*usageBudget, error) {
+	if now == nil {
+		now = time.Now
+	}
+	b := &usageBudget{
+		now:         now,
+		toolLimits:  cfg.ToolLimits,
+		modelLimits: cfg.ModelLimits,
+		tokens:      make(map[[2]string]*tokenUsage),
+		store:       store,
+	}
+	if store == nil {
+		return b, nil
+	}
+	data, err := store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load budget state: %w", err)
+	}
+	if len(data) == 0 {
+		return b, nil
+	}
+	var state budgetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse budget state: %w", err)
+	}
+	// state saved before models were canonicalized is folded into the canonical names
+	b.events = state.Events
+	for i := range b.events {
+		b.events[i].Model = canonicalModelName(b.events[i].Model)
+	}
+	for _, record := range state.Tokens {
+		key := [2]string{record.Tool, canonicalModelName(record.Model)}
+		if b.tokens[key] == nil {
+			b.tokens[key] = &tokenUsage{}
+		}
+		b.tokens[key].add(record.Usage)
+	}
+	b.prune(b.now())
+	return b, nil
+}
+
+// retention is how long events are kept: long enough for every configured window and for
+// the default reporting window.
+func (b *usageBudget) retention() time.Duration {
+	longest := defaultUsageWindow
+	for _, limits := range []map[string]budgetLimit{b.toolLimits, b.modelLimits} {
+		for _, limit := range limits {
+			if limit.Window > longest {
+				longest = limit.Window
+			}
+		}
+	}
+	return longest
+}
+
+// prune drops events that have aged out of every window. Events are kept in time order.
+func (b *usageBudget) prune(now time.Time) {
+	cutoff := now.Add(-b.retention())
+	i := sort.Search(len(b.events), func(i int) bool { return b.events[i].At.After(cutoff) })
+	b.events = b.events[i:]
+}
+
+// windowUsage counts the matching events in the window ending at now and returns the
+// earliest of them, which is the next one to age out.
+func (b *usageBudget) windowUsage(now time.Time, window time.Duration, match func(usageEvent) bool) (int, time.Time) {
+	cutoff := now.Add(-window)
+	count := 0
+	var oldest time.Time
+	for _, e := range b.events {
+		if !e.At.After(cutoff) || !match(e) {
+			continue
+		}
+		if count == 0 {
+			oldest = e.At
+		}
+		count++
+	}
+	return count, oldest
+}
+
+// check returns a budgetExceededError if one more request would go over the limit.
+func (b *usageBudget) check(now time.Time, scope, name string, limit budgetLimit, match func(usageEvent) bool) error {
+	used, oldest := b.windowUsage(now, limit.Window, match)
+	if used < limit.Max {
+		return nil
+	}
+	resetAt := now
+	if used > 0 {
+		resetAt = oldest.Add(limit.Window)
+	}
+	return &budgetExceededError{Scope: scope, Name: name, Limit: limit, Used: used, ResetAt: resetAt, Now: now}
+}
+
+// Reserve counts a request for the tool and model, or returns a *budgetExceededError
+// without counting it if either limit has been reached.
+func (b *usageBudget) Reserve(ctx context.Context, tool, model string) error {
+	if b == nil {
+		return nil
+	}
+	model = canonicalModelName(model)
+	b.mu.Lock()
+	now := b.now()
+	b.prune(now)
+	if limit, ok := b.toolLimits[tool]; ok {
+		if err := b.check(now, "tool", tool, limit, func(e usageEvent) bool { return e.Tool == tool }); err != nil {
+			b.mu.Unlock()
+			return err
+		}
+	}
+	if limit, ok := b.modelLimits[model]; ok {
+		if err := b.check(now, "model", model, limit, func(e usageEvent) bool { return e.Model == model }); err != nil {
+			b.mu.Unlock()
+			return err
+		}
+	}
+	b.events = append(b.events, usageEvent{Tool: tool, Model: model, At: now})
+	b.persistLocked()
+	b.mu.Unlock()
+	return nil
+}
+
+// RecordTokens adds the token counts of a successful response to the tool and model totals.
+func (b *usageBudget) RecordTokens(ctx context.Context, tool, model string, metadata *genai.GenerateContentResponseUsageMetadata) {
+	if b == nil || metadata == nil {
+		return
+	}
+	b.mu.Lock()
+	key := [2]string{tool, canonicalModelName(model)}
+	usage, ok := b.tokens[key]
+	if !ok {
+		usage = &tokenUsage{}
+		b.tokens[key] = usage
+	}
+	usage.add(tokenUsage{
+		PromptTokens:   int64(metadata.PromptTokenCount),
+		OutputTokens:   int64(metadata.CandidatesTokenCount),
+		ThoughtsTokens: int64(metadata.ThoughtsTokenCount),
+		TotalTokens:    int64(metadata.TotalTokenCount),
+	})
+	b.persistLocked()
+	b.mu.Unlock()
+}
+
+// snapshotLocked serializes the state; b.mu must be held.
+func (b *usageBudget) snapshotLocked() []byte {
+	state := budgetState{Events: b.events}
+	for key, usage := range b.tokens {
+		state.Tokens = append(state.Tokens, tokenRecord{Tool: key[0], Model: key[1], Usage: *usage})
+	}
+	sort.Slice(state.Tokens, func(i, j int) bool {
+		if state.Tokens[i].Tool != state.Tokens[j].Tool {
+			return state.Tokens[i].Tool < state.Tokens[j].Tool
+		}
+		return state.Tokens[i].Model < state.Tokens[j].Model
+	})
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("failed to serialize budget state: %v", err)
+		return nil
+	}
+	return data
+}
+
+// persistLocked marks the state as changed and starts the saver if it is not running, so
+// requests never wait on the store and changes made during a save go out together in the
+// next one; b.mu must be held.
+func (b *usageBudget) persistLocked() {
+	if b.store == nil {
+		return
+	}
+	b.dirty = true
+	if b.saving {
+		return
+	}
+	b.saving = true
+	b.saves.Add(1)
+	go b.saveLoop()
+}
+
+// saveLoop writes the latest state until no change is left unsaved. Failures are logged
+// rather than failing requests: the in-memory budget is still enforced.
+func (b *usageBudget) saveLoop() {
+	defer b.saves.Done()
+	for {
+		b.mu.Lock()
+		if !b.dirty {
+			b.saving = false
+			b.mu.Unlock()
+			return
+		}
+		b.dirty = false
+		data := b.snapshotLocked()
+		b.mu.Unlock()
+
+		if data == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), budgetSaveTimeout)
+		if err := b.store.Save(ctx, data); err != nil {
+			log.Printf("failed to persist budget state: %v", err)
+		}
+		cancel()
+	}
+}
+
+// waitForSaves blocks until the saver has written every change made so far.
+func (b *usageBudget) waitForSaves() {
+	b.saves.Wait()
+}
+
+// usageCounter is the consumption of one tool or model, as reported by gemini_usage.
+type usageCounter struct {
+	Name      string     `json:"name"`
+	Requests  int        `json:"requests"`
+	Window    string     `json:"window"`
+	Limit     *int       `json:"limit,omitempty"`
+	Remaining *int       `json:"remaining,omitempty"`
+	ResetsAt  string     `json:"resets_at,omitempty"`
+	Tokens    tokenUsage `json:"tokens"`
+}
+
+// usageReport is the gemini_usage result.
+type usageReport struct {
+	Tools  []usageCounter `json:"tools"`
+	Models []usageCounter `json:"models"`
+}
+
+// Report returns the current consumption per tool and per model. Requests are counted
+// over each limit's window, or over the last 24h where there is no limit; token totals
+// are cumulative.
+func (b *usageBudget) Report() usageReport {
+	report := usageReport{Tools: []usageCounter{}, Models: []usageCounter{}}
+	if b == nil {
+		return report
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.prune(now)
+
+	toolNames := make(map[string]bool)
+	modelNames := make(map[string]bool)
+	for name := range b.toolLimits {
+		toolNames[name] = true
+	}
+	for name := range b.modelLimits {
+		modelNames[name] = true
+	}
+	for _, e := range b.events {
+		toolNames[e.Tool] = true
+		modelNames[e.Model] = true
+	}
+	for key := range b.tokens {
+		toolNames[key[0]] = true
+		modelNames[key[1]] = true
+	}
+
+	counter := func(name string, limits map[string]budgetLimit, match func(usageEvent) bool, tokenKey int) usageCounter {
+		window := defaultUsageWindow
+		limit, limited := limits[name]
+		if limited {
+			window = limit.Window
+		}
+		used, oldest := b.windowUsage(now, window, match)
+		c := usageCounter{Name: name, Requests: used, Window: formatWindow(window)}
+		if limited {
+			max, remaining := limit.Max, limit.Max-used
+			if remaining < 0 {
+				remaining = 0
+			}
+			c.Limit, c.Remaining = &max, &remaining
+			if used > 0 {
+				c.ResetsAt = oldest.Add(window).UTC().Format(time.RFC3339)
+			}
+		}
+		for key, usage := range b.tokens {
+			if key[tokenKey] == name {
+				c.Tokens.add(*usage)
+			}
+		}
+		return c
+	}
+	for _, name := range sortedKeys(toolNames) {
+		report.Tools = append(report.Tools, counter(name, b.toolLimits, func(e usageEvent) bool { return e.Tool == name }, 0))
+	}
+	for _, name := range sortedKeys(modelNames) {
+		report.Models = append(report.Models, counter(name, b.modelLimits, func(e usageEvent) bool { return e.Model == name }, 1))
+	}
+	return report
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// canonicalModelName is the form models are counted under, so that "models/gemini-2.5-pro"
+// and " Gemini-2.5-Pro " share one budget: the resource path prefix, surrounding space and
+// case are dropped.
+func canonicalModelName(model string) string {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(model, "models/"); i >= 0 {
+		model = model[i+len("models/"):]
+	}
+	return model
+}
+
+// argsValidator checks a tool's arguments, returning the error the tool would report.
+type argsValidator func(args map[string]interface{}) error
+
+// withBudget wraps a tool handler so the request is counted against the tool's and the
+// model's budgets before the API is called. Requests that validate rejects are returned
+// without being counted. modelArg names the argument holding the model, and defaultModel
+// is used when it is not provided.
+func withBudget(b *usageBudget, tool, modelArg, defaultModel string, validate argsValidator, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if validate != nil {
+			if err := validate(request.GetArguments()); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		}
+		model, _ := request.GetArguments()[modelArg].(string)
+		if strings.TrimSpace(model) == "" {
+			model = defaultModel
+		}
+		if err := b.Reserve(ctx, tool, model); err != nil {
+			log.Printf("Rejecting %s request for model %s: %v", tool, model, err)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return next(ctx, request)
+	}
+}
+
+// geminiUsageHandler handles the 'gemini_usage' tool request.
+func geminiUsageHandler(b *usageBudget, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	report := b.Report()
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal usage report: %v", err)), nil
+	}
+	var limited []string
+	for _, c := range append(report.Tools, report.Models...) {
+		if c.Limit != nil {
+			limited = append(limited, fmt.Sprintf("%s %d/%d", c.Name, c.Requests, *c.Limit))
+		}
+	}
+	summary := "No request budgets are configured."
+	if len(limited) > 0 {
+		summary = "Budget consumption: " + strings.Join(limited, ", ") + "."
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{Type: "text", Text: summary},
+			mcp.TextContent{Type: "text", Text: string(reportJSON)},
+		},
+	}, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// fakeClock is a settable time source for budget tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// fakeBudgetStore stands in for the GCS counter object.
+type fakeBudgetStore struct {
+	data  []byte
+	saves int
+}
+
+func (s *fakeBudgetStore) Load(ctx context.Context) ([]byte, error) { return s.data, nil }
+func (s *fakeBudgetStore) Save(ctx context.Context, data []byte) error {
+	s.data = append([]byte(nil), data...)
+	s.saves++
+	return nil
+}
+
+func TestParseBudgetLimit(t *testing.T) {
+	testCases := []struct {
+		value     string
+		expected  budgetLimit
+		expectErr bool
+	}{
+		{"200/24h", budgetLimit{Max: 200, Window: 24 * time.Hour}, false},
+		{" 50 / 30m ", budgetLimit{Max: 50, Window: 30 * time.Minute}, false},
+		{"1000/7d", budgetLimit{Max: 1000, Window: 7 * 24 * time.Hour}, false},
+		{"200", budgetLimit{}, true},
+		{"-1/1h", budgetLimit{}, true},
+		{"10/forever", budgetLimit{}, true},
+		{"10/0s", budgetLimit{}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			limit, err := parseBudgetLimit(tc.value)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", limit)
+				}
+				return
+			}
+			if err != nil || limit != tc.expected {
+				t.Errorf("got %+v (err: %v), want %+v", limit, err, tc.expected)
+			}
+		})
+	}
+	if got := (budgetLimit{Max: 1000, Window: 7 * 24 * time.Hour}).String(); got != "1000/7d" {
+		t.Errorf("unexpected limit string %q", got)
+	}
+}
+
+func TestLoadBudgetConfig(t *testing.T) {
+	t.Setenv("GEMINI_IMAGE_GEN_LIMIT", "200/24h")
+	t.Setenv("GEMINI_MODEL_LIMITS", "gemini-2.5-pro-preview-tts=10/1h, gemini-2.5-flash-image-preview=100/24h")
+	cfg, err := loadBudgetConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ToolLimits["gemini_image_generation"].Max != 200 || cfg.ToolLimits["gemini_image_generation"].Source != "GEMINI_IMAGE_GEN_LIMIT" {
+		t.Errorf("unexpected tool limits: %+v", cfg.ToolLimits)
+	}
+	if cfg.ModelLimits["gemini-2.5-pro-preview-tts"].Window != time.Hour || cfg.ModelLimits["gemini-2.5-flash-image-preview"].Max != 100 {
+		t.Errorf("unexpected model limits: %+v", cfg.ModelLimits)
+	}
+
+	t.Setenv("GEMINI_MODEL_LIMITS", "gemini-2.5-flash")
+	if _, err := loadBudgetConfig(); err == nil {
+		t.Error("expected an error for a model limit without a value")
+	}
+}
+
+func TestUsageBudgetWindowRollover(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	cfg := budgetConfig{
+		ToolLimits:  map[string]budgetLimit{"gemini_image_generation": {Max: 2, Window: time.Hour, Source: "GEMINI_IMAGE_GEN_LIMIT"}},
+		ModelLimits: map[string]budgetLimit{},
+	}
+	b, err := newUsageBudget(context.Background(), cfg, nil, clock.Now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := b.Reserve(ctx, "gemini_image_generation", "m"); err != nil {
+		t.Fatalf("first request should be allowed: %v", err)
+	}
+	clock.Advance(20 * time.Minute)
+	if err := b.Reserve(ctx, "gemini_image_generation", "m"); err != nil {
+		t.Fatalf("second request should be allowed: %v", err)
+	}
+	clock.Advance(20 * time.Minute)
+	err = b.Reserve(ctx, "gemini_image_generation", "m")
+	var exceeded *budgetExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected a budget exceeded error, got %v", err)
+	}
+	if want := time.Date(2025, 9, 1, 13, 0, 0, 0, time.UTC); !exceeded.ResetAt.Equal(want) {
+		t.Errorf("expected reset at %v, got %v", want, exceeded.ResetAt)
+	}
+	for _, want := range []string{"budget exceeded for tool gemini_image_generation: 2 of 2 requests used in the last 1h", "Limit: 2/1h (GEMINI_IMAGE_GEN_LIMIT)", "Resets at: 2025-09-01T13:00:00Z (in 20m0s)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%s", want, err)
+		}
+	}
+
+	// other tools are not affected
+	if err := b.Reserve(ctx, "gemini_audio_tts", "m"); err != nil {
+		t.Errorf("unlimited tool should be allowed: %v", err)
+	}
+
+	// the first request ages out of the window, freeing one slot
+	clock.Advance(20*time.Minute + time.Second)
+	if err := b.Reserve(ctx, "gemini_image_generation", "m"); err != nil {
+		t.Fatalf("request after rollover should be allowed: %v", err)
+	}
+	if err := b.Reserve(ctx, "gemini_image_generation", "m"); err == nil {
+		t.Fatal("expected the window to be full again")
+	}
+}
+
+func TestUsageBudgetModelLimit(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	cfg := budgetConfig{ModelLimits: map[string]budgetLimit{"gemini-2.5-pro-preview-tts": {Max: 1, Window: time.Hour}}}
+	b, _ := newUsageBudget(context.Background(), cfg, nil, clock.Now)
+
+	if err := b.Reserve(context.Background(), "gemini_audio_tts", "gemini-2.5-pro-preview-tts"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Reserve(context.Background(), "gemini_audio_tts", "gemini-2.5-flash-preview-tts"); err != nil {
+		t.Errorf("other models should not be limited: %v", err)
+	}
+	err := b.Reserve(context.Background(), "gemini_audio_tts", "gemini-2.5-pro-preview-tts")
+	if err == nil || !strings.Contains(err.Error(), "model gemini-2.5-pro-preview-tts") {
+		t.Errorf("expected the model limit to apply, got %v", err)
+	}
+}
+
+func TestUsageBudgetPersistenceRoundTrip(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	cfg := budgetConfig{ToolLimits: map[string]budgetLimit{"gemini_image_generation": {Max: 3, Window: 24 * time.Hour}}}
+	store := &fakeBudgetStore{}
+	ctx := context.Background()
+
+	first, err := newUsageBudget(ctx, cfg, store, clock.Now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := first.Reserve(ctx, "gemini_image_generation", "gemini-2.5-flash-image-preview"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		first.waitForSaves()
+	}
+	first.RecordTokens(ctx, "gemini_image_generation", "gemini-2.5-flash-image-preview", &genai.GenerateContentResponseUsageMetadata{
+		PromptTokenCount: 10, CandidatesTokenCount: 1290, ThoughtsTokenCount: 5, TotalTokenCount: 1305,
+	})
+	first.waitForSaves()
+	if store.saves != 3 {
+		t.Errorf("expected the state to be saved after every change, got %d saves", store.saves)
+	}
+
+	// a restart restores the window and the token totals
+	clock.Advance(time.Hour)
+	restarted, err := newUsageBudget(ctx, cfg, store, clock.Now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := restarted.Report()
+	if len(report.Tools) != 1 || report.Tools[0].Requests != 2 || *report.Tools[0].Remaining != 1 {
+		t.Fatalf("expected the restored window to hold 2 requests, got %+v", report.Tools)
+	}
+	if report.Tools[0].ResetsAt != "2025-09-02T12:00:00Z" {
+		t.Errorf("unexpected reset time %q", report.Tools[0].ResetsAt)
+	}
+	if len(report.Models) != 1 || report.Models[0].Tokens.TotalTokens != 1305 || report.Models[0].Tokens.OutputTokens != 1290 {
+		t.Errorf("expected restored token totals, got %+v", report.Models)
+	}
+	if err := restarted.Reserve(ctx, "gemini_image_generation", "gemini-2.5-flash-image-preview"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := restarted.Reserve(ctx, "gemini_image_generation", "gemini-2.5-flash-image-preview"); err == nil {
+		t.Error("expected the restored budget to be enforced")
+	}
+}
+
+func TestWithBudgetRejectsBeforeCallingHandler(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	cfg := budgetConfig{ModelLimits: map[string]budgetLimit{"gemini-2.5-flash-image-preview": {Max: 0, Window: time.Hour, Source: "GEMINI_MODEL_LIMITS"}}}
+	b, _ := newUsageBudget(context.Background(), cfg, nil, clock.Now)
+
+	called := false
+	handler := withBudget(b, "gemini_image_generation", "model", "gemini-2.5-flash-image-preview", validateGenerateContentArgs, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		called = true
+		return mcp.NewToolResultText("ok"), nil
+	})
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"prompt": "a lighthouse"}
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if called || !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "budget exceeded for model gemini-2.5-flash-image-preview") {
+		t.Errorf("expected the default model's budget to reject the call, got called=%t result=%+v", called, result)
+	}
+}
+
+func TestWithBudgetValidatesBeforeReserving(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	cfg := budgetConfig{ToolLimits: map[string]budgetLimit{"gemini_image_generation": {Max: 1, Window: time.Hour}}}
+	b, _ := newUsageBudget(context.Background(), cfg, nil, clock.Now)
+
+	handler := withBudget(b, "gemini_image_generation", "model", "gemini-2.5-flash-image-preview", validateGenerateContentArgs, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("ok"), nil
+	})
+	call := func(args map[string]interface{}) *mcp.CallToolResult {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+
+	// invalid calls are rejected without using up the single request
+	for _, args := range []map[string]interface{}{{}, {"prompt": "  "}, {"prompt": "a lighthouse", "thinking_budget": -1}} {
+		if result := call(args); !result.IsError || strings.Contains(result.Content[0].(mcp.TextContent).Text, "budget exceeded") {
+			t.Errorf("expected a validation error for %v, got %+v", args, result)
+		}
+	}
+	if result := call(map[string]interface{}{"prompt": "a lighthouse"}); result.IsError {
+		t.Errorf("expected the valid call to be allowed, got %+v", result)
+	}
+}
+
+func TestUsageBudgetCanonicalModelNames(t *testing.T) {
+	t.Setenv(modelLimitsEnvVar, "Gemini-2.5-Pro-Preview-TTS=1/1h")
+	cfg, err := loadBudgetConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock := &fakeClock{t: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	b, _ := newUsageBudget(context.Background(), cfg, nil, clock.Now)
+
+	if err := b.Reserve(context.Background(), "gemini_audio_tts", "gemini-2.5-pro-preview-tts"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, model := range []string{" gemini-2.5-pro-preview-tts ", "models/gemini-2.5-pro-preview-tts", "publishers/google/models/GEMINI-2.5-PRO-PREVIEW-TTS"} {
+		if err := b.Reserve(context.Background(), "gemini_audio_tts", model); err == nil {
+			t.Errorf("expected %q to share the limit of gemini-2.5-pro-preview-tts", model)
+		}
+	}
+	if report := b.Report(); len(report.Models) != 1 || report.Models[0].Name != "gemini-2.5-pro-preview-tts" {
+		t.Errorf("expected usage under the canonical name, got %+v", report.Models)
+	}
+}
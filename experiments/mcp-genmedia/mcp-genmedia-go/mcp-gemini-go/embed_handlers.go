@@ -0,0 +1,252 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+const (
+	defaultEmbeddingModel = "text-embedding-004"
+
+	// maxEmbedTexts bounds one gemini_embed_text call, whatever the number of API calls it takes.
+	maxEmbedTexts = 2000
+	// maxInlineEmbeddingBytes is the largest embedding JSON returned in the tool result when
+	// output_gcs_uri is set; larger results are written to GCS instead.
+	maxInlineEmbeddingBytes = 512 * 1024
+)
+
+// embeddingTaskTypes are the task types accepted by the embedding models.
+var embeddingTaskTypes = []string{
+	"RETRIEVAL_QUERY",
+	"RETRIEVAL_DOCUMENT",
+	"SEMANTIC_SIMILARITY",
+	"CLASSIFICATION",
+	"CLUSTERING",
+	"QUESTION_ANSWERING",
+	"FACT_VERIFICATION",
+	"CODE_RETRIEVAL_QUERY",
+}
+
+// embedBatchSize is the number of texts sent in one EmbedContent call. The Gemini API's
+// batchEmbedContents takes up to 100 requests; Vertex AI's text embedding models take up to
+// 250 instances per predict call, except gemini-embedding models, which take one.
+func embedBatchSize(b backendSelection, model string) int {
+	switch {
+	case b.geminiAPI():
+		return 100
+	case strings.Contains(model, "gemini-embedding"):
+		return 1
+	default:
+		return 250
+	}
+}
+
+// gcsEmbeddingUploader writes JSONL embeddings. It is a variable so tests can avoid calling GCS.
+var gcsEmbeddingUploader = common.UploadToGCS
+
+// embeddingResult is the JSON returned by gemini_embed_text. Embeddings holds the vectors in
+// the order of the input texts, unless they were written to GCSURI.
+type embeddingResult struct {
+	Model      string      `json:"model"`
+	Dimensions int         `json:"dimensions"`
+	Count      int         `json:"count"`
+	APICalls   int         `json:"api_calls"`
+	GCSURI     string      `json:"gcs_uri,omitempty"`
+	Embeddings [][]float32 `json:"embeddings,omitempty"`
+}
+
+// embeddingRecord is one line of the JSONL written to output_gcs_uri.
+type embeddingRecord struct {
+	Index     int       `json:"index"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// parseEmbedTexts reads the 'texts' argument, rejecting anything but a non-empty list of
+// non-blank strings.
+func parseEmbedTexts(args map[string]interface{}) ([]string, error) {
+	raw, ok := args["texts"].([]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, fmt.Errorf("texts must be a non-empty list of strings")
+	}
+	if len(raw) > maxEmbedTexts {
+		return nil, fmt.Errorf("texts has %d items; at most %d can be embedded in one call", len(raw), maxEmbedTexts)
+	}
+	texts := make([]string, len(raw))
+	for i, item := range raw {
+		text, ok := item.(string)
+		if !ok || strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("texts[%d] must be a non-empty string", i)
+		}
+		texts[i] = text
+	}
+	return texts, nil
+}
+
+// parseEmbedOutputURI reads the optional 'output_gcs_uri' argument and splits it into its
+// bucket and object; all three are empty when it is not given.
+func parseEmbedOutputURI(args map[string]interface{}) (uri, bucket, object string, err error) {
+	uri, _ = args["output_gcs_uri"].(string)
+	uri = strings.TrimSpace(uri)
+	if uri == "" {
+		return "", "", "", nil
+	}
+	if activeBackend.geminiAPI() {
+		return "", "", "", fmt.Errorf("output_gcs_uri is not available on the Gemini API backend, which has no Google Cloud project to write to; embed fewer texts per call instead, or start the server with PROJECT_ID set to use Vertex AI")
+	}
+	uri = common.EnsureGCSPathPrefix(uri)
+	if bucket, object, err = common.ParseGCSPath(uri); err != nil || strings.HasSuffix(object, "/") {
+		return "", "", "", fmt.Errorf("output_gcs_uri must name an object, e.g. gs://my-bucket/embeddings/captions.jsonl, got %q", uri)
+	}
+	return uri, bucket, object, nil
+}
+
+// validateEmbedArgs checks the gemini_embed_text arguments that can be rejected before the
+// request is counted against a budget or sent.
+func validateEmbedArgs(args map[string]interface{}) error {
+	if _, err := parseEmbedTexts(args); err != nil {
+		return err
+	}
+	_, _, _, err := parseEmbedOutputURI(args)
+	return err
+}
+
+// embedTexts embeds texts in batches of batchSize, one EmbedContent call per batch, and
+// returns the vectors in input order along with the number of calls made.
+func embedTexts(ctx context.Context, client *genai.Client, model string, texts []string, config *genai.EmbedContentConfig, batchSize int) ([][]float32, int, error) {
+	vectors := make([][]float32, 0, len(texts))
+	calls := 0
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+		contents := make([]*genai.Content, 0, end-start)
+		for _, text := range texts[start:end] {
+			contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+		}
+		calls++
+		resp, err := client.Models.EmbedContent(ctx, model, contents, config)
+		if err != nil {
+			return nil, calls, fmt.Errorf("texts %d-%d: %w", start, end-1, err)
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, calls, fmt.Errorf("texts %d-%d: expected %d embeddings, got %d", start, end-1, end-start, len(resp.Embeddings))
+		}
+		for _, embedding := range resp.Embeddings {
+			vectors = append(vectors, embedding.Values)
+		}
+	}
+	return vectors, calls, nil
+}
+
+// geminiEmbedTextHandler handles the 'gemini_embed_text' tool request.
+// It embeds a list of texts, split into as many API calls as the backend's batch limit needs,
+// and returns the vectors as JSON, or writes them to output_gcs_uri as JSONL when they are
+// too large to return inline.
+func geminiEmbedTextHandler(client *genai.Client, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "gemini_embed_text")
+	defer span.End()
+
+	args := request.GetArguments()
+	texts, err := parseEmbedTexts(args)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	model, _ := args["model"].(string)
+	model = strings.TrimSpace(model)
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	config := &genai.EmbedContentConfig{}
+	if taskType, _ := args["task_type"].(string); strings.TrimSpace(taskType) != "" {
+		config.TaskType = strings.ToUpper(strings.TrimSpace(taskType))
+	}
+	outputGCSURI, bucket, object, err := parseEmbedOutputURI(args)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	batchSize := embedBatchSize(activeBackend, model)
+	span.SetAttributes(
+		attribute.String("model", model),
+		attribute.String("task_type", config.TaskType),
+		attribute.Int("text_count", len(texts)),
+		attribute.Int("batch_size", batchSize),
+	)
+	log.Printf("Handling gemini_embed_text request: %d texts, model %s, task type %q, batches of %d", len(texts), model, config.TaskType, batchSize)
+
+	vectors, calls, err := embedTexts(ctx, client, model, texts, config, batchSize)
+	span.SetAttributes(attribute.Int("api_calls", calls))
+	if err != nil {
+		span.RecordError(err)
+		return mcp.NewToolResultError(formatGeminiError("error embedding texts", err)), nil
+	}
+	dimensions := len(vectors[0])
+	for i, v := range vectors {
+		if len(v) != dimensions {
+			return mcp.NewToolResultError(fmt.Sprintf("embedding %d has %d dimensions, but embedding 0 has %d", i, len(v), dimensions)), nil
+		}
+	}
+	span.SetAttributes(attribute.Int("dimensions", dimensions))
+
+	result := embeddingResult{Model: model, Dimensions: dimensions, Count: len(vectors), APICalls: calls, Embeddings: vectors}
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal embeddings: %v", err)), nil
+	}
+	summary := fmt.Sprintf("Embedded %d texts with %s in %d API call(s): %d dimensions each.", len(vectors), model, calls, dimensions)
+
+	if len(resultJSON) > maxInlineEmbeddingBytes {
+		if outputGCSURI == "" {
+			summary += fmt.Sprintf(" The embeddings are %d bytes of JSON; set output_gcs_uri to write results over %d bytes to GCS as JSONL instead.", len(resultJSON), maxInlineEmbeddingBytes)
+		} else {
+			var jsonl bytes.Buffer
+			encoder := json.NewEncoder(&jsonl)
+			for i, v := range vectors {
+				if err := encoder.Encode(embeddingRecord{Index: i, Text: texts[i], Embedding: v}); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to marshal embedding %d: %v", i, err)), nil
+				}
+			}
+			if err := gcsEmbeddingUploader(ctx, bucket, object, "application/x-ndjson", jsonl.Bytes()); err != nil {
+				span.RecordError(err)
+				return mcp.NewToolResultError(fmt.Sprintf("failed to write embeddings to %s: %v", outputGCSURI, err)), nil
+			}
+			span.SetAttributes(attribute.String("gcs_uri", outputGCSURI))
+			result.GCSURI, result.Embeddings = outputGCSURI, nil
+			if resultJSON, err = json.Marshal(result); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to marshal result: %v", err)), nil
+			}
+			summary += fmt.Sprintf(" The embeddings were too large to return inline and were written to %s as JSONL, one {index, text, embedding} object per line.", outputGCSURI)
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{Type: "text", Text: summary},
+			mcp.TextContent{Type: "text", Text: string(resultJSON)},
+		},
+	}, nil
+}
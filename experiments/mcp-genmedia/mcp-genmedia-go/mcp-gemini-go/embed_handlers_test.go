@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// newStubEmbeddingClient returns a Vertex client whose predict calls are answered by a local
+// server with one vector of the given dimensions per instance: the instance's text length,
+// then 0.5s. The number of instances in each call is appended to the returned slice.
+func newStubEmbeddingClient(t *testing.T, dimensions int) (*genai.Client, *[]int) {
+	t.Helper()
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":predict") {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Instances []struct {
+				Content  string `json:"content"`
+				TaskType string `json:"task_type"`
+			} `json:"instances"`
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batches = append(batches, len(body.Instances))
+		var predictions []string
+		for _, instance := range body.Instances {
+			if instance.TaskType != "SEMANTIC_SIMILARITY" {
+				http.Error(w, "unexpected task type "+instance.TaskType, http.StatusBadRequest)
+				return
+			}
+			values := strings.Repeat(", 0.5", dimensions-1)
+			predictions = append(predictions, fmt.Sprintf(`{"embeddings": {"values": [%d%s]}}`, len(instance.Content), values))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"predictions": [%s]}`, strings.Join(predictions, ","))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		Backend:     genai.BackendVertexAI,
+		Project:     "test-project",
+		Location:    "us-central1",
+		HTTPClient:  &http.Client{},
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("creating stub client: %v", err)
+	}
+	return client, &batches
+}
+
+// embedTextsArgs returns n texts of increasing length, as tool arguments.
+func embedTextsArgs(n int) []interface{} {
+	texts := make([]interface{}, n)
+	for i := range texts {
+		texts[i] = strings.Repeat("a", i+1)
+	}
+	return texts
+}
+
+func TestEmbedBatchSize(t *testing.T) {
+	vertex := backendSelection{Backend: genai.BackendVertexAI}
+	gemini := backendSelection{Backend: genai.BackendGeminiAPI}
+	if got := embedBatchSize(vertex, "text-embedding-004"); got != 250 {
+		t.Errorf("Vertex text embedding batch = %d, want 250", got)
+	}
+	if got := embedBatchSize(vertex, "gemini-embedding-001"); got != 1 {
+		t.Errorf("Vertex gemini-embedding batch = %d, want 1", got)
+	}
+	if got := embedBatchSize(gemini, "gemini-embedding-001"); got != 100 {
+		t.Errorf("Gemini API batch = %d, want 100", got)
+	}
+}
+
+func TestGeminiEmbedTextBatching(t *testing.T) {
+	for _, tc := range []struct {
+		texts   int
+		batches []int
+	}{
+		{1, []int{1}},
+		{250, []int{250}},
+		{251, []int{250, 1}},
+		{600, []int{250, 250, 100}},
+	} {
+		t.Run(fmt.Sprint(tc.texts), func(t *testing.T) {
+			client, batches := newStubEmbeddingClient(t, 3)
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = map[string]interface{}{"texts": embedTextsArgs(tc.texts), "task_type": "SEMANTIC_SIMILARITY"}
+			result, err := geminiEmbedTextHandler(client, context.Background(), request)
+			if err != nil || result.IsError {
+				t.Fatalf("unexpected error: %v %+v", err, result)
+			}
+			if fmt.Sprint(*batches) != fmt.Sprint(tc.batches) {
+				t.Errorf("expected batches %v, got %v", tc.batches, *batches)
+			}
+
+			var embeddings embeddingResult
+			if err := json.Unmarshal([]byte(result.Content[1].(mcp.TextContent).Text), &embeddings); err != nil {
+				t.Fatalf("result is not embedding JSON: %v", err)
+			}
+			if embeddings.Dimensions != 3 || embeddings.Count != tc.texts || embeddings.APICalls != len(tc.batches) || embeddings.GCSURI != "" {
+				t.Errorf("unexpected result %+v", embeddings)
+			}
+			for i, v := range embeddings.Embeddings {
+				if int(v[0]) != i+1 {
+					t.Fatalf("embedding %d is out of order: %v", i, v)
+				}
+			}
+		})
+	}
+}
+
+func TestGeminiEmbedTextGCSOutput(t *testing.T) {
+	var uploadedURI string
+	var uploaded []byte
+	originalUploader := gcsEmbeddingUploader
+	gcsEmbeddingUploader = func(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
+		uploadedURI, uploaded = "gs://"+bucketName+"/"+objectName, data
+		return nil
+	}
+	defer func() { gcsEmbeddingUploader = originalUploader }()
+
+	// small results stay inline even with output_gcs_uri
+	client, _ := newStubEmbeddingClient(t, 3)
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"texts": embedTextsArgs(2), "task_type": "SEMANTIC_SIMILARITY", "output_gcs_uri": "my-bucket/embeddings/captions.jsonl"}
+	result, err := geminiEmbedTextHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	if uploaded != nil || !strings.Contains(result.Content[1].(mcp.TextContent).Text, `"embeddings":[[1,0.5,0.5],[2,0.5,0.5]]`) {
+		t.Errorf("expected a small result inline, got %s", result.Content[1].(mcp.TextContent).Text)
+	}
+
+	// 300 768-dimensional vectors are over 512 KiB of JSON, so they are written as JSONL
+	client, _ = newStubEmbeddingClient(t, 768)
+	texts := embedTextsArgs(300)
+	request.Params.Arguments = map[string]interface{}{"texts": texts, "task_type": "SEMANTIC_SIMILARITY", "output_gcs_uri": "my-bucket/embeddings/captions.jsonl"}
+	result, err = geminiEmbedTextHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	if uploadedURI != "gs://my-bucket/embeddings/captions.jsonl" {
+		t.Fatalf("expected the JSONL at the output URI, got %q", uploadedURI)
+	}
+	var embeddings embeddingResult
+	if err := json.Unmarshal([]byte(result.Content[1].(mcp.TextContent).Text), &embeddings); err != nil {
+		t.Fatalf("result is not embedding JSON: %v", err)
+	}
+	if embeddings.GCSURI != uploadedURI || embeddings.Embeddings != nil || embeddings.Count != 300 || embeddings.Dimensions != 768 || embeddings.APICalls != 2 {
+		t.Errorf("expected only a summary inline, got %+v", embeddings)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(bytes.NewReader(uploaded))
+	for ; scanner.Scan(); lines++ {
+		var record embeddingRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %d is not JSON: %v", lines, err)
+		}
+		if record.Index != lines || record.Text != texts[lines] || len(record.Embedding) != 768 {
+			t.Fatalf("unexpected record on line %d: index %d, %d dimensions", lines, record.Index, len(record.Embedding))
+		}
+	}
+	if lines != 300 {
+		t.Errorf("expected 300 JSONL lines, got %d", lines)
+	}
+}
+
+func TestGeminiEmbedTextValidation(t *testing.T) {
+	client, batches := newStubEmbeddingClient(t, 3)
+	originalBackend := activeBackend
+	defer func() { activeBackend = originalBackend }()
+
+	for _, tc := range []struct {
+		name    string
+		args    map[string]interface{}
+		backend genai.Backend
+		want    string
+	}{
+		{"no texts", map[string]interface{}{"texts": []interface{}{}}, genai.BackendVertexAI, "texts must be a non-empty list"},
+		{"blank text", map[string]interface{}{"texts": []interface{}{"a", " "}}, genai.BackendVertexAI, "texts[1] must be a non-empty string"},
+		{"too many", map[string]interface{}{"texts": embedTextsArgs(maxEmbedTexts + 1)}, genai.BackendVertexAI, "at most 2000"},
+		{"bucket only", map[string]interface{}{"texts": []interface{}{"a"}, "output_gcs_uri": "gs://my-bucket/"}, genai.BackendVertexAI, "output_gcs_uri must name an object"},
+		{"gemini api gcs", map[string]interface{}{"texts": []interface{}{"a"}, "output_gcs_uri": "gs://my-bucket/e.jsonl"}, genai.BackendGeminiAPI, "not available on the Gemini API backend"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			activeBackend = backendSelection{Backend: tc.backend}
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tc.args
+			result, err := geminiEmbedTextHandler(client, context.Background(), request)
+			if err != nil || !result.IsError {
+				t.Fatalf("expected an error result, got %v %+v", err, result)
+			}
+			if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, tc.want) {
+				t.Errorf("expected %q in %q", tc.want, text)
+			}
+		})
+	}
+	if len(*batches) != 0 {
+		t.Errorf("expected no API calls, got %v", *batches)
+	}
+}
@@ -17,61 +17,92 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
 	"github.com/mark3labs/mcp-go/mcp"
 	"google.golang.org/genai"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+// maxInlineImageBytes is the largest generated image returned in the tool result as image
+// content. Images are only inlined when no output location is set; saved images are referenced
+// by their path or URI instead.
+const maxInlineImageBytes = 4 * 1024 * 1024
+
 func geminiGenerateContentHandler(client *genai.Client, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	tr := otel.Tracer(serviceName)
 	ctx, span := tr.Start(ctx, "gemini_generate_content")
 	defer span.End()
 
 	// --- Parameter Parsing ---
-	prompt, ok := request.GetArguments()["prompt"].(string)
-	if !ok || strings.TrimSpace(prompt) == "" {
-		return mcp.NewToolResultError("prompt must be a non-empty string and is required"), nil
+	if err := validateGenerateContentArgs(request.GetArguments()); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
 	}
+	prompt, _ := request.GetArguments()["prompt"].(string)
 
 	model, _ := request.GetArguments()["model"].(string)
 
+	thinkingBudget, err := parseThinkingBudget(request.GetArguments())
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	tokenModel := model
+	if tokenModel == "" {
+		tokenModel = defaultGeminiImageModel
+	}
+	pathTokens := newOutputPathTokens(request.GetArguments(), "gemini_image_generation", tokenModel, time.Now())
 	outputDir := ""
 	if dir, ok := request.GetArguments()["output_directory"].(string); ok && strings.TrimSpace(dir) != "" {
-		outputDir = strings.TrimSpace(dir)
+		outputDir = pathTokens.expand(strings.TrimSpace(dir))
+	}
+	var gcsBucket, gcsPrefix string
+	if uri, ok := request.GetArguments()["gcs_bucket_uri"].(string); ok && strings.TrimSpace(uri) != "" {
+		gcsBucket, gcsPrefix, err = splitGCSPrefix(pathTokens.expand(uri))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 	}
 
 	// --- Construct Gemini Request ---
 	var parts []*genai.Part
 	parts = append(parts, genai.NewPartFromText(prompt))
 
+	var inputPaths []string
 	if imageArgs, ok := request.GetArguments()["images"].([]interface{}); ok {
 		for _, imgArg := range imageArgs {
 			if imgPath, ok := imgArg.(string); ok {
-				if strings.HasPrefix(imgPath, "gs://") {
-					parts = append(parts, genai.NewPartFromURI(imgPath, ""))
-				} else {
-					imgData, err := os.ReadFile(imgPath)
-					if err != nil {
-						return mcp.NewToolResultError(fmt.Sprintf("failed to read image file %s: %v", imgPath, err)), nil
-					}
-					parts = append(parts, genai.NewPartFromBytes(imgData, inferMimeType(imgPath)))
-				}
+				inputPaths = append(inputPaths, imgPath)
 			}
 		}
 	}
+	gcsBucketArg, _ := request.GetArguments()["gcs_bucket_uri"].(string)
+	if err := checkGenerateRequestForBackend(activeBackend, strings.TrimSpace(gcsBucketArg), inputPaths); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	inputParts, err := buildInputParts(ctx, inputPaths, inputPolicy)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	parts = append(parts, inputParts...)
 
 	span.SetAttributes(
 		attribute.String("prompt", prompt),
 		attribute.String("model", model),
 		attribute.String("output_directory", outputDir),
+		attribute.String("gcs_bucket_uri", gcsURIPrefix(gcsBucket, gcsPrefix)),
+		attribute.Int("num_inputs", len(inputPaths)),
+		attribute.String("thinking_budget", describeThinkingBudget(thinkingBudget)),
 	)
 
 	// --- API Call ---
@@ -80,6 +111,9 @@ func geminiGenerateContentHandler(client *genai.Client, ctx context.Context, req
 
 	config := &genai.GenerateContentConfig{}
 	config.ResponseModalities = []string{"IMAGE", "TEXT"}
+	if thinkingBudget != nil {
+		config.ThinkingConfig = &genai.ThinkingConfig{ThinkingBudget: thinkingBudget}
+	}
 	contents := &genai.Content{Parts: parts, Role: "USER"}
 
 	resp, err := client.Models.GenerateContent(ctx, model, []*genai.Content{contents}, config)
@@ -89,48 +123,204 @@ func geminiGenerateContentHandler(client *genai.Client, ctx context.Context, req
 	span.SetAttributes(attribute.Float64("duration_ms", float64(apiCallDuration.Milliseconds())))
 
 	if err != nil {
-		span.RecordError(err)
-		return mcp.NewToolResultError(fmt.Sprintf("error calling Gemini API: %v", err)), nil
+		common.SetGenerationSpanAttributes(span, common.GenerationSpanInfo{RequestedModel: model})
+		common.RecordSpanFailure(span, err, geminiErrorType(err))
+		return mcp.NewToolResultError(formatGeminiError("error calling Gemini API", err)), nil
+	}
+	common.SetGenerationSpanAttributes(span, responseSpanInfo(model, resp))
+	usage.RecordTokens(ctx, "gemini_image_generation", model, resp.UsageMetadata)
+	noteTokenUsage(ctx, resp.UsageMetadata)
+	if blocked := describePromptBlock(resp); blocked != "" {
+		common.RecordSpanFailure(span, errors.New(blocked), errorTypeSafety)
+		return mcp.NewToolResultError(blocked), nil
 	}
 
 	// --- Process Response ---
+	// When the response has images, the parts are mirrored in order after the summary, so that
+	// each image stays next to the text that explains it. A text-only response is just the
+	// summary, which already holds all of its text.
 	var responseText strings.Builder
-	var savedFiles []string
+	var savedFiles, uploadedFiles []string
+	var partItems []mcp.Content
+	var outputBytes int64
+	inlineImages := false
+	imageParts := 0
 	gentime := time.Now().Format("20060102150405")
 
+	partIndex := -1
 	for _, candidate := range resp.Candidates {
-		for n, part := range candidate.Content.Parts {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			partIndex++
 			if part.Text != "" {
 				responseText.WriteString(part.Text)
+				partItems = append(partItems, mcp.TextContent{Type: "text", Text: part.Text})
 			}
-			if part.InlineData != nil {
-				log.Printf("part %d mime-type: %s", n, part.InlineData.MIMEType)
-
-				if outputDir != "" {
-					if err := os.MkdirAll(outputDir, 0755); err != nil {
-						return mcp.NewToolResultError(fmt.Sprintf("failed to create output directory: %v", err)), nil
-					}
-					fileName := fmt.Sprintf("gemini_%s_%d.png", gentime, n)
-					filePath := filepath.Join(outputDir, fileName)
-					if err := os.WriteFile(filePath, part.InlineData.Data, 0644); err != nil {
-						return mcp.NewToolResultError(fmt.Sprintf("failed to write image file: %v", err)), nil
-					}
-					savedFiles = append(savedFiles, filePath)
-				} else {
-					// If no output dir, should we return base64? For now, we just log.
-					log.Println("Received image data but no output_directory was specified. Image not saved.")
+			if part.InlineData == nil {
+				continue
+			}
+			imageParts++
+			log.Printf("part %d mime-type: %s", partIndex, part.InlineData.MIMEType)
+			outputBytes += int64(len(part.InlineData.Data))
+
+			fileName := fmt.Sprintf("gemini_%s_part%d%s", gentime, partIndex, imageExtension(part.InlineData.MIMEType))
+			var locations []string
+			if outputDir != "" {
+				if err := os.MkdirAll(outputDir, 0755); err != nil {
+					common.RecordSpanFailure(span, err, "output_write")
+					return mcp.NewToolResultError(fmt.Sprintf("failed to create output directory: %v", err)), nil
 				}
+				filePath := filepath.Join(outputDir, fileName)
+				if err := os.WriteFile(filePath, part.InlineData.Data, 0644); err != nil {
+					common.RecordSpanFailure(span, err, "output_write")
+					return mcp.NewToolResultError(fmt.Sprintf("failed to write image file: %v", err)), nil
+				}
+				savedFiles = append(savedFiles, filePath)
+				locations = append(locations, filePath)
+			}
+			if gcsBucket != "" {
+				objectName := path.Join(gcsPrefix, fileName)
+				if err := gcsImageUploader(ctx, gcsBucket, objectName, part.InlineData.MIMEType, part.InlineData.Data); err != nil {
+					common.RecordSpanFailure(span, err, "output_upload")
+					return mcp.NewToolResultError(fmt.Sprintf("failed to upload image to gs://%s/%s: %v", gcsBucket, objectName, err)), nil
+				}
+				uploadedFiles = append(uploadedFiles, fmt.Sprintf("gs://%s/%s", gcsBucket, objectName))
+				locations = append(locations, fmt.Sprintf("gs://%s/%s", gcsBucket, objectName))
+			}
+			switch {
+			case len(locations) > 0:
+				partItems = append(partItems, mcp.TextContent{Type: "text", Text: fmt.Sprintf("[Image from part %d (%s): %s]", partIndex, part.InlineData.MIMEType, strings.Join(locations, ", "))})
+			case len(part.InlineData.Data) <= maxInlineImageBytes:
+				partItems = append(partItems, mcp.ImageContent{Type: "image", Data: base64.StdEncoding.EncodeToString(part.InlineData.Data), MIMEType: part.InlineData.MIMEType})
+				inlineImages = true
+			default:
+				log.Printf("Image from part %d is %s, over the inline limit, and no output_directory or gcs_bucket_uri was specified. Image not saved.", partIndex, common.FormatBytes(int64(len(part.InlineData.Data))))
+				partItems = append(partItems, mcp.TextContent{Type: "text", Text: fmt.Sprintf("[Image from part %d (%s, %s) not returned: it is over the %s inline limit. Set output_directory or gcs_bucket_uri to save it.]", partIndex, part.InlineData.MIMEType, common.FormatBytes(int64(len(part.InlineData.Data))), common.FormatBytes(maxInlineImageBytes))})
 			}
 		}
 	}
 
+	outputInfo := common.GenerationSpanInfo{OutputBytes: outputBytes}
+	if responseText.Len() > 0 || inlineImages {
+		outputInfo.OutputDestinations = append(outputInfo.OutputDestinations, common.OutputInline)
+	}
+	if len(savedFiles) > 0 {
+		outputInfo.OutputDestinations = append(outputInfo.OutputDestinations, common.OutputLocal)
+	}
+	if len(uploadedFiles) > 0 {
+		outputInfo.OutputDestinations = append(outputInfo.OutputDestinations, common.OutputGCS)
+	}
+	common.SetGenerationSpanAttributes(span, outputInfo)
+	if imageParts == 0 {
+		partItems = nil
+	}
+
 	// --- Format Final Result ---
+	// The first item is a summary of the whole response, for callers that only read one.
 	finalMessage := responseText.String()
 	if len(savedFiles) > 0 {
-		finalMessage += fmt.Sprintf("\n\nGenerated and saved %d image(s): %s", len(savedFiles), strings.Join(savedFiles, ", "))
+		finalMessage += fmt.Sprintf("\n\nGenerated and saved %d image(s) to %s: %s", len(savedFiles), outputDir, strings.Join(savedFiles, ", "))
+	}
+	if len(uploadedFiles) > 0 {
+		finalMessage += fmt.Sprintf("\n\nUploaded %d image(s) to %s: %s", len(uploadedFiles), gcsURIPrefix(gcsBucket, gcsPrefix), strings.Join(uploadedFiles, ", "))
+	}
+	finalMessage += fmt.Sprintf("\n\nThinking budget: %s", describeThinkingBudget(thinkingBudget))
+	if len(partItems) > 0 {
+		finalMessage += fmt.Sprintf("\n\nThe response's %d part(s) follow in order.", len(partItems))
 	}
 
-	return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: strings.TrimSpace(finalMessage)}}}, nil
+	content := []mcp.Content{mcp.TextContent{Type: "text", Text: strings.TrimSpace(finalMessage)}}
+	return &mcp.CallToolResult{Content: append(content, partItems...)}, nil
+}
+
+// imageExtension returns the file extension generated images of mimeType are saved with.
+func imageExtension(mimeType string) string {
+	switch strings.ToLower(mimeType) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+// responseSpanInfo describes a GenerateContent response for the span: the model version that
+// served it, its token usage and each candidate's finish reason.
+func responseSpanInfo(requestedModel string, resp *genai.GenerateContentResponse) common.GenerationSpanInfo {
+	info := common.GenerationSpanInfo{RequestedModel: requestedModel, ModelVersion: resp.ModelVersion}
+	if resp.UsageMetadata != nil {
+		info.HasUsage = true
+		info.PromptTokens = int64(resp.UsageMetadata.PromptTokenCount)
+		info.CandidateTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+		info.TotalTokens = int64(resp.UsageMetadata.TotalTokenCount)
+	}
+	for _, candidate := range resp.Candidates {
+		reason := string(candidate.FinishReason)
+		if reason == "" {
+			reason = string(genai.FinishReasonUnspecified)
+		}
+		info.FinishReasons = append(info.FinishReasons, reason)
+	}
+	return info
+}
+
+// validateGenerateContentArgs checks the gemini_image_generation arguments that can be
+// rejected before the request is counted against a budget or sent.
+func validateGenerateContentArgs(args map[string]interface{}) error {
+	if prompt, ok := args["prompt"].(string); !ok || strings.TrimSpace(prompt) == "" {
+		return errors.New("prompt must be a non-empty string and is required")
+	}
+	_, err := parseThinkingBudget(args)
+	return err
+}
+
+// parseThinkingBudget reads the optional 'thinking_budget' argument. nil means the parameter was
+// not provided and the model's default thinking behavior applies.
+func parseThinkingBudget(args map[string]interface{}) (*int32, error) {
+	raw, ok := args["thinking_budget"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	budget, ok := raw.(float64)
+	if !ok {
+		return nil, fmt.Errorf("thinking_budget must be a number, got %T", raw)
+	}
+	if budget < 0 || budget != float64(int32(budget)) {
+		return nil, fmt.Errorf("thinking_budget must be a non-negative whole number of tokens, got %v", budget)
+	}
+	value := int32(budget)
+	return &value, nil
+}
+
+// describeThinkingBudget formats the effective thinking budget for the tool result.
+func describeThinkingBudget(budget *int32) string {
+	switch {
+	case budget == nil:
+		return "model default"
+	case *budget == 0:
+		return "0 (thinking disabled)"
+	default:
+		return fmt.Sprintf("%d tokens", *budget)
+	}
+}
+
+// gcsImageUploader uploads generated images. It is a variable so tests can avoid calling GCS.
+var gcsImageUploader = common.UploadToGCS
+
+// gcsURIPrefix formats a bucket and object prefix as the gs:// location reported in results.
+func gcsURIPrefix(bucket, prefix string) string {
+	if bucket == "" {
+		return ""
+	}
+	if prefix == "" {
+		return fmt.Sprintf("gs://%s/", bucket)
+	}
+	return fmt.Sprintf("gs://%s/%s/", bucket, prefix)
 }
 
 func inferMimeType(path string) string {
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+func TestParseThinkingBudget(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      map[string]interface{}
+		expected  string
+		expectErr bool
+	}{
+		{"omitted", map[string]interface{}{}, "model default", false},
+		{"disabled", map[string]interface{}{"thinking_budget": 0.0}, "0 (thinking disabled)", false},
+		{"capped", map[string]interface{}{"thinking_budget": 1024.0}, "1024 tokens", false},
+		{"negative", map[string]interface{}{"thinking_budget": -1.0}, "", true},
+		{"fractional", map[string]interface{}{"thinking_budget": 10.5}, "", true},
+		{"wrong type", map[string]interface{}{"thinking_budget": "lots"}, "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			budget, err := parseThinkingBudget(tc.args)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got budget %v", budget)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := describeThinkingBudget(budget); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGenerateContentHandlerThinkingBudget(t *testing.T) {
+	var requestBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requestBody = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "done"}]}, "finishReason": "STOP"}]}`)
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"prompt": "describe a lighthouse", "model": "gemini-2.5-flash", "thinking_budget": 0.0}
+	result, err := geminiGenerateContentHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	if !strings.Contains(requestBody, `"thinkingConfig":{"thinkingBudget":0}`) {
+		t.Errorf("expected the thinking budget in the request, got %s", requestBody)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Thinking budget: 0 (thinking disabled)") {
+		t.Errorf("expected the effective budget to be echoed, got %q", text)
+	}
+}
+
+func TestGenerateContentHandlerTextOnlyResponse(t *testing.T) {
+	client := newStubGeminiClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"text": "A lighthouse stands "},
+			{"text": "on the cliff."}
+		]}, "finishReason": "STOP"}]}`)
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"prompt": "describe a lighthouse", "model": "gemini-2.5-flash"}
+	result, err := geminiGenerateContentHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	if len(result.Content) != 1 {
+		t.Fatalf("expected only the summary for a text-only response, got %d items: %+v", len(result.Content), result.Content)
+	}
+	summary := result.Content[0].(mcp.TextContent).Text
+	if !strings.HasPrefix(summary, "A lighthouse stands on the cliff.") || strings.Contains(summary, "part(s) follow") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestGenerateContentHandlerInterleavedParts(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString([]byte("png-bytes"))
+	jpeg := base64.StdEncoding.EncodeToString([]byte("jpeg-bytes"))
+	client := newStubGeminiClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"text": "First, sketch the outline."},
+			{"inlineData": {"mimeType": "image/png", "data": %q}},
+			{"text": "Then add color."},
+			{"inlineData": {"mimeType": "image/jpeg", "data": %q}}
+		]}, "finishReason": "STOP"}]}`, png, jpeg)
+	})
+
+	t.Run("saved", func(t *testing.T) {
+		outputDir := t.TempDir()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]interface{}{"prompt": "draw a lighthouse step by step", "model": "gemini-2.5-flash-image-preview", "output_directory": outputDir}
+		result, err := geminiGenerateContentHandler(client, context.Background(), request)
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error: %v %+v", err, result)
+		}
+		if len(result.Content) != 5 {
+			t.Fatalf("expected a summary and 4 part items, got %d: %+v", len(result.Content), result.Content)
+		}
+		summary := result.Content[0].(mcp.TextContent).Text
+		if !strings.HasPrefix(summary, "First, sketch the outline.Then add color.") || !strings.Contains(summary, "Generated and saved 2 image(s)") {
+			t.Errorf("expected the combined summary first, got %q", summary)
+		}
+		if got := result.Content[1].(mcp.TextContent).Text; got != "First, sketch the outline." {
+			t.Errorf("item 1: got %q", got)
+		}
+		if got := result.Content[3].(mcp.TextContent).Text; got != "Then add color." {
+			t.Errorf("item 3: got %q", got)
+		}
+		for item, suffix := range map[int]string{2: "_part1.png", 4: "_part3.jpg"} {
+			text := result.Content[item].(mcp.TextContent).Text
+			files, _ := filepath.Glob(filepath.Join(outputDir, "gemini_*"+suffix))
+			if len(files) != 1 || !strings.Contains(text, files[0]) {
+				t.Errorf("item %d: expected a reference to the saved %s image, got %q (files %v)", item, suffix, text, files)
+			}
+		}
+	})
+
+	t.Run("inline", func(t *testing.T) {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]interface{}{"prompt": "draw a lighthouse step by step", "model": "gemini-2.5-flash-image-preview"}
+		result, err := geminiGenerateContentHandler(client, context.Background(), request)
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error: %v %+v", err, result)
+		}
+		if len(result.Content) != 5 {
+			t.Fatalf("expected a summary and 4 part items, got %d: %+v", len(result.Content), result.Content)
+		}
+		for item, want := range map[int][2]string{2: {"image/png", png}, 4: {"image/jpeg", jpeg}} {
+			image, ok := result.Content[item].(mcp.ImageContent)
+			if !ok || image.MIMEType != want[0] || image.Data != want[1] {
+				t.Errorf("item %d: expected inline %s image content, got %+v", item, want[0], result.Content[item])
+			}
+		}
+	})
+}
@@ -0,0 +1,201 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"google.golang.org/genai"
+)
+
+// fileInputMimeTypes lists the document and video types that are passed to Gemini as
+// FileData parts referencing GCS, rather than being read and sent inline.
+var fileInputMimeTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+}
+
+// supportedInputTypesDescription is used in tool descriptions so clients know what they can pass.
+const supportedInputTypesDescription = "Images (.jpg, .jpeg, .png, .gif, .webp) may be local paths or GCS URIs. PDFs (.pdf) and videos (.mp4, .webm, .mov) must be GCS URIs (gs://...)."
+
+// fileInputPolicy limits how many PDF/video inputs a single request may reference and how
+// large each may be, and controls whether local PDF/video files are staged to GCS.
+type fileInputPolicy struct {
+	MaxFileInputs   int
+	MaxFileBytes    int64
+	StageLocalFiles bool
+	StagingBucket   string
+}
+
+// loadFileInputPolicy reads the input policy from the environment.
+// GEMINI_MAX_FILE_INPUTS (default 5) and GEMINI_MAX_FILE_INPUT_MB (default 500) set the limits;
+// local files are only staged when stageLocalFiles is set and GENMEDIA_BUCKET is configured.
+func loadFileInputPolicy(cfg *common.Config, stageLocalFiles bool) fileInputPolicy {
+	policy := fileInputPolicy{
+		MaxFileInputs:   5,
+		MaxFileBytes:    500 * 1024 * 1024,
+		StageLocalFiles: stageLocalFiles && cfg.GenmediaBucket != "",
+		StagingBucket:   cfg.GenmediaBucket,
+	}
+	if n, err := strconv.Atoi(common.GetEnv("GEMINI_MAX_FILE_INPUTS", "5")); err == nil && n >= 0 {
+		policy.MaxFileInputs = n
+	}
+	if mb, err := strconv.ParseInt(common.GetEnv("GEMINI_MAX_FILE_INPUT_MB", "500"), 10, 64); err == nil && mb > 0 {
+		policy.MaxFileBytes = mb * 1024 * 1024
+	}
+	if stageLocalFiles && cfg.GenmediaBucket == "" {
+		log.Printf("Local file staging was requested but GENMEDIA_BUCKET is not set; local PDF/video inputs will be rejected.")
+	}
+	return policy
+}
+
+// gcsObjectSizer, gcsObjectCreator and localFileStager are variables so tests can avoid calling
+// GCS.
+var (
+	gcsObjectSizer   = common.GetGCSObjectSize
+	gcsObjectCreator = common.CreateGCSObject
+	localFileStager  = stageLocalFileToGCS
+)
+
+// buildInputParts converts the paths passed to a generation tool into Gemini parts.
+// Images are sent inline (local) or by URI (GCS); PDFs and videos are always referenced
+// by GCS URI as FileData parts, subject to the policy's count and size limits.
+func buildInputParts(ctx context.Context, inputPaths []string, policy fileInputPolicy) ([]*genai.Part, error) {
+	var parts []*genai.Part
+	fileInputs := 0
+	for _, inputPath := range inputPaths {
+		mimeType, isFileInput := fileInputMimeTypes[strings.ToLower(filepath.Ext(inputPath))]
+		if !isFileInput {
+			part, err := buildImagePart(inputPath)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+			continue
+		}
+
+		fileInputs++
+		if fileInputs > policy.MaxFileInputs {
+			return nil, fmt.Errorf("too many PDF/video inputs: at most %d are allowed per request", policy.MaxFileInputs)
+		}
+
+		gcsURI := inputPath
+		if !strings.HasPrefix(inputPath, "gs://") {
+			if !policy.StageLocalFiles {
+				return nil, fmt.Errorf("local %s file %s cannot be sent to Gemini directly; upload it to GCS first (e.g., gsutil cp %s gs://your-bucket/) and pass the gs:// URI", mimeType, inputPath, inputPath)
+			}
+			staged, err := localFileStager(ctx, inputPath, policy)
+			if err != nil {
+				return nil, fmt.Errorf("failed to stage %s to GCS: %w", inputPath, err)
+			}
+			gcsURI = staged
+		} else {
+			size, err := gcsObjectSizer(ctx, gcsURI)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read metadata for %s: %w", gcsURI, err)
+			}
+			if size > policy.MaxFileBytes {
+				return nil, fmt.Errorf("%s is %s, which exceeds the %s per-file limit", gcsURI, common.FormatBytes(size), common.FormatBytes(policy.MaxFileBytes))
+			}
+		}
+		parts = append(parts, genai.NewPartFromURI(gcsURI, mimeType))
+	}
+	return parts, nil
+}
+
+// buildImagePart creates a part for an image input, keeping the original behavior of
+// sending local images inline and referencing GCS images by URI.
+func buildImagePart(imgPath string) (*genai.Part, error) {
+	if strings.HasPrefix(imgPath, "gs://") {
+		return genai.NewPartFromURI(imgPath, inferMimeType(imgPath)), nil
+	}
+	imgData, err := os.ReadFile(imgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image file %s: %v", imgPath, err)
+	}
+	return genai.NewPartFromBytes(imgData, inferMimeType(imgPath)), nil
+}
+
+// stageLocalFileToGCS uploads a local PDF/video to the staging bucket and returns its GCS URI.
+// The file is streamed rather than read into memory: it is hashed in a first pass to name the
+// object, then copied to GCS. The copy is hashed again on the way, and the upload is abandoned
+// when the file changed in between, so the name always matches the staged content.
+func stageLocalFileToGCS(ctx context.Context, localPath string, policy fileInputPolicy) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > policy.MaxFileBytes {
+		return "", fmt.Errorf("file is %s, which exceeds the %s per-file limit", common.FormatBytes(info.Size()), common.FormatBytes(policy.MaxFileBytes))
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	sum := hash.Sum(nil)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	gcsURI := fmt.Sprintf("gs://%s/%s", policy.StagingBucket, stagedObjectName(localPath, sum, time.Now()))
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := gcsObjectCreator(uploadCtx, gcsURI, "")
+	if err != nil {
+		return "", err
+	}
+	uploaded := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(f, uploaded)); err != nil {
+		cancel()
+		w.Close()
+		return "", err
+	}
+	if !bytes.Equal(uploaded.Sum(nil), sum) {
+		cancel()
+		w.Close()
+		return "", fmt.Errorf("%s changed while it was being staged", localPath)
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	log.Printf("Staged local input %s to %s", localPath, gcsURI)
+	return gcsURI, nil
+}
+
+// stagedObjectName names the staging object for a local file with the given SHA-256. The
+// content hash keeps two different files with the same base name, staged in the same second,
+// from overwriting each other.
+func stagedObjectName(localPath string, sum []byte, now time.Time) string {
+	return fmt.Sprintf("gemini_inputs/%s_%s_%s", now.Format("20060102150405"), hex.EncodeToString(sum[:8]), filepath.Base(localPath))
+}
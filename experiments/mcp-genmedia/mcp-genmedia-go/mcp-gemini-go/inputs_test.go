@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildInputParts(t *testing.T) {
+	originalSizer, originalStager := gcsObjectSizer, localFileStager
+	defer func() { gcsObjectSizer, localFileStager = originalSizer, originalStager }()
+
+	gcsObjectSizer = func(ctx context.Context, gcsURI string) (int64, error) {
+		if strings.Contains(gcsURI, "huge") {
+			return 2 * 1024 * 1024, nil
+		}
+		if strings.Contains(gcsURI, "missing") {
+			return 0, errors.New("object not found")
+		}
+		return 1024, nil
+	}
+	var staged []string
+	localFileStager = func(ctx context.Context, localPath string, policy fileInputPolicy) (string, error) {
+		staged = append(staged, localPath)
+		return "gs://" + policy.StagingBucket + "/gemini_inputs/" + filepath.Base(localPath), nil
+	}
+
+	localImage := filepath.Join(t.TempDir(), "photo.png")
+	if err := os.WriteFile(localImage, []byte("png-bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := fileInputPolicy{MaxFileInputs: 4, MaxFileBytes: 1024 * 1024}
+
+	t.Run("part construction for each type", func(t *testing.T) {
+		inputs := []string{
+			"gs://bucket/brief.pdf",
+			"gs://bucket/clip.mp4",
+			"gs://bucket/clip.webm",
+			"gs://bucket/clip.MOV",
+			"gs://bucket/photo.jpg",
+			localImage,
+		}
+		expectedMimeTypes := []string{"application/pdf", "video/mp4", "video/webm", "video/quicktime", "image/jpeg"}
+
+		parts, err := buildInputParts(context.Background(), inputs, policy)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if len(parts) != len(inputs) {
+			t.Fatalf("expected %d parts, but got %d", len(inputs), len(parts))
+		}
+		for i, mimeType := range expectedMimeTypes {
+			if parts[i].FileData == nil {
+				t.Fatalf("part %d: expected FileData, but got %+v", i, parts[i])
+			}
+			if parts[i].FileData.FileURI != inputs[i] || parts[i].FileData.MIMEType != mimeType {
+				t.Errorf("part %d: expected (%s, %s), but got (%s, %s)", i, inputs[i], mimeType, parts[i].FileData.FileURI, parts[i].FileData.MIMEType)
+			}
+		}
+		last := parts[len(parts)-1]
+		if last.InlineData == nil || string(last.InlineData.Data) != "png-bytes" || last.InlineData.MIMEType != "image/png" {
+			t.Errorf("expected local image to be sent inline, but got %+v", last)
+		}
+	})
+
+	t.Run("local pdf is rejected without staging", func(t *testing.T) {
+		_, err := buildInputParts(context.Background(), []string{"./brief.pdf"}, policy)
+		if err == nil || !strings.Contains(err.Error(), "upload it to GCS first") {
+			t.Errorf("expected guidance to upload to GCS, but got: %v", err)
+		}
+	})
+
+	t.Run("local video is staged when enabled", func(t *testing.T) {
+		stagingPolicy := policy
+		stagingPolicy.StageLocalFiles = true
+		stagingPolicy.StagingBucket = "genmedia"
+		staged = nil
+
+		parts, err := buildInputParts(context.Background(), []string{"/tmp/clip.mp4"}, stagingPolicy)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if len(staged) != 1 || staged[0] != "/tmp/clip.mp4" {
+			t.Errorf("expected the file to be staged, but staged %v", staged)
+		}
+		if parts[0].FileData == nil || parts[0].FileData.FileURI != "gs://genmedia/gemini_inputs/clip.mp4" || parts[0].FileData.MIMEType != "video/mp4" {
+			t.Errorf("unexpected staged part: %+v", parts[0])
+		}
+	})
+
+	t.Run("count limit", func(t *testing.T) {
+		countPolicy := policy
+		countPolicy.MaxFileInputs = 1
+		_, err := buildInputParts(context.Background(), []string{"gs://bucket/a.pdf", "gs://bucket/b.pdf"}, countPolicy)
+		if err == nil || !strings.Contains(err.Error(), "at most 1") {
+			t.Errorf("expected a count limit error, but got: %v", err)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		_, err := buildInputParts(context.Background(), []string{"gs://bucket/huge.mp4"}, policy)
+		if err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Errorf("expected a size limit error, but got: %v", err)
+		}
+	})
+
+	t.Run("metadata lookup failure", func(t *testing.T) {
+		_, err := buildInputParts(context.Background(), []string{"gs://bucket/missing.pdf"}, policy)
+		if err == nil {
+			t.Errorf("expected an error, but got none")
+		}
+	})
+}
+
+func TestStagedObjectName(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 30, 45, 0, time.UTC)
+	firstSum, secondSum := sha256.Sum256([]byte("first take")), sha256.Sum256([]byte("second take"))
+	first := stagedObjectName("/a/clip.mp4", firstSum[:], now)
+	second := stagedObjectName("/b/clip.mp4", secondSum[:], now)
+
+	if !strings.HasPrefix(first, "gemini_inputs/20250701123045_") || !strings.HasSuffix(first, "_clip.mp4") {
+		t.Errorf("unexpected object name: %s", first)
+	}
+	if first == second {
+		t.Errorf("expected different files staged in the same second to get different names, both got %s", first)
+	}
+	if again := stagedObjectName("/a/clip.mp4", firstSum[:], now); again != first {
+		t.Errorf("expected the name to be stable for the same content, got %s and %s", first, again)
+	}
+}
+
+// fakeGCSObject stands in for a GCS object writer. onWrite, when set, runs before each write.
+type fakeGCSObject struct {
+	ctx       context.Context
+	data      bytes.Buffer
+	onWrite   func()
+	abandoned bool
+}
+
+func (o *fakeGCSObject) Write(p []byte) (int, error) {
+	if o.onWrite != nil {
+		o.onWrite()
+	}
+	return o.data.Write(p)
+}
+
+func (o *fakeGCSObject) Close() error {
+	o.abandoned = o.ctx.Err() != nil
+	return nil
+}
+
+func TestStageLocalFileToGCS(t *testing.T) {
+	original := gcsObjectCreator
+	t.Cleanup(func() { gcsObjectCreator = original })
+	objects := map[string]*fakeGCSObject{}
+	var onWrite func()
+	gcsObjectCreator = func(ctx context.Context, gcsURI, contentType string) (io.WriteCloser, error) {
+		objects[gcsURI] = &fakeGCSObject{ctx: ctx, onWrite: onWrite}
+		return objects[gcsURI], nil
+	}
+	policy := fileInputPolicy{MaxFileBytes: 1024, StagingBucket: "staging"}
+	localPath := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(localPath, []byte("%PDF-1.7 quarterly report"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gcsURI, err := stageLocalFileToGCS(context.Background(), localPath, policy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := sha256.Sum256([]byte("%PDF-1.7 quarterly report"))
+	if !strings.HasPrefix(gcsURI, "gs://staging/gemini_inputs/") || !strings.HasSuffix(gcsURI, "_"+hex.EncodeToString(sum[:8])+"_report.pdf") {
+		t.Errorf("unexpected staged URI: %s", gcsURI)
+	}
+	if object := objects[gcsURI]; object == nil || object.data.String() != "%PDF-1.7 quarterly report" || object.abandoned {
+		t.Errorf("expected the file to be uploaded to %s, got %+v", gcsURI, objects)
+	}
+
+	// The file grows while it is copied: the upload no longer matches its name.
+	var revised bool
+	onWrite = func() {
+		if revised {
+			return
+		}
+		revised = true
+		f, err := os.OpenFile(localPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.WriteString(" (revised)")
+	}
+	objects = map[string]*fakeGCSObject{}
+	if _, err := stageLocalFileToGCS(context.Background(), localPath, policy); err == nil || !strings.Contains(err.Error(), "changed while it was being staged") {
+		t.Errorf("expected a changed file to be rejected, got %v", err)
+	}
+	for uri, object := range objects {
+		if !object.abandoned {
+			t.Errorf("expected the upload to %s to be abandoned", uri)
+		}
+	}
+}
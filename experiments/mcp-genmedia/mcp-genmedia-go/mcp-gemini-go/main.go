@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -28,31 +29,54 @@ import (
 )
 
 var (
-	appConfig   *common.Config
-	genAIClient *genai.Client
-	transport   string
+	appConfig       *common.Config
+	genAIClient     *genai.Client
+	transport       string
+	stageLocalFiles bool
+	inputPolicy     fileInputPolicy
+	usage           *usageBudget
+	recorder        *generationRecorder
 )
 
 const (
 	serviceName = "mcp-gemini-go"
 	version     = "0.2.0"
+
+	defaultGeminiImageModel = "gemini-2.5-flash-image-preview"
 )
 
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	flag.StringVar(&transport, "t", "stdio", "Transport type (stdio, sse, or http)")
 	flag.StringVar(&transport, "transport", "stdio", "Transport type (stdio, sse, or http)")
-	flag.Parse()
+	flag.BoolVar(&stageLocalFiles, "stage-local-files", false, "Upload local PDF/video inputs to GENMEDIA_BUCKET instead of rejecting them")
 }
 
 func main() {
-	appConfig = common.LoadConfig()
+	flag.Parse()
+	var err error
+	activeBackend, err = selectBackend(os.LookupEnv)
+	if err != nil {
+		log.Fatalf("Cannot configure the GenAI backend: %v", err)
+	}
+	log.Printf("Using the %s backend.", activeBackend)
 
-	// Override default location for Gemini models if not explicitly set
-	if os.Getenv("LOCATION") == "" {
-		log.Printf("LOCATION environment variable not set. Defaulting to 'global' for mcp-gemini-go.")
-		appConfig.Location = "global"
+	if activeBackend.geminiAPI() {
+		// There is no project on the Gemini API backend, so the GCS-based options stay unset.
+		appConfig = &common.Config{}
+		if stageLocalFiles {
+			log.Printf("-stage-local-files has no effect on the Gemini API backend; local PDF/video inputs will be rejected.")
+			stageLocalFiles = false
+		}
+	} else {
+		appConfig = common.LoadConfig()
+		// Override default location for Gemini models if not explicitly set
+		if os.Getenv("LOCATION") == "" {
+			log.Printf("LOCATION environment variable not set. Defaulting to 'global' for mcp-gemini-go.")
+			appConfig.Location = "global"
+		}
 	}
+	inputPolicy = loadFileInputPolicy(appConfig, stageLocalFiles)
 
 	tp, err := common.InitTracerProvider(serviceName, version)
 	if err != nil {
@@ -73,7 +97,12 @@ func main() {
 		Project:  appConfig.ProjectID,
 		Location: appConfig.Location,
 	}
-	if appConfig.ApiEndpoint != "" {
+	if activeBackend.geminiAPI() {
+		clientConfig = &genai.ClientConfig{
+			Backend: genai.BackendGeminiAPI,
+			APIKey:  activeBackend.APIKey,
+		}
+	} else if appConfig.ApiEndpoint != "" {
 		log.Printf("Using custom Vertex AI endpoint: %s", appConfig.ApiEndpoint)
 		clientConfig.HTTPOptions.BaseURL = appConfig.ApiEndpoint
 	}
@@ -82,27 +111,86 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating global GenAI client: %v", err)
 	}
+	// Wait out 429/503 responses that carry Retry-After, for unary and streaming calls alike.
+	common.HonorRetryAfter(genAIClient.ClientConfig().HTTPClient)
 	log.Printf("Global GenAI client initialized successfully.")
 
+	budgetCfg, err := loadBudgetConfig()
+	if err != nil {
+		log.Fatalf("Invalid request budget configuration: %v", err)
+	}
+	var store budgetStore
+	if budgetCfg.StateURI != "" {
+		gcsStore, err := newGCSBudgetStore(budgetCfg.StateURI)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", budgetStateEnvVar, err)
+		}
+		store = gcsStore
+		log.Printf("Persisting request budget state to %s", budgetCfg.StateURI)
+	}
+	usage, err = newUsageBudget(clientCtx, budgetCfg, store, nil)
+	if err != nil {
+		log.Fatalf("Error initializing request budgets: %v", err)
+	}
+	for tool, limit := range budgetCfg.ToolLimits {
+		log.Printf("Request budget for tool %s: %s", tool, limit)
+	}
+	for model, limit := range budgetCfg.ModelLimits {
+		log.Printf("Request budget for model %s: %s", model, limit)
+	}
+
+	recorderCfg, err := loadRecorderConfig()
+	if err != nil {
+		log.Fatalf("Invalid generation recorder configuration: %v", err)
+	}
+	if recorderCfg.Prefix != "" {
+		recorder = newGenerationRecorder(recorderCfg, gcsRecordSink{}, nil)
+		recorder.Start()
+		log.Printf("Recording successful generations as JSONL under %s every %s", recorderCfg.Prefix, recorderCfg.FlushInterval)
+	}
+
 	s := server.NewMCPServer("Gemini", version)
 
 	tool := mcp.NewTool("gemini_image_generation",
-		mcp.WithDescription("Generates content (text and/or images) based on a multimodal prompt using Gemini 2.5 Flash Image generation. This model is also called nano-banana."),
+		mcp.WithDescription("Generates content (text and/or images) based on a multimodal prompt using Gemini 2.5 Flash Image generation. This model is also called nano-banana. Input images, PDFs, and videos can be provided as context. "+supportedInputTypesDescription),
 		mcp.WithString("prompt", mcp.Required(), mcp.Description("The text prompt for content generation.")),
-		mcp.WithString("model", mcp.DefaultString("gemini-2.5-flash-image-preview"), mcp.Description("The specific Gemini model to use.")),
-		mcp.WithArray("images", mcp.Description("Optional. A list of local file paths or GCS URIs for input images.")),
-		mcp.WithString("output_directory", mcp.Description("Optional. Local directory to save generated image(s) to.")),
-		mcp.WithString("gcs_bucket_uri", mcp.Description("Optional. GCS URI prefix to store generated images (e.g., your-bucket/outputs/).")),
+		mcp.WithString("model", mcp.DefaultString(defaultGeminiImageModel), mcp.Description("The specific Gemini model to use.")),
+		mcp.WithArray("images", mcp.Description("Optional. A list of input files to use as context. "+supportedInputTypesDescription)),
+		mcp.WithString("output_directory", mcp.Description("Optional. Local directory to save generated image(s) to. "+outputPathTokensDescription)),
+		mcp.WithString("gcs_bucket_uri", mcp.Description("Optional. GCS URI prefix to store generated images (e.g., your-bucket/outputs/). "+outputPathTokensDescription)),
+		mcp.WithString("session_id", mcp.Description(sessionIDDescription)),
+		mcp.WithNumber("thinking_budget", mcp.Min(0), mcp.Description("Optional. Maximum number of reasoning (thinking) tokens for Gemini 2.5 models that support a thinking budget. 0 disables thinking where the model allows it. If omitted, the model's default applies.")),
 	)
 
 	handlerWithClient := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return geminiGenerateContentHandler(genAIClient, ctx, request)
 	}
-	s.AddTool(tool, handlerWithClient)
+	s.AddTool(tool, withBudget(usage, "gemini_image_generation", "model", defaultGeminiImageModel, validateGenerateContentArgs,
+		withRecorder(recorder, "gemini_image_generation", "model", defaultGeminiImageModel, handlerWithClient)))
+
+	listModelsTool := mcp.NewTool("gemini_list_models",
+		mcp.WithDescription("Lists the models available in the configured region and backend, with their supported methods and token limits where the backend reports them."),
+		mcp.WithString("capability", mcp.Description("Optional. Only list models offering this capability, e.g. 'image-generation', 'tts', 'embedding', 'video-generation', or any substring of a model's name or description.")),
+	)
+	s.AddTool(listModelsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return geminiListModelsHandler(genAIClient, ctx, request)
+	})
+
+	embedTool := mcp.NewTool("gemini_embed_text",
+		mcp.WithDescription("Computes embedding vectors for a list of texts, e.g. prompts or captions for similarity search. Large lists are split into several API calls transparently; the vectors are returned as JSON in input order, with their dimensionality."),
+		mcp.WithArray("texts", mcp.Required(), mcp.WithStringItems(), mcp.MaxItems(maxEmbedTexts), mcp.Description(fmt.Sprintf("The texts to embed (up to %d).", maxEmbedTexts))),
+		mcp.WithString("model", mcp.DefaultString(defaultEmbeddingModel), mcp.Description("The embedding model to use. Use 'gemini_list_models' with capability 'embedding' to see the available models.")),
+		mcp.WithString("task_type", mcp.Enum(embeddingTaskTypes...), mcp.Description("Optional. What the embeddings will be used for, which lets the model optimize them, e.g. SEMANTIC_SIMILARITY, or RETRIEVAL_DOCUMENT for the library and RETRIEVAL_QUERY for searches against it.")),
+		mcp.WithString("output_gcs_uri", mcp.Description(fmt.Sprintf("Optional. GCS object (e.g. gs://my-bucket/embeddings/captions.jsonl) to write the embeddings to as JSONL, one {index, text, embedding} object per line, when they are over %d KiB of JSON. Smaller results are returned inline. Vertex AI only.", maxInlineEmbeddingBytes/1024))),
+	)
+	s.AddTool(embedTool, withBudget(usage, "gemini_embed_text", "model", defaultEmbeddingModel, validateEmbedArgs,
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return geminiEmbedTextHandler(genAIClient, ctx, request)
+		}))
 
 	// --- Register Gemini TTS Tools ---
 	listVoicesTool := mcp.NewTool("list_gemini_voices",
-		mcp.WithDescription("Lists the available single-speaker voices for use with the Gemini-TTS models."),
+		mcp.WithDescription("Lists the available single-speaker voices for use with the Gemini-TTS models, with the language codes each voice speaks."),
 	)
 	s.AddTool(listVoicesTool, listGeminiVoicesHandler)
 
@@ -113,13 +201,20 @@ func main() {
 			mcp.Description("The text to synthesize (up to 800 characters)."),
 		),
 		mcp.WithString("prompt",
-			mcp.Description("Stylistic instructions on how to synthesize the content. You can adapt delivery, adopt specific accents, and produce a range of tones and expressions."),
+			mcp.Description("Stylistic instructions on how to synthesize the content. You can adapt delivery, adopt specific accents, and produce a range of tones and expressions. When omitted, the server's default style, if one is configured, is used."),
 		),
 		mcp.WithString("voice_name",
 			mcp.DefaultString(defaultGeminiTTSVoice),
 			mcp.Description("The voice to use. Use 'list_gemini_voices' to see available voices."),
 			mcp.Enum(availableGeminiVoices...),
 		),
+		mcp.WithString("language_code",
+			mcp.Description("Optional. BCP-47 code of the language the text is spoken in, e.g. 'es-ES'. It is added to the style prompt so the delivery matches the text. Defaults to 'en-US'. See the gemini://language_codes resource for the supported languages."),
+			mcp.Enum(geminiTTSLanguageCodes()...),
+		),
+		mcp.WithBoolean("strict_language",
+			mcp.Description("Optional. When true, text that does not look like it is written in language_code is rejected instead of synthesized with a warning."),
+		),
 		mcp.WithString("model_name",
 			mcp.DefaultString(defaultGeminiTTSModel),
 			mcp.Description("The model to use."),
@@ -130,12 +225,21 @@ func main() {
 			mcp.Description("Optional. A prefix for the output WAV filename if saving locally. A timestamp and .wav extension will be appended."),
 		),
 		mcp.WithString("output_directory",
-			mcp.Description("Optional. If provided, specifies a local directory to save the generated audio file to. If not provided, audio data is returned in the response."),
+			mcp.Description("Optional. If provided, specifies a local directory to save the generated audio file to. If not provided, audio data is returned in the response. "+outputPathTokensDescription),
 		),
+		mcp.WithString("session_id", mcp.Description(sessionIDDescription)),
 	)
-	s.AddTool(ttsTool, geminiAudioTTSHandler)
+	s.AddTool(ttsTool, withBudget(usage, "gemini_audio_tts", "model_name", defaultGeminiTTSModel, validateTTSArgs,
+		withRecorder(recorder, "gemini_audio_tts", "model_name", defaultGeminiTTSModel, geminiAudioTTSHandler)))
 	// --- End of TTS Tools ---
 
+	usageTool := mcp.NewTool("gemini_usage",
+		mcp.WithDescription("Reports request counts per tool and per model against the configured request budgets (with remaining requests and reset times), and the token totals accumulated from successful responses."),
+	)
+	s.AddTool(usageTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return geminiUsageHandler(usage, ctx, request)
+	})
+
 	// --- Register Gemini Resources ---
 	s.AddResource(mcp.NewResource(
 		"gemini://language_codes",
@@ -146,7 +250,12 @@ func main() {
 	// --- End of Gemini Resources ---
 
 	log.Printf("Starting %s MCP Server (Version: %s)", serviceName, version)
-	if err := server.ServeStdio(s); err != nil {
+	err = server.ServeStdio(s)
+	// Write the records still buffered before exiting.
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	recorder.Close(flushCtx)
+	flushCancel()
+	if err != nil {
 		log.Fatalf("STDIO Server error: %v", err)
 	}
 }
@@ -0,0 +1,122 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+// capabilityKeywords maps the capability names suggested in the tool description to the
+// substrings that identify them in model names and descriptions. Any other capability is
+// matched as a plain substring.
+var capabilityKeywords = map[string][]string{
+	"image-generation": {"image"},
+	"tts":              {"tts"},
+	"embedding":        {"embed"},
+	"video-generation": {"veo"},
+	"audio":            {"audio", "tts", "native-audio"},
+}
+
+// geminiModelSummary is the per-model entry returned by the gemini_list_models tool.
+type geminiModelSummary struct {
+	Name             string   `json:"name"`
+	DisplayName      string   `json:"display_name,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Version          string   `json:"version,omitempty"`
+	SupportedMethods []string `json:"supported_methods,omitempty"`
+	InputTokenLimit  int32    `json:"input_token_limit,omitempty"`
+	OutputTokenLimit int32    `json:"output_token_limit,omitempty"`
+}
+
+// modelMatchesCapability reports whether the model appears to offer the capability, by looking
+// for the capability's keywords in its name, display name, description and supported methods.
+func modelMatchesCapability(model *genai.Model, capability string) bool {
+	capability = strings.ToLower(strings.TrimSpace(capability))
+	if capability == "" {
+		return true
+	}
+	keywords, ok := capabilityKeywords[capability]
+	if !ok {
+		keywords = []string{capability}
+	}
+	haystack := strings.ToLower(strings.Join(append([]string{model.Name, model.DisplayName, model.Description}, model.SupportedActions...), " "))
+	for _, keyword := range keywords {
+		if strings.Contains(haystack, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// geminiListModelsHandler handles the 'gemini_list_models' tool request.
+// It lists the models available to the shared client's backend and region, optionally
+// filtered by capability, and returns their names, supported methods and token limits.
+func geminiListModelsHandler(client *genai.Client, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "gemini_list_models")
+	defer span.End()
+
+	capability, _ := request.GetArguments()["capability"].(string)
+	capability = strings.TrimSpace(capability)
+	span.SetAttributes(attribute.String("capability", capability))
+	log.Printf("Handling gemini_list_models request with capability filter: %q", capability)
+
+	var models []geminiModelSummary
+	for model, err := range client.Models.All(ctx) {
+		if err != nil {
+			span.RecordError(err)
+			return mcp.NewToolResultError(formatGeminiError("error listing Gemini models", err)), nil
+		}
+		if !modelMatchesCapability(model, capability) {
+			continue
+		}
+		models = append(models, geminiModelSummary{
+			Name:             model.Name,
+			DisplayName:      model.DisplayName,
+			Description:      model.Description,
+			Version:          model.Version,
+			SupportedMethods: model.SupportedActions,
+			InputTokenLimit:  model.InputTokenLimit,
+			OutputTokenLimit: model.OutputTokenLimit,
+		})
+	}
+	span.SetAttributes(attribute.Int("model_count", len(models)))
+
+	modelListJSON, err := json.MarshalIndent(models, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal model list: %v", err)), nil
+	}
+
+	summary := fmt.Sprintf("Found %d available models.", len(models))
+	if capability != "" {
+		summary = fmt.Sprintf("Found %d available models matching capability %q.", len(models), capability)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{Type: "text", Text: summary},
+			mcp.TextContent{Type: "text", Text: string(modelListJSON)},
+		},
+	}, nil
+}
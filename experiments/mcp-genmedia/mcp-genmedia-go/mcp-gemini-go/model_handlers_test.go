@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+func TestModelMatchesCapability(t *testing.T) {
+	imageModel := &genai.Model{Name: "models/gemini-2.5-flash-image-preview", SupportedActions: []string{"generateContent"}}
+	ttsModel := &genai.Model{Name: "models/gemini-2.5-flash-preview-tts", SupportedActions: []string{"generateContent"}}
+	embedModel := &genai.Model{Name: "models/text-embedding-004", SupportedActions: []string{"embedContent"}}
+
+	testCases := []struct {
+		model      *genai.Model
+		capability string
+		expected   bool
+	}{
+		{imageModel, "", true},
+		{imageModel, "image-generation", true},
+		{ttsModel, "image-generation", false},
+		{ttsModel, "TTS", true},
+		{embedModel, "embedding", true},
+		{embedModel, "embedContent", true},
+		{imageModel, "embedContent", false},
+	}
+	for _, tc := range testCases {
+		if got := modelMatchesCapability(tc.model, tc.capability); got != tc.expected {
+			t.Errorf("modelMatchesCapability(%s, %q) = %t, expected %t", tc.model.Name, tc.capability, got, tc.expected)
+		}
+	}
+}
+
+func TestGeminiListModelsHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"models": [
+			{"name": "models/gemini-2.5-flash-image-preview", "displayName": "Nano Banana", "inputTokenLimit": 32768, "outputTokenLimit": 8192, "supportedGenerationMethods": ["generateContent", "countTokens"]},
+			{"name": "models/gemini-2.5-flash-preview-tts", "inputTokenLimit": 8192, "outputTokenLimit": 16384, "supportedGenerationMethods": ["generateContent"]}
+		]}`)
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"capability": "tts"}
+	result, err := geminiListModelsHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+
+	var models []geminiModelSummary
+	if err := json.Unmarshal([]byte(result.Content[1].(mcp.TextContent).Text), &models); err != nil {
+		t.Fatalf("result is not a JSON model list: %v", err)
+	}
+	if len(models) != 1 || models[0].Name != "models/gemini-2.5-flash-preview-tts" {
+		t.Fatalf("expected only the TTS model, got %+v", models)
+	}
+	if models[0].InputTokenLimit != 8192 || models[0].OutputTokenLimit != 16384 || len(models[0].SupportedMethods) != 1 {
+		t.Errorf("token limits or methods were not carried over: %+v", models[0])
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// outputPathTokensDescription documents the template tokens for the output location parameters.
+const outputPathTokensDescription = "Supports the template tokens {date} (YYYY-MM-DD), {session_id}, {tool} and {model}, e.g. ./out/{session_id}/{date}."
+
+// sessionIDDescription documents the 'session_id' parameter that fills the {session_id} token.
+const sessionIDDescription = "Optional. Value for the {session_id} token in output locations. If omitted, an ID generated once per server process is used."
+
+// processSessionID is the {session_id} used when a request does not pass 'session_id'.
+// It is generated once per server process.
+var processSessionID = newSessionID()
+
+func newSessionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102-150405")
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// outputPathTokens are the values substituted into output_directory and gcs_bucket_uri.
+type outputPathTokens struct {
+	Date      string
+	SessionID string
+	Tool      string
+	Model     string
+}
+
+// newOutputPathTokens builds the token values for one request. {session_id} comes from the
+// optional 'session_id' argument and falls back to the per-process session ID.
+func newOutputPathTokens(args map[string]interface{}, tool, model string, now time.Time) outputPathTokens {
+	sessionID, _ := args["session_id"].(string)
+	if strings.TrimSpace(sessionID) == "" {
+		sessionID = processSessionID
+	}
+	return outputPathTokens{
+		Date:      now.Format("2006-01-02"),
+		SessionID: sessionID,
+		Tool:      tool,
+		Model:     model,
+	}
+}
+
+// expand replaces the tokens in template with their sanitized values. Text outside the
+// tokens is left as the caller wrote it.
+func (t outputPathTokens) expand(template string) string {
+	return strings.NewReplacer(
+		"{date}", sanitizePathToken(t.Date),
+		"{session_id}", sanitizePathToken(t.SessionID),
+		"{tool}", sanitizePathToken(t.Tool),
+		"{model}", sanitizePathToken(t.Model),
+	).Replace(template)
+}
+
+var unsafePathTokenChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizePathToken reduces a token value to a single safe path segment. Separators and other
+// unusual characters become '_', and a value made only of dots (such as "..") becomes "_", so a
+// token can never climb out of, or add levels to, the directory it is placed in.
+func sanitizePathToken(value string) string {
+	value = unsafePathTokenChars.ReplaceAllString(strings.TrimSpace(value), "_")
+	if strings.Trim(value, ".") == "" {
+		return "_"
+	}
+	return value
+}
+
+// splitGCSPrefix splits a GCS URI prefix such as gs://bucket/outputs/ (the gs:// is optional)
+// into the bucket and the object name prefix.
+func splitGCSPrefix(uri string) (bucket, prefix string, err error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(uri), "gs://")
+	bucket, prefix, _ = strings.Cut(trimmed, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("invalid GCS URI prefix '%s'", uri)
+	}
+	return bucket, strings.Trim(path.Clean("/"+prefix), "/"), nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+func TestOutputPathTokensExpand(t *testing.T) {
+	now := time.Date(2025, 7, 4, 12, 0, 0, 0, time.UTC)
+
+	tokens := newOutputPathTokens(map[string]interface{}{"session_id": "agent-7"}, "gemini_audio_tts", "gemini-2.5-flash-preview-tts", now)
+	got := tokens.expand("out/{session_id}/{date}/{tool}-{model}/{unknown}")
+	if want := "out/agent-7/2025-07-04/gemini_audio_tts-gemini-2.5-flash-preview-tts/{unknown}"; got != want {
+		t.Errorf("expand = %q, want %q", got, want)
+	}
+
+	// without 'session_id', every request in this process shares the generated ID
+	fallback := newOutputPathTokens(map[string]interface{}{"session_id": "  "}, "t", "m", now)
+	if fallback.SessionID != processSessionID || processSessionID == "" {
+		t.Errorf("expected the process session ID %q, got %q", processSessionID, fallback.SessionID)
+	}
+	if got := fallback.expand("{session_id}"); got != sanitizePathToken(processSessionID) || strings.ContainsAny(got, "/\\") {
+		t.Errorf("unexpected fallback expansion %q", got)
+	}
+}
+
+func TestOutputPathTokensSanitize(t *testing.T) {
+	base := t.TempDir()
+	for _, sessionID := range []string{"../../etc", "..", ".", "a/../../b", `..\..\windows`, "x\x00y", "/abs/path"} {
+		tokens := newOutputPathTokens(map[string]interface{}{"session_id": sessionID}, "tool", "publishers/google/models/m", time.Now())
+		dir := tokens.expand(filepath.Join(base, "{session_id}", "{model}"))
+		rel, err := filepath.Rel(base, dir)
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		if err != nil || len(segments) != 2 || segments[0] == ".." || segments[1] == ".." {
+			t.Errorf("session_id %q expanded to %q, which is not two levels under %q", sessionID, dir, base)
+		}
+	}
+	for value, want := range map[string]string{"..": "_", "": "_", "a b/c": "a_b_c", "v1.2_x-y": "v1.2_x-y"} {
+		if got := sanitizePathToken(value); got != want {
+			t.Errorf("sanitizePathToken(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestSplitGCSPrefix(t *testing.T) {
+	for uri, want := range map[string][2]string{
+		"gs://bucket/outputs/a/": {"bucket", "outputs/a"},
+		"bucket":                 {"bucket", ""},
+		"bucket/../x":            {"bucket", "x"},
+	} {
+		bucket, prefix, err := splitGCSPrefix(uri)
+		if err != nil || bucket != want[0] || prefix != want[1] {
+			t.Errorf("splitGCSPrefix(%q) = %q, %q, %v; want %q, %q", uri, bucket, prefix, err, want[0], want[1])
+		}
+	}
+	if _, _, err := splitGCSPrefix("gs:///x"); err == nil {
+		t.Error("expected an error for a missing bucket")
+	}
+}
+
+func TestGenerateContentHandlerOutputTemplates(t *testing.T) {
+	image := base64.StdEncoding.EncodeToString([]byte("png-bytes"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"candidates": [{"content": {"role": "model", "parts": [{"inlineData": {"mimeType": "image/png", "data": %q}}]}, "finishReason": "STOP"}]}`, image)
+	}))
+	defer srv.Close()
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var uploaded []string
+	originalUploader := gcsImageUploader
+	gcsImageUploader = func(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
+		uploaded = append(uploaded, fmt.Sprintf("gs://%s/%s", bucketName, objectName))
+		return nil
+	}
+	defer func() { gcsImageUploader = originalUploader }()
+
+	base := t.TempDir()
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"prompt":           "a lighthouse",
+		"model":            "gemini-2.5-flash-image-preview",
+		"session_id":       "../run 1",
+		"output_directory": filepath.Join(base, "{session_id}", "{date}"),
+		"gcs_bucket_uri":   "gs://media/{tool}/{session_id}/",
+	}
+	result, err := geminiGenerateContentHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+
+	expectedDir := filepath.Join(base, ".._run_1", time.Now().Format("2006-01-02"))
+	files, _ := filepath.Glob(filepath.Join(expectedDir, "gemini_*.png"))
+	if len(files) != 1 {
+		t.Fatalf("expected one image in %s (intermediate directories created), got %v", expectedDir, files)
+	}
+	if data, _ := os.ReadFile(files[0]); string(data) != "png-bytes" {
+		t.Errorf("unexpected image contents %q", data)
+	}
+	if len(uploaded) != 1 || !strings.HasPrefix(uploaded[0], "gs://media/gemini_image_generation/.._run_1/gemini_") {
+		t.Errorf("unexpected uploads %v", uploaded)
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{expectedDir, files[0], "gs://media/gemini_image_generation/.._run_1/", uploaded[0]} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the result to report %s, got %q", want, text)
+		}
+	}
+}
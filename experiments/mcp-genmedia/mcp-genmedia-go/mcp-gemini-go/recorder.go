@@ -0,0 +1,402 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/genai"
+)
+
+const (
+	// recordPrefixEnvVar is the gs:// prefix under which generation records are written.
+	recordPrefixEnvVar = "GEMINI_RECORD_JSONL_GCS_PREFIX"
+	// recordRedactEnvVar lists the argument keys whose values are hashed instead of stored.
+	recordRedactEnvVar = "GEMINI_RECORD_REDACT_FIELDS"
+	// recordFlushIntervalEnvVar overrides how often buffered records are written.
+	recordFlushIntervalEnvVar = "GEMINI_RECORD_FLUSH_INTERVAL"
+
+	defaultRecordFlushInterval = 30 * time.Second
+	// recordBatchSize is the number of buffered records that triggers a flush before the timer.
+	recordBatchSize = 100
+	// inlineDataMinLength is the length from which a base64 argument is treated as file bytes.
+	inlineDataMinLength = 256
+)
+
+// gcsURIPattern finds the GCS URIs of uploaded outputs in a tool's result text.
+var gcsURIPattern = regexp.MustCompile(`gs://[^\s,]+`)
+
+// recorderConfig is the generation recorder's configuration; an empty Prefix disables it.
+type recorderConfig struct {
+	Prefix        string
+	RedactFields  []string
+	FlushInterval time.Duration
+}
+
+// loadRecorderConfig reads the recorder settings from the environment.
+func loadRecorderConfig() (recorderConfig, error) {
+	cfg := recorderConfig{
+		Prefix:        strings.TrimSuffix(strings.TrimSpace(os.Getenv(recordPrefixEnvVar)), "/"),
+		FlushInterval: defaultRecordFlushInterval,
+	}
+	for _, field := range strings.Split(os.Getenv(recordRedactEnvVar), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			cfg.RedactFields = append(cfg.RedactFields, field)
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv(recordFlushIntervalEnvVar)); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return recorderConfig{}, fmt.Errorf("%s must be a positive duration such as 30s, got %q", recordFlushIntervalEnvVar, value)
+		}
+		cfg.FlushInterval = interval
+	}
+	if cfg.Prefix != "" && !strings.HasPrefix(cfg.Prefix, "gs://") {
+		return recorderConfig{}, fmt.Errorf("%s must be a gs:// URI, e.g. gs://bucket/mcp-gemini/records, got %q", recordPrefixEnvVar, cfg.Prefix)
+	}
+	return cfg, nil
+}
+
+// recordSink writes one object of JSONL records.
+type recordSink interface {
+	Write(ctx context.Context, objectURI string, data []byte) error
+}
+
+// gcsRecordSink writes record objects to GCS.
+type gcsRecordSink struct{}
+
+func (gcsRecordSink) Write(ctx context.Context, objectURI string, data []byte) error {
+	bucket, object, err := common.ParseGCSPath(objectURI)
+	if err != nil {
+		return err
+	}
+	return common.UploadToGCS(ctx, bucket, object, "application/x-ndjson", data)
+}
+
+// generationRecord is one successful tool call, as written to the JSONL corpus.
+type generationRecord struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Tool      string                 `json:"tool"`
+	Model     string                 `json:"model"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Response  recordedResponse       `json:"response"`
+	Usage     *tokenUsage            `json:"usage,omitempty"`
+}
+
+// recordedResponse is the result of a tool call, with inline media replaced by hashes.
+type recordedResponse struct {
+	Text  []string        `json:"text,omitempty"`
+	URIs  []string        `json:"uris,omitempty"`
+	Media []recordedMedia `json:"media,omitempty"`
+}
+
+// recordedMedia stands in for the bytes of an image or audio clip in a result.
+type recordedMedia struct {
+	MIMEType string `json:"mime_type"`
+	SHA256   string `json:"sha256"`
+}
+
+// generationRecorder buffers generation records and writes them in batches, on a timer and
+// when recordBatchSize records are waiting. Each batch becomes one object in the partition of
+// its day, since GCS objects can't be appended to. A nil *generationRecorder records nothing.
+//
+// Recording is best effort: a failed write is logged and its records are dropped, so the
+// corpus can have gaps but tool calls are never slowed down or failed by it.
+type generationRecorder struct {
+	sink     recordSink
+	prefix   string
+	redact   map[string]bool
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*bytes.Buffer // by day, YYYY-MM-DD
+	count   int
+	seq     int
+
+	flushMu sync.Mutex // serializes flushes so batch names stay unique
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newGenerationRecorder creates a recorder writing under cfg.Prefix. Call Start to begin the
+// timed flushes and Close to write the last batch.
+func newGenerationRecorder(cfg recorderConfig, sink recordSink, now func() time.Time) *generationRecorder {
+	if now == nil {
+		now = time.Now
+	}
+	r := &generationRecorder{
+		sink:     sink,
+		prefix:   cfg.Prefix,
+		redact:   make(map[string]bool),
+		interval: cfg.FlushInterval,
+		now:      now,
+		pending:  make(map[string]*bytes.Buffer),
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, field := range cfg.RedactFields {
+		r.redact[field] = true
+	}
+	return r
+}
+
+// Start flushes the buffered records every interval, and as soon as a batch is full.
+func (r *generationRecorder) Start() {
+	if r == nil {
+		return
+	}
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-r.full:
+			case <-r.stop:
+				return
+			}
+			r.Flush(context.Background())
+		}
+	}()
+}
+
+// Close stops the timed flushes and writes the records still buffered.
+func (r *generationRecorder) Close(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.Flush(ctx)
+}
+
+// Record buffers a record. It never blocks on a write.
+func (r *generationRecorder) Record(record generationRecord) {
+	if r == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Not recording %s call: %v", record.Tool, err)
+		return
+	}
+	day := record.Timestamp.UTC().Format("2006-01-02")
+
+	r.mu.Lock()
+	buf, ok := r.pending[day]
+	if !ok {
+		buf = &bytes.Buffer{}
+		r.pending[day] = buf
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	r.count++
+	full := r.count >= recordBatchSize
+	r.mu.Unlock()
+
+	if full {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes the buffered records, one object per day, and returns how many were written.
+func (r *generationRecorder) Flush(ctx context.Context) int {
+	if r == nil {
+		return 0
+	}
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+
+	r.mu.Lock()
+	pending, count := r.pending, r.count
+	r.pending, r.count = make(map[string]*bytes.Buffer), 0
+	r.seq++
+	seq := r.seq
+	r.mu.Unlock()
+	if count == 0 {
+		return 0
+	}
+
+	days := make([]string, 0, len(pending))
+	for day := range pending {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	written := 0
+	stamp := r.now().UTC().Format("20060102T150405.000000000Z")
+	for _, day := range days {
+		data := pending[day].Bytes()
+		lines := bytes.Count(data, []byte("\n"))
+		objectURI := fmt.Sprintf("%s/dt=%s/%s-%d-%d.jsonl", r.prefix, day, stamp, os.Getpid(), seq)
+		if err := r.sink.Write(ctx, objectURI, data); err != nil {
+			log.Printf("Dropping %d generation records: writing %s failed: %v", lines, objectURI, err)
+			continue
+		}
+		written += lines
+	}
+	return written
+}
+
+// sanitizeArguments copies the tool arguments for a record. Redacted keys and inline file
+// bytes (data: URIs and long base64 strings) are replaced by their SHA-256.
+func (r *generationRecorder) sanitizeArguments(args map[string]interface{}) map[string]interface{} {
+	sanitized := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if r.redact[key] {
+			data, _ := json.Marshal(value)
+			sanitized[key] = hashReference(data)
+			continue
+		}
+		sanitized[key] = sanitizeValue(value)
+	}
+	return sanitized
+}
+
+// sanitizeValue replaces the inline file bytes within an argument value by their hash.
+func sanitizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if isInlineData(v) {
+			return hashReference([]byte(v))
+		}
+		return v
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = sanitizeValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = sanitizeValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// isInlineData reports whether an argument string carries file bytes rather than text or a path.
+func isInlineData(s string) bool {
+	if strings.HasPrefix(s, "data:") {
+		return true
+	}
+	if len(s) < inlineDataMinLength || strings.ContainsAny(s, " \n") {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(s)
+	return err == nil
+}
+
+// hashReference is the stored form of a hashed value.
+func hashReference(data []byte) string {
+	return "sha256:" + sha256Hex(data)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordResponse extracts the text, output URIs and hashed media of a tool result.
+func recordResponse(result *mcp.CallToolResult) recordedResponse {
+	var response recordedResponse
+	for _, content := range result.Content {
+		switch c := content.(type) {
+		case mcp.TextContent:
+			response.Text = append(response.Text, c.Text)
+			for _, uri := range gcsURIPattern.FindAllString(c.Text, -1) {
+				response.URIs = append(response.URIs, strings.TrimRight(uri, ".:;)"))
+			}
+		case mcp.ImageContent:
+			response.Media = append(response.Media, recordedMedia{MIMEType: c.MIMEType, SHA256: sha256Hex([]byte(c.Data))})
+		case mcp.AudioContent:
+			response.Media = append(response.Media, recordedMedia{MIMEType: c.MIMEType, SHA256: sha256Hex([]byte(c.Data))})
+		}
+	}
+	return response
+}
+
+// recordedUsageKey is the context key of the token usage a handler reports for its record.
+type recordedUsageKey struct{}
+
+// noteTokenUsage reports the token usage of a response to the recorder wrapping the handler,
+// if any.
+func noteTokenUsage(ctx context.Context, metadata *genai.GenerateContentResponseUsageMetadata) {
+	slot, ok := ctx.Value(recordedUsageKey{}).(**tokenUsage)
+	if !ok || metadata == nil {
+		return
+	}
+	*slot = &tokenUsage{
+		PromptTokens:   int64(metadata.PromptTokenCount),
+		OutputTokens:   int64(metadata.CandidatesTokenCount),
+		ThoughtsTokens: int64(metadata.ThoughtsTokenCount),
+		TotalTokens:    int64(metadata.TotalTokenCount),
+	}
+}
+
+// withRecorder wraps a tool handler so each successful call is recorded. modelArg names the
+// argument holding the model, and defaultModel is used when it is not provided. The handler is
+// returned unchanged when recording is off.
+func withRecorder(r *generationRecorder, tool, modelArg, defaultModel string, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if r == nil {
+		return next
+	}
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var usage *tokenUsage
+		ctx = context.WithValue(ctx, recordedUsageKey{}, &usage)
+		result, err := next(ctx, request)
+		if err != nil || result == nil || result.IsError {
+			return result, err
+		}
+		model, _ := request.GetArguments()[modelArg].(string)
+		if strings.TrimSpace(model) == "" {
+			model = defaultModel
+		}
+		r.Record(generationRecord{
+			Timestamp: r.now().UTC(),
+			Tool:      tool,
+			Model:     model,
+			Arguments: r.sanitizeArguments(request.GetArguments()),
+			Response:  recordResponse(result),
+			Usage:     usage,
+		})
+		return result, nil
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// fakeRecordSink keeps the record objects it is given in memory.
+type fakeRecordSink struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	err     error
+	written chan string
+}
+
+func newFakeRecordSink() *fakeRecordSink {
+	return &fakeRecordSink{objects: make(map[string][]byte), written: make(chan string, 10)}
+}
+
+func (s *fakeRecordSink) Write(ctx context.Context, objectURI string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.objects[objectURI] = append([]byte(nil), data...)
+	s.written <- objectURI
+	return nil
+}
+
+// records decodes the records of an object.
+func (s *fakeRecordSink) records(t *testing.T, objectURI string) []generationRecord {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []generationRecord
+	for _, line := range bytes.Split(bytes.TrimSpace(s.objects[objectURI]), []byte("\n")) {
+		var record generationRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("%s has an invalid line %q: %v", objectURI, line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func testRecorderConfig() recorderConfig {
+	return recorderConfig{Prefix: "gs://eval-bucket/records", FlushInterval: time.Hour}
+}
+
+func TestRecorderFlushPartitionsByDay(t *testing.T) {
+	sink := newFakeRecordSink()
+	clock := &fakeClock{t: time.Date(2025, 9, 2, 0, 5, 0, 0, time.UTC)}
+	r := newGenerationRecorder(testRecorderConfig(), sink, clock.Now)
+
+	if n := r.Flush(context.Background()); n != 0 || len(sink.objects) != 0 {
+		t.Fatalf("expected an empty flush to write nothing, wrote %d records to %v", n, sink.objects)
+	}
+
+	r.Record(generationRecord{Timestamp: time.Date(2025, 9, 1, 23, 59, 0, 0, time.UTC), Tool: "gemini_image_generation"})
+	r.Record(generationRecord{Timestamp: time.Date(2025, 9, 2, 0, 1, 0, 0, time.UTC), Tool: "gemini_image_generation"})
+	r.Record(generationRecord{Timestamp: time.Date(2025, 9, 2, 0, 2, 0, 0, time.UTC), Tool: "gemini_audio_tts"})
+
+	if n := r.Flush(context.Background()); n != 3 {
+		t.Fatalf("expected 3 records to be written, got %d", n)
+	}
+	if len(sink.objects) != 2 {
+		t.Fatalf("expected one object per day, got %v", sink.objects)
+	}
+	for uri := range sink.objects {
+		switch {
+		case strings.HasPrefix(uri, "gs://eval-bucket/records/dt=2025-09-01/"):
+			if got := sink.records(t, uri); len(got) != 1 {
+				t.Errorf("expected 1 record on 2025-09-01, got %d", len(got))
+			}
+		case strings.HasPrefix(uri, "gs://eval-bucket/records/dt=2025-09-02/"):
+			if got := sink.records(t, uri); len(got) != 2 || got[1].Tool != "gemini_audio_tts" {
+				t.Errorf("expected the 2 records of 2025-09-02 in order, got %+v", got)
+			}
+		default:
+			t.Errorf("unexpected object %s", uri)
+		}
+		if !strings.HasSuffix(uri, ".jsonl") {
+			t.Errorf("expected a .jsonl object, got %s", uri)
+		}
+	}
+
+	if n := r.Flush(context.Background()); n != 0 {
+		t.Errorf("expected the buffer to be empty after a flush, wrote %d records", n)
+	}
+}
+
+func TestRecorderFlushesFullBatch(t *testing.T) {
+	sink := newFakeRecordSink()
+	r := newGenerationRecorder(testRecorderConfig(), sink, nil)
+	r.Start()
+
+	for i := 0; i < recordBatchSize; i++ {
+		r.Record(generationRecord{Timestamp: time.Now(), Tool: "gemini_image_generation"})
+	}
+	select {
+	case uri := <-sink.written:
+		if got := sink.records(t, uri); len(got) != recordBatchSize {
+			t.Errorf("expected a batch of %d records, got %d", recordBatchSize, len(got))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a full batch was not flushed before the timer")
+	}
+
+	r.Record(generationRecord{Timestamp: time.Now(), Tool: "gemini_audio_tts"})
+	r.Close(context.Background())
+	select {
+	case uri := <-sink.written:
+		if got := sink.records(t, uri); len(got) != 1 || got[0].Tool != "gemini_audio_tts" {
+			t.Errorf("expected Close to flush the last record, got %+v", got)
+		}
+	default:
+		t.Fatal("Close did not flush the buffered record")
+	}
+}
+
+func TestRecorderFlushesOnTimer(t *testing.T) {
+	sink := newFakeRecordSink()
+	cfg := testRecorderConfig()
+	cfg.FlushInterval = 10 * time.Millisecond
+	r := newGenerationRecorder(cfg, sink, nil)
+	r.Start()
+	defer r.Close(context.Background())
+
+	r.Record(generationRecord{Timestamp: time.Now(), Tool: "gemini_image_generation"})
+	select {
+	case <-sink.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the record was not flushed by the timer")
+	}
+}
+
+func TestRecorderWriteFailureDropsBatch(t *testing.T) {
+	sink := newFakeRecordSink()
+	sink.err = errors.New("permission denied")
+	r := newGenerationRecorder(testRecorderConfig(), sink, nil)
+
+	r.Record(generationRecord{Timestamp: time.Now(), Tool: "gemini_image_generation"})
+	if n := r.Flush(context.Background()); n != 0 {
+		t.Errorf("expected no records to be written, got %d", n)
+	}
+	sink.err = nil
+	if n := r.Flush(context.Background()); n != 0 {
+		t.Errorf("expected the failed batch to be dropped, not retried, got %d", n)
+	}
+}
+
+func TestRecorderSanitizeArguments(t *testing.T) {
+	cfg := testRecorderConfig()
+	cfg.RedactFields = []string{"prompt"}
+	r := newGenerationRecorder(cfg, newFakeRecordSink(), nil)
+	inline := strings.Repeat("iVBORw0KGgo", 40)
+
+	got := r.sanitizeArguments(map[string]interface{}{
+		"prompt":          "a portrait of our CEO",
+		"model":           "gemini-2.5-flash-image-preview",
+		"images":          []interface{}{"gs://in/cat.png", "data:image/png;base64,iVBORw0KGgo=", inline},
+		"thinking_budget": 128.0,
+	})
+
+	if got["prompt"] != hashReference([]byte(`"a portrait of our CEO"`)) {
+		t.Errorf("expected the redacted prompt to be hashed, got %v", got["prompt"])
+	}
+	if got["model"] != "gemini-2.5-flash-image-preview" || got["thinking_budget"] != 128.0 {
+		t.Errorf("expected the other arguments to be kept, got %v", got)
+	}
+	images := got["images"].([]interface{})
+	if images[0] != "gs://in/cat.png" {
+		t.Errorf("expected the image URI to be kept, got %v", images[0])
+	}
+	for i, image := range images[1:] {
+		if s, _ := image.(string); !strings.HasPrefix(s, "sha256:") || len(s) != len("sha256:")+64 {
+			t.Errorf("expected image %d's bytes to be replaced by a hash, got %v", i+1, image)
+		}
+	}
+}
+
+func TestWithRecorder(t *testing.T) {
+	sink := newFakeRecordSink()
+	r := newGenerationRecorder(testRecorderConfig(), sink, nil)
+	handler := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if request.GetArguments()["prompt"] == "fail" {
+			return mcp.NewToolResultError("blocked"), nil
+		}
+		noteTokenUsage(ctx, &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 12, CandidatesTokenCount: 1290, TotalTokenCount: 1302})
+		return &mcp.CallToolResult{Content: []mcp.Content{
+			mcp.TextContent{Type: "text", Text: "Uploaded 2 image(s) to gs://out/run: gs://out/run/a.png, gs://out/run/b.png"},
+			mcp.AudioContent{Type: "audio", Data: "UklGRg==", MIMEType: "audio/wav"},
+		}}, nil
+	}
+	wrapped := withRecorder(r, "gemini_image_generation", "model", defaultGeminiImageModel, handler)
+
+	for _, prompt := range []string{"fail", "a red fox"} {
+		if _, err := wrapped(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{"prompt": prompt}}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := r.Flush(context.Background()); n != 1 {
+		t.Fatalf("expected only the successful call to be recorded, got %d records", n)
+	}
+	var record generationRecord
+	for uri := range sink.objects {
+		record = sink.records(t, uri)[0]
+	}
+	if record.Model != defaultGeminiImageModel || record.Arguments["prompt"] != "a red fox" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if record.Usage == nil || record.Usage.PromptTokens != 12 || record.Usage.TotalTokens != 1302 {
+		t.Errorf("expected the token usage in the record, got %+v", record.Usage)
+	}
+	if len(record.Response.URIs) != 3 || record.Response.URIs[0] != "gs://out/run" || record.Response.URIs[2] != "gs://out/run/b.png" {
+		t.Errorf("expected the output URIs, got %v", record.Response.URIs)
+	}
+	if len(record.Response.Media) != 1 || record.Response.Media[0].SHA256 != sha256Hex([]byte("UklGRg==")) {
+		t.Errorf("expected the audio to be hashed, got %+v", record.Response.Media)
+	}
+
+	if withRecorder(nil, "gemini_audio_tts", "model_name", defaultGeminiTTSModel, handler) == nil {
+		t.Error("expected the handler to be returned when recording is off")
+	}
+}
+
+func TestLoadRecorderConfig(t *testing.T) {
+	t.Setenv(recordPrefixEnvVar, "gs://eval-bucket/records/")
+	t.Setenv(recordRedactEnvVar, "prompt, text ,")
+	t.Setenv(recordFlushIntervalEnvVar, "")
+	cfg, err := loadRecorderConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Prefix != "gs://eval-bucket/records" || len(cfg.RedactFields) != 2 || cfg.RedactFields[1] != "text" || cfg.FlushInterval != defaultRecordFlushInterval {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	t.Setenv(recordFlushIntervalEnvVar, "soon")
+	if _, err := loadRecorderConfig(); err == nil {
+		t.Error("expected an invalid flush interval to be rejected")
+	}
+	t.Setenv(recordFlushIntervalEnvVar, "")
+	t.Setenv(recordPrefixEnvVar, "eval-bucket/records")
+	if _, err := loadRecorderConfig(); err == nil {
+		t.Error("expected a prefix without gs:// to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"google.golang.org/genai"
+)
+
+func TestStreamingCallHonorsRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"code": 429, "message": "Resource has been exhausted", "status": "RESOURCE_EXHAUSTED"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"hello\"}]}}]}\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	common.HonorRetryAfter(client.ClientConfig().HTTPClient)
+
+	start := time.Now()
+	var text string
+	for resp, err := range client.Models.GenerateContentStream(context.Background(), "gemini-2.5-flash", genai.Text("hi"), nil) {
+		if err != nil {
+			t.Fatalf("expected the stream to succeed after the retry, got %v", err)
+		}
+		text += resp.Text()
+	}
+	elapsed := time.Since(start)
+
+	if text != "hello" || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected one retry and the streamed text, got %q after %d calls", text, calls)
+	}
+	if elapsed < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After: 1, but the stream finished in %v", elapsed)
+	}
+}
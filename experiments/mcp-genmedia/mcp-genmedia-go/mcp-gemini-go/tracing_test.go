@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/genai"
+)
+
+// recordSpans installs a recording tracer provider for the test and returns its recorder.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+	return recorder
+}
+
+// endedSpan returns the single ended span with the given name and its attributes.
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) (sdktrace.ReadOnlySpan, map[attribute.Key]attribute.Value) {
+	t.Helper()
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, kv := range span.Attributes() {
+				attrs[kv.Key] = kv.Value
+			}
+			return span, attrs
+		}
+	}
+	t.Fatalf("no ended span named %s", name)
+	return nil, nil
+}
+
+// newStubGeminiClient returns a client whose API calls are answered by handler.
+func newStubGeminiClient(t *testing.T, handler http.HandlerFunc) *genai.Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestGenerateContentSpanAttributes(t *testing.T) {
+	recorder := recordSpans(t)
+	client := newStubGeminiClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"candidates": [{"content": {"role": "model", "parts": [{"text": "Here it is."}, {"inlineData": {"mimeType": "image/png", "data": "iVBORw0KGgo="}}]}, "finishReason": "STOP"}],
+			"modelVersion": "gemini-2.5-flash-image-preview-001",
+			"usageMetadata": {"promptTokenCount": 7, "candidatesTokenCount": 1290, "totalTokenCount": 1297}
+		}`)
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"prompt": "a lighthouse", "model": "gemini-2.5-flash-image-preview", "output_directory": t.TempDir()}
+	result, err := geminiGenerateContentHandler(client, context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+
+	span, attrs := endedSpan(t, recorder, "gemini_generate_content")
+	if span.Status().Code == codes.Error {
+		t.Errorf("unexpected error status %+v", span.Status())
+	}
+	expected := map[attribute.Key]attribute.Value{
+		common.AttrRequestModel:       attribute.StringValue("gemini-2.5-flash-image-preview"),
+		common.AttrResponseModel:      attribute.StringValue("gemini-2.5-flash-image-preview-001"),
+		common.AttrInputTokens:        attribute.Int64Value(7),
+		common.AttrOutputTokens:       attribute.Int64Value(1290),
+		common.AttrTotalTokens:        attribute.Int64Value(1297),
+		common.AttrFinishReasons:      attribute.StringSliceValue([]string{"STOP"}),
+		common.AttrOutputBytes:        attribute.Int64Value(8),
+		common.AttrOutputDestinations: attribute.StringSliceValue([]string{common.OutputInline, common.OutputLocal}),
+	}
+	for key, want := range expected {
+		if got, ok := attrs[key]; !ok || got.Emit() != want.Emit() {
+			t.Errorf("%s = %q, want %q", key, got.Emit(), want.Emit())
+		}
+	}
+	if _, ok := attrs[common.AttrErrorType]; ok {
+		t.Errorf("unexpected error.type on a successful call: %v", attrs[common.AttrErrorType])
+	}
+}
+
+func TestGenerateContentSpanAPIError(t *testing.T) {
+	recorder := recordSpans(t)
+	client := newStubGeminiClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": 429, "message": "Resource has been exhausted", "status": "RESOURCE_EXHAUSTED"}}`)
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"prompt": "a lighthouse", "model": "gemini-2.5-flash"}
+	if result, _ := geminiGenerateContentHandler(client, context.Background(), request); !result.IsError {
+		t.Fatal("expected an error result")
+	}
+
+	span, attrs := endedSpan(t, recorder, "gemini_generate_content")
+	if span.Status().Code != codes.Error {
+		t.Errorf("expected an error status, got %+v", span.Status())
+	}
+	if attrs[common.AttrErrorType].AsString() != errorTypeQuota || attrs[common.AttrRequestModel].AsString() != "gemini-2.5-flash" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+	for _, key := range []attribute.Key{common.AttrResponseModel, common.AttrInputTokens, common.AttrOutputBytes} {
+		if _, ok := attrs[key]; ok {
+			t.Errorf("unexpected %s on a failed call", key)
+		}
+	}
+	if events := span.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded, got events %v", events)
+	}
+}
+
+func TestAudioTTSSpanAttributes(t *testing.T) {
+	recorder := recordSpans(t)
+	original := synthesizeGeminiTTS
+	t.Cleanup(func() { synthesizeGeminiTTS = original })
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"text": "Hello there", "voice_name": "Kore"}
+
+	synthesizeGeminiTTS = func(ctx context.Context, text, prompt, voiceName, languageCode, modelName string) ([]byte, error) {
+		return []byte("RIFF0000WAVE"), nil
+	}
+	if result, err := geminiAudioTTSHandler(context.Background(), request); err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %+v", err, result)
+	}
+	_, attrs := endedSpan(t, recorder, "gemini_audio_tts")
+	if attrs[common.AttrRequestModel].AsString() != defaultGeminiTTSModel || attrs[common.AttrOutputBytes].AsInt64() != 12 {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+	if got := attrs[common.AttrOutputDestinations].AsStringSlice(); len(got) != 1 || got[0] != common.OutputInline {
+		t.Errorf("expected the audio to be returned inline, got %v", got)
+	}
+
+	recorder.Reset()
+	synthesizeGeminiTTS = func(ctx context.Context, text, prompt, voiceName, languageCode, modelName string) ([]byte, error) {
+		apiErr, _ := parseAPIErrorBody(403, []byte(`{"error": {"message": "Permission denied", "status": "PERMISSION_DENIED"}}`))
+		return nil, fmt.Errorf("API request failed with status 403 Forbidden: %w", apiErr)
+	}
+	if result, _ := geminiAudioTTSHandler(context.Background(), request); !result.IsError {
+		t.Fatal("expected an error result")
+	}
+	span, attrs := endedSpan(t, recorder, "gemini_audio_tts")
+	if span.Status().Code != codes.Error || attrs[common.AttrErrorType].AsString() != errorTypePermissionDenied {
+		t.Errorf("expected a permission_denied failure, got %+v %v", span.Status(), attrs)
+	}
+}
+
+func TestGeminiErrorType(t *testing.T) {
+	testCases := map[string]error{
+		errorTypeServer:  genai.APIError{Code: 503, Message: "unavailable"},
+		errorTypeSafety:  &genai.APIError{Code: 400, Message: "blocked by safety filters"},
+		"timeout":        fmt.Errorf("call failed: %w", context.DeadlineExceeded),
+		"canceled":       context.Canceled,
+		"request_failed": fmt.Errorf("connection reset"),
+	}
+	for want, err := range testCases {
+		if got := geminiErrorType(err); got != want {
+			t.Errorf("geminiErrorType(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
@@ -5,16 +5,21 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
 	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -24,6 +29,8 @@ const (
 	defaultGeminiTTSModel        = "gemini-2.5-flash-preview-tts"
 	defaultGeminiTTSVoice        = "Callirrhoe"
 	timeFormatForTTSFilename     = "20060102-150405"
+	// defaultTTSStyleEnvVar holds the style prompt used when a request has none.
+	defaultTTSStyleEnvVar = "DEFAULT_TTS_STYLE"
 )
 
 // hardcoded list of voices based on documentation
@@ -60,15 +67,73 @@ var availableGeminiVoices = []string{
 	"Zubenelgenubi",
 }
 
-// geminiLanguageCodeMap holds the supported languages.
-var geminiLanguageCodeMap = map[string]string{
-	"english (united states)": "en-US",
+// geminiTTSLanguage is a language Gemini-TTS speaks, with the script its text is written in.
+type geminiTTSLanguage struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Script string `json:"script"`
+}
+
+// geminiTTSLanguages holds the languages Gemini-TTS supports, based on documentation. Every
+// voice speaks all of them.
+var geminiTTSLanguages = []geminiTTSLanguage{
+	{Code: "ar-EG", Name: "Arabic (Egypt)", Script: "Arabic"},
+	{Code: "bn-BD", Name: "Bangla (Bangladesh)", Script: "Bengali"},
+	{Code: "nl-NL", Name: "Dutch (Netherlands)", Script: "Latin"},
+	{Code: "en-IN", Name: "English (India)", Script: "Latin"},
+	{Code: "en-US", Name: "English (United States)", Script: "Latin"},
+	{Code: "fr-FR", Name: "French (France)", Script: "Latin"},
+	{Code: "de-DE", Name: "German (Germany)", Script: "Latin"},
+	{Code: "hi-IN", Name: "Hindi (India)", Script: "Devanagari"},
+	{Code: "id-ID", Name: "Indonesian (Indonesia)", Script: "Latin"},
+	{Code: "it-IT", Name: "Italian (Italy)", Script: "Latin"},
+	{Code: "ja-JP", Name: "Japanese (Japan)", Script: "Japanese"},
+	{Code: "ko-KR", Name: "Korean (South Korea)", Script: "Hangul"},
+	{Code: "mr-IN", Name: "Marathi (India)", Script: "Devanagari"},
+	{Code: "pl-PL", Name: "Polish (Poland)", Script: "Latin"},
+	{Code: "pt-BR", Name: "Portuguese (Brazil)", Script: "Latin"},
+	{Code: "ro-RO", Name: "Romanian (Romania)", Script: "Latin"},
+	{Code: "ru-RU", Name: "Russian (Russia)", Script: "Cyrillic"},
+	{Code: "es-ES", Name: "Spanish (Spain)", Script: "Latin"},
+	{Code: "ta-IN", Name: "Tamil (India)", Script: "Tamil"},
+	{Code: "te-IN", Name: "Telugu (India)", Script: "Telugu"},
+	{Code: "th-TH", Name: "Thai (Thailand)", Script: "Thai"},
+	{Code: "tr-TR", Name: "Turkish (Turkey)", Script: "Latin"},
+	{Code: "uk-UA", Name: "Ukrainian (Ukraine)", Script: "Cyrillic"},
+	{Code: "vi-VN", Name: "Vietnamese (Vietnam)", Script: "Latin"},
+}
+
+// defaultGeminiTTSLanguage is the language of requests without a language_code.
+const defaultGeminiTTSLanguage = "en-US"
+
+// findGeminiTTSLanguage returns the supported language with the given BCP-47 code, matched
+// case-insensitively.
+func findGeminiTTSLanguage(code string) (geminiTTSLanguage, bool) {
+	for _, language := range geminiTTSLanguages {
+		if strings.EqualFold(language.Code, strings.TrimSpace(code)) {
+			return language, true
+		}
+	}
+	return geminiTTSLanguage{}, false
+}
+
+// geminiTTSLanguageCodes returns the codes of the supported languages.
+func geminiTTSLanguageCodes() []string {
+	codes := make([]string, len(geminiTTSLanguages))
+	for i, language := range geminiTTSLanguages {
+		codes[i] = language.Code
+	}
+	return codes
 }
 
 // --- Resource Handler ---
 
 func geminiLanguageCodesHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
-	jsonData, err := json.MarshalIndent(geminiLanguageCodeMap, "", "  ")
+	languageCodeMap := make(map[string]string, len(geminiTTSLanguages))
+	for _, language := range geminiTTSLanguages {
+		languageCodeMap[strings.ToLower(language.Name)] = language.Code
+	}
+	jsonData, err := json.MarshalIndent(languageCodeMap, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal language codes: %w", err)
 	}
@@ -110,17 +175,28 @@ type geminiTTSResponse struct {
 
 // --- Tool Handlers ---
 
+// geminiVoiceInfo is an entry of the list_gemini_voices result.
+type geminiVoiceInfo struct {
+	Name          string   `json:"name"`
+	LanguageCodes []string `json:"language_codes"`
+}
+
 // listGeminiVoicesHandler handles the 'list_gemini_voices' tool request.
-// It returns a hardcoded list of available Gemini TTS voices.
+// It returns a hardcoded list of available Gemini TTS voices with the languages each speaks.
 func listGeminiVoicesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	log.Println("Handling list_gemini_voices request.")
 
-	voiceListJSON, err := json.MarshalIndent(availableGeminiVoices, "", "  ")
+	languageCodes := geminiTTSLanguageCodes()
+	voices := make([]geminiVoiceInfo, len(availableGeminiVoices))
+	for i, name := range availableGeminiVoices {
+		voices[i] = geminiVoiceInfo{Name: name, LanguageCodes: languageCodes}
+	}
+	voiceListJSON, err := json.MarshalIndent(voices, "", "  ")
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal voice list: %v", err)), nil
 	}
 
-	summary := fmt.Sprintf("Found %d available Gemini TTS voices.", len(availableGeminiVoices))
+	summary := fmt.Sprintf("Found %d available Gemini TTS voices, each speaking %d languages.", len(availableGeminiVoices), len(languageCodes))
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
@@ -130,20 +206,48 @@ func listGeminiVoicesHandler(ctx context.Context, request mcp.CallToolRequest) (
 	}, nil
 }
 
+// validateTTSArgs checks the gemini_audio_tts arguments that can be rejected before the
+// request is counted against a budget or sent.
+func validateTTSArgs(args map[string]interface{}) error {
+	if activeBackend.geminiAPI() {
+		return errors.New("gemini_audio_tts uses the Cloud Text-to-Speech API, which needs a Google Cloud project; it is not available on the Gemini API backend. Start the server with PROJECT_ID set to use it.")
+	}
+	text, ok := args["text"].(string)
+	if !ok || strings.TrimSpace(text) == "" {
+		return errors.New("text parameter must be a non-empty string and is required")
+	}
+	if len(text) > 800 {
+		return errors.New("text parameter cannot exceed 800 characters")
+	}
+	voiceName, _ := args["voice_name"].(string)
+	if voiceName != "" && !slices.Contains(availableGeminiVoices, voiceName) {
+		return fmt.Errorf("invalid voice_name '%s'. Use 'list_gemini_voices' to see available voices", voiceName)
+	}
+	languageCode, _ := args["language_code"].(string)
+	if strings.TrimSpace(languageCode) != "" {
+		if _, ok := findGeminiTTSLanguage(languageCode); !ok {
+			return fmt.Errorf("invalid language_code '%s'. Supported languages are: %s", languageCode, strings.Join(geminiTTSLanguageCodes(), ", "))
+		}
+	}
+	return nil
+}
+
 // geminiAudioTTSHandler handles the 'gemini_audio_tts' tool request.
 func geminiAudioTTSHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "gemini_audio_tts")
+	defer span.End()
+
 	log.Printf("Handling gemini_audio_tts request with arguments: %v", request.GetArguments())
 
 	// --- 1. Parse and Validate Arguments ---
-	text, ok := request.GetArguments()["text"].(string)
-	if !ok || strings.TrimSpace(text) == "" {
-		return mcp.NewToolResultError("text parameter must be a non-empty string and is required"), nil
-	}
-	if len(text) > 800 {
-		return mcp.NewToolResultError("text parameter cannot exceed 800 characters"), nil
+	if err := validateTTSArgs(request.GetArguments()); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
 	}
+	text, _ := request.GetArguments()["text"].(string)
 
 	prompt, _ := request.GetArguments()["prompt"].(string)
+	prompt, defaultStyle := ttsStylePrompt(prompt)
 
 	modelName, _ := request.GetArguments()["model_name"].(string)
 	if modelName == "" {
@@ -154,29 +258,55 @@ func geminiAudioTTSHandler(ctx context.Context, request mcp.CallToolRequest) (*m
 	if voiceName == "" {
 		voiceName = defaultGeminiTTSVoice
 	}
-	// Validate voice
-	validVoice := false
-	for _, v := range availableGeminiVoices {
-		if v == voiceName {
-			validVoice = true
-			break
-		}
+
+	languageCode, _ := request.GetArguments()["language_code"].(string)
+	language, _ := findGeminiTTSLanguage(defaultGeminiTTSLanguage)
+	if strings.TrimSpace(languageCode) != "" {
+		language, _ = findGeminiTTSLanguage(languageCode)
+		prompt = ttsLanguagePrompt(prompt, language)
 	}
-	if !validVoice {
-		return mcp.NewToolResultError(fmt.Sprintf("invalid voice_name '%s'. Use 'list_gemini_voices' to see available voices", voiceName)), nil
+	strictLanguage, _ := request.GetArguments()["strict_language"].(bool)
+	languageWarning := checkTTSTextLanguage(text, language)
+	if languageWarning != "" {
+		if strings.TrimSpace(languageCode) == "" {
+			languageWarning += fmt.Sprintf(" No language_code was given, so %s was assumed.", defaultGeminiTTSLanguage)
+		}
+		if strictLanguage {
+			return mcp.NewToolResultError(languageWarning + " Set language_code to the language of the text, or drop strict_language to synthesize anyway."), nil
+		}
+		log.Printf("gemini_audio_tts language mismatch: %s", languageWarning)
 	}
 
 	outputDir, _ := request.GetArguments()["output_directory"].(string)
+	if strings.TrimSpace(outputDir) != "" {
+		pathTokens := newOutputPathTokens(request.GetArguments(), "gemini_audio_tts", modelName, time.Now())
+		outputDir = pathTokens.expand(strings.TrimSpace(outputDir))
+	}
 	filenamePrefix, _ := request.GetArguments()["output_filename_prefix"].(string)
 	if filenamePrefix == "" {
 		filenamePrefix = "gemini_tts_audio"
 	}
 
+	span.SetAttributes(
+		attribute.String("voice_name", voiceName),
+		attribute.String("language_code", language.Code),
+		attribute.Bool("language_mismatch", languageWarning != ""),
+		attribute.Int("text_length", len(text)),
+		attribute.String("output_directory", outputDir),
+		attribute.Bool("default_style", defaultStyle),
+	)
+	spanInfo := common.GenerationSpanInfo{RequestedModel: modelName}
+
 	// --- 2. Call the TTS API ---
-	audioBytes, err := callGeminiTTSAPI(ctx, text, prompt, voiceName, modelName)
+	startTime := time.Now()
+	audioBytes, err := synthesizeGeminiTTS(ctx, text, prompt, voiceName, language.Code, modelName)
+	span.SetAttributes(attribute.Float64("duration_ms", float64(time.Since(startTime).Milliseconds())))
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("error calling Gemini TTS API: %v", err)), nil
+		common.SetGenerationSpanAttributes(span, spanInfo)
+		common.RecordSpanFailure(span, err, geminiErrorType(err))
+		return mcp.NewToolResultError(formatGeminiError("error calling Gemini TTS API", err)), nil
 	}
+	spanInfo.OutputBytes = int64(len(audioBytes))
 
 	// --- 3. Process the Audio Response ---
 	var contentItems []mcp.Content
@@ -199,7 +329,7 @@ func geminiAudioTTSHandler(ctx context.Context, request mcp.CallToolRequest) (*m
 				contentItems = append(contentItems, mcp.AudioContent{Type: "audio", Data: base64AudioData, MIMEType: "audio/wav"})
 			} else {
 				fileSaveMessage = fmt.Sprintf("Audio saved to: %s (%d bytes).", savedFilename, len(audioBytes))
-				log.Printf(fileSaveMessage)
+				log.Print(fileSaveMessage)
 			}
 		}
 	} else {
@@ -208,15 +338,45 @@ func geminiAudioTTSHandler(ctx context.Context, request mcp.CallToolRequest) (*m
 		fileSaveMessage = "Audio data is included in the response."
 	}
 
+	if len(contentItems) > 0 {
+		spanInfo.OutputDestinations = []string{common.OutputInline}
+	} else {
+		spanInfo.OutputDestinations = []string{common.OutputLocal}
+	}
+	common.SetGenerationSpanAttributes(span, spanInfo)
+
 	resultText := fmt.Sprintf("Speech synthesized successfully with voice %s. %s", voiceName, fileSaveMessage)
+	if defaultStyle {
+		resultText += fmt.Sprintf(" The default style from %s was applied.", defaultTTSStyleEnvVar)
+	}
+	if languageWarning != "" {
+		resultText += " Warning: " + languageWarning
+	}
 	contentItems = append([]mcp.Content{mcp.TextContent{Type: "text", Text: resultText}}, contentItems...)
 
 	return &mcp.CallToolResult{Content: contentItems}, nil
 }
 
+// ttsStylePrompt returns the request's style prompt, or the DEFAULT_TTS_STYLE prompt when the
+// request has none. defaulted reports whether the default was used.
+func ttsStylePrompt(prompt string) (style string, defaulted bool) {
+	if strings.TrimSpace(prompt) != "" {
+		return prompt, false
+	}
+	style = strings.TrimSpace(os.Getenv(defaultTTSStyleEnvVar))
+	if style == "" {
+		return prompt, false
+	}
+	log.Printf("No prompt given, applying the default style from %s: %q", defaultTTSStyleEnvVar, style)
+	return style, true
+}
+
 // --- API Helper Function ---
 
-func callGeminiTTSAPI(ctx context.Context, text, prompt, voiceName, modelName string) ([]byte, error) {
+// synthesizeGeminiTTS calls the TTS API; it is a variable so tests can avoid the API.
+var synthesizeGeminiTTS = callGeminiTTSAPI
+
+func callGeminiTTSAPI(ctx context.Context, text, prompt, voiceName, languageCode, modelName string) ([]byte, error) {
 	// --- 1. Get Project ID from environment ---
 	projectID := os.Getenv("PROJECT_ID")
 	if projectID == "" {
@@ -230,9 +390,9 @@ func callGeminiTTSAPI(ctx context.Context, text, prompt, voiceName, modelName st
 		return nil, fmt.Errorf("failed to create token source: %w", err)
 	}
 	client := &http.Client{
-		Transport: &oauth2.Transport{
+		Transport: common.NewRetryAfterTransport(&oauth2.Transport{
 			Source: tokenSource,
-		},
+		}),
 		Timeout: 30 * time.Second,
 	}
 
@@ -243,7 +403,7 @@ func callGeminiTTSAPI(ctx context.Context, text, prompt, voiceName, modelName st
 			Prompt: prompt,
 		},
 		Voice: geminiTTSVoiceParams{
-			LanguageCode: "en-US", // Currently only en-US is supported
+			LanguageCode: languageCode,
 			Name:         voiceName,
 			ModelName:    modelName,
 		},
@@ -270,7 +430,7 @@ func callGeminiTTSAPI(ctx context.Context, text, prompt, voiceName, modelName st
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-goog-user-project", projectID)
 
-	log.Printf("Sending Gemini TTS request to %s with model %s and voice %s", geminiTTSAPIEndpoint, modelName, voiceName)
+	log.Printf("Sending Gemini TTS request to %s with model %s, voice %s and language %s", geminiTTSAPIEndpoint, modelName, voiceName, languageCode)
 
 	resp, err := client.Do(httpReq)
 	if err != nil {
@@ -281,6 +441,9 @@ func callGeminiTTSAPI(ctx context.Context, text, prompt, voiceName, modelName st
 	// --- 5. Process the Response ---
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
+		if apiErr, ok := parseAPIErrorBody(resp.StatusCode, bodyBytes); ok {
+			return nil, fmt.Errorf("API request failed with status %s: %w", resp.Status, apiErr)
+		}
 		return nil, fmt.Errorf("API request failed with status %s: %s", resp.Status, string(bodyBytes))
 	}
 
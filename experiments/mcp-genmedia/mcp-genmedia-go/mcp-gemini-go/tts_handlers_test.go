@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestAudioTTSDefaultStyle(t *testing.T) {
+	original := synthesizeGeminiTTS
+	t.Cleanup(func() { synthesizeGeminiTTS = original })
+	var sentPrompt string
+	synthesizeGeminiTTS = func(ctx context.Context, text, prompt, voiceName, languageCode, modelName string) ([]byte, error) {
+		sentPrompt = prompt
+		return []byte("RIFF0000WAVE"), nil
+	}
+	tts := func(args map[string]interface{}) string {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := geminiAudioTTSHandler(context.Background(), request)
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error: %v %+v", err, result)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	t.Setenv(defaultTTSStyleEnvVar, "")
+	if text := tts(map[string]interface{}{"text": "Welcome back"}); sentPrompt != "" || strings.Contains(text, defaultTTSStyleEnvVar) {
+		t.Errorf("expected no style without %s, got %q: %s", defaultTTSStyleEnvVar, sentPrompt, text)
+	}
+
+	t.Setenv(defaultTTSStyleEnvVar, " Warm and upbeat, like a friendly host. ")
+	if text := tts(map[string]interface{}{"text": "Welcome back", "prompt": "  "}); sentPrompt != "Warm and upbeat, like a friendly host." || !strings.Contains(text, "The default style from DEFAULT_TTS_STYLE was applied.") {
+		t.Errorf("expected the default style to be applied, got %q: %s", sentPrompt, text)
+	}
+
+	if text := tts(map[string]interface{}{"text": "Welcome back", "prompt": "Whisper it."}); sentPrompt != "Whisper it." || strings.Contains(text, defaultTTSStyleEnvVar) {
+		t.Errorf("expected the request's prompt to win, got %q: %s", sentPrompt, text)
+	}
+}
+
+func TestAudioTTSLanguage(t *testing.T) {
+	original := synthesizeGeminiTTS
+	t.Cleanup(func() { synthesizeGeminiTTS = original })
+	t.Setenv(defaultTTSStyleEnvVar, "")
+	var sentPrompt, sentLanguage string
+	calls := 0
+	synthesizeGeminiTTS = func(ctx context.Context, text, prompt, voiceName, languageCode, modelName string) ([]byte, error) {
+		calls++
+		sentPrompt, sentLanguage = prompt, languageCode
+		return []byte("RIFF0000WAVE"), nil
+	}
+	tts := func(args map[string]interface{}) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := geminiAudioTTSHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+	const spanish = "Hola, ¿cómo está usted? Esta es una prueba de la voz para el anuncio."
+
+	result := tts(map[string]interface{}{"text": spanish, "language_code": "es-MX"})
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "invalid language_code 'es-MX'") || !strings.Contains(text, "es-ES") || calls != 0 {
+		t.Errorf("expected an unsupported language_code to be rejected, got %q", text)
+	}
+
+	result = tts(map[string]interface{}{"text": spanish, "language_code": "es-es", "prompt": "Read it warmly."})
+	if text := result.Content[0].(mcp.TextContent).Text; result.IsError || strings.Contains(text, "Warning") {
+		t.Errorf("expected matching text to be synthesized without a warning, got %q", text)
+	}
+	if sentLanguage != "es-ES" || sentPrompt != "Speak in Spanish (Spain). Read it warmly." {
+		t.Errorf("expected the language in the request and prompt, got %q, %q", sentLanguage, sentPrompt)
+	}
+
+	result = tts(map[string]interface{}{"text": spanish})
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !strings.Contains(text, "Warning: the text looks like Spanish, but language_code is en-US") || !strings.Contains(text, "No language_code was given") {
+		t.Errorf("expected a mismatch warning, got %q", text)
+	}
+	if sentLanguage != "en-US" || sentPrompt != "" {
+		t.Errorf("expected the default language without a language prompt, got %q, %q", sentLanguage, sentPrompt)
+	}
+
+	calls = 0
+	result = tts(map[string]interface{}{"text": "Привет, это проверка голоса.", "language_code": "en-US", "strict_language": true})
+	text = result.Content[0].(mcp.TextContent).Text
+	if !result.IsError || calls != 0 || !strings.Contains(text, "mostly in Cyrillic script, but language_code en-US") || !strings.Contains(text, "drop strict_language") {
+		t.Errorf("expected strict_language to reject the mismatch before synthesis, got %q", text)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ttsLanguagePrompt prepends the delivery language to a style prompt, so instructions written
+// in one language do not pull the prosody of text in another towards their own.
+func ttsLanguagePrompt(prompt string, language geminiTTSLanguage) string {
+	instruction := fmt.Sprintf("Speak in %s.", language.Name)
+	if strings.TrimSpace(prompt) == "" {
+		return instruction
+	}
+	return instruction + " " + prompt
+}
+
+// scriptTables maps the scripts of geminiTTSLanguages to their Unicode ranges. Japanese text
+// mixes kana and kanji, so all three count towards it.
+var scriptTables = map[string][]*unicode.RangeTable{
+	"Latin":      {unicode.Latin},
+	"Arabic":     {unicode.Arabic},
+	"Bengali":    {unicode.Bengali},
+	"Devanagari": {unicode.Devanagari},
+	"Japanese":   {unicode.Hiragana, unicode.Katakana, unicode.Han},
+	"Hangul":     {unicode.Hangul},
+	"Cyrillic":   {unicode.Cyrillic},
+	"Tamil":      {unicode.Tamil},
+	"Telugu":     {unicode.Telugu},
+	"Thai":       {unicode.Thai},
+}
+
+// dominantScript returns the script most of the letters of text are written in, or "" when no
+// script holds a majority of them or the text has too few letters to tell.
+func dominantScript(text string) string {
+	counts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for script, tables := range scriptTables {
+			if unicode.IsOneOf(tables, r) {
+				counts[script]++
+				break
+			}
+		}
+	}
+	if letters < 4 {
+		return ""
+	}
+	for script, count := range counts {
+		if count*2 > letters {
+			return script
+		}
+	}
+	return ""
+}
+
+// latinStopWords are frequent words of the Latin-script languages that are common enough to
+// tell them apart in a sentence or two. Languages without a list are only checked by script.
+var latinStopWords = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "with", "you", "this", "that", "what", "was", "for", "have", "your"},
+	"es": {"el", "los", "las", "y", "está", "con", "para", "una", "por", "del", "pero", "muy", "es", "qué", "cómo", "usted"},
+	"fr": {"le", "les", "des", "est", "et", "une", "avec", "pour", "dans", "pas", "vous", "nous", "je", "sur", "qui"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "ich", "sie", "auf", "für", "wir", "zu"},
+	"it": {"il", "gli", "che", "è", "di", "un", "per", "sono", "non", "della", "questo", "anche", "come", "ma"},
+	"pt": {"os", "não", "você", "uma", "com", "para", "do", "da", "um", "é", "são", "mas", "muito", "isso"},
+	"nl": {"het", "een", "en", "van", "niet", "met", "voor", "op", "ik", "je", "dat", "zijn", "wij", "maar"},
+}
+
+// latinLanguageNames names the languages of latinStopWords in warnings.
+var latinLanguageNames = map[string]string{
+	"en": "English", "es": "Spanish", "fr": "French", "de": "German", "it": "Italian", "pt": "Portuguese", "nl": "Dutch",
+}
+
+// detectLatinLanguage returns the base language (e.g. "es") whose stop words text uses most, or
+// "" when the text has too few of them or no language clearly leads. A lead needs three hits and
+// twice the hits of the runner-up, since languages such as Spanish and Portuguese share words.
+func detectLatinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	hits := map[string]int{}
+	for _, word := range words {
+		for language, stopWords := range latinStopWords {
+			for _, stopWord := range stopWords {
+				if word == stopWord {
+					hits[language]++
+					break
+				}
+			}
+		}
+	}
+	best, bestHits, runnerUpHits := "", 0, 0
+	for language, count := range hits {
+		if count > bestHits {
+			best, bestHits, runnerUpHits = language, count, bestHits
+		} else if count > runnerUpHits {
+			runnerUpHits = count
+		}
+	}
+	if bestHits < 3 || bestHits < 2*runnerUpHits {
+		return ""
+	}
+	return best
+}
+
+// checkTTSTextLanguage cross-checks text against the language it is to be spoken in, and
+// returns a warning describing the mismatch, or "" when the text looks right or its language
+// cannot be told. Text in another script is always a mismatch; Latin-script text is also
+// compared by stop words when both languages have a list.
+func checkTTSTextLanguage(text string, language geminiTTSLanguage) string {
+	script := dominantScript(text)
+	if script == "" {
+		return ""
+	}
+	if script != language.Script {
+		return fmt.Sprintf("the text is mostly in %s script, but language_code %s (%s) is written in %s script.", script, language.Code, language.Name, language.Script)
+	}
+	if script != "Latin" {
+		return ""
+	}
+	base, _, _ := strings.Cut(language.Code, "-")
+	if _, ok := latinStopWords[base]; !ok {
+		return ""
+	}
+	if detected := detectLatinLanguage(text); detected != "" && detected != base {
+		return fmt.Sprintf("the text looks like %s, but language_code is %s (%s).", latinLanguageNames[detected], language.Code, language.Name)
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestCheckTTSTextLanguage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		code     string
+		mismatch bool
+	}{
+		{"english", "Welcome back to the show, and thank you for listening.", "en-US", false},
+		{"spanish as english", "Bienvenidos de nuevo, y gracias por estar con nosotros para el programa.", "en-US", true},
+		{"english as spanish", "This is the news for today, and you are listening to the show.", "es-ES", true},
+		{"german", "Das ist nicht die Stimme, die ich mit dir auf der Bühne hören will.", "de-DE", false},
+		{"too few stop words", "Great product launch.", "fr-FR", false},
+		{"polish is only checked by script", "Dzień dobry, to jest test głosu.", "pl-PL", false},
+		{"japanese", "こんにちは、音声のテストです。", "ja-JP", false},
+		{"japanese as korean", "こんにちは、音声のテストです。", "ko-KR", true},
+		{"hindi", "नमस्ते, यह आवाज़ का परीक्षण है।", "hi-IN", false},
+		{"cyrillic as english", "Привет, это проверка голоса.", "en-US", true},
+		{"latin as arabic", "Welcome back to the show.", "ar-EG", true},
+		{"numbers only", "2025 10 17", "th-TH", false},
+	}
+	for _, tc := range testCases {
+		language, ok := findGeminiTTSLanguage(tc.code)
+		if !ok {
+			t.Fatalf("%s: unsupported test language %s", tc.name, tc.code)
+		}
+		if warning := checkTTSTextLanguage(tc.text, language); (warning != "") != tc.mismatch {
+			t.Errorf("%s: expected mismatch %v, got warning %q", tc.name, tc.mismatch, warning)
+		}
+	}
+}
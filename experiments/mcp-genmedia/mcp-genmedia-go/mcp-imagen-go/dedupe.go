@@ -0,0 +1,126 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-imagen-go/internal/imagehash"
+	"google.golang.org/genai"
+)
+
+// gcsImageReader reads generated images stored in GCS so they can be hashed. It is a variable so
+// tests can avoid calling GCS.
+var gcsImageReader = common.DownloadFromGCSAsBytes
+
+// parseDedupeThreshold reads the optional dedupe_threshold argument; 0 turns deduplication off.
+func parseDedupeThreshold(args map[string]interface{}) (float64, error) {
+	raw, ok := args["dedupe_threshold"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	threshold, ok := raw.(float64)
+	if !ok {
+		return 0, fmt.Errorf("dedupe_threshold must be a number, got %T", raw)
+	}
+	if threshold < 0 || threshold > 1 {
+		return 0, fmt.Errorf("dedupe_threshold must be between 0 and 1, got %g", threshold)
+	}
+	return threshold, nil
+}
+
+// duplicateImage is a generated image dropped because it looks like an earlier one.
+type duplicateImage struct {
+	Index     int // position in the API response
+	KeptIndex int // the earlier image it duplicates
+	Distance  int // Hamming distance between their hashes, out of imagehash.Bits
+}
+
+// findDuplicateImages hashes each image and marks it as a duplicate when its hash differs from an
+// earlier kept image's in less than threshold of imagehash.Bits. Images that cannot be read or
+// decoded are kept and never compared. The result maps the index of each dropped image to the
+// image it duplicates.
+func findDuplicateImages(ctx context.Context, images []*genai.GeneratedImage, threshold float64) map[int]duplicateImage {
+	duplicates := make(map[int]duplicateImage)
+	type keptHash struct {
+		index int
+		hash  imagehash.Hash
+	}
+	var kept []keptHash
+	for n, genImg := range images {
+		data, err := generatedImageBytes(ctx, genImg)
+		if err != nil {
+			log.Printf("Not deduplicating image %d: %v", n, err)
+			continue
+		}
+		hash, err := imagehash.Decode(data)
+		if err != nil {
+			log.Printf("Not deduplicating image %d: %v", n, err)
+			continue
+		}
+		duplicate := false
+		for _, k := range kept {
+			d := imagehash.Distance(hash, k.hash)
+			if float64(d) < threshold*imagehash.Bits {
+				log.Printf("Image %d is a near-duplicate of image %d (distance %d/%d)", n, k.index, d, imagehash.Bits)
+				duplicates[n] = duplicateImage{Index: n, KeptIndex: k.index, Distance: d}
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, keptHash{index: n, hash: hash})
+		}
+	}
+	return duplicates
+}
+
+// generatedImageBytes returns the encoded image, reading it from GCS when the API stored it there.
+func generatedImageBytes(ctx context.Context, genImg *genai.GeneratedImage) ([]byte, error) {
+	switch {
+	case genImg == nil || genImg.Image == nil:
+		return nil, fmt.Errorf("no image was returned")
+	case genImg.Image.GCSURI != "":
+		readCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		defer cancel()
+		return gcsImageReader(readCtx, genImg.Image.GCSURI)
+	case len(genImg.Image.ImageBytes) > 0:
+		return genImg.Image.ImageBytes, nil
+	}
+	return nil, fmt.Errorf("image has no GCS URI and no data")
+}
+
+// describeDuplicates reports the dropped images in index order.
+func describeDuplicates(duplicates map[int]duplicateImage, threshold float64, count int, storedInGCS bool) string {
+	var parts []string
+	for n := 0; n < count; n++ {
+		if d, ok := duplicates[n]; ok {
+			parts = append(parts, fmt.Sprintf("image %d (distance %d/%d from image %d)", d.Index, d.Distance, imagehash.Bits, d.KeptIndex))
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("No near-duplicate images were found at dedupe_threshold %g.", threshold)
+	}
+	text := fmt.Sprintf("Dropped %d near-duplicate image(s) at dedupe_threshold %g: %s.", len(parts), threshold, strings.Join(parts, ", "))
+	if storedInGCS {
+		text += " Dropped images are not deleted from GCS."
+	}
+	return text
+}
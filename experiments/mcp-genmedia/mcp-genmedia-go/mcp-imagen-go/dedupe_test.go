@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// rampPNG encodes a horizontal grayscale ramp; noise brightens every other pixel slightly.
+func rampPNG(t *testing.T, noise uint8) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			v := uint8(200 - x*200/128)
+			if (x+y)%2 == 0 {
+				v += noise
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// stripesPNG encodes vertical stripes, which look nothing like a ramp.
+func stripesPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x / 7 % 2) * 255)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImagenGenerationHandlerDedupe(t *testing.T) {
+	objects := map[string][]byte{
+		"gs://bucket/out/attempt1-sample1.png": rampPNG(t, 0),
+		"gs://bucket/out/attempt1-sample2.png": rampPNG(t, 5),
+		"gs://bucket/out/attempt1-sample3.png": stripesPNG(t),
+		"gs://bucket/out/attempt1-sample4.png": rampPNG(t, 0),
+	}
+	originalReader := gcsImageReader
+	gcsImageReader = func(ctx context.Context, gcsURI string) ([]byte, error) {
+		data, ok := objects[gcsURI]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", gcsURI)
+		}
+		return data, nil
+	}
+	t.Cleanup(func() { gcsImageReader = originalReader })
+
+	args := map[string]interface{}{
+		"prompt":           "a lighthouse",
+		"num_images":       4.0,
+		"gcs_bucket_uri":   "gs://bucket/out/",
+		"dedupe_threshold": 0.1,
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = args
+	result, err := imagenGenerationHandler(&stubImagenModels{}, context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+
+	for _, kept := range []string{"attempt1-sample1.png", "attempt1-sample3.png"} {
+		if !strings.Contains(text, kept) {
+			t.Errorf("expected %s to be kept: %s", kept, text)
+		}
+	}
+	for _, dropped := range []string{"attempt1-sample2.png", "attempt1-sample4.png"} {
+		if strings.Contains(text, dropped) {
+			t.Errorf("expected %s to be dropped: %s", dropped, text)
+		}
+	}
+	for _, want := range []string{
+		"Generated 2 image(s)",
+		"Dropped 2 near-duplicate image(s) at dedupe_threshold 0.1: image 1 (distance ",
+		"image 3 (distance 0/64 from image 0)",
+		"Dropped images are not deleted from GCS.",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("result does not contain %q: %s", want, text)
+		}
+	}
+
+	// without a threshold every image is kept
+	delete(args, "dedupe_threshold")
+	result, err = imagenGenerationHandler(&stubImagenModels{}, context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Generated 4 image(s)") || strings.Contains(text, "near-duplicate") {
+		t.Errorf("expected all 4 images without dedupe_threshold: %s", text)
+	}
+}
+
+func TestFindDuplicateImagesKeepsUnreadableImages(t *testing.T) {
+	originalReader := gcsImageReader
+	gcsImageReader = func(ctx context.Context, gcsURI string) ([]byte, error) {
+		return nil, fmt.Errorf("permission denied")
+	}
+	t.Cleanup(func() { gcsImageReader = originalReader })
+
+	images := []*genai.GeneratedImage{
+		{Image: &genai.Image{ImageBytes: rampPNG(t, 0)}},
+		{Image: &genai.Image{ImageBytes: []byte("not an image")}},
+		{Image: &genai.Image{ImageBytes: rampPNG(t, 0)}},
+		{Image: &genai.Image{GCSURI: "gs://bucket/out/unreadable.png"}},
+		{},
+	}
+
+	duplicates := findDuplicateImages(context.Background(), images, 0.1)
+	if len(duplicates) != 1 || duplicates[2].KeptIndex != 0 || duplicates[2].Distance != 0 {
+		t.Errorf("expected only image 2 to be dropped as a copy of image 0, got %+v", duplicates)
+	}
+}
+
+func TestDedupeThresholdValidation(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{"dedupe_threshold": 1.5},
+		{"dedupe_threshold": -0.1},
+		{"dedupe_threshold": "high"},
+		{"dedupe_threshold": 0.1, "verify_text": true, "render_text": map[string]interface{}{"text": "OPEN"}},
+	} {
+		request := mcp.CallToolRequest{}
+		arguments := map[string]interface{}{"prompt": "a sign", "gcs_bucket_uri": "gs://bucket/out/"}
+		for k, v := range args {
+			arguments[k] = v
+		}
+		request.Params.Arguments = arguments
+		models := &stubImagenModels{}
+		result, err := imagenGenerationHandler(models, context.Background(), request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if text := result.Content[0].(mcp.TextContent).Text; !strings.HasPrefix(text, "Error: ") || models.generateCalls != 0 {
+			t.Errorf("%v: expected an error before generating, got %q", args, text)
+		}
+	}
+}
@@ -51,11 +51,11 @@ func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	flag.StringVar(&transport, "t", "stdio", "Transport type (stdio, sse, or http)")
 	flag.StringVar(&transport, "transport", "stdio", "Transport type (stdio, sse, or http)")
-	flag.Parse()
 }
 
 // main is the entry point for the mcp-imagen-go service.
 func main() {
+	flag.Parse()
 	appConfig = common.LoadConfig()
 
 	tp, err := common.InitTracerProvider(serviceName, version)
@@ -86,10 +86,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating global GenAI client: %v", err)
 	}
+	// Wait out 429/503 responses that carry Retry-After, for unary and streaming calls alike.
+	common.HonorRetryAfter(genAIClient.ClientConfig().HTTPClient)
 	log.Printf("Global GenAI client initialized successfully.")
 
 		s := server.NewMCPServer("Imagen", version, server.WithResourceCapabilities(true, true))
 	registerImagenEditingTools(s, genAIClient, appConfig)
+	registerImagenBatchTool(s, genAIClient)
 
 	tool := mcp.NewTool("imagen_t2i",
 		mcp.WithDescription("Generates an image based on a text prompt using Google's Imagen models. The image can be returned as base64 data, saved to a local directory, or stored in a Google Cloud Storage bucket."),
@@ -110,10 +113,33 @@ func main() {
 		),
 		mcp.WithString("gcs_bucket_uri", mcp.Description("Optional. GCS URI prefix to store the generated images (e.g., your-bucket/outputs/ or gs://your-bucket/outputs/).")),
 		mcp.WithString("output_directory", mcp.Description("Optional. Local directory to save the generated image(s) to.")),
+		mcp.WithObject("render_text",
+			mcp.Description(fmt.Sprintf("Optional. Text that must appear in the image. It is added to the prompt with a template that quotes it and asks for legible lettering. Keep it short: Imagen renders up to %d characters most reliably.", recommendedRenderTextLength)),
+			mcp.Properties(map[string]any{
+				"text":          map[string]any{"type": "string", "description": "The exact text to render."},
+				"language_hint": map[string]any{"type": "string", "description": "Optional. Language or script of the text, e.g. \"Japanese\"."},
+				"position_hint": map[string]any{"type": "string", "description": "Optional. Where to place the text, e.g. \"across the top in large letters\"."},
+			}),
+		),
+		mcp.WithBoolean("verify_text",
+			mcp.DefaultBool(false),
+			mcp.Description("Optional. Requires render_text. After generation, ask Gemini whether each image contains the exact text, and generate again when none does. All attempts' images are kept and the outcome is reported per image."),
+		),
+		mcp.WithNumber("max_attempts",
+			mcp.DefaultNumber(defaultTextRenderAttempts),
+			mcp.Min(1),
+			mcp.Max(maxTextRenderAttempts),
+			mcp.Description(fmt.Sprintf("Optional. With verify_text, the maximum number of generation attempts (1-%d). Defaults to %d.", maxTextRenderAttempts, defaultTextRenderAttempts)),
+		),
+		mcp.WithNumber("dedupe_threshold",
+			mcp.Min(0),
+			mcp.Max(1),
+			mcp.Description("Optional. Drop images that look like an earlier image of the same response. Each image gets a 64-bit perceptual hash; an image whose hash differs from a kept image's in less than this fraction of the bits is dropped, and the dropped indices are reported. 0.1 catches near-duplicates. Defaults to 0 (off). Cannot be combined with verify_text."),
+		),
 	)
 
 	handlerWithClient := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		return imagenGenerationHandler(genAIClient, ctx, request)
+		return imagenGenerationHandler(genAIClient.Models, ctx, request)
 	}
 		s.AddTool(tool, handlerWithClient)
 
@@ -142,7 +168,7 @@ func main() {
 		toolRequest := mcp.CallToolRequest{
 			Params:   mcp.CallToolParams{Arguments: args},
 		}
-		result, err := imagenGenerationHandler(genAIClient, ctx, toolRequest)
+		result, err := imagenGenerationHandler(genAIClient.Models, ctx, toolRequest)
 		if err != nil {
 			return nil, err
 		}
@@ -214,17 +240,17 @@ type ImagenOutput struct {
 	Message      string   `json:"message"`
 }
 
-func imagenGenerationHandler(client *genai.Client, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	tr := otel.Tracer(serviceName)
-	ctx, span := tr.Start(ctx, "imagen_t2i")
-	defer span.End()
-
-	prompt, ok := request.GetArguments()["prompt"].(string)
-	if !ok {
-		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: "Error: prompt must be a string and is required"}}}, nil
-	}
+// imageGenerator is the part of the GenAI client used for text-to-image generation.
+// *genai.Models satisfies it; tests substitute a stub.
+type imageGenerator interface {
+	GenerateImages(ctx context.Context, model, prompt string, config *genai.GenerateImagesConfig) (*genai.GenerateImagesResponse, error)
+}
 
-	modelInput, ok := request.GetArguments()["model"].(string)
+// resolveImagenGenerationParams reads the model, num_images and aspect_ratio arguments shared by
+// the generation tools, applying defaults and the model's image limit. A non-empty errMsg means
+// the arguments are invalid.
+func resolveImagenGenerationParams(args map[string]interface{}) (model string, numberOfImages int32, aspectRatio string, errMsg string) {
+	modelInput, ok := args["model"].(string)
 	if !ok || modelInput == "" {
 		log.Printf("Model not provided or empty, using default: imagen-3.0-generate-002")
 		modelInput = "imagen-3.0-generate-002"
@@ -232,13 +258,13 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 
 	canonicalName, found := common.ResolveImagenModel(modelInput)
 	if !found {
-		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: fmt.Sprintf("Error: Model '%s' is not a valid or supported model name.", modelInput)}}}, nil
+		return "", 0, "", fmt.Sprintf("Error: Model '%s' is not a valid or supported model name.", modelInput)
 	}
-	model := canonicalName
+	model = canonicalName
 	modelDetails := common.SupportedImagenModels[model]
 
-	var numberOfImages int32 = 1
-	numImagesArg, ok := request.GetArguments()["num_images"].(interface{})
+	numberOfImages = 1
+	numImagesArg, ok := args["num_images"].(interface{})
 	if ok {
 		if numImagesFloat, okFloat := numImagesArg.(float64); okFloat {
 			numberOfImages = int32(numImagesFloat)
@@ -255,11 +281,90 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 		numberOfImages = modelDetails.MaxImages
 	}
 
-	aspectRatio, ok := request.GetArguments()["aspect_ratio"].(string)
+	aspectRatio, ok = args["aspect_ratio"].(string)
 	if !ok || aspectRatio == "" {
 		log.Printf("Aspect ratio not provided or empty, using default: 1:1")
 		aspectRatio = "1:1"
 	}
+	return model, numberOfImages, aspectRatio, ""
+}
+
+// generateImagesWithTimeout calls GenerateImages with a 3 minute limit. The returned error
+// carries a user-facing message that distinguishes timeouts and cancellation from API failures.
+func generateImagesWithTimeout(ctx context.Context, generator imageGenerator, model, prompt string, config *genai.GenerateImagesConfig) (*genai.GenerateImagesResponse, time.Duration, error) {
+	apiCallCtx, apiCallCancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer apiCallCancel()
+
+	log.Printf("Calling GenerateImages with Model: %s, Prompt: \"%s\". API call timeout: 3m", model, prompt)
+	startTime := time.Now()
+
+	response, err := generator.GenerateImages(
+		apiCallCtx,
+		model,
+		prompt,
+		config,
+	)
+
+	apiCallDuration := time.Since(startTime)
+	log.Printf("GenerateImages call took: %v", apiCallDuration)
+
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && apiCallCtx.Err() == context.DeadlineExceeded {
+			log.Printf("GenerateImages failed due to API call timeout (3 minutes): %v", err)
+			return nil, apiCallDuration, fmt.Errorf("image generation timed out")
+		} else if errors.Is(err, context.Canceled) {
+			log.Printf("GenerateImages failed due to context cancellation: %v", err)
+			return nil, apiCallDuration, fmt.Errorf("image generation was canceled")
+		}
+		log.Printf("Error generating images (API call failed): %v", err)
+		return nil, apiCallDuration, fmt.Errorf("error generating images: %v", err.Error())
+	}
+	return response, apiCallDuration, nil
+}
+
+func imagenGenerationHandler(models imagenModels, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "imagen_t2i")
+	defer span.End()
+
+	prompt, ok := request.GetArguments()["prompt"].(string)
+	if !ok {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: "Error: prompt must be a string and is required"}}}, nil
+	}
+
+	model, numberOfImages, aspectRatio, errMsg := resolveImagenGenerationParams(request.GetArguments())
+	if errMsg != "" {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: errMsg}}}, nil
+	}
+
+	renderText, err := parseRenderText(request.GetArguments())
+	if err != nil {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: "Error: " + err.Error()}}}, nil
+	}
+	verifyText, _ := request.GetArguments()["verify_text"].(bool)
+	if verifyText && renderText == nil {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: "Error: verify_text requires render_text"}}}, nil
+	}
+	maxAttempts := defaultTextRenderAttempts
+	if n, ok := request.GetArguments()["max_attempts"].(float64); ok {
+		maxAttempts = int(n)
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if maxAttempts > maxTextRenderAttempts {
+		maxAttempts = maxTextRenderAttempts
+	}
+	dedupeThreshold, err := parseDedupeThreshold(request.GetArguments())
+	if err != nil {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: "Error: " + err.Error()}}}, nil
+	}
+	if verifyText && dedupeThreshold > 0 {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent{Type: "text", Text: "Error: dedupe_threshold cannot be combined with verify_text"}}}, nil
+	}
+	if renderText != nil {
+		prompt = buildTextRenderingPrompt(prompt, *renderText)
+	}
 
 	// ... rest of handler ...
 	gcsOutputURI := ""
@@ -297,6 +402,9 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 		attribute.String("aspect_ratio", aspectRatio),
 		attribute.String("gcs_bucket_uri", gcsBucketUriParam),
 		attribute.String("output_directory", outputDir),
+		attribute.Bool("render_text", renderText != nil),
+		attribute.Bool("verify_text", verifyText),
+		attribute.Float64("dedupe_threshold", dedupeThreshold),
 	)
 
 	select {
@@ -315,49 +423,50 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 		OutputGCSURI:   gcsOutputURI,
 	}
 
-	apiCallCtx, apiCallCancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer apiCallCancel()
-
-	log.Printf("Calling GenerateImages with Model: %s, Prompt: \"%s\". API call timeout: 3m", model, prompt)
-	startTime := time.Now()
-
-	response, err := client.Models.GenerateImages(
-		apiCallCtx,
-		model,
-		prompt,
-		config,
-	)
-
-	apiCallDuration := time.Since(startTime)
-	log.Printf("GenerateImages call took: %v", apiCallDuration)
+	// With verify_text the images of every attempt are kept, in attempt order, so a reviewer can
+	// still pick one when verification never passes.
+	var generatedImages []*genai.GeneratedImage
+	var textAttempts []textRenderAttempt
+	var apiCallDuration time.Duration
+	verificationModel := textVerificationModel()
+	if verifyText {
+		textAttempts, apiCallDuration, err = generateWithTextVerification(ctx, models, model, prompt, config, *renderText, verificationModel, maxAttempts)
+		for _, attempt := range textAttempts {
+			generatedImages = append(generatedImages, attempt.Images...)
+		}
+		span.SetAttributes(attribute.Int("text_verification_attempts", len(textAttempts)))
+	} else {
+		var response *genai.GenerateImagesResponse
+		response, apiCallDuration, err = generateImagesWithTimeout(ctx, models, model, prompt, config)
+		if response != nil {
+			generatedImages = response.GeneratedImages
+		}
+	}
 	span.SetAttributes(attribute.Float64("duration_ms", float64(apiCallDuration.Milliseconds())))
 
 	var contentItems []mcp.Content
 
 	if err != nil {
-		errorMessage := fmt.Sprintf("error generating images: %v", err.Error())
-		if errors.Is(err, context.DeadlineExceeded) && apiCallCtx.Err() == context.DeadlineExceeded {
-			log.Printf("GenerateImages failed due to API call timeout (3 minutes): %v", err)
-			errorMessage = "image generation timed out"
-		} else if errors.Is(err, context.Canceled) {
-			log.Printf("GenerateImages failed due to context cancellation: %v", err)
-			errorMessage = "image generation was canceled"
-		} else {
-			log.Printf("Error generating images (API call failed): %v", err)
-		}
 		span.RecordError(err)
-		contentItems = append(contentItems, mcp.TextContent{Type: "text", Text: errorMessage})
+		contentItems = append(contentItems, mcp.TextContent{Type: "text", Text: err.Error()})
 		return &mcp.CallToolResult{Content: contentItems}, nil
 	}
 
-	if response == nil || len(response.GeneratedImages) == 0 {
+	if len(generatedImages) == 0 {
 		noImageText := fmt.Sprintf("Sorry, I couldn't generate any images for the prompt \"%s\".", prompt)
-		log.Printf(noImageText)
+		log.Print(noImageText)
 		contentItems = append(contentItems, mcp.TextContent{Type: "text", Text: noImageText})
 		return &mcp.CallToolResult{Content: contentItems}, nil
 	}
 
-	log.Printf("Successfully received %d image metadata/references from API.", len(response.GeneratedImages))
+	log.Printf("Successfully received %d image metadata/references from API.", len(generatedImages))
+
+	// Duplicates are skipped below but keep their index, so the report matches the API response.
+	var duplicates map[int]duplicateImage
+	if dedupeThreshold > 0 {
+		duplicates = findDuplicateImages(ctx, generatedImages, dedupeThreshold)
+		span.SetAttributes(attribute.Int("dropped_duplicates", len(duplicates)))
+	}
 
 	var savedLocalFilenames []string
 	var failedLocalSaveReasons []string
@@ -367,17 +476,22 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 	returnImageDataInResponse := gcsOutputURI == "" && !attemptLocalSave
 	log.Printf("Will return image data in response: %t", returnImageDataInResponse)
 
-	for n, genImg := range response.GeneratedImages {
+	imageLocations := make([]string, len(generatedImages))
+	for n, genImg := range generatedImages {
 		var imageData []byte
 		var imageMimeType string = "image/png"
 		var imageSourceIsGCS bool = false
 		var currentImageGCSURI string
 
+		if _, dropped := duplicates[n]; dropped {
+			continue
+		}
 		if genImg.Image != nil && genImg.Image.GCSURI != "" {
 			currentImageGCSURI = genImg.Image.GCSURI
 			imagesWithDataOrURI++
 			imageSourceIsGCS = true
 			gcsSavedURIs = append(gcsSavedURIs, currentImageGCSURI)
+			imageLocations[n] = currentImageGCSURI
 			log.Printf("Image %d available at GCS URI (from API response): %s", n, currentImageGCSURI)
 			if genImg.Image.MIMEType != "" {
 				imageMimeType = genImg.Image.MIMEType
@@ -396,15 +510,7 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 		}
 
 		if attemptLocalSave {
-			localFilename := fmt.Sprintf("imagen-%s-%s-%d", model, time.Now().Format("20060102-150405"), n)
-			switch imageMimeType {
-			case "image/jpeg":
-				localFilename += ".jpg"
-			case "image/webp":
-				localFilename += ".webp"
-			default:
-				localFilename += ".png"
-			}
+			localFilename := fmt.Sprintf("imagen-%s-%s-%d", model, time.Now().Format("20060102-150405"), n) + imageExtension(imageMimeType)
 			actualSavePath := filepath.Join(outputDir, localFilename)
 			actualSavePath = filepath.Clean(actualSavePath)
 
@@ -437,6 +543,7 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 					} else {
 						log.Printf("Saved image %s (Size: %s)", actualSavePath, common.FormatBytes(int64(len(imageData))))
 						savedLocalFilenames = append(savedLocalFilenames, actualSavePath)
+						imageLocations[n] = actualSavePath
 					}
 				}
 			}
@@ -490,6 +597,18 @@ func imagenGenerationHandler(client *genai.Client, ctx context.Context, request
 		saveMessageParts = append(saveMessageParts, "Image(s) are included in this MCP response as base64 data.")
 	}
 
+	if verifyText {
+		saveMessageParts = append(saveMessageParts, describeTextVerification(*renderText, verificationModel, textAttempts, maxAttempts, imageLocations))
+	}
+	if dedupeThreshold > 0 {
+		saveMessageParts = append(saveMessageParts, describeDuplicates(duplicates, dedupeThreshold, len(generatedImages), gcsOutputURI != ""))
+	}
+	if renderText != nil {
+		if note := renderTextLengthNote(*renderText); note != "" {
+			saveMessageParts = append(saveMessageParts, note)
+		}
+	}
+
 	sizeReport := ""
 	if totalSizeBytesGenerated > 0 {
 		sizeReport = fmt.Sprintf("(total processed/downloaded byte size: %s) ", common.FormatBytes(totalSizeBytesGenerated))
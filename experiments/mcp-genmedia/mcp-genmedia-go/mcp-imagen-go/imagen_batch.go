@@ -0,0 +1,376 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+const (
+	defaultBatchMaxPrompts  = 50
+	defaultBatchConcurrency = 4
+	maxBatchConcurrency     = 10
+)
+
+// batchObjectUploader writes batch outputs to GCS. It is a variable so tests can avoid calling GCS.
+var batchObjectUploader = common.UploadToGCS
+
+// imagenBatchJob holds the parameters shared by every prompt in an imagen_t2i_batch call.
+type imagenBatchJob struct {
+	Prompts        []string
+	Model          string
+	NumberOfImages int32
+	AspectRatio    string
+	OutputBucket   string
+	OutputPrefix   string // object prefix within OutputBucket, without a trailing slash
+	Concurrency    int
+}
+
+// batchManifestRow is one line of manifest.csv. A prompt that produced several images has a row
+// per image; a failed prompt has a single row with an empty filename.
+type batchManifestRow struct {
+	Index    int
+	Prompt   string
+	Filename string
+	Status   string
+	Error    string
+}
+
+// batchMaxPrompts returns the prompt cap, configurable with IMAGEN_BATCH_MAX_PROMPTS.
+func batchMaxPrompts() int {
+	if n, err := strconv.Atoi(common.GetEnv("IMAGEN_BATCH_MAX_PROMPTS", strconv.Itoa(defaultBatchMaxPrompts))); err == nil && n > 0 {
+		return n
+	}
+	return defaultBatchMaxPrompts
+}
+
+// registerImagenBatchTool adds the imagen_t2i_batch tool to the MCP server.
+func registerImagenBatchTool(s *server.MCPServer, client *genai.Client) {
+	tool := mcp.NewTool("imagen_t2i_batch",
+		mcp.WithDescription("Generates images for many prompt variations in one call (e.g., for a campaign). Prompts are generated concurrently with the same model and settings, and the images are written under a GCS prefix with index-based names, together with a manifest.csv listing each prompt, file, status and error. A failed prompt does not stop the rest of the batch."),
+		mcp.WithArray("prompts", mcp.Required(), mcp.WithStringItems(), mcp.Description(fmt.Sprintf("The prompts to generate images for, at most %d (configurable with IMAGEN_BATCH_MAX_PROMPTS).", batchMaxPrompts()))),
+		mcp.WithString("model",
+			mcp.DefaultString("imagen-3.0-generate-002"),
+			mcp.Description(common.BuildImagenModelDescription()),
+		),
+		mcp.WithNumber("num_images",
+			mcp.DefaultNumber(1),
+			mcp.Min(1),
+			mcp.Max(4),
+			mcp.Description("Number of images to generate per prompt (1-4). Note: the maximum is model-dependent."),
+		),
+		mcp.WithString("aspect_ratio",
+			mcp.DefaultString("1:1"),
+			mcp.Description("Aspect ratio of the generated images (e.g., \"1:1\", \"16:9\", \"9:16\")."),
+		),
+		mcp.WithString("output_gcs_prefix", mcp.Description("Optional. GCS prefix for the batch outputs (e.g., gs://your-bucket/campaigns/spring/). Defaults to gs://$GENMEDIA_BUCKET/imagen_batch/<timestamp>/.")),
+		mcp.WithNumber("max_concurrency",
+			mcp.DefaultNumber(defaultBatchConcurrency),
+			mcp.Min(1),
+			mcp.Max(maxBatchConcurrency),
+			mcp.Description(fmt.Sprintf("Optional. Maximum number of prompts generated at the same time (1-%d). Defaults to %d.", maxBatchConcurrency, defaultBatchConcurrency)),
+		),
+	)
+	s.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return imagenBatchHandler(client.Models, ctx, request)
+	})
+}
+
+// imagenBatchHandler validates the batch request, runs it, and uploads the manifest.
+// Progress notifications report completed/total prompts when the client asked for progress.
+func imagenBatchHandler(generator imageGenerator, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "imagen_t2i_batch")
+	defer span.End()
+
+	args := request.GetArguments()
+	// Blank prompts are rejected rather than skipped, so that each prompt keeps the index the
+	// caller gave it in the file names and the manifest.
+	promptArgs, _ := args["prompts"].([]interface{})
+	if len(promptArgs) == 0 {
+		return mcp.NewToolResultError("prompts must be a non-empty array of strings"), nil
+	}
+	prompts := make([]string, len(promptArgs))
+	for i, p := range promptArgs {
+		prompt, ok := p.(string)
+		if !ok || strings.TrimSpace(prompt) == "" {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid prompts[%d]: each prompt must be a non-empty string", i)), nil
+		}
+		prompts[i] = strings.TrimSpace(prompt)
+	}
+	if maxPrompts := batchMaxPrompts(); len(prompts) > maxPrompts {
+		return mcp.NewToolResultError(fmt.Sprintf("too many prompts: %d were given but at most %d are allowed per batch", len(prompts), maxPrompts)), nil
+	}
+
+	model, numberOfImages, aspectRatio, errMsg := resolveImagenGenerationParams(args)
+	if errMsg != "" {
+		return mcp.NewToolResultError(errMsg), nil
+	}
+
+	outputPrefix, _ := args["output_gcs_prefix"].(string)
+	outputPrefix = strings.TrimSpace(outputPrefix)
+	if outputPrefix == "" {
+		if appConfig == nil || appConfig.GenmediaBucket == "" {
+			return mcp.NewToolResultError("output_gcs_prefix is required when GENMEDIA_BUCKET is not set"), nil
+		}
+		outputPrefix = fmt.Sprintf("gs://%s/imagen_batch/%s", appConfig.GenmediaBucket, time.Now().Format("20060102-150405"))
+		log.Printf("Handler imagen_t2i_batch: 'output_gcs_prefix' parameter not provided, using default constructed from GENMEDIA_BUCKET: %s", outputPrefix)
+	}
+	bucket, objectPrefix, err := splitGCSPrefix(outputPrefix)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	concurrency := defaultBatchConcurrency
+	if c, ok := args["max_concurrency"].(float64); ok {
+		concurrency = int(c)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > maxBatchConcurrency {
+		concurrency = maxBatchConcurrency
+	}
+
+	job := imagenBatchJob{
+		Prompts:        prompts,
+		Model:          model,
+		NumberOfImages: numberOfImages,
+		AspectRatio:    aspectRatio,
+		OutputBucket:   bucket,
+		OutputPrefix:   objectPrefix,
+		Concurrency:    concurrency,
+	}
+	span.SetAttributes(
+		attribute.Int("num_prompts", len(prompts)),
+		attribute.String("model", model),
+		attribute.Int("num_images", int(numberOfImages)),
+		attribute.String("aspect_ratio", aspectRatio),
+		attribute.String("output_gcs_prefix", outputPrefix),
+		attribute.Int("max_concurrency", concurrency),
+	)
+
+	mcpServer := server.ServerFromContext(ctx)
+	var progressToken mcp.ProgressToken
+	if request.Params.Meta != nil {
+		progressToken = request.Params.Meta.ProgressToken
+	}
+	onProgress := func(completed, total int) {
+		if progressToken == nil || mcpServer == nil {
+			return
+		}
+		mcpServer.SendNotificationToClient(ctx, "notifications/progress", map[string]interface{}{
+			"progressToken": progressToken,
+			"progress":      completed,
+			"total":         total,
+			"message":       fmt.Sprintf("Generated %d of %d prompts", completed, total),
+		})
+	}
+
+	startTime := time.Now()
+	rows := runImagenBatch(ctx, generator, job, onProgress)
+	duration := time.Since(startTime)
+
+	succeededPrompts, imageCount := summarizeBatch(rows)
+	span.SetAttributes(
+		attribute.Int("succeeded_prompts", succeededPrompts),
+		attribute.Int("image_count", imageCount),
+		attribute.Float64("duration_ms", float64(duration.Milliseconds())),
+	)
+
+	manifestObject := joinObjectPath(job.OutputPrefix, "manifest.csv")
+	manifestURI := fmt.Sprintf("gs://%s/%s", job.OutputBucket, manifestObject)
+	manifest, err := buildBatchManifest(rows)
+	if err == nil {
+		err = batchObjectUploader(ctx, job.OutputBucket, manifestObject, "text/csv", manifest)
+	}
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("Failed to write batch manifest %s: %v", manifestURI, err)
+	}
+
+	var messageParts []string
+	messageParts = append(messageParts, fmt.Sprintf("Batch completed in %s: %d of %d prompts succeeded, %d image(s) generated with model %s under gs://%s/%s.",
+		duration.Round(time.Second), succeededPrompts, len(prompts), imageCount, model, job.OutputBucket, joinObjectPath(job.OutputPrefix, "")))
+	if err != nil {
+		messageParts = append(messageParts, fmt.Sprintf("The manifest could not be written to %s: %v.", manifestURI, err))
+	} else {
+		messageParts = append(messageParts, fmt.Sprintf("Manifest: %s.", manifestURI))
+	}
+	var failures []string
+	for _, row := range rows {
+		if row.Status != "ok" {
+			failures = append(failures, fmt.Sprintf("#%d: %s", row.Index, row.Error))
+		}
+	}
+	if len(failures) > 0 {
+		messageParts = append(messageParts, fmt.Sprintf("Failed prompts: %s.", strings.Join(failures, "; ")))
+	}
+
+	result := mcp.NewToolResultText(strings.Join(messageParts, " "))
+	result.IsError = succeededPrompts == 0
+	return result, nil
+}
+
+// runImagenBatch generates every prompt with at most job.Concurrency generations in flight and
+// returns the manifest rows in prompt order. onProgress is called after each prompt finishes.
+func runImagenBatch(ctx context.Context, generator imageGenerator, job imagenBatchJob, onProgress func(completed, total int)) []batchManifestRow {
+	results := make([][]batchManifestRow, len(job.Prompts))
+	sem := make(chan struct{}, job.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed := 0
+
+	for i, prompt := range job.Prompts {
+		wg.Add(1)
+		go func(i int, prompt string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			results[i] = generateBatchPrompt(ctx, generator, job, i+1, prompt)
+			<-sem
+
+			mu.Lock()
+			completed++
+			done := completed
+			mu.Unlock()
+			onProgress(done, len(job.Prompts))
+		}(i, prompt)
+	}
+	wg.Wait()
+
+	var rows []batchManifestRow
+	for _, r := range results {
+		rows = append(rows, r...)
+	}
+	return rows
+}
+
+// generateBatchPrompt generates and uploads the images for one prompt using the same
+// GenerateImages call as imagen_t2i. Images are named <index>.<ext>, or <index>_<n>.<ext> when
+// several images are requested per prompt.
+func generateBatchPrompt(ctx context.Context, generator imageGenerator, job imagenBatchJob, index int, prompt string) []batchManifestRow {
+	failed := func(err error) []batchManifestRow {
+		log.Printf("Batch prompt %d failed: %v", index, err)
+		return []batchManifestRow{{Index: index, Prompt: prompt, Status: "failed", Error: err.Error()}}
+	}
+
+	config := &genai.GenerateImagesConfig{
+		NumberOfImages: job.NumberOfImages,
+		AspectRatio:    job.AspectRatio,
+	}
+	response, _, err := generateImagesWithTimeout(ctx, generator, job.Model, prompt, config)
+	if err != nil {
+		return failed(err)
+	}
+
+	var rows []batchManifestRow
+	for n, genImg := range response.GeneratedImages {
+		if genImg.Image == nil || len(genImg.Image.ImageBytes) == 0 {
+			continue
+		}
+		filename := fmt.Sprintf("%03d", index)
+		if job.NumberOfImages > 1 {
+			filename += fmt.Sprintf("_%d", n+1)
+		}
+		filename += imageExtension(genImg.Image.MIMEType)
+		objectName := joinObjectPath(job.OutputPrefix, filename)
+		if err := batchObjectUploader(ctx, job.OutputBucket, objectName, genImg.Image.MIMEType, genImg.Image.ImageBytes); err != nil {
+			rows = append(rows, batchManifestRow{Index: index, Prompt: prompt, Filename: filename, Status: "failed", Error: fmt.Sprintf("upload failed: %v", err)})
+			continue
+		}
+		rows = append(rows, batchManifestRow{Index: index, Prompt: prompt, Filename: filename, Status: "ok"})
+	}
+	if len(rows) == 0 {
+		return failed(fmt.Errorf("no images were returned for the prompt"))
+	}
+	return rows
+}
+
+// summarizeBatch counts the prompts with at least one successful image, and the images.
+func summarizeBatch(rows []batchManifestRow) (succeededPrompts, images int) {
+	succeeded := make(map[int]bool)
+	for _, row := range rows {
+		if row.Status == "ok" {
+			succeeded[row.Index] = true
+			images++
+		}
+	}
+	return len(succeeded), images
+}
+
+// buildBatchManifest renders the manifest rows as CSV with a header line.
+func buildBatchManifest(rows []batchManifestRow) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"prompt", "filename", "status", "error"}); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := w.Write([]string{row.Prompt, row.Filename, row.Status, row.Error}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// splitGCSPrefix splits gs://bucket/some/prefix/ into the bucket and the object prefix,
+// without a trailing slash. The prefix may be empty.
+func splitGCSPrefix(uri string) (bucket, prefix string, err error) {
+	trimmed := strings.TrimPrefix(common.EnsureGCSPathPrefix(uri), "gs://")
+	parts := strings.SplitN(trimmed, "/", 2)
+	if parts[0] == "" {
+		return "", "", fmt.Errorf("invalid GCS prefix %q: expected gs://bucket/optional/prefix/", uri)
+	}
+	if len(parts) == 2 {
+		prefix = strings.Trim(parts[1], "/")
+	}
+	return parts[0], prefix, nil
+}
+
+// joinObjectPath joins an object prefix and a name, allowing an empty prefix.
+func joinObjectPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// imageExtension maps an image MIME type to the file extension used for saved images.
+func imageExtension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// stubImageGenerator returns one PNG per requested image, fails prompts containing "fail",
+// and records the highest number of concurrent calls.
+type stubImageGenerator struct {
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (g *stubImageGenerator) GenerateImages(ctx context.Context, model, prompt string, config *genai.GenerateImagesConfig) (*genai.GenerateImagesResponse, error) {
+	current := atomic.AddInt32(&g.inFlight, 1)
+	defer atomic.AddInt32(&g.inFlight, -1)
+	for {
+		observed := atomic.LoadInt32(&g.maxInFlight)
+		if current <= observed || atomic.CompareAndSwapInt32(&g.maxInFlight, observed, current) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if strings.Contains(prompt, "fail") {
+		return nil, errors.New("prompt blocked by safety filters")
+	}
+	resp := &genai.GenerateImagesResponse{}
+	for i := int32(0); i < config.NumberOfImages; i++ {
+		resp.GeneratedImages = append(resp.GeneratedImages, &genai.GeneratedImage{
+			Image: &genai.Image{ImageBytes: []byte("png-" + prompt), MIMEType: "image/png"},
+		})
+	}
+	return resp, nil
+}
+
+// stubUploads replaces the GCS uploader and records the uploaded objects.
+func stubUploads(t *testing.T) map[string][]byte {
+	t.Helper()
+	original := batchObjectUploader
+	t.Cleanup(func() { batchObjectUploader = original })
+	var mu sync.Mutex
+	uploads := make(map[string][]byte)
+	batchObjectUploader = func(ctx context.Context, bucket, object, contentType string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		uploads[bucket+"/"+object] = data
+		return nil
+	}
+	return uploads
+}
+
+func TestImagenBatchHandlerPartialFailureAndManifest(t *testing.T) {
+	uploads := stubUploads(t)
+	generator := &stubImageGenerator{}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"prompts":           []interface{}{"a red bicycle", "please fail, this one", "a blue kite"},
+		"num_images":        2.0,
+		"output_gcs_prefix": "gs://campaign-bucket/spring/",
+	}
+	result, err := imagenBatchHandler(generator, context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("a partially failed batch should not be an error result: %+v", result)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "2 of 3 prompts succeeded, 4 image(s)") || !strings.Contains(text, "#2: error generating images: prompt blocked") {
+		t.Errorf("unexpected summary: %s", text)
+	}
+
+	for _, object := range []string{"spring/001_1.png", "spring/001_2.png", "spring/003_1.png", "spring/003_2.png"} {
+		if _, ok := uploads["campaign-bucket/"+object]; !ok {
+			t.Errorf("expected %s to be uploaded", object)
+		}
+	}
+	if _, ok := uploads["campaign-bucket/spring/002_1.png"]; ok {
+		t.Error("the failed prompt should not have produced an image")
+	}
+
+	manifest, ok := uploads["campaign-bucket/spring/manifest.csv"]
+	if !ok {
+		t.Fatal("expected manifest.csv to be uploaded")
+	}
+	records, err := csv.NewReader(strings.NewReader(string(manifest))).ReadAll()
+	if err != nil {
+		t.Fatalf("manifest is not valid CSV: %v", err)
+	}
+	expected := [][]string{
+		{"prompt", "filename", "status", "error"},
+		{"a red bicycle", "001_1.png", "ok", ""},
+		{"a red bicycle", "001_2.png", "ok", ""},
+		{"please fail, this one", "", "failed", "error generating images: prompt blocked by safety filters"},
+		{"a blue kite", "003_1.png", "ok", ""},
+		{"a blue kite", "003_2.png", "ok", ""},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("unexpected manifest:\n got %v\nwant %v", records, expected)
+	}
+}
+
+func TestRunImagenBatchConcurrencyBound(t *testing.T) {
+	stubUploads(t)
+	generator := &stubImageGenerator{}
+
+	var prompts []string
+	for i := 0; i < 12; i++ {
+		prompts = append(prompts, fmt.Sprintf("variation %d", i))
+	}
+	var progress []int
+	var mu sync.Mutex
+	rows := runImagenBatch(context.Background(), generator, imagenBatchJob{
+		Prompts:        prompts,
+		Model:          "imagen-3.0-generate-002",
+		NumberOfImages: 1,
+		AspectRatio:    "1:1",
+		OutputBucket:   "bucket",
+		Concurrency:    3,
+	}, func(completed, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if total != len(prompts) {
+			t.Errorf("expected total %d, got %d", len(prompts), total)
+		}
+		progress = append(progress, completed)
+	})
+
+	if max := atomic.LoadInt32(&generator.maxInFlight); max > 3 {
+		t.Errorf("expected at most 3 concurrent generations, observed %d", max)
+	}
+	if len(rows) != len(prompts) || rows[0].Filename != "001.png" || rows[11].Filename != "012.png" {
+		t.Errorf("expected one row per prompt in order, got %+v", rows)
+	}
+	if len(progress) != len(prompts) || progress[len(progress)-1] != len(prompts) {
+		t.Errorf("expected a progress update per prompt ending at %d, got %v", len(prompts), progress)
+	}
+}
+
+func TestImagenBatchHandlerPromptCap(t *testing.T) {
+	t.Setenv("IMAGEN_BATCH_MAX_PROMPTS", "2")
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"prompts":           []interface{}{"one", "two", "three"},
+		"output_gcs_prefix": "gs://bucket/x",
+	}
+	result, _ := imagenBatchHandler(&stubImageGenerator{}, context.Background(), request)
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "at most 2") {
+		t.Errorf("expected the prompt cap to be enforced, got %+v", result)
+	}
+}
+
+func TestImagenBatchHandlerRejectsBlankPrompts(t *testing.T) {
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"prompts":           []interface{}{"one", "  ", "three"},
+		"output_gcs_prefix": "gs://bucket/x",
+	}
+	generator := &stubImageGenerator{}
+	result, _ := imagenBatchHandler(generator, context.Background(), request)
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "prompts[1]") {
+		t.Errorf("expected the blank prompt to be rejected by index, got %+v", result)
+	}
+}
+
+func TestImagenBatchHandlerBucketRootPrefix(t *testing.T) {
+	uploads := stubUploads(t)
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"prompts":           []interface{}{"one"},
+		"output_gcs_prefix": "gs://bucket/",
+	}
+	result, _ := imagenBatchHandler(&stubImageGenerator{}, context.Background(), request)
+	text := result.Content[0].(mcp.TextContent).Text
+	if strings.Contains(text, "gs://bucket//") || !strings.Contains(text, "under gs://bucket/.") || !strings.Contains(text, "Manifest: gs://bucket/manifest.csv.") {
+		t.Errorf("expected paths at the bucket root, got %q", text)
+	}
+	if _, ok := uploads["bucket/manifest.csv"]; !ok {
+		t.Errorf("expected the manifest at the bucket root, got %v", uploads)
+	}
+}
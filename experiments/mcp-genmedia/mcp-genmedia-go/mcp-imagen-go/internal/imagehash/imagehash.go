@@ -0,0 +1,91 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package imagehash computes perceptual hashes of images, so that near-duplicate images can be
+// found by comparing hashes instead of pixels.
+package imagehash
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Imagen returns JPEG when asked for it
+	_ "image/png"
+	"math/bits"
+)
+
+// Bits is the number of bits in a Hash.
+const Bits = 64
+
+// Hash is a difference hash (dHash): one bit per pair of horizontally adjacent pixels of the
+// image shrunk to 9x8 grayscale, set when the left pixel is brighter. Similar images have hashes
+// that differ in few bits, whatever their size or encoding.
+type Hash uint64
+
+// Decode decodes PNG or JPEG data and returns its hash.
+func Decode(data []byte) (Hash, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("decoding image: %w", err)
+	}
+	return DHash(img), nil
+}
+
+// DHash returns the difference hash of img.
+func DHash(img image.Image) Hash {
+	const width, height = 9, 8
+	gray := downscaleGray(img, width, height)
+	var h Hash
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			h <<= 1
+			if gray[y*width+x] > gray[y*width+x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// Distance returns the number of bits in which a and b differ, from 0 to Bits.
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// downscaleGray shrinks img to width x height luma values, averaging every source pixel that
+// falls into a cell so that fine detail and noise do not decide the hash.
+func downscaleGray(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	sums := make([]float64, width*height)
+	counts := make([]int, width*height)
+	if bounds.Empty() {
+		return sums
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cx := (x - bounds.Min.X) * width / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			// ITU-R BT.601 luma, as used by image/color's Gray model
+			sums[cy*width+cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cy*width+cx]++
+		}
+	}
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
@@ -0,0 +1,104 @@
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gradient draws a horizontal grayscale ramp, brightening to the right, or to the left when
+// reversed. noise adds a small checkerboard pattern on top.
+func gradient(width, height int, reversed bool, noise uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 200 / width)
+			if reversed {
+				v = 200 - v
+			}
+			if (x+y)%2 == 0 {
+				v += noise
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+// blocks draws a vertical gradient crossed by bright bars, which hashes far from any
+// horizontal ramp.
+func blocks(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(y * 255 / height)
+			if (x*9/width)%2 == 1 {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	// brightening to the right, no left pixel is brighter than its neighbour
+	if got := DHash(gradient(90, 80, false, 0)); got != 0 {
+		t.Errorf("expected a rising ramp to hash to 0, got %064b", got)
+	}
+	if got := DHash(gradient(90, 80, true, 0)); got != ^Hash(0) {
+		t.Errorf("expected a falling ramp to set every bit, got %064b", got)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	base := DHash(gradient(256, 256, true, 0))
+	testCases := []struct {
+		name    string
+		img     image.Image
+		maxDist int
+		minDist int
+	}{
+		{"same image", gradient(256, 256, true, 0), 0, 0},
+		{"resized", gradient(1024, 512, true, 0), 0, 0},
+		{"noisy", gradient(256, 256, true, 6), 4, 0},
+		{"mirrored", gradient(256, 256, false, 0), Bits, Bits},
+		{"different content", blocks(256, 256), Bits, 16},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Distance(base, DHash(tc.img))
+			if d < tc.minDist || d > tc.maxDist {
+				t.Errorf("distance %d, want between %d and %d", d, tc.minDist, tc.maxDist)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	img := gradient(128, 128, true, 0)
+	var pngData, jpegData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, img, &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	pngHash, err := Decode(pngData.Bytes())
+	if err != nil {
+		t.Fatalf("decoding PNG: %v", err)
+	}
+	jpegHash, err := Decode(jpegData.Bytes())
+	if err != nil {
+		t.Fatalf("decoding JPEG: %v", err)
+	}
+	if d := Distance(pngHash, jpegHash); d > 2 {
+		t.Errorf("expected the PNG and JPEG encodings to hash alike, distance %d", d)
+	}
+	if _, err := Decode([]byte("not an image")); err == nil {
+		t.Error("expected undecodable data to be rejected")
+	}
+}
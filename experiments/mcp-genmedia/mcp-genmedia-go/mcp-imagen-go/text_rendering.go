@@ -0,0 +1,298 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"google.golang.org/genai"
+)
+
+const (
+	defaultTextVerificationModel = "gemini-2.5-flash"
+	defaultTextRenderAttempts    = 3
+	maxTextRenderAttempts        = 5
+	// recommendedRenderTextLength is the length up to which Imagen renders text most reliably.
+	recommendedRenderTextLength = 25
+	textVerificationTimeout     = 1 * time.Minute
+)
+
+// contentGenerator is the part of the GenAI client used for the Gemini text check.
+// *genai.Models satisfies it; tests substitute a stub.
+type contentGenerator interface {
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+}
+
+// imagenModels is what imagen_t2i needs from the GenAI client: image generation, and Gemini for
+// verifying rendered text.
+type imagenModels interface {
+	imageGenerator
+	contentGenerator
+}
+
+// renderTextSpec is the 'render_text' argument of imagen_t2i: text that must appear in the image.
+type renderTextSpec struct {
+	Text         string
+	LanguageHint string
+	PositionHint string
+}
+
+// textVerificationModel returns the Gemini model used to check rendered text, configurable with
+// IMAGEN_TEXT_VERIFICATION_MODEL.
+func textVerificationModel() string {
+	return common.GetEnv("IMAGEN_TEXT_VERIFICATION_MODEL", defaultTextVerificationModel)
+}
+
+// parseRenderText reads the optional 'render_text' object. It returns nil when the argument is absent.
+func parseRenderText(args map[string]interface{}) (*renderTextSpec, error) {
+	raw, ok := args["render_text"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("render_text must be an object with a 'text' field")
+	}
+	text, _ := obj["text"].(string)
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("render_text.text is required and must be a non-empty string")
+	}
+	spec := &renderTextSpec{Text: strings.TrimSpace(text)}
+	for field, dest := range map[string]*string{"language_hint": &spec.LanguageHint, "position_hint": &spec.PositionHint} {
+		if v, ok := obj[field]; ok && v != nil {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("render_text.%s must be a string", field)
+			}
+			*dest = strings.TrimSpace(s)
+		}
+	}
+	return spec, nil
+}
+
+// buildTextRenderingPrompt appends the on-image text to the prompt. Imagen renders text best when
+// it is quoted, short, placed explicitly, and given a plain typeface with good contrast, so the
+// template spells all of that out and asks for no other lettering.
+func buildTextRenderingPrompt(prompt string, spec renderTextSpec) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(strings.TrimSpace(prompt), ". "))
+	fmt.Fprintf(&b, ". The image contains the text \"%s\", spelled exactly as written", spec.Text)
+	if spec.LanguageHint != "" {
+		fmt.Fprintf(&b, " in %s", spec.LanguageHint)
+	}
+	b.WriteString(".")
+	if spec.PositionHint != "" {
+		fmt.Fprintf(&b, " The text is placed %s.", spec.PositionHint)
+	}
+	b.WriteString(" The lettering is sharp and legible, in a clean typeface with strong contrast against its background, and no other text appears in the image.")
+	return b.String()
+}
+
+// textCheck is the verification outcome for one generated image. Err is set when the check
+// itself could not be made, in which case Passed is false.
+type textCheck struct {
+	Passed   bool
+	Observed string
+	Err      error
+}
+
+// textVerificationAnswer is the JSON answer requested from Gemini.
+type textVerificationAnswer struct {
+	ContainsExactText bool   `json:"contains_exact_text"`
+	ObservedText      string `json:"observed_text"`
+}
+
+var textVerificationSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"contains_exact_text": {Type: genai.TypeBoolean},
+		"observed_text":       {Type: genai.TypeString},
+	},
+	Required: []string{"contains_exact_text", "observed_text"},
+}
+
+// verifyRenderedText asks Gemini whether the image contains exactly the requested text. Images
+// stored in GCS are passed by URI; images returned as bytes are sent inline.
+func verifyRenderedText(ctx context.Context, gen contentGenerator, model string, image *genai.Image, spec renderTextSpec) textCheck {
+	var imagePart *genai.Part
+	mimeType := "image/png"
+	if image != nil && image.MIMEType != "" {
+		mimeType = image.MIMEType
+	}
+	switch {
+	case image != nil && image.GCSURI != "":
+		imagePart = genai.NewPartFromURI(image.GCSURI, mimeType)
+	case image != nil && len(image.ImageBytes) > 0:
+		imagePart = genai.NewPartFromBytes(image.ImageBytes, mimeType)
+	default:
+		return textCheck{Err: fmt.Errorf("the image has no data to check")}
+	}
+
+	question := fmt.Sprintf("Does this image contain the exact text %q? Answer true only if all of it appears, spelled exactly and in the same order; line breaks do not matter. In observed_text, transcribe the text you can read in the image, or leave it empty if there is none.", spec.Text)
+	contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{imagePart, genai.NewPartFromText(question)}, genai.RoleUser)}
+	config := &genai.GenerateContentConfig{
+		Temperature:      genai.Ptr[float32](0),
+		ResponseMIMEType: "application/json",
+		ResponseSchema:   textVerificationSchema,
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, textVerificationTimeout)
+	defer cancel()
+	resp, err := gen.GenerateContent(checkCtx, model, contents, config)
+	if err != nil {
+		return textCheck{Err: fmt.Errorf("text verification failed: %w", err)}
+	}
+	var answer textVerificationAnswer
+	if err := json.Unmarshal([]byte(resp.Text()), &answer); err != nil {
+		return textCheck{Err: fmt.Errorf("could not parse the text verification answer: %w", err)}
+	}
+	return textCheck{Passed: answer.ContainsExactText, Observed: strings.TrimSpace(answer.ObservedText)}
+}
+
+// textRenderAttempt is one generation call made while verifying rendered text, with a check per
+// returned image. Err is set when the generation call failed.
+type textRenderAttempt struct {
+	Images []*genai.GeneratedImage
+	Checks []textCheck
+	Err    error
+}
+
+// passed reports whether at least one image of the attempt shows the text.
+func (a textRenderAttempt) passed() bool {
+	for _, check := range a.Checks {
+		if check.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// checksUnavailable reports whether every check of the attempt failed to run, e.g. because the
+// verification model is unreachable. Generating again would not help then.
+func (a textRenderAttempt) checksUnavailable() bool {
+	if len(a.Checks) == 0 {
+		return false
+	}
+	for _, check := range a.Checks {
+		if check.Err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateWithTextVerification generates images and checks each one for the requested text,
+// generating again until an attempt has at least one passing image or maxAttempts is reached.
+// Every attempt is returned, so callers can keep all of the images. The error is only set when
+// the first generation fails.
+func generateWithTextVerification(ctx context.Context, models imagenModels, model, prompt string, config *genai.GenerateImagesConfig, spec renderTextSpec, verificationModel string, maxAttempts int) ([]textRenderAttempt, time.Duration, error) {
+	var attempts []textRenderAttempt
+	var total time.Duration
+	for i := 1; i <= maxAttempts; i++ {
+		response, apiCallDuration, err := generateImagesWithTimeout(ctx, models, model, prompt, config)
+		total += apiCallDuration
+		if err != nil {
+			if i == 1 {
+				return nil, total, err
+			}
+			attempts = append(attempts, textRenderAttempt{Err: err})
+			break
+		}
+
+		attempt := textRenderAttempt{}
+		if response != nil {
+			attempt.Images = response.GeneratedImages
+		}
+		for n, genImg := range attempt.Images {
+			check := verifyRenderedText(ctx, models, verificationModel, genImg.Image, spec)
+			log.Printf("Text verification attempt %d, image %d: passed=%t observed=%q err=%v", i, n, check.Passed, check.Observed, check.Err)
+			attempt.Checks = append(attempt.Checks, check)
+		}
+		attempts = append(attempts, attempt)
+		if attempt.passed() || attempt.checksUnavailable() || ctx.Err() != nil {
+			break
+		}
+	}
+	return attempts, total, nil
+}
+
+// describeTextVerification reports the outcome of every check. locations holds a GCS URI or local
+// path for each image, in the order the attempts returned them; an empty location means the
+// image is only included inline in the response.
+func describeTextVerification(spec renderTextSpec, verificationModel string, attempts []textRenderAttempt, maxAttempts int, locations []string) string {
+	var b strings.Builder
+	passedOn := 0
+	imageCount := 0
+	for i, attempt := range attempts {
+		if passedOn == 0 && attempt.passed() {
+			passedOn = i + 1
+		}
+		imageCount += len(attempt.Images)
+	}
+	if passedOn > 0 {
+		fmt.Fprintf(&b, "Text verification for \"%s\" (checked with %s) passed on attempt %d of at most %d.", spec.Text, verificationModel, passedOn, maxAttempts)
+	} else {
+		fmt.Fprintf(&b, "Text verification for \"%s\" (checked with %s) did not pass in %d attempt(s); all %d image(s) are kept so one can be picked manually.", spec.Text, verificationModel, len(attempts), imageCount)
+	}
+
+	index := 0
+	for i, attempt := range attempts {
+		if attempt.Err != nil {
+			fmt.Fprintf(&b, " Attempt %d: generation failed: %v.", i+1, attempt.Err)
+			continue
+		}
+		if len(attempt.Images) == 0 {
+			fmt.Fprintf(&b, " Attempt %d: no images were returned.", i+1)
+			continue
+		}
+		var samples []string
+		for n, check := range attempt.Checks {
+			var outcome string
+			switch {
+			case check.Err != nil:
+				outcome = fmt.Sprintf("not checked (%v)", check.Err)
+			case check.Passed:
+				outcome = "passed"
+			case check.Observed != "":
+				outcome = fmt.Sprintf("failed (read \"%s\")", check.Observed)
+			default:
+				outcome = "failed (no text found)"
+			}
+			location := "inline image"
+			if index < len(locations) && locations[index] != "" {
+				location = locations[index]
+			}
+			samples = append(samples, fmt.Sprintf("sample %d %s: %s", n+1, outcome, location))
+			index++
+		}
+		fmt.Fprintf(&b, " Attempt %d: %s.", i+1, strings.Join(samples, "; "))
+	}
+	return b.String()
+}
+
+// renderTextLengthNote warns when the text is longer than Imagen renders reliably.
+func renderTextLengthNote(spec renderTextSpec) string {
+	if utf8.RuneCountInString(spec.Text) <= recommendedRenderTextLength {
+		return ""
+	}
+	return fmt.Sprintf("Note: Imagen renders text of up to %d characters most reliably; consider shortening \"%s\".", recommendedRenderTextLength, spec.Text)
+}
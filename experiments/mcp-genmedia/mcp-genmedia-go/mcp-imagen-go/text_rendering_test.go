@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// stubImagenModels returns GCS-stored images named after the attempt and sample, and passes the
+// text check only for the images listed in readable.
+type stubImagenModels struct {
+	generateCalls int
+	checkCalls    int
+	prompts       []string
+	readable      map[string]bool
+	checkErr      error
+}
+
+func (m *stubImagenModels) GenerateImages(ctx context.Context, model, prompt string, config *genai.GenerateImagesConfig) (*genai.GenerateImagesResponse, error) {
+	m.generateCalls++
+	m.prompts = append(m.prompts, prompt)
+	resp := &genai.GenerateImagesResponse{}
+	for i := int32(1); i <= config.NumberOfImages; i++ {
+		resp.GeneratedImages = append(resp.GeneratedImages, &genai.GeneratedImage{
+			Image: &genai.Image{GCSURI: fmt.Sprintf("%sattempt%d-sample%d.png", config.OutputGCSURI, m.generateCalls, i), MIMEType: "image/png"},
+		})
+	}
+	return resp, nil
+}
+
+func (m *stubImagenModels) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	m.checkCalls++
+	if m.checkErr != nil {
+		return nil, m.checkErr
+	}
+	uri := contents[0].Parts[0].FileData.FileURI
+	answer := `{"contains_exact_text": false, "observed_text": "GRAND OPNING"}`
+	if m.readable[uri] {
+		answer = `{"contains_exact_text": true, "observed_text": "GRAND OPENING"}`
+	}
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: genai.NewContentFromText(answer, genai.RoleModel)}},
+	}, nil
+}
+
+func textRenderRequest(extra map[string]interface{}) mcp.CallToolRequest {
+	args := map[string]interface{}{
+		"prompt":         "a bakery storefront at dawn.",
+		"num_images":     2.0,
+		"gcs_bucket_uri": "gs://bucket/out/",
+		"render_text": map[string]interface{}{
+			"text":          "GRAND OPENING",
+			"position_hint": "on a banner above the door",
+		},
+	}
+	for k, v := range extra {
+		args[k] = v
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = args
+	return request
+}
+
+func TestBuildTextRenderingPrompt(t *testing.T) {
+	got := buildTextRenderingPrompt("A poster of a mountain lake. ", renderTextSpec{Text: "山の湖", LanguageHint: "Japanese", PositionHint: "vertically along the right edge"})
+	for _, want := range []string{
+		"A poster of a mountain lake. The image contains the text \"山の湖\", spelled exactly as written in Japanese.",
+		"The text is placed vertically along the right edge.",
+		"no other text appears in the image.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("prompt %q does not contain %q", got, want)
+		}
+	}
+	if got := buildTextRenderingPrompt("a sign", renderTextSpec{Text: "OPEN"}); !strings.Contains(got, "spelled exactly as written. The lettering") || strings.Contains(got, "placed") {
+		t.Errorf("hints that were not given should be left out: %q", got)
+	}
+}
+
+func TestImagenGenerationHandlerRetriesUntilTextVerifies(t *testing.T) {
+	models := &stubImagenModels{readable: map[string]bool{"gs://bucket/out/attempt2-sample2.png": true}}
+	result, err := imagenGenerationHandler(models, context.Background(), textRenderRequest(map[string]interface{}{"verify_text": true}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if models.generateCalls != 2 || models.checkCalls != 4 {
+		t.Errorf("expected 2 generations and 4 checks, got %d and %d", models.generateCalls, models.checkCalls)
+	}
+	if !strings.Contains(models.prompts[0], `the text "GRAND OPENING"`) || !strings.Contains(models.prompts[0], "on a banner above the door") {
+		t.Errorf("render_text was not added to the prompt: %q", models.prompts[0])
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{
+		"Generated 4 image(s)",
+		"passed on attempt 2 of at most 3",
+		`Attempt 1: sample 1 failed (read "GRAND OPNING"): gs://bucket/out/attempt1-sample1.png`,
+		"Attempt 2: sample 1 failed",
+		"sample 2 passed: gs://bucket/out/attempt2-sample2.png",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("result does not contain %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestImagenGenerationHandlerKeepsAllAttemptsWhenTextNeverVerifies(t *testing.T) {
+	models := &stubImagenModels{}
+	result, err := imagenGenerationHandler(models, context.Background(), textRenderRequest(map[string]interface{}{"verify_text": true, "max_attempts": 2.0}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if models.generateCalls != 2 {
+		t.Errorf("expected the retry loop to stop after 2 attempts, got %d", models.generateCalls)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "did not pass in 2 attempt(s); all 4 image(s) are kept") {
+		t.Errorf("unexpected verification summary:\n%s", text)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		for sample := 1; sample <= 2; sample++ {
+			if uri := fmt.Sprintf("gs://bucket/out/attempt%d-sample%d.png", attempt, sample); !strings.Contains(text, uri) {
+				t.Errorf("result does not list %s:\n%s", uri, text)
+			}
+		}
+	}
+}
+
+func TestImagenGenerationHandlerStopsWhenVerifierUnavailable(t *testing.T) {
+	models := &stubImagenModels{checkErr: errors.New("model not found")}
+	result, err := imagenGenerationHandler(models, context.Background(), textRenderRequest(map[string]interface{}{"verify_text": true}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if models.generateCalls != 1 {
+		t.Errorf("generating again cannot help when no check can run, got %d generations", models.generateCalls)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "not checked (text verification failed: model not found)") {
+		t.Errorf("unexpected result:\n%s", text)
+	}
+}
+
+func TestImagenGenerationHandlerRenderTextValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		args map[string]interface{}
+		want string
+	}{
+		{"verify without text", map[string]interface{}{"render_text": nil, "verify_text": true}, "verify_text requires render_text"},
+		{"empty text", map[string]interface{}{"render_text": map[string]interface{}{"text": " "}}, "render_text.text is required"},
+		{"bad hint", map[string]interface{}{"render_text": map[string]interface{}{"text": "OPEN", "language_hint": 3.0}}, "render_text.language_hint must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := &stubImagenModels{}
+			result, err := imagenGenerationHandler(models, context.Background(), textRenderRequest(tt.args))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, tt.want) {
+				t.Errorf("got %q, want it to contain %q", text, tt.want)
+			}
+			if models.generateCalls != 0 {
+				t.Error("no image should be generated for an invalid request")
+			}
+		})
+	}
+}
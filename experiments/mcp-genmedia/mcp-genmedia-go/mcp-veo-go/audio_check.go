@@ -0,0 +1,177 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"google.golang.org/genai"
+)
+
+// audioStreamChecker reports whether a generated video contains an audio stream.
+// localPath is used when the video was downloaded; otherwise gcsURI is read.
+// It is a variable so tests can substitute a stub.
+var audioStreamChecker = checkAudioStream
+
+// rangedObject is a GCS object that can be read at arbitrary offsets.
+type rangedObject interface {
+	io.ReaderAt
+	Size() int64
+	Close() error
+}
+
+// openRangedVideoObject opens a generated video in GCS for ranged reads, so the
+// MP4 box walk fetches only the headers it needs. It is a variable for tests.
+var openRangedVideoObject = func(ctx context.Context, gcsURI string) (rangedObject, error) {
+	return common.OpenGCSObjectAt(ctx, gcsURI)
+}
+
+// shouldCheckAudio reports whether the audio stream check applies: audio must
+// have been requested, and the model must be able to produce it.
+func shouldCheckAudio(config *genai.GenerateVideosConfig, modelName string) bool {
+	if config == nil || config.GenerateAudio == nil || !*config.GenerateAudio {
+		return false
+	}
+	return common.SupportedVeoModels[modelName].SupportsAudio
+}
+
+// ffmpegPath returns the configured ffmpeg executable, from FFMPEG_PATH.
+func ffmpegPath() string {
+	if appConfig != nil && appConfig.FFmpegPath != "" {
+		return appConfig.FFmpegPath
+	}
+	return "ffmpeg"
+}
+
+// ffprobePath returns the configured ffprobe executable, from FFPROBE_PATH.
+func ffprobePath() string {
+	if appConfig != nil && appConfig.FFprobePath != "" {
+		return appConfig.FFprobePath
+	}
+	return "ffprobe"
+}
+
+// checkAudioStream prefers ffprobe when it is installed and falls back to
+// parsing the MP4 box structure for a track whose handler type is 'soun'.
+func checkAudioStream(ctx context.Context, localPath, gcsURI string) (bool, error) {
+	if localPath != "" {
+		if _, err := exec.LookPath(ffprobePath()); err == nil {
+			return ffprobeHasAudio(ctx, localPath)
+		}
+		f, err := os.Open(localPath)
+		if err != nil {
+			return false, fmt.Errorf("opening %s: %w", localPath, err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return false, fmt.Errorf("stat %s: %w", localPath, err)
+		}
+		return mp4HasSoundTrack(f, info.Size())
+	}
+
+	object, err := openRangedVideoObject(ctx, gcsURI)
+	if err != nil {
+		return false, fmt.Errorf("opening %s for audio check: %w", gcsURI, err)
+	}
+	defer object.Close()
+	return mp4HasSoundTrack(object, object.Size())
+}
+
+// ffprobeHasAudio asks ffprobe to list only the audio streams of a file.
+func ffprobeHasAudio(ctx context.Context, path string) (bool, error) {
+	cmd := exec.CommandContext(ctx, ffprobePath(), "-v", "error", "-select_streams", "a", "-show_entries", "stream=codec_type", "-of", "csv=p=0", path)
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("ffprobe failed on %s: %w", path, err)
+	}
+	return strings.Contains(string(output), "audio"), nil
+}
+
+// mp4HasSoundTrack walks moov/trak/mdia boxes looking for an 'hdlr' box whose
+// handler type is 'soun'. Only the box headers are read, so large 'mdat'
+// payloads are skipped without loading them.
+func mp4HasSoundTrack(r io.ReaderAt, size int64) (bool, error) {
+	return findSoundHandler(r, 0, size, 0)
+}
+
+func findSoundHandler(r io.ReaderAt, start, end int64, depth int) (bool, error) {
+	if depth > 8 {
+		return false, nil
+	}
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return false, fmt.Errorf("reading box header at %d: %w", offset, err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return false, fmt.Errorf("reading extended box size at %d: %w", offset, err)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if boxSize < headerLen || offset+boxSize > end {
+			return false, fmt.Errorf("malformed MP4 box %q at offset %d", boxType, offset)
+		}
+
+		switch boxType {
+		case "moov", "trak", "mdia":
+			found, err := findSoundHandler(r, offset+headerLen, offset+boxSize, depth+1)
+			if err != nil || found {
+				return found, err
+			}
+		case "hdlr":
+			// hdlr is a full box: version/flags (4), pre_defined (4), handler_type (4).
+			if boxSize >= headerLen+12 {
+				handler := make([]byte, 4)
+				if _, err := r.ReadAt(handler, offset+headerLen+8); err != nil {
+					return false, fmt.Errorf("reading hdlr box at %d: %w", offset, err)
+				}
+				if string(handler) == "soun" {
+					return true, nil
+				}
+			}
+		}
+		offset += boxSize
+	}
+	return false, nil
+}
+
+// describeAudioCheck runs the audio check for one video and formats the outcome
+// for the tool result.
+func describeAudioCheck(ctx context.Context, index int, localPath, gcsURI string) string {
+	hasAudio, err := audioStreamChecker(ctx, localPath, gcsURI)
+	if err != nil {
+		log.Printf("Could not verify audio stream for video %d (%s): %v", index, gcsURI, err)
+		return fmt.Sprintf("video %d has_audio=unknown (%v)", index, err)
+	}
+	log.Printf("Audio stream check for video %d (%s): has_audio=%t", index, gcsURI, hasAudio)
+	return fmt.Sprintf("video %d has_audio=%t", index, hasAudio)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"google.golang.org/genai"
+)
+
+// mp4Box builds a minimal MP4 box with a 32-bit size header.
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(body)))
+	copy(header[4:], boxType)
+	return append(header, body...)
+}
+
+// hdlrBox builds a handler reference box for the given handler type.
+func hdlrBox(handlerType string) []byte {
+	payload := make([]byte, 20)
+	copy(payload[8:12], handlerType)
+	return mp4Box("hdlr", payload)
+}
+
+func TestMP4HasSoundTrack(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom"))
+	mdat := mp4Box("mdat", make([]byte, 64))
+	videoTrak := mp4Box("trak", mp4Box("tkhd", make([]byte, 12)), mp4Box("mdia", hdlrBox("vide")))
+	audioTrak := mp4Box("trak", mp4Box("mdia", mp4Box("mdhd", make([]byte, 8)), hdlrBox("soun")))
+
+	testCases := []struct {
+		name      string
+		data      []byte
+		expected  bool
+		expectErr bool
+	}{
+		{"video only", bytes.Join([][]byte{ftyp, mp4Box("moov", videoTrak), mdat}, nil), false, false},
+		{"video and audio", bytes.Join([][]byte{ftyp, mdat, mp4Box("moov", videoTrak, audioTrak)}, nil), true, false},
+		{"no moov", bytes.Join([][]byte{ftyp, mdat}, nil), false, false},
+		{"truncated box", append(ftyp, 0, 0, 1, 0, 'm', 'o', 'o', 'v'), false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := mp4HasSoundTrack(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %t, but got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDescribeAudioCheck(t *testing.T) {
+	original := audioStreamChecker
+	defer func() { audioStreamChecker = original }()
+
+	var gotLocal, gotURI string
+	audioStreamChecker = func(ctx context.Context, localPath, gcsURI string) (bool, error) {
+		gotLocal, gotURI = localPath, gcsURI
+		return true, nil
+	}
+	if actual := describeAudioCheck(context.Background(), 0, "/tmp/out.mp4", "gs://bucket/out.mp4"); actual != "video 0 has_audio=true" {
+		t.Errorf("unexpected result: %s", actual)
+	}
+	if gotLocal != "/tmp/out.mp4" || gotURI != "gs://bucket/out.mp4" {
+		t.Errorf("checker called with (%q, %q)", gotLocal, gotURI)
+	}
+
+	audioStreamChecker = func(ctx context.Context, localPath, gcsURI string) (bool, error) {
+		return false, errors.New("probe failed")
+	}
+	if actual := describeAudioCheck(context.Background(), 1, "", "gs://bucket/out.mp4"); !strings.Contains(actual, "has_audio=unknown") {
+		t.Errorf("expected unknown result, but got: %s", actual)
+	}
+}
+
+// countingObject serves an in-memory MP4 and counts the bytes read from it.
+type countingObject struct {
+	*bytes.Reader
+	read   int64
+	closed bool
+}
+
+func (o *countingObject) ReadAt(p []byte, off int64) (int, error) {
+	n, err := o.Reader.ReadAt(p, off)
+	o.read += int64(n)
+	return n, err
+}
+
+func (o *countingObject) Close() error {
+	o.closed = true
+	return nil
+}
+
+func TestCheckAudioStreamReadsGCSHeadersOnly(t *testing.T) {
+	original := openRangedVideoObject
+	defer func() { openRangedVideoObject = original }()
+
+	audioTrak := mp4Box("trak", mp4Box("mdia", hdlrBox("soun")))
+	data := bytes.Join([][]byte{mp4Box("ftyp", []byte("isom")), mp4Box("mdat", make([]byte, 1<<20)), mp4Box("moov", audioTrak)}, nil)
+	object := &countingObject{Reader: bytes.NewReader(data)}
+	openRangedVideoObject = func(ctx context.Context, gcsURI string) (rangedObject, error) {
+		return object, nil
+	}
+
+	hasAudio, err := checkAudioStream(context.Background(), "", "gs://bucket/out.mp4")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if !hasAudio {
+		t.Errorf("expected an audio track to be found")
+	}
+	if object.read > 1024 {
+		t.Errorf("expected only box headers to be read, but read %d bytes", object.read)
+	}
+	if !object.closed {
+		t.Errorf("expected the object to be closed")
+	}
+}
+
+func TestShouldCheckAudio(t *testing.T) {
+	var audioModel, silentModel string
+	for name, model := range common.SupportedVeoModels {
+		if model.SupportsAudio {
+			audioModel = name
+		} else {
+			silentModel = name
+		}
+	}
+	enabled, disabled := true, false
+
+	testCases := []struct {
+		name     string
+		config   *genai.GenerateVideosConfig
+		model    string
+		expected bool
+	}{
+		{"requested on audio model", &genai.GenerateVideosConfig{GenerateAudio: &enabled}, audioModel, true},
+		{"not requested", &genai.GenerateVideosConfig{}, audioModel, false},
+		{"disabled", &genai.GenerateVideosConfig{GenerateAudio: &disabled}, audioModel, false},
+		{"model without audio", &genai.GenerateVideosConfig{GenerateAudio: &enabled}, silentModel, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := shouldCheckAudio(tc.config, tc.model); actual != tc.expected {
+				t.Errorf("expected %t, but got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFFprobePathUsesConfig(t *testing.T) {
+	original := appConfig
+	defer func() { appConfig = original }()
+
+	appConfig = &common.Config{FFprobePath: "/opt/ffmpeg-static/ffprobe"}
+	if actual := ffprobePath(); actual != "/opt/ffmpeg-static/ffprobe" {
+		t.Errorf("expected the configured ffprobe, but got %q", actual)
+	}
+	appConfig = nil
+	if actual := ffprobePath(); actual != "ffprobe" {
+		t.Errorf("expected the PATH default, but got %q", actual)
+	}
+}
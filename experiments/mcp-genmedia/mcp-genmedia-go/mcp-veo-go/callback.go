@@ -0,0 +1,273 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+const (
+	// callbackSignatureHeader carries the HMAC-SHA256 of the callback body, as "sha256=<hex>".
+	callbackSignatureHeader = "X-Veo-Signature"
+	// callbackMaxAttempts is how many times a callback is sent before it is given up on.
+	callbackMaxAttempts = 5
+
+	callbackStatusSucceeded = "succeeded"
+	callbackStatusFailed    = "failed"
+)
+
+var (
+	// callbackHTTPClient sends the callbacks. It is a variable so tests can trust their TLS receiver.
+	// Redirects are never followed, see postCallback.
+	callbackHTTPClient = &http.Client{Timeout: 30 * time.Second, CheckRedirect: refuseCallbackRedirect}
+	// callbackRetryDelay is the wait before the second attempt at a callback; it doubles for each
+	// attempt after that. It is a variable so tests can retry without waiting.
+	callbackRetryDelay = 2 * time.Second
+)
+
+// videoCallback is where to send the outcome of a generation, and the secret it is signed with.
+type videoCallback struct {
+	URL    string
+	Secret string
+}
+
+// callbackPayload is the JSON body POSTed to a callback URL once the operation is done.
+type callbackPayload struct {
+	OperationName string   `json:"operation_name"`
+	Status        string   `json:"status"`
+	CallType      string   `json:"call_type"`
+	Model         string   `json:"model"`
+	OutputURIs    []string `json:"output_uris,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	CompletedAt   string   `json:"completed_at"`
+}
+
+// parseCallbackParam reads the optional 'callback_url'. Callbacks need VEO_CALLBACK_SECRET to
+// sign them and a URL on one of the hosts of CALLBACK_ALLOWED_HOSTS. As the tool call returns
+// before the videos exist, they cannot be downloaded to 'output_directory'. It returns nil when
+// no callback was requested.
+func parseCallbackParam(args map[string]interface{}, cfg *common.Config, outputDir string) (*videoCallback, error) {
+	rawURL, _ := args["callback_url"].(string)
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return nil, nil
+	}
+	secret := os.Getenv("VEO_CALLBACK_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("callback_url is not enabled on this server; VEO_CALLBACK_SECRET must be set to sign callbacks")
+	}
+	var allowedHosts []string
+	if cfg != nil {
+		allowedHosts = cfg.CallbackAllowedHosts
+	}
+	if err := validateCallbackURL(rawURL, allowedHosts); err != nil {
+		return nil, err
+	}
+	if outputDir != "" {
+		return nil, fmt.Errorf("callback_url cannot be combined with output_directory; with a callback the tool returns before the videos exist, so they are only saved to GCS")
+	}
+	return &videoCallback{URL: rawURL, Secret: secret}, nil
+}
+
+// validateCallbackURL checks that a callback URL is an https URL on an allowed host.
+func validateCallbackURL(rawURL string, allowedHosts []string) error {
+	if len(allowedHosts) == 0 {
+		return fmt.Errorf("callback_url is not enabled on this server; CALLBACK_ALLOWED_HOSTS must list the hosts callbacks may be sent to")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("callback_url '%s' is not a valid URL: %v", rawURL, err)
+	}
+	if u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("callback_url '%s' must be an https URL", rawURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("callback_url '%s' must not contain credentials", rawURL)
+	}
+	for _, pattern := range allowedHosts {
+		if callbackHostMatches(pattern, u) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback_url host '%s' is not allowed; allowed hosts: %s", u.Host, strings.Join(allowedHosts, ", "))
+}
+
+// callbackHostMatches reports whether a URL's host matches an allow-list pattern. A pattern
+// with a port must match the host and port; "*.example.com" matches any subdomain of
+// example.com, but not example.com itself.
+func callbackHostMatches(pattern string, u *url.URL) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host := strings.ToLower(u.Hostname())
+	if patternHost, patternPort, found := strings.Cut(pattern, ":"); found && !strings.Contains(patternPort, ":") {
+		if patternPort != u.Port() {
+			return false
+		}
+		pattern = patternHost
+	}
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == pattern
+}
+
+// signCallbackBody returns the value of the signature header for a callback body.
+func signCallbackBody(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendCallback POSTs the signed payload to the callback URL. Server errors and failed requests
+// are retried, with a doubling delay, up to callbackMaxAttempts times; any other non-2xx
+// response is final.
+func sendCallback(ctx context.Context, callback *videoCallback, payload callbackPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding callback payload: %w", err)
+	}
+	signature := signCallbackBody(body, callback.Secret)
+
+	delay := callbackRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := postCallback(ctx, callback.URL, body, signature)
+		if err == nil {
+			log.Printf("Callback for operation %s delivered to %s (attempt %d)", payload.OperationName, callback.URL, attempt)
+			return nil
+		}
+		if !retry || attempt == callbackMaxAttempts || ctx.Err() != nil {
+			return fmt.Errorf("callback to %s failed after %d attempt(s): %w", callback.URL, attempt, err)
+		}
+		log.Printf("Callback for operation %s to %s failed (attempt %d of %d), retrying in %v: %v", payload.OperationName, callback.URL, attempt, callbackMaxAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("callback to %s canceled: %w", callback.URL, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+// refuseCallbackRedirect stops the client at a redirect. Only the callback URL itself was checked
+// against CALLBACK_ALLOWED_HOSTS, so following a redirect could send the signed payload to any
+// address, internal ones included; the redirect is reported as a non-retryable failure instead.
+func refuseCallbackRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// postCallback makes one attempt at a callback. It reports whether a failure is worth retrying.
+func postCallback(ctx context.Context, callbackURL string, body []byte, signature string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(callbackSignatureHeader, signature)
+	client := *callbackHTTPClient
+	client.CheckRedirect = refuseCallbackRedirect
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+}
+
+// startVideosOperationWithCallback starts a GenerateVideos operation and returns its name right
+// away, leaving a goroutine to poll it and send the outcome to the callback. The goroutine
+// outlives the tool call, so it is not canceled with the request; it is bounded by
+// videoOperationTimeout like a synchronous call.
+func startVideosOperationWithCallback(
+	client *genai.Client,
+	ctx context.Context,
+	callback *videoCallback,
+	modelName string,
+	prompt string,
+	image *genai.Image,
+	config *genai.GenerateVideosConfig,
+	callType string,
+) *mcp.CallToolResult {
+	backgroundCtx := context.WithoutCancel(ctx)
+	operationCtx, operationCancel := context.WithTimeout(backgroundCtx, videoOperationTimeout)
+	startTime := time.Now()
+
+	log.Printf("Initiating GenerateVideos (%s) with Model: %s, OutputGCS: %s. The result will be sent to %s", callType, modelName, config.OutputGCSURI, callback.URL)
+	operation, failure := startVideosOperation(client, operationCtx, modelName, prompt, image, config, callType)
+	if failure != nil {
+		operationCancel()
+		return failure
+	}
+
+	go func() {
+		defer operationCancel()
+		payload := callbackPayload{OperationName: operation.Name, CallType: callType, Model: modelName}
+		done, _, failure := pollVideosOperation(client, backgroundCtx, operationCtx, nil, nil, operation, callType, startTime)
+		switch {
+		case failure != nil:
+			payload.Status, payload.Error = callbackStatusFailed, callToolText(failure)
+		default:
+			payload.OutputURIs = generatedVideoURIs(done)
+			if len(payload.OutputURIs) == 0 {
+				payload.Status, payload.Error = callbackStatusFailed, "the operation completed but no videos were generated"
+			} else {
+				payload.Status = callbackStatusSucceeded
+			}
+		}
+		payload.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := sendCallback(backgroundCtx, callback, payload); err != nil {
+			log.Printf("Giving up on the callback for operation %s: %v", operation.Name, err)
+		}
+	}()
+
+	return mcp.NewToolResultText(fmt.Sprintf("Video generation (%s) started as operation %s using model %s. The result will be POSTed to %s when it finishes.", callType, operation.Name, modelName, callback.URL))
+}
+
+// generatedVideoURIs returns the GCS URIs of the videos of a completed operation.
+func generatedVideoURIs(operation *genai.GenerateVideosOperation) []string {
+	if operation == nil || operation.Response == nil {
+		return nil
+	}
+	var uris []string
+	for _, generatedVideo := range operation.Response.GeneratedVideos {
+		if generatedVideo.Video != nil && generatedVideo.Video.URI != "" {
+			uris = append(uris, generatedVideo.Video.URI)
+		}
+	}
+	return uris
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+)
+
+// openVideoObject opens a generated video in GCS for reading.
+// It is a variable so tests can substitute a stub.
+var openVideoObject = common.OpenGCSObject
+
+// unsafeFileNameChars matches the runs of characters replaced in a requested output_file_name.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// videoDownload is the local copy of one generated video.
+type videoDownload struct {
+	GCSURI    string
+	LocalPath string
+	Err       error
+}
+
+// sanitizeVideoFileName reduces a requested file name to a single safe path segment without its
+// extension, or "" when nothing usable is left.
+func sanitizeVideoFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(strings.TrimSpace(name), "\\", "/"))
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = unsafeFileNameChars.ReplaceAllString(name, "_")
+	return strings.TrimLeft(name, "._")
+}
+
+// localVideoFileName names the local copy of video index of count. A requested name is used as
+// is for a single video and gets an index suffix when there are several; without one, the name
+// is built from the model and the time of the request.
+func localVideoFileName(outputFileName, modelName string, index, count int, now time.Time) string {
+	stem := sanitizeVideoFileName(outputFileName)
+	if stem == "" {
+		return fmt.Sprintf("veo-%s-%s-%d.mp4", modelName, now.Format("20060102-150405"), index)
+	}
+	if count > 1 {
+		return fmt.Sprintf("%s-%d.mp4", stem, index)
+	}
+	return stem + ".mp4"
+}
+
+// downloadVideo streams a video from GCS to localPath. The video is written to a temporary file
+// in the same directory and renamed when complete, so a failed download leaves no partial file.
+func downloadVideo(ctx context.Context, gcsURI, localPath string) error {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating directory %s: %w", dir, err)
+	}
+	rc, err := openVideoObject(ctx, gcsURI)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(dir, ".veo-download-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file in %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		return fmt.Errorf("copying %s: %w", gcsURI, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp.Name(), err)
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+// downloadVideos copies each generated video to outputDir. A failed download is recorded on its
+// entry and does not stop the others, since every video is still available in GCS.
+func downloadVideos(ctx context.Context, gcsURIs []string, outputDir, outputFileName, modelName string) []videoDownload {
+	now := time.Now()
+	downloads := make([]videoDownload, len(gcsURIs))
+	for i, gcsURI := range gcsURIs {
+		localPath := filepath.Join(outputDir, localVideoFileName(outputFileName, modelName, i, len(gcsURIs), now))
+		log.Printf("Attempting to download video %d from GCS URI %s to %s", i, gcsURI, localPath)
+		downloads[i] = videoDownload{GCSURI: gcsURI, LocalPath: localPath}
+		if err := downloadVideo(ctx, gcsURI, localPath); err != nil {
+			log.Printf("Error downloading video %d from %s to %s: %v", i, gcsURI, localPath, err)
+			downloads[i].LocalPath = ""
+			downloads[i].Err = err
+			continue
+		}
+		log.Printf("Successfully downloaded and saved video %d to %s", i, localPath)
+	}
+	return downloads
+}
+
+// describeDownloads reports where each video was downloaded to and, as a warning, which
+// downloads failed.
+func describeDownloads(outputDir string, downloads []videoDownload) []string {
+	var saved, failed []string
+	for i, d := range downloads {
+		if d.Err != nil {
+			failed = append(failed, fmt.Sprintf("video %d (%s): %v", i, d.GCSURI, d.Err))
+			continue
+		}
+		saved = append(saved, fmt.Sprintf("%s -> %s", d.GCSURI, d.LocalPath))
+	}
+	var parts []string
+	if len(saved) > 0 {
+		parts = append(parts, fmt.Sprintf("Downloaded locally to '%s': %s.", outputDir, strings.Join(saved, ", ")))
+	}
+	if len(failed) > 0 {
+		parts = append(parts, fmt.Sprintf("Warning: %d of %d local downloads failed; the GCS copies are unaffected: %s.", len(failed), len(downloads), strings.Join(failed, "; ")))
+	}
+	return parts
+}
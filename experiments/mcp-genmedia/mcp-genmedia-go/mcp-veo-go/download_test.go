@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubVideoObjects serves the given GCS objects from memory; other URIs fail as missing.
+func stubVideoObjects(t *testing.T, objects map[string]string) {
+	t.Helper()
+	original := openVideoObject
+	t.Cleanup(func() { openVideoObject = original })
+	openVideoObject = func(ctx context.Context, gcsURI string) (io.ReadCloser, error) {
+		data, ok := objects[gcsURI]
+		if !ok {
+			return nil, errors.New("storage: object doesn't exist")
+		}
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+}
+
+func TestLocalVideoFileName(t *testing.T) {
+	now := time.Date(2025, 9, 1, 14, 30, 5, 0, time.UTC)
+	testCases := []struct {
+		outputFileName string
+		index, count   int
+		want           string
+	}{
+		{"", 1, 2, "veo-veo-2.0-generate-001-20250901-143005-1.mp4"},
+		{"intro.mp4", 0, 1, "intro.mp4"},
+		{"intro.mp4", 1, 3, "intro-1.mp4"},
+		{"intro", 0, 1, "intro.mp4"},
+		{"../../etc/launch clip.MOV", 0, 1, "launch_clip.mp4"},
+		{"..", 0, 1, "veo-veo-2.0-generate-001-20250901-143005-0.mp4"},
+	}
+	for _, tc := range testCases {
+		if got := localVideoFileName(tc.outputFileName, "veo-2.0-generate-001", tc.index, tc.count, now); got != tc.want {
+			t.Errorf("localVideoFileName(%q, %d, %d) = %s, want %s", tc.outputFileName, tc.index, tc.count, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadVideosMultipleSamples(t *testing.T) {
+	stubVideoObjects(t, map[string]string{
+		"gs://out-bucket/veo_outputs/1/sample_0.mp4": "first",
+		"gs://out-bucket/veo_outputs/1/sample_1.mp4": "second",
+	})
+	dir := filepath.Join(t.TempDir(), "videos")
+
+	downloads := downloadVideos(context.Background(), []string{
+		"gs://out-bucket/veo_outputs/1/sample_0.mp4",
+		"gs://out-bucket/veo_outputs/1/sample_1.mp4",
+	}, dir, "teaser.mp4", "veo-2.0-generate-001")
+
+	for i, want := range []string{"first", "second"} {
+		d := downloads[i]
+		if d.Err != nil {
+			t.Fatalf("video %d: unexpected error %v", i, d.Err)
+		}
+		if d.LocalPath != filepath.Join(dir, []string{"teaser-0.mp4", "teaser-1.mp4"}[i]) {
+			t.Errorf("video %d saved to %s", i, d.LocalPath)
+		}
+		if data, err := os.ReadFile(d.LocalPath); err != nil || string(data) != want {
+			t.Errorf("video %d: read %q, %v; want %q", i, data, err, want)
+		}
+	}
+}
+
+func TestDownloadVideosPartialFailure(t *testing.T) {
+	stubVideoObjects(t, map[string]string{"gs://out-bucket/a.mp4": "video"})
+	dir := t.TempDir()
+
+	downloads := downloadVideos(context.Background(), []string{"gs://out-bucket/a.mp4", "gs://out-bucket/b.mp4"}, dir, "", "veo-3.0-generate-001")
+	if downloads[0].Err != nil || downloads[0].LocalPath == "" {
+		t.Fatalf("expected the first video to be downloaded, got %+v", downloads[0])
+	}
+	if downloads[1].Err == nil || downloads[1].LocalPath != "" {
+		t.Fatalf("expected the second download to fail, got %+v", downloads[1])
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the downloaded video in %s, got %v", dir, entries)
+	}
+
+	parts := describeDownloads(dir, downloads)
+	if len(parts) != 2 {
+		t.Fatalf("expected a saved and a warning part, got %q", parts)
+	}
+	if !strings.Contains(parts[0], "gs://out-bucket/a.mp4 -> "+downloads[0].LocalPath) {
+		t.Errorf("expected the local path next to its GCS URI, got %q", parts[0])
+	}
+	if !strings.HasPrefix(parts[1], "Warning: 1 of 2 local downloads failed") || !strings.Contains(parts[1], "video 1 (gs://out-bucket/b.mp4)") {
+		t.Errorf("unexpected warning %q", parts[1])
+	}
+}
+
+func TestOutputFileNameRequiresOutputDirectory(t *testing.T) {
+	stubVeoDependencies(t)
+	client, calls, _ := newStubVeoClient(t)
+
+	result, err := veoTextToVideoHandler(client, context.Background(), i2vRequest(map[string]interface{}{
+		"prompt":           "a lighthouse at dusk",
+		"output_file_name": "lighthouse.mp4",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.IsError || !strings.Contains(resultText(result), "output_file_name requires output_directory") {
+		t.Errorf("expected an output_directory error, got %s", resultText(result))
+	}
+	if *calls != 0 {
+		t.Errorf("expected no API calls, got %d", *calls)
+	}
+}
+
+func TestTextToVideoDownloadsLocally(t *testing.T) {
+	stubVeoDependencies(t)
+	stubVideoObjects(t, map[string]string{"gs://out-bucket/veo_outputs/video.mp4": "mp4 data"})
+	client, _, _ := newStubVeoClient(t)
+	dir := t.TempDir()
+
+	result, err := veoTextToVideoHandler(client, context.Background(), i2vRequest(map[string]interface{}{
+		"prompt":           "a lighthouse at dusk",
+		"output_directory": dir,
+		"output_file_name": "lighthouse",
+	}))
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %s", err, resultText(result))
+	}
+	localPath := filepath.Join(dir, "lighthouse.mp4")
+	if data, err := os.ReadFile(localPath); err != nil || string(data) != "mp4 data" {
+		t.Errorf("expected the video at %s, got %q, %v", localPath, data, err)
+	}
+	if text := resultText(result); !strings.Contains(text, "gs://out-bucket/veo_outputs/video.mp4 -> "+localPath) {
+		t.Errorf("expected the local path in the result, got %q", text)
+	}
+}
@@ -33,9 +33,9 @@ func veoTextToVideoHandler(client *genai.Client, ctx context.Context, request mc
 	ctx, span := tr.Start(ctx, "veo_t2v")
 	defer span.End()
 
-	prompt, ok := request.GetArguments()["prompt"].(string)
-	if !ok || strings.TrimSpace(prompt) == "" {
-		return mcp.NewToolResultError("prompt must be a non-empty string and is required for text-to-video"), nil
+	prompt, template, libraryVersion, err := templatePrompt(ctx, promptTemplates, request.GetArguments())
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
 	}
 
 	gcsBucket, outputDir, model, finalAspectRatio, numberOfVideos, durationSecs, err := parseCommonVideoParams(request.GetArguments(), appConfig)
@@ -43,15 +43,39 @@ func veoTextToVideoHandler(client *genai.Client, ctx context.Context, request mc
 		return mcp.NewToolResultError(err.Error()), nil
 	}
 
+	generateAudio, err := parseGenerateAudioParam(request.GetArguments(), model)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	outputFileName, err := parseOutputFileNameParam(request.GetArguments(), outputDir)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	callback, err := parseCallbackParam(request.GetArguments(), appConfig, outputDir)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
 	span.SetAttributes(
 		attribute.String("prompt", prompt),
 		attribute.String("gcs_bucket", gcsBucket),
 		attribute.String("output_dir", outputDir),
+		attribute.String("output_file_name", outputFileName),
 		attribute.String("model", model),
 		attribute.String("aspect_ratio", finalAspectRatio),
 		attribute.Int("num_videos", int(numberOfVideos)),
 		attribute.Int("duration_secs", int(durationSecs)),
+		attribute.Bool("generate_audio", generateAudio != nil && *generateAudio),
+		attribute.Bool("callback", callback != nil),
 	)
+	if template != nil {
+		span.SetAttributes(
+			attribute.String("template_id", template.ID),
+			attribute.String("template_version", template.Version),
+		)
+	}
 
 	mcpServer := server.ServerFromContext(ctx)
 	var progressToken mcp.ProgressToken
@@ -72,9 +96,22 @@ func veoTextToVideoHandler(client *genai.Client, ctx context.Context, request mc
 		AspectRatio:     finalAspectRatio,
 		OutputGCSURI:    gcsBucket,
 		DurationSeconds: &durationSecs,
+		GenerateAudio:   generateAudio,
 	}
 
-	return callGenerateVideosAPI(client, ctx, mcpServer, progressToken, outputDir, model, prompt, nil, config, "t2v")
+	var result *mcp.CallToolResult
+	if callback != nil {
+		result = startVideosOperationWithCallback(client, ctx, callback, model, prompt, nil, config, "t2v")
+	} else {
+		result, err = callGenerateVideosAPI(client, ctx, mcpServer, progressToken, outputDir, outputFileName, model, prompt, nil, config, "t2v")
+	}
+	if err != nil || result == nil || result.IsError || template == nil {
+		return result, err
+	}
+
+	// Record which template produced the prompt.
+	result.Content = append(result.Content, mcp.NewTextContent(fmt.Sprintf("Template: %s (version %s, library version %s). Prompt: %s", template.ID, template.Version, libraryVersion, prompt)))
+	return result, nil
 }
 
 // veoImageToVideoHandler is the handler for the 'veo_i2v' tool.
@@ -85,10 +122,7 @@ func veoImageToVideoHandler(client *genai.Client, ctx context.Context, request m
 
 	imageURI, ok := request.GetArguments()["image_uri"].(string)
 	if !ok || strings.TrimSpace(imageURI) == "" {
-		return mcp.NewToolResultError("image_uri must be a non-empty string (GCS URI) and is required for image-to-video"), nil
-	}
-	if !strings.HasPrefix(imageURI, "gs://") {
-		return mcp.NewToolResultError(fmt.Sprintf("invalid image_uri '%s'. Must be a GCS URI starting with 'gs://'", imageURI)), nil
+		return mcp.NewToolResultError("image_uri must be a non-empty string (GCS URI or local path) and is required for image-to-video"), nil
 	}
 
 	var mimeType string
@@ -118,16 +152,60 @@ func veoImageToVideoHandler(client *genai.Client, ctx context.Context, request m
 		return mcp.NewToolResultError(err.Error()), nil
 	}
 
+	generateAudio, err := parseGenerateAudioParam(request.GetArguments(), modelName)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	outputFileName, err := parseOutputFileNameParam(request.GetArguments(), outputDir)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	callback, err := parseCallbackParam(request.GetArguments(), appConfig, outputDir)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	lastFrameURI, err := parseLastFrameParam(request.GetArguments(), modelName)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	var lastFrameMimeType string
+	if lastFrameURI != "" {
+		lastFrameMimeType = inferMimeTypeFromURI(lastFrameURI)
+		if lastFrameMimeType == "" {
+			return mcp.NewToolResultError(fmt.Sprintf("MIME type for last frame image '%s' could not be inferred or is not supported. Use a .png, .jpg or .jpeg image.", lastFrameURI)), nil
+		}
+	}
+
+	stagedImageURI, err := stageImageInput(ctx, imageURI, gcsBucket, mimeType)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	var stagedLastFrameURI string
+	if lastFrameURI != "" {
+		stagedLastFrameURI, err = stageImageInput(ctx, lastFrameURI, gcsBucket, lastFrameMimeType)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	}
+
 	span.SetAttributes(
 		attribute.String("image_uri", imageURI),
+		attribute.String("staged_image_uri", stagedImageURI),
+		attribute.String("last_frame_image_uri", lastFrameURI),
 		attribute.String("mime_type", mimeType),
 		attribute.String("prompt", prompt),
 		attribute.String("gcs_bucket", gcsBucket),
 		attribute.String("output_dir", outputDir),
+		attribute.String("output_file_name", outputFileName),
 		attribute.String("model", modelName),
 		attribute.String("aspect_ratio", finalAspectRatio),
 		attribute.Int("num_videos", int(numberOfVideos)),
 		attribute.Int("duration_secs", int(durationSecs)),
+		attribute.Bool("generate_audio", generateAudio != nil && *generateAudio),
+		attribute.Bool("callback", callback != nil),
 	)
 
 	mcpServer := server.ServerFromContext(ctx)
@@ -141,11 +219,11 @@ func veoImageToVideoHandler(client *genai.Client, ctx context.Context, request m
 		log.Printf("Incoming i2v context for image_uri \"%s\" was already canceled: %v", imageURI, ctx.Err())
 		return mcp.NewToolResultError(fmt.Sprintf("request processing canceled early: %v", ctx.Err())), nil
 	default:
-		log.Printf("Handling Veo i2v request: ImageURI=\"%%s\", MimeType=\"%%s\", Prompt=\"%%s\", GCSBucket=%s, OutputDir='%s', Model=%s, NumVideos=%d, AspectRatio=%s, Duration=%ds", imageURI, mimeType, prompt, gcsBucket, outputDir, modelName, numberOfVideos, finalAspectRatio, durationSecs)
+		log.Printf("Handling Veo i2v request: ImageURI=\"%s\", MimeType=\"%s\", Prompt=\"%s\", GCSBucket=%s, OutputDir='%s', Model=%s, NumVideos=%d, AspectRatio=%s, Duration=%ds", imageURI, mimeType, prompt, gcsBucket, outputDir, modelName, numberOfVideos, finalAspectRatio, durationSecs)
 	}
 
 	inputImage := &genai.Image{
-		GCSURI:   imageURI,
+		GCSURI:   stagedImageURI,
 		MIMEType: mimeType,
 	}
 
@@ -154,7 +232,30 @@ func veoImageToVideoHandler(client *genai.Client, ctx context.Context, request m
 		AspectRatio:     finalAspectRatio,
 		OutputGCSURI:    gcsBucket,
 		DurationSeconds: &durationSecs,
+		GenerateAudio:   generateAudio,
+	}
+	if stagedLastFrameURI != "" {
+		config.LastFrame = &genai.Image{
+			GCSURI:   stagedLastFrameURI,
+			MIMEType: lastFrameMimeType,
+		}
+	}
+
+	var result *mcp.CallToolResult
+	if callback != nil {
+		result = startVideosOperationWithCallback(client, ctx, callback, modelName, prompt, inputImage, config, "i2v")
+	} else {
+		result, err = callGenerateVideosAPI(client, ctx, mcpServer, progressToken, outputDir, outputFileName, modelName, prompt, inputImage, config, "i2v")
+	}
+	if err != nil || result == nil || result.IsError {
+		return result, err
 	}
 
-	return callGenerateVideosAPI(client, ctx, mcpServer, progressToken, outputDir, modelName, prompt, inputImage, config, "i2v")
+	// Report where each frame came from and where Veo read it from.
+	trace := fmt.Sprintf("First frame: %s (staged: %s).", imageURI, stagedImageURI)
+	if lastFrameURI != "" {
+		trace += fmt.Sprintf(" Last frame: %s (staged: %s).", lastFrameURI, stagedLastFrameURI)
+	}
+	result.Content = append(result.Content, mcp.NewTextContent(trace))
+	return result, nil
 }
\ No newline at end of file
@@ -0,0 +1,176 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/genai"
+)
+
+// newStubVeoClient returns a Vertex client whose predictLongRunning calls are
+// answered by a local server with one finished video. Each request body is
+// decoded into the returned map.
+func newStubVeoClient(t *testing.T) (*genai.Client, *int, map[string]interface{}) {
+	t.Helper()
+	calls := 0
+	payload := map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !strings.HasSuffix(r.URL.Path, ":predictLongRunning") {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"name": "operations/op-1", "done": true, "response": {"videos": [{"gcsUri": "gs://out-bucket/veo_outputs/video.mp4", "mimeType": "video/mp4"}]}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		Backend:     genai.BackendVertexAI,
+		Project:     "test-project",
+		Location:    "us-central1",
+		HTTPClient:  &http.Client{},
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("creating stub client: %v", err)
+	}
+	return client, &calls, payload
+}
+
+func stubVeoDependencies(t *testing.T) map[string][]byte {
+	t.Helper()
+	origConfig, origUpload, origChecker := appConfig, stageUpload, audioStreamChecker
+	t.Cleanup(func() { appConfig, stageUpload, audioStreamChecker = origConfig, origUpload, origChecker })
+
+	appConfig = &common.Config{GenmediaBucket: "out-bucket"}
+	uploads := map[string][]byte{}
+	stageUpload = func(ctx context.Context, bucketName, objectName, contentType string, data []byte) error {
+		uploads["gs://"+bucketName+"/"+objectName] = data
+		return nil
+	}
+	audioStreamChecker = func(ctx context.Context, localPath, gcsURI string) (bool, error) { return false, nil }
+	return uploads
+}
+
+func i2vRequest(args map[string]interface{}) mcp.CallToolRequest {
+	var request mcp.CallToolRequest
+	request.Params.Arguments = args
+	return request
+}
+
+func resultText(result *mcp.CallToolResult) string {
+	var parts []string
+	for _, c := range result.Content {
+		if text, ok := c.(mcp.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func TestImageToVideoLastFrame(t *testing.T) {
+	uploads := stubVeoDependencies(t)
+	client, _, payload := newStubVeoClient(t)
+
+	lastFrame := filepath.Join(t.TempDir(), "end.jpg")
+	if err := os.WriteFile(lastFrame, []byte("jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := veoImageToVideoHandler(client, context.Background(), i2vRequest(map[string]interface{}{
+		"image_uri":            "gs://in-bucket/start.png",
+		"last_frame_image_uri": lastFrame,
+		"model":                "Veo 2",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("unexpected tool error: %s", resultText(result))
+	}
+
+	if len(uploads) != 1 {
+		t.Fatalf("expected only the local last frame to be staged, got %v", uploads)
+	}
+	var stagedURI string
+	for uri := range uploads {
+		stagedURI = uri
+	}
+	if !strings.HasPrefix(stagedURI, "gs://out-bucket/veo_inputs/") || !strings.HasSuffix(stagedURI, "-end.jpg") {
+		t.Errorf("unexpected staged URI %s", stagedURI)
+	}
+
+	instances, _ := payload["instances"].([]interface{})
+	if len(instances) != 1 {
+		t.Fatalf("expected one instance in the request, got %v", payload)
+	}
+	instance := instances[0].(map[string]interface{})
+	for field, want := range map[string][2]string{
+		"image":     {"gs://in-bucket/start.png", "image/png"},
+		"lastFrame": {stagedURI, "image/jpeg"},
+	} {
+		frame, _ := instance[field].(map[string]interface{})
+		if frame["gcsUri"] != want[0] || frame["mimeType"] != want[1] {
+			t.Errorf("%s = %v, want gcsUri %s and mimeType %s", field, frame, want[0], want[1])
+		}
+	}
+
+	text := resultText(result)
+	for _, want := range []string{"gs://in-bucket/start.png", lastFrame, stagedURI, "gs://out-bucket/veo_outputs/video.mp4"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the result to mention %s, got %q", want, text)
+		}
+	}
+}
+
+func TestImageToVideoLastFrameUnsupportedModel(t *testing.T) {
+	uploads := stubVeoDependencies(t)
+	client, calls, _ := newStubVeoClient(t)
+
+	result, err := veoImageToVideoHandler(client, context.Background(), i2vRequest(map[string]interface{}{
+		"image_uri":            "gs://in-bucket/start.png",
+		"last_frame_image_uri": "gs://in-bucket/end.png",
+		"model":                "veo-3.0-generate-preview",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.IsError {
+		t.Fatalf("expected a tool error, got %s", resultText(result))
+	}
+	if text := resultText(result); !strings.Contains(text, "veo-2.0-generate-001") {
+		t.Errorf("expected the error to list supported models, got %q", text)
+	}
+	if *calls != 0 || len(uploads) != 0 {
+		t.Errorf("expected no API calls or uploads, got %d calls and %d uploads", *calls, len(uploads))
+	}
+}
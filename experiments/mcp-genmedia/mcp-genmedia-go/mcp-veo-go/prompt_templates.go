@@ -0,0 +1,253 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// templateRevalidateInterval is how long a loaded template library is used before its ETag
+// is checked again, so edits made in Creative Studio reach the server within a few minutes.
+const templateRevalidateInterval = 3 * time.Minute
+
+// templatePlaceholder matches a {{variable}} placeholder; spaces inside the braces are allowed.
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// promptTemplate is one vetted prompt of the shared template library.
+type promptTemplate struct {
+	ID          string `json:"id"`
+	Version     string `json:"version"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Prompt      string `json:"prompt"`
+	// Variables are the placeholder names of Prompt, sorted. They are derived from Prompt
+	// when the library is loaded.
+	Variables []string `json:"variables"`
+}
+
+// templateLibrary is the JSON file of prompt templates maintained by the Creative Studio UI.
+type templateLibrary struct {
+	Version   string           `json:"version"`
+	Templates []promptTemplate `json:"templates"`
+}
+
+// parseTemplateLibrary decodes and checks a template library. Every template needs a unique id
+// and a prompt.
+func parseTemplateLibrary(data []byte) (*templateLibrary, error) {
+	var library templateLibrary
+	if err := json.Unmarshal(data, &library); err != nil {
+		return nil, fmt.Errorf("decoding template library: %w", err)
+	}
+	seen := make(map[string]bool, len(library.Templates))
+	for i := range library.Templates {
+		t := &library.Templates[i]
+		if strings.TrimSpace(t.ID) == "" {
+			return nil, fmt.Errorf("template %d has no id", i)
+		}
+		if seen[t.ID] {
+			return nil, fmt.Errorf("duplicate template id '%s'", t.ID)
+		}
+		seen[t.ID] = true
+		if strings.TrimSpace(t.Prompt) == "" {
+			return nil, fmt.Errorf("template '%s' has no prompt", t.ID)
+		}
+		t.Variables = templateVariables(t.Prompt)
+	}
+	return &library, nil
+}
+
+// templateVariables returns the unique placeholder names of a prompt, sorted.
+func templateVariables(prompt string) []string {
+	names := map[string]bool{}
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(prompt, -1) {
+		names[m[1]] = true
+	}
+	variables := make([]string, 0, len(names))
+	for name := range names {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+	return variables
+}
+
+// find returns the template with the given id.
+func (l *templateLibrary) find(id string) (*promptTemplate, bool) {
+	for i := range l.Templates {
+		if l.Templates[i].ID == id {
+			return &l.Templates[i], true
+		}
+	}
+	return nil, false
+}
+
+// render substitutes the variables into the template's placeholders. Every placeholder must be
+// given a non-blank value; the error lists the missing ones along with all the required ones.
+func (t *promptTemplate) render(variables map[string]string) (string, error) {
+	var missing []string
+	for _, name := range t.Variables {
+		if strings.TrimSpace(variables[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("template '%s' is missing variables: %s (required: %s)", t.ID, strings.Join(missing, ", "), strings.Join(t.Variables, ", "))
+	}
+	return templatePlaceholder.ReplaceAllStringFunc(t.Prompt, func(placeholder string) string {
+		return variables[templatePlaceholder.FindStringSubmatch(placeholder)[1]]
+	}), nil
+}
+
+// templateStore serves the template library at a GCS URI. The library is cached and revalidated
+// by ETag at most every templateRevalidateInterval. When the file can't be read or parsed, the
+// last library that loaded keeps being served and a warning is logged.
+type templateStore struct {
+	uri string
+	// fetch reads the library unless its ETag matches; common.ReadGCSObjectIfChanged in production.
+	fetch func(ctx context.Context, gcsURI, etag string) ([]byte, string, bool, error)
+	now   func() time.Time
+
+	mu        sync.Mutex
+	library   *templateLibrary // last known good
+	etag      string
+	checkedAt time.Time
+	loadErr   error // why the current file could not be used, while there is no library
+}
+
+func newTemplateStore(uri string) *templateStore {
+	return &templateStore{uri: uri, fetch: common.ReadGCSObjectIfChanged, now: time.Now}
+}
+
+// promptTemplates is the shared template library, nil when VEO_TEMPLATE_GCS_URI is not set.
+var promptTemplates *templateStore
+
+// get returns the current template library, revalidating it when the cached copy is stale.
+func (s *templateStore) get(ctx context.Context) (*templateLibrary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkedAt.IsZero() && s.now().Sub(s.checkedAt) < templateRevalidateInterval {
+		return s.current()
+	}
+	s.checkedAt = s.now()
+
+	data, etag, modified, err := s.fetch(ctx, s.uri, s.etag)
+	if err != nil {
+		if s.library != nil {
+			log.Printf("Warning: could not revalidate prompt templates at %s, keeping version '%s': %v", s.uri, s.library.Version, err)
+			return s.library, nil
+		}
+		// Retry on the next call rather than waiting out the interval.
+		s.checkedAt = time.Time{}
+		return nil, fmt.Errorf("loading prompt templates from %s: %w", s.uri, err)
+	}
+	if !modified {
+		return s.current()
+	}
+
+	s.etag = etag
+	library, err := parseTemplateLibrary(data)
+	if err != nil {
+		s.loadErr = fmt.Errorf("prompt templates at %s are invalid: %w", s.uri, err)
+		if s.library != nil {
+			log.Printf("Warning: %v; keeping version '%s'", s.loadErr, s.library.Version)
+		}
+		return s.current()
+	}
+	log.Printf("Loaded %d prompt templates (version '%s') from %s", len(library.Templates), library.Version, s.uri)
+	s.library, s.loadErr = library, nil
+	return s.library, nil
+}
+
+// current returns the last known good library, or why there is none.
+func (s *templateStore) current() (*templateLibrary, error) {
+	if s.library == nil {
+		return nil, s.loadErr
+	}
+	return s.library, nil
+}
+
+// templatePrompt resolves the prompt of veo_t2v: the 'prompt' argument, or the template named by
+// 'template_id' with 'template_variables' substituted. The template is returned with the library
+// version so the result can record what was used.
+func templatePrompt(ctx context.Context, store *templateStore, args map[string]interface{}) (string, *promptTemplate, string, error) {
+	prompt, _ := args["prompt"].(string)
+	templateID, _ := args["template_id"].(string)
+	templateID = strings.TrimSpace(templateID)
+	if templateID == "" {
+		if strings.TrimSpace(prompt) == "" {
+			return "", nil, "", fmt.Errorf("prompt must be a non-empty string and is required for text-to-video, unless template_id is given")
+		}
+		return prompt, nil, "", nil
+	}
+	if strings.TrimSpace(prompt) != "" {
+		return "", nil, "", fmt.Errorf("give either prompt or template_id, not both")
+	}
+	if store == nil {
+		return "", nil, "", fmt.Errorf("template_id needs a template library; set VEO_TEMPLATE_GCS_URI")
+	}
+	library, err := store.get(ctx)
+	if err != nil {
+		return "", nil, "", err
+	}
+	template, ok := library.find(templateID)
+	if !ok {
+		return "", nil, "", fmt.Errorf("unknown template_id '%s'; use veo_list_prompt_templates to see the available templates", templateID)
+	}
+
+	variables := map[string]string{}
+	if raw, ok := args["template_variables"]; ok && raw != nil {
+		rawMap, ok := raw.(map[string]interface{})
+		if !ok {
+			return "", nil, "", fmt.Errorf("template_variables must be an object of variable names to values")
+		}
+		for name, value := range rawMap {
+			if value != nil {
+				variables[name] = fmt.Sprint(value)
+			}
+		}
+	}
+	rendered, err := template.render(variables)
+	if err != nil {
+		return "", nil, "", err
+	}
+	return rendered, template, library.Version, nil
+}
+
+// veoListPromptTemplatesHandler is the handler for the 'veo_list_prompt_templates' tool.
+func veoListPromptTemplatesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if promptTemplates == nil {
+		return mcp.NewToolResultError("No prompt template library is configured; set VEO_TEMPLATE_GCS_URI to the library's gs:// URI."), nil
+	}
+	library, err := promptTemplates.get(ctx)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	body, err := json.MarshalIndent(library, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("encoding templates: %v", err)), nil
+	}
+	return mcp.NewToolResultText(string(body)), nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLibrary = `{
+  "version": "2025-06-01",
+  "templates": [
+    {"id": "product-hero", "version": "3", "name": "Product hero shot", "prompt": "A slow dolly shot of {{product}} on {{ surface }}, lit like {{product}} in a commercial."},
+    {"id": "drone-reveal", "version": "1", "prompt": "A drone rises over the treeline to reveal a lake at dawn."}
+  ]
+}`
+
+// fakeTemplateBucket stands in for the GCS object: it serves content under an ETag and counts
+// full reads and revalidations.
+type fakeTemplateBucket struct {
+	content string
+	etag    string
+	err     error
+
+	reads, revalidations int
+}
+
+func (b *fakeTemplateBucket) fetch(ctx context.Context, gcsURI, etag string) ([]byte, string, bool, error) {
+	if b.err != nil {
+		return nil, "", false, b.err
+	}
+	if etag != "" && etag == b.etag {
+		b.revalidations++
+		return nil, b.etag, false, nil
+	}
+	b.reads++
+	return []byte(b.content), b.etag, true, nil
+}
+
+// newTestTemplateStore returns a store reading from the fake bucket, with a clock the test advances.
+func newTestTemplateStore(bucket *fakeTemplateBucket) (*templateStore, *time.Time) {
+	clock := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := newTemplateStore("gs://studio/templates/veo.json")
+	store.fetch = bucket.fetch
+	store.now = func() time.Time { return clock }
+	return store, &clock
+}
+
+func TestTemplateStoreETagCaching(t *testing.T) {
+	bucket := &fakeTemplateBucket{content: testLibrary, etag: "etag-1"}
+	store, clock := newTestTemplateStore(bucket)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.get(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bucket.reads != 1 || bucket.revalidations != 0 {
+		t.Errorf("expected one read within the interval, got %d reads and %d revalidations", bucket.reads, bucket.revalidations)
+	}
+
+	*clock = clock.Add(templateRevalidateInterval)
+	if _, err := store.get(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.reads != 1 || bucket.revalidations != 1 {
+		t.Errorf("expected an unchanged ETag to be revalidated without a read, got %d reads and %d revalidations", bucket.reads, bucket.revalidations)
+	}
+
+	bucket.content = strings.Replace(testLibrary, "2025-06-01", "2025-07-01", 1)
+	bucket.etag = "etag-2"
+	*clock = clock.Add(templateRevalidateInterval)
+	library, err := store.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket.reads != 2 || library.Version != "2025-07-01" {
+		t.Errorf("expected the changed library to be reloaded, got %d reads and version %s", bucket.reads, library.Version)
+	}
+}
+
+func TestTemplateStoreKeepsLastKnownGood(t *testing.T) {
+	bucket := &fakeTemplateBucket{content: testLibrary, etag: "etag-1"}
+	store, clock := newTestTemplateStore(bucket)
+	ctx := context.Background()
+	if _, err := store.get(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func(){
+		"malformed JSON": func() { bucket.content, bucket.etag = `{"templates": [`, "etag-bad" },
+		"duplicate id": func() {
+			bucket.content, bucket.etag = `{"templates": [{"id": "a", "prompt": "x"}, {"id": "a", "prompt": "y"}]}`, "etag-dup"
+		},
+		"unreadable file": func() { bucket.err = errors.New("permission denied") },
+	} {
+		change()
+		*clock = clock.Add(templateRevalidateInterval)
+		library, err := store.get(ctx)
+		if err != nil || library.Version != "2025-06-01" {
+			t.Errorf("%s: expected the last known good library, got %v, %v", name, library, err)
+		}
+		bucket.err = nil
+	}
+}
+
+func TestTemplateStoreWithoutGoodLibrary(t *testing.T) {
+	bucket := &fakeTemplateBucket{content: "not json", etag: "etag-bad"}
+	store, clock := newTestTemplateStore(bucket)
+	if _, err := store.get(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("expected an invalid library error, got %v", err)
+	}
+
+	bucket.content, bucket.etag = testLibrary, "etag-1"
+	*clock = clock.Add(templateRevalidateInterval)
+	if library, err := store.get(context.Background()); err != nil || len(library.Templates) != 2 {
+		t.Errorf("expected the fixed library to load, got %v, %v", library, err)
+	}
+}
+
+func TestTemplatePromptSubstitution(t *testing.T) {
+	store, _ := newTestTemplateStore(&fakeTemplateBucket{content: testLibrary, etag: "etag-1"})
+
+	prompt, template, libraryVersion, err := templatePrompt(context.Background(), store, map[string]interface{}{
+		"template_id":        "product-hero",
+		"template_variables": map[string]interface{}{"product": "a red sneaker", "surface": "wet asphalt", "unused": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "A slow dolly shot of a red sneaker on wet asphalt, lit like a red sneaker in a commercial."
+	if prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+	if template.ID != "product-hero" || template.Version != "3" || libraryVersion != "2025-06-01" {
+		t.Errorf("unexpected template %+v from library version %s", template, libraryVersion)
+	}
+
+	prompt, _, _, err = templatePrompt(context.Background(), store, map[string]interface{}{"template_id": "drone-reveal"})
+	if err != nil || !strings.HasPrefix(prompt, "A drone rises") {
+		t.Errorf("expected a template without variables to render as is, got %q, %v", prompt, err)
+	}
+}
+
+func TestTemplatePromptErrors(t *testing.T) {
+	store, _ := newTestTemplateStore(&fakeTemplateBucket{content: testLibrary, etag: "etag-1"})
+
+	tests := []struct {
+		name    string
+		store   *templateStore
+		args    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:    "missing variable",
+			store:   store,
+			args:    map[string]interface{}{"template_id": "product-hero", "template_variables": map[string]interface{}{"product": "a red sneaker"}},
+			wantErr: "template 'product-hero' is missing variables: surface (required: product, surface)",
+		},
+		{
+			name:    "unknown template",
+			store:   store,
+			args:    map[string]interface{}{"template_id": "sunset"},
+			wantErr: "unknown template_id 'sunset'",
+		},
+		{
+			name:    "prompt and template",
+			store:   store,
+			args:    map[string]interface{}{"template_id": "drone-reveal", "prompt": "a cat"},
+			wantErr: "either prompt or template_id",
+		},
+		{
+			name:    "no library configured",
+			args:    map[string]interface{}{"template_id": "drone-reveal"},
+			wantErr: "VEO_TEMPLATE_GCS_URI",
+		},
+		{
+			name:    "no prompt",
+			store:   store,
+			args:    map[string]interface{}{},
+			wantErr: "prompt must be a non-empty string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := templatePrompt(context.Background(), tt.store, tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTextToVideoRecordsTemplate(t *testing.T) {
+	stubVeoDependencies(t)
+	client, _, payload := newStubVeoClient(t)
+	store, _ := newTestTemplateStore(&fakeTemplateBucket{content: testLibrary, etag: "etag-1"})
+	origTemplates := promptTemplates
+	promptTemplates = store
+	t.Cleanup(func() { promptTemplates = origTemplates })
+
+	result, err := veoTextToVideoHandler(client, context.Background(), i2vRequest(map[string]interface{}{
+		"template_id":        "product-hero",
+		"template_variables": map[string]interface{}{"product": "a red sneaker", "surface": "wet asphalt"},
+		"model":              "Veo 2",
+	}))
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %s", err, resultText(result))
+	}
+
+	instances, _ := payload["instances"].([]interface{})
+	if len(instances) != 1 || !strings.Contains(instances[0].(map[string]interface{})["prompt"].(string), "a red sneaker on wet asphalt") {
+		t.Errorf("expected the rendered prompt to be sent, got %v", payload)
+	}
+	if text := resultText(result); !strings.Contains(text, "Template: product-hero (version 3, library version 2025-06-01)") {
+		t.Errorf("expected the result to record the template, got %q", text)
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+const (
+	transitionCut       = "cut"
+	transitionCrossfade = "crossfade"
+
+	minStoryboardScenes = 2
+	maxStoryboardScenes = 10
+	// maxConcurrentScenes is how many scenes are generated at once.
+	maxConcurrentScenes = 3
+	// defaultTransitionSeconds is the length of a crossfade when 'transition_duration' is not set.
+	defaultTransitionSeconds = 0.5
+)
+
+// assemblyRequest is what an Assembler needs to join the scene clips of a storyboard.
+type assemblyRequest struct {
+	// ClipURIs are the gs:// URIs of the scene clips, in order.
+	ClipURIs []string
+	// ClipDurations are the clips' lengths in seconds, which place the crossfades.
+	ClipDurations []float64
+	// Transition is transitionCut or transitionCrossfade.
+	Transition        string
+	TransitionSeconds float64
+	// WithAudio is set when the clips have an audio track to join as well.
+	WithAudio bool
+	// OutputBucket and OutputFileName name the GCS object the video is written to.
+	OutputBucket   string
+	OutputFileName string
+}
+
+// Assembler joins the scene clips of a storyboard into one video in GCS.
+type Assembler interface {
+	// Name identifies the assembly backend in results.
+	Name() string
+	// Supports reports whether the backend can join clips with the transition.
+	Supports(transition string) bool
+	// Assemble joins the clips and returns the gs:// URI of the video.
+	Assemble(ctx context.Context, req assemblyRequest) (string, error)
+}
+
+// storyboardAssembler is the assembler veo_storyboard uses; nil when neither AVTOOL_ENDPOINT is set
+// nor ffmpeg is installed.
+var storyboardAssembler Assembler
+
+// newStoryboardAssembler picks the avtool server at avtoolEndpoint when one is set, and otherwise
+// the local ffmpeg binary, from FFMPEG_PATH, when it can be found.
+func newStoryboardAssembler(avtoolEndpoint string) Assembler {
+	if avtoolEndpoint != "" {
+		return avtoolAssembler{endpoint: avtoolEndpoint}
+	}
+	if path, err := exec.LookPath(ffmpegPath()); err == nil {
+		return ffmpegAssembler{binary: path}
+	}
+	return nil
+}
+
+// avtoolAssembler joins the clips with the ffmpeg_concatenate_media_files tool of an avtool MCP
+// server reached over streamable HTTP. That tool has no transitions, so only cuts are supported.
+type avtoolAssembler struct {
+	endpoint string
+}
+
+func (a avtoolAssembler) Name() string { return "avtool" }
+
+func (a avtoolAssembler) Supports(transition string) bool { return transition == transitionCut }
+
+func (a avtoolAssembler) Assemble(ctx context.Context, req assemblyRequest) (string, error) {
+	c, err := client.NewStreamableHttpClient(a.endpoint)
+	if err != nil {
+		return "", fmt.Errorf("creating avtool client for %s: %w", a.endpoint, err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		return "", fmt.Errorf("connecting to avtool at %s: %w", a.endpoint, err)
+	}
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{Name: serviceName, Version: version}
+	if _, err := c.Initialize(ctx, initRequest); err != nil {
+		return "", fmt.Errorf("initializing avtool session at %s: %w", a.endpoint, err)
+	}
+
+	callRequest := mcp.CallToolRequest{}
+	callRequest.Params.Name = "ffmpeg_concatenate_media_files"
+	callRequest.Params.Arguments = map[string]interface{}{
+		"input_media_uris":  req.ClipURIs,
+		"output_file_name":  req.OutputFileName,
+		"output_gcs_bucket": req.OutputBucket,
+	}
+	result, err := c.CallTool(ctx, callRequest)
+	if err != nil {
+		return "", fmt.Errorf("calling avtool ffmpeg_concatenate_media_files: %w", err)
+	}
+	text := callToolText(result)
+	if result.IsError {
+		return "", fmt.Errorf("avtool ffmpeg_concatenate_media_files failed: %s", text)
+	}
+	videoURI := fmt.Sprintf("gs://%s/%s", req.OutputBucket, req.OutputFileName)
+	if !strings.Contains(text, videoURI) {
+		return "", fmt.Errorf("avtool did not report an upload to %s: %s", videoURI, text)
+	}
+	return videoURI, nil
+}
+
+// callToolText joins the text content of a tool result.
+func callToolText(result *mcp.CallToolResult) string {
+	var parts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ffmpegAssembler downloads the clips, joins them with a local ffmpeg and uploads the video.
+type ffmpegAssembler struct {
+	binary string
+}
+
+func (a ffmpegAssembler) Name() string { return "ffmpeg" }
+
+func (a ffmpegAssembler) Supports(transition string) bool {
+	return transition == transitionCut || transition == transitionCrossfade
+}
+
+func (a ffmpegAssembler) Assemble(ctx context.Context, req assemblyRequest) (string, error) {
+	tempDir, err := os.MkdirTemp("", "veo-storyboard-")
+	if err != nil {
+		return "", fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	localClips := make([]string, len(req.ClipURIs))
+	for i, uri := range req.ClipURIs {
+		localClips[i] = filepath.Join(tempDir, fmt.Sprintf("scene-%02d.mp4", i+1))
+		if err := common.DownloadFromGCS(ctx, uri, localClips[i]); err != nil {
+			return "", fmt.Errorf("downloading scene %d (%s): %w", i+1, uri, err)
+		}
+	}
+	outputPath := filepath.Join(tempDir, "storyboard.mp4")
+	args := buildStoryboardArgs(localClips, req, outputPath)
+	log.Printf("Assembling storyboard: %s %s", a.binary, strings.Join(args, " "))
+	if output, err := exec.CommandContext(ctx, a.binary, args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %w: %s", err, common.GetTail(string(output), 20))
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("reading assembled video: %w", err)
+	}
+	if err := common.UploadToGCS(ctx, req.OutputBucket, req.OutputFileName, "video/mp4", data); err != nil {
+		return "", fmt.Errorf("uploading assembled video: %w", err)
+	}
+	return fmt.Sprintf("gs://%s/%s", req.OutputBucket, req.OutputFileName), nil
+}
+
+// buildStoryboardArgs returns the ffmpeg arguments that join the local clips into outputPath:
+// the concat filter for cuts, or a chain of xfade (and acrossfade) filters for crossfades, each
+// starting one transition before the end of the video so far.
+func buildStoryboardArgs(localClips []string, req assemblyRequest, outputPath string) []string {
+	args := []string{"-y"}
+	for _, clip := range localClips {
+		args = append(args, "-i", clip)
+	}
+
+	var filters []string
+	if req.Transition == transitionCrossfade {
+		video, audio := "[0:v]", "[0:a]"
+		offset := 0.0
+		for i := 1; i < len(localClips); i++ {
+			offset += req.ClipDurations[i-1] - req.TransitionSeconds
+			filters = append(filters, fmt.Sprintf("%s[%d:v]xfade=transition=fade:duration=%g:offset=%g[v%d]", video, i, req.TransitionSeconds, offset, i))
+			video = fmt.Sprintf("[v%d]", i)
+			if req.WithAudio {
+				filters = append(filters, fmt.Sprintf("%s[%d:a]acrossfade=d=%g[a%d]", audio, i, req.TransitionSeconds, i))
+				audio = fmt.Sprintf("[a%d]", i)
+			}
+		}
+		args = append(args, "-filter_complex", strings.Join(filters, ";"), "-map", video)
+		if req.WithAudio {
+			args = append(args, "-map", audio)
+		}
+	} else {
+		var inputs strings.Builder
+		for i := range localClips {
+			inputs.WriteString(fmt.Sprintf("[%d:v]", i))
+			if req.WithAudio {
+				inputs.WriteString(fmt.Sprintf("[%d:a]", i))
+			}
+		}
+		audioStreams, outputs := 0, "[v]"
+		if req.WithAudio {
+			audioStreams, outputs = 1, "[v][a]"
+		}
+		args = append(args, "-filter_complex", fmt.Sprintf("%sconcat=n=%d:v=1:a=%d%s", inputs.String(), len(localClips), audioStreams, outputs), "-map", "[v]")
+		if req.WithAudio {
+			args = append(args, "-map", "[a]")
+		}
+	}
+
+	args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p")
+	if req.WithAudio {
+		args = append(args, "-c:a", "aac")
+	}
+	return append(args, outputPath)
+}
+
+// storyboardScene is one scene of a storyboard and what became of it.
+type storyboardScene struct {
+	Index           int    `json:"index"`
+	Prompt          string `json:"prompt"`
+	DurationSeconds int32  `json:"duration_seconds"`
+	GCSURI          string `json:"gcs_uri,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// storyboardResult is the structured result of veo_storyboard.
+type storyboardResult struct {
+	Scenes          []storyboardScene `json:"scenes"`
+	VideoURI        string            `json:"video_uri,omitempty"`
+	AssemblyBackend string            `json:"assembly_backend"`
+	AssemblyError   string            `json:"assembly_error,omitempty"`
+}
+
+// parseStoryboardScenes reads the 'scenes' argument; a scene without a duration gets defaultDuration,
+// and explicit durations are checked against the model's range.
+func parseStoryboardScenes(args map[string]interface{}, model string, defaultDuration int32) ([]storyboardScene, error) {
+	rawScenes, _ := args["scenes"].([]interface{})
+	if len(rawScenes) < minStoryboardScenes || len(rawScenes) > maxStoryboardScenes {
+		return nil, fmt.Errorf("scenes must be an array of %d to %d scenes, got %d", minStoryboardScenes, maxStoryboardScenes, len(rawScenes))
+	}
+	modelDetails := common.SupportedVeoModels[model]
+	scenes := make([]storyboardScene, len(rawScenes))
+	for i, raw := range rawScenes {
+		scene, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("scenes[%d] must be an object with a 'prompt'", i)
+		}
+		prompt, _ := scene["prompt"].(string)
+		if strings.TrimSpace(prompt) == "" {
+			return nil, fmt.Errorf("scenes[%d].prompt must be a non-empty string", i)
+		}
+		duration := defaultDuration
+		if d, ok := scene["duration"].(float64); ok {
+			duration = int32(d)
+			if duration < modelDetails.MinDuration || duration > modelDetails.MaxDuration {
+				return nil, fmt.Errorf("scenes[%d].duration must be between %d and %d seconds for model %s, got %g", i, modelDetails.MinDuration, modelDetails.MaxDuration, model, d)
+			}
+		}
+		scenes[i] = storyboardScene{Index: i, Prompt: strings.TrimSpace(prompt), DurationSeconds: duration}
+	}
+	return scenes, nil
+}
+
+// parseStoryboardTransition reads 'transition' and 'transition_duration'. A crossfade must be
+// shorter than every scene.
+func parseStoryboardTransition(args map[string]interface{}, scenes []storyboardScene) (string, float64, error) {
+	transition, _ := args["transition"].(string)
+	transition = strings.ToLower(strings.TrimSpace(transition))
+	if transition == "" {
+		transition = transitionCut
+	}
+	if transition != transitionCut && transition != transitionCrossfade {
+		return "", 0, fmt.Errorf("transition must be '%s' or '%s', got '%s'", transitionCut, transitionCrossfade, transition)
+	}
+	if transition == transitionCut {
+		return transition, 0, nil
+	}
+	seconds := defaultTransitionSeconds
+	if d, ok := args["transition_duration"].(float64); ok {
+		seconds = d
+	}
+	for _, scene := range scenes {
+		if seconds <= 0 || seconds >= float64(scene.DurationSeconds) {
+			return "", 0, fmt.Errorf("transition_duration must be more than 0 and less than the shortest scene (%ds), got %g", scene.DurationSeconds, seconds)
+		}
+	}
+	return transition, seconds, nil
+}
+
+// veoStoryboardHandler is the handler for the 'veo_storyboard' tool. It generates a clip for each
+// scene, a few at a time, and joins the clips with storyboardAssembler. A failed assembly is
+// reported alongside the scene clips rather than as an error, so the clips are never lost.
+func veoStoryboardHandler(client *genai.Client, ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	tr := otel.Tracer(serviceName)
+	ctx, span := tr.Start(ctx, "veo_storyboard")
+	defer span.End()
+
+	args := request.GetArguments()
+	gcsBucket, _, model, aspectRatio, _, defaultDuration, err := parseCommonVideoParams(args, appConfig)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if gcsBucket == "" {
+		return mcp.NewToolResultError("a GCS bucket is required for the scene clips; set 'bucket' or GENMEDIA_BUCKET"), nil
+	}
+	generateAudio, err := parseGenerateAudioParam(args, model)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	scenes, err := parseStoryboardScenes(args, model, defaultDuration)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	transition, transitionSeconds, err := parseStoryboardTransition(args, scenes)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	assembler := storyboardAssembler
+	if assembler == nil {
+		return mcp.NewToolResultError("no assembly backend is available; set AVTOOL_ENDPOINT to an avtool MCP server or install ffmpeg"), nil
+	}
+	if !assembler.Supports(transition) {
+		return mcp.NewToolResultError(fmt.Sprintf("the %s assembly backend does not support the '%s' transition", assembler.Name(), transition)), nil
+	}
+	outputName, _ := args["output_video_name"].(string)
+	outputName = sanitizeVideoFileName(outputName)
+	if outputName == "" {
+		outputName = fmt.Sprintf("storyboard-%s", time.Now().Format("20060102-150405"))
+	}
+	outputFileName := outputName + ".mp4"
+
+	span.SetAttributes(
+		attribute.Int("scenes", len(scenes)),
+		attribute.String("gcs_bucket", gcsBucket),
+		attribute.String("model", model),
+		attribute.String("aspect_ratio", aspectRatio),
+		attribute.String("transition", transition),
+		attribute.String("assembly_backend", assembler.Name()),
+	)
+	log.Printf("Handling Veo storyboard request: %d scenes, Model=%s, Transition=%s, Assembler=%s", len(scenes), model, transition, assembler.Name())
+
+	mcpServer := server.ServerFromContext(ctx)
+	var progressToken mcp.ProgressToken
+	if request.Params.Meta != nil {
+		progressToken = request.Params.Meta.ProgressToken
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentScenes)
+	for i := range scenes {
+		wg.Add(1)
+		go func(scene *storyboardScene) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			duration := scene.DurationSeconds
+			config := &genai.GenerateVideosConfig{
+				NumberOfVideos:  1,
+				AspectRatio:     aspectRatio,
+				OutputGCSURI:    gcsBucket,
+				DurationSeconds: &duration,
+				GenerateAudio:   generateAudio,
+			}
+			callType := fmt.Sprintf("scene %d", scene.Index+1)
+			operation, _, failure := runVideosOperation(client, ctx, mcpServer, progressToken, model, scene.Prompt, nil, config, callType)
+			switch {
+			case failure != nil:
+				scene.Error = callToolText(failure)
+			case operation.Response == nil || len(operation.Response.GeneratedVideos) == 0 || operation.Response.GeneratedVideos[0].Video == nil || operation.Response.GeneratedVideos[0].Video.URI == "":
+				scene.Error = fmt.Sprintf("video generation (%s) completed without a video", callType)
+			default:
+				scene.GCSURI = operation.Response.GeneratedVideos[0].Video.URI
+			}
+		}(&scenes[i])
+	}
+	wg.Wait()
+
+	result := storyboardResult{Scenes: scenes, AssemblyBackend: assembler.Name()}
+	var clipURIs []string
+	var clipDurations []float64
+	var failed []string
+	for _, scene := range scenes {
+		if scene.Error != "" {
+			failed = append(failed, fmt.Sprintf("scene %d: %s", scene.Index+1, scene.Error))
+			continue
+		}
+		clipURIs = append(clipURIs, scene.GCSURI)
+		clipDurations = append(clipDurations, float64(scene.DurationSeconds))
+	}
+	if len(failed) > 0 {
+		result.AssemblyError = "not assembled, as some scenes failed"
+		return storyboardToolResult(fmt.Sprintf("%d of %d scenes failed, so the storyboard was not assembled. %s. Generated clips: %s.", len(failed), len(scenes), strings.Join(failed, "; "), joinOrNone(clipURIs)), result, true)
+	}
+
+	videoURI, err := assembler.Assemble(ctx, assemblyRequest{
+		ClipURIs:          clipURIs,
+		ClipDurations:     clipDurations,
+		Transition:        transition,
+		TransitionSeconds: transitionSeconds,
+		WithAudio:         generateAudio != nil && *generateAudio,
+		OutputBucket:      strings.SplitN(strings.TrimPrefix(gcsBucket, "gs://"), "/", 2)[0],
+		OutputFileName:    outputFileName,
+	})
+	if err != nil {
+		log.Printf("Storyboard assembly with %s failed: %v", assembler.Name(), err)
+		result.AssemblyError = err.Error()
+		return storyboardToolResult(fmt.Sprintf("Generated %d scene clips, but assembling them with %s failed: %v. Scene clips: %s.", len(scenes), assembler.Name(), err, strings.Join(clipURIs, ", ")), result, false)
+	}
+	result.VideoURI = videoURI
+	return storyboardToolResult(fmt.Sprintf("Generated %d scene clips and assembled them with %s (%s transitions) into %s. Scene clips: %s.", len(scenes), assembler.Name(), transition, videoURI, strings.Join(clipURIs, ", ")), result, false)
+}
+
+// storyboardToolResult returns the summary and the structured result as two text contents.
+func storyboardToolResult(summary string, result storyboardResult, isError bool) (*mcp.CallToolResult, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("encoding storyboard result: %w", err)
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(summary), mcp.NewTextContent(string(data))},
+		IsError: isError,
+	}, nil
+}
+
+func joinOrNone(uris []string) string {
+	if len(uris) == 0 {
+		return "none"
+	}
+	return strings.Join(uris, ", ")
+}
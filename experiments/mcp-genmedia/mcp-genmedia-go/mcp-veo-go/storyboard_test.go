@@ -0,0 +1,330 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/genai"
+)
+
+// newStubSceneClient returns a Vertex client whose predictLongRunning calls finish straight away
+// with a video named after the prompt; a prompt containing "blocked" fails instead.
+func newStubSceneClient(t *testing.T) *genai.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Instances []struct {
+				Prompt string `json:"prompt"`
+			} `json:"instances"`
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &body); err != nil || len(body.Instances) != 1 {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		prompt := body.Instances[0].Prompt
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(prompt, "blocked") {
+			io.WriteString(w, `{"name": "operations/op-1", "done": true, "error": {"code": 3, "message": "prompt blocked"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"name": "operations/op-1", "done": true, "response": {"videos": [{"gcsUri": "gs://out-bucket/veo_outputs/%s.mp4", "mimeType": "video/mp4"}]}}`, strings.ReplaceAll(prompt, " ", "-"))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		Backend:     genai.BackendVertexAI,
+		Project:     "test-project",
+		Location:    "us-central1",
+		HTTPClient:  &http.Client{},
+		HTTPOptions: genai.HTTPOptions{BaseURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("creating stub client: %v", err)
+	}
+	return client
+}
+
+// fakeAssembler records the assembly request and returns err, or a video URI.
+type fakeAssembler struct {
+	mu          sync.Mutex
+	transitions []string
+	requests    []assemblyRequest
+	err         error
+}
+
+func (f *fakeAssembler) Name() string { return "fake" }
+
+func (f *fakeAssembler) Supports(transition string) bool {
+	for _, t := range f.transitions {
+		if t == transition {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeAssembler) Assemble(ctx context.Context, req assemblyRequest) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return "", f.err
+	}
+	return fmt.Sprintf("gs://%s/%s", req.OutputBucket, req.OutputFileName), nil
+}
+
+func stubStoryboard(t *testing.T, assembler Assembler) {
+	t.Helper()
+	origConfig, origAssembler := appConfig, storyboardAssembler
+	t.Cleanup(func() { appConfig, storyboardAssembler = origConfig, origAssembler })
+	appConfig = &common.Config{GenmediaBucket: "out-bucket"}
+	storyboardAssembler = assembler
+}
+
+func storyboardRequest(args map[string]interface{}) mcp.CallToolRequest {
+	var request mcp.CallToolRequest
+	request.Params.Arguments = args
+	return request
+}
+
+func scenesArg(prompts ...string) []interface{} {
+	scenes := make([]interface{}, len(prompts))
+	for i, prompt := range prompts {
+		scenes[i] = map[string]interface{}{"prompt": prompt}
+	}
+	return scenes
+}
+
+func decodeStoryboardResult(t *testing.T, result *mcp.CallToolResult) storyboardResult {
+	t.Helper()
+	var decoded storyboardResult
+	if err := json.Unmarshal([]byte(result.Content[1].(mcp.TextContent).Text), &decoded); err != nil {
+		t.Fatalf("result is not storyboard JSON: %v", err)
+	}
+	return decoded
+}
+
+func TestBuildStoryboardArgs(t *testing.T) {
+	clips := []string{"a.mp4", "b.mp4", "c.mp4"}
+	tests := []struct {
+		name string
+		req  assemblyRequest
+		want string
+	}{
+		{
+			name: "cut",
+			req:  assemblyRequest{Transition: transitionCut},
+			want: "-y -i a.mp4 -i b.mp4 -i c.mp4 -filter_complex [0:v][1:v][2:v]concat=n=3:v=1:a=0[v] -map [v] -c:v libx264 -pix_fmt yuv420p out.mp4",
+		},
+		{
+			name: "cut with audio",
+			req:  assemblyRequest{Transition: transitionCut, WithAudio: true},
+			want: "-y -i a.mp4 -i b.mp4 -i c.mp4 -filter_complex [0:v][0:a][1:v][1:a][2:v][2:a]concat=n=3:v=1:a=1[v][a] -map [v] -map [a] -c:v libx264 -pix_fmt yuv420p -c:a aac out.mp4",
+		},
+		{
+			name: "crossfade",
+			req:  assemblyRequest{Transition: transitionCrossfade, TransitionSeconds: 0.5, ClipDurations: []float64{8, 5, 6}},
+			want: "-y -i a.mp4 -i b.mp4 -i c.mp4 -filter_complex [0:v][1:v]xfade=transition=fade:duration=0.5:offset=7.5[v1];[v1][2:v]xfade=transition=fade:duration=0.5:offset=12[v2] -map [v2] -c:v libx264 -pix_fmt yuv420p out.mp4",
+		},
+		{
+			name: "crossfade with audio",
+			req:  assemblyRequest{Transition: transitionCrossfade, TransitionSeconds: 1, ClipDurations: []float64{5, 5, 5}, WithAudio: true},
+			want: "-y -i a.mp4 -i b.mp4 -i c.mp4 -filter_complex [0:v][1:v]xfade=transition=fade:duration=1:offset=4[v1];[0:a][1:a]acrossfade=d=1[a1];[v1][2:v]xfade=transition=fade:duration=1:offset=8[v2];[a1][2:a]acrossfade=d=1[a2] -map [v2] -map [a2] -c:v libx264 -pix_fmt yuv420p -c:a aac out.mp4",
+		},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(buildStoryboardArgs(clips, tt.req, "out.mp4"), " "); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStoryboardAssemblesScenesInOrder(t *testing.T) {
+	assembler := &fakeAssembler{transitions: []string{transitionCut, transitionCrossfade}}
+	stubStoryboard(t, assembler)
+
+	scenes := scenesArg("sunrise over hills", "city wakes up", "night falls")
+	scenes[1].(map[string]interface{})["duration"] = float64(8)
+	result, err := veoStoryboardHandler(newStubSceneClient(t), context.Background(), storyboardRequest(map[string]interface{}{
+		"scenes":            scenes,
+		"transition":        "crossfade",
+		"output_video_name": "day-in-the-life.mp4",
+	}))
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error: %v %s", err, resultText(result))
+	}
+
+	wantClips := []string{
+		"gs://out-bucket/veo_outputs/sunrise-over-hills.mp4",
+		"gs://out-bucket/veo_outputs/city-wakes-up.mp4",
+		"gs://out-bucket/veo_outputs/night-falls.mp4",
+	}
+	if len(assembler.requests) != 1 {
+		t.Fatalf("expected one assembly, got %d", len(assembler.requests))
+	}
+	req := assembler.requests[0]
+	if fmt.Sprint(req.ClipURIs) != fmt.Sprint(wantClips) || fmt.Sprint(req.ClipDurations) != "[5 8 5]" {
+		t.Errorf("unexpected clips %v with durations %v", req.ClipURIs, req.ClipDurations)
+	}
+	if req.Transition != transitionCrossfade || req.TransitionSeconds != defaultTransitionSeconds || req.OutputBucket != "out-bucket" || req.OutputFileName != "day-in-the-life.mp4" {
+		t.Errorf("unexpected assembly request %+v", req)
+	}
+
+	decoded := decodeStoryboardResult(t, result)
+	if decoded.VideoURI != "gs://out-bucket/day-in-the-life.mp4" || decoded.AssemblyBackend != "fake" || decoded.AssemblyError != "" {
+		t.Errorf("unexpected result %+v", decoded)
+	}
+	for i, scene := range decoded.Scenes {
+		if scene.Index != i || scene.GCSURI != wantClips[i] {
+			t.Errorf("unexpected scene %d: %+v", i, scene)
+		}
+	}
+}
+
+func TestStoryboardAssemblyFailureKeepsClips(t *testing.T) {
+	stubStoryboard(t, &fakeAssembler{transitions: []string{transitionCut}, err: errors.New("avtool unreachable")})
+
+	result, err := veoStoryboardHandler(newStubSceneClient(t), context.Background(), storyboardRequest(map[string]interface{}{
+		"scenes": scenesArg("first shot", "second shot"),
+	}))
+	if err != nil || result.IsError {
+		t.Fatalf("expected the clips to be returned without an error, got %v %s", err, resultText(result))
+	}
+	decoded := decodeStoryboardResult(t, result)
+	if decoded.VideoURI != "" || decoded.AssemblyError != "avtool unreachable" || decoded.AssemblyBackend != "fake" {
+		t.Errorf("unexpected result %+v", decoded)
+	}
+	if decoded.Scenes[0].GCSURI != "gs://out-bucket/veo_outputs/first-shot.mp4" || decoded.Scenes[1].GCSURI != "gs://out-bucket/veo_outputs/second-shot.mp4" {
+		t.Errorf("expected both scene clips, got %+v", decoded.Scenes)
+	}
+	if text := resultText(result); !strings.Contains(text, "assembling them with fake failed") {
+		t.Errorf("expected the assembly failure in the summary, got %q", text)
+	}
+}
+
+func TestStoryboardSceneFailureSkipsAssembly(t *testing.T) {
+	assembler := &fakeAssembler{transitions: []string{transitionCut}}
+	stubStoryboard(t, assembler)
+
+	result, err := veoStoryboardHandler(newStubSceneClient(t), context.Background(), storyboardRequest(map[string]interface{}{
+		"scenes": scenesArg("first shot", "blocked shot", "last shot"),
+	}))
+	if err != nil || !result.IsError {
+		t.Fatalf("expected an error result, got %v %s", err, resultText(result))
+	}
+	if len(assembler.requests) != 0 {
+		t.Errorf("expected no assembly, got %+v", assembler.requests)
+	}
+	decoded := decodeStoryboardResult(t, result)
+	if decoded.Scenes[1].Error == "" || decoded.Scenes[0].GCSURI == "" || decoded.Scenes[2].GCSURI == "" {
+		t.Errorf("expected only scene 2 to fail, got %+v", decoded.Scenes)
+	}
+	if text := resultText(result); !strings.Contains(text, "1 of 3 scenes failed") || !strings.Contains(text, "prompt blocked") {
+		t.Errorf("unexpected summary %q", text)
+	}
+}
+
+func TestStoryboardValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		assembler Assembler
+		args      map[string]interface{}
+		want      string
+	}{
+		{"one scene", &fakeAssembler{transitions: []string{transitionCut}}, map[string]interface{}{"scenes": scenesArg("only")}, "scenes must be an array of 2 to 10 scenes"},
+		{"blank prompt", &fakeAssembler{transitions: []string{transitionCut}}, map[string]interface{}{"scenes": scenesArg("a", " ")}, "scenes[1].prompt must be a non-empty string"},
+		{"duration out of range", &fakeAssembler{transitions: []string{transitionCut}}, map[string]interface{}{"scenes": []interface{}{map[string]interface{}{"prompt": "a", "duration": float64(30)}, map[string]interface{}{"prompt": "b"}}}, "scenes[0].duration must be between"},
+		{"unknown transition", &fakeAssembler{transitions: []string{transitionCut}}, map[string]interface{}{"scenes": scenesArg("a", "b"), "transition": "wipe"}, "transition must be 'cut' or 'crossfade'"},
+		{"crossfade too long", &fakeAssembler{transitions: []string{transitionCrossfade}}, map[string]interface{}{"scenes": scenesArg("a", "b"), "transition": "crossfade", "transition_duration": float64(5)}, "less than the shortest scene"},
+		{"unsupported transition", &fakeAssembler{transitions: []string{transitionCut}}, map[string]interface{}{"scenes": scenesArg("a", "b"), "transition": "crossfade"}, "fake assembly backend does not support the 'crossfade' transition"},
+		{"no assembler", nil, map[string]interface{}{"scenes": scenesArg("a", "b")}, "set AVTOOL_ENDPOINT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubStoryboard(t, tt.assembler)
+			result, err := veoStoryboardHandler(nil, context.Background(), storyboardRequest(tt.args))
+			if err != nil || !result.IsError {
+				t.Fatalf("expected an error result, got %v %+v", err, result)
+			}
+			if text := resultText(result); !strings.Contains(text, tt.want) {
+				t.Errorf("expected %q in %q", tt.want, text)
+			}
+		})
+	}
+}
+
+func TestAvtoolAssemblerCallsConcatenate(t *testing.T) {
+	var received map[string]interface{}
+	avtool := server.NewMCPServer("avtool", "test")
+	avtool.AddTool(mcp.NewTool("ffmpeg_concatenate_media_files"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		received = request.GetArguments()
+		return mcp.NewToolResultText(fmt.Sprintf("Media concatenated. Output uploaded to GCS: gs://%s/%s.", received["output_gcs_bucket"], received["output_file_name"])), nil
+	})
+	srv := server.NewTestStreamableHTTPServer(avtool)
+	defer srv.Close()
+
+	videoURI, err := avtoolAssembler{endpoint: srv.URL + "/mcp"}.Assemble(context.Background(), assemblyRequest{
+		ClipURIs:       []string{"gs://b/one.mp4", "gs://b/two.mp4"},
+		Transition:     transitionCut,
+		OutputBucket:   "b",
+		OutputFileName: "story.mp4",
+	})
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	if videoURI != "gs://b/story.mp4" {
+		t.Errorf("unexpected video URI %q", videoURI)
+	}
+	if fmt.Sprint(received["input_media_uris"]) != "[gs://b/one.mp4 gs://b/two.mp4]" || received["output_gcs_bucket"] != "b" {
+		t.Errorf("unexpected concatenate arguments %v", received)
+	}
+}
+
+func TestNewStoryboardAssemblerUsesFFmpegPath(t *testing.T) {
+	original := appConfig
+	t.Cleanup(func() { appConfig = original })
+
+	binary := filepath.Join(t.TempDir(), "ffmpeg-static")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	appConfig = &common.Config{FFmpegPath: binary}
+	if assembler, ok := newStoryboardAssembler("").(ffmpegAssembler); !ok || assembler.binary != binary {
+		t.Errorf("expected the ffmpeg at FFMPEG_PATH, got %#v", newStoryboardAssembler(""))
+	}
+	if _, ok := newStoryboardAssembler("http://localhost:8080/mcp").(avtoolAssembler); !ok {
+		t.Error("expected AVTOOL_ENDPOINT to take precedence")
+	}
+	appConfig = &common.Config{FFmpegPath: filepath.Join(t.TempDir(), "missing")}
+	if assembler := newStoryboardAssembler(""); assembler != nil {
+		t.Errorf("expected no assembler without ffmpeg, got %#v", assembler)
+	}
+}
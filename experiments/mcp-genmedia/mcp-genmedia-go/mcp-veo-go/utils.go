@@ -15,10 +15,15 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	common "github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
 )
@@ -106,4 +111,93 @@ func parseCommonVideoParams(args map[string]interface{}, appConfig *common.Confi
 	}
 
 	return gcsBucket, outputDir, model, finalAspectRatio, numberOfVideos, durationSecs, nil
-}
\ No newline at end of file
+}
+
+// parseGenerateAudioParam reads the optional 'generate_audio' flag and validates it
+// against the audio capability of the resolved model. A nil result means the
+// parameter was not provided and the API default should be used.
+func parseGenerateAudioParam(args map[string]interface{}, model string) (*bool, error) {
+	generateAudio, ok := args["generate_audio"].(bool)
+	if !ok {
+		return nil, nil
+	}
+	if !common.SupportedVeoModels[model].SupportsAudio {
+		if generateAudio {
+			return nil, fmt.Errorf("model %s does not support audio generation; models that support 'generate_audio': %s",
+				model, strings.Join(common.VeoModelsSupportingAudio(), ", "))
+		}
+		// Silent output is the only option for this model, so leave the field unset.
+		return nil, nil
+	}
+	return &generateAudio, nil
+}
+
+// parseLastFrameParam reads the optional 'last_frame_image_uri' and validates it
+// against the last-frame capability of the resolved model. An empty result means
+// the parameter was not provided.
+func parseLastFrameParam(args map[string]interface{}, model string) (string, error) {
+	lastFrameURI, _ := args["last_frame_image_uri"].(string)
+	lastFrameURI = strings.TrimSpace(lastFrameURI)
+	if lastFrameURI == "" {
+		return "", nil
+	}
+	if !common.SupportedVeoModels[model].SupportsLastFrame {
+		return "", fmt.Errorf("model %s does not support last frame conditioning; models that support 'last_frame_image_uri': %s",
+			model, strings.Join(common.VeoModelsSupportingLastFrame(), ", "))
+	}
+	return lastFrameURI, nil
+}
+
+// parseOutputFileNameParam reads the optional 'output_file_name', which names the local copies
+// of the videos and so only applies with 'output_directory'.
+func parseOutputFileNameParam(args map[string]interface{}, outputDir string) (string, error) {
+	outputFileName, _ := args["output_file_name"].(string)
+	outputFileName = strings.TrimSpace(outputFileName)
+	if outputFileName == "" {
+		return "", nil
+	}
+	if outputDir == "" {
+		return "", fmt.Errorf("output_file_name requires output_directory; the videos are only saved locally when output_directory is set")
+	}
+	if sanitizeVideoFileName(outputFileName) == "" {
+		return "", fmt.Errorf("output_file_name '%s' has no usable characters; use letters, digits, '.', '_' or '-'", outputFileName)
+	}
+	return outputFileName, nil
+}
+
+// stageUpload uploads a local input image to GCS. It is a variable so tests can
+// substitute a stub.
+var stageUpload = common.UploadToGCS
+
+// stageImageInput returns a GCS URI that Veo can read for an input image. GCS URIs
+// are used as-is; local files are uploaded to the 'veo_inputs/' prefix of the
+// output bucket.
+func stageImageInput(ctx context.Context, imageURI, gcsBucket, mimeType string) (string, error) {
+	if strings.HasPrefix(imageURI, "gs://") {
+		return imageURI, nil
+	}
+	if gcsBucket == "" {
+		return "", fmt.Errorf("local image '%s' needs a GCS bucket for staging; set 'bucket' or GENMEDIA_BUCKET", imageURI)
+	}
+	bucketName := strings.SplitN(strings.TrimPrefix(gcsBucket, "gs://"), "/", 2)[0]
+
+	data, err := os.ReadFile(imageURI)
+	if err != nil {
+		return "", fmt.Errorf("reading local image '%s': %w", imageURI, err)
+	}
+	objectName := stagedImageObjectName(imageURI, data, time.Now())
+	if err := stageUpload(ctx, bucketName, objectName, mimeType, data); err != nil {
+		return "", fmt.Errorf("staging local image '%s' to gs://%s/%s: %w", imageURI, bucketName, objectName, err)
+	}
+	stagedURI := fmt.Sprintf("gs://%s/%s", bucketName, objectName)
+	log.Printf("Staged local image %s to %s", imageURI, stagedURI)
+	return stagedURI, nil
+}
+
+// stagedImageObjectName names the staging object for a local image. The content hash keeps
+// two different images with the same base name, staged in the same second, from overwriting
+// each other.
+func stagedImageObjectName(localPath string, data []byte, now time.Time) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("veo_inputs/%s-%s-%s", now.Format("20060102-150405"), hex.EncodeToString(sum[:8]), filepath.Base(localPath))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGenerateAudioParam(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      map[string]interface{}
+		model     string
+		expectNil bool
+		expected  bool
+		expectErr bool
+	}{
+		{"not provided", map[string]interface{}{}, "veo-3.0-generate-preview", true, false, false},
+		{"enabled on audio model", map[string]interface{}{"generate_audio": true}, "veo-3.0-generate-preview", false, true, false},
+		{"disabled on audio model", map[string]interface{}{"generate_audio": false}, "veo-3.0-fast-generate-preview", false, false, false},
+		{"enabled on silent model", map[string]interface{}{"generate_audio": true}, "veo-2.0-generate-001", true, false, true},
+		{"disabled on silent model", map[string]interface{}{"generate_audio": false}, "veo-2.0-generate-001", true, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parseGenerateAudioParam(tc.args, tc.model)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, but got none")
+				}
+				if !strings.Contains(err.Error(), "veo-3.0-generate-preview") {
+					t.Errorf("expected error to list supported models, but got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if tc.expectNil {
+				if actual != nil {
+					t.Errorf("expected nil, but got %t", *actual)
+				}
+				return
+			}
+			if actual == nil || *actual != tc.expected {
+				t.Errorf("expected %t, but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestStagedImageObjectName(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 30, 45, 0, time.UTC)
+	first := stagedImageObjectName("/a/start.png", []byte("first frame"), now)
+	second := stagedImageObjectName("/b/start.png", []byte("other frame"), now)
+
+	if !strings.HasPrefix(first, "veo_inputs/20250701-123045-") || !strings.HasSuffix(first, "-start.png") {
+		t.Errorf("unexpected object name: %s", first)
+	}
+	if first == second {
+		t.Errorf("expected different images staged in the same second to get different names, both got %s", first)
+	}
+	if again := stagedImageObjectName("/a/start.png", []byte("first frame"), now); again != first {
+		t.Errorf("expected the name to be stable for the same content, got %s and %s", first, again)
+	}
+}
@@ -40,7 +40,7 @@ var (
 
 const (
 	serviceName = "mcp-veo-go"
-	version     = "1.10.0" // Fix: Honor GENMEDIA_BUCKET env var
+	version     = "1.15.0" // Feat: callback_url for veo_t2v and veo_i2v
 )
 
 // init handles command-line flags and initial logging setup.
@@ -49,7 +49,6 @@ func init() {
 	flag.StringVar(&transport, "t", "stdio", "Transport type (stdio, sse, or http)")
 	flag.StringVar(&transport, "transport", "stdio", "Transport type (stdio, sse, or http)")
 	flag.BoolVar(&otel_enabled, "otel", true, "Enable OpenTelemetry")
-	flag.Parse()
 }
 
 // main is the entry point for the mcp-veo-go service.
@@ -57,6 +56,7 @@ func init() {
 // It then creates an MCP server, registers the 'veo_t2v' and 'veo_i2v' tools,
 // and starts listening for requests on the configured transport.
 func main() {
+	flag.Parse()
 	var err error
 	appConfig = common.LoadConfig()
 
@@ -92,8 +92,18 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating global GenAI client: %v", err)
 	}
+	// Wait out 429/503 responses that carry Retry-After, for unary and streaming calls alike.
+	common.HonorRetryAfter(genAIClient.ClientConfig().HTTPClient)
 	log.Printf("Global GenAI client initialized successfully.")
 
+	if uri := os.Getenv("VEO_TEMPLATE_GCS_URI"); uri != "" {
+		promptTemplates = newTemplateStore(uri)
+		// A library that can't be loaded yet must not stop the server; it is retried on use.
+		if _, err := promptTemplates.get(context.Background()); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
 	s := server.NewMCPServer(
 		"Veo", // Standardized name
 		version,
@@ -104,7 +114,10 @@ func main() {
 			mcp.Description("Google Cloud Storage bucket where the API will save the generated video(s) (e.g., your-bucket/output-folder or gs://your-bucket/output-folder). If not provided, GENMEDIA_BUCKET env var will be used. One of them is required."),
 		),
 		mcp.WithString("output_directory",
-			mcp.Description("Optional. If provided, specifies a local directory to download the generated video(s) to. Filenames will be generated automatically."),
+			mcp.Description("Optional. If provided, specifies a local directory to download the generated video(s) to, in addition to the GCS copies. Filenames are generated automatically unless output_file_name is set."),
+		),
+		mcp.WithString("output_file_name",
+			mcp.Description("Optional. Name of the downloaded video in output_directory (e.g., 'intro.mp4'); requires output_directory. The extension is always .mp4, and with several videos an index is appended (intro-0.mp4, intro-1.mp4)."),
 		),
 		mcp.WithString("model",
 			mcp.DefaultString("veo-2.0-generate-001"),
@@ -122,17 +135,30 @@ func main() {
 			mcp.DefaultNumber(5),
 			mcp.Description("Duration of the generated video in seconds. Note: the supported duration range is model-dependent."),
 		),
+		mcp.WithBoolean("generate_audio",
+			mcp.Description("Optional. Whether to generate a synchronized audio track. Only supported by models marked 'Audio: true' in the model list; requesting audio from other models returns an error."),
+		),
 	}
 
+	callbackParam := mcp.WithString("callback_url",
+		mcp.Description("Optional. An https URL to notify when the generation finishes. The tool then returns the operation name right away, and the outcome (operation name, status, output URIs or error) is POSTed to this URL as JSON, signed with an HMAC-SHA256 of the body in the X-Veo-Signature header. The host must be allowed by the server's CALLBACK_ALLOWED_HOSTS, and cannot be combined with output_directory."),
+	)
+
 	var textToVideoToolParams []mcp.ToolOption
 	textToVideoToolParams = append(textToVideoToolParams,
 		mcp.WithDescription("Generate a video from a text prompt using Veo. Video is saved to GCS and optionally downloaded locally."),
 		mcp.WithString("prompt",
-			mcp.Required(),
-			mcp.Description("Text prompt for video generation."),
+			mcp.Description("Text prompt for video generation. Required unless template_id is given."),
+		),
+		mcp.WithString("template_id",
+			mcp.Description("Optional. Id of a prompt template from the shared library (see veo_list_prompt_templates) to use instead of prompt."),
+		),
+		mcp.WithObject("template_variables",
+			mcp.Description("Optional. Values for the template's {{placeholders}}, e.g. {\"product\": \"a red sneaker\"}. Every variable listed for the template is required."),
 		),
 	)
 	textToVideoToolParams = append(textToVideoToolParams, commonVideoParams...)
+	textToVideoToolParams = append(textToVideoToolParams, callbackParam)
 
 	textToVideoTool := mcp.NewTool("veo_t2v",
 		textToVideoToolParams...,
@@ -141,12 +167,20 @@ func main() {
 		return veoTextToVideoHandler(genAIClient, ctx, request)
 	})
 
+	listPromptTemplatesTool := mcp.NewTool("veo_list_prompt_templates",
+		mcp.WithDescription("List the vetted Veo prompt templates shared with Creative Studio, with the variables each one needs. Use a template with veo_t2v's template_id and template_variables."),
+	)
+	s.AddTool(listPromptTemplatesTool, veoListPromptTemplatesHandler)
+
 	var imageToVideoToolParams []mcp.ToolOption
 	imageToVideoToolParams = append(imageToVideoToolParams,
 		mcp.WithDescription("Generate a video from an input image (and optional prompt) using Veo. Video is saved to GCS and optionally downloaded locally. Supported image MIME types: image/jpeg, image/png."),
 		mcp.WithString("image_uri",
 			mcp.Required(),
-			mcp.Description("GCS URI of the input image for video generation (e.g., gs://your-bucket/input-image.png)."),
+			mcp.Description("GCS URI or local path of the input image for video generation (e.g., gs://your-bucket/input-image.png). Local files are staged to the output bucket under 'veo_inputs/'."),
+		),
+		mcp.WithString("last_frame_image_uri",
+			mcp.Description("Optional. GCS URI or local path of an image to end the video on; Veo interpolates between image_uri and this frame. Must be a .png or .jpg image. Only supported by some models; others return an error listing the models that support it."),
 		),
 		mcp.WithString("mime_type",
 			mcp.Description("MIME type of the input image. Supported types are 'image/jpeg' and 'image/png'. If not provided, an attempt will be made to infer it from the image_uri extension."),
@@ -156,6 +190,7 @@ func main() {
 		),
 	)
 	imageToVideoToolParams = append(imageToVideoToolParams, commonVideoParams...)
+	imageToVideoToolParams = append(imageToVideoToolParams, callbackParam)
 
 	imageToVideoTool := mcp.NewTool("veo_i2v",
 		imageToVideoToolParams...,
@@ -164,6 +199,60 @@ func main() {
 		return veoImageToVideoHandler(genAIClient, ctx, request)
 	})
 
+	storyboardAssembler = newStoryboardAssembler(os.Getenv("AVTOOL_ENDPOINT"))
+	if storyboardAssembler == nil {
+		log.Printf("Warning: veo_storyboard cannot assemble videos; set AVTOOL_ENDPOINT or install ffmpeg")
+	} else {
+		log.Printf("veo_storyboard assembles videos with %s", storyboardAssembler.Name())
+	}
+	storyboardTool := mcp.NewTool("veo_storyboard",
+		mcp.WithDescription("Generate a multi-scene video: one Veo clip per scene prompt, joined in order into a single video. Clips are joined by the avtool server at AVTOOL_ENDPOINT, or by a local ffmpeg when it is unset. Returns every scene clip's GCS URI, the final video URI and the assembly backend used; if assembly fails, the scene clips are still returned."),
+		mcp.WithArray("scenes",
+			mcp.Required(),
+			mcp.Description(fmt.Sprintf("Ordered scenes, %d to %d, each {\"prompt\": ..., \"duration\": seconds}. 'duration' is optional and defaults to the tool's 'duration'.", minStoryboardScenes, maxStoryboardScenes)),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"prompt":   map[string]any{"type": "string", "description": "Text prompt for the scene."},
+					"duration": map[string]any{"type": "number", "description": "Length of the scene in seconds; the supported range is model-dependent."},
+				},
+				"required": []string{"prompt"},
+			}),
+		),
+		mcp.WithString("transition",
+			mcp.DefaultString(transitionCut),
+			mcp.Description("How scenes are joined: 'cut' or 'crossfade'. Crossfades need the local ffmpeg backend; the avtool backend only cuts."),
+		),
+		mcp.WithNumber("transition_duration",
+			mcp.DefaultNumber(defaultTransitionSeconds),
+			mcp.Description("Optional. Length of each crossfade in seconds; must be shorter than every scene. Each crossfade shortens the video by this much."),
+		),
+		mcp.WithString("output_video_name",
+			mcp.Description("Optional. Name of the assembled video, written to the root of the output bucket as <name>.mp4. Defaults to storyboard-<timestamp>.mp4."),
+		),
+		mcp.WithString("bucket",
+			mcp.Description("Google Cloud Storage bucket where the scene clips are saved (e.g., your-bucket/output-folder); the assembled video goes to the root of the same bucket. If not provided, GENMEDIA_BUCKET env var will be used. One of them is required."),
+		),
+		mcp.WithString("model",
+			mcp.DefaultString("veo-2.0-generate-001"),
+			mcp.Description(common.BuildVeoModelDescription()),
+		),
+		mcp.WithString("aspect_ratio",
+			mcp.DefaultString("16:9"),
+			mcp.Description("Aspect ratio of every scene. Note: supported aspect ratios are model-dependent."),
+		),
+		mcp.WithNumber("duration",
+			mcp.DefaultNumber(5),
+			mcp.Description("Default scene length in seconds, for scenes without their own 'duration'."),
+		),
+		mcp.WithBoolean("generate_audio",
+			mcp.Description("Optional. Generate an audio track for every scene and keep it in the assembled video. Only supported by models marked 'Audio: true' in the model list."),
+		),
+	)
+	s.AddTool(storyboardTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return veoStoryboardHandler(genAIClient, ctx, request)
+	})
+
 	s.AddPrompt(mcp.NewPrompt("generate-video",
 		mcp.WithPromptDescription("Generates a video from a text prompt."),
 		mcp.WithArgument("prompt", mcp.ArgumentDescription("The text prompt to generate a video from."), mcp.RequiredArgument()),
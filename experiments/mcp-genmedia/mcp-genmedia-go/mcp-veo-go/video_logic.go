@@ -20,11 +20,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/GoogleCloudPlatform/vertex-ai-creative-studio/experiments/mcp-genmedia/mcp-genmedia-go/mcp-common"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"google.golang.org/genai"
@@ -35,14 +33,15 @@ import (
 
 // callGenerateVideosAPI orchestrates the entire video generation process.
 // It initiates the video generation operation, polls for its completion, and handles
-// progress notifications. Once the video is generated, it can download the file
-// to a local directory if requested. It returns a summary of the operation's outcome.
+// progress notifications. Once the video is generated, it can download the files
+// to a local directory if requested, named after outputFileName when it is set. It returns a summary of the operation's outcome.
 func callGenerateVideosAPI(
 	client *genai.Client,
 	parentCtx context.Context, // Renamed from ctx to avoid conflict with operationCtx
 	mcpServer *server.MCPServer,
 	progressToken mcp.ProgressToken,
 	outputDir string,
+	outputFileName string,
 	modelName string,
 	prompt string,
 	image *genai.Image,
@@ -55,13 +54,6 @@ func callGenerateVideosAPI(
 
 	attemptLocalDownload := outputDir != ""
 
-	// Context for the entire GenerateVideos operation, including polling.
-	// We derive the operation context from the parent context to ensure that if the
-	// client disconnects or the parent request is canceled, we propagate the
-	// cancellation to the long-running GenAI operation.
-	operationCtx, operationCancel := context.WithTimeout(ctx, 5*time.Minute) // Timeout for the entire GenAI operation + polling
-	defer operationCancel()
-
 	logMsg := fmt.Sprintf("Initiating GenerateVideos (%s) with Model: %s", callType, modelName)
 	if image != nil && image.GCSURI != "" {
 		logMsg += fmt.Sprintf(", ImageGCSURI: %s, ImageMIMEType: %s", image.GCSURI, image.MIMEType)
@@ -72,25 +64,121 @@ func callGenerateVideosAPI(
 	if config.DurationSeconds != nil {
 		logMsg += fmt.Sprintf(", Duration: %ds", *config.DurationSeconds)
 	}
-	logMsg += fmt.Sprintf(", OutputGCS: %s. Operation timeout: %v", config.OutputGCSURI, 5*time.Minute)
+	logMsg += fmt.Sprintf(", OutputGCS: %s. Operation timeout: %v", config.OutputGCSURI, videoOperationTimeout)
 	if attemptLocalDownload {
 		logMsg += fmt.Sprintf(". Will attempt to download to local directory: '%s'", outputDir)
 	}
 	log.Print(logMsg)
 
-	startTime := time.Now()
+	operation, operationDuration, failure := runVideosOperation(client, ctx, mcpServer, progressToken, modelName, prompt, image, config, callType)
+	if failure != nil {
+		return failure, nil
+	}
 
-	// Use operationCtx for the initial call to GenerateVideos
-	operation, err := client.Models.GenerateVideos(operationCtx, modelName, prompt, image, config)
-	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) && operationCtx.Err() == context.DeadlineExceeded {
-			log.Printf("GenerateVideos (%s) failed: initial call timed out: %v", callType, err)
-			return mcp.NewToolResultError(fmt.Sprintf("video generation (%s) initiation timed out", callType)), nil
+	if operation.Response == nil || len(operation.Response.GeneratedVideos) == 0 {
+		log.Printf("No videos generated (%s) by operation %s, despite successful completion.", callType, operation.Name)
+		return mcp.NewToolResultText(fmt.Sprintf("Sorry, I couldn't generate any videos (%s) for your request (operation completed but no videos found).", callType)), nil
+	}
+
+	log.Printf("Successfully generated %d videos (%s) by operation %s.", len(operation.Response.GeneratedVideos), callType, operation.Name)
+
+	var gcsVideoURIs []string
+	var audioChecks []string
+
+	for i, generatedVideo := range operation.Response.GeneratedVideos {
+		if generatedVideo.Video == nil || generatedVideo.Video.URI == "" {
+			log.Printf("Generated video %d (%s) (model: %s, operation: %s) had no retrievable GCS URI.", i, callType, modelName, operation.Name)
+			continue
 		}
-		log.Printf("Error initiating GenerateVideos (%s): %v", callType, err)
-		return mcp.NewToolResultError(fmt.Sprintf("error starting video generation (%s): %v", callType, err)), nil
+		gcsVideoURIs = append(gcsVideoURIs, generatedVideo.Video.URI)
+		log.Printf("Video %d (%s) generated by operation %s is available at GCS URI: %s", i, callType, operation.Name, generatedVideo.Video.URI)
+	}
+
+	var downloads []videoDownload
+	if attemptLocalDownload {
+		downloads = downloadVideos(ctx, gcsVideoURIs, outputDir, outputFileName, modelName)
+	}
+	if shouldCheckAudio(config, modelName) {
+		for i, videoGCSURI := range gcsVideoURIs {
+			// Verify against the local copy when we have one, otherwise read the GCS object.
+			localFilepath := ""
+			if downloads != nil {
+				localFilepath = downloads[i].LocalPath
+			}
+			audioChecks = append(audioChecks, describeAudioCheck(ctx, i, localFilepath, videoGCSURI))
+		}
+	}
+
+	var resultText string
+	var saveMessageParts []string
+
+	if len(gcsVideoURIs) > 0 {
+		saveMessageParts = append(saveMessageParts, fmt.Sprintf("Videos saved to GCS: %s.", strings.Join(gcsVideoURIs, ", ")))
+	}
+	saveMessageParts = append(saveMessageParts, describeDownloads(outputDir, downloads)...)
+
+	if len(audioChecks) > 0 {
+		saveMessageParts = append(saveMessageParts, fmt.Sprintf("Audio track check (generate_audio: true): %s.", strings.Join(audioChecks, ", ")))
+	}
+
+	if len(gcsVideoURIs) > 0 {
+		resultText = fmt.Sprintf("Generated %d video(s) using model %s. This took about %s. %s",
+			len(gcsVideoURIs),
+			modelName,
+			operationDuration.Round(time.Second),
+			strings.Join(saveMessageParts, " "),
+		)
+	} else if operation.Error == nil {
+		resultText = fmt.Sprintf("Processed request (%s) for model %s (took %s), but no video URIs were found in the completed operation %s. No specific error reported by the operation.",
+			callType,
+			modelName,
+			operationDuration.Round(time.Second),
+			operation.Name,
+		)
+	} else {
+		// This case should ideally be caught by the operation.Error check earlier.
+		// If we reach here, it implies operation.Error was non-nil but didn't lead to an early return.
+		resultText = fmt.Sprintf("Video generation request (%s) for model %s (took %s) did not yield videos and encountered an issue with operation %s.",
+			callType,
+			modelName,
+			operationDuration.Round(time.Second),
+			operation.Name,
+		)
+	}
+
+	return mcp.NewToolResultText(strings.TrimSpace(resultText)), nil
+}
+
+// videoOperationTimeout bounds a GenerateVideos operation, including polling.
+const videoOperationTimeout = 5 * time.Minute
+
+// runVideosOperation starts a GenerateVideos operation and polls it until it is done, sending
+// progress notifications along the way. It returns the completed operation and how long it took,
+// or, when the operation could not be started, timed out or failed, the error result to return.
+func runVideosOperation(
+	client *genai.Client,
+	ctx context.Context,
+	mcpServer *server.MCPServer,
+	progressToken mcp.ProgressToken,
+	modelName string,
+	prompt string,
+	image *genai.Image,
+	config *genai.GenerateVideosConfig,
+	callType string,
+) (*genai.GenerateVideosOperation, time.Duration, *mcp.CallToolResult) {
+	// Context for the entire GenerateVideos operation, including polling.
+	// We derive the operation context from the parent context to ensure that if the
+	// client disconnects or the parent request is canceled, we propagate the
+	// cancellation to the long-running GenAI operation.
+	operationCtx, operationCancel := context.WithTimeout(ctx, videoOperationTimeout) // Timeout for the entire GenAI operation + polling
+	defer operationCancel()
+
+	startTime := time.Now()
+
+	operation, failure := startVideosOperation(client, operationCtx, modelName, prompt, image, config, callType)
+	if failure != nil {
+		return nil, 0, failure
 	}
-	log.Printf("GenerateVideos operation (%s) initiated successfully. Operation Name: %s", callType, operation.Name)
 
 	if progressToken != nil && mcpServer != nil {
 		mcpServer.SendNotificationToClient(
@@ -104,6 +192,47 @@ func callGenerateVideosAPI(
 		)
 	}
 
+	return pollVideosOperation(client, ctx, operationCtx, mcpServer, progressToken, operation, callType, startTime)
+}
+
+// startVideosOperation makes the initial GenerateVideos call, which returns the operation to
+// poll, or the error result to return when it could not be started.
+func startVideosOperation(
+	client *genai.Client,
+	operationCtx context.Context,
+	modelName string,
+	prompt string,
+	image *genai.Image,
+	config *genai.GenerateVideosConfig,
+	callType string,
+) (*genai.GenerateVideosOperation, *mcp.CallToolResult) {
+	operation, err := client.Models.GenerateVideos(operationCtx, modelName, prompt, image, config)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && operationCtx.Err() == context.DeadlineExceeded {
+			log.Printf("GenerateVideos (%s) failed: initial call timed out: %v", callType, err)
+			return nil, mcp.NewToolResultError(fmt.Sprintf("video generation (%s) initiation timed out", callType))
+		}
+		log.Printf("Error initiating GenerateVideos (%s): %v", callType, err)
+		return nil, mcp.NewToolResultError(fmt.Sprintf("error starting video generation (%s): %v", callType, err))
+	}
+	log.Printf("GenerateVideos operation (%s) initiated successfully. Operation Name: %s", callType, operation.Name)
+	return operation, nil
+}
+
+// pollVideosOperation polls a started GenerateVideos operation until it is done, within
+// operationCtx, sending progress notifications when there is a progress token. ctx is the
+// request's context; canceling it stops the polling. It returns the completed operation and
+// how long it took since startTime, or the error result when it timed out or failed.
+func pollVideosOperation(
+	client *genai.Client,
+	ctx context.Context,
+	operationCtx context.Context,
+	mcpServer *server.MCPServer,
+	progressToken mcp.ProgressToken,
+	operation *genai.GenerateVideosOperation,
+	callType string,
+	startTime time.Time,
+) (*genai.GenerateVideosOperation, time.Duration, *mcp.CallToolResult) {
 	pollingStartTime := time.Now()
 	pollingInterval := 15 * time.Second
 	pollingAttempt := 0
@@ -111,12 +240,12 @@ func callGenerateVideosAPI(
 	for !operation.Done {
 		select {
 		case <-ctx.Done(): // Check if the original MCP request was canceled
+			// operationCtx is derived from ctx, so the GenAI operation calls are canceled too.
 			log.Printf("Parent context for GenerateVideos (%s) polling canceled: %v. Stopping polling and GenAI operation.", callType, ctx.Err())
-			operationCancel() // Attempt to cancel the GenAI operation
-			return mcp.NewToolResultError(fmt.Sprintf("video generation (%s) was canceled by the client: %v", callType, ctx.Err())), nil
+			return nil, 0, mcp.NewToolResultError(fmt.Sprintf("video generation (%s) was canceled by the client: %v", callType, ctx.Err()))
 		case <-operationCtx.Done(): // Check if the GenAI operation itself timed out or was canceled
 			log.Printf("Polling loop for GenerateVideos (%s) canceled/timed out by operationCtx: %v", callType, operationCtx.Err())
-			return mcp.NewToolResultError(fmt.Sprintf("video generation (%s) timed out while waiting for completion", callType)), nil
+			return nil, 0, mcp.NewToolResultError(fmt.Sprintf("video generation (%s) timed out while waiting for completion", callType))
 		case <-time.After(pollingInterval): // Time to poll
 			pollingAttempt++
 			log.Printf("Polling GenerateVideos operation (%s): %s (Attempt: %d, Elapsed: %v)", callType, operation.Name, pollingAttempt, time.Since(pollingStartTime).Round(time.Second))
@@ -142,7 +271,7 @@ func callGenerateVideosAPI(
 				log.Printf("Error polling GenerateVideos operation (%s) %s: %v", callType, operation.Name, getErr)
 				// If operationCtx is done, it means the GenAI operation itself was canceled or timed out.
 				if errors.Is(getErr, context.Canceled) || errors.Is(getErr, context.DeadlineExceeded) {
-					return mcp.NewToolResultError(fmt.Sprintf("video generation (%s) polling was canceled or timed out during GetOperation", callType)), nil
+					return nil, 0, mcp.NewToolResultError(fmt.Sprintf("video generation (%s) polling was canceled or timed out during GetOperation", callType))
 				}
 				// For other errors, notify and continue (could be transient)
 				if progressToken != nil && mcpServer != nil {
@@ -240,100 +369,7 @@ func callGenerateVideosAPI(
 			}
 		}
 		log.Printf("GenerateVideos operation (%s) %s failed with error: %s (Code: %d, FullError: %v)", callType, operation.Name, errMessage, errCode, operation.Error)
-		return mcp.NewToolResultError(fmt.Sprintf("video generation (%s) failed: %s (code: %d)", callType, errMessage, errCode)), nil
+		return nil, 0, mcp.NewToolResultError(fmt.Sprintf("video generation (%s) failed: %s (code: %d)", callType, errMessage, errCode))
 	}
-
-	if operation.Response == nil || len(operation.Response.GeneratedVideos) == 0 {
-		log.Printf("No videos generated (%s) by operation %s, despite successful completion.", callType, operation.Name)
-		return mcp.NewToolResultText(fmt.Sprintf("Sorry, I couldn't generate any videos (%s) for your request (operation completed but no videos found).", callType)), nil
-	}
-
-	log.Printf("Successfully generated %d videos (%s) by operation %s.", len(operation.Response.GeneratedVideos), callType, operation.Name)
-
-	var gcsVideoURIs []string
-	var downloadedLocalFiles []string
-	var downloadErrors []string
-
-	for i, generatedVideo := range operation.Response.GeneratedVideos {
-		videoGCSURI := ""
-		if generatedVideo.Video != nil && generatedVideo.Video.URI != "" {
-			videoGCSURI = generatedVideo.Video.URI
-		}
-
-		if videoGCSURI == "" {
-			log.Printf("Generated video %d (%s) (model: %s, operation: %s) had no retrievable GCS URI.", i, callType, modelName, operation.Name)
-			continue
-		}
-		gcsVideoURIs = append(gcsVideoURIs, videoGCSURI)
-		log.Printf("Video %d (%s) generated by operation %s is available at GCS URI: %s", i, callType, operation.Name, videoGCSURI)
-
-		if attemptLocalDownload {
-			// Construct a descriptive filename similar to Imagen
-			localFilename := fmt.Sprintf("veo-%s-%s-%d.mp4", modelName, time.Now().Format("20060102-150405"), i)
-			localFilepath := filepath.Join(outputDir, localFilename)
-			localFilepath = filepath.Clean(localFilepath)
-
-			log.Printf("Attempting to download video %d from GCS URI %s to %s", i, videoGCSURI, localFilepath)
-			downloadErr := common.DownloadFromGCS(ctx, videoGCSURI, localFilepath)
-			if downloadErr != nil {
-				errMsg := fmt.Sprintf("Error downloading video %d from %s to %s: %v", i, videoGCSURI, localFilepath, downloadErr)
-				log.Print(errMsg)
-				downloadErrors = append(downloadErrors, errMsg)
-			} else {
-				log.Printf("Successfully downloaded and saved video %d to %s", i, localFilepath)
-				downloadedLocalFiles = append(downloadedLocalFiles, localFilepath)
-			}
-		}
-	}
-
-	var resultText string
-	var saveMessageParts []string
-
-	if len(gcsVideoURIs) > 0 {
-		saveMessageParts = append(saveMessageParts, fmt.Sprintf("Videos saved to GCS: %s.", strings.Join(gcsVideoURIs, ", ")))
-	}
-
-	if attemptLocalDownload {
-		if len(downloadedLocalFiles) > 0 { // Only mention outputDir if downloads were attempted and successful
-			saveMessageParts = append(saveMessageParts, fmt.Sprintf("Successfully downloaded locally to '%s': %s.", outputDir, strings.Join(downloadedLocalFiles, ", ")))
-		} else if outputDir != "" { // If outputDir was specified but no files downloaded (all errors or no videos)
-			saveMessageParts = append(saveMessageParts, fmt.Sprintf("Attempted to download videos to local directory '%s'.", outputDir))
-		}
-		if len(downloadErrors) > 0 {
-			saveMessageParts = append(saveMessageParts, fmt.Sprintf("Local download/save issues: %s.", strings.Join(downloadErrors, "; ")))
-		}
-	}
-
-	if len(gcsVideoURIs) > 0 {
-		resultText = fmt.Sprintf("Generated %d video(s) using model %s. This took about %s. %s",
-			len(gcsVideoURIs),
-			modelName,
-			operationDuration.Round(time.Second),
-			strings.Join(saveMessageParts, " "),
-		)
-	} else if operation.Error == nil {
-		resultText = fmt.Sprintf("Processed request (%s) for model %s (took %s), but no video URIs were found in the completed operation %s. No specific error reported by the operation.",
-			callType,
-			modelName,
-			operationDuration.Round(time.Second),
-			operation.Name,
-		)
-		if len(downloadErrors) > 0 { // If there were download errors even with no GCS URIs (shouldn't happen but good to cover)
-			resultText += " " + strings.Join(saveMessageParts, " ")
-		}
-	} else {
-		// This case should ideally be caught by the operation.Error check earlier.
-		// If we reach here, it implies operation.Error was non-nil but didn't lead to an early return.
-		resultText = fmt.Sprintf("Video generation request (%s) for model %s (took %s) did not yield videos and encountered an issue with operation %s.",
-			callType,
-			modelName,
-			operationDuration.Round(time.Second),
-			operation.Name,
-		)
-		if len(downloadErrors) > 0 {
-			resultText += " " + strings.Join(saveMessageParts, " ")
-		}
-	}
-
-	return mcp.NewToolResultText(strings.TrimSpace(resultText)), nil
+	return operation, operationDuration, nil
 }